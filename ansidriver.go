@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// escapeTimeout is how long to wait for the rest of an escape sequence before treating ESC as the Escape key.
+const escapeTimeout = 25 * time.Millisecond
+
+// Terminal modes turned on by the ANSIDriver, they are turned off in reverse order on Close.
+var ansiModes = []struct{ on, off string }{
+	{"\x1b[?2004h", "\x1b[?2004l"}, // bracketed paste
+	{"\x1b[?1004h", "\x1b[?1004l"}, // focus events
+	{"\x1b[>1u", "\x1b[<u"},        // kitty keyboard protocol
+}
+
+// SGR mouse reporting of presses, releases and drags.
+const (
+	mouseOn  = "\x1b[?1000h\x1b[?1002h\x1b[?1006h"
+	mouseOff = "\x1b[?1006l\x1b[?1002l\x1b[?1000l"
+)
+
+const (
+	syncStart = "\x1b[?2026h"
+	syncEnd   = "\x1b[?2026l"
+)
+
+// ANSIDriver is a ConsoleDriver that talks to the terminal directly with escape sequences.
+// Capabilities come from terminfo when there is an entry for $TERM, otherwise xterm is assumed.
+type ANSIDriver struct {
+	in        *os.File
+	out       io.Writer
+	info      *Terminfo
+	parser    inputParser
+	trueColor bool
+
+	events chan Event
+	quit   chan interface{}
+	done   chan interface{}
+	wake   *os.File
+	state  *ttyState
+	active bool
+	mouse  bool
+
+	mutex    sync.Mutex
+	outMutex sync.Mutex
+	width    int
+	height   int
+	repaint  bool
+
+	frame    bytes.Buffer
+	cursorX  int
+	cursorY  int
+	current  ColorPair
+	colorSet bool
+	showX    int
+	showY    int
+}
+
+// NewANSIDriver constructs an ANSIDriver reading from in and drawing to out, usually both the tty.
+func NewANSIDriver(in *os.File, out io.Writer) ANSIDriver {
+	info, err := LoadTerminfo(os.Getenv("TERM"))
+	if err != nil {
+		info = XtermTerminfo()
+	}
+
+	colorTerm := os.Getenv("COLORTERM")
+	return ANSIDriver{
+		in:        in,
+		out:       out,
+		info:      info,
+		parser:    newInputParser(info),
+		trueColor: colorTerm == "truecolor" || colorTerm == "24bit",
+		events:    make(chan Event),
+		width:     80,
+		height:    24,
+	}
+}
+
+// Init puts the terminal in raw mode, switches to the alternate screen and starts reading input.
+func (driver *ANSIDriver) Init() {
+	if driver.active {
+		return
+	}
+	driver.active = true
+
+	driver.setup()
+	driver.updateSize()
+
+	driver.quit = make(chan interface{})
+	wakeRead, wakeWrite, err := os.Pipe()
+	if err == nil {
+		driver.wake = wakeWrite
+		driver.done = make(chan interface{})
+		go driver.readInput(wakeRead, driver.quit, driver.done)
+	}
+	go driver.handleSignals(driver.quit)
+}
+
+// Close stops reading input and puts the terminal back how it was.
+func (driver *ANSIDriver) Close() {
+	if !driver.active {
+		return
+	}
+	driver.active = false
+
+	close(driver.quit)
+	if driver.wake != nil {
+		driver.wake.Close()
+		driver.wake = nil
+		<-driver.done
+	}
+	driver.teardown()
+}
+
+// setup switches the terminal to raw mode and turns on the modes the driver uses.
+func (driver *ANSIDriver) setup() {
+	if state, err := makeRaw(driver.in.Fd()); err == nil {
+		driver.state = state
+	}
+
+	var out strings.Builder
+	out.WriteString(driver.info.Strings["smcup"])
+	out.WriteString(driver.info.Strings["smkx"])
+	for _, mode := range ansiModes {
+		out.WriteString(mode.on)
+	}
+	if driver.mouse {
+		out.WriteString(mouseOn)
+	}
+	out.WriteString(driver.info.Strings["clear"])
+	driver.write(out.String())
+
+	driver.mutex.Lock()
+	driver.repaint = true
+	driver.mutex.Unlock()
+}
+
+// teardown undoes setup.
+func (driver *ANSIDriver) teardown() {
+	var out strings.Builder
+	out.WriteString(driver.info.Strings["sgr0"])
+	if driver.mouse {
+		out.WriteString(mouseOff)
+	}
+	for i := len(ansiModes) - 1; i >= 0; i-- {
+		out.WriteString(ansiModes[i].off)
+	}
+	out.WriteString(driver.info.Strings["cnorm"])
+	out.WriteString(driver.info.Strings["rmkx"])
+	out.WriteString(driver.info.Strings["rmcup"])
+	driver.write(out.String())
+
+	if driver.state != nil {
+		restoreTTY(driver.in.Fd(), driver.state)
+		driver.state = nil
+	}
+}
+
+// write sends text straight to the terminal.
+func (driver *ANSIDriver) write(text string) {
+	driver.outMutex.Lock()
+	defer driver.outMutex.Unlock()
+	io.WriteString(driver.out, text)
+}
+
+// Size returns the size of the terminal.
+func (driver *ANSIDriver) Size() (width int, height int) {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.width, driver.height
+}
+
+func (driver *ANSIDriver) updateSize() (width, height int) {
+	width, height, err := ttySize(driver.in.Fd())
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	if err == nil && width > 0 && height > 0 {
+		driver.width, driver.height = width, height
+	}
+	return driver.width, driver.height
+}
+
+// NeedsRepaint returns true once after the screen was cleared or resized.
+// The position of the cursor and the current colours are forgotten as they can't be trusted anymore.
+func (driver *ANSIDriver) NeedsRepaint() bool {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	repaint := driver.repaint
+	driver.repaint = false
+
+	if repaint {
+		driver.cursorX, driver.cursorY = -1, -1
+		driver.colorSet = false
+	}
+	return repaint
+}
+
+// SetMouse turns reporting of mouse events on or off.
+func (driver *ANSIDriver) SetMouse(enabled bool) {
+	if enabled == driver.mouse {
+		return
+	}
+	driver.mouse = enabled
+
+	if driver.active && enabled {
+		driver.write(mouseOn)
+	} else if driver.active {
+		driver.write(mouseOff)
+	}
+}
+
+// Events returns a channel of events.
+func (driver *ANSIDriver) Events() chan Event {
+	return driver.events
+}
+
+// SetCell draws a character, it is sent to the terminal by AfterDraw.
+func (driver *ANSIDriver) SetCell(x, y int, r rune, fg, bg Color) {
+	if x != driver.cursorX || y != driver.cursorY {
+		driver.frame.WriteString(driver.moveTo(x, y))
+	}
+
+	colors := ColorPair{fg, bg}
+	if !driver.colorSet || colors != driver.current {
+		driver.frame.WriteString(driver.sgr(fg, bg))
+		driver.current = colors
+		driver.colorSet = true
+	}
+
+	if r == 0 || r < 0x20 || !utf8.ValidRune(r) {
+		r = ' '
+	}
+	driver.frame.WriteRune(r)
+
+	driver.cursorX, driver.cursorY = x+1, y
+	if width, _ := driver.Size(); driver.cursorX >= width {
+		driver.cursorX, driver.cursorY = -1, -1
+	}
+}
+
+// SetCursor sets where the cursor is shown after drawing.
+func (driver *ANSIDriver) SetCursor(x, y int) {
+	driver.showX, driver.showY = x, y
+}
+
+// AfterDraw sends the frame to the terminal as one synchronized update.
+func (driver *ANSIDriver) AfterDraw() {
+	var out bytes.Buffer
+	out.WriteString(syncStart)
+	out.WriteString(driver.info.Strings["civis"])
+	out.Write(driver.frame.Bytes())
+	out.WriteString(driver.moveTo(driver.showX, driver.showY))
+	out.WriteString(driver.info.Strings["cnorm"])
+	out.WriteString(syncEnd)
+
+	driver.write(out.String())
+	driver.frame.Reset()
+	driver.cursorX, driver.cursorY = driver.showX, driver.showY
+}
+
+// moveTo returns the sequence moving the cursor to the cell.
+func (driver *ANSIDriver) moveTo(x, y int) string {
+	if move, ok := Parameterize(driver.info.Strings["cup"], y, x); ok && move != "" {
+		return move
+	}
+	return fmt.Sprintf("\x1b[%d;%dH", y+1, x+1)
+}
+
+// sgr returns the sequence setting the colours and attributes of the following text.
+// Colours are termbox Attributes or RGB values.
+func (driver *ANSIDriver) sgr(fg, bg Color) string {
+	codes := []string{"0"}
+
+	if attr, ok := fg.(termbox.Attribute); ok {
+		for _, a := range []struct {
+			attr termbox.Attribute
+			code string
+		}{
+			{termbox.AttrBold, "1"},
+			{termbox.AttrDim, "2"},
+			{termbox.AttrCursive, "3"},
+			{termbox.AttrUnderline, "4"},
+			{termbox.AttrBlink, "5"},
+			{termbox.AttrReverse, "7"},
+			{termbox.AttrHidden, "8"},
+		} {
+			if attr&a.attr != 0 {
+				codes = append(codes, a.code)
+			}
+		}
+	}
+
+	if code := driver.colorCode(fg, false); code != "" {
+		codes = append(codes, code)
+	}
+	if code := driver.colorCode(bg, true); code != "" {
+		codes = append(codes, code)
+	}
+
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// colorCode returns the SGR parameters for a colour, empty for the default colour.
+func (driver *ANSIDriver) colorCode(color Color, background bool) string {
+	base := 30
+	if background {
+		base = 40
+	}
+
+	switch c := color.(type) {
+	case RGB:
+		if driver.trueColor {
+			return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c.R, c.G, c.B)
+		}
+		if driver.info.Colors >= 256 {
+			return fmt.Sprintf("%d;5;%d", base+8, c.Nearest256())
+		}
+		return fmt.Sprint(base + c.NearestBasic())
+	case termbox.Attribute:
+		index := int(c&0x1ff) - 1
+		switch {
+		case index < 0:
+			return ""
+		case index < 8:
+			return fmt.Sprint(base + index)
+		case index < 16:
+			return fmt.Sprint(base + 60 + index - 8)
+		default:
+			return fmt.Sprintf("%d;5;%d", base+8, index)
+		}
+	}
+	return ""
+}
+
+// readInput parses the input until the quit channel is closed or wake becomes readable, then closes done.
+func (driver *ANSIDriver) readInput(wake *os.File, quit, done chan interface{}) {
+	defer close(done)
+	defer wake.Close()
+
+	buffer := make([]byte, 4096)
+	pending := []byte{}
+
+	for {
+		timeout := time.Duration(-1)
+		if len(pending) > 0 {
+			timeout = escapeTimeout
+		}
+
+		ready, err := waitReadable(driver.in.Fd(), wake.Fd(), timeout)
+		if err != nil {
+			return
+		}
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		final := !ready
+		if ready {
+			n, err := driver.in.Read(buffer)
+			if err != nil {
+				return
+			}
+			pending = append(pending, buffer[:n]...)
+		}
+
+		events, used := driver.parser.parse(pending, final)
+		pending = append(pending[:0], pending[used:]...)
+
+		for _, event := range events {
+			select {
+			case driver.events <- event:
+			case <-quit:
+				return
+			}
+		}
+	}
+}
+
+// handleSignals reports terminal resizes until the quit channel is closed.
+func (driver *ANSIDriver) handleSignals(quit chan interface{}) {
+	if resizeSignal == nil {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, resizeSignal)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-signals:
+			event := Event{driver.resizeEvent()}
+			select {
+			case driver.events <- event:
+			case <-quit:
+				return
+			}
+		}
+	}
+}
+
+// resizeEvent updates the size of the terminal and returns a ResizeEvent for it.
+func (driver *ANSIDriver) resizeEvent() ResizeEvent {
+	width, height := driver.updateSize()
+	driver.mutex.Lock()
+	driver.repaint = true
+	driver.mutex.Unlock()
+	return ResizeEvent{Width: width, Height: height}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestANSIDriverOutput(t *testing.T) {
+	Convey("given an ANSIDriver drawing to a buffer", t, func() {
+		out := &bytes.Buffer{}
+		driver := NewANSIDriver(os.Stdin, out)
+		driver.info = XtermTerminfo()
+		driver.cursorX, driver.cursorY = -1, -1
+
+		Convey("a frame is sent as one synchronized update", func() {
+			driver.SetCell(2, 1, 'a', termbox.ColorWhite, termbox.ColorRed)
+			driver.SetCell(3, 1, 'b', termbox.ColorWhite, termbox.ColorRed)
+			driver.SetCursor(4, 1)
+			So(out.Len(), ShouldEqual, 0)
+
+			driver.AfterDraw()
+			So(out.String(), ShouldEqual, syncStart+"\x1b[?25l"+
+				"\x1b[2;3H\x1b[0;37;41mab"+
+				"\x1b[2;5H\x1b[?12l\x1b[?25h"+syncEnd)
+		})
+
+		Convey("the cursor is only moved for cells that aren't next to each other", func() {
+			driver.SetCell(0, 0, 'a', termbox.ColorDefault, termbox.ColorDefault)
+			driver.SetCell(5, 0, 'b', termbox.ColorDefault, termbox.ColorDefault)
+			So(driver.frame.String(), ShouldEqual, "\x1b[1;1H\x1b[0ma\x1b[1;6Hb")
+		})
+
+		Convey("attributes and bright colours", func() {
+			So(driver.sgr(termbox.ColorYellow|termbox.AttrBold, termbox.ColorLightBlue), ShouldEqual, "\x1b[0;1;33;104m")
+		})
+
+		Convey("true colour", func() {
+			driver.trueColor = true
+			So(driver.sgr(RGB{255, 128, 0}, termbox.ColorDefault), ShouldEqual, "\x1b[0;38;2;255;128;0m")
+
+			Convey("falls back to 256 or 8 colours", func() {
+				driver.trueColor = false
+				driver.info.Colors = 256
+				So(driver.sgr(RGB{255, 0, 0}, termbox.ColorDefault), ShouldEqual, "\x1b[0;38;5;196m")
+				driver.info.Colors = 8
+				So(driver.sgr(RGB{255, 0, 0}, termbox.ColorDefault), ShouldEqual, "\x1b[0;31m")
+			})
+		})
+
+		Convey("after a repaint is needed the cursor and colours are reset", func() {
+			driver.SetCell(0, 0, 'a', termbox.ColorDefault, termbox.ColorDefault)
+			driver.AfterDraw()
+			driver.repaint = true
+			So(driver.NeedsRepaint(), ShouldBeTrue)
+			So(driver.NeedsRepaint(), ShouldBeFalse)
+			driver.SetCell(0, 0, 'a', termbox.ColorDefault, termbox.ColorDefault)
+			So(driver.frame.String(), ShouldEqual, "\x1b[1;1H\x1b[0ma")
+		})
+	})
+}
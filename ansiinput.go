@@ -0,0 +1,321 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// inputParser turns the bytes read from a terminal into events.
+// Key sequences from terminfo are tried first, then the usual xterm, SGR mouse and kitty keyboard sequences.
+type inputParser struct {
+	keys map[string]Key
+}
+
+// terminfoKeys maps terminfo key capabilities to the Key they send.
+var terminfoKeys = map[string]Key{
+	"kbs":   KeyBackspace,
+	"kdch1": KeyDelete,
+	"kich1": KeyInsert,
+	"kcuu1": KeyUp,
+	"kcud1": KeyDown,
+	"kcub1": KeyLeft,
+	"kcuf1": KeyRight,
+	"khome": KeyHome,
+	"kend":  KeyEnd,
+	"kpp":   KeyPageUp,
+	"knp":   KeyPageDown,
+	"kf1":   KeyF1,
+	"kf2":   KeyF2,
+	"kf3":   KeyF3,
+	"kf4":   KeyF4,
+	"kf5":   KeyF5,
+	"kf6":   KeyF6,
+	"kf7":   KeyF7,
+	"kf8":   KeyF8,
+	"kf9":   KeyF9,
+	"kf10":  KeyF10,
+	"kf11":  KeyF11,
+	"kf12":  KeyF12,
+}
+
+// tildeKeys are the keys sent as CSI number ~.
+var tildeKeys = map[int]Key{
+	1: KeyHome, 2: KeyInsert, 3: KeyDelete, 4: KeyEnd, 5: KeyPageUp, 6: KeyPageDown, 7: KeyHome, 8: KeyEnd,
+	11: KeyF1, 12: KeyF2, 13: KeyF3, 14: KeyF4, 15: KeyF5, 17: KeyF6, 18: KeyF7, 19: KeyF8,
+	20: KeyF9, 21: KeyF10, 23: KeyF11, 24: KeyF12,
+}
+
+// letterKeys are the keys sent as CSI letter or SS3 letter.
+var letterKeys = map[byte]Key{
+	'A': KeyUp, 'B': KeyDown, 'C': KeyRight, 'D': KeyLeft, 'H': KeyHome, 'F': KeyEnd,
+	'P': KeyF1, 'Q': KeyF2, 'R': KeyF3, 'S': KeyF4,
+}
+
+func newInputParser(info *Terminfo) inputParser {
+	parser := inputParser{keys: map[string]Key{}}
+	for name, key := range terminfoKeys {
+		if sequence := info.Strings[name]; strings.HasPrefix(sequence, "\x1b") {
+			parser.keys[sequence] = key
+		}
+	}
+	return parser
+}
+
+// parse returns the events in data and how many bytes were used.
+// A sequence cut off at the end of data is left unused unless final is true,
+// in which case a lone escape is reported as the Escape key.
+func (parser *inputParser) parse(data []byte, final bool) ([]Event, int) {
+	events := []Event{}
+	pos := 0
+
+	for pos < len(data) {
+		event, n := parser.parseOne(data[pos:])
+		if n == 0 {
+			if !final {
+				break
+			}
+			event, n = parser.parseIncomplete(data[pos:])
+		}
+		if event != nil {
+			events = append(events, Event{event})
+		}
+		pos += n
+	}
+
+	return events, pos
+}
+
+// parseIncomplete handles a sequence that was cut off and will never be finished.
+func (parser *inputParser) parseIncomplete(data []byte) (interface{}, int) {
+	if data[0] == 0x1b {
+		return KeyEvent{Key: KeyEscape}, 1
+	}
+	return nil, 1
+}
+
+// parseOne parses the first event in data, n is 0 if more data is needed.
+func (parser *inputParser) parseOne(data []byte) (event interface{}, n int) {
+	if data[0] != 0x1b {
+		return parseKey(data)
+	}
+
+	if len(data) == 1 {
+		return nil, 0
+	}
+
+	if strings.HasPrefix(string(data), pasteStart) {
+		end := strings.Index(string(data), pasteEnd)
+		if end == -1 {
+			return nil, 0
+		}
+		return PasteEvent{Text: string(data[len(pasteStart):end])}, end + len(pasteEnd)
+	}
+
+	incomplete := false
+	for sequence, key := range parser.keys {
+		if strings.HasPrefix(string(data), sequence) {
+			return KeyEvent{Key: key}, len(sequence)
+		}
+		if strings.HasPrefix(sequence, string(data)) {
+			incomplete = true
+		}
+	}
+
+	switch data[1] {
+	case '[':
+		return parseCSI(data)
+	case 'O':
+		if len(data) < 3 {
+			return nil, 0
+		}
+		if key, ok := letterKeys[data[2]]; ok {
+			return KeyEvent{Key: key}, 3
+		}
+		return nil, 3
+	case 0x1b:
+		return KeyEvent{Key: KeyEscape, Mod: ModAlt}, 2
+	}
+
+	if incomplete {
+		return nil, 0
+	}
+
+	event, n = parseKey(data[1:])
+	if n == 0 {
+		return nil, 0
+	}
+	if key, ok := event.(KeyEvent); ok {
+		key.Mod |= ModAlt
+		event = key
+	}
+	return event, n + 1
+}
+
+// parseKey parses a single character or control character.
+func parseKey(data []byte) (interface{}, int) {
+	switch data[0] {
+	case 0x1b:
+		return KeyEvent{Key: KeyEscape}, 1
+	case '\r':
+		return KeyEvent{Key: KeyEnter}, 1
+	case '\t':
+		return KeyEvent{Key: KeyTab}, 1
+	case 0x7f, 0x08:
+		return KeyEvent{Key: KeyBackspace}, 1
+	}
+
+	if data[0] < 0x20 {
+		return controlKey(rune(data[0]), 0), 1
+	}
+
+	if !utf8.FullRune(data) {
+		return nil, 0
+	}
+	r, n := utf8.DecodeRune(data)
+	return KeyEvent{Rune: r}, n
+}
+
+// parseCSI parses an escape sequence starting with ESC [.
+func parseCSI(data []byte) (interface{}, int) {
+	end := 2
+	for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+		end++
+	}
+	if end >= len(data) {
+		return nil, 0
+	}
+
+	params := string(data[2:end])
+	final := data[end]
+	n := end + 1
+
+	if strings.HasPrefix(params, "<") && (final == 'M' || final == 'm') {
+		return parseSGRMouse(params[1:], final == 'm'), n
+	}
+
+	fields := strings.Split(params, ";")
+	number := func(i int) int {
+		if i >= len(fields) {
+			return 0
+		}
+		value, _ := strconv.Atoi(strings.SplitN(fields[i], ":", 2)[0])
+		return value
+	}
+	mod := modifiers(number(1))
+
+	switch final {
+	case 'I':
+		return FocusEvent{Focused: true}, n
+	case 'O':
+		return FocusEvent{Focused: false}, n
+	case 'Z':
+		return KeyEvent{Key: KeyTab, Mod: ModShift}, n
+	case '~':
+		if key, ok := tildeKeys[number(0)]; ok {
+			return KeyEvent{Key: key, Mod: mod}, n
+		}
+		return nil, n
+	case 'u':
+		return kittyKey(number(0), mod), n
+	}
+
+	if key, ok := letterKeys[final]; ok {
+		return KeyEvent{Key: key, Mod: mod}, n
+	}
+	return nil, n
+}
+
+// modifiers decodes the modifier parameter of xterm and kitty key sequences.
+func modifiers(value int) Modifier {
+	if value < 2 {
+		return 0
+	}
+	bits := value - 1
+
+	var mod Modifier
+	if bits&1 != 0 {
+		mod |= ModShift
+	}
+	if bits&2 != 0 {
+		mod |= ModAlt
+	}
+	if bits&4 != 0 {
+		mod |= ModCtrl
+	}
+	return mod
+}
+
+// kittyKey converts a key reported by the kitty keyboard protocol.
+func kittyKey(code int, mod Modifier) interface{} {
+	switch code {
+	case 27:
+		return KeyEvent{Key: KeyEscape, Mod: mod}
+	case 13:
+		return KeyEvent{Key: KeyEnter, Mod: mod}
+	case 9:
+		return KeyEvent{Key: KeyTab, Mod: mod}
+	case 127:
+		return KeyEvent{Key: KeyBackspace, Mod: mod}
+	}
+
+	if code < 0x20 || code >= 57344 {
+		return nil
+	}
+	return KeyEvent{Rune: rune(code), Mod: mod}
+}
+
+// parseSGRMouse parses the parameters of an SGR mouse report, "button;x;y".
+func parseSGRMouse(params string, release bool) interface{} {
+	fields := strings.Split(params, ";")
+	if len(fields) != 3 {
+		return nil
+	}
+
+	values := make([]int, 3)
+	for i, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		values[i] = value
+	}
+
+	code := values[0]
+	event := MouseEvent{X: values[1] - 1, Y: values[2] - 1}
+
+	if code&4 != 0 {
+		event.Mod |= ModShift
+	}
+	if code&8 != 0 {
+		event.Mod |= ModAlt
+	}
+	if code&16 != 0 {
+		event.Mod |= ModCtrl
+	}
+
+	switch {
+	case code&64 != 0 && code&1 == 0:
+		event.Button = MouseWheelUp
+	case code&64 != 0:
+		event.Button = MouseWheelDown
+	default:
+		event.Button = []MouseButton{MouseLeft, MouseMiddle, MouseRight, MouseNone}[code&3]
+	}
+
+	switch {
+	case release:
+		event.Action = MouseRelease
+	case code&32 != 0 && event.Button == MouseNone:
+		event.Action = MouseMove
+	case code&32 != 0:
+		event.Action = MouseDrag
+	}
+
+	return event
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInputParser(t *testing.T) {
+	Convey("given a parser with xterm key sequences", t, func() {
+		parser := newInputParser(XtermTerminfo())
+
+		parse := func(input string) []interface{} {
+			events, used := parser.parse([]byte(input), true)
+			So(used, ShouldEqual, len(input))
+			data := []interface{}{}
+			for _, event := range events {
+				data = append(data, event.Data)
+			}
+			return data
+		}
+
+		Convey("plain and unicode characters", func() {
+			So(parse("aé"), ShouldResemble, []interface{}{KeyEvent{Rune: 'a'}, KeyEvent{Rune: 'é'}})
+		})
+
+		Convey("control characters", func() {
+			So(parse("\r\t\x7f\x01\x1e"), ShouldResemble, []interface{}{
+				KeyEvent{Key: KeyEnter},
+				KeyEvent{Key: KeyTab},
+				KeyEvent{Key: KeyBackspace},
+				KeyEvent{Rune: 'a', Mod: ModCtrl},
+				KeyEvent{Rune: '^', Mod: ModCtrl},
+			})
+		})
+
+		Convey("terminfo and xterm key sequences", func() {
+			So(parse("\x1bOA\x1b[B\x1b[1;5C\x1b[3~\x1b[15~\x1b[Z"), ShouldResemble, []interface{}{
+				KeyEvent{Key: KeyUp},
+				KeyEvent{Key: KeyDown},
+				KeyEvent{Key: KeyRight, Mod: ModCtrl},
+				KeyEvent{Key: KeyDelete},
+				KeyEvent{Key: KeyF5},
+				KeyEvent{Key: KeyTab, Mod: ModShift},
+			})
+		})
+
+		Convey("alt and escape", func() {
+			So(parse("\x1bx\x1b"), ShouldResemble, []interface{}{
+				KeyEvent{Rune: 'x', Mod: ModAlt},
+				KeyEvent{Key: KeyEscape},
+			})
+		})
+
+		Convey("kitty keyboard protocol", func() {
+			So(parse("\x1b[97;5u\x1b[27u\x1b[13;2u"), ShouldResemble, []interface{}{
+				KeyEvent{Rune: 'a', Mod: ModCtrl},
+				KeyEvent{Key: KeyEscape},
+				KeyEvent{Key: KeyEnter, Mod: ModShift},
+			})
+		})
+
+		Convey("SGR mouse", func() {
+			So(parse("\x1b[<0;10;5M\x1b[<32;11;5M\x1b[<0;11;5m\x1b[<65;1;1M\x1b[<35;2;2M"), ShouldResemble, []interface{}{
+				MouseEvent{X: 9, Y: 4, Button: MouseLeft, Action: MousePress},
+				MouseEvent{X: 10, Y: 4, Button: MouseLeft, Action: MouseDrag},
+				MouseEvent{X: 10, Y: 4, Button: MouseLeft, Action: MouseRelease},
+				MouseEvent{X: 0, Y: 0, Button: MouseWheelDown, Action: MousePress},
+				MouseEvent{X: 1, Y: 1, Button: MouseNone, Action: MouseMove},
+			})
+		})
+
+		Convey("bracketed paste", func() {
+			So(parse("\x1b[200~a\x1bb\r\x1b[201~c"), ShouldResemble, []interface{}{
+				PasteEvent{Text: "a\x1bb\r"},
+				KeyEvent{Rune: 'c'},
+			})
+		})
+
+		Convey("focus", func() {
+			So(parse("\x1b[I\x1b[O"), ShouldResemble, []interface{}{
+				FocusEvent{Focused: true},
+				FocusEvent{Focused: false},
+			})
+		})
+
+		Convey("unfinished sequences are left for later", func() {
+			for _, input := range []string{"\x1b", "\x1b[1;5", "\x1b[200~abc", "\xc3"} {
+				events, used := parser.parse([]byte("a"+input), false)
+				So(len(events), ShouldEqual, 1)
+				So(used, ShouldEqual, 1)
+			}
+		})
+	})
+}
@@ -4,34 +4,52 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/dcbishop/jkl/service"
-	"github.com/nsf/termbox-go"
 	"github.com/spf13/afero"
 )
 
+// DefaultFrameInterval is the shortest time between redraws, changes made faster than this are drawn together.
+const DefaultFrameInterval = 16 * time.Millisecond
+
 // App is the main program.
+// It sleeps until there is input, posted work or a Timer is due and only redraws when something changed.
 type App struct {
-	quit   chan interface{}
-	UI     UI
-	state  service.State
-	editor *Editor
+	quit       chan interface{}
+	work       chan func()
+	UI         UI
+	remote     *Server
+	state      service.State
+	editor     *Editor
+	timers     scheduler
+	dirty      bool
+	lastRedraw time.Time
+	keyTimeout *Timer
+
+	restartArgs []string
+	restartEnv  []string
 
-	Out    io.Writer
-	ErrOut io.Writer
+	FrameInterval time.Duration
+	Out           io.Writer
+	ErrOut        io.Writer
 }
 
 // NewApp constructs a new app from the given options.
 func NewApp(options ...Option) App {
 	editor := NewEditor(afero.OsFs{})
 	app := App{
-		editor: &editor,
-		UI:     nil,
-		Out:    os.Stdout,
-		ErrOut: os.Stderr,
+		editor:        &editor,
+		UI:            nil,
+		FrameInterval: DefaultFrameInterval,
+		Out:           os.Stdout,
+		ErrOut:        os.Stderr,
 	}
 	app.initializeQuitChannel()
+	app.work = make(chan func(), 64)
+	work := app.work
+	editor.SetPost(func(f func()) { work <- f })
 
 	app.LoadOptions(options...)
 
@@ -52,11 +70,28 @@ func (app *App) SetUI(ui UI) {
 
 	app.UI = ui
 
+	if suspender, ok := ui.(Suspender); ok {
+		app.editor.SetSuspender(suspender)
+	} else {
+		app.editor.SetSuspender(nil)
+	}
+
+	if detacher, ok := ui.(Detacher); ok {
+		app.editor.SetDetacher(detacher)
+	} else {
+		app.editor.SetDetacher(nil)
+	}
+
 	if app.UI != nil && app.Running() {
 		go app.UI.Run()
 	}
 }
 
+// SetRemote sets a Server taking requests from other programs, ie "jkl --remote", while the App runs.
+func (app *App) SetRemote(server *Server) {
+	app.remote = server
+}
+
 // SetOut sets the default output stream of the App.
 func (app *App) SetOut(out io.Writer) {
 	app.Out = out
@@ -93,8 +128,19 @@ func (app *App) Run() {
 		}
 	}
 
+	if app.remote != nil {
+		go app.remote.Run()
+		service.WaitUntilRunning(app.remote, time.Second)
+	}
+
 	app.loopUntilQuit()
+	app.editor.Events().Publish(EditorEvent{Name: VimLeave})
+	app.editor.StopPlugins()
+	app.editor.StopLanguageServers()
 	app.UI.Stop()
+	if app.remote != nil {
+		app.remote.Stop()
+	}
 	app.state.SetStopped()
 }
 
@@ -127,65 +173,246 @@ func (app *App) initializeQuitChannel() {
 	app.quit = make(chan interface{})
 }
 
+// Post queues a function to run on the main loop, it is safe to call from any goroutine.
+// The screen is redrawn after it runs. Blocks if the queue is full.
+func (app *App) Post(f func()) {
+	app.work <- f
+}
+
+// AfterFunc runs f on the main loop once the duration has passed.
+// Must be called from the main loop.
+func (app *App) AfterFunc(d time.Duration, f func()) *Timer {
+	timer := &Timer{when: time.Now().Add(d), f: f}
+	app.timers.add(timer)
+	return timer
+}
+
+// Every runs f on the main loop each time the interval passes until the Timer is stopped.
+// Must be called from the main loop.
+func (app *App) Every(interval time.Duration, f func()) *Timer {
+	timer := &Timer{when: time.Now().Add(interval), interval: interval, f: f}
+	app.timers.add(timer)
+	return timer
+}
+
+// Invalidate marks the screen as needing to be redrawn.
+func (app *App) Invalidate() {
+	app.dirty = true
+}
+
 func (app *App) loopUntilQuit() {
+	app.Invalidate()
+
 loop:
 	for {
-		var events <-chan Event
+		var events, requests <-chan Event
 		if app.UI != nil {
 			events = app.UI.Events()
 		}
+		if app.remote != nil {
+			requests = app.remote.Events()
+		}
+
+		wake, stop := app.wakeUp()
 		select {
 		case <-app.quit:
+			stop()
 			break loop
 		case event := <-events:
 			app.handleEvent(event)
-		default:
-			app.Update()
+			app.Invalidate()
+		case event := <-requests:
+			app.handleEvent(event)
+			app.Invalidate()
+		case work := <-app.work:
+			work()
+			app.Invalidate()
+		case <-wake:
+		}
+		stop()
+
+		if app.timers.runDue(time.Now()) > 0 {
+			app.Invalidate()
+		}
+		app.redrawIfDirty()
+	}
+}
+
+// wakeUp returns a channel that fires when the next Timer is due or a pending redraw can happen.
+// It is nil if there is nothing to wait for. stop releases the underlying timer.
+func (app *App) wakeUp() (wake <-chan time.Time, stop func()) {
+	when, ok := app.timers.next()
+	if app.dirty {
+		frame := app.lastRedraw.Add(app.FrameInterval)
+		if !ok || frame.Before(when) {
+			when, ok = frame, true
 		}
 	}
+
+	if !ok {
+		return nil, func() {}
+	}
+
+	timer := time.NewTimer(time.Until(when))
+	return timer.C, func() { timer.Stop() }
+}
+
+// redrawIfDirty redraws if something changed and the frame interval has passed since the last redraw.
+func (app *App) redrawIfDirty() {
+	if !app.dirty || time.Since(app.lastRedraw) < app.FrameInterval {
+		return
+	}
+
+	app.dirty = false
+	app.lastRedraw = time.Now()
+	app.Update()
 }
 
 func (app *App) handleEvent(event Event) {
-	// [TODO]: Convert all Events to an interal format in the UI layer rather than using termbox directly. - 2014-09-27 11:27am
 	switch data := event.Data.(type) {
-	case termbox.Event:
-		app.handleTermboxEvent(data)
+	case KeyEvent:
+		app.handleKeyEvent(data)
+	case MouseEvent:
+		if app.editor.Settings().Mouse {
+			app.editor.HandleMouse(data)
+		}
+	case PasteEvent:
+		if app.editor.Mode() == TerminalMode {
+			app.editor.PasteTerminal(data.Text)
+		}
+		if app.editor.Mode() == InsertMode {
+			app.editor.InsertText(data.Text)
+		}
+	case OpenEvent:
+		buffer := app.editor.OpenFileAt(data.Filename, data.Line)
+		if data.Deleted != nil {
+			app.editor.WhenDeleted(buffer, data.Deleted)
+		}
+	case RestartEvent:
+		app.restartRequested(data)
 	}
 }
 
-func (app *App) handleTermboxEvent(event termbox.Event) {
-	switch event.Type {
-	case termbox.EventKey:
-		app.handleTermboxKeyEvent(event)
+func (app *App) handleKeyEvent(event KeyEvent) {
+	if app.editor.FocusedFloat() != nil {
+		app.editor.HandleFloatKey(event)
+		return
+	}
+	if app.editor.Mode() == CommandMode {
+		app.handleCommandLineKeyEvent(event)
+		return
+	}
+	if app.editor.Mode() == TerminalMode {
+		app.editor.HandleTerminalKey(event)
+		return
+	}
+	if app.editor.Mode() == InsertMode {
+		app.editor.HandleInsertKey(event)
+		return
 	}
-}
 
-func (app *App) handleTermboxKeyEvent(event termbox.Event) {
-	if event.Ch == 'q' {
+	if strings.Contains(app.editor.Message().Text, "\n") {
+		app.editor.ClearMessage()
+	}
+
+	if event.Key == KeyEscape && app.editor.Mode() == VisualMode {
+		app.editor.LeaveVisualMode()
+		return
+	}
+
+	if event.Key != KeyRune || event.Mod&ModAlt != 0 {
+		return
+	}
+
+	r := event.Rune
+	if event.Mod&ModCtrl != 0 {
+		r = []rune(ctrl(r))[0]
+	}
+	if used, err := app.editor.HandleNormalKey(r); used {
+		app.reportError(err)
+		app.startKeyTimeout()
+		return
+	}
+	if event.Mod&ModCtrl != 0 {
+		return
+	}
+
+	if event.Rune == 'q' {
 		go app.Stop()
 	}
 
+	if event.Rune == ':' {
+		app.editor.LeaveVisualMode()
+		app.editor.EnterCommandMode()
+		return
+	}
+
 	cursor := app.editor.CurrentPane().Cursor()
 	if cursor == nil {
 		return
 	}
 
-	if event.Ch == 'j' {
+	if event.Rune == 'j' {
 		cursor.Move(cursor.DownLine())
 	}
-	if event.Ch == 'k' {
+	if event.Rune == 'k' {
 		cursor.Move(cursor.UpLine())
 	}
-	if event.Ch == 'h' {
+	if event.Rune == 'h' {
 		cursor.Move(cursor.BackCharacter())
 	}
-	if event.Ch == 'l' {
+	if event.Rune == 'l' {
 		cursor.Move(cursor.ForwardCharacter())
 	}
 }
 
+func (app *App) handleCommandLineKeyEvent(event KeyEvent) {
+	commandLine := app.editor.CommandLine()
+
+	switch event.Key {
+	case KeyEscape:
+		app.editor.LeaveCommandMode()
+	case KeyEnter:
+		app.reportError(app.editor.ExecuteCommandLine())
+	case KeyTab:
+		app.editor.CompleteCommandLine()
+	case KeyBackspace:
+		if !commandLine.Backspace() {
+			app.editor.LeaveCommandMode()
+		}
+	case KeyRune:
+		if event.Mod&(ModCtrl|ModAlt) == 0 {
+			commandLine.Insert(event.Rune)
+		}
+	}
+}
+
+// startKeyTimeout abandons an unfinished key sequence if it isn't completed within Settings.TimeoutLength.
+func (app *App) startKeyTimeout() {
+	if app.keyTimeout != nil {
+		app.keyTimeout.Stop()
+		app.keyTimeout = nil
+	}
+
+	if app.editor.PendingKeys() == "" {
+		return
+	}
+
+	timeout := time.Duration(app.editor.Settings().TimeoutLength) * time.Millisecond
+	app.keyTimeout = app.AfterFunc(timeout, app.editor.CancelPendingKeys)
+}
+
+// reportError shows the error to the user if there is one.
+func (app *App) reportError(err error) {
+	if err != nil {
+		app.editor.EchoError(err)
+	}
+}
+
 // Update processes input and redraws the app.
 func (app *App) Update() {
+	app.editor.ShareCursors()
+	app.editor.NotifyChanges()
 	if app.UI != nil {
 		app.UI.Redraw(app.editor)
 	}
@@ -66,3 +66,150 @@ func TestLoadOptions(t *testing.T) {
 		So(len(app.editor.Buffers()), ShouldEqual, 2)
 	})
 }
+
+func typeKeys(app *App, keys ...interface{}) {
+	for _, key := range keys {
+		var event KeyEvent
+		switch k := key.(type) {
+		case rune:
+			event.Rune = k
+		case string:
+			for _, r := range k {
+				typeKeys(app, r)
+			}
+			continue
+		case Key:
+			event.Key = k
+		case KeyEvent:
+			event = k
+		}
+		app.handleEvent(Event{event})
+	}
+}
+
+func TestBufferKeys(t *testing.T) {
+	Convey("app with two files open", t, func() {
+		app := fakeApp()
+		app.Editor().OpenFiles([]string{"fakefile.txt", "fakefile2.txt"})
+
+		Convey("typing an ex command switches buffer", func() {
+			typeKeys(&app, ':', "b 2", KeyEnter)
+			So(app.Editor().Mode(), ShouldEqual, NormalMode)
+			So(app.Editor().CurrentPane().Buffer().ID(), ShouldEqual, 2)
+
+			Convey("Ctrl-^ switches to the alternate buffer", func() {
+				typeKeys(&app, KeyEvent{Rune: '^', Mod: ModCtrl})
+				So(app.Editor().CurrentPane().Buffer().ID(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("errors are shown as a message", func() {
+			typeKeys(&app, KeyEvent{Rune: '^', Mod: ModCtrl})
+			So(app.Editor().Message(), ShouldResemble, Message{Text: "No alternate file", Severity: SeverityError})
+		})
+
+		Convey("escape abandons the command", func() {
+			typeKeys(&app, ':', "b 2", KeyEscape)
+			So(app.Editor().Mode(), ShouldEqual, NormalMode)
+			So(app.Editor().CurrentPane().Buffer().ID(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestEventLoop(t *testing.T) {
+	Convey("given a running app with a fake UI", t, func() {
+		ui := NewFakeUI()
+		app := NewApp(SetUI(&ui), SetFS(GetCustomTestFs(fakeFileSystem)))
+		app.FrameInterval = time.Millisecond
+		go app.Run()
+		So(service.WaitUntilRunning(&app, time.Second), ShouldBeNil)
+		defer app.Stop()
+
+		waitForRedraws := func(count int) bool {
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if ui.Redraws() >= count {
+					return true
+				}
+				time.Sleep(time.Millisecond)
+			}
+			return false
+		}
+
+		So(waitForRedraws(1), ShouldBeTrue)
+
+		Convey("an idle app doesn't redraw", func() {
+			redraws := ui.Redraws()
+			time.Sleep(50 * time.Millisecond)
+			So(ui.Redraws(), ShouldEqual, redraws)
+		})
+
+		Convey("input causes a redraw", func() {
+			redraws := ui.Redraws()
+			ui.eventChan <- Event{KeyEvent{Rune: 'j'}}
+			So(waitForRedraws(redraws+1), ShouldBeTrue)
+		})
+
+		Convey("posted work runs on the main loop and causes a redraw", func() {
+			redraws := ui.Redraws()
+			done := make(chan bool)
+			app.Post(func() {
+				app.Editor().Echo("from the background")
+				done <- true
+			})
+			<-done
+			So(waitForRedraws(redraws+1), ShouldBeTrue)
+		})
+
+		Convey("timers run on the main loop", func() {
+			fired := make(chan bool, 10)
+			app.Post(func() {
+				app.AfterFunc(5*time.Millisecond, func() { fired <- true })
+			})
+
+			select {
+			case <-fired:
+			case <-time.After(time.Second):
+				So("timer never fired", ShouldBeEmpty)
+			}
+		})
+
+		Convey("repeating timers run until stopped", func() {
+			fired := make(chan bool, 10)
+			var timer *Timer
+			count := 0
+			app.Post(func() {
+				timer = app.Every(time.Millisecond, func() {
+					count++
+					if count == 3 {
+						timer.Stop()
+						fired <- true
+					}
+				})
+			})
+
+			select {
+			case <-fired:
+			case <-time.After(time.Second):
+				So("timer never stopped", ShouldBeEmpty)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			result := make(chan int)
+			app.Post(func() { result <- count })
+			So(<-result, ShouldEqual, 3)
+		})
+
+		Convey("unfinished key sequences time out", func() {
+			result := make(chan string)
+			app.Post(func() { app.Editor().Settings().TimeoutLength = 5 })
+			ui.eventChan <- Event{KeyEvent{Rune: 'z'}}
+			app.Post(func() { result <- app.Editor().PendingKeys() })
+			So(<-result, ShouldEqual, "z")
+
+			time.Sleep(20 * time.Millisecond)
+			app.Post(func() { result <- app.Editor().PendingKeys() })
+			So(<-result, ShouldEqual, "")
+		})
+	})
+}
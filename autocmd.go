@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// EventName names something that happens in the editor, see EventBus.
+type EventName string
+
+// Events of the editor, named after Vim's autocommand events.
+// BufNew is sent when a buffer is added, BufRead once a file is read into a new buffer, BufWritePre
+// and BufWritePost before and after a buffer is written and BufDelete when a buffer is deleted or wiped.
+// BufLeave and BufEnter are sent when the current buffer changes, TextChanged after a buffer's text
+// changed, CursorMoved after the cursor of the current pane moved, ModeChanged when the input mode does,
+// PaneResized once a pane was drawn at a new size and VimLeave before jkl exits.
+const (
+	BufNew       EventName = "BufNew"
+	BufRead      EventName = "BufRead"
+	BufWritePre  EventName = "BufWritePre"
+	BufWritePost EventName = "BufWritePost"
+	BufDelete    EventName = "BufDelete"
+	BufEnter     EventName = "BufEnter"
+	BufLeave     EventName = "BufLeave"
+	TextChanged  EventName = "TextChanged"
+	CursorMoved  EventName = "CursorMoved"
+	ModeChanged  EventName = "ModeChanged"
+	PaneResized  EventName = "PaneResized"
+	VimLeave     EventName = "VimLeave"
+)
+
+var eventNames = []EventName{
+	BufNew, BufRead, BufWritePre, BufWritePost, BufDelete, BufEnter, BufLeave,
+	TextChanged, CursorMoved, ModeChanged, PaneResized, VimLeave,
+}
+
+// ParseEventName returns the event with the name, ignoring case like Vim.
+func ParseEventName(name string) (EventName, error) {
+	for _, event := range eventNames {
+		if strings.EqualFold(string(event), name) {
+			return event, nil
+		}
+	}
+	return "", fmt.Errorf("No such event: %s", name)
+}
+
+// EditorEvent is passed to subscribers. Buffer is the buffer the event is about, nil for ModeChanged,
+// PaneResized and VimLeave. Pane is set for BufEnter, BufLeave, CursorMoved and PaneResized
+// and Mode is the new mode for ModeChanged.
+type EditorEvent struct {
+	Name   EventName
+	Buffer *Buffer
+	Pane   *Pane
+	Mode   Mode
+}
+
+// EventHandler is called with the events it subscribed to.
+type EventHandler func(event EditorEvent)
+
+// maxEventDepth is how many events can be published by the subscribers of other events,
+// so an autocommand that causes its own event doesn't run forever.
+const maxEventDepth = 10
+
+// EventBus passes the events of the editor to their subscribers.
+type EventBus struct {
+	subscribers map[EventName][]*subscription
+	lastID      int
+	depth       int
+}
+
+type subscription struct {
+	id       int
+	patterns []string
+	handler  EventHandler
+	removed  bool
+}
+
+// Subscribe calls handler when the event is published and returns an id for Unsubscribe.
+// The pattern is a comma separated list of globs matched against the filename of the event's buffer,
+// or its base name for globs without a '/'. A pattern only matches events about a buffer, an empty
+// one matches every event.
+func (bus *EventBus) Subscribe(name EventName, pattern string, handler EventHandler) int {
+	if bus.subscribers == nil {
+		bus.subscribers = map[EventName][]*subscription{}
+	}
+	bus.lastID++
+	s := &subscription{id: bus.lastID, handler: handler}
+	if pattern != "" {
+		s.patterns = strings.Split(pattern, ",")
+	}
+	bus.subscribers[name] = append(bus.subscribers[name], s)
+	return s.id
+}
+
+// Unsubscribe stops calling a handler, it returns false if the id wasn't subscribed.
+func (bus *EventBus) Unsubscribe(id int) bool {
+	for name, subscribers := range bus.subscribers {
+		for i, s := range subscribers {
+			if s.id == id {
+				s.removed = true
+				bus.subscribers[name] = append(subscribers[:i:i], subscribers[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Subscribed returns true if anything subscribed to the event.
+func (bus *EventBus) Subscribed(name EventName) bool {
+	return len(bus.subscribers[name]) > 0
+}
+
+// Publish calls the subscribers of the event that match it in the order they subscribed.
+func (bus *EventBus) Publish(event EditorEvent) {
+	if bus.depth >= maxEventDepth {
+		return
+	}
+	bus.depth++
+	defer func() { bus.depth-- }()
+
+	subscribers := append([]*subscription{}, bus.subscribers[event.Name]...)
+	for _, s := range subscribers {
+		if !s.removed && s.matches(event) {
+			s.handler(event)
+		}
+	}
+}
+
+func (s *subscription) matches(event EditorEvent) bool {
+	if s.patterns == nil {
+		return true
+	}
+	if event.Buffer == nil {
+		return false
+	}
+	filename := event.Buffer.Filename()
+	for _, pattern := range s.patterns {
+		name := filename
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(filename)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Events returns the editor's event bus.
+func (editor *Editor) Events() *EventBus {
+	return &editor.events
+}
+
+// eventWatch is what NotifyChanges saw the last time it was called.
+type eventWatch struct {
+	versions map[*Buffer]int
+	sizes    map[*Pane][2]int
+	pane     *Pane
+	buffer   *Buffer
+	x, line  int
+}
+
+// NotifyChanges publishes the events for what changed since it was last called, the text of buffers,
+// the current buffer, the cursor and the size of panes.
+func (editor *Editor) NotifyChanges() {
+	watch := &editor.watch
+
+	if editor.events.Subscribed(TextChanged) {
+		versions := map[*Buffer]int{}
+		for _, buffer := range editor.buffers {
+			version, seen := watch.versions[buffer]
+			versions[buffer] = buffer.version
+			if seen && version != buffer.version {
+				editor.events.Publish(EditorEvent{Name: TextChanged, Buffer: buffer})
+			}
+		}
+		watch.versions = versions
+	}
+
+	pane := editor.CurrentPane()
+	buffer := pane.Buffer()
+	x, line := 0, 0
+	if cursor := pane.Cursor(); cursor != nil {
+		x, line = cursor.Position()
+	}
+	if buffer != watch.buffer {
+		if watch.buffer != nil {
+			editor.events.Publish(EditorEvent{Name: BufLeave, Buffer: watch.buffer, Pane: watch.pane})
+		}
+		if buffer != nil {
+			editor.events.Publish(EditorEvent{Name: BufEnter, Buffer: buffer, Pane: pane})
+		}
+	} else if pane == watch.pane && buffer != nil && (x != watch.x || line != watch.line) {
+		editor.events.Publish(EditorEvent{Name: CursorMoved, Buffer: buffer, Pane: pane})
+	}
+	watch.pane, watch.buffer, watch.x, watch.line = pane, buffer, x, line
+
+	sizes := map[*Pane][2]int{}
+	for _, pane := range editor.panes {
+		size := [2]int{pane.View().Width, pane.View().Height}
+		last, seen := watch.sizes[pane]
+		sizes[pane] = size
+		if seen && size != last {
+			editor.events.Publish(EditorEvent{Name: PaneResized, Pane: pane})
+		}
+	}
+	watch.sizes = sizes
+}
+
+// autocommand is an ex command run on an event, added with ":autocmd".
+type autocommand struct {
+	id      int
+	event   EventName
+	pattern string
+	command string
+}
+
+// AddAutocommand runs an ex command when the event happens to a buffer matching the pattern,
+// see EventBus.Subscribe. Errors from the command are shown.
+// A pattern of "*" matches every event like an empty one.
+func (editor *Editor) AddAutocommand(event EventName, pattern, command string) {
+	glob := pattern
+	if glob == "*" {
+		glob = ""
+	}
+	id := editor.events.Subscribe(event, glob, func(EditorEvent) {
+		if err := editor.ExecuteCommand(command); err != nil {
+			editor.EchoError(err)
+		}
+	})
+	editor.autocommands = append(editor.autocommands, autocommand{id, event, pattern, command})
+}
+
+// RemoveAutocommands removes the autocommands for the event, or all of them if it's empty.
+func (editor *Editor) RemoveAutocommands(event EventName) {
+	kept := []autocommand{}
+	for _, autocmd := range editor.autocommands {
+		if event == "" || autocmd.event == event {
+			editor.events.Unsubscribe(autocmd.id)
+		} else {
+			kept = append(kept, autocmd)
+		}
+	}
+	editor.autocommands = kept
+}
+
+// autocmdCommand adds autocommands with ":au[tocmd] {event}[,{event}] {pattern} {command}",
+// lists them without a command and removes them with ":au[tocmd]! [event]".
+func autocmdCommand(editor *Editor, command Command) error {
+	fields := strings.Fields(command.Args)
+	events := []EventName{}
+	if len(fields) > 0 {
+		for _, name := range strings.Split(fields[0], ",") {
+			event, err := ParseEventName(name)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+	}
+
+	if command.Bang {
+		if len(fields) > 1 {
+			return errors.New("Trailing characters")
+		}
+		if len(events) == 0 {
+			editor.RemoveAutocommands("")
+		}
+		for _, event := range events {
+			editor.RemoveAutocommands(event)
+		}
+		return nil
+	}
+
+	if len(fields) < 3 {
+		lines := []string{}
+		for _, autocmd := range editor.autocommands {
+			if len(events) == 0 || autocmd.event == events[0] {
+				lines = append(lines, fmt.Sprintf("%s %s %s", autocmd.event, autocmd.pattern, autocmd.command))
+			}
+		}
+		if len(lines) == 0 {
+			editor.Echo("No autocommands")
+			return nil
+		}
+		editor.Echo(strings.Join(lines, "\n"))
+		return nil
+	}
+
+	// The command is the rest of the line after the pattern, spaces included.
+	rest := strings.TrimSpace(command.Args)
+	for _, field := range fields[:2] {
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, field))
+	}
+	for _, event := range events {
+		editor.AddAutocommand(event, fields[1], rest)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestEventBus(t *testing.T) {
+	Convey("An event bus", t, func() {
+		bus := EventBus{}
+		heard := []string{}
+		listen := func(name string) EventHandler {
+			return func(event EditorEvent) { heard = append(heard, name) }
+		}
+		buffer := NewBuffer()
+		buffer.SetFilename("src/main.go")
+
+		Convey("calls subscribers in order", func() {
+			bus.Subscribe(BufRead, "", listen("first"))
+			bus.Subscribe(BufRead, "", listen("second"))
+			bus.Subscribe(BufNew, "", listen("other"))
+			bus.Publish(EditorEvent{Name: BufRead, Buffer: &buffer})
+			So(heard, ShouldResemble, []string{"first", "second"})
+			So(bus.Subscribed(BufRead), ShouldBeTrue)
+			So(bus.Subscribed(VimLeave), ShouldBeFalse)
+		})
+
+		Convey("matches filenames", func() {
+			bus.Subscribe(BufRead, "*.go", listen("base name"))
+			bus.Subscribe(BufRead, "*.txt,src/*.go", listen("path"))
+			bus.Subscribe(BufRead, "*.txt", listen("wrong"))
+			bus.Publish(EditorEvent{Name: BufRead, Buffer: &buffer})
+			bus.Publish(EditorEvent{Name: BufRead})
+			So(heard, ShouldResemble, []string{"base name", "path"})
+		})
+
+		Convey("stops calling those that unsubscribe", func() {
+			var id int
+			id = bus.Subscribe(BufRead, "", func(EditorEvent) {
+				heard = append(heard, "once")
+				bus.Unsubscribe(id)
+			})
+			bus.Subscribe(BufRead, "", listen("always"))
+			bus.Publish(EditorEvent{Name: BufRead})
+			bus.Publish(EditorEvent{Name: BufRead})
+			So(heard, ShouldResemble, []string{"once", "always", "always"})
+			So(bus.Unsubscribe(id), ShouldBeFalse)
+		})
+
+		Convey("doesn't let events publish themselves forever", func() {
+			bus.Subscribe(TextChanged, "", func(event EditorEvent) {
+				heard = append(heard, "changed")
+				bus.Publish(event)
+			})
+			bus.Publish(EditorEvent{Name: TextChanged})
+			So(heard, ShouldHaveLength, maxEventDepth)
+		})
+	})
+
+	Convey("Event names ignore case", t, func() {
+		name, err := ParseEventName("bufwritepost")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, BufWritePost)
+		_, err = ParseEventName("BufNothing")
+		So(err.Error(), ShouldEqual, "No such event: BufNothing")
+	})
+}
+
+func TestEditorEvents(t *testing.T) {
+	Convey("Editor publishing events", t, func() {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "one.txt", []byte("one\ntwo\n"), 0644)
+		editor := NewEditor(fs)
+		events := []string{}
+		for _, name := range eventNames {
+			editor.Events().Subscribe(name, "", func(event EditorEvent) {
+				text := string(event.Name)
+				if event.Buffer != nil {
+					text += " " + event.Buffer.Name()
+				}
+				if event.Name == ModeChanged {
+					text += " " + event.Mode.String()
+				}
+				events = append(events, text)
+			})
+		}
+		editor.OpenFile("one.txt")
+		editor.NotifyChanges()
+
+		So(events, ShouldResemble, []string{"BufNew one.txt", "BufRead one.txt", "BufEnter one.txt"})
+		events = events[:0]
+
+		Convey("for edits, writes and modes", func() {
+			editor.HandleNormalKey('i')
+			editor.InsertText("x")
+			editor.HandleNormalKey('j')
+			editor.NotifyChanges()
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+			So(events, ShouldResemble, []string{
+				"ModeChanged insert", "TextChanged one.txt", "CursorMoved one.txt", "BufWritePre one.txt", "BufWritePost one.txt",
+			})
+		})
+
+		Convey("for switching and deleting buffers", func() {
+			editor.OpenFile("two.txt")
+			editor.NotifyChanges()
+			So(editor.ExecuteCommand("bd"), ShouldBeNil)
+			So(events, ShouldResemble, []string{
+				"BufNew two.txt", "BufRead two.txt", "BufLeave one.txt", "BufEnter two.txt", "BufDelete two.txt",
+			})
+		})
+
+		Convey("for panes drawn at a new size", func() {
+			editor.CurrentPane().View().Width = 40
+			editor.NotifyChanges()
+			editor.NotifyChanges()
+			So(events, ShouldResemble, []string{"PaneResized"})
+		})
+
+		Convey("runs autocommands", func() {
+			So(editor.ExecuteCommand("au BufWritePost *.txt set number"), ShouldBeNil)
+			So(editor.ExecuteCommand("autocmd bufenter,BufLeave * lua editor.echo(\"moved on\")"), ShouldBeNil)
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+			So(editor.Settings().Number, ShouldBeTrue)
+
+			So(editor.ExecuteCommand("au"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "BufWritePost *.txt set number\nBufEnter * lua editor.echo(\"moved on\")\nBufLeave * lua editor.echo(\"moved on\")")
+
+			So(editor.ExecuteCommand("au! BufWritePost"), ShouldBeNil)
+			editor.OpenFile("two.txt")
+			editor.NotifyChanges()
+			So(editor.Message().Text, ShouldEqual, "moved on")
+
+			So(editor.ExecuteCommand("au!"), ShouldBeNil)
+			So(editor.ExecuteCommand("au"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "No autocommands")
+			So(editor.ExecuteCommand("au Nothing * echo"), ShouldNotBeNil)
+		})
+
+		Convey("calls WhenDeleted once", func() {
+			deleted := 0
+			editor.WhenDeleted(editor.CurrentPane().Buffer(), func() { deleted++ })
+			So(editor.ExecuteCommand("bd"), ShouldBeNil)
+			So(editor.ExecuteCommand("b1"), ShouldBeNil)
+			So(editor.ExecuteCommand("bd"), ShouldBeNil)
+			So(deleted, ShouldEqual, 1)
+		})
+	})
+}
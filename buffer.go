@@ -4,13 +4,32 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"path/filepath"
 	"strings"
+	"unicode/utf8"
 )
 
 // Buffer is a simple implenetation of the buffer that stores data in a []byte.
 type Buffer struct {
-	filename string
-	data     []byte
+	id         int
+	filename   string
+	data       []byte
+	modified   bool
+	listed     bool
+	signs      []Sign
+	lastSignID int
+	terminal   *Terminal
+	undo       [][]bufferChange
+	shared     *SharedBuffer
+	version    int
+	watchers   map[interface{}]func(start, end int, text string)
+}
+
+// bufferChange is an edit that can be undone, removed was replaced by inserted at start.
+type bufferChange struct {
+	start    int
+	removed  string
+	inserted string
 }
 
 // NewBuffer constructs a new ByteBuffer object containing data.
@@ -18,6 +37,34 @@ func NewBuffer() Buffer {
 	return Buffer{}
 }
 
+// ID returns the buffers number. It is assigned by the Editor and is 0 until the buffer is added.
+func (buffer *Buffer) ID() int {
+	return buffer.id
+}
+
+// Name returns the name used to display the buffer.
+func (buffer *Buffer) Name() string {
+	if buffer.filename == "" {
+		return "[No Name]"
+	}
+	return buffer.filename
+}
+
+// Modified returns true if the buffer has changes that haven't been written.
+func (buffer *Buffer) Modified() bool {
+	return buffer.modified
+}
+
+// SetModified sets the modified flag of the buffer.
+func (buffer *Buffer) SetModified(modified bool) {
+	buffer.modified = modified
+}
+
+// Listed returns true if the buffer shows up in the buffer list.
+func (buffer *Buffer) Listed() bool {
+	return buffer.listed
+}
+
 // Filename returns the buffers filename as a byte slice.
 func (buffer *Buffer) Filename() string {
 	return buffer.filename
@@ -52,7 +99,61 @@ func (buffer *Buffer) ReadData(data io.Reader) {
 }
 
 func (buffer *Buffer) setData(data []byte) {
+	buffer.notifyEdit(0, len(buffer.data), string(data))
 	buffer.data = data
+	buffer.undo = nil
+	buffer.version++
+}
+
+// LineCount returns the number of lines in the buffer.
+func (buffer *Buffer) LineCount() int {
+	count := bytes.Count(buffer.data, []byte{'\n'})
+	if len(buffer.data) > 0 && buffer.data[len(buffer.data)-1] != '\n' {
+		count++
+	}
+	return count
+}
+
+// FileFormat returns the line ending style of the buffer, "dos" for CRLF or "unix" for LF.
+func (buffer *Buffer) FileFormat() string {
+	if bytes.Contains(buffer.data, []byte("\r\n")) {
+		return "dos"
+	}
+	return "unix"
+}
+
+// Encoding returns "utf-8" if the buffer contains valid UTF-8, otherwise "latin1".
+func (buffer *Buffer) Encoding() string {
+	if utf8.Valid(buffer.data) {
+		return "utf-8"
+	}
+	return "latin1"
+}
+
+// Filetype returns the type of the buffers contents guessed from its filename.
+func (buffer *Buffer) Filetype() string {
+	return filetypes[strings.ToLower(filepath.Ext(buffer.filename))]
+}
+
+// filetypes maps file extensions to filetypes.
+var filetypes = map[string]string{
+	".c":    "c",
+	".cpp":  "cpp",
+	".css":  "css",
+	".go":   "go",
+	".h":    "c",
+	".html": "html",
+	".java": "java",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".py":   "python",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".sh":   "sh",
+	".txt":  "text",
+	".yaml": "yaml",
+	".yml":  "yaml",
 }
 
 // GetLine returns the requested line as a string.
@@ -101,6 +202,156 @@ func (buffer *Buffer) GetLines(first, last int) ([]string, error) {
 	}
 }
 
+// ReplaceLines replaces the lines from first to last with new lines, which can be a different number of lines.
+func (buffer *Buffer) ReplaceLines(first, last int, lines []string) error {
+	if first < 1 || last < first || last > buffer.LineCount() {
+		return errors.New("Invalid range")
+	}
+
+	start, end := buffer.lineOffset(first), buffer.lineOffset(last+1)
+	text := joinLines(lines, end == len(buffer.data) && !buffer.endsWithNewline())
+	buffer.splice(start, end, text)
+	return nil
+}
+
+// InsertLines adds lines after the given line, 0 inserts them at the start of the buffer.
+func (buffer *Buffer) InsertLines(after int, lines []string) error {
+	if after < 0 || after > buffer.LineCount() {
+		return errors.New("Invalid line")
+	}
+
+	start := buffer.lineOffset(after + 1)
+	text := joinLines(lines, false)
+	if start == len(buffer.data) && len(buffer.data) > 0 && !buffer.endsWithNewline() {
+		text = "\n" + strings.TrimSuffix(text, "\n")
+	}
+	buffer.splice(start, start, text)
+	return nil
+}
+
+// lineOffset returns the index in the data where the line starts, or the end of the data for lines after the last.
+func (buffer *Buffer) lineOffset(line int) int {
+	pos := 0
+	for i := 1; i < line; i++ {
+		next := bytesUntillNextNewline(buffer.data[pos:])
+		if next == -1 {
+			return len(buffer.data)
+		}
+		pos += next + 1
+	}
+	return pos
+}
+
+func (buffer *Buffer) endsWithNewline() bool {
+	return len(buffer.data) > 0 && buffer.data[len(buffer.data)-1] == '\n'
+}
+
+// Offset returns the index in the data of the character x of the line, clamped to the end of the line.
+func (buffer *Buffer) Offset(x, line int) int {
+	start := buffer.lineOffset(line)
+	end := start
+	for i := 0; i < x && end < len(buffer.data) && buffer.data[end] != '\n'; i++ {
+		_, size := utf8.DecodeRune(buffer.data[end:])
+		end += size
+	}
+	return end
+}
+
+// Position returns the character and line number at an index in the data, see Offset.
+func (buffer *Buffer) Position(offset int) (x int, line int) {
+	if offset > len(buffer.data) {
+		offset = len(buffer.data)
+	}
+	before := buffer.data[:offset]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return utf8.RuneCount(before[lineStart:]), bytes.Count(before, []byte{'\n'}) + 1
+}
+
+// Undo reverts the last change and returns the index in the data where it was, false if there is nothing to undo.
+// In a shared buffer it reverts the last change made in this editor, leaving those of others alone.
+func (buffer *Buffer) Undo() (int, bool) {
+	if buffer.shared != nil {
+		return buffer.shared.undo()
+	}
+	if len(buffer.undo) == 0 {
+		return 0, false
+	}
+
+	group := buffer.undo[len(buffer.undo)-1]
+	buffer.undo = buffer.undo[:len(buffer.undo)-1]
+	for i := len(group) - 1; i >= 0; i-- {
+		change := group[i]
+		buffer.replace(change.start, change.start+len(change.inserted), change.removed)
+	}
+	buffer.modified = true
+	return group[0].start, true
+}
+
+// splice replaces data[start:end] with text and marks the buffer modified.
+func (buffer *Buffer) splice(start, end int, text string) {
+	buffer.change(start, end, text, false)
+}
+
+// change is splice that, with join, is undone together with the previous change, ie text typed in one go.
+// Changes to shared buffers are sent to the other editors.
+func (buffer *Buffer) change(start, end int, text string, join bool) {
+	if buffer.shared != nil {
+		buffer.shared.edit(start, end, text, join)
+	} else {
+		change := bufferChange{start: start, removed: string(buffer.data[start:end]), inserted: text}
+		if join && len(buffer.undo) > 0 {
+			buffer.undo[len(buffer.undo)-1] = append(buffer.undo[len(buffer.undo)-1], change)
+		} else {
+			buffer.undo = append(buffer.undo, []bufferChange{change})
+		}
+	}
+	buffer.replace(start, end, text)
+	buffer.modified = true
+}
+
+// replace replaces data[start:end] with text.
+func (buffer *Buffer) replace(start, end int, text string) {
+	buffer.notifyEdit(start, end, text)
+	data := make([]byte, 0, len(buffer.data)-(end-start)+len(text))
+	data = append(data, buffer.data[:start]...)
+	data = append(data, text...)
+	data = append(data, buffer.data[end:]...)
+	buffer.data = data
+	buffer.version++
+}
+
+// watchEdits calls f before each change to the data with the part being replaced and its replacement,
+// ie to keep a copy of the buffer elsewhere up to date. A nil f stops the owner's watching.
+func (buffer *Buffer) watchEdits(owner interface{}, f func(start, end int, text string)) {
+	if f == nil {
+		delete(buffer.watchers, owner)
+		return
+	}
+	if buffer.watchers == nil {
+		buffer.watchers = map[interface{}]func(int, int, string){}
+	}
+	buffer.watchers[owner] = f
+}
+
+// notifyEdit tells the watchers about a change before it's made.
+func (buffer *Buffer) notifyEdit(start, end int, text string) {
+	for _, f := range buffer.watchers {
+		f(start, end, text)
+	}
+}
+
+// joinLines joins lines ending each with a newline, except the last if noFinalNewline is true.
+func joinLines(lines []string, noFinalNewline bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	text := strings.Join(lines, "\n") + "\n"
+	if noFinalNewline {
+		text = strings.TrimSuffix(text, "\n")
+	}
+	return text
+}
+
 func untillNewLineOrEnd(data []byte) (string, error) {
 	endOfLine := bytesUntillNextNewline(data)
 	if endOfLine == -1 {
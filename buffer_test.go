@@ -148,3 +148,103 @@ func TestGetLines(t *testing.T) {
 		})
 	})
 }
+
+func TestBufferInfo(t *testing.T) {
+	Convey("LineCount", t, func() {
+		buffer := NewBuffer()
+		So(buffer.LineCount(), ShouldEqual, 0)
+		buffer.SetDataString("1\n2")
+		So(buffer.LineCount(), ShouldEqual, 2)
+		buffer.SetDataString("1\n2\n")
+		So(buffer.LineCount(), ShouldEqual, 2)
+	})
+
+	Convey("FileFormat and Encoding", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("1\n2\n")
+		So(buffer.FileFormat(), ShouldEqual, "unix")
+		So(buffer.Encoding(), ShouldEqual, "utf-8")
+
+		buffer.SetData([]byte("1\r\n\xe9\r\n"))
+		So(buffer.FileFormat(), ShouldEqual, "dos")
+		So(buffer.Encoding(), ShouldEqual, "latin1")
+	})
+
+	Convey("Filetype", t, func() {
+		buffer := NewBuffer()
+		So(buffer.Filetype(), ShouldEqual, "")
+		buffer.SetFilename("dir/Main.GO")
+		So(buffer.Filetype(), ShouldEqual, "go")
+	})
+}
+
+func TestBufferEditLines(t *testing.T) {
+	Convey("Buffer with some lines", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("1\n2\n3\n")
+
+		Convey("ReplaceLines can change the number of lines", func() {
+			So(buffer.ReplaceLines(2, 3, []string{"a", "b", "c"}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "1\na\nb\nc\n")
+			So(buffer.Modified(), ShouldBeTrue)
+		})
+
+		Convey("ReplaceLines with nothing deletes them", func() {
+			So(buffer.ReplaceLines(1, 2, []string{}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "3\n")
+		})
+
+		Convey("ReplaceLines keeps a missing final newline missing", func() {
+			buffer.SetDataString("1\n2")
+			So(buffer.ReplaceLines(2, 2, []string{"b"}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "1\nb")
+		})
+
+		Convey("ReplaceLines checks the range", func() {
+			So(buffer.ReplaceLines(0, 1, []string{}), ShouldNotBeNil)
+			So(buffer.ReplaceLines(2, 4, []string{}), ShouldNotBeNil)
+		})
+
+		Convey("InsertLines at the start, middle and end", func() {
+			So(buffer.InsertLines(0, []string{"a"}), ShouldBeNil)
+			So(buffer.InsertLines(2, []string{"b"}), ShouldBeNil)
+			So(buffer.InsertLines(5, []string{"c"}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "a\n1\nb\n2\n3\nc\n")
+		})
+
+		Convey("InsertLines after a last line without a newline", func() {
+			buffer.SetDataString("1")
+			So(buffer.InsertLines(1, []string{"a", "b"}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "1\na\nb")
+		})
+
+		Convey("InsertLines into an empty buffer", func() {
+			buffer.SetDataString("")
+			So(buffer.InsertLines(0, []string{"a"}), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "a\n")
+		})
+	})
+}
+
+func TestBufferOffsets(t *testing.T) {
+	Convey("Buffer with multibyte characters", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("añb\nçd\n")
+
+		Convey("Offset turns characters into indexes in the data", func() {
+			So(buffer.Offset(0, 1), ShouldEqual, 0)
+			So(buffer.Offset(2, 1), ShouldEqual, 3)
+			So(buffer.Offset(9, 1), ShouldEqual, 4)
+			So(buffer.Offset(1, 2), ShouldEqual, 7)
+			So(buffer.Offset(0, 3), ShouldEqual, 9)
+		})
+
+		Convey("Position turns them back", func() {
+			for _, offset := range []int{0, 3, 4, 7, 9} {
+				So(buffer.Offset(buffer.Position(offset)), ShouldEqual, offset)
+			}
+			x, line := buffer.Position(100)
+			So([]int{x, line}, ShouldResemble, []int{0, 3})
+		})
+	})
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/dcbishop/jkl/globals"
 	"github.com/docopt/docopt-go"
@@ -13,11 +16,30 @@ import (
 var usageMessage = `%[1]s
 
 Usage:
-  %[2]s [<file>...]
+  %[2]s [--driver=<driver>] [<file>...]
+  %[2]s (-d | --diff) [--driver=<driver>] <file> <file> [<file>]
+  %[2]s --daemon [--session=<name>] [<file>...]
+  %[2]s --attach [--session=<name>] [--driver=<driver>]
+  %[2]s --detach [--session=<name>]
+  %[2]s (--remote | --remote-wait) [--session=<name>] <file>...
+  %[2]s --hub=<address>
+  %[2]s --rebuild [--session=<name>] [--source=<dir>] [--plugins=<manifest>]
   %[2]s -h | --help
 
 Options:
-  -h --help     Show this screen.
+  -h --help           Show this screen.
+  --driver=<driver>   Terminal driver, "termbox" or "ansi" [default: termbox].
+  -d --diff           Show the differences between two or three files side by side.
+  --daemon            Run a session without a terminal for terminals to attach to.
+  --attach            Show a session in this terminal, starting it if it isn't running.
+  --detach            Detach every terminal attached to a session.
+  --remote            Open files, given as file or file:line, in a running jkl.
+  --remote-wait       Like --remote but wait until their buffers are deleted.
+  --session=<name>    Name of the session, or of the jkl to open files in. Sessions are "default" unless given.
+  --hub=<address>     Run a hub for sharing buffers with ":share", on a host:port or a Unix socket path.
+  --rebuild           Build jkl with the plugins of the manifest and restart the session with it.
+  --source=<dir>      The jkl source to rebuild, $JKL_SOURCE or the current directory unless given.
+  --plugins=<file>    The plugin manifest, plugins in the configuration directory unless given.
 `
 
 // Option is a command line option.
@@ -34,6 +56,13 @@ func OpenFile(filename string) func(*App) error {
 	}
 }
 
+// DiffFiles opens files side by side in diff mode.
+func DiffFiles(filenames []string) func(*App) error {
+	return func(a *App) error {
+		return a.Editor().DiffFiles(filenames)
+	}
+}
+
 // DisplayHelp displays program help and quits.
 func DisplayHelp() func(*App) error {
 	return func(a *App) error {
@@ -52,6 +81,139 @@ func SetUI(ui UI) func(*App) error {
 	}
 }
 
+// UseDriver sets the Apps UI to a TerminalUI using the named ConsoleDriver.
+func UseDriver(name string) func(*App) error {
+	return func(a *App) error {
+		driver, err := NewConsoleDriver(name)
+		if err != nil {
+			return err
+		}
+		ui := NewTerminalUI(driver)
+		a.SetUI(&ui)
+		return nil
+	}
+}
+
+// ServeSession sets the Apps UI to a Server for the named session, see Server.
+// Exits if the session can't listen, ie it is already running.
+func ServeSession(name string) func(*App) error {
+	return func(a *App) error {
+		path, err := SessionSocket(name)
+		if err == nil {
+			server := NewServer(path)
+			if err = server.Listen(); err == nil {
+				a.SetUI(&server)
+				return nil
+			}
+		}
+		fmt.Fprintln(a.ErrOut, err)
+		os.Exit(1)
+		return err
+	}
+}
+
+// AttachToSession shows the named session in the terminal using the named ConsoleDriver and exits once detached.
+func AttachToSession(name, driver string) func(*App) error {
+	return func(a *App) error {
+		console, err := NewConsoleDriver(driver)
+		if err == nil {
+			var reason string
+			if reason, err = AttachSession(name, console); err == nil {
+				fmt.Fprintf(a.Out, "[%s from session %s]\n", reason, name)
+				os.Exit(0)
+			}
+		}
+		fmt.Fprintln(a.ErrOut, err)
+		os.Exit(1)
+		return err
+	}
+}
+
+// DetachClients detaches every terminal attached to the named session and exits.
+func DetachClients(name string) func(*App) error {
+	return func(a *App) error {
+		if err := DetachSession(name); err != nil {
+			fmt.Fprintln(a.ErrOut, err)
+			os.Exit(1)
+			return err
+		}
+		os.Exit(0)
+		return nil
+	}
+}
+
+// RemoteOpenFiles opens files in a running jkl and exits, see RemoteOpen.
+// When none is running they are opened in this App instead, like Vim does.
+func RemoteOpenFiles(session string, files []string, wait bool) func(*App) error {
+	return func(a *App) error {
+		err := RemoteOpen(session, files, wait)
+		if err == nil {
+			os.Exit(0)
+		}
+		if err != ErrNoInstance {
+			fmt.Fprintln(a.ErrOut, err)
+			os.Exit(1)
+			return err
+		}
+
+		for _, file := range files {
+			a.Editor().OpenFileAt(splitFileLine(file))
+		}
+		return nil
+	}
+}
+
+// RunHub runs a CollabServer on the address until interrupted, then exits.
+func RunHub(address string) func(*App) error {
+	return func(a *App) error {
+		hub := NewCollabServer(address)
+		if err := hub.Listen(); err != nil {
+			fmt.Fprintln(a.ErrOut, err)
+			os.Exit(1)
+			return err
+		}
+		fmt.Fprintf(a.Out, "Sharing buffers on %s\n", hub.Addr())
+
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		go hub.Run()
+		<-interrupted
+		hub.Stop()
+		os.Exit(0)
+		return nil
+	}
+}
+
+// ListenForRemote lets "jkl --remote" find the App, see NewControlServer.
+// The socket is named after the process, nothing happens if it can't be created.
+func ListenForRemote() func(*App) error {
+	return func(a *App) error {
+		path, err := SessionSocket(strconv.Itoa(os.Getpid()))
+		if err != nil {
+			return err
+		}
+		server := NewControlServer(path)
+		if err := server.Listen(); err != nil {
+			return err
+		}
+		a.SetRemote(&server)
+		return nil
+	}
+}
+
+// NewConsoleDriver constructs the ConsoleDriver with the given name, "termbox" or "ansi".
+func NewConsoleDriver(name string) (ConsoleDriver, error) {
+	switch name {
+	case "termbox":
+		driver := NewTermboxDriver()
+		return &driver, nil
+	case "ansi":
+		driver := NewANSIDriver(os.Stdin, os.Stdout)
+		return &driver, nil
+	}
+	return nil, fmt.Errorf("Unknown driver: %s", name)
+}
+
 // SetOut sets the Apps output stream.
 func SetOut(out io.Writer) func(*App) error {
 	return func(a *App) error {
@@ -115,7 +277,41 @@ func docoptArgsToOptions(arguments map[string]interface{}) Options {
 		return []Option{DisplayHelp()}
 	}
 
+	session, _ := arguments["--session"].(string)
+	driver, _ := arguments["--driver"].(string)
 	files := arguments["<file>"].([]string)
+
+	if hub, ok := arguments["--hub"].(string); ok {
+		return []Option{RunHub(hub)}
+	}
+	if arguments["--rebuild"].(bool) {
+		source, _ := arguments["--source"].(string)
+		manifest, _ := arguments["--plugins"].(string)
+		return []Option{RebuildJkl(source, manifest, session)}
+	}
+
+	remote, wait := arguments["--remote"].(bool), arguments["--remote-wait"].(bool)
+	if remote || wait {
+		return []Option{RemoteOpenFiles(session, files, wait)}
+	}
+
+	if session == "" {
+		session = DefaultSession
+	}
+	switch {
+	case arguments["--attach"].(bool):
+		return []Option{AttachToSession(session, driver)}
+	case arguments["--detach"].(bool):
+		return []Option{DetachClients(session)}
+	case arguments["--daemon"].(bool):
+		options = append(options, ServeSession(session))
+	case driver != "":
+		options = append(options, UseDriver(driver))
+	}
+
+	if diff, _ := arguments["--diff"].(bool); diff {
+		return append(options, DiffFiles(files))
+	}
 	for _, f := range files {
 		options = append(options, OpenFile(f))
 	}
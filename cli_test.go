@@ -18,3 +18,70 @@ func TestParseArgs(t *testing.T) {
 		So(result, ShouldResemble, Options{})
 	})
 }
+
+func TestParseSessionArgs(t *testing.T) {
+	Convey("--attach only attaches", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--attach", "--session=work"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 1)
+	})
+	Convey("--detach only detaches", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--detach"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 1)
+	})
+	Convey("--daemon serves and opens the files", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--daemon", "one.txt", "two.txt"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 3)
+	})
+	Convey("--attach doesn't take files", t, func() {
+		_, err := ParseArgs([]string{"jkl", "--attach", "one.txt"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseRemoteArgs(t *testing.T) {
+	Convey("--remote opens the files remotely", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--remote", "a.go:3", "b.go"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 1)
+	})
+	Convey("--remote-wait does too", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--remote-wait", "--session=work", "a.go"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 1)
+	})
+	Convey("--remote needs files", t, func() {
+		_, err := ParseArgs([]string{"jkl", "--remote"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseDiffArgs(t *testing.T) {
+	Convey("-d compares the files", t, func() {
+		result, err := ParseArgs([]string{"jkl", "-d", "a.txt", "b.txt", "c.txt"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 2)
+	})
+	Convey("--diff needs two files", t, func() {
+		_, err := ParseArgs([]string{"jkl", "--diff", "a.txt"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("--diff takes three at most", t, func() {
+		_, err := ParseArgs([]string{"jkl", "--diff", "a", "b", "c", "d"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseHubArgs(t *testing.T) {
+	Convey("--hub runs a hub", t, func() {
+		result, err := ParseArgs([]string{"jkl", "--hub=127.0.0.1:4000"})
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 1)
+	})
+	Convey("--hub needs an address", t, func() {
+		_, err := ParseArgs([]string{"jkl", "--hub"})
+		So(err, ShouldNotBeNil)
+	})
+}
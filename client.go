@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// sessionStartTimeout is how long to wait for a new session to start listening.
+const sessionStartTimeout = 2 * time.Second
+
+// Client shows a session of a Server in a terminal and sends it the terminal's input.
+// It keeps a copy of the screen so it can repaint the terminal without asking the server.
+type Client struct {
+	wire    WireConn
+	Console ConsoleDriver
+	Theme   Theme
+	screen  RuneGrid
+	repaint bool
+	mouse   *bool
+}
+
+// NewClient constructs a Client for a connection to a session, see DialSession.
+func NewClient(wire WireConn, console ConsoleDriver) Client {
+	return Client{
+		wire:    wire,
+		Console: console,
+		Theme:   DefaultTheme(),
+	}
+}
+
+// ErrNoSession is returned by DialSession when nothing is listening for the session.
+var ErrNoSession = errors.New("No session")
+
+// DialSession connects to the named session and checks it speaks the same protocol version.
+func DialSession(name string) (WireConn, error) {
+	path, err := SessionSocket(name)
+	if err != nil {
+		return WireConn{}, err
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return WireConn{}, fmt.Errorf("%w %s", ErrNoSession, name)
+	}
+
+	wire := NewWireConn(conn)
+	if err := wire.Write(WireMessage{Type: "hello", Version: ProtocolVersion}); err != nil {
+		wire.Close()
+		return WireConn{}, err
+	}
+
+	reply, err := wire.Read()
+	switch {
+	case err != nil:
+		err = fmt.Errorf("Session %s did not answer: %s", name, err)
+	case reply.Type == "error":
+		err = errors.New(reply.Error)
+	case reply.Type != "hello" || reply.Version != ProtocolVersion:
+		err = fmt.Errorf("Session %s speaks another protocol", name)
+	}
+	if err != nil {
+		wire.Close()
+		return WireConn{}, err
+	}
+	return wire, nil
+}
+
+// StartSession runs the named session in the background, without a terminal, opening the files.
+// It returns once the session is listening.
+func StartSession(name string, files ...string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Cannot start session: %s", err)
+	}
+
+	cmd := exec.Command(executable, append([]string{"--daemon", "--session=" + name}, files...)...)
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Cannot start session: %s", err)
+	}
+	exited := make(chan interface{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(sessionStartTimeout)
+	for time.Now().Before(deadline) {
+		if wire, err := DialSession(name); err == nil {
+			wire.Close()
+			return nil
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("Session %s exited while starting", name)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("Session %s did not start", name)
+}
+
+// AttachSession shows the named session in the terminal, starting it if it isn't running.
+// It returns why it stopped, ie "detached". A session that restarts is attached again.
+func AttachSession(name string, console ConsoleDriver) (string, error) {
+	wire, err := DialSession(name)
+	if err != nil {
+		if err = StartSession(name); err != nil {
+			return "", err
+		}
+		if wire, err = DialSession(name); err != nil {
+			return "", err
+		}
+	}
+	for {
+		client := NewClient(wire, console)
+		reason, err := client.Attach()
+		wire.Close()
+		if err != nil || reason != "restarted" {
+			return reason, err
+		}
+		if wire, err = waitForSession(name); err != nil {
+			return "", err
+		}
+	}
+}
+
+// waitForSession connects to the named session once it is running again after a restart.
+func waitForSession(name string) (WireConn, error) {
+	deadline := time.Now().Add(sessionStartTimeout)
+	for {
+		wire, err := DialSession(name)
+		if err == nil || time.Now().After(deadline) {
+			return wire, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// DetachSession detaches every client attached to the named session.
+func DetachSession(name string) error {
+	wire, err := DialSession(name)
+	if err != nil {
+		return err
+	}
+	defer wire.Close()
+
+	if err := wire.Write(WireMessage{Type: "detach"}); err != nil {
+		return err
+	}
+	reply, err := wire.Read()
+	if err != nil {
+		return err
+	}
+	if reply.Type == "error" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// Attach shows the session until the client is detached or the session ends and returns which happened.
+func (client *Client) Attach() (string, error) {
+	client.Console.Init()
+	defer client.Console.Close()
+
+	width, height := client.Console.Size()
+	if err := client.wire.Write(WireMessage{Type: "attach", Width: width, Height: height}); err != nil {
+		return "", err
+	}
+
+	messages := make(chan WireMessage)
+	failed := make(chan error, 1)
+	done := make(chan interface{})
+	defer close(done)
+	go client.read(messages, failed, done)
+
+	for {
+		select {
+		case event := <-client.Console.Events():
+			if _, ok := event.Data.(ResizeEvent); ok {
+				client.repaint = true
+			}
+			if message, ok := eventMessage(event); ok {
+				if err := client.wire.Write(message); err != nil {
+					return "", fmt.Errorf("Lost connection to session: %s", err)
+				}
+			}
+
+		case message := <-messages:
+			switch message.Type {
+			case "draw":
+				client.draw(message)
+			case "detach":
+				return "detached", nil
+			case "exit":
+				return "exited", nil
+			case "restart":
+				return "restarted", nil
+			case "error":
+				return "", errors.New(message.Error)
+			}
+
+		case err := <-failed:
+			return "", fmt.Errorf("Lost connection to session: %s", err)
+		}
+	}
+}
+
+// read passes messages from the server to Attach until the connection fails or done is closed.
+func (client *Client) read(messages chan WireMessage, failed chan error, done chan interface{}) {
+	for {
+		message, err := client.wire.Read()
+		if err != nil {
+			failed <- err
+			return
+		}
+		select {
+		case messages <- message:
+		case <-done:
+			return
+		}
+	}
+}
+
+// draw applies a draw message to the copy of the screen and the terminal.
+func (client *Client) draw(message WireMessage) {
+	if w, h := client.screen.Size(); message.Full || w != message.Width || h != message.Height {
+		client.screen = NewRuneGrid(message.Width, message.Height)
+		client.repaint = true
+	}
+
+	for _, span := range message.Spans {
+		for i, r := range []rune(span.Text) {
+			client.screen.SetCell(span.X+i, span.Y, r)
+			if i < len(span.Styles) {
+				client.screen.SetStyle(span.X+i, span.Y, span.Styles[i])
+			}
+		}
+	}
+
+	client.updateMouse(message.Mouse)
+	if repainter, ok := client.Console.(Repainter); ok && repainter.NeedsRepaint() {
+		client.repaint = true
+	}
+
+	if client.repaint {
+		client.drawScreen()
+	} else {
+		client.drawSpans(message.Spans)
+	}
+	client.repaint = false
+
+	if message.Cursor != nil {
+		client.Console.SetCursor(message.Cursor.X, message.Cursor.Y)
+	}
+	client.Console.AfterDraw()
+}
+
+// drawScreen sends every cell of the terminal to the console, clearing any part the screen doesn't cover.
+func (client *Client) drawScreen() {
+	cells := client.screen.Cells()
+	width, height := client.Console.Size()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, style := ' ', StyleNormal
+			if client.screen.IsCellValid(x, y) {
+				r, style = cells[y][x], client.screen.Style(x, y)
+			}
+			colors := client.Theme[style]
+			client.Console.SetCell(x, y, r, colors.Fg, colors.Bg)
+		}
+	}
+}
+
+// drawSpans sends the cells of the spans to the console.
+func (client *Client) drawSpans(spans []WireSpan) {
+	for _, span := range spans {
+		for i, r := range []rune(span.Text) {
+			style := StyleNormal
+			if i < len(span.Styles) {
+				style = span.Styles[i]
+			}
+			colors := client.Theme[style]
+			client.Console.SetCell(span.X+i, span.Y, r, colors.Fg, colors.Bg)
+		}
+	}
+}
+
+// updateMouse tells the console whether to report mouse events when the setting changes.
+func (client *Client) updateMouse(enabled bool) {
+	capturer, ok := client.Console.(MouseCapturer)
+	if !ok || client.mouse != nil && *client.mouse == enabled {
+		return
+	}
+	capturer.SetMouse(enabled)
+	client.mouse = &enabled
+}
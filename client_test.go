@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientDraw(t *testing.T) {
+	Convey("A client draws what the server sends", t, func() {
+		fd := NewFakeDriver()
+		fd.SetSize(4, 2)
+		client := NewClient(WireConn{}, &fd)
+
+		client.draw(WireMessage{
+			Type: "draw", Width: 3, Height: 2, Full: true,
+			Spans: []WireSpan{
+				{X: 0, Y: 0, Text: "abc", Styles: []Style{StyleNormal, StyleNormal, StyleNormal}},
+				{X: 0, Y: 1, Text: "def", Styles: []Style{StyleNormal, StyleNormal, StyleNormal}},
+			},
+			Cursor: &WireCursor{X: 1, Y: 1},
+		})
+		So(string(fd.Grid.Cells()[0]), ShouldEqual, "abc ")
+		So(string(fd.Grid.Cells()[1]), ShouldEqual, "def ")
+		So(fd.CellsSet, ShouldEqual, 8)
+		So(fd.CursorX, ShouldEqual, 1)
+		So(fd.CursorY, ShouldEqual, 1)
+
+		Convey("then only the cells that changed", func() {
+			client.draw(WireMessage{
+				Type: "draw", Width: 3, Height: 2,
+				Spans: []WireSpan{{X: 1, Y: 0, Text: "X", Styles: []Style{StyleError}}},
+			})
+			So(string(fd.Grid.Cells()[0]), ShouldEqual, "aXc ")
+			So(fd.CellsSet, ShouldEqual, 9)
+			So(client.screen.Style(1, 0), ShouldEqual, StyleError)
+		})
+
+		Convey("and repaints from its copy of the screen after a resize", func() {
+			client.repaint = true
+			fd.SetSize(4, 2)
+			client.draw(WireMessage{Type: "draw", Width: 3, Height: 2})
+			So(string(fd.Grid.Cells()[1]), ShouldEqual, "def ")
+		})
+	})
+}
+
+func TestAttachSession(t *testing.T) {
+	Convey("Attaching shows the session until detached", t, func() {
+		withSession(func(app *App, server *Server) {
+			fd := NewFakeDriver()
+			result := make(chan string)
+			go func() {
+				reason, _ := AttachSession("test", &fd)
+				result <- reason
+			}()
+
+			waitForAttached(server, 1)
+			So(DetachSession("test"), ShouldBeNil)
+
+			select {
+			case reason := <-result:
+				So(reason, ShouldEqual, "detached")
+			case <-time.After(time.Second):
+				So("attach didn't return", ShouldBeEmpty)
+			}
+			So(fd.Inits, ShouldEqual, 1)
+			So(fd.Closes, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Detaching a session that isn't running is an error", t, func() {
+		withRuntimeDir(func(string) {
+			So(DetachSession("missing"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Dialing a session that isn't running says there's no session", t, func() {
+		withRuntimeDir(func(string) {
+			_, err := DialSession("missing")
+			So(errors.Is(err, ErrNoSession), ShouldBeTrue)
+			So(err.Error(), ShouldEqual, "No session missing")
+		})
+	})
+}
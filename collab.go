@@ -0,0 +1,690 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dcbishop/jkl/service"
+)
+
+// peerStyles is how many peers get a colour of their own, further peers reuse them.
+const peerStyles = 6
+
+// collabQueueLength is how many messages can wait to be written to a peer of a CollabServer, or by a
+// SharedBuffer to the hub. Edits can't be dropped, so one that falls further behind is disconnected.
+const collabQueueLength = 1024
+
+// collabDialTimeout is how long to wait for a CollabServer to answer.
+const collabDialTimeout = 2 * time.Second
+
+// CollabNetwork returns the network of a CollabServer address, "unix" for socket paths and otherwise "tcp".
+func CollabNetwork(address string) string {
+	if strings.Contains(address, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// CollabServer is a hub that editors share buffers through, see ShareBuffer.
+// It gives each editor a site, keeps every edit of each shared document so editors joining
+// later can catch up and passes edits and cursors on to the other editors of the document.
+type CollabServer struct {
+	address  string
+	listener net.Listener
+	state    service.State
+	quit     chan bool
+	writers  sync.WaitGroup
+
+	mutex     sync.Mutex
+	closed    bool
+	lastSite  int
+	documents map[string]*collabDocument
+	peers     map[*collabPeer]bool
+}
+
+// collabDocument is a document shared through a CollabServer.
+type collabDocument struct {
+	history []DocOp
+	cursors map[int]WireMessage
+}
+
+// collabPeer is an editor connected to a CollabServer, messages to it are written by its own goroutine.
+type collabPeer struct {
+	conn     net.Conn
+	out      chan WireMessage
+	site     int
+	document string
+}
+
+// NewCollabServer constructs a CollabServer listening on a TCP address or Unix socket path.
+func NewCollabServer(address string) CollabServer {
+	return CollabServer{
+		address:   address,
+		quit:      make(chan bool),
+		documents: map[string]*collabDocument{},
+		peers:     map[*collabPeer]bool{},
+	}
+}
+
+// Listen starts listening, a TCP port of 0 picks a free port, see Addr.
+func (hub *CollabServer) Listen() error {
+	if hub.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen(CollabNetwork(hub.address), hub.address)
+	if err != nil {
+		return fmt.Errorf("Cannot listen on %s: %s", hub.address, err)
+	}
+	hub.listener = listener
+	return nil
+}
+
+// Addr returns the address editors connect to once listening.
+func (hub *CollabServer) Addr() string {
+	if hub.listener == nil {
+		return hub.address
+	}
+	return hub.listener.Addr().String()
+}
+
+// Run accepts editors until Stop() is called.
+func (hub *CollabServer) Run() {
+	if hub.state.SetRunning() != nil {
+		panic("CollabServer already running.")
+	}
+	defer hub.state.SetStopped()
+
+	hub.mutex.Lock()
+	hub.closed = false
+	hub.mutex.Unlock()
+
+	if hub.Listen() == nil {
+		go hub.accept(hub.listener)
+	}
+
+	<-hub.quit
+	if hub.listener != nil {
+		hub.listener.Close()
+		hub.listener = nil
+	}
+
+	hub.mutex.Lock()
+	hub.closed = true
+	for peer := range hub.peers {
+		peer.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		hub.drop(peer)
+	}
+	hub.mutex.Unlock()
+	hub.writers.Wait()
+}
+
+// Running returns true if Run() was called but Stop() hasn't been.
+func (hub *CollabServer) Running() bool {
+	return hub.state.Running()
+}
+
+// Stop terminates the Run loop.
+func (hub *CollabServer) Stop() {
+	if !hub.Running() {
+		return
+	}
+	hub.quit <- true
+	service.WaitUntilStopped(hub, time.Second)
+}
+
+func (hub *CollabServer) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go hub.serve(conn)
+	}
+}
+
+// serve handles the messages of an editor until it disconnects.
+func (hub *CollabServer) serve(conn net.Conn) {
+	peer := &collabPeer{conn: conn, out: make(chan WireMessage, collabQueueLength)}
+	if !hub.add(peer) {
+		conn.Close()
+		return
+	}
+	defer hub.remove(peer)
+
+	wire := NewWireConn(conn)
+	hello, err := wire.Read()
+	if err != nil || hello.Type != "hello" || hello.Version != ProtocolVersion {
+		hub.reply(peer, WireMessage{Type: "error", Error: "Expected hello"})
+		return
+	}
+	hub.reply(peer, WireMessage{Type: "hello", Version: ProtocolVersion})
+
+	for {
+		message, err := wire.Read()
+		if err != nil || !hub.handle(peer, message) {
+			return
+		}
+	}
+}
+
+// handle acts on a message from an editor, it returns false once the editor is gone.
+func (hub *CollabServer) handle(peer *collabPeer, message WireMessage) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if !hub.peers[peer] {
+		return false
+	}
+
+	if message.Type == "join" {
+		if peer.site != 0 || message.Text == "" {
+			return hub.fail(peer, "Expected one join with a document name")
+		}
+		hub.join(peer, message.Text, message.Content)
+		return true
+	}
+	if peer.site == 0 {
+		return hub.fail(peer, "Not joined")
+	}
+
+	document := hub.documents[peer.document]
+	message.Site = peer.site
+	switch message.Type {
+	case "ops":
+		document.history = append(document.history, message.Ops...)
+	case "cursor":
+		document.cursors[peer.site] = message
+	default:
+		return hub.fail(peer, "Unknown message type: "+message.Type)
+	}
+	hub.broadcast(peer, message)
+	return true
+}
+
+// join adds an editor to a document, creating it with content if it's new.
+// The mutex must be held.
+func (hub *CollabServer) join(peer *collabPeer, name, content string) {
+	document := hub.documents[name]
+	if document == nil {
+		start := NewDocument(0)
+		document = &collabDocument{history: start.Load(content), cursors: map[int]WireMessage{}}
+		hub.documents[name] = document
+	}
+
+	hub.lastSite++
+	peer.site, peer.document = hub.lastSite, name
+	history := append([]DocOp{}, document.history...)
+	hub.send(peer, WireMessage{Type: "joined", Site: peer.site, Ops: history})
+	for _, cursor := range document.cursors {
+		hub.send(peer, cursor)
+	}
+}
+
+// broadcast sends a message to the other editors of the sender's document. The mutex must be held.
+func (hub *CollabServer) broadcast(from *collabPeer, message WireMessage) {
+	for peer := range hub.peers {
+		if peer != from && peer.site != 0 && peer.document == from.document {
+			hub.send(peer, message)
+		}
+	}
+}
+
+// send queues a message to an editor, disconnecting it if it has fallen too far behind.
+// The mutex must be held.
+func (hub *CollabServer) send(peer *collabPeer, message WireMessage) {
+	if !hub.peers[peer] {
+		return
+	}
+	select {
+	case peer.out <- message:
+	default:
+		hub.drop(peer)
+	}
+}
+
+// fail sends an error to an editor and disconnects it, it returns false. The mutex must be held.
+func (hub *CollabServer) fail(peer *collabPeer, err string) bool {
+	hub.send(peer, WireMessage{Type: "error", Error: err})
+	hub.drop(peer)
+	return false
+}
+
+// reply queues a message to an editor.
+func (hub *CollabServer) reply(peer *collabPeer, message WireMessage) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.send(peer, message)
+}
+
+// add starts writing to a new editor, it returns false if the hub is stopping.
+func (hub *CollabServer) add(peer *collabPeer) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if hub.closed {
+		return false
+	}
+	hub.peers[peer] = true
+	hub.writers.Add(1)
+	go func() {
+		defer hub.writers.Done()
+		peer.write()
+	}()
+	return true
+}
+
+// remove forgets an editor whose connection was closed.
+func (hub *CollabServer) remove(peer *collabPeer) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.drop(peer)
+}
+
+// drop disconnects an editor once its queue is written and tells the others it left.
+// The mutex must be held.
+func (hub *CollabServer) drop(peer *collabPeer) {
+	if !hub.peers[peer] {
+		return
+	}
+	delete(hub.peers, peer)
+	close(peer.out)
+
+	if document := hub.documents[peer.document]; document != nil {
+		delete(document.cursors, peer.site)
+		hub.broadcast(peer, WireMessage{Type: "left", Site: peer.site})
+	}
+}
+
+// write sends queued messages until the queue is closed, then closes the connection.
+func (peer *collabPeer) write() {
+	wire := NewWireConn(peer.conn)
+	for message := range peer.out {
+		if wire.Write(message) != nil {
+			break
+		}
+	}
+	peer.conn.Close()
+}
+
+// Peer is another editor sharing a buffer, shown as a cursor and selection in a colour of its own.
+type Peer struct {
+	Site   int
+	anchor CharID
+	mark   *CharID
+}
+
+// CursorStyle returns the Style of the peer's cursor.
+func (peer *Peer) CursorStyle() Style {
+	return StylePeerCursor1 + Style((peer.Site-1)%peerStyles)
+}
+
+// SelectionStyle returns the Style of the peer's selection.
+func (peer *Peer) SelectionStyle() Style {
+	return StylePeerSelection1 + Style((peer.Site-1)%peerStyles)
+}
+
+// SharedBuffer keeps a Buffer in step with the same document in other editors through a CollabServer.
+// Edits are merged by a Document, so everyone ends up with the same text, and each editor undoes only its own.
+type SharedBuffer struct {
+	name   string
+	wire   WireConn
+	out    chan WireMessage
+	doc    Document
+	buffer *Buffer
+	editor *Editor
+	peers  map[int]*Peer
+	sent   WireMessage
+	closed bool
+}
+
+// ShareBuffer shares the current buffer as the named document of the CollabServer at the address.
+// If the document is already shared the buffer is replaced with its text, otherwise the buffer's text starts it.
+// Messages from the hub are handled on the main loop, see Editor.SetPost.
+func (editor *Editor) ShareBuffer(address, name string) error {
+	buffer := editor.CurrentPane().Buffer()
+	switch {
+	case buffer == nil:
+		return errors.New("No buffer to share")
+	case buffer.Terminal() != nil:
+		return errors.New("Cannot share a terminal")
+	case buffer.shared != nil:
+		return fmt.Errorf("Buffer is already shared as %s", buffer.shared.name)
+	}
+	if name == "" {
+		name = buffer.Name()
+	}
+
+	conn, err := net.DialTimeout(CollabNetwork(address), address, collabDialTimeout)
+	if err != nil {
+		return fmt.Errorf("Cannot connect to %s: %s", address, err)
+	}
+	wire := NewWireConn(conn)
+	conn.SetDeadline(time.Now().Add(collabDialTimeout))
+	joined, err := collabJoin(wire, name, string(buffer.data))
+	if err != nil {
+		wire.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	shared := &SharedBuffer{
+		name:   name,
+		wire:   wire,
+		out:    make(chan WireMessage, collabQueueLength),
+		doc:    NewDocument(joined.Site),
+		buffer: buffer,
+		editor: editor,
+		peers:  map[int]*Peer{},
+	}
+	shared.doc.Apply(joined.Ops...)
+	buffer.replace(0, len(buffer.data), shared.doc.Text())
+	buffer.undo = nil
+	buffer.shared = shared
+	for _, pane := range editor.Panes() {
+		if cursor := pane.cursors[buffer]; cursor != nil {
+			cursor.Move(buffer.Position(buffer.Offset(cursor.Position())))
+		}
+	}
+
+	go shared.write()
+	go shared.read()
+	editor.WhenDeleted(buffer, shared.Close)
+	return nil
+}
+
+// collabJoin does the handshake with a CollabServer and joins the document.
+func collabJoin(wire WireConn, name, content string) (WireMessage, error) {
+	messages := []WireMessage{
+		{Type: "hello", Version: ProtocolVersion},
+		{Type: "join", Text: name, Content: content},
+	}
+	for _, message := range messages {
+		if err := wire.Write(message); err != nil {
+			return WireMessage{}, err
+		}
+	}
+
+	for _, expected := range []string{"hello", "joined"} {
+		reply, err := wire.Read()
+		switch {
+		case err != nil:
+			return reply, fmt.Errorf("Sharing %s failed: %s", name, err)
+		case reply.Type == "error":
+			return reply, errors.New(reply.Error)
+		case reply.Type != expected:
+			return reply, fmt.Errorf("Sharing %s failed: unexpected %s", name, reply.Type)
+		}
+		if expected == "joined" {
+			return reply, nil
+		}
+	}
+	return WireMessage{}, nil
+}
+
+// Shared returns the SharedBuffer keeping the buffer in step with other editors, nil if it isn't shared.
+func (buffer *Buffer) Shared() *SharedBuffer {
+	if buffer == nil {
+		return nil
+	}
+	return buffer.shared
+}
+
+// Name returns the name of the shared document.
+func (shared *SharedBuffer) Name() string {
+	return shared.name
+}
+
+// Site returns the site of this editor in the shared document.
+func (shared *SharedBuffer) Site() int {
+	return shared.doc.Site()
+}
+
+// Peers returns the other editors sharing the buffer that have shown their cursor.
+func (shared *SharedBuffer) Peers() []*Peer {
+	sites := make([]int, 0, len(shared.peers))
+	for site := range shared.peers {
+		sites = append(sites, site)
+	}
+	sort.Ints(sites)
+
+	peers := make([]*Peer, len(sites))
+	for i, site := range sites {
+		peers[i] = shared.peers[site]
+	}
+	return peers
+}
+
+// Close stops sharing the buffer, it keeps its text.
+func (shared *SharedBuffer) Close() {
+	if shared.closed {
+		return
+	}
+	shared.closed = true
+	shared.buffer.shared = nil
+	close(shared.out)
+}
+
+// edit turns a change to data[start:end] of the buffer into operations for the other editors.
+func (shared *SharedBuffer) edit(start, end int, text string, join bool) {
+	data := shared.buffer.data
+	pos, count := utf8.RuneCount(data[:start]), utf8.RuneCount(data[start:end])
+	shared.send(WireMessage{Type: "ops", Ops: shared.doc.Edit(pos, count, text, join)})
+}
+
+// undo reverts this editor's last change, see Buffer.Undo.
+func (shared *SharedBuffer) undo() (int, bool) {
+	ops, ok := shared.doc.Undo()
+	if !ok {
+		return 0, false
+	}
+	shared.send(WireMessage{Type: "ops", Ops: ops})
+
+	text := shared.doc.Text()
+	shared.buffer.replace(0, len(shared.buffer.data), text)
+	shared.buffer.modified = true
+	return runeOffset(text, shared.doc.Position(ops[len(ops)-1].Ref)), true
+}
+
+// update runs a change to the document from elsewhere and puts its text in the buffer.
+// The cursors of the panes showing the buffer stay on the same characters.
+func (shared *SharedBuffer) update(change func()) {
+	anchors := map[*Cursor]CharID{}
+	for _, pane := range shared.editor.Panes() {
+		if cursor := pane.cursors[shared.buffer]; cursor != nil {
+			anchors[cursor] = shared.doc.Anchor(shared.cursorPosition(cursor))
+		}
+	}
+
+	change()
+	text := shared.doc.Text()
+	shared.buffer.replace(0, len(shared.buffer.data), text)
+
+	for cursor, anchor := range anchors {
+		cursor.Move(shared.buffer.Position(runeOffset(text, shared.doc.Position(anchor))))
+	}
+}
+
+// cursorPosition returns the position in the document of a cursor.
+func (shared *SharedBuffer) cursorPosition(cursor *Cursor) int {
+	return utf8.RuneCount(shared.buffer.data[:shared.buffer.Offset(cursor.Position())])
+}
+
+// receive handles a message from the hub on the main loop.
+func (shared *SharedBuffer) receive(message WireMessage) {
+	if shared.closed {
+		return
+	}
+
+	switch message.Type {
+	case "ops":
+		shared.update(func() { shared.doc.Apply(message.Ops...) })
+		shared.buffer.modified = true
+	case "cursor":
+		peer := &Peer{Site: message.Site, mark: message.Mark}
+		if message.Anchor != nil {
+			peer.anchor = *message.Anchor
+		}
+		shared.peers[message.Site] = peer
+	case "left":
+		delete(shared.peers, message.Site)
+	case "error":
+		shared.editor.EchoError(fmt.Errorf("Sharing %s: %s", shared.name, message.Error))
+	}
+}
+
+// ShareCursors tells the other editors of shared buffers where the cursor and selection are, if they moved.
+// The first pane showing a buffer is used, unless it is the current buffer.
+func (editor *Editor) ShareCursors() {
+	for _, buffer := range editor.buffers {
+		shared := buffer.shared
+		if shared == nil {
+			continue
+		}
+
+		pane := editor.CurrentPane()
+		if pane.Buffer() != buffer {
+			pane = nil
+			for _, other := range editor.panes {
+				if other.Buffer() == buffer {
+					pane = other
+					break
+				}
+			}
+		}
+		if pane == nil {
+			continue
+		}
+
+		anchor := shared.doc.Anchor(shared.cursorPosition(pane.Cursor()))
+		message := WireMessage{Type: "cursor", Anchor: &anchor}
+		if editor.Mode() == VisualMode && pane == editor.CurrentPane() {
+			mark := shared.doc.Anchor(shared.cursorPosition(&pane.visual))
+			message.Mark = &mark
+		}
+		if sameCursor(message, shared.sent) {
+			continue
+		}
+		shared.sent = message
+		shared.send(message)
+	}
+}
+
+// sameCursor returns true if two cursor messages put the cursor and selection in the same place.
+func sameCursor(a, b WireMessage) bool {
+	same := func(x, y *CharID) bool {
+		return x == nil && y == nil || x != nil && y != nil && *x == *y
+	}
+	return a.Type == b.Type && same(a.Anchor, b.Anchor) && same(a.Mark, b.Mark)
+}
+
+// send queues a message to the hub. If the hub has fallen too far behind to take it the buffer stops
+// being shared, rather than the editor waiting for it.
+func (shared *SharedBuffer) send(message WireMessage) {
+	if shared.closed {
+		return
+	}
+	select {
+	case shared.out <- message:
+	default:
+		shared.Close()
+		shared.wire.Close()
+		shared.editor.EchoError(fmt.Errorf("Stopped sharing %s: the hub isn't keeping up", shared.name))
+	}
+}
+
+// write sends queued messages to the hub until the buffer stops being shared.
+func (shared *SharedBuffer) write() {
+	for message := range shared.out {
+		if shared.wire.Write(message) != nil {
+			break
+		}
+	}
+	shared.wire.Close()
+}
+
+// read passes messages from the hub to the main loop until the connection is closed.
+func (shared *SharedBuffer) read() {
+	for {
+		message, err := shared.wire.Read()
+		if err != nil {
+			shared.editor.Post(func() {
+				if !shared.closed {
+					shared.Close()
+					shared.editor.EchoError(fmt.Errorf("Stopped sharing %s: %s", shared.name, err))
+				}
+			})
+			return
+		}
+		shared.editor.Post(func() { shared.receive(message) })
+	}
+}
+
+// RenderPeers shows the cursors and selections of the other editors sharing the Pane's buffer where it was drawn.
+func (grid *RuneGrid) RenderPeers(settings *Settings, pane *Pane) {
+	shared := pane.Buffer().Shared()
+	if shared == nil {
+		return
+	}
+
+	text := string(shared.buffer.data)
+	position := func(anchor CharID) (int, int) {
+		return shared.buffer.Position(runeOffset(text, shared.doc.Position(anchor)))
+	}
+	for _, peer := range shared.Peers() {
+		x, line := position(peer.anchor)
+		if peer.mark != nil {
+			markX, markLine := position(*peer.mark)
+			startX, startLine, endX, endLine := markX, markLine, x, line
+			if line < markLine || line == markLine && x < markX {
+				startX, startLine, endX, endLine = x, line, markX, markLine
+			}
+			grid.RenderRange(settings, pane, startX, startLine, endX, endLine, peer.SelectionStyle())
+		}
+		grid.RenderRange(settings, pane, x, line, x, line, peer.CursorStyle())
+	}
+}
+
+// runeOffset returns the index in text of the character at the position, or the length of text past its end.
+func runeOffset(text string, pos int) int {
+	for i := range text {
+		if pos == 0 {
+			return i
+		}
+		pos--
+	}
+	return len(text)
+}
+
+// shareCommand shares the current buffer through the CollabServer at an address, "share <address> [name]".
+func shareCommand(editor *Editor, command Command) error {
+	args := strings.Fields(command.Args)
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Usage: share <address> [name]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	if err := editor.ShareBuffer(args[0], name); err != nil {
+		return err
+	}
+	shared := editor.CurrentPane().Buffer().Shared()
+	editor.Echo(fmt.Sprintf("Sharing %s as site %d", shared.Name(), shared.Site()))
+	return nil
+}
+
+// unshareCommand stops sharing the current buffer.
+func unshareCommand(editor *Editor, command Command) error {
+	shared := editor.CurrentPane().Buffer().Shared()
+	if shared == nil {
+		return errors.New("Buffer is not shared")
+	}
+	shared.Close()
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dcbishop/jkl/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// withHub runs a CollabServer on the address for the duration of f.
+func withHub(address string, f func(hub *CollabServer)) {
+	hub := NewCollabServer(address)
+	So(hub.Listen(), ShouldBeNil)
+	go hub.Run()
+	So(service.WaitUntilRunning(&hub, time.Second), ShouldBeNil)
+	defer hub.Stop()
+	f(&hub)
+}
+
+// collabEditor is an editor showing a buffer, with a main loop the test runs by hand.
+type collabEditor struct {
+	editor *Editor
+	buffer *Buffer
+	work   chan func()
+}
+
+func newCollabEditor(text string) *collabEditor {
+	editor := NewEditor(GetTestFs())
+	buffer := editor.AddBuffer(bufferWithText(text))
+	editor.SwitchToBuffer(buffer)
+	editor.Settings().Borders = false
+	editor.Settings().StatusLine = ""
+	work := make(chan func(), 1024)
+	editor.SetPost(func(f func()) { work <- f })
+	return &collabEditor{editor: &editor, buffer: buffer, work: work}
+}
+
+// settle runs the main loops of the editors until done returns true.
+func settle(done func() bool, editors ...*collabEditor) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		ran := false
+		for _, e := range editors {
+			select {
+			case f := <-e.work:
+				f()
+				ran = true
+			default:
+			}
+		}
+		if !ran {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return true
+}
+
+// sameText returns a check that every editor has the same text.
+func sameText(editors ...*collabEditor) func() bool {
+	return func() bool {
+		for _, e := range editors[1:] {
+			if string(e.buffer.data) != string(editors[0].buffer.data) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestCollabServer(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		Convey("Editors sharing a buffer through a hub over "+network, t, func() {
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				dir, err := os.MkdirTemp("", "jkl-collab")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(dir)
+				address = filepath.Join(dir, "hub.sock")
+			}
+
+			withHub(address, func(hub *CollabServer) {
+				alice := newCollabEditor("hello\nworld\n")
+				bob := newCollabEditor("ignored")
+				So(alice.editor.ShareBuffer(hub.Addr(), "notes"), ShouldBeNil)
+				So(bob.editor.ShareBuffer(hub.Addr(), "notes"), ShouldBeNil)
+				So(alice.buffer.Shared().Site(), ShouldEqual, 1)
+				So(bob.buffer.Shared().Site(), ShouldEqual, 2)
+
+				Convey("the document starts with the text of the first", func() {
+					So(string(bob.buffer.data), ShouldEqual, "hello\nworld\n")
+					So(bob.editor.ShareBuffer(hub.Addr(), "notes"), ShouldNotBeNil)
+				})
+
+				Convey("edits typed at once are merged", func() {
+					alice.editor.CurrentPane().Cursor().Move(5, 1)
+					bob.editor.CurrentPane().Cursor().Move(0, 2)
+					alice.editor.EnterInsertMode(false)
+					bob.editor.EnterInsertMode(false)
+					typeInsert(alice.editor, ", there")
+					typeInsert(bob.editor, "big ")
+
+					So(settle(sameText(alice, bob), alice, bob), ShouldBeTrue)
+					So(string(alice.buffer.data), ShouldEqual, "hello, there\nbig world\n")
+
+					Convey("cursors stay on their characters", func() {
+						x, line := alice.editor.CurrentPane().Cursor().Position()
+						So([]int{x, line}, ShouldResemble, []int{12, 1})
+						x, line = bob.editor.CurrentPane().Cursor().Position()
+						So([]int{x, line}, ShouldResemble, []int{4, 2})
+					})
+
+					Convey("each undoes only their own edits", func() {
+						So(bob.editor.Undo(), ShouldBeNil)
+						So(settle(sameText(alice, bob), alice, bob), ShouldBeTrue)
+						So(string(alice.buffer.data), ShouldEqual, "hello, there\nworld\n")
+						So(bob.editor.Undo(), ShouldNotBeNil)
+
+						So(alice.editor.Undo(), ShouldBeNil)
+						So(settle(sameText(alice, bob), alice, bob), ShouldBeTrue)
+						So(string(bob.buffer.data), ShouldEqual, "hello\nworld\n")
+					})
+				})
+
+				Convey("cursors and selections are shown in the colour of their site", func() {
+					carol := newCollabEditor("")
+					So(carol.editor.ShareBuffer(hub.Addr(), "notes"), ShouldBeNil)
+					carol.editor.CurrentPane().Cursor().Move(3, 2)
+					carol.editor.ShareCursors()
+					bob.editor.CurrentPane().Cursor().Move(1, 1)
+					bob.editor.EnterVisualMode()
+					bob.editor.CurrentPane().Cursor().Move(3, 1)
+					bob.editor.ShareCursors()
+
+					So(settle(func() bool { return len(alice.buffer.Shared().Peers()) == 2 }, alice, bob, carol), ShouldBeTrue)
+					grid := NewRuneGrid(20, 4)
+					grid.RenderEditor(alice.editor)
+					styles := grid.Styles()
+					So(styles[0][0], ShouldEqual, StyleNormal)
+					So(styles[0][1], ShouldEqual, StylePeerSelection2)
+					So(styles[0][2], ShouldEqual, StylePeerSelection2)
+					So(styles[0][3], ShouldEqual, StylePeerCursor2)
+					So(styles[0][4], ShouldEqual, StyleNormal)
+					So(styles[1][3], ShouldEqual, StylePeerCursor3)
+
+					Convey("and follow the text as it changes", func() {
+						alice.editor.CurrentPane().Cursor().Move(0, 2)
+						alice.editor.InsertText(">> ")
+						grid.Clear()
+						grid.RenderEditor(alice.editor)
+						So(grid.Styles()[1][6], ShouldEqual, StylePeerCursor3)
+					})
+
+					Convey("until they leave", func() {
+						carol.buffer.Shared().Close()
+						So(settle(func() bool { return len(alice.buffer.Shared().Peers()) == 1 }, alice, bob), ShouldBeTrue)
+					})
+
+					Convey("editors joining later see them", func() {
+						dave := newCollabEditor("")
+						So(dave.editor.ShareBuffer(hub.Addr(), "notes"), ShouldBeNil)
+						So(settle(func() bool { return len(dave.buffer.Shared().Peers()) == 2 }, dave), ShouldBeTrue)
+					})
+				})
+
+				Convey("deleting the buffer stops sharing it", func() {
+					So(alice.editor.DeleteBuffer(alice.buffer, true), ShouldBeNil)
+					So(alice.buffer.Shared(), ShouldBeNil)
+				})
+			})
+		})
+	}
+}
+
+func TestCollabStalledHub(t *testing.T) {
+	Convey("A shared buffer whose hub stops reading", t, func() {
+		client, hub := net.Pipe()
+		defer hub.Close()
+		editor := NewEditor(GetTestFs())
+		buffer := editor.AddBuffer(bufferWithText("a\n"))
+		editor.SwitchToBuffer(buffer)
+		shared := &SharedBuffer{
+			name: "notes", wire: NewWireConn(client), out: make(chan WireMessage, collabQueueLength),
+			doc: NewDocument(1), buffer: buffer, editor: &editor, peers: map[int]*Peer{},
+		}
+		shared.doc.Load("a\n")
+		buffer.shared = shared
+		go shared.write()
+
+		Convey("stops being shared instead of freezing the editor", func() {
+			done := make(chan bool)
+			go func() {
+				for i := 0; i < collabQueueLength+10; i++ {
+					buffer.InsertLines(0, []string{"x"})
+				}
+				done <- true
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Editing froze")
+			}
+			So(buffer.Shared(), ShouldBeNil)
+			So(buffer.LineCount(), ShouldEqual, collabQueueLength+11)
+			So(editor.Message().Text, ShouldEqual, "Stopped sharing notes: the hub isn't keeping up")
+		})
+	})
+}
+
+func TestCollabConvergence(t *testing.T) {
+	Convey("Editors typing random edits at once through a hub converge", t, func() {
+		withHub("127.0.0.1:0", func(hub *CollabServer) {
+			random := rand.New(rand.NewSource(1))
+			editors := []*collabEditor{}
+			for i := 0; i < 3; i++ {
+				e := newCollabEditor("one\ntwo\nthree\n")
+				So(e.editor.ShareBuffer(hub.Addr(), "random"), ShouldBeNil)
+				editors = append(editors, e)
+			}
+
+			for step := 0; step < 300; step++ {
+				e := editors[random.Intn(len(editors))]
+				buffer, cursor := e.buffer, e.editor.CurrentPane().Cursor()
+				cursor.Move(buffer.Position(random.Intn(len(buffer.data) + 1)))
+				switch random.Intn(6) {
+				case 0:
+					e.editor.DeleteBackward()
+				case 1:
+					e.editor.Undo()
+				case 2:
+					e.editor.InsertText("\n")
+				default:
+					e.editor.InsertText(string(rune('a' + random.Intn(26))))
+				}
+
+				// Let a random editor catch up with some of what the others did.
+				other := editors[random.Intn(len(editors))]
+				for n := random.Intn(4); n > 0; n-- {
+					select {
+					case f := <-other.work:
+						f()
+					default:
+					}
+				}
+			}
+
+			// The text is only final once nothing arrives for a while.
+			quiet := time.Now()
+			So(settle(func() bool {
+				for _, e := range editors {
+					if len(e.work) > 0 {
+						quiet = time.Now()
+					}
+				}
+				return time.Since(quiet) > 100*time.Millisecond && sameText(editors...)()
+			}, editors...), ShouldBeTrue)
+			So(string(editors[0].buffer.data), ShouldEqual, editors[0].buffer.Shared().doc.Text())
+		})
+	})
+}
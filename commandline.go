@@ -0,0 +1,112 @@
+package main
+
+// commandHistoryLength is how many of the commands typed are remembered.
+const commandHistoryLength = 100
+
+// CommandLine holds the text being typed while in CommandMode and the commands typed before.
+type CommandLine struct {
+	text        []rune
+	completions []string
+	completion  int
+	history     []string
+}
+
+// Text returns the typed command without the leading ':'.
+func (cl *CommandLine) Text() string {
+	return string(cl.text)
+}
+
+// SetText replaces the typed command.
+func (cl *CommandLine) SetText(text string) {
+	cl.text = []rune(text)
+	cl.completions = nil
+}
+
+// Insert adds a character to the end of the command.
+func (cl *CommandLine) Insert(r rune) {
+	cl.text = append(cl.text, r)
+	cl.completions = nil
+}
+
+// Backspace removes the last character, returns false if the command was already empty.
+func (cl *CommandLine) Backspace() bool {
+	cl.completions = nil
+	if len(cl.text) == 0 {
+		return false
+	}
+	cl.text = cl.text[:len(cl.text)-1]
+	return true
+}
+
+// Clear empties the command.
+func (cl *CommandLine) Clear() {
+	cl.SetText("")
+}
+
+// AddHistory remembers a command that was typed, moving it to the end if it was typed before.
+func (cl *CommandLine) AddHistory(line string) {
+	for i, old := range cl.history {
+		if old == line {
+			cl.history = append(cl.history[:i], cl.history[i+1:]...)
+			break
+		}
+	}
+	cl.history = append(cl.history, line)
+	if len(cl.history) > commandHistoryLength {
+		cl.history = cl.history[len(cl.history)-commandHistoryLength:]
+	}
+}
+
+// History returns the commands typed, oldest first.
+func (cl *CommandLine) History() []string {
+	return cl.history
+}
+
+// CommandLine returns the command line being typed.
+func (editor *Editor) CommandLine() *CommandLine {
+	return &editor.commandLine
+}
+
+// EnterCommandMode starts typing a new ex command.
+func (editor *Editor) EnterCommandMode() {
+	editor.commandLine.Clear()
+	editor.ClearMessage()
+	editor.SetMode(CommandMode)
+}
+
+// LeaveCommandMode abandons the command being typed.
+func (editor *Editor) LeaveCommandMode() {
+	editor.commandLine.Clear()
+	editor.SetMode(NormalMode)
+}
+
+// ExecuteCommandLine runs the typed command and returns to NormalMode.
+func (editor *Editor) ExecuteCommandLine() error {
+	line := editor.commandLine.Text()
+	editor.LeaveCommandMode()
+	if line == "" {
+		return nil
+	}
+	editor.commandLine.AddHistory(line)
+	return editor.ExecuteCommand(line)
+}
+
+// CompleteCommandLine replaces the typed command with the next possible completion.
+// Repeated calls cycle through the completions.
+func (editor *Editor) CompleteCommandLine() {
+	cl := &editor.commandLine
+
+	if cl.completions == nil {
+		completions := editor.CompleteCommand(cl.Text())
+		if len(completions) == 0 {
+			return
+		}
+		cl.text = []rune(completions[0])
+		cl.completions = completions
+		cl.completion = 0
+		return
+	}
+
+	cl.completion = (cl.completion + 1) % len(cl.completions)
+	cl.text = []rune(cl.completions[cl.completion])
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CommandFunc runs an ex command.
+type CommandFunc func(editor *Editor, command Command) error
+
+// CompleteFunc returns the possible completions of a partially typed command argument.
+type CompleteFunc func(editor *Editor, arg string) []string
+
+// CommandDefinition describes an ex command.
+// Name uses Vim's notation where the optional part is in brackets, ie "bd[elete]".
+type CommandDefinition struct {
+	Name     string
+	Run      CommandFunc
+	Complete CompleteFunc
+}
+
+// FullName returns the name of the command without abbreviation brackets.
+func (def *CommandDefinition) FullName() string {
+	return strings.NewReplacer("[", "", "]", "").Replace(def.Name)
+}
+
+// Matches returns true if name is the command or a valid abbreviation of it.
+func (def *CommandDefinition) Matches(name string) bool {
+	required := def.Name
+	if i := strings.Index(def.Name, "["); i != -1 {
+		required = def.Name[:i]
+	}
+
+	return len(name) >= len(required) && strings.HasPrefix(def.FullName(), name)
+}
+
+// Command is a parsed ex command line.
+// Range is the unparsed line range before the name, ie "1,$" or ".+2", see Editor.CommandRange.
+type Command struct {
+	Range string
+	Name  string
+	Bang  bool
+	Args  string
+}
+
+// ParseCommand splits a command line into the range, command name, '!' and arguments.
+func ParseCommand(line string) (Command, error) {
+	line = strings.TrimLeft(line, ": \t")
+
+	rangeEnd := strings.IndexFunc(line, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.,$%+-", r)
+	})
+	if rangeEnd == -1 {
+		rangeEnd = len(line)
+	}
+	command := Command{Range: line[:rangeEnd]}
+	line = line[rangeEnd:]
+
+	end := strings.IndexFunc(line, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if end == -1 {
+		end = len(line)
+	}
+
+	command.Name = line[:end]
+	if command.Name == "" && strings.HasPrefix(line, "!") {
+		command.Name = "!"
+		end = 1
+	}
+	if command.Name == "" && (command.Range == "" || strings.TrimSpace(line) != "") {
+		return command, errors.New("Not an editor command: " + command.Range + line)
+	}
+
+	rest := line[end:]
+	if command.Name != "!" && strings.HasPrefix(rest, "!") {
+		command.Bang = true
+		rest = rest[1:]
+	}
+	command.Args = strings.TrimSpace(rest)
+
+	return command, nil
+}
+
+// RegisterCommand adds an ex command to the editor.
+// Commands registered earlier take priority when an abbreviation is ambiguous.
+func (editor *Editor) RegisterCommand(def CommandDefinition) {
+	editor.commands = append(editor.commands, &def)
+}
+
+// LookupCommand finds the command matching the given name or abbreviation.
+func (editor *Editor) LookupCommand(name string) *CommandDefinition {
+	for _, def := range editor.commands {
+		if def.FullName() == name {
+			return def
+		}
+	}
+
+	for _, def := range editor.commands {
+		if def.Matches(name) {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// ExecuteCommand parses and runs an ex command line.
+func (editor *Editor) ExecuteCommand(line string) error {
+	command, err := ParseCommand(line)
+	if err != nil {
+		return err
+	}
+
+	if command.Name == "" {
+		return gotoLineCommand(editor, command)
+	}
+
+	def := editor.LookupCommand(command.Name)
+	if def == nil {
+		return fmt.Errorf("Not an editor command: %s", strings.TrimLeft(line, ": \t"))
+	}
+
+	return def.Run(editor, command)
+}
+
+// CompleteCommand returns the possible completions of a partially typed command line.
+func (editor *Editor) CompleteCommand(line string) []string {
+	line = strings.TrimLeft(line, ": \t")
+	completions := []string{}
+
+	space := strings.IndexAny(line, " \t")
+	if space == -1 {
+		for _, def := range editor.commands {
+			if strings.HasPrefix(def.FullName(), line) {
+				completions = append(completions, def.FullName())
+			}
+		}
+		return completions
+	}
+
+	command, err := ParseCommand(line)
+	if err != nil {
+		return completions
+	}
+
+	def := editor.LookupCommand(command.Name)
+	if def == nil || def.Complete == nil {
+		return completions
+	}
+
+	for _, arg := range def.Complete(editor, command.Args) {
+		completions = append(completions, line[:space+1]+arg)
+	}
+	return completions
+}
+
+// CommandRange resolves the range of a command to line numbers.
+// Without a range the current line is used. Addresses are a line number, '.' for the current line
+// or '$' for the last line, followed by any number of +N or -N offsets. '%' is the whole buffer.
+func (editor *Editor) CommandRange(command Command) (first, last int, err error) {
+	buffer := editor.CurrentPane().Buffer()
+	cursor := editor.CurrentPane().Cursor()
+	if buffer == nil || cursor == nil {
+		return 0, 0, errors.New("No buffer")
+	}
+
+	_, current := cursor.Position()
+	lineCount := buffer.LineCount()
+
+	if command.Range == "%" {
+		return 1, lineCount, nil
+	}
+	if command.Range == "" {
+		return current, current, nil
+	}
+
+	addresses := strings.Split(command.Range, ",")
+	if len(addresses) > 2 {
+		return 0, 0, errors.New("Invalid range: " + command.Range)
+	}
+
+	lines := []int{}
+	for _, address := range addresses {
+		line, err := resolveAddress(address, current, lineCount)
+		if err != nil {
+			return 0, 0, err
+		}
+		lines = append(lines, line)
+	}
+
+	first, last = lines[0], lines[len(lines)-1]
+	if first > last {
+		return 0, 0, errors.New("Backwards range given")
+	}
+	if first < 1 || last > lineCount {
+		return 0, 0, errors.New("Invalid range: " + command.Range)
+	}
+	return first, last, nil
+}
+
+// resolveAddress converts a single line address to a line number.
+func resolveAddress(address string, current, lineCount int) (int, error) {
+	line := current
+	i := 0
+
+	switch {
+	case address == "":
+		return current, nil
+	case address[0] == '.':
+		i = 1
+	case address[0] == '$':
+		line = lineCount
+		i = 1
+	case address[0] >= '0' && address[0] <= '9':
+		for i < len(address) && address[i] >= '0' && address[i] <= '9' {
+			i++
+		}
+		line, _ = strconv.Atoi(address[:i])
+	}
+
+	for i < len(address) {
+		sign := 1
+		switch address[i] {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return 0, errors.New("Invalid address: " + address)
+		}
+		i++
+
+		start := i
+		for i < len(address) && address[i] >= '0' && address[i] <= '9' {
+			i++
+		}
+
+		offset := 1
+		if i > start {
+			offset, _ = strconv.Atoi(address[start:i])
+		}
+		line += sign * offset
+	}
+
+	return line, nil
+}
+
+// gotoLineCommand moves the cursor to the last line of the range, ie ":42".
+func gotoLineCommand(editor *Editor, command Command) error {
+	_, last, err := editor.CommandRange(command)
+	if err != nil {
+		return err
+	}
+	editor.CurrentPane().Cursor().Move(0, last)
+	return nil
+}
+
+func (editor *Editor) registerDefaultCommands() {
+	for _, name := range []string{"ls", "buffers", "files"} {
+		editor.RegisterCommand(CommandDefinition{Name: name, Run: listBuffersCommand})
+	}
+	editor.RegisterCommand(CommandDefinition{Name: "b[uffer]", Run: bufferCommand, Complete: completeBufferName})
+	editor.RegisterCommand(CommandDefinition{Name: "w[rite]", Run: writeCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "bd[elete]", Run: deleteBufferCommand, Complete: completeBufferName})
+	editor.RegisterCommand(CommandDefinition{Name: "bw[ipeout]", Run: wipeBufferCommand, Complete: completeBufferName})
+	editor.RegisterCommand(CommandDefinition{Name: "mes[sages]", Run: messagesCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "se[t]", Run: setCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "setl[ocal]", Run: setLocalCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "fo[ld]", Run: foldCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "foldo[pen]", Run: foldOpenCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "foldc[lose]", Run: foldCloseCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "sp[lit]", Run: splitCommand, Complete: completeBufferName})
+	editor.RegisterCommand(CommandDefinition{Name: "vs[plit]", Run: verticalSplitCommand, Complete: completeBufferName})
+	editor.RegisterCommand(CommandDefinition{Name: "clo[se]", Run: closeCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "on[ly]", Run: onlyCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "!", Run: bangCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "r[ead]", Run: readCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "sus[pend]", Run: suspendCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "st[op]", Run: suspendCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "ter[minal]", Run: terminalCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "det[ach]", Run: detachCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "sh[are]", Run: shareCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "unsh[are]", Run: unshareCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "plug[in]", Run: pluginCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "au[tocmd]", Run: autocmdCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "lua", Run: luaCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "luaf[ile]", Run: luaFileCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "lsp", Run: lspCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "git", Run: gitCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "bl[ame]", Run: blameCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "difft[his]", Run: diffThisCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "diffo[ff]", Run: diffOffCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "diffu[pdate]", Run: diffUpdateCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "diffg[et]", Run: diffGetCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "diffpu[t]", Run: diffPutCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "conf[lict]", Run: conflictCommand})
+	editor.RegisterCommand(CommandDefinition{Name: "pi[ck]", Run: pickCommand, Complete: completePickerSource})
+	editor.RegisterCommand(CommandDefinition{Name: "h[elp]", Run: helpCommand, Complete: completeHelpTopic})
+}
+
+func listBuffersCommand(editor *Editor, command Command) error {
+	editor.Echo(strings.Join(editor.BufferList(command.Bang), "\n"))
+	return nil
+}
+
+func bufferCommand(editor *Editor, command Command) error {
+	if command.Args == "" {
+		return nil
+	}
+
+	buffer, err := editor.FindBuffer(command.Args)
+	if err != nil {
+		return err
+	}
+
+	editor.SwitchToBuffer(buffer)
+	return nil
+}
+
+// writeCommand writes the current buffer to its file, or the file given, ie ":w notes.txt".
+// Buffers that still have merge conflicts are only written with '!'.
+func writeCommand(editor *Editor, command Command) error {
+	buffer := editor.CurrentPane().Buffer()
+	if buffer == nil {
+		return errors.New("No buffer")
+	}
+	if n := len(editor.Conflicts(buffer)); n > 0 && !command.Bang {
+		return fmt.Errorf("%d merge conflicts left in buffer %d (add ! to write anyway)", n, buffer.ID())
+	}
+	filename := strings.TrimSpace(command.Args)
+	if err := editor.WriteBuffer(buffer, filename); err != nil {
+		return err
+	}
+	if filename == "" {
+		filename = buffer.Filename()
+	}
+	editor.Echo(fmt.Sprintf("%q %dL, %dB written", filename, buffer.LineCount(), len(buffer.data)))
+	return nil
+}
+
+func deleteBufferCommand(editor *Editor, command Command) error {
+	buffer, err := commandBuffer(editor, command)
+	if err != nil {
+		return err
+	}
+	return editor.DeleteBuffer(buffer, command.Bang)
+}
+
+func wipeBufferCommand(editor *Editor, command Command) error {
+	buffer, err := commandBuffer(editor, command)
+	if err != nil {
+		return err
+	}
+	return editor.WipeBuffer(buffer, command.Bang)
+}
+
+// commandBuffer returns the buffer named in the command arguments or the current buffer.
+func commandBuffer(editor *Editor, command Command) (*Buffer, error) {
+	if command.Args != "" {
+		return editor.FindBuffer(command.Args)
+	}
+
+	if buffer := editor.CurrentPane().Buffer(); buffer != nil {
+		return buffer, nil
+	}
+	return nil, errors.New("No buffer")
+}
+
+func completeBufferName(editor *Editor, arg string) []string {
+	names := []string{}
+	for _, buffer := range editor.MatchBuffers(arg) {
+		names = append(names, buffer.Name())
+	}
+	return names
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseCommand(t *testing.T) {
+	Convey("ParseCommand", t, func() {
+		Convey("splits name, bang and arguments", func() {
+			command, err := ParseCommand(":bd! 2 ")
+			So(err, ShouldBeNil)
+			So(command, ShouldResemble, Command{Name: "bd", Bang: true, Args: "2"})
+		})
+
+		Convey("handles arguments directly after the name", func() {
+			command, err := ParseCommand("b2")
+			So(err, ShouldBeNil)
+			So(command, ShouldResemble, Command{Name: "b", Args: "2"})
+		})
+
+		Convey("treats ! as a command name", func() {
+			command, err := ParseCommand(":%!sort -r")
+			So(err, ShouldBeNil)
+			So(command, ShouldResemble, Command{Range: "%", Name: "!", Args: "sort -r"})
+		})
+
+		Convey("returns an error without a command name", func() {
+			_, err := ParseCommand(":")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCommandDefinition(t *testing.T) {
+	Convey("a command with an abbreviation", t, func() {
+		def := CommandDefinition{Name: "bd[elete]"}
+		So(def.FullName(), ShouldEqual, "bdelete")
+		So(def.Matches("bd"), ShouldBeTrue)
+		So(def.Matches("bdel"), ShouldBeTrue)
+		So(def.Matches("bdelete"), ShouldBeTrue)
+		So(def.Matches("b"), ShouldBeFalse)
+		So(def.Matches("bdx"), ShouldBeFalse)
+	})
+}
+
+func TestBufferCommands(t *testing.T) {
+	Convey("editor with two open files", t, func() {
+		editor := NewEditor(GetTestFs())
+		editor.OpenFiles([]string{"fakefile.txt", "fakefile2.txt"})
+
+		Convey(":b switches buffer by number", func() {
+			So(editor.ExecuteCommand(":b 2"), ShouldBeNil)
+			So(editor.CurrentPane().Buffer().ID(), ShouldEqual, 2)
+		})
+
+		Convey(":buffer switches buffer by partial name", func() {
+			So(editor.ExecuteCommand(":buffer file2"), ShouldBeNil)
+			So(editor.CurrentPane().Buffer().ID(), ShouldEqual, 2)
+		})
+
+		Convey(":ls lists the buffers", func() {
+			So(editor.ExecuteCommand(":ls"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "  1 %a   \"fakefile.txt\"\n  2  h   \"fakefile2.txt\"")
+		})
+
+		Convey(":bd refuses to delete a modified buffer without !", func() {
+			editor.CurrentPane().Buffer().SetModified(true)
+			So(editor.ExecuteCommand(":bd"), ShouldNotBeNil)
+			So(editor.ExecuteCommand(":bd!"), ShouldBeNil)
+			So(editor.CurrentPane().Buffer().ID(), ShouldEqual, 2)
+		})
+
+		Convey(":bw removes the buffer", func() {
+			So(editor.ExecuteCommand(":bw 1"), ShouldBeNil)
+			So(len(editor.Buffers()), ShouldEqual, 1)
+		})
+
+		Convey("unknown commands return an error", func() {
+			So(editor.ExecuteCommand(":notacommand"), ShouldNotBeNil)
+		})
+
+		Convey("completes command names", func() {
+			So(editor.CompleteCommand("bu"), ShouldResemble, []string{"buffers", "buffer"})
+		})
+
+		Convey("completes buffer names", func() {
+			So(editor.CompleteCommand("b fake"), ShouldResemble, []string{"b fakefile.txt", "b fakefile2.txt"})
+		})
+
+		Convey("the command line cycles through completions", func() {
+			editor.EnterCommandMode()
+			for _, r := range "b fake" {
+				editor.CommandLine().Insert(r)
+			}
+			editor.CompleteCommandLine()
+			So(editor.CommandLine().Text(), ShouldEqual, "b fakefile.txt")
+			editor.CompleteCommandLine()
+			So(editor.CommandLine().Text(), ShouldEqual, "b fakefile2.txt")
+
+			So(editor.ExecuteCommandLine(), ShouldBeNil)
+			So(editor.Mode(), ShouldEqual, NormalMode)
+			So(editor.CurrentPane().Buffer().ID(), ShouldEqual, 2)
+		})
+
+		Convey("the command line remembers what was typed", func() {
+			for _, line := range []string{"b 1", "ls", "b 1"} {
+				editor.EnterCommandMode()
+				editor.CommandLine().SetText(line)
+				editor.ExecuteCommandLine()
+			}
+			So(editor.CommandLine().History(), ShouldResemble, []string{"ls", "b 1"})
+		})
+	})
+}
+
+func TestMessagesCommand(t *testing.T) {
+	Convey("editor with some messages", t, func() {
+		editor := NewEditor(GetTestFs())
+		editor.Echo("first")
+		editor.EchoWarning("second")
+
+		Convey(":messages shows the history", func() {
+			So(editor.ExecuteCommand(":messages"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "first\nsecond")
+			So(len(editor.Messages()), ShouldEqual, 2)
+		})
+
+		Convey(":messages clear empties the history", func() {
+			So(editor.ExecuteCommand(":mes clear"), ShouldBeNil)
+			So(editor.Messages(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestCommandRange(t *testing.T) {
+	Convey("editor with a five line buffer and the cursor on line 2", t, func() {
+		editor := NewEditor(GetCustomTestFs(map[string][]byte{"a": []byte("1\n2\n3\n4\n5\n")}))
+		editor.OpenFile("a")
+		editor.CurrentPane().Cursor().Move(0, 2)
+
+		resolve := func(line string) (int, int, error) {
+			command, err := ParseCommand(line)
+			So(err, ShouldBeNil)
+			return editor.CommandRange(command)
+		}
+
+		Convey("no range is the current line", func() {
+			first, last, err := resolve("fold")
+			So(err, ShouldBeNil)
+			So([]int{first, last}, ShouldResemble, []int{2, 2})
+		})
+
+		Convey("addresses and offsets", func() {
+			first, last, _ := resolve("1,$fold")
+			So([]int{first, last}, ShouldResemble, []int{1, 5})
+			first, last, _ = resolve(".,.+2fold")
+			So([]int{first, last}, ShouldResemble, []int{2, 4})
+			first, last, _ = resolve("%fold")
+			So([]int{first, last}, ShouldResemble, []int{1, 5})
+			first, last, _ = resolve("$-1fold")
+			So([]int{first, last}, ShouldResemble, []int{4, 4})
+		})
+
+		Convey("invalid ranges", func() {
+			_, _, err := resolve("3,1fold")
+			So(err, ShouldNotBeNil)
+			_, _, err = resolve("1,9fold")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a range on its own goes to the line", func() {
+			So(editor.ExecuteCommand(":4"), ShouldBeNil)
+			_, line := editor.CurrentPane().Cursor().Position()
+			So(line, ShouldEqual, 4)
+		})
+
+		Convey(":fold, :foldopen and :foldclose", func() {
+			So(editor.ExecuteCommand(":2,4fold"), ShouldBeNil)
+			So(editor.CurrentPane().Folds().ClosedFoldAt(3), ShouldNotBeNil)
+			So(editor.ExecuteCommand(":3foldopen"), ShouldBeNil)
+			So(editor.CurrentPane().Folds().ClosedFoldAt(3), ShouldBeNil)
+			So(editor.ExecuteCommand(":%foldc"), ShouldBeNil)
+			So(editor.CurrentPane().Folds().ClosedFoldAt(3), ShouldNotBeNil)
+			So(editor.ExecuteCommand(":5foldopen"), ShouldNotBeNil)
+		})
+	})
+}
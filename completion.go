@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/afero"
+)
+
+// CompletionItem is a candidate for the text before the cursor, it replaces the line from the rune
+// column Start up to the cursor. Text is a snippet if Snippet is set, see expandSnippet. The menu shows
+// Label, or Text if there isn't one, and ranks the item by how well Filter, or Text, matches what it replaces.
+type CompletionItem struct {
+	Text    string
+	Label   string
+	Detail  string
+	Filter  string
+	Start   int
+	Snippet bool
+}
+
+// CompletionRequest is what a CompletionSource completes: the line of the cursor up to the cursor and the
+// word being typed at the end of it, which starts at the rune column WordStart.
+type CompletionRequest struct {
+	Buffer    *Buffer
+	Line      int
+	Column    int
+	Before    string
+	Word      string
+	WordStart int
+}
+
+// CompletionSource suggests completions. Complete is called on the main loop and has to return quickly,
+// it calls reply once, maybe later from another goroutine, so a slow source doesn't hold up typing.
+type CompletionSource interface {
+	Complete(request CompletionRequest, reply func(items []CompletionItem))
+}
+
+// CompletionFunc is a function that is a CompletionSource.
+type CompletionFunc func(request CompletionRequest, reply func(items []CompletionItem))
+
+// Complete calls the function.
+func (f CompletionFunc) Complete(request CompletionRequest, reply func(items []CompletionItem)) {
+	f(request, reply)
+}
+
+// completion is the editor's insert mode completion, menu is nil while it isn't shown.
+type completion struct {
+	sources    []CompletionSource
+	snippets   map[string]map[string]string
+	menu       *completionMenu
+	generation int
+}
+
+// completionMenu are the candidates for a request. results are the items of each source, items those
+// ranked for the request with selected the one being previewed in the buffer, -1 if there isn't one.
+type completionMenu struct {
+	generation int
+	request    CompletionRequest
+	results    [][]CompletionItem
+	items      []completionCandidate
+	selected   int
+	top        int
+	preview    *completionPreview
+	float      *Float
+}
+
+type completionCandidate struct {
+	CompletionItem
+	source int
+	score  int
+}
+
+// completionPreview is a candidate inserted in the buffer in place of the text removed.
+type completionPreview struct {
+	start, end int
+	removed    string
+}
+
+// completionMenuHeight is the most candidates shown at once, completionMenuWidth the widest the menu gets.
+const (
+	completionMenuHeight = 10
+	completionMenuWidth  = 60
+)
+
+// completionAction is what a key does while completing.
+type completionAction int
+
+const (
+	completionNone completionAction = iota
+	completionOpen
+	completionNext
+	completionPrevious
+	completionAccept
+	completionCancel
+)
+
+// completionKeymaps are the keys of completion for each value of the "completekeys" option.
+// Next and previous open the menu when it isn't shown.
+var completionKeymaps = map[string]map[KeyEvent]completionAction{
+	"vim": {
+		{Rune: ' ', Mod: ModCtrl}: completionOpen,
+		{Rune: 'n', Mod: ModCtrl}: completionNext,
+		{Rune: 'p', Mod: ModCtrl}: completionPrevious,
+		{Key: KeyDown}:            completionNext,
+		{Key: KeyUp}:              completionPrevious,
+		{Rune: 'y', Mod: ModCtrl}: completionAccept,
+		{Rune: 'e', Mod: ModCtrl}: completionCancel,
+	},
+	"tab": {
+		{Rune: ' ', Mod: ModCtrl}:    completionOpen,
+		{Key: KeyTab}:                completionNext,
+		{Key: KeyTab, Mod: ModShift}: completionPrevious,
+		{Key: KeyDown}:               completionNext,
+		{Key: KeyUp}:                 completionPrevious,
+		{Key: KeyEnter}:              completionAccept,
+		{Rune: 'e', Mod: ModCtrl}:    completionCancel,
+	},
+}
+
+// AddCompletionSource adds a source of completions after the others, earlier sources win ties.
+func (editor *Editor) AddCompletionSource(source CompletionSource) {
+	editor.completionSources()
+	editor.completion.sources = append(editor.completion.sources, source)
+}
+
+// completionSources returns the sources of completions, starting with the built in ones.
+func (editor *Editor) completionSources() []CompletionSource {
+	if editor.completion.sources == nil {
+		editor.completion.sources = []CompletionSource{
+			CompletionFunc(editor.completeFromLanguageServer),
+			CompletionFunc(editor.completeSnippets),
+			CompletionFunc(editor.completeWords),
+			CompletionFunc(editor.completePaths),
+			CompletionFunc(editor.completeTags),
+		}
+	}
+	return editor.completion.sources
+}
+
+// AddSnippet adds a snippet completed from its trigger in buffers of a filetype, or all of them for "".
+// The body can have tab stops like $1 and ${1:default}, see expandSnippet.
+func (editor *Editor) AddSnippet(filetype, trigger, body string) {
+	if editor.completion.snippets == nil {
+		editor.completion.snippets = map[string]map[string]string{}
+	}
+	if editor.completion.snippets[filetype] == nil {
+		editor.completion.snippets[filetype] = map[string]string{}
+	}
+	editor.completion.snippets[filetype][trigger] = body
+}
+
+// Completions returns the candidates being shown, best first.
+func (editor *Editor) Completions() []CompletionItem {
+	items := []CompletionItem{}
+	if menu := editor.completion.menu; menu != nil {
+		for _, candidate := range menu.items {
+			items = append(items, candidate.CompletionItem)
+		}
+	}
+	return items
+}
+
+// SelectedCompletion returns the index of the candidate being previewed, -1 if there isn't one.
+func (editor *Editor) SelectedCompletion() int {
+	if menu := editor.completion.menu; menu != nil {
+		return menu.selected
+	}
+	return -1
+}
+
+// OpenCompletion asks the sources to complete the text before the cursor and shows their candidates
+// as they arrive.
+func (editor *Editor) OpenCompletion() {
+	if editor.Mode() != InsertMode {
+		return
+	}
+	editor.CloseCompletion()
+	menu := &completionMenu{selected: -1}
+	menu.float = &Float{Anchor: AnchorCursor, Y: 1, Z: floatLayerCompletion, Style: StyleCompletion}
+	menu.float.Layout = func(float *Float, width, height int) bool { return editor.layoutCompletion(menu) }
+	menu.float.Draw = func(grid *RuneGrid, x1, y1, x2, y2 int) { drawCompletion(grid, menu, x1, y1, x2) }
+	editor.completion.menu = menu
+	editor.OpenFloat(menu.float)
+	editor.requestCompletions(true)
+}
+
+// CloseCompletion stops completing, leaving the candidate being previewed in the buffer.
+func (editor *Editor) CloseCompletion() {
+	if menu := editor.completion.menu; menu != nil {
+		editor.CloseFloat(menu.float)
+	}
+	editor.completion.menu = nil
+}
+
+// CancelCompletion stops completing and puts back the text typed before a candidate was previewed.
+func (editor *Editor) CancelCompletion() {
+	editor.restorePreview()
+	editor.CloseCompletion()
+}
+
+// SelectCompletion previews the candidate delta after the one selected, going round through
+// the text that was typed.
+func (editor *Editor) SelectCompletion(delta int) {
+	menu := editor.completion.menu
+	if menu == nil || len(menu.items) == 0 {
+		return
+	}
+	n := len(menu.items) + 1
+	selected := ((menu.selected+1+delta)%n+n)%n - 1
+
+	editor.restorePreview()
+	menu.selected = selected
+	if selected == -1 {
+		return
+	}
+	if selected < menu.top {
+		menu.top = selected
+	} else if selected >= menu.top+completionMenuHeight {
+		menu.top = selected - completionMenuHeight + 1
+	}
+
+	item := menu.items[selected].CompletionItem
+	text := item.Text
+	if item.Snippet {
+		text, _ = expandSnippet(text)
+	}
+	start, removed := editor.replaceCompletion(item.Start, text, len(text))
+	menu.preview = &completionPreview{start: start, end: start + len(text), removed: removed}
+}
+
+// AcceptCompletion inserts the candidate selected, placing the cursor in a snippet, and stops completing.
+func (editor *Editor) AcceptCompletion() {
+	menu := editor.completion.menu
+	if menu == nil || menu.selected == -1 {
+		return
+	}
+	item := menu.items[menu.selected].CompletionItem
+	editor.restorePreview()
+	editor.CloseCompletion()
+
+	text, cursor := item.Text, len(item.Text)
+	if item.Snippet {
+		text, cursor = expandSnippet(text)
+		_, line := editor.CurrentPane().Cursor().Position()
+		lineText, _ := editor.CurrentPane().Buffer().GetLine(line)
+		indent := lineText[:len(lineText)-len(strings.TrimLeft(lineText, " \t"))]
+		cursor += strings.Count(text[:cursor], "\n") * len(indent)
+		text = strings.ReplaceAll(text, "\n", "\n"+indent)
+	}
+	editor.replaceCompletion(item.Start, text, cursor)
+}
+
+// replaceCompletion replaces the cursor's line from the column start to the cursor with text, as part of what
+// is being typed, and puts the cursor at the offset in it. It returns where the text starts in the data
+// and what it replaced.
+func (editor *Editor) replaceCompletion(start int, text string, cursor int) (int, string) {
+	buffer, c := editor.CurrentPane().Buffer(), editor.CurrentPane().Cursor()
+	x, line := c.Position()
+	if start > x {
+		start = x
+	}
+	from, to := buffer.Offset(start, line), buffer.Offset(x, line)
+	removed := string(buffer.data[from:to])
+	buffer.change(from, to, text, editor.joinEdits)
+	editor.joinEdits = true
+	c.Move(buffer.Position(from + cursor))
+	return from, removed
+}
+
+// restorePreview puts back the text a previewed candidate replaced.
+func (editor *Editor) restorePreview() {
+	menu := editor.completion.menu
+	if menu == nil || menu.preview == nil {
+		return
+	}
+	preview := menu.preview
+	menu.preview = nil
+	buffer := editor.CurrentPane().Buffer()
+	buffer.change(preview.start, preview.end, preview.removed, editor.joinEdits)
+	editor.CurrentPane().Cursor().Move(buffer.Position(preview.start + len(preview.removed)))
+}
+
+// handleCompletionKey runs the completion action of a key in insert mode, returning false if it has none.
+// Other keys keep the candidate being previewed.
+func (editor *Editor) handleCompletionKey(event KeyEvent) bool {
+	keymap, ok := completionKeymaps[editor.Settings().CompleteKeys]
+	if !ok {
+		keymap = completionKeymaps["vim"]
+	}
+	action := keymap[event]
+	menu := editor.completion.menu
+
+	if menu == nil {
+		switch action {
+		case completionNext, completionPrevious:
+			if event.Key == KeyUp || event.Key == KeyDown {
+				return false
+			}
+			if event.Key == KeyTab {
+				if request, ok := editor.completionRequest(); !ok || !request.completing() {
+					return false
+				}
+			}
+			fallthrough
+		case completionOpen:
+			editor.OpenCompletion()
+			return true
+		}
+		return false
+	}
+
+	switch action {
+	case completionOpen:
+		editor.OpenCompletion()
+	case completionNext:
+		editor.SelectCompletion(1)
+	case completionPrevious:
+		editor.SelectCompletion(-1)
+	case completionAccept:
+		if menu.selected == -1 {
+			// Enter still starts a new line when nothing is selected.
+			editor.CloseCompletion()
+			return event.Key != KeyEnter
+		}
+		editor.AcceptCompletion()
+	case completionCancel:
+		editor.CancelCompletion()
+	default:
+		menu.preview = nil
+		return false
+	}
+	return true
+}
+
+// updateCompletion follows what was typed, asking for new candidates while completing or, with the
+// "autocomplete" option, starting to once a word is two letters long.
+func (editor *Editor) updateCompletion(event KeyEvent) {
+	if editor.completion.menu != nil {
+		editor.requestCompletions(false)
+		return
+	}
+	if !editor.Settings().AutoComplete || event.Key != KeyRune || event.Mod&(ModCtrl|ModAlt) != 0 || !isWordRune(event.Rune) {
+		return
+	}
+	if request, ok := editor.completionRequest(); ok && utf8.RuneCountInString(request.Word) >= 2 {
+		editor.OpenCompletion()
+	}
+}
+
+// requestCompletions asks every source to complete the text before the cursor, replies to earlier
+// requests are dropped. It stops completing once the cursor leaves the line or what was being completed.
+func (editor *Editor) requestCompletions(opening bool) {
+	menu := editor.completion.menu
+	request, ok := editor.completionRequest()
+	if !ok || editor.Mode() != InsertMode || (!opening && (request.Line != menu.request.Line || !request.completing())) {
+		editor.CloseCompletion()
+		return
+	}
+
+	editor.completion.generation++
+	generation := editor.completion.generation
+	sources := editor.completionSources()
+	menu.generation, menu.request = generation, request
+	if len(menu.results) != len(sources) {
+		menu.results = make([][]CompletionItem, len(sources))
+	}
+	menu.selected, menu.top = -1, 0
+	editor.rankCompletions()
+
+	for i, source := range sources {
+		i := i
+		source.Complete(request, func(items []CompletionItem) {
+			editor.Post(func() {
+				if menu := editor.completion.menu; menu != nil && menu.generation == generation {
+					menu.results[i] = items
+					editor.rankCompletions()
+				}
+			})
+		})
+	}
+}
+
+// completionRequest returns what to complete at the cursor, false if there is nothing to type into.
+func (editor *Editor) completionRequest() (CompletionRequest, bool) {
+	pane := editor.CurrentPane()
+	buffer, cursor := pane.Buffer(), pane.Cursor()
+	if buffer == nil || cursor == nil || buffer.Terminal() != nil {
+		return CompletionRequest{}, false
+	}
+	x, line := cursor.Position()
+	text, _ := buffer.GetLine(line)
+	runes := []rune(text)
+	if x > len(runes) {
+		x = len(runes)
+	}
+	start := x
+	for start > 0 && isWordRune(runes[start-1]) {
+		start--
+	}
+	return CompletionRequest{
+		Buffer:    buffer,
+		Line:      line,
+		Column:    x,
+		Before:    string(runes[:x]),
+		Word:      string(runes[start:x]),
+		WordStart: start,
+	}, true
+}
+
+// completing returns true if there's a word or path before the cursor to complete.
+func (request CompletionRequest) completing() bool {
+	return request.Word != "" || strings.Contains(completionToken(request.Before), "/")
+}
+
+// rankCompletions orders the candidates by how well they match what they'd replace, keeping the one selected.
+func (editor *Editor) rankCompletions() {
+	menu := editor.completion.menu
+	var selected *completionCandidate
+	if menu.selected != -1 {
+		selected = &menu.items[menu.selected]
+	}
+
+	before := []rune(menu.request.Before)
+	candidates := []completionCandidate{}
+	for source, items := range menu.results {
+		for _, item := range items {
+			if item.Start < 0 || item.Start > len(before) {
+				continue
+			}
+			if score, _, ok := FuzzyMatch(string(before[item.Start:]), item.filter()); ok {
+				candidates = append(candidates, completionCandidate{item, source, score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.score != b.score:
+			return a.score > b.score
+		case a.source != b.source:
+			return a.source < b.source
+		case len(a.filter()) != len(b.filter()):
+			return len(a.filter()) < len(b.filter())
+		}
+		return a.filter() < b.filter()
+	})
+
+	type key struct {
+		text  string
+		start int
+	}
+	seen := map[key]bool{}
+	items := []completionCandidate{}
+	index := -1
+	for _, candidate := range candidates {
+		k := key{candidate.Text, candidate.Start}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if selected != nil && candidate.CompletionItem == selected.CompletionItem {
+			index = len(items)
+		}
+		items = append(items, candidate)
+	}
+
+	menu.items, menu.selected = items, index
+	if index == -1 {
+		menu.preview = nil
+	}
+	if menu.top > len(items)-1 {
+		menu.top = 0
+	}
+}
+
+// filter returns the text the item is ranked by.
+func (item CompletionItem) filter() string {
+	if item.Filter != "" {
+		return item.Filter
+	}
+	return item.Text
+}
+
+// label returns what the menu shows for the item.
+func (item CompletionItem) label() string {
+	if item.Label != "" {
+		return item.Label
+	}
+	return item.Text
+}
+
+// isWordRune returns true for the letters, digits and underscores of words.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// completionToken returns the end of the text up to a space or bracket, ie a word or a path.
+func completionToken(before string) string {
+	i := strings.LastIndexFunc(before, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("\"'`()[]{}<>,;=", r)
+	})
+	if i == -1 {
+		return before
+	}
+	_, size := utf8.DecodeRuneInString(before[i:])
+	return before[i+size:]
+}
+
+// expandSnippet returns the text of a snippet with its placeholders filled in and the offset of where the
+// cursor goes: the first tab stop, $0 or the end. Tab stops are $1, ${1:default} or ${1|choice,other|}, the
+// first choice is used. Variables such as ${TM_FILENAME} are left empty and \$ is a dollar.
+func expandSnippet(body string) (string, int) {
+	runes := []rune(body)
+	out := []byte{}
+	stops := map[int]int{}
+	stop := func(digits []rune) {
+		if n, err := strconv.Atoi(string(digits)); err == nil {
+			if _, ok := stops[n]; !ok {
+				stops[n] = len(out)
+			}
+		}
+	}
+	skipTo := func(i int, end rune) int {
+		for i < len(runes) && runes[i] != end {
+			i++
+		}
+		return i + 1
+	}
+	digits := func(i int) int {
+		for i < len(runes) && unicode.IsDigit(runes[i]) {
+			i++
+		}
+		return i
+	}
+
+	var expand func(i int, nested bool) int
+	expand = func(i int, nested bool) int {
+		for i < len(runes) {
+			r := runes[i]
+			switch {
+			case r == '\\' && i+1 < len(runes):
+				out = append(out, string(runes[i+1])...)
+				i += 2
+			case r == '}' && nested:
+				return i + 1
+			case r == '$' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+				j := digits(i + 1)
+				stop(runes[i+1 : j])
+				i = j
+			case r == '$' && i+1 < len(runes) && runes[i+1] == '{':
+				j := digits(i + 2)
+				if j > i+2 {
+					stop(runes[i+2 : j])
+				} else {
+					for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+						j++
+					}
+				}
+				switch {
+				case j < len(runes) && runes[j] == ':':
+					i = expand(j+1, true)
+				case j < len(runes) && runes[j] == '|':
+					k := j + 1
+					for k < len(runes) && runes[k] != ',' && runes[k] != '|' {
+						k++
+					}
+					out = append(out, string(runes[j+1:k])...)
+					i = skipTo(skipTo(k, '|'), '}')
+				default:
+					i = skipTo(j, '}')
+				}
+			default:
+				out = append(out, string(r)...)
+				i++
+			}
+		}
+		return i
+	}
+	expand(0, false)
+
+	cursor, first := len(out), -1
+	for n, offset := range stops {
+		if n > 0 && (first == -1 || n < first) {
+			first, cursor = n, offset
+		}
+	}
+	if offset, ok := stops[0]; ok && first == -1 {
+		cursor = offset
+	}
+	return string(out), cursor
+}
+
+// completeSnippets suggests the snippets of the buffer's filetype.
+func (editor *Editor) completeSnippets(request CompletionRequest, reply func([]CompletionItem)) {
+	items := []CompletionItem{}
+	for _, filetype := range []string{request.Buffer.Filetype(), ""} {
+		for trigger, body := range editor.completion.snippets[filetype] {
+			items = append(items, CompletionItem{
+				Text:    body,
+				Label:   trigger,
+				Filter:  trigger,
+				Detail:  "snippet",
+				Start:   request.WordStart,
+				Snippet: true,
+			})
+		}
+	}
+	reply(items)
+}
+
+// completeWords suggests the words of the open buffers that match the word being typed, those of
+// the current buffer first. Words from other buffers have the buffer's name as their detail.
+func (editor *Editor) completeWords(request CompletionRequest, reply func([]CompletionItem)) {
+	if request.Word == "" {
+		reply(nil)
+		return
+	}
+	type text struct {
+		name string
+		data []byte
+	}
+	texts := []text{{"", request.Buffer.data}}
+	for _, buffer := range editor.Buffers() {
+		if buffer != request.Buffer && buffer.Terminal() == nil {
+			texts = append(texts, text{filepath.Base(buffer.Name()), buffer.data})
+		}
+	}
+
+	go func() {
+		seen := map[string]bool{request.Word: true}
+		items := []CompletionItem{}
+		for _, t := range texts {
+			for _, word := range strings.FieldsFunc(string(t.data), func(r rune) bool { return !isWordRune(r) }) {
+				if seen[word] || utf8.RuneCountInString(word) < 2 {
+					continue
+				}
+				seen[word] = true
+				if _, _, ok := FuzzyMatch(request.Word, word); ok {
+					items = append(items, CompletionItem{Text: word, Detail: t.name, Start: request.WordStart})
+				}
+			}
+		}
+		reply(items)
+	}()
+}
+
+// completePaths suggests the files in the directory of a path being typed, relative paths are in the
+// working directory.
+func (editor *Editor) completePaths(request CompletionRequest, reply func([]CompletionItem)) {
+	token := completionToken(request.Before)
+	slash := strings.LastIndex(token, "/")
+	if slash == -1 {
+		reply(nil)
+		return
+	}
+	dir, base := token[:slash+1], token[slash+1:]
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:]) + "/"
+		}
+	}
+	start := request.Column - utf8.RuneCountInString(base)
+
+	go func() {
+		infos, err := afero.ReadDir(editor.fs, dir)
+		if err != nil {
+			reply(nil)
+			return
+		}
+		items := []CompletionItem{}
+		for _, info := range infos {
+			name := info.Name()
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+				continue
+			}
+			item := CompletionItem{Text: name, Detail: "file", Start: start}
+			if info.IsDir() {
+				item.Text, item.Detail = name+"/", "dir"
+			}
+			items = append(items, item)
+		}
+		reply(items)
+	}()
+}
+
+// tagFiles returns where the ctags file of a buffer might be, next to its file or in the working directory.
+func tagFiles(buffer *Buffer) []string {
+	filenames := []string{"tags"}
+	if buffer != nil && buffer.Filename() != "" {
+		filenames = append([]string{filepath.Join(filepath.Dir(buffer.Filename()), "tags")}, filenames...)
+	}
+	return filenames
+}
+
+// completeTags suggests the names in the ctags file next to the buffer's file or in the working directory.
+func (editor *Editor) completeTags(request CompletionRequest, reply func([]CompletionItem)) {
+	if request.Word == "" {
+		reply(nil)
+		return
+	}
+	filenames := tagFiles(request.Buffer)
+	go func() {
+		items := []CompletionItem{}
+		seen := map[string]bool{}
+		for _, filename := range filenames {
+			file, err := editor.fs.Open(filename)
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Split(scanner.Text(), "\t")
+				if len(fields) < 3 || strings.HasPrefix(fields[0], "!_TAG_") || seen[fields[0]] {
+					continue
+				}
+				if _, _, ok := FuzzyMatch(request.Word, fields[0]); !ok {
+					continue
+				}
+				seen[fields[0]] = true
+				detail := fields[1]
+				if len(fields) > 3 && len(fields[3]) == 1 {
+					detail = fields[3] + " " + detail
+				}
+				items = append(items, CompletionItem{Text: fields[0], Detail: detail, Start: request.WordStart})
+			}
+			file.Close()
+			break
+		}
+		reply(items)
+	}()
+}
+
+// layoutCompletion sizes the float of the completion menu to the candidates shown, under the start of
+// the word being completed. It returns false if there is nothing to show.
+func (editor *Editor) layoutCompletion(menu *completionMenu) bool {
+	if len(menu.items) == 0 || editor.Mode() != InsertMode {
+		return false
+	}
+	column, _ := editor.CurrentPane().Cursor().Position()
+	items := menu.shown()
+	labelWidth, width := menu.labelWidth(), 0
+	for _, item := range items {
+		n := labelWidth + 2
+		if item.Detail != "" {
+			n += utf8.RuneCountInString(item.Detail) + 2
+		}
+		if n > width {
+			width = n
+		}
+	}
+	if width > completionMenuWidth {
+		width = completionMenuWidth
+	}
+	menu.float.X, menu.float.Width, menu.float.Height = menu.request.WordStart-column, width, len(items)
+	return true
+}
+
+// shown returns the candidates in view.
+func (menu *completionMenu) shown() []completionCandidate {
+	items := menu.items[menu.top:]
+	if len(items) > completionMenuHeight {
+		items = items[:completionMenuHeight]
+	}
+	return items
+}
+
+// labelWidth returns the width of the widest label in view.
+func (menu *completionMenu) labelWidth() int {
+	width := 0
+	for _, item := range menu.shown() {
+		if n := utf8.RuneCountInString(item.label()); n > width {
+			width = n
+		}
+	}
+	return width
+}
+
+// drawCompletion draws the candidates in view with their details, highlighting the selected one.
+func drawCompletion(grid *RuneGrid, menu *completionMenu, x1, y1, x2 int) {
+	labelWidth := menu.labelWidth()
+	for i, item := range menu.shown() {
+		style := StyleCompletion
+		if menu.top+i == menu.selected {
+			style = StyleCompletionSelected
+		}
+		line := " " + item.label() + strings.Repeat(" ", labelWidth-utf8.RuneCountInString(item.label())+1)
+		if item.Detail != "" {
+			line += " " + item.Detail + " "
+		}
+		grid.FillStyle(x1, y1+i, x2, y1+i, style)
+		grid.DrawText(x1, y1+i, x2, line, style)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestExpandSnippet(t *testing.T) {
+	Convey("Snippets", t, func() {
+		Convey("fill in their placeholders with the cursor at the first", func() {
+			text, cursor := expandSnippet("for ${1:i} := 0; $1 < ${2:n}; $1++ {\n\t$0\n}")
+			So(text, ShouldEqual, "for i := 0;  < n; ++ {\n\t\n}")
+			So(cursor, ShouldEqual, 4)
+		})
+
+		Convey("put the cursor at $0 without tab stops", func() {
+			text, cursor := expandSnippet("if err != nil {\n\t$0\n}")
+			So(text, ShouldEqual, "if err != nil {\n\t\n}")
+			So(cursor, ShouldEqual, 17)
+		})
+
+		Convey("take the first choice and leave variables out", func() {
+			text, cursor := expandSnippet(`\$x ${1|a,b|} ${TM_FILENAME} ${2:${3:nested}}`)
+			So(text, ShouldEqual, "$x a  nested")
+			So(cursor, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestCompletion(t *testing.T) {
+	Convey("Editor typing in a buffer", t, func() {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "/proj/src/main.go", []byte{}, 0644)
+		afero.WriteFile(fs, "/proj/src/lib/lib.go", []byte{}, 0644)
+		afero.WriteFile(fs, "/proj/src/.hidden", []byte{}, 0644)
+		afero.WriteFile(fs, "tags", []byte("!_TAG_FILE_FORMAT\t2\nTagged\tlib.go\t/^func Tagged/;\"\tf\n"), 0644)
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		work := make(chan func(), 1024)
+		editor.SetPost(func(f func()) { work <- f })
+		run := func(done func() bool) {
+			deadline := time.After(time.Second)
+			for !done() {
+				select {
+				case f := <-work:
+					f()
+				case <-deadline:
+					So(done(), ShouldBeTrue)
+					return
+				}
+			}
+		}
+
+		other := editor.AddBuffer(bufferWithText("alphanumeric\n"))
+		other.SetFilename("other.txt")
+		buffer := editor.AddBuffer(bufferWithText("alpha beta alphabet\n\n"))
+		editor.SwitchToBuffer(buffer)
+		editor.CurrentPane().Cursor().Move(0, 2)
+		editor.EnterInsertMode(false)
+		typeInsert(&editor, "al")
+		ctrl := func(r rune) { editor.HandleInsertKey(KeyEvent{Rune: r, Mod: ModCtrl}) }
+		line := func() string { text, _ := buffer.GetLine(2); return text }
+		texts := func() []string {
+			texts := []string{}
+			for _, item := range editor.Completions() {
+				texts = append(texts, item.Text)
+			}
+			return texts
+		}
+
+		Convey("Ctrl-N shows the words of the buffers, best first", func() {
+			ctrl('n')
+			run(func() bool { return len(editor.Completions()) == 3 })
+			So(texts(), ShouldResemble, []string{"alpha", "alphabet", "alphanumeric"})
+			So(editor.Completions()[2].Detail, ShouldEqual, "other.txt")
+			So(editor.SelectedCompletion(), ShouldEqual, -1)
+
+			grid := NewRuneGrid(20, 6)
+			grid.RenderEditor(&editor)
+			So(string(grid.Cells()[2]), ShouldStartWith, " alpha ")
+			So(string(grid.Cells()[3]), ShouldStartWith, " alphabet ")
+			So(grid.Styles()[2][1], ShouldEqual, StyleCompletion)
+
+			Convey("which are previewed as they're selected", func() {
+				ctrl('n')
+				So(line(), ShouldEqual, "alpha")
+				ctrl('n')
+				So(line(), ShouldEqual, "alphabet")
+				grid.RenderEditor(&editor)
+				So(grid.Styles()[3][1], ShouldEqual, StyleCompletionSelected)
+				ctrl('n')
+				ctrl('n')
+				So(line(), ShouldEqual, "al")
+				So(editor.SelectedCompletion(), ShouldEqual, -1)
+				ctrl('p')
+				So(line(), ShouldEqual, "alphanumeric")
+			})
+
+			Convey("Ctrl-E puts back what was typed", func() {
+				ctrl('n')
+				ctrl('e')
+				So(line(), ShouldEqual, "al")
+				So(editor.Completions(), ShouldBeEmpty)
+			})
+
+			Convey("Ctrl-Y accepts the candidate", func() {
+				ctrl('n')
+				ctrl('y')
+				So(line(), ShouldEqual, "alpha")
+				So(editor.Completions(), ShouldBeEmpty)
+
+				Convey("which is undone with the rest of the typing", func() {
+					editor.HandleInsertKey(KeyEvent{Key: KeyEscape})
+					So(editor.Undo(), ShouldBeNil)
+					So(line(), ShouldEqual, "")
+				})
+			})
+
+			Convey("typing keeps the candidate and narrows the others", func() {
+				ctrl('n')
+				typeInsert(&editor, "n")
+				So(line(), ShouldEqual, "alphan")
+				run(func() bool { return len(editor.Completions()) == 1 })
+				So(texts(), ShouldResemble, []string{"alphanumeric"})
+
+				Convey("until the word ends", func() {
+					typeInsert(&editor, " ")
+					So(editor.Completions(), ShouldBeEmpty)
+				})
+			})
+
+			Convey("Escape leaves insert mode with the candidate", func() {
+				ctrl('n')
+				editor.HandleInsertKey(KeyEvent{Key: KeyEscape})
+				So(line(), ShouldEqual, "alpha")
+				So(editor.Mode(), ShouldEqual, NormalMode)
+				So(editor.Completions(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("replies to earlier requests are dropped", func() {
+			replies := []func([]CompletionItem){}
+			editor.AddCompletionSource(CompletionFunc(func(request CompletionRequest, reply func([]CompletionItem)) {
+				replies = append(replies, reply)
+			}))
+			ctrl('n')
+			typeInsert(&editor, "p")
+			replies[0]([]CompletionItem{{Text: "alpstale", Start: 0}})
+			replies[1]([]CompletionItem{{Text: "alpfresh", Start: 0}})
+			run(func() bool { return len(editor.Completions()) == 4 })
+			So(texts(), ShouldContain, "alpfresh")
+			So(texts(), ShouldNotContain, "alpstale")
+		})
+
+		Convey("snippets are expanded", func() {
+			editor.AddSnippet("", "alfn", "func ${1:name}() {\n\t$0\n}")
+			typeInsert(&editor, "\b\b\tal")
+			ctrl('n')
+			run(func() bool { return len(editor.Completions()) == 4 })
+			So(editor.Completions()[0].Label, ShouldEqual, "alfn")
+			ctrl('n')
+			So(line(), ShouldEqual, "\tfunc name() {")
+			ctrl('y')
+			lines, _ := buffer.GetLines(2, 4)
+			So(lines, ShouldResemble, []string{"\tfunc name() {", "\t\t", "\t}"})
+			x, y := editor.CurrentPane().Cursor().Position()
+			So([]int{x, y}, ShouldResemble, []int{6, 2})
+		})
+
+		Convey("paths are completed from the filesystem", func() {
+			typeInsert(&editor, "\b\b/proj/src/")
+			ctrl('n')
+			run(func() bool { return len(editor.Completions()) == 2 })
+			So(texts(), ShouldResemble, []string{"lib/", "main.go"})
+			typeInsert(&editor, "m")
+			run(func() bool { return len(editor.Completions()) == 2 })
+			So(texts(), ShouldResemble, []string{"main.go", "alphanumeric"})
+			ctrl('n')
+			So(line(), ShouldEqual, "/proj/src/main.go")
+		})
+
+		Convey("names are completed from the tags file", func() {
+			typeInsert(&editor, "\b\bTag")
+			ctrl('n')
+			run(func() bool { return len(editor.Completions()) == 1 })
+			So(editor.Completions()[0], ShouldResemble, CompletionItem{Text: "Tagged", Detail: "f lib.go", Start: 0})
+		})
+
+		Convey("scripts can add completions", func() {
+			_, err := editor.RunScript("test", `editor.add_completion(function(word) return {word .. "_from_lua"} end)`)
+			So(err, ShouldBeNil)
+			ctrl('n')
+			run(func() bool { return len(editor.Completions()) == 4 })
+			So(texts(), ShouldContain, "al_from_lua")
+		})
+
+		Convey("with the tab keys", func() {
+			So(editor.ExecuteCommand("set completekeys=tab"), ShouldBeNil)
+			editor.HandleInsertKey(KeyEvent{Key: KeyTab})
+			run(func() bool { return len(editor.Completions()) == 3 })
+			editor.HandleInsertKey(KeyEvent{Key: KeyTab, Mod: ModShift})
+			So(line(), ShouldEqual, "alphanumeric")
+			editor.HandleInsertKey(KeyEvent{Key: KeyEnter})
+			So(line(), ShouldEqual, "alphanumeric")
+			So(editor.Completions(), ShouldBeEmpty)
+
+			Convey("Tab and Enter type when there's nothing to complete", func() {
+				typeInsert(&editor, " ")
+				editor.HandleInsertKey(KeyEvent{Key: KeyTab})
+				So(line(), ShouldEqual, "alphanumeric \t")
+				editor.HandleInsertKey(KeyEvent{Key: KeyEnter})
+				So(buffer.LineCount(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("autocomplete starts as a word is typed", func() {
+			So(editor.ExecuteCommand("set autocomplete"), ShouldBeNil)
+			typeInsert(&editor, " b")
+			So(editor.Completions(), ShouldBeEmpty)
+			typeInsert(&editor, "e")
+			run(func() bool { return len(editor.Completions()) == 2 })
+			So(strings.Join(texts(), ","), ShouldEqual, "beta,alphabet")
+		})
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Conflict is a merge conflict left in a file by git, the lines of its markers counted from 1. Ours
+// are the lines between Start and Base, or Middle without a base, theirs between Middle and End. Base is
+// 0 unless the conflict has the base's lines as well, as with "git config merge.conflictStyle diff3".
+type Conflict struct {
+	Start  int
+	Base   int
+	Middle int
+	End    int
+}
+
+// conflictFile is the conflicts of a buffer as of a version of it.
+type conflictFile struct {
+	version   int
+	conflicts []Conflict
+}
+
+// isMarker returns whether a line is a conflict marker, seven of a character alone or followed by a space.
+func isMarker(line string, marker byte) bool {
+	line = strings.TrimSuffix(line, "\r")
+	if len(line) < 7 || strings.Count(line[:7], string(marker)) != 7 {
+		return false
+	}
+	return len(line) == 7 || line[7] == ' '
+}
+
+// FindConflicts returns the merge conflicts in lines, markers that don't make a whole conflict are ignored.
+func FindConflicts(lines []string) []Conflict {
+	conflicts := []Conflict{}
+	var conflict *Conflict
+	for i, line := range lines {
+		number := i + 1
+		switch {
+		case isMarker(line, '<'):
+			conflict = &Conflict{Start: number}
+		case conflict == nil:
+		case isMarker(line, '|') && conflict.Base == 0 && conflict.Middle == 0:
+			conflict.Base = number
+		case isMarker(line, '=') && conflict.Middle == 0:
+			conflict.Middle = number
+		case isMarker(line, '>') && conflict.Middle != 0:
+			conflict.End = number
+			conflicts = append(conflicts, *conflict)
+			conflict = nil
+		}
+	}
+	return conflicts
+}
+
+// Ours returns the first and last lines of our side of the conflict, last is before first if it's empty.
+func (conflict Conflict) Ours() (int, int) {
+	if conflict.Base != 0 {
+		return conflict.Start + 1, conflict.Base - 1
+	}
+	return conflict.Start + 1, conflict.Middle - 1
+}
+
+// Theirs returns the first and last lines of their side of the conflict.
+func (conflict Conflict) Theirs() (int, int) {
+	return conflict.Middle + 1, conflict.End - 1
+}
+
+// BaseLines returns the first and last lines of the base of the conflict.
+func (conflict Conflict) BaseLines() (int, int) {
+	if conflict.Base == 0 {
+		return 0, -1
+	}
+	return conflict.Base + 1, conflict.Middle - 1
+}
+
+// Conflicts returns the merge conflicts in a buffer. Buffers with conflicts are highlighted.
+func (editor *Editor) Conflicts(buffer *Buffer) []Conflict {
+	file, ok := editor.conflicts[buffer]
+	if ok && file.version == buffer.version {
+		return file.conflicts
+	}
+	conflicts := FindConflicts(bufferLines(buffer, 1, buffer.LineCount()))
+	if !ok {
+		if len(conflicts) == 0 {
+			return conflicts
+		}
+		if editor.conflicts == nil {
+			editor.conflicts = map[*Buffer]*conflictFile{}
+		}
+		file = &conflictFile{}
+		editor.conflicts[buffer] = file
+		editor.WhenDeleted(buffer, func() { delete(editor.conflicts, buffer) })
+	}
+	file.version, file.conflicts = buffer.version, conflicts
+	return conflicts
+}
+
+// checkConflicts warns about the merge conflicts of a file that was just read.
+func (editor *Editor) checkConflicts(buffer *Buffer) {
+	if n := len(editor.Conflicts(buffer)); n > 0 {
+		editor.EchoWarning(fmt.Sprintf("%d merge conflicts in %s", n, buffer.Filename()))
+	}
+}
+
+// conflictHere returns the conflict under the cursor.
+func (editor *Editor) conflictHere() (Conflict, error) {
+	buffer := editor.CurrentPane().Buffer()
+	_, line := editor.CurrentPane().Cursor().Position()
+	for _, conflict := range editor.Conflicts(buffer) {
+		if line >= conflict.Start && line <= conflict.End {
+			return conflict, nil
+		}
+	}
+	return Conflict{}, errors.New("No conflict under the cursor")
+}
+
+// NextConflict moves the cursor to the start of the next conflict, or the previous one if count is negative.
+func (editor *Editor) NextConflict(count int) error {
+	cursor := editor.CurrentPane().Cursor()
+	_, line := cursor.Position()
+	target := 0
+	for _, conflict := range editor.Conflicts(editor.CurrentPane().Buffer()) {
+		switch {
+		case count > 0 && conflict.Start > line && target == 0:
+			target = conflict.Start
+		case count < 0 && conflict.End < line:
+			target = conflict.Start
+		}
+	}
+	if target == 0 {
+		return errors.New("No more conflicts")
+	}
+	cursor.Move(0, target)
+	return nil
+}
+
+// resolution returns the lines a conflict is replaced with to take "ours", "theirs", "both" or the "base".
+func resolution(lines []string, conflict Conflict, take string) ([]string, error) {
+	between := func(first, last int) []string { return lines[first-1 : last] }
+	switch take {
+	case "ours":
+		return between(conflict.Ours()), nil
+	case "theirs":
+		return between(conflict.Theirs()), nil
+	case "both":
+		return append(append([]string{}, between(conflict.Ours())...), between(conflict.Theirs())...), nil
+	case "base":
+		if conflict.Base == 0 {
+			return nil, errors.New("Conflict has no base")
+		}
+		return between(conflict.BaseLines()), nil
+	}
+	return nil, fmt.Errorf("Unknown side %q, use ours, theirs, both or base", take)
+}
+
+// ResolveConflicts replaces the conflict under the cursor, or every conflict of the buffer if all is set,
+// with "ours", "theirs", "both" or the "base".
+func (editor *Editor) ResolveConflicts(take string, all bool) error {
+	buffer := editor.CurrentPane().Buffer()
+	conflicts := editor.Conflicts(buffer)
+	if !all {
+		conflict, err := editor.conflictHere()
+		if err != nil {
+			return err
+		}
+		conflicts = []Conflict{conflict}
+	} else if len(conflicts) == 0 {
+		return errors.New("No conflicts")
+	}
+	lines := bufferLines(buffer, 1, buffer.LineCount())
+	replacements := make([][]string, len(conflicts))
+	for i, conflict := range conflicts {
+		replacement, err := resolution(lines, conflict, take)
+		if err != nil {
+			return err
+		}
+		replacements[i] = replacement
+	}
+	// From the last so the lines of those before don't move.
+	for i := len(conflicts) - 1; i >= 0; i-- {
+		if err := buffer.ReplaceLines(conflicts[i].Start, conflicts[i].End, replacements[i]); err != nil {
+			return err
+		}
+	}
+	_, line := editor.CurrentPane().Cursor().Position()
+	if count := buffer.LineCount(); line > count {
+		line = count
+	}
+	if !all {
+		line = conflicts[0].Start
+	}
+	if line < 1 {
+		line = 1
+	}
+	editor.CurrentPane().Cursor().Move(0, line)
+	return nil
+}
+
+// conflictCommand resolves the conflict under the cursor with ":conflict ours", "theirs", "both" or "base", every
+// conflict in the buffer with '!'. ":conflict next" and "prev" move between them, without arguments it counts them.
+func conflictCommand(editor *Editor, command Command) error {
+	if editor.CurrentPane().Buffer() == nil {
+		return errors.New("No buffer")
+	}
+	args := strings.Fields(command.Args)
+	if len(args) == 0 {
+		editor.Echo(fmt.Sprintf("%d merge conflicts", len(editor.Conflicts(editor.CurrentPane().Buffer()))))
+		return nil
+	}
+	switch args[0] {
+	case "next":
+		return editor.NextConflict(1)
+	case "prev":
+		return editor.NextConflict(-1)
+	}
+	return editor.ResolveConflicts(args[0], command.Bang)
+}
+
+// RenderConflicts highlights the sides of the merge conflicts in a pane's buffer.
+func (grid *RuneGrid) RenderConflicts(editor *Editor, pane *Pane) {
+	if _, ok := editor.conflicts[pane.Buffer()]; !ok {
+		return
+	}
+	conflicts := editor.Conflicts(pane.Buffer())
+	view := pane.View()
+	for y, row := range view.Rows {
+		if row.Line == 0 || row.Fold != nil {
+			continue
+		}
+		for _, conflict := range conflicts {
+			if row.Line < conflict.Start || row.Line > conflict.End {
+				continue
+			}
+			style := StyleConflictMarker
+			switch line := row.Line; {
+			case line == conflict.Start || line == conflict.Base || line == conflict.Middle || line == conflict.End:
+			case line > conflict.Middle:
+				style = StyleConflictTheirs
+			case conflict.Base != 0 && line > conflict.Base:
+				style = StyleConflictBase
+			default:
+				style = StyleConflictOurs
+			}
+			grid.FillStyle(view.X, view.Y+y, view.X+view.Width-1, view.Y+y, style)
+			break
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+const conflicted = `a
+<<<<<<< HEAD
+ours
+=======
+theirs
+>>>>>>> branch
+b
+<<<<<<< HEAD
+ours 2
+||||||| base
+base 2
+=======
+>>>>>>> branch
+c
+`
+
+func TestFindConflicts(t *testing.T) {
+	Convey("Finding conflicts", t, func() {
+		Convey("finds both styles of markers", func() {
+			So(FindConflicts(strings.Split(conflicted, "\n")), ShouldResemble, []Conflict{
+				{Start: 2, Middle: 4, End: 6},
+				{Start: 8, Base: 10, Middle: 12, End: 13},
+			})
+		})
+
+		Convey("ignores markers that don't make a conflict", func() {
+			So(FindConflicts([]string{"=======", "<<<<<<<<", ">>>>>>>", "<<<<<<< a", ">>>>>>> b"}), ShouldBeEmpty)
+			So(FindConflicts([]string{"<<<<<<<", "<<<<<<<\r", "=======\r", ">>>>>>>\r"}), ShouldResemble, []Conflict{
+				{Start: 2, Middle: 3, End: 4},
+			})
+		})
+
+		Convey("gives the lines of each side", func() {
+			conflict := Conflict{Start: 8, Base: 10, Middle: 12, End: 13}
+			first, last := conflict.Ours()
+			So([]int{first, last}, ShouldResemble, []int{9, 9})
+			first, last = conflict.BaseLines()
+			So([]int{first, last}, ShouldResemble, []int{11, 11})
+			first, last = conflict.Theirs()
+			So(last, ShouldBeLessThan, first)
+		})
+	})
+}
+
+func TestConflicts(t *testing.T) {
+	Convey("A file with merge conflicts", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{"merge.txt": []byte(conflicted)})
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		editor.OpenFile("merge.txt")
+		buffer := editor.CurrentPane().Buffer()
+		cursor := editor.CurrentPane().Cursor()
+
+		Convey("is warned about when it's opened", func() {
+			So(editor.Message().Text, ShouldEqual, "2 merge conflicts in merge.txt")
+			So(editor.Message().Severity, ShouldEqual, SeverityWarning)
+		})
+
+		Convey("has its sides highlighted", func() {
+			grid := NewRuneGrid(20, 16)
+			grid.RenderEditor(&editor)
+			So(grid.Style(0, 0), ShouldEqual, StyleNormal)
+			So(grid.Style(0, 1), ShouldEqual, StyleConflictMarker)
+			So(grid.Style(5, 2), ShouldEqual, StyleConflictOurs)
+			So(grid.Style(0, 4), ShouldEqual, StyleConflictTheirs)
+			So(grid.Style(0, 10), ShouldEqual, StyleConflictBase)
+			So(grid.Style(0, 12), ShouldEqual, StyleConflictMarker)
+		})
+
+		Convey("moves between conflicts", func() {
+			editor.HandleNormalKey(']')
+			editor.HandleNormalKey('x')
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 2)
+			So(editor.ExecuteCommand("conflict next"), ShouldBeNil)
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 8)
+			So(editor.ExecuteCommand("conflict next").Error(), ShouldEqual, "No more conflicts")
+			editor.HandleNormalKey('[')
+			editor.HandleNormalKey('x')
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 2)
+		})
+
+		Convey("takes one side of the conflict under the cursor", func() {
+			So(editor.ExecuteCommand("conflict ours").Error(), ShouldEqual, "No conflict under the cursor")
+			cursor.Move(0, 5)
+			So(editor.ExecuteCommand("conflict theirs"), ShouldBeNil)
+			So(string(buffer.data), ShouldStartWith, "a\ntheirs\nb\n<<<<<<< HEAD\n")
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 2)
+			So(editor.Conflicts(buffer), ShouldHaveLength, 1)
+
+			Convey("or the base", func() {
+				cursor.Move(0, 6)
+				So(editor.ExecuteCommand("conflict base"), ShouldBeNil)
+				So(string(buffer.data), ShouldEqual, "a\ntheirs\nb\nbase 2\nc\n")
+				So(editor.Conflicts(buffer), ShouldBeEmpty)
+			})
+		})
+
+		Convey("takes both sides of every conflict", func() {
+			So(editor.ExecuteCommand("conflict! both"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "a\nours\ntheirs\nb\nours 2\nc\n")
+			So(editor.ExecuteCommand("conflict"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "0 merge conflicts")
+		})
+
+		Convey("can't take a base that isn't there", func() {
+			So(editor.ExecuteCommand("conflict! base").Error(), ShouldEqual, "Conflict has no base")
+			So(editor.ExecuteCommand("conflict! mine").Error(), ShouldEqual, `Unknown side "mine", use ours, theirs, both or base`)
+			So(string(buffer.data), ShouldEqual, conflicted)
+		})
+
+		Convey("is only written with '!'", func() {
+			buffer.SetModified(true)
+			So(editor.ExecuteCommand("w").Error(), ShouldEqual, "2 merge conflicts left in buffer 1 (add ! to write anyway)")
+			So(editor.ExecuteCommand("w!"), ShouldBeNil)
+			So(buffer.Modified(), ShouldBeFalse)
+
+			Convey("unless they're resolved", func() {
+				So(editor.ExecuteCommand("conflict! ours"), ShouldBeNil)
+				So(editor.ExecuteCommand("w"), ShouldBeNil)
+				data, _ := afero.ReadFile(fs, "merge.txt")
+				So(string(data), ShouldEqual, "a\nours\nb\nours 2\nc\n")
+			})
+		})
+
+		Convey("is forgotten when its buffer is deleted", func() {
+			So(editor.DeleteBuffer(buffer, true), ShouldBeNil)
+			So(editor.conflicts, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+// CharID identifies a character of a Document, or an operation on one.
+// Counter is a Lamport clock so IDs of operations that saw each other are ordered, Site breaks ties.
+// The zero CharID stands for the start of the document.
+type CharID struct {
+	Counter int `json:"c"`
+	Site    int `json:"s"`
+}
+
+// Less orders CharIDs by counter, then site.
+func (id CharID) Less(other CharID) bool {
+	if id.Counter != other.Counter {
+		return id.Counter < other.Counter
+	}
+	return id.Site < other.Site
+}
+
+// DocOp is an operation on a Document.
+// "insert" adds Rune as the character ID after the character Ref.
+// "delete" and "restore" hide or show the character Ref again, the one with the latest ID wins.
+type DocOp struct {
+	Kind string `json:"k"`
+	ID   CharID `json:"id"`
+	Ref  CharID `json:"ref"`
+	Rune rune   `json:"r,omitempty"`
+}
+
+// docChar is a character of a Document, deleted characters are kept hidden so later operations can find them.
+type docChar struct {
+	id      CharID
+	r       rune
+	visible bool
+	stamp   CharID
+}
+
+// Document is text edited by several sites at once, a replicated growable array.
+// Operations can be applied in any order and every site ends up with the same text.
+// Each site can only undo its own edits.
+// index has where each character is in chars, the entries from stale on may be out of date since
+// characters were inserted before them.
+type Document struct {
+	site    int
+	clock   int
+	chars   []docChar
+	index   map[CharID]int
+	stale   int
+	pending []DocOp
+	undo    [][]DocOp
+}
+
+// NewDocument constructs an empty Document edited by the site, which must be unique and not 0.
+func NewDocument(site int) Document {
+	return Document{site: site}
+}
+
+// Site returns the site editing the Document.
+func (doc *Document) Site() int {
+	return doc.site
+}
+
+// Text returns the visible text.
+func (doc *Document) Text() string {
+	runes := make([]rune, 0, len(doc.chars))
+	for _, c := range doc.chars {
+		if c.visible {
+			runes = append(runes, c.r)
+		}
+	}
+	return string(runes)
+}
+
+// Len returns the number of visible characters.
+func (doc *Document) Len() int {
+	count := 0
+	for _, c := range doc.chars {
+		if c.visible {
+			count++
+		}
+	}
+	return count
+}
+
+// Load inserts text at the start without adding it to the undo stack, ie the text of a file being shared.
+func (doc *Document) Load(text string) []DocOp {
+	return doc.edit(0, 0, text)
+}
+
+// Edit replaces count characters at the position with text and returns the operations to send to other sites.
+// With join it is undone together with the previous edit, ie the keys typed in one go in insert mode.
+func (doc *Document) Edit(pos, count int, text string, join bool) []DocOp {
+	ops := doc.edit(pos, count, text)
+	if len(ops) == 0 {
+		return ops
+	}
+
+	if join && len(doc.undo) > 0 {
+		doc.undo[len(doc.undo)-1] = append(doc.undo[len(doc.undo)-1], ops...)
+	} else {
+		doc.undo = append(doc.undo, ops)
+	}
+	return ops
+}
+
+func (doc *Document) edit(pos, count int, text string) []DocOp {
+	ops := []DocOp{}
+
+	for _, i := range doc.visibleIndexes(pos, count) {
+		op := DocOp{Kind: "delete", ID: doc.tick(), Ref: doc.chars[i].id}
+		doc.apply(op)
+		ops = append(ops, op)
+	}
+
+	ref := doc.idBefore(pos)
+	for _, r := range text {
+		op := DocOp{Kind: "insert", ID: doc.tick(), Ref: ref, Rune: r}
+		doc.apply(op)
+		ops = append(ops, op)
+		ref = op.ID
+	}
+	return ops
+}
+
+// Undo reverts this site's last edit and returns the operations to send to other sites, false if there was nothing to undo.
+// Characters it inserted are deleted and characters it deleted are restored, even if others edited them since.
+func (doc *Document) Undo() ([]DocOp, bool) {
+	if len(doc.undo) == 0 {
+		return nil, false
+	}
+	group := doc.undo[len(doc.undo)-1]
+	doc.undo = doc.undo[:len(doc.undo)-1]
+
+	ops := []DocOp{}
+	for i := len(group) - 1; i >= 0; i-- {
+		kind, target := "delete", group[i].Ref
+		switch group[i].Kind {
+		case "insert":
+			target = group[i].ID
+		case "delete":
+			kind = "restore"
+		}
+		op := DocOp{Kind: kind, ID: doc.tick(), Ref: target}
+		doc.apply(op)
+		ops = append(ops, op)
+	}
+	return ops, true
+}
+
+// Apply applies operations from other sites. Operations that depend on characters
+// that haven't arrived yet are kept until they do, applying an operation twice does nothing.
+func (doc *Document) Apply(ops ...DocOp) {
+	doc.pending = append(doc.pending, ops...)
+	for progress := true; progress; {
+		progress = false
+		remaining := doc.pending[:0]
+		for _, op := range doc.pending {
+			if op.ID.Counter > doc.clock {
+				doc.clock = op.ID.Counter
+			}
+			if doc.apply(op) {
+				progress = true
+			} else {
+				remaining = append(remaining, op)
+			}
+		}
+		doc.pending = remaining
+	}
+}
+
+// Pending returns how many operations are waiting for characters they depend on.
+func (doc *Document) Pending() int {
+	return len(doc.pending)
+}
+
+// apply integrates an operation, it returns false if the character it refers to is missing.
+func (doc *Document) apply(op DocOp) bool {
+	if op.Kind == "insert" {
+		if doc.find(op.ID) != -1 {
+			return true
+		}
+		i := 0
+		if op.Ref != (CharID{}) {
+			ref := doc.find(op.Ref)
+			if ref == -1 {
+				return false
+			}
+			i = ref + 1
+		}
+		// Characters inserted after the same one concurrently go in order of their IDs, latest first.
+		for i < len(doc.chars) && op.ID.Less(doc.chars[i].id) {
+			i++
+		}
+		doc.chars = append(doc.chars, docChar{})
+		copy(doc.chars[i+1:], doc.chars[i:])
+		doc.chars[i] = docChar{id: op.ID, r: op.Rune, visible: true, stamp: op.ID}
+		if i < doc.stale {
+			doc.stale = i
+		}
+		return true
+	}
+
+	i := doc.find(op.Ref)
+	if i == -1 {
+		return false
+	}
+	if doc.chars[i].stamp.Less(op.ID) {
+		doc.chars[i].visible = op.Kind == "restore"
+		doc.chars[i].stamp = op.ID
+	}
+	return true
+}
+
+// Anchor returns the ID of the visible character at the position, the zero CharID for the end of the text.
+// It follows the character as others edit the text, see Position.
+func (doc *Document) Anchor(pos int) CharID {
+	if indexes := doc.visibleIndexes(pos, 1); len(indexes) == 1 {
+		return doc.chars[indexes[0]].id
+	}
+	return CharID{}
+}
+
+// Position returns the position of an anchored character.
+// A deleted character gives the position of the next visible one, the zero CharID or an unknown one gives the end.
+func (doc *Document) Position(anchor CharID) int {
+	pos := 0
+	for _, c := range doc.chars {
+		if c.id == anchor {
+			return pos
+		}
+		if c.visible {
+			pos++
+		}
+	}
+	return pos
+}
+
+// tick returns a new ID for a local operation.
+func (doc *Document) tick() CharID {
+	doc.clock++
+	return CharID{Counter: doc.clock, Site: doc.site}
+}
+
+// find returns the index in chars of a character, -1 if it hasn't arrived.
+// The index is brought up to date from the first character inserted since it last was, so appending
+// characters one after the other, as loading text does, doesn't go over the whole document each time.
+func (doc *Document) find(id CharID) int {
+	if i, ok := doc.index[id]; ok && i < doc.stale {
+		return i
+	}
+	if doc.stale == len(doc.chars) && doc.index != nil {
+		return -1
+	}
+	if doc.index == nil {
+		doc.index = map[CharID]int{}
+	}
+	for i := doc.stale; i < len(doc.chars); i++ {
+		doc.index[doc.chars[i].id] = i
+	}
+	doc.stale = len(doc.chars)
+	if i, ok := doc.index[id]; ok {
+		return i
+	}
+	return -1
+}
+
+// visibleIndexes returns the indexes in chars of count visible characters from the position.
+func (doc *Document) visibleIndexes(pos, count int) []int {
+	indexes := []int{}
+	visible := 0
+	for i, c := range doc.chars {
+		if !c.visible {
+			continue
+		}
+		if visible >= pos && visible < pos+count {
+			indexes = append(indexes, i)
+		}
+		visible++
+	}
+	return indexes
+}
+
+// idBefore returns the ID of the visible character before the position, the zero CharID at the start.
+func (doc *Document) idBefore(pos int) CharID {
+	if pos <= 0 {
+		return CharID{}
+	}
+	if indexes := doc.visibleIndexes(pos-1, 1); len(indexes) == 1 {
+		return doc.chars[indexes[0]].id
+	}
+	for i := len(doc.chars) - 1; i >= 0; i-- {
+		if doc.chars[i].visible {
+			return doc.chars[i].id
+		}
+	}
+	return CharID{}
+}
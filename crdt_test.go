@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDocument(t *testing.T) {
+	Convey("Document with some text", t, func() {
+		doc := NewDocument(1)
+		doc.Load("hello")
+		So(doc.Text(), ShouldEqual, "hello")
+		So(doc.Len(), ShouldEqual, 5)
+
+		Convey("Edit replaces text at a position", func() {
+			doc.Edit(1, 3, "ipp", false)
+			So(doc.Text(), ShouldEqual, "hippo")
+			doc.Edit(5, 0, "!", false)
+			So(doc.Text(), ShouldEqual, "hippo!")
+		})
+
+		Convey("Undo reverts edits in groups, but not the loaded text", func() {
+			doc.Edit(5, 0, " ", false)
+			doc.Edit(6, 0, "w", false)
+			doc.Edit(7, 0, "o", true)
+			doc.Edit(0, 1, "j", false)
+			So(doc.Text(), ShouldEqual, "jello wo")
+
+			_, ok := doc.Undo()
+			So(ok, ShouldBeTrue)
+			So(doc.Text(), ShouldEqual, "hello wo")
+			doc.Undo()
+			So(doc.Text(), ShouldEqual, "hello ")
+			doc.Undo()
+			So(doc.Text(), ShouldEqual, "hello")
+			_, ok = doc.Undo()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Anchors follow characters as text is edited", func() {
+			anchor := doc.Anchor(2)
+			So(doc.Position(anchor), ShouldEqual, 2)
+			doc.Edit(0, 0, ">> ", false)
+			So(doc.Position(anchor), ShouldEqual, 5)
+			doc.Edit(5, 1, "", false)
+			So(doc.Position(anchor), ShouldEqual, 5)
+			So(doc.Position(doc.Anchor(100)), ShouldEqual, doc.Len())
+		})
+	})
+
+	Convey("Two sites editing concurrently", t, func() {
+		base := NewDocument(1)
+		ops := base.Load("abc")
+		other := NewDocument(2)
+		other.Apply(ops...)
+
+		Convey("inserts at the same place are merged in the same order on both", func() {
+			first := base.Edit(1, 0, "XY", false)
+			second := other.Edit(1, 0, "12", false)
+			base.Apply(second...)
+			other.Apply(first...)
+			So(base.Text(), ShouldEqual, other.Text())
+			So(len(base.Text()), ShouldEqual, 7)
+		})
+
+		Convey("operations arriving before what they depend on wait for it", func() {
+			edit := base.Edit(3, 0, "de", false)
+			other.Apply(edit[1])
+			So(other.Text(), ShouldEqual, "abc")
+			So(other.Pending(), ShouldEqual, 1)
+			other.Apply(edit[0])
+			So(other.Text(), ShouldEqual, "abcde")
+			So(other.Pending(), ShouldEqual, 0)
+			other.Apply(edit...)
+			So(other.Text(), ShouldEqual, "abcde")
+		})
+
+		Convey("each site only undoes its own edits", func() {
+			mine := base.Edit(3, 0, "!", false)
+			theirs := other.Edit(0, 1, "A", false)
+			base.Apply(theirs...)
+			other.Apply(mine...)
+			So(base.Text(), ShouldEqual, "Abc!")
+
+			undo, _ := other.Undo()
+			base.Apply(undo...)
+			So(base.Text(), ShouldEqual, "abc!")
+			So(other.Text(), ShouldEqual, "abc!")
+		})
+
+		Convey("undoing a delete restores text deleted by someone else too", func() {
+			mine := base.Edit(1, 1, "", false)
+			theirs := other.Edit(1, 2, "", false)
+			base.Apply(theirs...)
+			other.Apply(mine...)
+			So(base.Text(), ShouldEqual, "a")
+
+			undo, _ := base.Undo()
+			other.Apply(undo...)
+			So(base.Text(), ShouldEqual, "ab")
+			So(other.Text(), ShouldEqual, "ab")
+		})
+	})
+}
+
+func TestDocumentConvergence(t *testing.T) {
+	Convey("Sites making random concurrent edits converge", t, func() {
+		for seed := int64(1); seed <= 20; seed++ {
+			random := rand.New(rand.NewSource(seed))
+			sites := make([]Document, 2+random.Intn(3))
+			for i := range sites {
+				sites[i] = NewDocument(i + 1)
+			}
+			loaded := sites[0].Load("the quick brown fox")
+
+			// inboxes hold the operations each site hasn't seen yet, delivered in random order and batches.
+			inboxes := make([][]DocOp, len(sites))
+			for i := 1; i < len(sites); i++ {
+				inboxes[i] = append(inboxes[i], loaded...)
+			}
+			broadcast := func(from int, ops []DocOp) {
+				for i := range sites {
+					if i != from {
+						inboxes[i] = append(inboxes[i], ops...)
+					}
+				}
+			}
+			deliver := func(i int) {
+				random.Shuffle(len(inboxes[i]), func(a, b int) {
+					inboxes[i][a], inboxes[i][b] = inboxes[i][b], inboxes[i][a]
+				})
+				n := random.Intn(len(inboxes[i]) + 1)
+				sites[i].Apply(inboxes[i][:n]...)
+				inboxes[i] = inboxes[i][n:]
+			}
+
+			for step := 0; step < 200; step++ {
+				i := random.Intn(len(sites))
+				doc := &sites[i]
+				switch random.Intn(5) {
+				case 0:
+					deliver(i)
+				case 1:
+					if ops, ok := doc.Undo(); ok {
+						broadcast(i, ops)
+					}
+				default:
+					pos := random.Intn(doc.Len() + 1)
+					count := random.Intn(3)
+					text := string(rune('a' + random.Intn(26)))
+					if random.Intn(3) == 0 {
+						text = ""
+					}
+					broadcast(i, doc.Edit(pos, count, text, random.Intn(2) == 0))
+				}
+			}
+
+			for i := range sites {
+				sites[i].Apply(inboxes[i]...)
+				So(sites[i].Pending(), ShouldEqual, 0)
+				So(sites[i].Text(), ShouldEqual, sites[0].Text())
+			}
+		}
+	})
+}
+
+// largeText returns n characters of text over several lines.
+func largeText(n int) string {
+	return strings.Repeat("the quick brown fox jumps over the lazy dog\n", n/44+1)[:n]
+}
+
+func BenchmarkDocumentLoad(b *testing.B) {
+	text := largeText(40000)
+	for i := 0; i < b.N; i++ {
+		doc := NewDocument(1)
+		doc.Load(text)
+	}
+}
+
+func BenchmarkDocumentApply(b *testing.B) {
+	source := NewDocument(1)
+	ops := source.Load(largeText(40000))
+	for i := 0; i < b.N; i++ {
+		doc := NewDocument(2)
+		doc.Apply(ops...)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+// DiffHunk is a run of lines that differ between two versions of a text, OldLines lines from OldStart of
+// the old one were replaced by NewLines lines from NewStart of the new one. Lines are counted from 0 and
+// either count can be 0, a hunk that only adds lines starts before the old line it's added at.
+type DiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+}
+
+// DiffLines returns the hunks that turn the old lines into the new ones, fewest changed lines first.
+func DiffLines(old, new []string) []DiffHunk {
+	return diff(len(old), len(new), func(i, j int) bool { return old[i] == new[j] })
+}
+
+// diff finds the shortest edit between sequences of n and m items using Myers' algorithm in linear space,
+// equal says whether item i of the first is item j of the second.
+func diff(n, m int, equal func(i, j int) bool) []DiffHunk {
+	d := &differ{equal: equal, removed: make([]bool, n), added: make([]bool, m)}
+	d.compare(0, n, 0, m)
+
+	hunks := []DiffHunk{}
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && !d.removed[i] && !d.added[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		hunk := DiffHunk{OldStart: i, NewStart: j}
+		for i < n && d.removed[i] {
+			i++
+		}
+		for j < m && d.added[j] {
+			j++
+		}
+		hunk.OldLines, hunk.NewLines = i-hunk.OldStart, j-hunk.NewStart
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// differ marks the items removed from the first sequence and added to the second.
+type differ struct {
+	equal   func(i, j int) bool
+	removed []bool
+	added   []bool
+}
+
+// compare marks what changed between a[aLow:aHigh] and b[bLow:bHigh], splitting them at the middle
+// of the shortest edit until one side is empty.
+func (d *differ) compare(aLow, aHigh, bLow, bHigh int) {
+	for aLow < aHigh && bLow < bHigh && d.equal(aLow, bLow) {
+		aLow, bLow = aLow+1, bLow+1
+	}
+	for aLow < aHigh && bLow < bHigh && d.equal(aHigh-1, bHigh-1) {
+		aHigh, bHigh = aHigh-1, bHigh-1
+	}
+	switch {
+	case aLow == aHigh:
+		for j := bLow; j < bHigh; j++ {
+			d.added[j] = true
+		}
+	case bLow == bHigh:
+		for i := aLow; i < aHigh; i++ {
+			d.removed[i] = true
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLow, aHigh, bLow, bHigh)
+		d.compare(aLow, x, bLow, y)
+		d.compare(u, aHigh, v, bHigh)
+	}
+}
+
+// middleSnake returns where the run of equal items in the middle of the shortest edit starts and ends,
+// found by searching from both ends until the searches meet.
+func (d *differ) middleSnake(aLow, aHigh, bLow, bHigh int) (x, y, u, v int) {
+	n, m := aHigh-aLow, bHigh-bLow
+	delta := n - m
+	odd := delta&1 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for depth := 0; depth <= limit; depth++ {
+		for k := -depth; k <= depth; k += 2 {
+			x := 0
+			if k == -depth || k != depth && forward[offset+k-1] < forward[offset+k+1] {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.equal(aLow+x, bLow+y) {
+				x, y = x+1, y+1
+			}
+			forward[offset+k] = x
+			if reverse := delta - k; odd && reverse >= -(depth-1) && reverse <= depth-1 && x+backward[offset+reverse] >= n {
+				return aLow + startX, bLow + startY, aLow + x, bLow + y
+			}
+		}
+		for k := -depth; k <= depth; k += 2 {
+			x := 0
+			if k == -depth || k != depth && backward[offset+k-1] < backward[offset+k+1] {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.equal(aHigh-1-x, bHigh-1-y) {
+				x, y = x+1, y+1
+			}
+			backward[offset+k] = x
+			if ahead := delta - k; !odd && ahead >= -depth && ahead <= depth && x+forward[offset+ahead] >= n {
+				return aHigh - x, bHigh - y, aHigh - startX, bHigh - startY
+			}
+		}
+	}
+	// The searches always meet, this is only reached if equal isn't consistent.
+	return aLow, bLow, aHigh, bHigh
+}
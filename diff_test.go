@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// applyHunks turns the old lines into the new ones with the hunks of a diff.
+func applyHunks(old, new []string, hunks []DiffHunk) []string {
+	result := []string{}
+	at := 0
+	for _, hunk := range hunks {
+		result = append(result, old[at:hunk.OldStart]...)
+		result = append(result, new[hunk.NewStart:hunk.NewStart+hunk.NewLines]...)
+		at = hunk.OldStart + hunk.OldLines
+	}
+	return append(result, old[at:]...)
+}
+
+// longestCommon returns the length of the longest common subsequence of two texts.
+func longestCommon(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestDiffLines(t *testing.T) {
+	Convey("Diffing lines", t, func() {
+		lines := func(text string) []string { return strings.Split(text, "") }
+
+		Convey("finds nothing in equal texts", func() {
+			So(DiffLines(lines("abc"), lines("abc")), ShouldBeEmpty)
+			So(DiffLines(nil, nil), ShouldBeEmpty)
+		})
+
+		Convey("finds added, removed and changed lines", func() {
+			So(DiffLines(lines("ac"), lines("abc")), ShouldResemble, []DiffHunk{{1, 0, 1, 1}})
+			So(DiffLines(lines("abc"), lines("ac")), ShouldResemble, []DiffHunk{{1, 1, 1, 0}})
+			So(DiffLines(lines("abc"), lines("axc")), ShouldResemble, []DiffHunk{{1, 1, 1, 1}})
+			So(DiffLines(nil, lines("ab")), ShouldResemble, []DiffHunk{{0, 0, 0, 2}})
+			So(DiffLines(lines("ab"), nil), ShouldResemble, []DiffHunk{{0, 2, 0, 0}})
+		})
+
+		Convey("keeps separate changes apart", func() {
+			So(DiffLines(lines("abcdefg"), lines("xbcdeyg")), ShouldResemble, []DiffHunk{{0, 1, 0, 1}, {5, 1, 5, 1}})
+		})
+
+		Convey("finds the shortest edit", func() {
+			random := rand.New(rand.NewSource(1))
+			text := func() []string {
+				result := make([]string, random.Intn(12))
+				for i := range result {
+					result[i] = string(rune('a' + random.Intn(3)))
+				}
+				return result
+			}
+			for i := 0; i < 500; i++ {
+				old, new := text(), text()
+				hunks := DiffLines(old, new)
+				So(applyHunks(old, new, hunks), ShouldResemble, append([]string{}, new...))
+				removed := 0
+				for _, hunk := range hunks {
+					removed += hunk.OldLines
+				}
+				So(len(old)-removed, ShouldEqual, longestCommon(old, new))
+			}
+		})
+	})
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// diffMode is the panes whose buffers are compared side by side, see Editor.DiffThis. The first pane's
+// buffer is the one the others are compared to. blocks are worked out again once one of the buffers
+// changes, topFill is how many filler rows each pane shows above its TopLine to line up with the others.
+type diffMode struct {
+	panes    []*Pane
+	buffers  []*Buffer
+	versions []int
+	lines    [][]string
+	blocks   []diffBlock
+	topFill  map[*Pane]int
+}
+
+// diffBlock is a run of rows of the panes in diff mode, Lines[i] lines of pane i from line Start[i],
+// counted from 0. The panes have the same lines in blocks that aren't Changed, changed blocks are padded
+// with filler rows to the height of the tallest.
+type diffBlock struct {
+	Start   []int
+	Lines   []int
+	Changed bool
+}
+
+// height returns how many rows the block takes.
+func (block diffBlock) height() int {
+	height := 0
+	for _, lines := range block.Lines {
+		if lines > height {
+			height = lines
+		}
+	}
+	return height
+}
+
+// diffBlocks lines up texts by comparing each one to the first, changes that overlap in the first are
+// put in one block.
+func diffBlocks(texts [][]string) []diffBlock {
+	n := len(texts)
+	if n == 0 {
+		return nil
+	}
+	type change struct {
+		DiffHunk
+		text int
+	}
+	changes := []change{}
+	for i := 1; i < n; i++ {
+		for _, hunk := range DiffLines(texts[0], texts[i]) {
+			changes = append(changes, change{hunk, i})
+		}
+	}
+	// Changes are sorted by where they are in the first text, a few at most so insertion sort does.
+	for i := 1; i < len(changes); i++ {
+		for j := i; j > 0 && changes[j].OldStart < changes[j-1].OldStart; j-- {
+			changes[j], changes[j-1] = changes[j-1], changes[j]
+		}
+	}
+
+	blocks := []diffBlock{}
+	at := make([]int, n)
+	unchanged := func(lines int) {
+		if lines <= 0 {
+			return
+		}
+		block := diffBlock{Start: make([]int, n), Lines: make([]int, n)}
+		for i := range at {
+			block.Start[i], block.Lines[i] = at[i], lines
+			at[i] += lines
+		}
+		blocks = append(blocks, block)
+	}
+	for k := 0; k < len(changes); {
+		start, end := changes[k].OldStart, changes[k].OldStart+changes[k].OldLines
+		last := make([]*DiffHunk, n)
+		for ; k < len(changes) && changes[k].OldStart <= end; k++ {
+			if e := changes[k].OldStart + changes[k].OldLines; e > end {
+				end = e
+			}
+			last[changes[k].text] = &changes[k].DiffHunk
+		}
+		unchanged(start - at[0])
+
+		block := diffBlock{Start: make([]int, n), Lines: make([]int, n), Changed: true}
+		for i := range at {
+			stop := at[i] + end - start
+			if hunk := last[i]; hunk != nil {
+				stop = hunk.NewStart + hunk.NewLines + end - hunk.OldStart - hunk.OldLines
+			}
+			block.Start[i], block.Lines[i] = at[i], stop-at[i]
+			at[i] = stop
+		}
+		blocks = append(blocks, block)
+	}
+	unchanged(len(texts[0]) - at[0])
+	return blocks
+}
+
+// DiffThis compares the buffer of the current pane with those of the other panes in diff mode. Long
+// lines aren't wrapped so the panes line up.
+func (editor *Editor) DiffThis() {
+	editor.diffPane(editor.CurrentPane())
+}
+
+// diffPane puts a pane in diff mode.
+func (editor *Editor) diffPane(pane *Pane) {
+	d := &editor.diff
+	if d.index(pane) != -1 {
+		return
+	}
+	if d.topFill == nil {
+		d.topFill = map[*Pane]int{}
+	}
+	d.panes = append(d.panes, pane)
+	d.buffers = nil
+	pane.SetWrap(false)
+}
+
+// DiffOff takes a pane out of diff mode, or every pane if pane is nil.
+func (editor *Editor) DiffOff(pane *Pane) {
+	d := &editor.diff
+	for i := len(d.panes) - 1; i >= 0; i-- {
+		if pane == nil || d.panes[i] == pane {
+			delete(d.topFill, d.panes[i])
+			d.panes = append(d.panes[:i], d.panes[i+1:]...)
+		}
+	}
+	d.buffers = nil
+}
+
+// DiffPanes returns the panes in diff mode.
+func (editor *Editor) DiffPanes() []*Pane {
+	return editor.diff.panes
+}
+
+// DiffFiles opens files in panes side by side, from left to right, and compares them.
+func (editor *Editor) DiffFiles(filenames []string) error {
+	if len(filenames) < 2 {
+		return errors.New("Diff mode needs at least two files")
+	}
+	editor.OpenFile(filenames[len(filenames)-1])
+	panes := []*Pane{editor.CurrentPane()}
+	for i := len(filenames) - 2; i >= 0; i-- {
+		editor.SplitPane(true)
+		editor.OpenFile(filenames[i])
+		panes = append([]*Pane{editor.CurrentPane()}, panes...)
+	}
+	for _, pane := range panes {
+		editor.diffPane(pane)
+	}
+	return nil
+}
+
+// index returns where a pane is in diff mode, -1 if it isn't.
+func (d *diffMode) index(pane *Pane) int {
+	for i, p := range d.panes {
+		if p == pane {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateDiff forgets the panes that were closed and compares the buffers again if they changed.
+func (editor *Editor) updateDiff() {
+	d := &editor.diff
+	for _, pane := range append([]*Pane{}, d.panes...) {
+		if !editor.hasPane(pane) || pane.Buffer() == nil {
+			editor.DiffOff(pane)
+		}
+	}
+	changed := len(d.buffers) != len(d.panes)
+	for i, pane := range d.panes {
+		if !changed && (d.buffers[i] != pane.Buffer() || d.versions[i] != pane.Buffer().version) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	d.buffers, d.versions, d.lines = nil, nil, nil
+	for _, pane := range d.panes {
+		buffer := pane.Buffer()
+		d.buffers = append(d.buffers, buffer)
+		d.versions = append(d.versions, buffer.version)
+		d.lines = append(d.lines, bufferLines(buffer, 1, buffer.LineCount()))
+	}
+	d.blocks = diffBlocks(d.lines)
+}
+
+// row returns the row of a line of pane i counting the filler rows, lines past the end give the number of rows.
+func (d *diffMode) row(i, line int) int {
+	row := 0
+	for _, block := range d.blocks {
+		if line-1 >= block.Start[i] && line-1 < block.Start[i]+block.Lines[i] {
+			return row + line - 1 - block.Start[i]
+		}
+		row += block.height()
+	}
+	return row
+}
+
+// lineAt returns the line of pane i on a row and how many filler rows come before it from there.
+func (d *diffMode) lineAt(i, row int) (line, fill int) {
+	top := 0
+	for _, block := range d.blocks {
+		height := block.height()
+		if row < top+height {
+			offset := row - top
+			if offset < block.Lines[i] {
+				return block.Start[i] + offset + 1, 0
+			}
+			line, fill = block.Start[i]+block.Lines[i]+1, height-offset
+			break
+		}
+		top += height
+	}
+	if count := len(d.lines[i]); line == 0 || line > count {
+		line, fill = count, 0
+	}
+	if line < 1 {
+		line = 1
+	}
+	return line, fill
+}
+
+// fillers returns how many filler rows pane i has above each of its lines, after the last line for one more
+// than the line count.
+func (d *diffMode) fillers(i int) map[int]int {
+	fillers := map[int]int{}
+	for _, block := range d.blocks {
+		if extra := block.height() - block.Lines[i]; extra > 0 {
+			fillers[block.Start[i]+block.Lines[i]+1] += extra
+		}
+	}
+	return fillers
+}
+
+// syncDiff scrolls the panes in diff mode and moves their cursors to line up with the current pane.
+func (editor *Editor) syncDiff() {
+	editor.updateDiff()
+	d := &editor.diff
+	current := editor.CurrentPane()
+	i := d.index(current)
+	if i == -1 || current.Cursor() == nil {
+		return
+	}
+	settings := editor.Settings()
+	if height := current.View().Height; height > 0 {
+		UpdateTopLine(settings, current, height-1)
+	}
+	d.topFill[current] = 0
+	if current.TopLine() == 1 {
+		d.topFill[current] = d.fillers(i)[1]
+	}
+	top := d.row(i, current.TopLine()) - d.topFill[current]
+	x, cursorLine := current.Cursor().Position()
+	cursorRow := d.row(i, cursorLine)
+
+	for j, pane := range d.panes {
+		if j == i || pane.Cursor() == nil {
+			continue
+		}
+		line, fill := d.lineAt(j, top)
+		pane.SetTopLine(line)
+		d.topFill[pane] = fill
+		line, _ = d.lineAt(j, cursorRow)
+		column := x
+		if line <= len(d.lines[j]) && column >= len([]rune(d.lines[j][line-1])) {
+			column = len([]rune(d.lines[j][line-1])) - 1
+		}
+		if column < 0 {
+			column = 0
+		}
+		pane.Cursor().Move(column, line)
+	}
+}
+
+// layoutDiffRows adds the filler rows of a pane in diff mode to the rows laid out for it.
+func (editor *Editor) layoutDiffRows(pane *Pane, rows []ScreenRow, height int) []ScreenRow {
+	d := &editor.diff
+	i := d.index(pane)
+	if i == -1 || len(d.buffers) != len(d.panes) {
+		return rows
+	}
+	fillers := d.fillers(i)
+	filler := func(result []ScreenRow, n int) []ScreenRow {
+		for ; n > 0; n-- {
+			result = append(result, ScreenRow{Filler: true})
+		}
+		return result
+	}
+	result := []ScreenRow{}
+	for _, row := range rows {
+		if !row.Continuation && row.Line != 0 {
+			n := fillers[row.Line]
+			if row.Line == pane.TopLine() {
+				n = d.topFill[pane]
+			}
+			result = filler(result, n)
+		}
+		result = append(result, row)
+	}
+	if count := pane.Buffer().LineCount(); len(rows) == 0 || rows[len(rows)-1].Line == count {
+		result = filler(result, fillers[count+1])
+	}
+	if len(result) > height {
+		result = result[:height]
+	}
+	return result
+}
+
+// RenderDiff highlights the changes in a pane in diff mode. Lines only this pane has are added, the others
+// in changed blocks are changed with the characters that differ highlighted.
+func (grid *RuneGrid) RenderDiff(editor *Editor, pane *Pane) {
+	d := &editor.diff
+	i := d.index(pane)
+	if i == -1 || len(d.buffers) != len(d.panes) {
+		return
+	}
+	settings := editor.Settings()
+	view := pane.View()
+	x2 := view.X + view.Width - 1
+	for y, row := range view.Rows {
+		y += view.Y
+		if row.Filler {
+			grid.DrawHorizontalLine(view.X, x2, y, '-')
+			grid.FillStyle(view.X, y, x2, y, StyleDiffDelete)
+			continue
+		}
+		if row.Line == 0 || row.Fold != nil {
+			continue
+		}
+		style, other := d.lineStyle(i, row.Line)
+		if style == StyleNormal {
+			continue
+		}
+		grid.FillStyle(view.X, y, x2, y, style)
+		if style != StyleDiffChange {
+			continue
+		}
+		text := d.lines[i][row.Line-1]
+		for _, span := range changedRunes(text, other) {
+			for index := span[0]; index < span[1]; index++ {
+				x := view.X + row.Prefix + DisplayColumn(settings, text, index) - row.Start
+				if x >= view.X && x <= x2 {
+					grid.SetStyle(x, y, StyleDiffText)
+				}
+			}
+		}
+	}
+}
+
+// lineStyle returns how a line of pane i is highlighted and the line it's compared to for changed ones.
+func (d *diffMode) lineStyle(i, line int) (Style, string) {
+	for _, block := range d.blocks {
+		offset := line - 1 - block.Start[i]
+		if offset < 0 || offset >= block.Lines[i] {
+			continue
+		}
+		if !block.Changed {
+			return StyleNormal, ""
+		}
+		text := d.lines[i][line-1]
+		others := []string{}
+		differs := false
+		for j := range d.panes {
+			if j != i && offset < block.Lines[j] {
+				other := d.lines[j][block.Start[j]+offset]
+				others = append(others, other)
+				differs = differs || other != text
+			}
+		}
+		switch {
+		case len(others) == 0:
+			return StyleDiffAdd, ""
+		case !differs:
+			return StyleNormal, ""
+		}
+		return StyleDiffChange, others[0]
+	}
+	return StyleNormal, ""
+}
+
+// changedRunes returns the ranges of runes of a line that aren't in the line it's compared to.
+func changedRunes(text, other string) [][2]int {
+	a, b := []rune(text), []rune(other)
+	spans := [][2]int{}
+	for _, hunk := range diff(len(a), len(b), func(i, j int) bool { return a[i] == b[j] }) {
+		if hunk.OldLines > 0 {
+			spans = append(spans, [2]int{hunk.OldStart, hunk.OldStart + hunk.OldLines})
+		}
+	}
+	return spans
+}
+
+// diffBlockHere returns the changed block at the cursor of the current pane, a block the pane has no lines
+// in is at the line below its filler rows.
+func (editor *Editor) diffBlockHere() (int, diffBlock, error) {
+	editor.updateDiff()
+	d := &editor.diff
+	i := d.index(editor.CurrentPane())
+	if i == -1 {
+		return -1, diffBlock{}, errors.New("Not in diff mode")
+	}
+	_, line := editor.CurrentPane().Cursor().Position()
+	for _, block := range d.blocks {
+		first, last := block.Start[i]+1, block.Start[i]+block.Lines[i]
+		if block.Lines[i] == 0 {
+			first = block.Start[i] + 1
+			if first > len(d.lines[i]) {
+				first = len(d.lines[i])
+			}
+			last = first
+		}
+		if block.Changed && line >= first && line <= last {
+			return i, block, nil
+		}
+	}
+	return i, diffBlock{}, errors.New("No difference here")
+}
+
+// NextDiff moves the cursor to the start of the next changed block, or the previous one if count is negative.
+func (editor *Editor) NextDiff(count int) error {
+	editor.updateDiff()
+	d := &editor.diff
+	i := d.index(editor.CurrentPane())
+	if i == -1 {
+		return errors.New("Not in diff mode")
+	}
+	cursor := editor.CurrentPane().Cursor()
+	_, line := cursor.Position()
+	target := 0
+	for _, block := range d.blocks {
+		if !block.Changed {
+			continue
+		}
+		start := block.Start[i] + 1
+		if start > len(d.lines[i]) {
+			start = len(d.lines[i])
+		}
+		switch {
+		case count > 0 && start > line && target == 0:
+			target = start
+		case count < 0 && start < line:
+			target = start
+		}
+	}
+	if target == 0 {
+		return errors.New("No more differences")
+	}
+	cursor.Move(0, target)
+	return nil
+}
+
+// diffOther returns the other pane of diff mode, the one with the buffer of a number if it's given.
+func (editor *Editor) diffOther(i int, bufferNumber string) (int, error) {
+	d := &editor.diff
+	if bufferNumber != "" {
+		for j, pane := range d.panes {
+			if j != i && strconv.Itoa(pane.Buffer().ID()) == bufferNumber {
+				return j, nil
+			}
+		}
+		return -1, fmt.Errorf("No buffer %s in diff mode", bufferNumber)
+	}
+	switch len(d.panes) {
+	case 1:
+		return -1, errors.New("No other buffer in diff mode")
+	case 2:
+		return 1 - i, nil
+	}
+	return -1, errors.New("More than two buffers in diff mode, give the number of one")
+}
+
+// DiffGet replaces the lines of the changed block at the cursor with those of another buffer in diff
+// mode, or puts them in the other buffer if put is set. The other buffer is given by its number
+// unless there are only two.
+func (editor *Editor) DiffGet(bufferNumber string, put bool) error {
+	i, block, err := editor.diffBlockHere()
+	if err != nil {
+		return err
+	}
+	j, err := editor.diffOther(i, bufferNumber)
+	if err != nil {
+		return err
+	}
+	from, to := j, i
+	if put {
+		from, to = i, j
+	}
+	d := &editor.diff
+	lines := append([]string{}, d.lines[from][block.Start[from]:block.Start[from]+block.Lines[from]]...)
+	buffer := d.panes[to].Buffer()
+	if block.Lines[to] == 0 {
+		err = buffer.InsertLines(block.Start[to], lines)
+	} else {
+		err = buffer.ReplaceLines(block.Start[to]+1, block.Start[to]+block.Lines[to], lines)
+	}
+	if err != nil {
+		return err
+	}
+	editor.updateDiff()
+	return nil
+}
+
+// diffThisCommand puts the current pane in diff mode.
+func diffThisCommand(editor *Editor, command Command) error {
+	editor.DiffThis()
+	return nil
+}
+
+// diffOffCommand takes the current pane out of diff mode, ":diffoff!" every pane.
+func diffOffCommand(editor *Editor, command Command) error {
+	if command.Bang {
+		editor.DiffOff(nil)
+	} else {
+		editor.DiffOff(editor.CurrentPane())
+	}
+	return nil
+}
+
+// diffUpdateCommand compares the buffers in diff mode again.
+func diffUpdateCommand(editor *Editor, command Command) error {
+	editor.diff.buffers = nil
+	editor.updateDiff()
+	return nil
+}
+
+// diffGetCommand takes the lines of the block at the cursor from another buffer, ":diffget [bufnr]".
+func diffGetCommand(editor *Editor, command Command) error {
+	return editor.DiffGet(strings.TrimSpace(command.Args), false)
+}
+
+// diffPutCommand puts the lines of the block at the cursor in another buffer, ":diffput [bufnr]".
+func diffPutCommand(editor *Editor, command Command) error {
+	return editor.DiffGet(strings.TrimSpace(command.Args), true)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiffBlocks(t *testing.T) {
+	Convey("Diff blocks", t, func() {
+		lines := func(text string) []string { return strings.Split(text, "") }
+
+		Convey("line up two texts", func() {
+			So(diffBlocks([][]string{lines("abcd"), lines("aXcYd")}), ShouldResemble, []diffBlock{
+				{Start: []int{0, 0}, Lines: []int{1, 1}},
+				{Start: []int{1, 1}, Lines: []int{1, 1}, Changed: true},
+				{Start: []int{2, 2}, Lines: []int{1, 1}},
+				{Start: []int{3, 3}, Lines: []int{0, 1}, Changed: true},
+				{Start: []int{3, 4}, Lines: []int{1, 1}},
+			})
+		})
+
+		Convey("put changes to the same lines of three texts together", func() {
+			So(diffBlocks([][]string{lines("abc"), lines("aXYc"), lines("ac")}), ShouldResemble, []diffBlock{
+				{Start: []int{0, 0, 0}, Lines: []int{1, 1, 1}},
+				{Start: []int{1, 1, 1}, Lines: []int{1, 2, 0}, Changed: true},
+				{Start: []int{2, 3, 1}, Lines: []int{1, 1, 1}},
+			})
+		})
+
+		Convey("are empty for equal texts", func() {
+			So(diffBlocks([][]string{nil, nil}), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestDiffMode(t *testing.T) {
+	Convey("Two files in diff mode", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{
+			"a.txt": []byte("one\ntwo\nthree\nfour\nfive\n"),
+			"b.txt": []byte("one\nTwo\nthree\nextra\nfour\nfive\n"),
+		})
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		So(editor.DiffFiles([]string{"a.txt", "b.txt"}), ShouldBeNil)
+		panes := editor.DiffPanes()
+		So(panes, ShouldHaveLength, 2)
+		left, right := panes[0], panes[1]
+		So(editor.CurrentPane(), ShouldEqual, left)
+		So(left.Buffer().Filename(), ShouldEqual, "a.txt")
+		So(right.Buffer().Filename(), ShouldEqual, "b.txt")
+		grid := NewRuneGrid(21, 8)
+		grid.RenderEditor(&editor)
+		row := func(y int) string { return strings.Replace(string(grid.Cells()[y]), "\x00", " ", -1) }
+
+		Convey("lines them up with filler rows", func() {
+			So(row(0), ShouldEqual, "one       │one       ")
+			So(row(3), ShouldEqual, "----------│extra     ")
+			So(row(4), ShouldEqual, "four      │four      ")
+			So(grid.Style(0, 3), ShouldEqual, StyleDiffDelete)
+			So(grid.Style(11, 3), ShouldEqual, StyleDiffAdd)
+			So(grid.Style(0, 4), ShouldEqual, StyleNormal)
+		})
+
+		Convey("highlights the characters that changed", func() {
+			So(grid.Style(0, 1), ShouldEqual, StyleDiffText)
+			So(grid.Style(1, 1), ShouldEqual, StyleDiffChange)
+			So(grid.Style(11, 1), ShouldEqual, StyleDiffText)
+			So(grid.Style(12, 1), ShouldEqual, StyleDiffChange)
+		})
+
+		Convey("moves the other cursor to the same row", func() {
+			left.Cursor().Move(1, 4)
+			grid.RenderEditor(&editor)
+			x, line := right.Cursor().Position()
+			So(x, ShouldEqual, 1)
+			So(line, ShouldEqual, 5)
+		})
+
+		Convey("moves between changes", func() {
+			editor.HandleNormalKey(']')
+			editor.HandleNormalKey('c')
+			_, line := left.Cursor().Position()
+			So(line, ShouldEqual, 2)
+			So(editor.NextDiff(1), ShouldBeNil)
+			_, line = left.Cursor().Position()
+			So(line, ShouldEqual, 4)
+			So(editor.NextDiff(1).Error(), ShouldEqual, "No more differences")
+			editor.HandleNormalKey('[')
+			editor.HandleNormalKey('c')
+			_, line = left.Cursor().Position()
+			So(line, ShouldEqual, 2)
+		})
+
+		Convey("gets a change from the other file", func() {
+			left.Cursor().Move(0, 2)
+			editor.HandleNormalKey('d')
+			editor.HandleNormalKey('o')
+			So(string(left.Buffer().data), ShouldEqual, "one\nTwo\nthree\nfour\nfive\n")
+			So(left.Buffer().Modified(), ShouldBeTrue)
+
+			Convey("and lines only it has", func() {
+				left.Cursor().Move(0, 4)
+				So(editor.ExecuteCommand("diffget"), ShouldBeNil)
+				So(string(left.Buffer().data), ShouldEqual, "one\nTwo\nthree\nextra\nfour\nfive\n")
+				So(editor.ExecuteCommand("diffget").Error(), ShouldEqual, "No difference here")
+			})
+		})
+
+		Convey("puts a change in the other file", func() {
+			left.Cursor().Move(0, 3)
+			So(editor.ExecuteCommand("diffget").Error(), ShouldEqual, "No difference here")
+			left.Cursor().Move(0, 4)
+			editor.HandleNormalKey('d')
+			editor.HandleNormalKey('p')
+			So(string(right.Buffer().data), ShouldEqual, "one\nTwo\nthree\nfour\nfive\n")
+		})
+
+		Convey("is compared again after an edit", func() {
+			right.Buffer().ReplaceLines(2, 2, []string{"two"})
+			grid.Clear()
+			grid.RenderEditor(&editor)
+			So(row(1), ShouldEqual, "two       │two       ")
+			So(grid.Style(0, 1), ShouldEqual, StyleNormal)
+		})
+
+		Convey("scrolls the panes together", func() {
+			lines := []string{}
+			for i := 1; i <= 20; i++ {
+				lines = append(lines, fmt.Sprint("line ", i))
+			}
+			left.Buffer().InsertLines(5, lines)
+			right.Buffer().InsertLines(6, lines)
+			left.Cursor().Move(0, 20)
+			grid.RenderEditor(&editor)
+			So(right.TopLine(), ShouldEqual, left.TopLine()+1)
+			texts := strings.Split(row(0), "│")
+			So(strings.TrimSpace(texts[0]), ShouldEqual, strings.TrimSpace(texts[1]))
+		})
+
+		Convey("is turned off", func() {
+			So(editor.ExecuteCommand("diffoff!"), ShouldBeNil)
+			So(editor.DiffPanes(), ShouldBeEmpty)
+			grid.Clear()
+			grid.RenderEditor(&editor)
+			So(row(3), ShouldEqual, "four      │extra     ")
+			So(editor.NextDiff(1).Error(), ShouldEqual, "Not in diff mode")
+
+			Convey("and on again", func() {
+				So(editor.ExecuteCommand("diffthis"), ShouldBeNil)
+				editor.CyclePane(1)
+				So(editor.ExecuteCommand("diffthis"), ShouldBeNil)
+				So(editor.DiffPanes(), ShouldResemble, []*Pane{left, right})
+			})
+		})
+
+		Convey("forgets closed panes", func() {
+			editor.ClosePane(right)
+			grid.RenderEditor(&editor)
+			So(editor.DiffPanes(), ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Three files in diff mode", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{
+			"ours.txt":   []byte("a\nours\nc\n"),
+			"merged.txt": []byte("a\nbase\nc\n"),
+			"theirs.txt": []byte("a\ntheirs\nc\n"),
+		})
+		editor := NewEditor(fs)
+		So(editor.DiffFiles([]string{"ours.txt", "merged.txt", "theirs.txt"}), ShouldBeNil)
+		editor.CyclePane(1)
+		merged := editor.CurrentPane()
+		So(merged.Buffer().Filename(), ShouldEqual, "merged.txt")
+		merged.Cursor().Move(0, 2)
+
+		Convey("need the buffer to get from", func() {
+			So(editor.DiffGet("", false).Error(), ShouldEqual, "More than two buffers in diff mode, give the number of one")
+			theirs := editor.DiffPanes()[2].Buffer()
+			So(editor.ExecuteCommand(fmt.Sprint("diffget ", theirs.ID())), ShouldBeNil)
+			So(string(merged.Buffer().data), ShouldEqual, "a\ntheirs\nc\n")
+			So(editor.ExecuteCommand("diffget 99").Error(), ShouldEqual, "No buffer 99 in diff mode")
+		})
+	})
+
+	Convey("Diff mode needs two files", t, func() {
+		editor := NewEditor(GetCustomTestFs(map[string][]byte{}))
+		So(editor.DiffFiles([]string{"a.txt"}).Error(), ShouldEqual, "Diff mode needs at least two files")
+	})
+}
@@ -1,34 +1,98 @@
 package main
 
-import "github.com/spf13/afero"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dcbishop/jkl/plugin"
+	"github.com/dcbishop/jkl/script"
+	"github.com/spf13/afero"
+)
 
 // Settings stores settings for the editor
 // Borders draws pretty borders around panes but takes up some screen space.
 // OuterBorder when false turns off just the outer border.
 // ShiftWidth is the number of spaces each tab will be displayed as.
 // ScrollOffset is the minimum number of lines that will be visible above or below the cursor.
+// StatusLine is the format of the line drawn under each pane, see FormatStatusLine. Empty hides it.
+// Number shows line numbers, RelativeNumber shows them relative to the cursor, both together is hybrid.
+// SignColumn is "yes", "no" or "auto" to only show it when the buffer has signs.
+// FoldColumn is the width of the column showing folds, 0 hides it.
+// Wrap soft wraps long lines at word boundaries, otherwise panes scroll sideways.
+// SideScrollOffset is the minimum number of columns that will be visible left or right of the cursor.
+// ShowBreak is drawn at the start of wrapped lines and BreakIndent indents them to match the line.
+// FoldMethod is "manual", "indent", "marker" or "syntax", FoldMarker is the start and end marker separated by a comma.
+// TimeoutLength is how many milliseconds to wait for the rest of a key sequence.
+// Mouse lets the mouse move the cursor, select, scroll and resize panes.
+// Shell is the program that runs external commands, started with "-c" and the command line.
+// CompleteKeys are the keys of insert mode completion, "vim" or "tab", and AutoComplete starts it while typing.
+// GitBase is what the signs of changed lines compare buffers to, the "index" or "head".
+// Options added by plugins are kept in options by name.
 type Settings struct {
-	Borders      bool
-	OuterBorder  bool
-	ShiftWidth   int
-	ScrollOffset int
+	Borders          bool
+	OuterBorder      bool
+	ShiftWidth       int
+	ScrollOffset     int
+	StatusLine       string
+	Number           bool
+	RelativeNumber   bool
+	SignColumn       string
+	FoldColumn       int
+	Wrap             bool
+	SideScrollOffset int
+	ShowBreak        string
+	BreakIndent      bool
+	FoldMethod       string
+	FoldMarker       string
+	TimeoutLength    int
+	Mouse            bool
+	Shell            string
+	CompleteKeys     string
+	AutoComplete     bool
+	GitBase          string
+	options          map[string]interface{}
 }
 
 // DefaultSettings constructs a default settings.
 func DefaultSettings() Settings {
 	return Settings{
-		Borders:      true,
-		OuterBorder:  true,
-		ShiftWidth:   4,
-		ScrollOffset: 0,
+		Borders:       true,
+		OuterBorder:   true,
+		ShiftWidth:    4,
+		ScrollOffset:  0,
+		StatusLine:    DefaultStatusLine,
+		SignColumn:    "auto",
+		Wrap:          true,
+		ShowBreak:     "↪ ",
+		BreakIndent:   true,
+		FoldMethod:    "manual",
+		FoldMarker:    "{{{,}}}",
+		TimeoutLength: 1000,
+		Mouse:         true,
+		Shell:         defaultShell(),
+		CompleteKeys:  "vim",
+		GitBase:       "index",
 	}
 }
 
+// defaultShell is $SHELL, or sh if it isn't set.
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
 // Cursor stores a position in a buffer and handles movement.
+// Closed folds are treated as a single line when moving up and down.
 type Cursor struct {
 	x      int
 	line   int
 	buffer *Buffer
+	folds  *Folds
 }
 
 // Position reutrns the cursors current position.
@@ -44,19 +108,21 @@ func (cursor *Cursor) Move(xPos int, lineNumber int) {
 
 // DownLine returns the cursors position one line down.
 func (cursor *Cursor) DownLine() (xPos int, lineNumber int) {
-	_, err := cursor.buffer.GetLine(cursor.line + 2)
+	next := cursor.folds.NextLine(cursor.line + 1)
+	_, err := cursor.buffer.GetLine(next)
 	if err != nil {
 		return cursor.Position()
 	}
-	return cursor.x, cursor.line + 2
+	return cursor.x, next
 }
 
 // UpLine returns the cursors position one line up.
 func (cursor *Cursor) UpLine() (xPos int, lineNumber int) {
-	if cursor.line == 0 {
+	previous := cursor.folds.PreviousLine(cursor.line + 1)
+	if previous < 1 {
 		return cursor.Position()
 	}
-	return cursor.x, cursor.line
+	return cursor.x, previous
 }
 
 // BackCharacter returns the cursors position one character back.
@@ -89,9 +155,16 @@ func (cursor *Cursor) EndOfLine() (xPos int, lineNumber int) {
 
 // Pane represents a 'Window' in the editor. It has a Buffer.
 type Pane struct {
-	buffer  *Buffer
-	cursors map[*Buffer]*Cursor
-	topLine int
+	buffer     *Buffer
+	alternate  *Buffer
+	cursors    map[*Buffer]*Cursor
+	folds      map[*Buffer]*Folds
+	topLine    int
+	leftColumn int
+	statusLine string
+	wrap       *bool
+	view       PaneView
+	visual     Cursor
 }
 
 // NewPane constructs and initilizes a NewPane
@@ -99,6 +172,7 @@ func NewPane() Pane {
 	return Pane{
 		buffer:  nil,
 		cursors: make(map[*Buffer]*Cursor),
+		folds:   make(map[*Buffer]*Folds),
 		topLine: 1,
 	}
 }
@@ -114,10 +188,35 @@ func (pane *Pane) Buffer() *Buffer {
 }
 
 // SetBuffer binds a Buffer to the Pane and creates a Cursor if needed.
+// The previous Buffer becomes the Pane's alternate buffer.
 func (pane *Pane) SetBuffer(buffer *Buffer) {
+	if pane.buffer != nil && pane.buffer != buffer {
+		pane.alternate = pane.buffer
+	}
+
 	pane.buffer = buffer
-	if pane.Cursor() == nil {
-		pane.cursors[pane.buffer] = &Cursor{buffer: pane.buffer}
+	if pane.buffer != nil && pane.Cursor() == nil {
+		pane.folds[pane.buffer] = &Folds{}
+		pane.cursors[pane.buffer] = &Cursor{buffer: pane.buffer, folds: pane.folds[pane.buffer]}
+	}
+}
+
+// Folds returns the folds of the Pane's current buffer.
+func (pane *Pane) Folds() *Folds {
+	return pane.folds[pane.Buffer()]
+}
+
+// AlternateBuffer returns the Buffer that was shown in the Pane before the current one.
+func (pane *Pane) AlternateBuffer() *Buffer {
+	return pane.alternate
+}
+
+// forgetBuffer removes any reference the Pane holds to the given Buffer.
+func (pane *Pane) forgetBuffer(buffer *Buffer) {
+	delete(pane.cursors, buffer)
+	delete(pane.folds, buffer)
+	if pane.alternate == buffer {
+		pane.alternate = nil
 	}
 }
 
@@ -131,23 +230,98 @@ func (pane *Pane) SetTopLine(topLine int) {
 	pane.topLine = topLine
 }
 
+// Mode is the input mode the editor is in.
+type Mode int
+
+// NormalMode is the default mode, CommandMode is entered with ':' to type an ex command,
+// VisualMode selects text from where it was entered to the cursor, TerminalMode sends keys to a terminal
+// and InsertMode types text into the buffer.
+const (
+	NormalMode Mode = iota
+	CommandMode
+	VisualMode
+	TerminalMode
+	InsertMode
+)
+
+// String returns the name of the mode, ie "normal".
+func (mode Mode) String() string {
+	switch mode {
+	case CommandMode:
+		return "command"
+	case VisualMode:
+		return "visual"
+	case TerminalMode:
+		return "terminal"
+	case InsertMode:
+		return "insert"
+	}
+	return "normal"
+}
+
+// StatusLine returns the Pane's own status line format, empty if it uses the default.
+func (pane *Pane) StatusLine() string {
+	return pane.statusLine
+}
+
+// SetStatusLine overrides the status line format for this Pane.
+func (pane *Pane) SetStatusLine(format string) {
+	pane.statusLine = format
+}
+
 // Editor is the core of Jkl. Maintains buffers, panes and manipluates them.
 type Editor struct {
-	fs          afero.Fs
-	currentPane *Pane
-	buffers     []*Buffer
-	panes       []*Pane
-	settings    Settings
+	fs            afero.Fs
+	currentPane   *Pane
+	buffers       []*Buffer
+	panes         []*Pane
+	layout        *Split
+	settings      Settings
+	lastBufferID  int
+	mode          Mode
+	commands      []*CommandDefinition
+	commandLine   CommandLine
+	message       Message
+	messages      []Message
+	normalMap     map[string]NormalCommand
+	pendingKeys   string
+	mouse         mouseState
+	suspender     Suspender
+	detacher      Detacher
+	post          func(f func())
+	register      string
+	joinEdits     bool
+	events        EventBus
+	watch         eventWatch
+	autocommands  []autocommand
+	statusItems   map[string]func(plugin.Buffer) string
+	floats        []*Float
+	focus         []*Float
+	plugins       []*PluginProcess
+	languages     languageClient
+	git           gitClient
+	diff          diffMode
+	conflicts     map[*Buffer]*conflictFile
+	completion    completion
+	picker        *pickerState
+	script        *script.Interpreter
+	scriptObjects map[interface{}]*script.Table
+	scriptAdded   map[string]bool
 }
 
 // New constructs a new editor.
 func NewEditor(filesystem afero.Fs) Editor {
 	pane := NewPane()
-	return Editor{
+	editor := Editor{
 		fs:          filesystem,
 		currentPane: &pane,
+		panes:       []*Pane{&pane},
+		layout:      &Split{Pane: &pane},
 		settings:    DefaultSettings(),
 	}
+	editor.registerDefaultCommands()
+	editor.registerDefaultKeys()
+	return editor
 }
 
 // SetFS sets the filesystem handler of the Editor.
@@ -165,6 +339,8 @@ func (editor *Editor) OpenFiles(filenames []string) {
 	for i, filename := range filenames {
 		newBuffer := editor.openFile(filename)
 		buffer := editor.AddBuffer(&newBuffer)
+		editor.events.Publish(EditorEvent{Name: BufRead, Buffer: buffer})
+		editor.checkConflicts(buffer)
 
 		if i == 0 {
 			editor.CurrentPane().SetBuffer(buffer)
@@ -188,14 +364,45 @@ func (editor *Editor) openFile(filename string) Buffer {
 	return buffer
 }
 
+// WriteBuffer writes the buffer to the file, or to the buffer's own file if it's empty.
+// A buffer without a file takes the name it's written to.
+func (editor *Editor) WriteBuffer(buffer *Buffer, filename string) error {
+	if filename == "" {
+		filename = buffer.Filename()
+	}
+	switch {
+	case filename == "":
+		return errors.New("No file name")
+	case buffer.Terminal() != nil:
+		return errors.New("Cannot write a terminal")
+	}
+
+	editor.events.Publish(EditorEvent{Name: BufWritePre, Buffer: buffer})
+	if err := afero.WriteFile(editor.fs, filename, buffer.data, 0644); err != nil {
+		return fmt.Errorf("Cannot write %s: %s", filename, err)
+	}
+	if buffer.Filename() == "" {
+		buffer.SetFilename(filename)
+	}
+	if filename == buffer.Filename() {
+		buffer.SetModified(false)
+	}
+	editor.events.Publish(EditorEvent{Name: BufWritePost, Buffer: buffer})
+	return nil
+}
+
 // OpenFile opens a file and sets it to the current buffer.
 func (editor *Editor) OpenFile(filename string) {
 	editor.OpenFiles([]string{filename})
 }
 
-// AddBuffer adds a buffer to the list of buffers.
+// AddBuffer adds a buffer to the list of buffers and gives it a number.
 func (editor *Editor) AddBuffer(buffer *Buffer) *Buffer {
+	editor.lastBufferID++
+	buffer.id = editor.lastBufferID
+	buffer.listed = true
 	editor.buffers = append(editor.buffers, buffer)
+	editor.events.Publish(EditorEvent{Name: BufNew, Buffer: buffer})
 	return editor.LastBuffer()
 }
 
@@ -223,3 +430,221 @@ func (editor *Editor) Buffers() []*Buffer {
 func (editor *Editor) Panes() []*Pane {
 	return editor.panes
 }
+
+// Mode returns the current input mode.
+func (editor *Editor) Mode() Mode {
+	return editor.mode
+}
+
+// SetMode changes the current input mode.
+func (editor *Editor) SetMode(mode Mode) {
+	if mode == editor.mode {
+		return
+	}
+	editor.mode = mode
+	editor.events.Publish(EditorEvent{Name: ModeChanged, Mode: mode})
+}
+
+// BufferByID returns the buffer with the given number or nil if there isn't one.
+func (editor *Editor) BufferByID(id int) *Buffer {
+	for _, buffer := range editor.buffers {
+		if buffer.ID() == id {
+			return buffer
+		}
+	}
+	return nil
+}
+
+// FindBuffer finds a buffer by its number or a partial name.
+// A full name match is preferred over partial matches, an error is returned if the name is ambiguous.
+func (editor *Editor) FindBuffer(name string) (*Buffer, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		if buffer := editor.BufferByID(id); buffer != nil {
+			return buffer, nil
+		}
+		return nil, fmt.Errorf("Buffer %d does not exist", id)
+	}
+
+	matches := editor.MatchBuffers(name)
+	for _, buffer := range matches {
+		if buffer.Name() == name {
+			return buffer, nil
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No matching buffer for %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("More than one match for %s", name)
+}
+
+// MatchBuffers returns the listed buffers whose name contains the given text.
+func (editor *Editor) MatchBuffers(partial string) []*Buffer {
+	matches := []*Buffer{}
+	for _, buffer := range editor.buffers {
+		if buffer.Listed() && strings.Contains(buffer.Name(), partial) {
+			matches = append(matches, buffer)
+		}
+	}
+	return matches
+}
+
+// BufferVisible returns true if the buffer is shown in any pane.
+func (editor *Editor) BufferVisible(buffer *Buffer) bool {
+	for _, pane := range editor.panes {
+		if pane.Buffer() == buffer {
+			return true
+		}
+	}
+	return false
+}
+
+// SwitchToBuffer shows the given buffer in the current pane.
+func (editor *Editor) SwitchToBuffer(buffer *Buffer) {
+	buffer.listed = true
+	editor.CurrentPane().SetBuffer(buffer)
+}
+
+// SwitchToAlternateBuffer shows the current pane's alternate buffer.
+func (editor *Editor) SwitchToAlternateBuffer() error {
+	alternate := editor.CurrentPane().AlternateBuffer()
+	if alternate == nil {
+		return errors.New("No alternate file")
+	}
+	editor.SwitchToBuffer(alternate)
+	return nil
+}
+
+// DeleteBuffer unlists the buffer and removes it from any panes showing it.
+// Unless force is set an error is returned if the buffer has unsaved changes.
+func (editor *Editor) DeleteBuffer(buffer *Buffer, force bool) error {
+	if buffer.Modified() && !force {
+		return fmt.Errorf("No write since last change for buffer %d (add ! to override)", buffer.ID())
+	}
+	if terminal := buffer.Terminal(); terminal != nil && terminal.Running() {
+		if !force {
+			return fmt.Errorf("Job still running in buffer %d (add ! to end the job)", buffer.ID())
+		}
+		terminal.Stop()
+	}
+
+	buffer.listed = false
+	editor.removeFromPanes(buffer)
+
+	editor.events.Publish(EditorEvent{Name: BufDelete, Buffer: buffer})
+	return nil
+}
+
+// WhenDeleted calls f the next time the buffer is deleted or wiped.
+func (editor *Editor) WhenDeleted(buffer *Buffer, f func()) {
+	var id int
+	id = editor.events.Subscribe(BufDelete, "", func(event EditorEvent) {
+		if event.Buffer == buffer {
+			editor.events.Unsubscribe(id)
+			f()
+		}
+	})
+}
+
+// WipeBuffer deletes the buffer and removes it from the list of buffers completely.
+func (editor *Editor) WipeBuffer(buffer *Buffer, force bool) error {
+	if err := editor.DeleteBuffer(buffer, force); err != nil {
+		return err
+	}
+
+	for i, b := range editor.buffers {
+		if b == buffer {
+			editor.buffers = append(editor.buffers[:i], editor.buffers[i+1:]...)
+			break
+		}
+	}
+
+	for _, pane := range editor.panes {
+		pane.forgetBuffer(buffer)
+	}
+	return nil
+}
+
+// removeFromPanes switches any pane showing the buffer to its alternate buffer,
+// another listed buffer or to no buffer at all.
+func (editor *Editor) removeFromPanes(buffer *Buffer) {
+	for _, pane := range editor.panes {
+		if pane.Buffer() == buffer {
+			replacement := pane.AlternateBuffer()
+			if replacement == nil || !replacement.Listed() {
+				replacement = editor.nearestListedBuffer(buffer)
+			}
+			pane.SetBuffer(replacement)
+		}
+
+		if pane.alternate == buffer {
+			pane.alternate = nil
+		}
+	}
+}
+
+// nearestListedBuffer returns the listed buffer after the given one, or before it if there are none after.
+func (editor *Editor) nearestListedBuffer(buffer *Buffer) *Buffer {
+	var before *Buffer
+	after := false
+	for _, b := range editor.buffers {
+		if b == buffer {
+			after = true
+			continue
+		}
+		if !b.Listed() {
+			continue
+		}
+		if after {
+			return b
+		}
+		before = b
+	}
+	return before
+}
+
+// BufferList returns a line describing each buffer in the style of Vim's :ls.
+// Unlisted buffers are only included when all is set.
+// Flags are '%' for the current buffer, '#' for the alternate, 'a' if it's visible,
+// 'h' if it's hidden, '+' if it is modified and 'u' if it is unlisted.
+func (editor *Editor) BufferList(all bool) []string {
+	lines := []string{}
+	for _, buffer := range editor.buffers {
+		if !buffer.Listed() && !all {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%3d%s \"%s\"", buffer.ID(), editor.bufferFlags(buffer), buffer.Name()))
+	}
+	return lines
+}
+
+func (editor *Editor) bufferFlags(buffer *Buffer) string {
+	flags := []byte("     ")
+
+	if !buffer.Listed() {
+		flags[0] = 'u'
+	}
+
+	switch buffer {
+	case editor.CurrentPane().Buffer():
+		flags[1] = '%'
+	case editor.CurrentPane().AlternateBuffer():
+		flags[1] = '#'
+	}
+
+	if editor.BufferVisible(buffer) {
+		flags[2] = 'a'
+	} else if buffer.Listed() {
+		flags[2] = 'h'
+	}
+
+	if buffer.Modified() {
+		flags[4] = '+'
+	}
+
+	return string(flags)
+}
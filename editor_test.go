@@ -103,3 +103,104 @@ func TestCursor(t *testing.T) {
 		})
 	})
 }
+
+func TestBufferList(t *testing.T) {
+	Convey("editor with three open files", t, func() {
+		fs := GetCustomTestFs(fakeFileSystem)
+		editor := NewEditor(fs)
+		editor.OpenFiles([]string{"file.txt", "fakefile.txt", "fakefile2.txt"})
+		first, second, third := editor.Buffers()[0], editor.Buffers()[1], editor.Buffers()[2]
+
+		Convey("buffers should be numbered in order", func() {
+			So(first.ID(), ShouldEqual, 1)
+			So(second.ID(), ShouldEqual, 2)
+			So(third.ID(), ShouldEqual, 3)
+			So(editor.BufferByID(2), ShouldEqual, second)
+			So(editor.BufferByID(4), ShouldBeNil)
+		})
+
+		Convey("FindBuffer by number and partial name", func() {
+			buffer, err := editor.FindBuffer("3")
+			So(err, ShouldBeNil)
+			So(buffer, ShouldEqual, third)
+
+			buffer, err = editor.FindBuffer("file2")
+			So(err, ShouldBeNil)
+			So(buffer, ShouldEqual, third)
+
+			buffer, err = editor.FindBuffer("file.txt")
+			So(err, ShouldBeNil)
+			So(buffer, ShouldEqual, first)
+
+			_, err = editor.FindBuffer("fake")
+			So(err, ShouldNotBeNil)
+
+			_, err = editor.FindBuffer("missing")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("switching buffers should set the alternate buffer", func() {
+			editor.SwitchToBuffer(third)
+			So(editor.CurrentPane().Buffer(), ShouldEqual, third)
+			So(editor.CurrentPane().AlternateBuffer(), ShouldEqual, first)
+
+			So(editor.SwitchToAlternateBuffer(), ShouldBeNil)
+			So(editor.CurrentPane().Buffer(), ShouldEqual, first)
+			So(editor.CurrentPane().AlternateBuffer(), ShouldEqual, third)
+		})
+
+		Convey("SwitchToAlternateBuffer without an alternate should fail", func() {
+			So(editor.SwitchToAlternateBuffer(), ShouldNotBeNil)
+		})
+
+		Convey("BufferList should flag current, alternate, hidden and modified buffers", func() {
+			editor.SwitchToBuffer(second)
+			third.SetModified(true)
+
+			So(editor.BufferList(false), ShouldResemble, []string{
+				`  1 #h   "file.txt"`,
+				`  2 %a   "fakefile.txt"`,
+				`  3  h + "fakefile2.txt"`,
+			})
+		})
+
+		Convey("DeleteBuffer on the current buffer should fall back to the alternate", func() {
+			editor.SwitchToBuffer(third)
+			So(editor.DeleteBuffer(third, false), ShouldBeNil)
+
+			So(third.Listed(), ShouldBeFalse)
+			So(editor.CurrentPane().Buffer(), ShouldEqual, first)
+			So(editor.CurrentPane().AlternateBuffer(), ShouldBeNil)
+			So(len(editor.BufferList(false)), ShouldEqual, 2)
+			So(len(editor.BufferList(true)), ShouldEqual, 3)
+		})
+
+		Convey("DeleteBuffer without an alternate should fall back to the next buffer", func() {
+			So(editor.DeleteBuffer(first, false), ShouldBeNil)
+			So(editor.CurrentPane().Buffer(), ShouldEqual, second)
+		})
+
+		Convey("DeleteBuffer on a modified buffer should require force", func() {
+			first.SetModified(true)
+			So(editor.DeleteBuffer(first, false), ShouldNotBeNil)
+			So(first.Listed(), ShouldBeTrue)
+			So(editor.DeleteBuffer(first, true), ShouldBeNil)
+			So(first.Listed(), ShouldBeFalse)
+		})
+
+		Convey("WipeBuffer should remove the buffer completely", func() {
+			So(editor.WipeBuffer(first, false), ShouldBeNil)
+			So(len(editor.Buffers()), ShouldEqual, 2)
+			So(editor.BufferByID(1), ShouldBeNil)
+			So(editor.CurrentPane().Buffer(), ShouldEqual, second)
+		})
+
+		Convey("deleting every buffer should leave the pane empty", func() {
+			for _, buffer := range editor.Buffers() {
+				So(editor.DeleteBuffer(buffer, false), ShouldBeNil)
+			}
+			So(editor.CurrentPane().Buffer(), ShouldBeNil)
+			So(editor.CurrentPane().Cursor(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+// Key identifies a key that doesn't produce a character.
+type Key int
+
+// Keys reported in a KeyEvent, KeyRune means the key typed the KeyEvent's Rune.
+const (
+	KeyRune Key = iota
+	KeyEscape
+	KeyEnter
+	KeyTab
+	KeyBackspace
+	KeyDelete
+	KeyInsert
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+)
+
+// Modifier is a set of modifier keys held during a KeyEvent or MouseEvent.
+type Modifier int
+
+// Modifier keys.
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+)
+
+// KeyEvent is a key press. Control characters are reported as their letter with ModCtrl, ie Ctrl-A is 'a'.
+type KeyEvent struct {
+	Key  Key
+	Rune rune
+	Mod  Modifier
+}
+
+// ResizeEvent is sent when the terminal changes size.
+type ResizeEvent struct {
+	Width  int
+	Height int
+}
+
+// MouseButton identifies the button of a MouseEvent.
+type MouseButton int
+
+// Mouse buttons, the wheel is reported as a button press.
+const (
+	MouseNone MouseButton = iota
+	MouseLeft
+	MouseMiddle
+	MouseRight
+	MouseWheelUp
+	MouseWheelDown
+)
+
+// MouseAction is what happened to the MouseButton.
+type MouseAction int
+
+// Mouse actions.
+const (
+	MousePress MouseAction = iota
+	MouseRelease
+	MouseDrag
+	MouseMove
+)
+
+// MouseEvent is a mouse button or movement at a cell of the screen.
+type MouseEvent struct {
+	X      int
+	Y      int
+	Button MouseButton
+	Action MouseAction
+	Mod    Modifier
+}
+
+// PasteEvent holds text pasted into the terminal all at once.
+type PasteEvent struct {
+	Text string
+}
+
+// FocusEvent is sent when the terminal gains or loses focus.
+type FocusEvent struct {
+	Focused bool
+}
+
+// OpenEvent asks for a file to be opened, ie by "jkl --remote".
+// Deleted is called when its buffer is deleted, if it is set.
+type OpenEvent struct {
+	Filename string
+	Line     int
+	Deleted  func()
+}
@@ -8,6 +8,9 @@ type FakeDriver struct {
 	CursorX   int
 	CursorY   int
 	Grid      RuneGrid
+	CellsSet  int
+	Inits     int
+	Closes    int
 }
 
 func NewFakeDriver() FakeDriver {
@@ -25,6 +28,7 @@ func (fd *FakeDriver) SetSize(width, height int) {
 }
 
 func (fd *FakeDriver) Init() {
+	fd.Inits++
 }
 
 func (fd *FakeDriver) Events() chan Event {
@@ -35,9 +39,12 @@ func (fd *FakeDriver) Size() (width int, height int) {
 	return fd.Grid.Size()
 }
 
-func (fd *FakeDriver) Close() {}
+func (fd *FakeDriver) Close() {
+	fd.Closes++
+}
 func (fd *FakeDriver) SetCell(x, y int, r rune, fg Color, bg Color) {
 	fd.Grid.SetCell(x, y, r)
+	fd.CellsSet++
 }
 func (fd *FakeDriver) SetCursor(x, y int) {
 	fd.CursorX = x
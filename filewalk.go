@@ -0,0 +1,162 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// walkWorkers is how many directories WalkFiles reads at once.
+const walkWorkers = 8
+
+// WalkFiles lists the files under root, as slash separated paths relative to it, calling found with
+// those of each directory as it is read. Directories are read concurrently and found is called from
+// their goroutines, one call at a time. Anything ignored by a .gitignore file is skipped, as is .git.
+// It returns once everything has been listed or done is closed.
+func WalkFiles(fs afero.Fs, root string, done <-chan struct{}, found func(paths []string)) {
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	limit := make(chan struct{}, walkWorkers)
+
+	var walk func(dir string, rules gitignore)
+	walk = func(dir string, rules gitignore) {
+		defer wait.Done()
+		select {
+		case <-done:
+			return
+		case limit <- struct{}{}:
+		}
+		full := filepath.Join(root, filepath.FromSlash(dir))
+		entries, err := afero.ReadDir(fs, full)
+		if data, err := afero.ReadFile(fs, filepath.Join(full, ".gitignore")); err == nil {
+			rules = append(rules[:len(rules):len(rules)], parseGitignore(dir, string(data))...)
+		}
+		<-limit
+		if err != nil {
+			return
+		}
+
+		files := []string{}
+		for _, entry := range entries {
+			name := path.Join(dir, entry.Name())
+			if entry.Name() == ".git" || rules.ignored(name, entry.IsDir()) {
+				continue
+			}
+			if entry.IsDir() {
+				wait.Add(1)
+				go walk(name, rules)
+			} else {
+				files = append(files, name)
+			}
+		}
+		if len(files) == 0 {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		select {
+		case <-done:
+		default:
+			found(files)
+		}
+	}
+
+	wait.Add(1)
+	walk("", nil)
+	wait.Wait()
+}
+
+// gitignore are the rules of the .gitignore files that apply to a directory, the last matching one wins.
+type gitignore []ignoreRule
+
+// ignoreRule is a line of a .gitignore file in the directory base, "" for the root.
+// Anchored patterns have a slash and match the whole path from base, others match the name at any depth.
+type ignoreRule struct {
+	base     string
+	parts    []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseGitignore reads the rules of a .gitignore file in the directory base.
+func parseGitignore(base, text string) []ignoreRule {
+	rules := []ignoreRule{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.parts = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored returns true if a path relative to the root of the walk is ignored.
+func (rules gitignore) ignored(name string, dir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.matches(name, dir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matches returns true if the rule's pattern matches a path relative to the root of the walk.
+func (rule ignoreRule) matches(name string, dir bool) bool {
+	if rule.dirOnly && !dir {
+		return false
+	}
+	if rule.base != "" {
+		if !strings.HasPrefix(name, rule.base+"/") {
+			return false
+		}
+		name = name[len(rule.base)+1:]
+	}
+	if !rule.anchored {
+		ok, _ := path.Match(rule.parts[0], path.Base(name))
+		return ok
+	}
+	return matchPathParts(rule.parts, strings.Split(name, "/"))
+}
+
+// matchPathParts matches the parts of a path against those of a pattern, where "**" matches any number of parts.
+func matchPathParts(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchPathParts(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchPathParts(pattern[1:], parts[1:])
+}
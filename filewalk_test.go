@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestWalkFiles(t *testing.T) {
+	Convey("Walking a directory", t, func() {
+		fs := afero.NewMemMapFs()
+		for _, name := range []string{
+			"/proj/main.go", "/proj/debug.log", "/proj/keep.log", "/proj/.git/HEAD",
+			"/proj/build/out", "/proj/src/lib.go", "/proj/src/gen/gen.go", "/proj/src/gen/keep.go",
+			"/proj/docs/build/page.html", "/proj/vendor/a/b/c.go",
+		} {
+			afero.WriteFile(fs, name, []byte{}, 0644)
+		}
+		afero.WriteFile(fs, "/proj/.gitignore", []byte("# comment\n*.log\n!keep.log\n/build/\nvendor/**\n"), 0644)
+		afero.WriteFile(fs, "/proj/src/.gitignore", []byte("gen/*\n!gen/keep.go\n"), 0644)
+
+		walk := func() []string {
+			var mutex sync.Mutex
+			paths := []string{}
+			WalkFiles(fs, "/proj", make(chan struct{}), func(found []string) {
+				mutex.Lock()
+				paths = append(paths, found...)
+				mutex.Unlock()
+			})
+			sort.Strings(paths)
+			return paths
+		}
+
+		Convey("lists the files that aren't ignored", func() {
+			So(walk(), ShouldResemble, []string{
+				".gitignore", "docs/build/page.html", "keep.log", "main.go",
+				"src/.gitignore", "src/gen/keep.go", "src/lib.go",
+			})
+		})
+
+		Convey("stops when it's done", func() {
+			done := make(chan struct{})
+			close(done)
+			called := false
+			WalkFiles(fs, "/proj", done, func([]string) { called = true })
+			So(called, ShouldBeFalse)
+		})
+	})
+
+	Convey("Gitignore patterns", t, func() {
+		rules := gitignore(parseGitignore("", "a/**/z\n\\!bang\nname/\n"))
+		So(rules.ignored("a/z", false), ShouldBeTrue)
+		So(rules.ignored("a/b/c/z", false), ShouldBeTrue)
+		So(rules.ignored("b/a/z", false), ShouldBeFalse)
+		So(rules.ignored("x/!bang", false), ShouldBeTrue)
+		So(rules.ignored("x/name", true), ShouldBeTrue)
+		So(rules.ignored("x/name", false), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FloatAnchor is what the position of a Float is relative to.
+type FloatAnchor int
+
+// AnchorEditor puts the top left corner of a Float at X, Y inside the editor's frame. AnchorCursor puts it
+// X, Y from the cell of the cursor, above the cursor's line if it doesn't fit below, and AnchorCenter
+// centres it in the frame and moves it by X, Y.
+const (
+	AnchorEditor FloatAnchor = iota
+	AnchorCursor
+	AnchorCenter
+)
+
+// Layers of the editor's own floats, higher ones are drawn over lower ones. Other floats are at 0
+// unless they set their Z.
+const (
+	floatLayerHover      = 10
+	floatLayerCompletion = 20
+	floatLayerPicker     = 30
+	floatLayerDialog     = 40
+)
+
+// Float is a window drawn over the panes, ie by a plugin. Width and Height are the size of what's inside,
+// a border goes around that, and a size of 0 takes four fifths of the frame. Floats with a higher Z are
+// drawn over the others, those with the same Z in the order they were opened. The Lines are shown from
+// Scroll on, unless Draw draws the inside itself.
+type Float struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	Lines  []string
+	Anchor FloatAnchor
+	Z      int
+	Title  string
+	Border bool
+	Shadow bool
+	Scroll int
+	// Style is the style of the float, StyleFloat if it's left as StyleNormal.
+	Style Style
+	// Layout, if set, sizes and moves the float just before it's drawn in a frame of the given size.
+	// The float isn't drawn if it returns false.
+	Layout func(float *Float, width, height int) bool
+	// Draw, if set, draws the inside of the float instead of its Lines.
+	Draw func(grid *RuneGrid, x1, y1, x2, y2 int)
+	// Key, if set, gets the keys typed while the float has focus. It returns false to leave a key to the
+	// float, which scrolls with j, k, Ctrl-D and Ctrl-U and closes with q or Escape.
+	Key func(event KeyEvent) bool
+	// Closed, if set, is called once the float has been closed.
+	Closed func()
+	// The cursor is shown at CursorX, CursorY inside the float while it has focus, if ShowCursor is set.
+	CursorX    int
+	CursorY    int
+	ShowCursor bool
+
+	inside Rect
+	drawn  bool
+}
+
+// OpenFloat shows a Float over the panes, opening one that is already open does nothing.
+func (editor *Editor) OpenFloat(float *Float) {
+	for _, open := range editor.floats {
+		if open == float {
+			return
+		}
+	}
+	editor.floats = append(editor.floats, float)
+}
+
+// CloseFloat stops showing a Float, giving the focus back to the one that had it before.
+func (editor *Editor) CloseFloat(float *Float) {
+	for i, open := range editor.floats {
+		if open == float {
+			editor.floats = append(editor.floats[:i], editor.floats[i+1:]...)
+			editor.unfocusFloat(float)
+			if float.Closed != nil {
+				float.Closed()
+			}
+			return
+		}
+	}
+}
+
+// Floats returns the Floats being shown from the bottom one up.
+func (editor *Editor) Floats() []*Float {
+	floats := append([]*Float{}, editor.floats...)
+	sort.SliceStable(floats, func(i, j int) bool { return floats[i].Z < floats[j].Z })
+	return floats
+}
+
+// FocusFloat sends the keys to an open Float until it's closed or another one takes the focus.
+func (editor *Editor) FocusFloat(float *Float) {
+	editor.unfocusFloat(float)
+	editor.focus = append(editor.focus, float)
+}
+
+// unfocusFloat forgets that a float had the focus.
+func (editor *Editor) unfocusFloat(float *Float) {
+	for i, focused := range editor.focus {
+		if focused == float {
+			editor.focus = append(editor.focus[:i], editor.focus[i+1:]...)
+			return
+		}
+	}
+}
+
+// FocusedFloat returns the Float the keys go to, nil if they go to the panes.
+func (editor *Editor) FocusedFloat() *Float {
+	if len(editor.focus) == 0 {
+		return nil
+	}
+	return editor.focus[len(editor.focus)-1]
+}
+
+// FloatCursor returns where the cursor is shown in the focused Float, ok is false if it isn't.
+func (editor *Editor) FloatCursor() (x, y int, ok bool) {
+	float := editor.FocusedFloat()
+	if float == nil || !float.ShowCursor || !float.drawn {
+		return 0, 0, false
+	}
+	return float.inside.X1 + float.CursorX, float.inside.Y1 + float.CursorY, true
+}
+
+// HandleFloatKey gives a key to the focused Float.
+func (editor *Editor) HandleFloatKey(event KeyEvent) {
+	float := editor.FocusedFloat()
+	if float == nil || float.Key != nil && float.Key(event) {
+		return
+	}
+	page := float.visibleHeight() / 2
+	if page < 1 {
+		page = 1
+	}
+	ctrl := func(r rune) bool { return event.Key == KeyRune && event.Mod&ModCtrl != 0 && event.Rune == r }
+	key := func(r rune) bool { return event.Key == KeyRune && event.Mod&(ModCtrl|ModAlt) == 0 && event.Rune == r }
+
+	switch {
+	case event.Key == KeyEscape || key('q'):
+		editor.CloseFloat(float)
+	case event.Key == KeyDown || key('j') || ctrl('e') || ctrl('n'):
+		float.ScrollBy(1)
+	case event.Key == KeyUp || key('k') || ctrl('y') || ctrl('p'):
+		float.ScrollBy(-1)
+	case event.Key == KeyPageDown || ctrl('d'):
+		float.ScrollBy(page)
+	case event.Key == KeyPageUp || ctrl('u'):
+		float.ScrollBy(-page)
+	case event.Key == KeyHome || key('g'):
+		float.ScrollBy(-len(float.Lines))
+	case event.Key == KeyEnd || key('G'):
+		float.ScrollBy(len(float.Lines))
+	}
+}
+
+// Confirm asks a question in a dialog over the panes, answer gets true if y is typed and false for n or Escape.
+func (editor *Editor) Confirm(question string, answer func(yes bool)) {
+	lines := append(strings.Split(question, "\n"), "", "[y]es  [n]o")
+	width := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	yes := false
+	dialog := &Float{
+		Anchor: AnchorCenter, Width: width + 2, Height: len(lines), Title: "Confirm", Border: true, Shadow: true,
+		Z: floatLayerDialog,
+	}
+	for _, line := range lines {
+		dialog.Lines = append(dialog.Lines, " "+line)
+	}
+	dialog.Key = func(event KeyEvent) bool {
+		switch {
+		case event.Key == KeyRune && (event.Rune == 'y' || event.Rune == 'Y'):
+			yes = true
+			editor.CloseFloat(dialog)
+		case event.Key == KeyEscape || event.Key == KeyRune && (event.Rune == 'n' || event.Rune == 'N'):
+			editor.CloseFloat(dialog)
+		}
+		return true
+	}
+	dialog.Closed = func() { answer(yes) }
+	editor.OpenFloat(dialog)
+	editor.FocusFloat(dialog)
+}
+
+// ScrollBy moves the Lines shown by delta, keeping the last line at the bottom or above.
+func (float *Float) ScrollBy(delta int) {
+	float.Scroll += delta
+	if last := len(float.Lines) - float.visibleHeight(); float.Scroll > last {
+		float.Scroll = last
+	}
+	if float.Scroll < 0 {
+		float.Scroll = 0
+	}
+}
+
+// visibleHeight returns how many lines fit inside the float as it was last drawn.
+func (float *Float) visibleHeight() int {
+	if float.drawn {
+		return float.inside.Y2 - float.inside.Y1 + 1
+	}
+	return float.Height
+}
+
+// place returns where a Float is drawn in the frame, border included, clipped to the frame.
+// ok is false if it can't be seen.
+func (float *Float) place(frame Rect, cursorX, cursorY int, cursorShown bool) (Rect, bool) {
+	border := 0
+	if float.Border {
+		border = 1
+	}
+	frameWidth, frameHeight := frame.X2-frame.X1+1, frame.Y2-frame.Y1+1
+	width, height := float.Width+2*border, float.Height+2*border
+	if float.Width <= 0 {
+		width = frameWidth * 4 / 5
+	}
+	if float.Height <= 0 {
+		height = frameHeight * 4 / 5
+	}
+
+	left, top := frame.X1+float.X, frame.Y1+float.Y
+	switch float.Anchor {
+	case AnchorCursor:
+		if !cursorShown {
+			return Rect{}, false
+		}
+		left, top = cursorX+float.X, cursorY+float.Y
+		if top+height-1 > frame.Y2 && cursorY-height >= frame.Y1 {
+			top = cursorY - height
+		}
+		if left+width-1 > frame.X2 {
+			left = frame.X2 - width + 1
+		}
+		if left < frame.X1 {
+			left = frame.X1
+		}
+	case AnchorCenter:
+		left = frame.X1 + (frameWidth-width)/2 + float.X
+		top = frame.Y1 + (frameHeight-height)/2 + float.Y
+	}
+
+	rect := Rect{left, top, left + width - 1, top + height - 1}
+	if rect.X2 > frame.X2 {
+		rect.X2 = frame.X2
+	}
+	if rect.Y2 > frame.Y2 {
+		rect.Y2 = frame.Y2
+	}
+	if rect.X1 < frame.X1 {
+		rect.X1 = frame.X1
+	}
+	if rect.Y1 < frame.Y1 {
+		rect.Y1 = frame.Y1
+	}
+	return rect, rect.X1+2*border <= rect.X2 && rect.Y1+2*border <= rect.Y2
+}
+
+// RenderFloats draws the editor's Floats inside the frame from the bottom one up, clipping those that don't fit.
+func (grid *RuneGrid) RenderFloats(editor *Editor, x1, y1, x2, y2 int) {
+	cursorX, cursorY, cursorShown := 0, 0, false
+	if pane := editor.CurrentPane(); pane != nil {
+		cursorX, cursorY, cursorShown = pane.CursorScreenPosition(editor.Settings())
+	}
+	for _, float := range editor.Floats() {
+		float.drawn = false
+		if float.Layout != nil && !float.Layout(float, x2-x1+1, y2-y1+1) {
+			continue
+		}
+		rect, ok := float.place(Rect{x1, y1, x2, y2}, cursorX, cursorY, cursorShown)
+		if !ok {
+			continue
+		}
+		style := float.Style
+		if style == StyleNormal {
+			style = StyleFloat
+		}
+
+		if float.Shadow {
+			right := rect.X2 + 1
+			if right > x2 {
+				right = x2
+			}
+			if rect.Y2 < y2 {
+				grid.FillStyle(rect.X1+1, rect.Y2+1, right, rect.Y2+1, StyleShadow)
+			}
+			if rect.X2 < x2 {
+				grid.FillStyle(right, rect.Y1+1, right, rect.Y2, StyleShadow)
+			}
+		}
+		for y := rect.Y1; y <= rect.Y2; y++ {
+			grid.DrawHorizontalLine(rect.X1, rect.X2, y, ' ')
+		}
+		grid.FillStyle(rect.X1, rect.Y1, rect.X2, rect.Y2, style)
+
+		inside := rect
+		if float.Border {
+			grid.DrawBox(rect.X1, rect.Y1, rect.X2, rect.Y2, '─', '│', '┌', '┐', '└', '┘')
+			if float.Title != "" {
+				grid.DrawText(rect.X1+2, rect.Y1, rect.X2-2, " "+float.Title+" ", style)
+			}
+			inside = Rect{rect.X1 + 1, rect.Y1 + 1, rect.X2 - 1, rect.Y2 - 1}
+		}
+		float.inside, float.drawn = inside, true
+
+		if float.Draw != nil {
+			float.Draw(grid, inside.X1, inside.Y1, inside.X2, inside.Y2)
+			continue
+		}
+		float.ScrollBy(0)
+		for i, line := range float.Lines[float.Scroll:] {
+			if inside.Y1+i > inside.Y2 {
+				break
+			}
+			grid.DrawText(inside.X1, inside.Y1+i, inside.X2, line, style)
+		}
+		if float.Border && len(float.Lines) > inside.Y2-inside.Y1+1 {
+			position := fmt.Sprintf(" %d/%d ", float.Scroll+1, len(float.Lines))
+			grid.DrawText(rect.X2-1-len(position), rect.Y2, rect.X2-2, position, style)
+		}
+	}
+}
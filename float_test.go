@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestFloats(t *testing.T) {
+	Convey("Editor with floats", t, func() {
+		editor := NewEditor(afero.NewMemMapFs())
+		editor.Settings().Borders = false
+		editor.SwitchToBuffer(editor.AddBuffer(bufferWithText("one\ntwo\nthree\n")))
+		grid := NewRuneGrid(20, 10)
+		rows := func() []string {
+			grid.RenderEditor(&editor)
+			rows := []string{}
+			for _, row := range grid.Cells() {
+				rows = append(rows, string(row))
+			}
+			return rows
+		}
+
+		Convey("a float is drawn where it's put", func() {
+			editor.OpenFloat(&Float{X: 2, Y: 1, Width: 5, Height: 2, Lines: []string{"hello", "there", "cut"}})
+			So(rows()[1][2:7], ShouldEqual, "hello")
+			So(rows()[2][2:7], ShouldEqual, "there")
+			So(rows()[3], ShouldNotContainSubstring, "cut")
+			So(grid.Styles()[1][2], ShouldEqual, StyleFloat)
+		})
+
+		Convey("a border goes around it with a title and where it's scrolled to", func() {
+			float := &Float{X: 1, Y: 1, Width: 8, Height: 2, Lines: []string{"a", "b", "c", "d"}, Border: true, Title: "T"}
+			editor.OpenFloat(float)
+			So(rows()[1][1:], ShouldStartWith, "┌─ T ────┐")
+			So(rows()[2][1:], ShouldStartWith, "│a       │")
+			So(rows()[4][1:], ShouldStartWith, "└── 1/4 ─┘")
+
+			float.ScrollBy(5)
+			So(float.Scroll, ShouldEqual, 2)
+			So(rows()[2][1:], ShouldStartWith, "│c       │")
+			So(rows()[4][1:], ShouldStartWith, "└── 3/4 ─┘")
+		})
+
+		Convey("a shadow is drawn below and right of it", func() {
+			editor.OpenFloat(&Float{X: 1, Y: 1, Width: 3, Height: 1, Shadow: true})
+			rows()
+			So(grid.Styles()[2][2], ShouldEqual, StyleShadow)
+			So(grid.Styles()[2][4], ShouldEqual, StyleShadow)
+			So(grid.Styles()[1][4], ShouldNotEqual, StyleShadow)
+			So(grid.Styles()[2][1], ShouldNotEqual, StyleShadow)
+		})
+
+		Convey("floats anchored to the cursor", func() {
+			float := &Float{Anchor: AnchorCursor, Y: 1, Width: 4, Height: 2, Lines: []string{"menu", "menu"}}
+			editor.OpenFloat(float)
+
+			Convey("go below it", func() {
+				editor.CurrentPane().Cursor().Move(1, 1)
+				So(rows()[1][1:5], ShouldEqual, "menu")
+			})
+
+			Convey("go above it when they don't fit below", func() {
+				editor.CurrentPane().Buffer().InsertLines(3, []string{"4", "5", "6", "7", "8"})
+				editor.CurrentPane().Cursor().Move(1, 8)
+				So(rows()[5][1:5], ShouldEqual, "menu")
+				So(rows()[6][1:5], ShouldEqual, "menu")
+			})
+
+			Convey("move left to fit", func() {
+				float.X = 18
+				editor.CurrentPane().Cursor().Move(0, 1)
+				So(rows()[1][16:20], ShouldEqual, "menu")
+			})
+		})
+
+		Convey("centred floats take four fifths of the frame without a size", func() {
+			editor.OpenFloat(&Float{Anchor: AnchorCenter, Border: true})
+			So(rows()[0], ShouldNotContainSubstring, "┌")
+			So(rows()[1][2:], ShouldStartWith, "┌──────────────┐")
+			So(rows()[7][2:], ShouldStartWith, "└──────────────┘")
+		})
+
+		Convey("floats higher up are drawn over the others", func() {
+			top := &Float{Width: 3, Height: 1, Lines: []string{"top"}, Z: 1}
+			editor.OpenFloat(top)
+			editor.OpenFloat(&Float{Width: 3, Height: 1, Lines: []string{"low"}})
+			So(rows()[0][:3], ShouldEqual, "top")
+			So(editor.Floats()[1], ShouldEqual, top)
+		})
+
+		Convey("a float isn't drawn when its layout says so", func() {
+			editor.OpenFloat(&Float{Width: 3, Height: 1, Lines: []string{"not"}, Layout: func(*Float, int, int) bool { return false }})
+			So(rows()[0], ShouldNotContainSubstring, "not")
+		})
+
+		Convey("a focused float gets the keys", func() {
+			below := &Float{Width: 3, Height: 1}
+			editor.OpenFloat(below)
+			editor.FocusFloat(below)
+			closed := false
+			float := &Float{Width: 3, Height: 2, Lines: []string{"1", "2", "3", "4", "5"}, Closed: func() { closed = true }}
+			editor.OpenFloat(float)
+			editor.FocusFloat(float)
+			So(editor.FocusedFloat(), ShouldEqual, float)
+
+			editor.HandleFloatKey(KeyEvent{Rune: 'j'})
+			So(float.Scroll, ShouldEqual, 1)
+			editor.HandleFloatKey(KeyEvent{Rune: 'G'})
+			So(float.Scroll, ShouldEqual, 3)
+			editor.HandleFloatKey(KeyEvent{Rune: 'u', Mod: ModCtrl})
+			So(float.Scroll, ShouldEqual, 2)
+			editor.HandleFloatKey(KeyEvent{Key: KeyHome})
+			So(float.Scroll, ShouldEqual, 0)
+
+			editor.HandleFloatKey(KeyEvent{Rune: 'q'})
+			So(closed, ShouldBeTrue)
+			So(editor.Floats(), ShouldResemble, []*Float{below})
+			So(editor.FocusedFloat(), ShouldEqual, below)
+
+			Convey("unless its Key takes them", func() {
+				keys := []KeyEvent{}
+				below.Key = func(event KeyEvent) bool {
+					keys = append(keys, event)
+					return true
+				}
+				editor.HandleFloatKey(KeyEvent{Key: KeyEscape})
+				So(keys, ShouldResemble, []KeyEvent{{Key: KeyEscape}})
+				So(editor.Floats(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("the cursor is shown in a focused float", func() {
+			float := &Float{X: 2, Y: 3, Width: 5, Height: 1, Border: true, CursorX: 1, ShowCursor: true}
+			editor.OpenFloat(float)
+			_, _, ok := editor.FloatCursor()
+			So(ok, ShouldBeFalse)
+			editor.FocusFloat(float)
+			rows()
+			x, y, ok := editor.FloatCursor()
+			So(ok, ShouldBeTrue)
+			So([]int{x, y}, ShouldResemble, []int{4, 4})
+		})
+
+		Convey("confirm dialogs", func() {
+			answers := []bool{}
+			answer := func(yes bool) { answers = append(answers, yes) }
+
+			Convey("ask a question", func() {
+				editor.Confirm("Really?", answer)
+				So(rows()[2], ShouldContainSubstring, "┌─ Confirm ─")
+				So(rows()[3], ShouldContainSubstring, "│ Really?")
+				So(rows()[5], ShouldContainSubstring, "│ [y]es  [n]o │")
+			})
+
+			Convey("answer yes with y", func() {
+				editor.Confirm("Really?", answer)
+				editor.HandleFloatKey(KeyEvent{Rune: 'x'})
+				So(answers, ShouldBeEmpty)
+				editor.HandleFloatKey(KeyEvent{Rune: 'y'})
+				So(answers, ShouldResemble, []bool{true})
+				So(editor.Floats(), ShouldBeEmpty)
+			})
+
+			Convey("answer no with n or Escape", func() {
+				editor.Confirm("Really?", answer)
+				editor.HandleFloatKey(KeyEvent{Rune: 'n'})
+				editor.Confirm("Really?", answer)
+				editor.HandleFloatKey(KeyEvent{Key: KeyEscape})
+				So(answers, ShouldResemble, []bool{false, false})
+				So(editor.FocusedFloat(), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fold is a range of lines that can be collapsed into a single summary line.
+type Fold struct {
+	Start  int
+	End    int
+	Closed bool
+}
+
+// Contains returns true if the line is inside the fold.
+func (fold *Fold) Contains(line int) bool {
+	return line >= fold.Start && line <= fold.End
+}
+
+// Folds holds the folds of a buffer in a pane.
+// Folds may nest but never partially overlap.
+type Folds struct {
+	folds   []*Fold
+	updated foldsKey
+}
+
+// foldsKey is what automatic folds were last worked out from, they're only worked out again when it changes.
+type foldsKey struct {
+	buffer     *Buffer
+	version    int
+	method     string
+	marker     string
+	shiftWidth int
+}
+
+// All returns every fold, ordered by start line with outer folds first.
+func (folds *Folds) All() []*Fold {
+	if folds == nil {
+		return nil
+	}
+	return folds.folds
+}
+
+// Create adds a closed fold over the given lines, used by manual folding.
+func (folds *Folds) Create(start, end int) (*Fold, error) {
+	if start > end {
+		start, end = end, start
+	}
+
+	for _, f := range folds.folds {
+		overlaps := start <= f.End && end >= f.Start
+		nested := f.Contains(start) && f.Contains(end) || start <= f.Start && end >= f.End
+		if overlaps && !nested {
+			return nil, fmt.Errorf("Fold %d,%d overlaps an existing fold", start, end)
+		}
+	}
+
+	fold := &Fold{Start: start, End: end, Closed: true}
+	folds.folds = append(folds.folds, fold)
+	folds.sort()
+	return fold, nil
+}
+
+// Set replaces all the folds, keeping folds that start and end on the same lines open or closed as before.
+func (folds *Folds) Set(ranges []Fold) {
+	closed := map[[2]int]bool{}
+	for _, f := range folds.folds {
+		closed[[2]int{f.Start, f.End}] = f.Closed
+	}
+
+	folds.folds = []*Fold{}
+	seen := map[[2]int]bool{}
+	for _, r := range ranges {
+		key := [2]int{r.Start, r.End}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		fold := &Fold{Start: r.Start, End: r.End, Closed: r.Closed}
+		if wasClosed, ok := closed[key]; ok {
+			fold.Closed = wasClosed
+		}
+		folds.folds = append(folds.folds, fold)
+	}
+	folds.sort()
+}
+
+// Delete removes every fold.
+func (folds *Folds) Delete() {
+	folds.folds = nil
+	folds.updated = foldsKey{}
+}
+
+func (folds *Folds) sort() {
+	sort.SliceStable(folds.folds, func(i, j int) bool {
+		a, b := folds.folds[i], folds.folds[j]
+		if a.Start == b.Start {
+			return a.End > b.End
+		}
+		return a.Start < b.Start
+	})
+}
+
+// ClosedFoldAt returns the outermost closed fold containing the line, or nil.
+func (folds *Folds) ClosedFoldAt(line int) *Fold {
+	for _, f := range folds.All() {
+		if f.Closed && f.Contains(line) {
+			return f
+		}
+	}
+	return nil
+}
+
+// innermostAt returns the smallest fold containing the line that matches the filter.
+func (folds *Folds) innermostAt(line int, filter func(*Fold) bool) *Fold {
+	var found *Fold
+	for _, f := range folds.All() {
+		if f.Contains(line) && filter(f) {
+			found = f
+		}
+	}
+	return found
+}
+
+// Open opens the fold under the line, it returns an error if there isn't one.
+func (folds *Folds) Open(line int) error {
+	fold := folds.ClosedFoldAt(line)
+	if fold == nil {
+		return errors.New("No fold found")
+	}
+	fold.Closed = false
+	return nil
+}
+
+// Close closes the innermost open fold under the line, it returns an error if there isn't one.
+func (folds *Folds) Close(line int) error {
+	fold := folds.innermostAt(line, func(f *Fold) bool {
+		return !f.Closed && folds.ClosedFoldAt(f.Start) == nil
+	})
+	if fold == nil {
+		if folds.ClosedFoldAt(line) != nil {
+			return nil
+		}
+		return errors.New("No fold found")
+	}
+	fold.Closed = true
+	return nil
+}
+
+// Toggle opens the fold under the line if it is closed or closes it if it is open.
+func (folds *Folds) Toggle(line int) error {
+	if folds.ClosedFoldAt(line) != nil {
+		return folds.Open(line)
+	}
+	return folds.Close(line)
+}
+
+// OpenAll opens every fold.
+func (folds *Folds) OpenAll() {
+	for _, f := range folds.All() {
+		f.Closed = false
+	}
+}
+
+// CloseAll closes every fold.
+func (folds *Folds) CloseAll() {
+	for _, f := range folds.All() {
+		f.Closed = true
+	}
+}
+
+// VisibleLine returns the number of the row the line would be drawn on if every line was visible,
+// counting each closed fold as a single line.
+func (folds *Folds) VisibleLine(line int) int {
+	visible := line
+	for l := 1; l <= line; {
+		fold := folds.ClosedFoldAt(l)
+		if fold == nil {
+			l++
+			continue
+		}
+		if fold.End >= line {
+			return visible - (line - fold.Start)
+		}
+		visible -= fold.End - fold.Start
+		l = fold.End + 1
+	}
+	return visible
+}
+
+// LineAtVisible is the inverse of VisibleLine, it returns the first line of the given visible line.
+func (folds *Folds) LineAtVisible(visible int) int {
+	line := 1
+	for v := 1; v < visible; v++ {
+		if fold := folds.ClosedFoldAt(line); fold != nil {
+			line = fold.End
+		}
+		line++
+	}
+	return line
+}
+
+// NextLine returns the line after the given one, skipping over closed folds.
+func (folds *Folds) NextLine(line int) int {
+	if fold := folds.ClosedFoldAt(line); fold != nil {
+		return fold.End + 1
+	}
+	return line + 1
+}
+
+// PreviousLine returns the line before the given one, or the start of the closed fold it is in.
+func (folds *Folds) PreviousLine(line int) int {
+	if fold := folds.ClosedFoldAt(line); fold != nil {
+		line = fold.Start
+	}
+	if fold := folds.ClosedFoldAt(line - 1); fold != nil {
+		return fold.Start
+	}
+	return line - 1
+}
+
+// Update recalculates the folds for the automatic fold methods if the buffer or fold settings changed.
+// Manual folds are left alone.
+func (folds *Folds) Update(settings *Settings, buffer *Buffer) {
+	key := foldsKey{buffer, buffer.version, settings.FoldMethod, settings.FoldMarker, settings.ShiftWidth}
+	if key == folds.updated {
+		return
+	}
+	folds.updated = key
+	var ranges []Fold
+
+	switch settings.FoldMethod {
+	case "indent":
+		ranges = IndentFolds(settings, buffer)
+	case "marker":
+		ranges = MarkerFolds(settings, buffer)
+	case "syntax":
+		ranges = SyntaxFolds(buffer)
+	default:
+		return
+	}
+
+	folds.Set(ranges)
+}
+
+// Summary is the text drawn in place of a closed fold, with a dash for each level of nesting like Vim.
+func (folds *Folds) Summary(buffer *Buffer, fold *Fold) string {
+	text, _ := buffer.GetLine(fold.Start)
+	dashes := strings.Repeat("-", folds.Level(fold))
+	return fmt.Sprintf("+-%s%3d lines: %s", dashes, fold.End-fold.Start+1, strings.TrimSpace(text))
+}
+
+// Level returns how deeply a fold is nested, starting at 1 for folds that aren't inside any other.
+func (folds *Folds) Level(fold *Fold) int {
+	level := 1
+	for _, f := range folds.All() {
+		if f != fold && f.Contains(fold.Start) && f.Contains(fold.End) {
+			level++
+		}
+	}
+	return level
+}
+
+// IndentFolds creates folds from lines with the same or more indentation.
+// Each Settings.ShiftWidth of indent is one fold level, blank lines take the lower level of the lines around them.
+func IndentFolds(settings *Settings, buffer *Buffer) []Fold {
+	lines, _ := buffer.GetLines(1, buffer.LineCount())
+	levels := make([]int, len(lines))
+
+	for i, line := range lines {
+		levels[i] = -1
+		if strings.TrimSpace(line) != "" {
+			levels[i] = indentWidth(ExpandLine(settings, line)) / settings.ShiftWidth
+		}
+	}
+
+	for i := range levels {
+		if levels[i] != -1 {
+			continue
+		}
+		levels[i] = neighbourLevel(levels, i)
+	}
+
+	return foldsFromLevels(levels)
+}
+
+// neighbourLevel returns the lower of the levels of the non blank lines before and after i.
+func neighbourLevel(levels []int, i int) int {
+	before, after := 0, 0
+	for j := i - 1; j >= 0; j-- {
+		if levels[j] != -1 {
+			before = levels[j]
+			break
+		}
+	}
+	for j := i + 1; j < len(levels); j++ {
+		if levels[j] != -1 {
+			after = levels[j]
+			break
+		}
+	}
+	if before < after {
+		return before
+	}
+	return after
+}
+
+// foldsFromLevels creates a fold for each run of lines at or above each level.
+func foldsFromLevels(levels []int) []Fold {
+	folds := []Fold{}
+	maxLevel := 0
+	for _, level := range levels {
+		if level > maxLevel {
+			maxLevel = level
+		}
+	}
+
+	for level := 1; level <= maxLevel; level++ {
+		start := -1
+		for i := 0; i <= len(levels); i++ {
+			inside := i < len(levels) && levels[i] >= level
+			if inside && start == -1 {
+				start = i
+			}
+			if !inside && start != -1 {
+				if i-start > 1 {
+					folds = append(folds, Fold{Start: start + 1, End: i})
+				}
+				start = -1
+			}
+		}
+	}
+
+	return folds
+}
+
+// MarkerFolds creates folds between lines containing the Settings.FoldMarker start and end markers.
+func MarkerFolds(settings *Settings, buffer *Buffer) []Fold {
+	markers := strings.SplitN(settings.FoldMarker, ",", 2)
+	if len(markers) != 2 {
+		return nil
+	}
+
+	folds := []Fold{}
+	starts := []int{}
+	lines, _ := buffer.GetLines(1, buffer.LineCount())
+
+	for i, line := range lines {
+		if strings.Contains(line, markers[0]) {
+			starts = append(starts, i+1)
+		}
+		if strings.Contains(line, markers[1]) && len(starts) > 0 {
+			start := starts[len(starts)-1]
+			starts = starts[:len(starts)-1]
+			if i+1 > start {
+				folds = append(folds, Fold{Start: start, End: i + 1})
+			}
+		}
+	}
+
+	return folds
+}
+
+// SyntaxFolds creates folds from the lines containing matching brackets, ie the bodies of
+// functions and blocks in C-like languages. Brackets in strings and line comments are ignored.
+func SyntaxFolds(buffer *Buffer) []Fold {
+	folds := []Fold{}
+	starts := []int{}
+	lines, _ := buffer.GetLines(1, buffer.LineCount())
+
+	for i, line := range lines {
+		for _, r := range codeOnly(line) {
+			switch r {
+			case '{', '(', '[':
+				starts = append(starts, i+1)
+			case '}', ')', ']':
+				if len(starts) == 0 {
+					continue
+				}
+				start := starts[len(starts)-1]
+				starts = starts[:len(starts)-1]
+				if i+1 > start {
+					folds = append(folds, Fold{Start: start, End: i + 1})
+				}
+			}
+		}
+	}
+
+	return folds
+}
+
+// codeOnly strips strings and // comments from a line.
+func codeOnly(line string) string {
+	code := []rune{}
+	var quote rune
+	escaped := false
+	runes := []rune(line)
+
+	for i, r := range runes {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			return string(code)
+		default:
+			code = append(code, r)
+		}
+	}
+
+	return string(code)
+}
+
+func foldCommand(editor *Editor, command Command) error {
+	first, last, err := editor.CommandRange(command)
+	if err != nil {
+		return err
+	}
+	_, err = editor.CurrentPane().Folds().Create(first, last)
+	return err
+}
+
+func foldOpenCommand(editor *Editor, command Command) error {
+	return forEachFoldInRange(editor, command, func(fold *Fold) {
+		fold.Closed = false
+	})
+}
+
+func foldCloseCommand(editor *Editor, command Command) error {
+	return forEachFoldInRange(editor, command, func(fold *Fold) {
+		fold.Closed = true
+	})
+}
+
+// forEachFoldInRange runs f on the folds containing any line of the command's range.
+// With ! every nested fold is included, otherwise only the outermost.
+func forEachFoldInRange(editor *Editor, command Command, f func(*Fold)) error {
+	first, last, err := editor.CommandRange(command)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, fold := range editor.CurrentPane().Folds().All() {
+		if fold.Start > last || fold.End < first {
+			continue
+		}
+		if !command.Bang && editor.CurrentPane().Folds().Level(fold) > 1 {
+			continue
+		}
+		f(fold)
+		found = true
+	}
+
+	if !found {
+		return errors.New("No fold found")
+	}
+	return nil
+}
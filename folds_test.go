@@ -0,0 +1,228 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func foldRanges(folds []*Fold) [][2]int {
+	ranges := [][2]int{}
+	for _, f := range folds {
+		ranges = append(ranges, [2]int{f.Start, f.End})
+	}
+	return ranges
+}
+
+func TestFolds(t *testing.T) {
+	Convey("Folds with an outer and inner fold", t, func() {
+		folds := Folds{}
+		_, err := folds.Create(5, 7)
+		So(err, ShouldBeNil)
+		_, err = folds.Create(2, 10)
+		So(err, ShouldBeNil)
+
+		Convey("are sorted outer first", func() {
+			So(foldRanges(folds.All()), ShouldResemble, [][2]int{{2, 10}, {5, 7}})
+			So(folds.Level(folds.All()[1]), ShouldEqual, 2)
+		})
+
+		Convey("partially overlapping folds are rejected", func() {
+			_, err := folds.Create(6, 12)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ClosedFoldAt returns the outermost closed fold", func() {
+			So(folds.ClosedFoldAt(6).Start, ShouldEqual, 2)
+			So(folds.ClosedFoldAt(11), ShouldBeNil)
+		})
+
+		Convey("Open opens one level at a time", func() {
+			So(folds.Open(6), ShouldBeNil)
+			So(folds.ClosedFoldAt(6).Start, ShouldEqual, 5)
+			So(folds.Open(6), ShouldBeNil)
+			So(folds.ClosedFoldAt(6), ShouldBeNil)
+			So(folds.Open(6), ShouldNotBeNil)
+
+			Convey("Close closes the innermost fold first", func() {
+				So(folds.Close(6), ShouldBeNil)
+				So(folds.ClosedFoldAt(6).Start, ShouldEqual, 5)
+				So(folds.Close(6), ShouldBeNil)
+				So(folds.ClosedFoldAt(6).Start, ShouldEqual, 2)
+			})
+
+			Convey("Toggle", func() {
+				So(folds.Toggle(3), ShouldBeNil)
+				So(folds.ClosedFoldAt(3).Start, ShouldEqual, 2)
+				So(folds.Toggle(3), ShouldBeNil)
+				So(folds.ClosedFoldAt(3), ShouldBeNil)
+			})
+		})
+
+		Convey("closed folds count as a single visible line", func() {
+			So(folds.VisibleLine(1), ShouldEqual, 1)
+			So(folds.VisibleLine(2), ShouldEqual, 2)
+			So(folds.VisibleLine(8), ShouldEqual, 2)
+			So(folds.VisibleLine(11), ShouldEqual, 3)
+			So(folds.LineAtVisible(3), ShouldEqual, 11)
+			So(folds.LineAtVisible(2), ShouldEqual, 2)
+		})
+
+		Convey("NextLine and PreviousLine skip closed folds", func() {
+			So(folds.NextLine(1), ShouldEqual, 2)
+			So(folds.NextLine(2), ShouldEqual, 11)
+			So(folds.PreviousLine(11), ShouldEqual, 2)
+			So(folds.PreviousLine(6), ShouldEqual, 1)
+		})
+
+		Convey("OpenAll and CloseAll", func() {
+			folds.OpenAll()
+			So(folds.ClosedFoldAt(6), ShouldBeNil)
+			folds.CloseAll()
+			So(folds.ClosedFoldAt(6), ShouldNotBeNil)
+		})
+
+		Convey("Set keeps the open state of unchanged folds", func() {
+			folds.OpenAll()
+			folds.Set([]Fold{{Start: 2, End: 10}, {Start: 12, End: 14, Closed: true}})
+			So(foldRanges(folds.All()), ShouldResemble, [][2]int{{2, 10}, {12, 14}})
+			So(folds.ClosedFoldAt(3), ShouldBeNil)
+			So(folds.ClosedFoldAt(13), ShouldNotBeNil)
+		})
+	})
+
+	Convey("nil Folds has no folds", t, func() {
+		var folds *Folds
+		So(folds.ClosedFoldAt(1), ShouldBeNil)
+		So(folds.NextLine(1), ShouldEqual, 2)
+		So(folds.VisibleLine(4), ShouldEqual, 4)
+	})
+}
+
+func bufferWithText(text string) *Buffer {
+	buffer := NewBuffer()
+	buffer.SetDataString(text)
+	return &buffer
+}
+
+func TestAutomaticFolds(t *testing.T) {
+	settings := DefaultSettings()
+
+	Convey("IndentFolds", t, func() {
+		buffer := bufferWithText(strings.Join([]string{
+			"func a() {",
+			"    if b {",
+			"        c()",
+			"        d()",
+			"",
+			"    }",
+			"}",
+		}, "\n"))
+		So(foldRanges(toFoldPointers(IndentFolds(&settings, buffer))), ShouldResemble, [][2]int{{2, 6}, {3, 4}})
+	})
+
+	Convey("MarkerFolds", t, func() {
+		buffer := bufferWithText("a {{{\nb\nc {{{\nd }}}\ne }}}\nf")
+		So(foldRanges(toFoldPointers(MarkerFolds(&settings, buffer))), ShouldResemble, [][2]int{{3, 4}, {1, 5}})
+	})
+
+	Convey("SyntaxFolds ignores brackets in strings and comments", t, func() {
+		buffer := bufferWithText("func a() {\n\ts := \"{\" // {\n\tb(\n\t\t1)\n}")
+		So(foldRanges(toFoldPointers(SyntaxFolds(buffer))), ShouldResemble, [][2]int{{3, 4}, {1, 5}})
+	})
+
+	Convey("Update only changes automatic folds", t, func() {
+		buffer := bufferWithText("a {{{\nb }}}\n")
+		folds := Folds{}
+		folds.Create(1, 2)
+		folds.Update(&settings, buffer)
+		So(len(folds.All()), ShouldEqual, 1)
+		So(folds.All()[0].Closed, ShouldBeTrue)
+
+		settings.FoldMethod = "marker"
+		folds.Delete()
+		folds.Update(&settings, buffer)
+		So(foldRanges(folds.All()), ShouldResemble, [][2]int{{1, 2}})
+
+		Convey("and only when the buffer or settings change", func() {
+			folds.Set(nil)
+			folds.Update(&settings, buffer)
+			So(folds.All(), ShouldBeEmpty)
+
+			buffer.InsertLines(0, []string{"zero"})
+			folds.Update(&settings, buffer)
+			So(foldRanges(folds.All()), ShouldResemble, [][2]int{{2, 3}})
+
+			settings.FoldMarker = "<,>"
+			folds.Update(&settings, buffer)
+			So(folds.All(), ShouldBeEmpty)
+		})
+	})
+}
+
+func toFoldPointers(folds []Fold) []*Fold {
+	pointers := []*Fold{}
+	for i := range folds {
+		pointers = append(pointers, &folds[i])
+	}
+	return pointers
+}
+
+func TestRenderFolds(t *testing.T) {
+	Convey("Editor with a closed fold", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{"a.go": []byte("a\nb {\nc\nd\n}\ne\n")})
+		editor := NewEditor(fs)
+		editor.OpenFile("a.go")
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		editor.Settings().FoldColumn = 1
+		editor.CurrentPane().Folds().Create(2, 5)
+		grid := NewRuneGrid(20, 4)
+		grid.RenderEditor(&editor)
+
+		Convey("draws the fold as a summary line", func() {
+			So(string(grid.Cells()[0][:2]), ShouldEqual, " a")
+			So(string(grid.Cells()[1]), ShouldEqual, "++--  4 lines: b {--")
+			So(string(grid.Cells()[2][:2]), ShouldEqual, " e")
+			So(grid.Style(1, 1), ShouldEqual, StyleFolded)
+		})
+
+		Convey("the cursor moves over the fold as one line", func() {
+			cursor := editor.CurrentPane().Cursor()
+			cursor.Move(cursor.DownLine())
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 2)
+
+			cursor.Move(cursor.DownLine())
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 6)
+
+			cursor.Move(cursor.UpLine())
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 2)
+		})
+
+		Convey("za opens it again", func() {
+			editor.CurrentPane().Cursor().Move(0, 3)
+			editor.HandleNormalKey('z')
+			editor.HandleNormalKey('a')
+			grid = NewRuneGrid(20, 4)
+			grid.RenderEditor(&editor)
+			So(string(grid.Cells()[1][:4]), ShouldEqual, "-b {")
+			So(string(grid.Cells()[2][:2]), ShouldEqual, "|c")
+		})
+	})
+
+	Convey("UpdateTopLine counts a closed fold as one line", t, func() {
+		buffer := bufferWithText(strings.Repeat("x\n", 20))
+		pane := NewPane()
+		pane.SetBuffer(buffer)
+		pane.Folds().Create(2, 15)
+		pane.Cursor().Move(0, 17)
+		settings := DefaultSettings()
+
+		UpdateTopLine(&settings, &pane, 3)
+		So(pane.TopLine(), ShouldEqual, 1)
+	})
+}
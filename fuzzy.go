@@ -0,0 +1,121 @@
+package main
+
+import "unicode"
+
+// Scores of the parts of a fuzzy match.
+const (
+	fuzzyMatchScore       = 16
+	fuzzyConsecutiveBonus = 12
+	fuzzyBoundaryBonus    = 10
+	fuzzyFirstBonus       = 8
+	fuzzyGapPenalty       = 1
+)
+
+// fuzzyNone marks where the pattern can't be matched in the score table.
+const fuzzyNone = -1 << 30
+
+// FuzzyMatch finds the characters of the pattern in order in the text and scores how well they match,
+// higher is better. Matches at the start of words and runs of matched characters score more, gaps less.
+// Like Smith-Waterman it scores every alignment and keeps the best one, not the first one found.
+// It is case insensitive unless the pattern has an upper case letter. The positions are the indexes of
+// the matched runes in the text, ok is false if the pattern isn't in the text.
+func FuzzyMatch(pattern, text string) (score int, positions []int, ok bool) {
+	pat, runes := []rune(pattern), []rune(text)
+	if len(pat) == 0 {
+		return 0, nil, true
+	}
+	ignoreCase := true
+	for _, r := range pat {
+		if unicode.IsUpper(r) {
+			ignoreCase = false
+		}
+	}
+	equal := func(a, b rune) bool {
+		if ignoreCase {
+			return unicode.ToLower(a) == unicode.ToLower(b)
+		}
+		return a == b
+	}
+
+	// Most texts don't match at all, so check that before filling in the table.
+	p := 0
+	for _, r := range runes {
+		if p < len(pat) && equal(r, pat[p]) {
+			p++
+		}
+	}
+	if p < len(pat) {
+		return 0, nil, false
+	}
+
+	// scores[i][j] is the best score of pat[:i+1] with pat[i] matched at runes[j], from[i][j] is
+	// where pat[i-1] was matched to get it.
+	scores, from := make([][]int, len(pat)), make([][]int, len(pat))
+	for i := range pat {
+		scores[i], from[i] = make([]int, len(runes)), make([]int, len(runes))
+		// The best score of pat[i-1] matched before runes[j-1], less the gap to j.
+		gapBest, gapAt := fuzzyNone, -1
+		for j, r := range runes {
+			scores[i][j] = fuzzyNone
+			if i > 0 && j >= 2 {
+				gapBest -= fuzzyGapPenalty
+				if previous := scores[i-1][j-2] - fuzzyGapPenalty; previous > gapBest {
+					gapBest, gapAt = previous, j-2
+				}
+			}
+			if !equal(r, pat[i]) {
+				continue
+			}
+
+			base := fuzzyMatchScore
+			if fuzzyBoundary(runes, j) {
+				base += fuzzyBoundaryBonus
+				if i == 0 {
+					base += fuzzyFirstBonus
+				}
+			}
+			if i == 0 {
+				scores[i][j], from[i][j] = base, -1
+				continue
+			}
+			best, at := fuzzyNone, -1
+			if j > 0 && scores[i-1][j-1] > fuzzyNone {
+				best, at = scores[i-1][j-1]+fuzzyConsecutiveBonus, j-1
+			}
+			if gapAt != -1 && gapBest > best {
+				best, at = gapBest, gapAt
+			}
+			if at != -1 {
+				scores[i][j], from[i][j] = base+best, at
+			}
+		}
+	}
+
+	last, end := len(pat)-1, -1
+	for j := range runes {
+		if scores[last][j] > fuzzyNone && (end == -1 || scores[last][j] > scores[last][end]) {
+			end = j
+		}
+	}
+	positions = make([]int, len(pat))
+	for i, j := last, end; i >= 0; i-- {
+		positions[i] = j
+		j = from[i][j]
+	}
+	return scores[last][end], positions, true
+}
+
+// fuzzyBoundary returns true if the rune at i starts a word, ie after a separator or a camelCase hump.
+func fuzzyBoundary(runes []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	previous, r := runes[i-1], runes[i]
+	switch {
+	case !unicode.IsLetter(previous) && !unicode.IsDigit(previous):
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	case unicode.IsLower(previous) && unicode.IsUpper(r):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	Convey("Fuzzy matching", t, func() {
+		Convey("finds the pattern's characters in order", func() {
+			_, positions, ok := FuzzyMatch("fbr", "fooBar")
+			So(ok, ShouldBeTrue)
+			So(positions, ShouldResemble, []int{0, 3, 5})
+			_, _, ok = FuzzyMatch("rbf", "fooBar")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("matches everything with an empty pattern", func() {
+			score, positions, ok := FuzzyMatch("", "anything")
+			So(ok, ShouldBeTrue)
+			So(score, ShouldEqual, 0)
+			So(positions, ShouldBeEmpty)
+		})
+
+		Convey("is case sensitive only with upper case in the pattern", func() {
+			_, _, ok := FuzzyMatch("readme", "README.md")
+			So(ok, ShouldBeTrue)
+			_, _, ok = FuzzyMatch("Readme", "README.md")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("prefers the starts of words and runs of characters", func() {
+			boundary, _, _ := FuzzyMatch("fb", "foo_bar")
+			middle, _, _ := FuzzyMatch("fb", "afxb")
+			So(boundary, ShouldBeGreaterThan, middle)
+
+			prefix, _, _ := FuzzyMatch("buf", "buffer.go")
+			scattered, _, _ := FuzzyMatch("buf", "b_u_f.go")
+			So(prefix, ShouldBeGreaterThan, scattered)
+		})
+
+		Convey("uses the best match rather than the first", func() {
+			_, positions, _ := FuzzyMatch("ab", "xa_b_ab")
+			So(positions, ShouldResemble, []int{5, 6})
+			_, positions, _ = FuzzyMatch("ab", "a_xab")
+			So(positions, ShouldResemble, []int{0, 4})
+		})
+	})
+}
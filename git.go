@@ -0,0 +1,581 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dcbishop/jkl/git"
+)
+
+// gitSignPriority puts the signs of changed lines under those of diagnostics.
+const gitSignPriority = 0
+
+// UseGit shows which lines of files in git repositories have changed.
+func UseGit() func(*App) error {
+	return func(a *App) error {
+		a.Editor().EnableGit()
+		return nil
+	}
+}
+
+// gitClient is the editor's side of git, what the files of buffers are compared to.
+type gitClient struct {
+	files map[*Buffer]*gitFile
+	blame *gitBlame
+}
+
+// gitFile is a buffer of a file in a repository. base is the file in the index or HEAD, as the
+// GitBase setting says, and hunks are where the buffer differs from it as of version.
+type gitFile struct {
+	repo    *git.Repository
+	path    string
+	against string
+	stamp   string
+	tracked bool
+	base    []string
+	version int
+	hunks   []DiffHunk
+}
+
+// gitBlame is the pane showing who changed the lines of the buffer in source.
+type gitBlame struct {
+	pane   *Pane
+	source *Pane
+}
+
+// BlameLine is who last changed a line, Commit is zero for changes that aren't committed.
+type BlameLine struct {
+	Commit git.Hash
+	Author string
+	When   time.Time
+}
+
+// EnableGit puts signs on the changed lines of the buffers of files in git repositories, those open
+// now and those opened later.
+func (editor *Editor) EnableGit() {
+	if editor.git.files != nil {
+		return
+	}
+	editor.git.files = map[*Buffer]*gitFile{}
+	bus := editor.Events()
+	refresh := func(event EditorEvent) { editor.refreshGit(event.Buffer) }
+	bus.Subscribe(BufRead, "", refresh)
+	bus.Subscribe(BufEnter, "", refresh)
+	bus.Subscribe(TextChanged, "", refresh)
+	bus.Subscribe(BufWritePost, "", func(event EditorEvent) {
+		delete(editor.git.files, event.Buffer)
+		editor.refreshGit(event.Buffer)
+	})
+	bus.Subscribe(BufDelete, "", func(event EditorEvent) {
+		delete(editor.git.files, event.Buffer)
+		event.Buffer.ClearSigns("git")
+	})
+	bus.Subscribe(CursorMoved, "", func(event EditorEvent) { editor.syncBlame(event.Pane) })
+	for _, buffer := range editor.buffers {
+		editor.refreshGit(buffer)
+	}
+}
+
+// gitFileOf returns the git state of a buffer, finding its repository the first time. It's nil for
+// buffers of files that aren't in one.
+func (editor *Editor) gitFileOf(buffer *Buffer) *gitFile {
+	if buffer == nil || buffer.Filename() == "" || buffer.Terminal() != nil {
+		return nil
+	}
+	if file, ok := editor.git.files[buffer]; ok {
+		return file
+	}
+	var file *gitFile
+	if repo, err := git.Open(editor.fs, buffer.Filename()); err == nil {
+		if path, err := repo.RelativePath(buffer.Filename()); err == nil {
+			file = &gitFile{repo: repo, path: path, version: -1}
+		}
+	}
+	editor.git.files[buffer] = file
+	return file
+}
+
+// refreshGit compares a buffer to its base again if it or the base changed and puts signs on the lines
+// that differ.
+func (editor *Editor) refreshGit(buffer *Buffer) {
+	file := editor.gitFileOf(buffer)
+	if file == nil {
+		return
+	}
+	against := editor.Settings().GitBase
+	if stamp := file.baseStamp(against); against != file.against || stamp != file.stamp {
+		data, tracked, err := file.read(against)
+		if err != nil {
+			editor.EchoError(err)
+			return
+		}
+		file.against, file.stamp, file.tracked, file.base, file.version = against, stamp, tracked, splitLines(data), -1
+	}
+	if file.version == buffer.version {
+		return
+	}
+	file.version = buffer.version
+	file.hunks = nil
+	if file.tracked {
+		file.hunks = DiffLines(file.base, bufferLines(buffer, 1, buffer.LineCount()))
+	}
+
+	buffer.ClearSigns("git")
+	for _, hunk := range file.hunks {
+		if hunk.NewLines == 0 {
+			sign := Sign{Group: "git", Line: hunk.NewStart, Text: "_", Style: StyleSignDelete, Priority: gitSignPriority}
+			if hunk.NewStart == 0 {
+				sign.Line, sign.Text = 1, "‾"
+			}
+			buffer.PlaceSign(sign)
+			continue
+		}
+		for i := 0; i < hunk.NewLines; i++ {
+			sign := Sign{Group: "git", Line: hunk.NewStart + i + 1, Text: "~", Style: StyleSignChange, Priority: gitSignPriority}
+			if i >= hunk.OldLines {
+				sign.Text, sign.Style = "+", StyleSignAdd
+			}
+			buffer.PlaceSign(sign)
+		}
+	}
+}
+
+// baseStamp returns what changes when the index or HEAD does, so the file is read again after git is run
+// outside the editor.
+func (file *gitFile) baseStamp(against string) string {
+	if against == "head" {
+		head, _ := file.repo.Head()
+		return head.String()
+	}
+	modified, size := file.repo.IndexStamp()
+	return fmt.Sprint(modified.UnixNano(), size)
+}
+
+// read returns the file as it is in the index or HEAD, tracked is false if it isn't there.
+func (file *gitFile) read(against string) (data []byte, tracked bool, err error) {
+	if against == "head" {
+		head, err := file.repo.Head()
+		if err == git.ErrNoCommits {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		data, _, err = file.repo.ReadFile(head, file.path)
+		if err == git.ErrNotFound {
+			return nil, false, nil
+		}
+		return data, err == nil, err
+	}
+
+	index, err := file.repo.ReadIndex()
+	if err != nil {
+		return nil, false, err
+	}
+	entry := index.Entry(file.path)
+	if entry == nil {
+		return nil, false, nil
+	}
+	_, data, err = file.repo.ReadObject(entry.Hash)
+	return data, err == nil, err
+}
+
+// splitLines splits the contents of a file into lines, a newline ends the last one.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// GitHunks returns where a buffer differs from its file in the index or HEAD.
+func (editor *Editor) GitHunks(buffer *Buffer) []DiffHunk {
+	editor.EnableGit()
+	editor.refreshGit(buffer)
+	if file := editor.gitFileOf(buffer); file != nil {
+		return file.hunks
+	}
+	return nil
+}
+
+// hunkLines returns the first and last line of the buffer a hunk is on, lines that were removed are
+// shown on the line above them.
+func hunkLines(hunk DiffHunk) (first, last int) {
+	if hunk.NewLines == 0 {
+		if hunk.NewStart == 0 {
+			return 1, 1
+		}
+		return hunk.NewStart, hunk.NewStart
+	}
+	return hunk.NewStart + 1, hunk.NewStart + hunk.NewLines
+}
+
+// gitFileHere returns the git state of the current buffer, an error if it isn't in a repository.
+func (editor *Editor) gitFileHere() (*gitFile, error) {
+	buffer := editor.CurrentPane().Buffer()
+	editor.EnableGit()
+	file := editor.gitFileOf(buffer)
+	if file == nil {
+		return nil, errors.New("Not in a git repository")
+	}
+	editor.refreshGit(buffer)
+	return file, nil
+}
+
+// NextHunk moves the cursor to the next change to the buffer, or the previous one if count is negative.
+func (editor *Editor) NextHunk(count int) error {
+	file, err := editor.gitFileHere()
+	if err != nil {
+		return err
+	}
+	cursor := editor.CurrentPane().Cursor()
+	_, line := cursor.Position()
+	target := 0
+	for _, hunk := range file.hunks {
+		first, last := hunkLines(hunk)
+		switch {
+		case count > 0 && first > line && target == 0:
+			target = first
+		case count < 0 && last < line:
+			target = first
+		}
+	}
+	if target == 0 {
+		return errors.New("No more hunks")
+	}
+	cursor.Move(0, target)
+	return nil
+}
+
+// hunkHere returns the hunk under the cursor.
+func (editor *Editor) hunkHere(file *gitFile) (DiffHunk, error) {
+	_, line := editor.CurrentPane().Cursor().Position()
+	for _, hunk := range file.hunks {
+		if first, last := hunkLines(hunk); line >= first && line <= last {
+			return hunk, nil
+		}
+	}
+	return DiffHunk{}, errors.New("No hunk under the cursor")
+}
+
+// PreviewHunk shows the lines of the hunk under the cursor as they were and are.
+func (editor *Editor) PreviewHunk() error {
+	file, err := editor.gitFileHere()
+	if err != nil {
+		return err
+	}
+	hunk, err := editor.hunkHere(file)
+	if err != nil {
+		return err
+	}
+	buffer := editor.CurrentPane().Buffer()
+	lines := []string{}
+	for _, line := range file.base[hunk.OldStart : hunk.OldStart+hunk.OldLines] {
+		lines = append(lines, "-"+line)
+	}
+	for _, line := range bufferLines(buffer, hunk.NewStart+1, hunk.NewStart+hunk.NewLines) {
+		lines = append(lines, "+"+line)
+	}
+	editor.showHover(lines)
+	return nil
+}
+
+// RevertHunk puts back the lines of the hunk under the cursor as they are in the index or HEAD.
+func (editor *Editor) RevertHunk() error {
+	file, err := editor.gitFileHere()
+	if err != nil {
+		return err
+	}
+	hunk, err := editor.hunkHere(file)
+	if err != nil {
+		return err
+	}
+	buffer := editor.CurrentPane().Buffer()
+	lines := append([]string{}, file.base[hunk.OldStart:hunk.OldStart+hunk.OldLines]...)
+	if hunk.NewLines == 0 {
+		err = buffer.InsertLines(hunk.NewStart, lines)
+	} else {
+		err = buffer.ReplaceLines(hunk.NewStart+1, hunk.NewStart+hunk.NewLines, lines)
+	}
+	if err != nil {
+		return err
+	}
+	first, _ := hunkLines(hunk)
+	editor.CurrentPane().Cursor().Move(0, first)
+	editor.refreshGit(buffer)
+	return nil
+}
+
+// StageHunk adds the hunk under the cursor to the index, a file that isn't in it is added whole.
+func (editor *Editor) StageHunk() error {
+	file, err := editor.gitFileHere()
+	if err != nil {
+		return err
+	}
+	buffer := editor.CurrentPane().Buffer()
+	data, _, err := file.read("index")
+	if err != nil {
+		return err
+	}
+	staged := splitLines(data)
+	lines := bufferLines(buffer, 1, buffer.LineCount())
+	newline := len(data) == 0 || data[len(data)-1] == '\n'
+
+	_, line := editor.CurrentPane().Cursor().Position()
+	found := false
+	for _, hunk := range DiffLines(staged, lines) {
+		if first, last := hunkLines(hunk); line < first || line > last {
+			continue
+		}
+		if hunk.OldStart+hunk.OldLines == len(staged) {
+			// The hunk ends the file, so its last line is staged as it's written, with or without the newline.
+			newline = buffer.endsWithNewline()
+		}
+		changed := append([]string{}, staged[:hunk.OldStart]...)
+		changed = append(changed, lines[hunk.NewStart:hunk.NewStart+hunk.NewLines]...)
+		staged = append(changed, staged[hunk.OldStart+hunk.OldLines:]...)
+		found = true
+		break
+	}
+	if !found {
+		return errors.New("No hunk under the cursor")
+	}
+
+	text := strings.Join(staged, "\n")
+	if len(staged) > 0 && newline {
+		text += "\n"
+	}
+	hash, err := file.repo.WriteObject(git.BlobObject, []byte(text))
+	if err != nil {
+		return err
+	}
+	index, err := file.repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	entry := git.IndexEntry{Mode: git.ModeFile, Path: file.path}
+	if old := index.Entry(file.path); old != nil {
+		entry.Mode = old.Mode
+	} else if info, err := editor.fs.Stat(buffer.Filename()); err == nil && info.Mode()&0111 != 0 {
+		entry.Mode = git.ModeExecutable
+	}
+	// The times are left at 0 so git reads the file again to see if it matches what's staged.
+	entry.Hash, entry.Size = hash, uint32(len(text))
+	index.Set(entry)
+	if err := file.repo.WriteIndex(index); err != nil {
+		return err
+	}
+	file.against = ""
+	editor.refreshGit(buffer)
+	editor.Echo("Staged hunk")
+	return nil
+}
+
+// Blame returns who last changed each line of a buffer, following the file back through the first parents
+// of HEAD. Lines that differ from HEAD aren't committed yet.
+func (editor *Editor) Blame(buffer *Buffer) ([]BlameLine, error) {
+	editor.EnableGit()
+	file := editor.gitFileOf(buffer)
+	if file == nil {
+		return nil, errors.New("Not in a git repository")
+	}
+	repo := file.repo
+	hash, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	data, blob, err := repo.ReadFile(hash, file.path)
+	if err == git.ErrNotFound {
+		return nil, fmt.Errorf("%s isn't committed", file.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bufferLines(buffer, 1, buffer.LineCount())
+	blame := make([]BlameLine, len(lines))
+	committed := splitLines(data)
+	// pending has the lines of the buffer that are still to be blamed, by their line in committed.
+	pending := unchangedLines(committed, lines)
+	for len(pending) > 0 {
+		commit, err := repo.Commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		var parentData []byte
+		parentBlob := git.Hash{}
+		if len(commit.Parents) > 0 {
+			parentData, parentBlob, err = repo.ReadFile(commit.Parents[0], file.path)
+			if err != nil && err != git.ErrNotFound {
+				return nil, err
+			}
+		}
+		if parentBlob == blob && len(commit.Parents) > 0 {
+			hash = commit.Parents[0]
+			continue
+		}
+
+		parentLines := splitLines(parentData)
+		older := map[int]int{}
+		if !parentBlob.IsZero() {
+			older = unchangedLines(parentLines, committed)
+		}
+		next := map[int]int{}
+		for line, bufferLine := range pending {
+			if parentLine, ok := older[line]; ok {
+				next[parentLine] = bufferLine
+				continue
+			}
+			blame[bufferLine] = BlameLine{Commit: hash, Author: commit.Author.Name, When: commit.Author.When}
+		}
+		if len(next) == 0 {
+			break
+		}
+		pending, committed, blob, hash = next, parentLines, parentBlob, commit.Parents[0]
+	}
+	return blame, nil
+}
+
+// unchangedLines maps the lines of the new text that are in the old one to their line in the old one,
+// keyed by the line in the new.
+func unchangedLines(old, new []string) map[int]int {
+	unchanged := map[int]int{}
+	i, j := 0, 0
+	for _, hunk := range append(DiffLines(old, new), DiffHunk{OldStart: len(old), NewStart: len(new)}) {
+		for ; j < hunk.NewStart; i, j = i+1, j+1 {
+			unchanged[j] = i
+		}
+		i, j = hunk.OldStart+hunk.OldLines, hunk.NewStart+hunk.NewLines
+	}
+	return unchanged
+}
+
+// blameAuthorWidth is the most of an author's name the blame pane shows.
+const blameAuthorWidth = 20
+
+// ToggleBlame shows who changed the lines of the current buffer in a pane to its left, which scrolls
+// with it, or closes that pane.
+func (editor *Editor) ToggleBlame() error {
+	if blame := editor.git.blame; blame != nil {
+		editor.git.blame = nil
+		if editor.hasPane(blame.pane) {
+			return editor.ClosePane(blame.pane)
+		}
+	}
+	source := editor.CurrentPane()
+	lines, err := editor.Blame(source.Buffer())
+	if err != nil {
+		return err
+	}
+
+	authorWidth := len("Not Committed Yet")
+	for _, line := range lines {
+		if n := len([]rune(line.Author)); n > authorWidth {
+			authorWidth = n
+		}
+	}
+	if authorWidth > blameAuthorWidth {
+		authorWidth = blameAuthorWidth
+	}
+	text := &strings.Builder{}
+	for _, line := range lines {
+		if line.Commit.IsZero() {
+			fmt.Fprintf(text, "%s %-*s\n", strings.Repeat("0", 8), authorWidth, "Not Committed Yet")
+			continue
+		}
+		author := []rune(line.Author)
+		if len(author) > authorWidth {
+			author = author[:authorWidth]
+		}
+		fmt.Fprintf(text, "%s %-*s %s\n", line.Commit.String()[:8], authorWidth, string(author), line.When.Format("2006-01-02"))
+	}
+
+	newBuffer := NewBuffer()
+	newBuffer.SetDataString(text.String())
+	buffer := editor.AddBuffer(&newBuffer)
+	buffer.listed = false
+	pane := editor.SplitPane(true)
+	pane.SetBuffer(buffer)
+	pane.SetWrap(false)
+	editor.layout.Find(pane).Size = 8 + 1 + authorWidth + 1 + len("2006-01-02") + 1
+	editor.git.blame = &gitBlame{pane: pane, source: source}
+	editor.syncBlame(source)
+	return nil
+}
+
+// hasPane returns true if a pane is in the layout.
+func (editor *Editor) hasPane(pane *Pane) bool {
+	for _, p := range editor.panes {
+		if p == pane {
+			return true
+		}
+	}
+	return false
+}
+
+// syncBlame moves the cursor of the blame pane or its source to the line of the other one's cursor.
+func (editor *Editor) syncBlame(moved *Pane) {
+	blame := editor.git.blame
+	if blame == nil || moved != blame.pane && moved != blame.source {
+		return
+	}
+	if !editor.hasPane(blame.pane) || !editor.hasPane(blame.source) {
+		editor.git.blame = nil
+		return
+	}
+	other := blame.pane
+	if moved == blame.pane {
+		other = blame.source
+	}
+	_, line := moved.Cursor().Position()
+	x, _ := other.Cursor().Position()
+	other.Cursor().Move(x, line)
+	other.SetTopLine(moved.TopLine())
+}
+
+// gitCommand runs the git commands on the hunk under the cursor, ":git" on its own counts the hunks.
+func gitCommand(editor *Editor, command Command) error {
+	fields := strings.Fields(command.Args)
+	if len(fields) == 0 {
+		file, err := editor.gitFileHere()
+		if err != nil {
+			return err
+		}
+		added, changed, removed := 0, 0, 0
+		for _, hunk := range file.hunks {
+			switch {
+			case hunk.NewLines > hunk.OldLines:
+				changed += hunk.OldLines
+				added += hunk.NewLines - hunk.OldLines
+			default:
+				changed += hunk.NewLines
+				removed += hunk.OldLines - hunk.NewLines
+			}
+		}
+		editor.Echo(fmt.Sprintf("%s: %d hunks, +%d ~%d -%d against the %s", file.path, len(file.hunks), added, changed, removed, file.against))
+		return nil
+	}
+
+	switch fields[0] {
+	case "next":
+		return editor.NextHunk(1)
+	case "prev":
+		return editor.NextHunk(-1)
+	case "preview":
+		return editor.PreviewHunk()
+	case "stage":
+		return editor.StageHunk()
+	case "revert":
+		return editor.RevertHunk()
+	case "blame":
+		return editor.ToggleBlame()
+	}
+	return fmt.Errorf("Unknown git command: %s", fields[0])
+}
+
+// blameCommand shows or hides the blame pane.
+func blameCommand(editor *Editor, command Command) error {
+	return editor.ToggleBlame()
+}
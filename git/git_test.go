@@ -0,0 +1,314 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+// commitFiles commits files at the top of a repository on top of HEAD.
+func commitFiles(r *Repository, message string, files map[string]string) Hash {
+	entries := []TreeEntry{}
+	index := &Index{}
+	for name, text := range files {
+		hash, err := r.WriteObject(BlobObject, []byte(text))
+		So(err, ShouldBeNil)
+		entries = append(entries, TreeEntry{Mode: ModeFile, Name: name, Hash: hash})
+		index.Set(IndexEntry{Mode: ModeFile, Hash: hash, Path: name})
+	}
+	tree, err := r.WriteTree(entries)
+	So(err, ShouldBeNil)
+	who := Signature{"Ann", "ann@example.com", time.Unix(1500000000, 0).In(time.FixedZone("", 3600))}
+	commit := &Commit{Tree: tree, Author: who, Committer: who, Message: message + "\n"}
+	if head, err := r.Head(); err == nil {
+		commit.Parents = []Hash{head}
+	}
+	hash, err := r.WriteCommit(commit)
+	So(err, ShouldBeNil)
+	So(r.UpdateRef("HEAD", hash), ShouldBeNil)
+	So(r.WriteIndex(index), ShouldBeNil)
+	return hash
+}
+
+// run runs git in a directory.
+func run(dir string, args ...string) string {
+	command := exec.Command("git", args...)
+	command.Dir = dir
+	command.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Bob", "GIT_AUTHOR_EMAIL=bob@example.com",
+		"GIT_COMMITTER_NAME=Bob", "GIT_COMMITTER_EMAIL=bob@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := command.CombinedOutput()
+	So(err, ShouldBeNil)
+	return strings.TrimSpace(string(out))
+}
+
+func TestRepository(t *testing.T) {
+	Convey("A new repository", t, func() {
+		fs := afero.NewMemMapFs()
+		r, err := Init(fs, "/work")
+		So(err, ShouldBeNil)
+
+		Convey("has no commits", func() {
+			_, err := r.Head()
+			So(err, ShouldEqual, ErrNoCommits)
+			index, err := r.ReadIndex()
+			So(err, ShouldBeNil)
+			So(index.Entries, ShouldBeEmpty)
+		})
+
+		Convey("is found from the files in it", func() {
+			fs.MkdirAll("/work/src/deep", 0755)
+			afero.WriteFile(fs, "/work/src/deep/main.go", []byte("package main\n"), 0644)
+			found, err := Open(fs, "/work/src/deep/main.go")
+			So(err, ShouldBeNil)
+			So(found.WorkTree(), ShouldEqual, "/work")
+			path, err := found.RelativePath("/work/src/deep/main.go")
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, "src/deep/main.go")
+
+			_, err = found.RelativePath("/elsewhere/file")
+			So(err, ShouldNotBeNil)
+			_, err = Open(fs, "/elsewhere/file")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is found through a .git file", func() {
+			afero.WriteFile(fs, "/other/.git", []byte("gitdir: ../work/.git\n"), 0644)
+			found, err := Open(fs, "/other")
+			So(err, ShouldBeNil)
+			So(found.WorkTree(), ShouldEqual, "/other")
+			So(found.gitDir, ShouldEqual, "/work/.git")
+		})
+
+		Convey("stores objects by their hash", func() {
+			hash, err := r.WriteObject(BlobObject, []byte("hello\n"))
+			So(err, ShouldBeNil)
+			So(hash.String(), ShouldEqual, "ce013625030ba8dba906f756967f9e9ca394464a")
+			objectType, data, err := r.ReadObject(hash)
+			So(err, ShouldBeNil)
+			So(objectType, ShouldEqual, BlobObject)
+			So(string(data), ShouldEqual, "hello\n")
+
+			_, _, err = r.ReadObject(Hash{1})
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("with commits", func() {
+			first := commitFiles(r, "First", map[string]string{"a.txt": "one\n"})
+			second := commitFiles(r, "Second", map[string]string{"a.txt": "two\n", "b.txt": "bee\n"})
+
+			Convey("moves its branch", func() {
+				head, err := r.Head()
+				So(err, ShouldBeNil)
+				So(head, ShouldEqual, second)
+				master, err := r.ResolveRef("refs/heads/master")
+				So(err, ShouldBeNil)
+				So(master, ShouldEqual, second)
+			})
+
+			Convey("reads them back", func() {
+				commit, err := r.Commit(second)
+				So(err, ShouldBeNil)
+				So(commit.Parents, ShouldResemble, []Hash{first})
+				So(commit.Author.Name, ShouldEqual, "Ann")
+				So(commit.Author.Email, ShouldEqual, "ann@example.com")
+				So(commit.Author.When.Unix(), ShouldEqual, 1500000000)
+				So(commit.Author.String(), ShouldEqual, "Ann <ann@example.com> 1500000000 +0100")
+				So(commit.Message, ShouldEqual, "Second\n")
+			})
+
+			Convey("reads the files in them", func() {
+				data, _, err := r.ReadFile(first, "a.txt")
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "one\n")
+				data, _, err = r.ReadFile(second, "a.txt")
+				So(string(data), ShouldEqual, "two\n")
+				_, _, err = r.ReadFile(first, "b.txt")
+				So(err, ShouldEqual, ErrNotFound)
+				_, _, err = r.ReadFile(first, "a.txt/c")
+				So(err, ShouldEqual, ErrNotFound)
+			})
+
+			Convey("keeps the files in the index", func() {
+				index, err := r.ReadIndex()
+				So(err, ShouldBeNil)
+				So(index.Entries, ShouldHaveLength, 2)
+				So(index.Entry("b.txt").Hash, ShouldEqual, HashObject(BlobObject, []byte("bee\n")))
+				So(index.Entry("c.txt"), ShouldBeNil)
+
+				index.Set(IndexEntry{Mode: ModeFile, Path: "a.b", Hash: Hash{2}, Flags: 2 << 12})
+				index.Set(IndexEntry{Mode: ModeFile, Path: "a.txt", Hash: Hash{3}})
+				So(r.WriteIndex(index), ShouldBeNil)
+				index, err = r.ReadIndex()
+				So(err, ShouldBeNil)
+				So(index.Entries, ShouldHaveLength, 3)
+				So(index.Entries[0].Path, ShouldEqual, "a.b")
+				So(index.Entries[0].Stage(), ShouldEqual, 2)
+				So(index.Entry("a.b"), ShouldBeNil)
+				So(index.Entry("a.txt").Hash, ShouldEqual, Hash{3})
+
+				index.Extensions = []IndexExtension{{Signature: "REUC", Data: []byte("a.txt\x00")}}
+				So(r.WriteIndex(index), ShouldBeNil)
+				index, err = r.ReadIndex()
+				So(err, ShouldBeNil)
+				So(index.Extensions, ShouldResemble, []IndexExtension{{Signature: "REUC", Data: []byte("a.txt\x00")}})
+				So(index.Entries, ShouldHaveLength, 3)
+
+				Convey("but not those about the entries as they were", func() {
+					index.Extensions = append(index.Extensions,
+						IndexExtension{Signature: "FSMN", Data: []byte{0, 0, 0, 1}},
+						IndexExtension{Signature: "UNTR", Data: []byte{0}})
+					So(r.WriteIndex(index), ShouldBeNil)
+					index, err = r.ReadIndex()
+					So(err, ShouldBeNil)
+					index.Set(IndexEntry{Mode: ModeFile, Path: "new.txt", Hash: Hash{4}})
+					So(r.WriteIndex(index), ShouldBeNil)
+					index, err = r.ReadIndex()
+					So(err, ShouldBeNil)
+					So(index.Extensions, ShouldResemble, []IndexExtension{{Signature: "REUC", Data: []byte("a.txt\x00")}})
+					So(index.Entries, ShouldHaveLength, 4)
+				})
+			})
+
+			Convey("refuses to write the index while it's locked", func() {
+				afero.WriteFile(fs, "/work/.git/index.lock", nil, 0644)
+				So(r.WriteIndex(&Index{}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("keeps trees in git's order", func() {
+			hash, _ := r.WriteObject(BlobObject, nil)
+			tree, err := r.WriteTree([]TreeEntry{
+				{Mode: ModeFile, Name: "a.c", Hash: hash},
+				{Mode: ModeDir, Name: "a", Hash: hash},
+				{Mode: ModeFile, Name: "a-", Hash: hash},
+			})
+			So(err, ShouldBeNil)
+			entries, err := r.Tree(tree)
+			So(err, ShouldBeNil)
+			So([]string{entries[0].Name, entries[1].Name, entries[2].Name}, ShouldResemble, []string{"a-", "a.c", "a"})
+		})
+	})
+
+	Convey("Deltas", t, func() {
+		base := []byte("hello world")
+		Convey("copy from the base and insert new bytes", func() {
+			delta := []byte{11, 12, 0x91, 6, 5, 2, '!', '!', 0x90, 5}
+			patched, err := applyDelta(base, delta)
+			So(err, ShouldBeNil)
+			So(string(patched), ShouldEqual, "world!!hello")
+		})
+
+		Convey("that don't fit are corrupt", func() {
+			_, err := applyDelta(base, []byte{12, 1, 1, 'x'})
+			So(err, ShouldEqual, errCorruptPack)
+			_, err = applyDelta(base, []byte{11, 5, 0x91, 8, 5})
+			So(err, ShouldEqual, errCorruptPack)
+		})
+	})
+}
+
+func TestRealRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	Convey("A repository made by git", t, func() {
+		dir, err := os.MkdirTemp("", "jkl-git")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		run(dir, "init", "-q", "-b", "main")
+		lines := []string{}
+		for i := 0; i < 200; i++ {
+			lines = append(lines, strings.Repeat("line ", 10)+string(rune('a'+i%26)))
+		}
+		os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+		os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		run(dir, "add", ".")
+		run(dir, "commit", "-q", "-m", "First")
+		lines[100] = "changed"
+		os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		run(dir, "commit", "-q", "-am", "Second")
+		want := strings.Join(lines, "\n") + "\n"
+		fs := afero.NewOsFs()
+
+		check := func() {
+			r, err := Open(fs, filepath.Join(dir, "sub"))
+			So(err, ShouldBeNil)
+			head, err := r.Head()
+			So(err, ShouldBeNil)
+			So(head.String(), ShouldEqual, run(dir, "rev-parse", "HEAD"))
+			data, hash, err := r.ReadFile(head, "sub/file.txt")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, want)
+			So(hash.String(), ShouldEqual, run(dir, "rev-parse", "HEAD:sub/file.txt"))
+			commit, err := r.Commit(head)
+			So(err, ShouldBeNil)
+			So(commit.Author.Name, ShouldEqual, "Bob")
+			So(commit.Message, ShouldEqual, "Second\n")
+			index, err := r.ReadIndex()
+			So(err, ShouldBeNil)
+			So(index.Entry("sub/file.txt").Hash, ShouldEqual, hash)
+		}
+
+		Convey("is read from loose objects", func() {
+			check()
+		})
+
+		Convey("is read from packs with deltas", func() {
+			run(dir, "repack", "-adfq")
+			run(dir, "pack-refs", "--all")
+			matches, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.pack"))
+			So(matches, ShouldHaveLength, 1)
+			check()
+		})
+
+		Convey("is read with a version 4 index", func() {
+			run(dir, "update-index", "--index-version", "4")
+			check()
+		})
+
+		Convey("takes what's staged here", func() {
+			r, err := Open(fs, dir)
+			So(err, ShouldBeNil)
+			hash, err := r.WriteObject(BlobObject, []byte("staged\n"))
+			So(err, ShouldBeNil)
+			index, err := r.ReadIndex()
+			So(err, ShouldBeNil)
+			index.Set(IndexEntry{Mode: ModeFile, Hash: hash, Path: "sub/new.txt"})
+			So(r.WriteIndex(index), ShouldBeNil)
+			So(run(dir, "show", ":sub/new.txt"), ShouldEqual, "staged")
+			So(run(dir, "diff", "--cached", "--name-status"), ShouldEqual, "A\tsub/new.txt")
+			So(run(dir, "ls-tree", "-r", "--name-only", run(dir, "write-tree")), ShouldEqual, "sub/file.txt\nsub/new.txt")
+		})
+
+		Convey("keeps git's extensions of the index", func() {
+			r, err := Open(fs, dir)
+			So(err, ShouldBeNil)
+			index, err := r.ReadIndex()
+			So(err, ShouldBeNil)
+			entry := *index.Entry("sub/file.txt")
+			for stage := uint16(1); stage <= 3; stage++ {
+				entry.Flags = stage << 12
+				index.Entries = append(index.Entries, entry)
+			}
+			index.Entries = index.Entries[1:]
+			So(r.WriteIndex(index), ShouldBeNil)
+			run(dir, "add", "sub/file.txt")
+			resolved := run(dir, "ls-files", "--resolve-undo")
+			So(resolved, ShouldNotBeEmpty)
+
+			index, err = r.ReadIndex()
+			So(err, ShouldBeNil)
+			So(index.Extensions, ShouldNotBeEmpty)
+			index.Set(IndexEntry{Mode: ModeFile, Hash: index.Entry("sub/file.txt").Hash, Path: "sub/copy.txt"})
+			So(r.WriteIndex(index), ShouldBeNil)
+			So(run(dir, "ls-files", "--resolve-undo"), ShouldEqual, resolved)
+		})
+	})
+}
@@ -0,0 +1,266 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Index is the staging area, the files of the next commit.
+type Index struct {
+	Version    int
+	Entries    []IndexEntry
+	Extensions []IndexExtension
+}
+
+// IndexExtension is data git keeps after the entries, such as what undoes the resolving of conflicts.
+// It's written back as it was read.
+type IndexExtension struct {
+	Signature string
+	Data      []byte
+}
+
+// staleExtensions are extensions that describe the entries as they were read, git rebuilds them when
+// they're missing but would trust them if they were written back after the entries changed.
+var staleExtensions = map[string]bool{
+	"TREE": true, // the cached trees
+	"EOIE": true, // where the entries end
+	"IEOT": true, // where blocks of entries start
+	"FSMN": true, // which entries the file system monitor saw change, by position
+	"UNTR": true, // the untracked files, which include any that were just staged
+}
+
+// IndexEntry is a file in the index. The times and the like are what the file had when it was added,
+// git compares them to tell whether it has changed since.
+type IndexEntry struct {
+	CTime         uint64
+	MTime         uint64
+	Dev           uint32
+	Ino           uint32
+	Mode          uint32
+	UID           uint32
+	GID           uint32
+	Size          uint32
+	Hash          Hash
+	Flags         uint16
+	ExtendedFlags uint16
+	Path          string
+}
+
+// Flags of index entries.
+const (
+	indexExtended = 0x4000
+	indexStage    = 0x3000
+	indexNameMask = 0x0fff
+)
+
+// Stage returns 0 for a file that's merged, 1 for the base of a conflict, 2 for ours and 3 for theirs.
+func (entry *IndexEntry) Stage() int {
+	return int(entry.Flags&indexStage) >> 12
+}
+
+var errCorruptIndex = errors.New("Corrupt index")
+
+// indexPath returns where the index is kept.
+func (r *Repository) indexPath() string {
+	return filepath.Join(r.gitDir, "index")
+}
+
+// IndexStamp returns the modification time and size of the index, which change whenever it's written.
+// They're zero if there's no index.
+func (r *Repository) IndexStamp() (time.Time, int64) {
+	info, err := r.fs.Stat(r.indexPath())
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// ReadIndex reads the index, an empty one if there's none yet. Extensions that would be wrong once the
+// entries change, such as the cached trees, are dropped, git rebuilds them when it needs them.
+func (r *Repository) ReadIndex() (*Index, error) {
+	data, err := afero.ReadFile(r.fs, r.indexPath())
+	if os.IsNotExist(err) {
+		return &Index{Version: 2}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12+20 || string(data[:4]) != "DIRC" {
+		return nil, errCorruptIndex
+	}
+	if sum := sha1.Sum(data[:len(data)-20]); !bytes.Equal(sum[:], data[len(data)-20:]) {
+		return nil, errors.New("Index checksum doesn't match")
+	}
+	index := &Index{Version: int(binary.BigEndian.Uint32(data[4:]))}
+	if index.Version < 2 || index.Version > 4 {
+		return nil, fmt.Errorf("Unsupported index version %d", index.Version)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	rest := data[12 : len(data)-20]
+	previous := ""
+	for i := 0; i < count; i++ {
+		entry, size, err := readIndexEntry(rest, index.Version, previous)
+		if err != nil {
+			return nil, err
+		}
+		index.Entries = append(index.Entries, entry)
+		previous = entry.Path
+		rest = rest[size:]
+	}
+
+	for len(rest) >= 8 {
+		signature, size := string(rest[:4]), int(binary.BigEndian.Uint32(rest[4:]))
+		if signature == "link" {
+			return nil, errors.New("Split indexes aren't supported")
+		}
+		if size < 0 || len(rest) < 8+size {
+			return nil, errCorruptIndex
+		}
+		if !staleExtensions[signature] {
+			data := append([]byte{}, rest[8:8+size]...)
+			index.Extensions = append(index.Extensions, IndexExtension{Signature: signature, Data: data})
+		}
+		rest = rest[8+size:]
+	}
+	return index, nil
+}
+
+// readIndexEntry reads an entry and returns how many bytes it took. Version 4 stores each path as the
+// number of bytes to drop from the end of the previous one and the bytes to add.
+func readIndexEntry(data []byte, version int, previous string) (IndexEntry, int, error) {
+	const fixed = 62
+	if len(data) < fixed {
+		return IndexEntry{}, 0, errCorruptIndex
+	}
+	entry := IndexEntry{
+		CTime: binary.BigEndian.Uint64(data[0:]),
+		MTime: binary.BigEndian.Uint64(data[8:]),
+		Dev:   binary.BigEndian.Uint32(data[16:]),
+		Ino:   binary.BigEndian.Uint32(data[20:]),
+		Mode:  binary.BigEndian.Uint32(data[24:]),
+		UID:   binary.BigEndian.Uint32(data[28:]),
+		GID:   binary.BigEndian.Uint32(data[32:]),
+		Size:  binary.BigEndian.Uint32(data[36:]),
+		Flags: binary.BigEndian.Uint16(data[60:]),
+	}
+	copy(entry.Hash[:], data[40:60])
+	at := fixed
+	if entry.Flags&indexExtended != 0 {
+		if version < 3 || len(data) < at+2 {
+			return IndexEntry{}, 0, errCorruptIndex
+		}
+		entry.ExtendedFlags = binary.BigEndian.Uint16(data[at:])
+		at += 2
+	}
+
+	if version == 4 {
+		reader := bytes.NewReader(data[at:])
+		drop, err := readOffset(reader)
+		if err != nil || int(drop) > len(previous) {
+			return IndexEntry{}, 0, errCorruptIndex
+		}
+		at = len(data) - reader.Len()
+		nul := bytes.IndexByte(data[at:], 0)
+		if nul == -1 {
+			return IndexEntry{}, 0, errCorruptIndex
+		}
+		entry.Path = previous[:len(previous)-int(drop)] + string(data[at:at+nul])
+		return entry, at + nul + 1, nil
+	}
+
+	nul := bytes.IndexByte(data[at:], 0)
+	if nul == -1 {
+		return IndexEntry{}, 0, errCorruptIndex
+	}
+	entry.Path = string(data[at : at+nul])
+	// Entries are padded with 1 to 8 NULs to a multiple of 8 bytes.
+	size := (at + nul + 8) &^ 7
+	if size > len(data) {
+		return IndexEntry{}, 0, errCorruptIndex
+	}
+	return entry, size, nil
+}
+
+// WriteIndex replaces the index, as version 2 unless entries have extended flags, followed by its extensions.
+func (r *Repository) WriteIndex(index *Index) error {
+	entries := append([]IndexEntry{}, index.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Stage() < entries[j].Stage()
+	})
+	version := 2
+	for _, entry := range entries {
+		if entry.ExtendedFlags != 0 {
+			version = 3
+		}
+	}
+
+	data := &bytes.Buffer{}
+	data.WriteString("DIRC")
+	binary.Write(data, binary.BigEndian, uint32(version))
+	binary.Write(data, binary.BigEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		start := data.Len()
+		flags := entry.Flags &^ (indexExtended | indexNameMask)
+		if len(entry.Path) < indexNameMask {
+			flags |= uint16(len(entry.Path))
+		} else {
+			flags |= indexNameMask
+		}
+		if entry.ExtendedFlags != 0 {
+			flags |= indexExtended
+		}
+		for _, field := range []interface{}{entry.CTime, entry.MTime, entry.Dev, entry.Ino, entry.Mode, entry.UID, entry.GID, entry.Size} {
+			binary.Write(data, binary.BigEndian, field)
+		}
+		data.Write(entry.Hash[:])
+		binary.Write(data, binary.BigEndian, flags)
+		if entry.ExtendedFlags != 0 {
+			binary.Write(data, binary.BigEndian, entry.ExtendedFlags)
+		}
+		data.WriteString(entry.Path)
+		size := (data.Len() - start + 8) &^ 7
+		data.Write(make([]byte, size-(data.Len()-start)))
+	}
+	for _, extension := range index.Extensions {
+		data.WriteString(extension.Signature)
+		binary.Write(data, binary.BigEndian, uint32(len(extension.Data)))
+		data.Write(extension.Data)
+	}
+	sum := sha1.Sum(data.Bytes())
+	data.Write(sum[:])
+	return writeFileAtomic(r.fs, r.indexPath(), data.Bytes())
+}
+
+// Entry returns the merged entry of a path, nil if it isn't in the index.
+func (index *Index) Entry(path string) *IndexEntry {
+	for i := range index.Entries {
+		if index.Entries[i].Path == path && index.Entries[i].Stage() == 0 {
+			return &index.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Set adds an entry, replacing any with the same path including those of a conflict.
+func (index *Index) Set(entry IndexEntry) {
+	entries := index.Entries[:0]
+	for _, e := range index.Entries {
+		if e.Path != entry.Path {
+			entries = append(entries, e)
+		}
+	}
+	index.Entries = append(entries, entry)
+	sort.SliceStable(index.Entries, func(i, j int) bool { return index.Entries[i].Path < index.Entries[j].Path })
+}
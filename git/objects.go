@@ -0,0 +1,350 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Hash is the SHA-1 name of an object.
+type Hash [20]byte
+
+// ParseHash parses the 40 hex digits of a hash.
+func ParseHash(text string) (Hash, error) {
+	hash := Hash{}
+	if len(text) != 40 {
+		return hash, fmt.Errorf("Invalid hash %q", text)
+	}
+	if _, err := hex.Decode(hash[:], []byte(text)); err != nil {
+		return hash, fmt.Errorf("Invalid hash %q", text)
+	}
+	return hash, nil
+}
+
+// String returns the hash in hex.
+func (hash Hash) String() string {
+	return hex.EncodeToString(hash[:])
+}
+
+// IsZero returns true for the hash of nothing, which git uses for changes that aren't committed.
+func (hash Hash) IsZero() bool {
+	return hash == Hash{}
+}
+
+// ObjectType is the kind of an object, numbered as in pack files.
+type ObjectType int
+
+// The types of objects.
+const (
+	CommitObject ObjectType = 1
+	TreeObject   ObjectType = 2
+	BlobObject   ObjectType = 3
+	TagObject    ObjectType = 4
+)
+
+var objectTypeNames = map[ObjectType]string{CommitObject: "commit", TreeObject: "tree", BlobObject: "blob", TagObject: "tag"}
+
+// String returns the name git uses for the type, ie "blob".
+func (t ObjectType) String() string {
+	if name, ok := objectTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// parseObjectType returns the type with a name.
+func parseObjectType(name string) (ObjectType, error) {
+	for t, n := range objectTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown object type %s", name)
+}
+
+// object is a decompressed object.
+type object struct {
+	objectType ObjectType
+	data       []byte
+}
+
+// objectCacheSize is how many objects a repository keeps, deltas and blame read the same ones often.
+const objectCacheSize = 512
+
+// HashObject returns the hash an object of a type with the data has.
+func HashObject(objectType ObjectType, data []byte) Hash {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objectType, len(data))
+	h.Write(data)
+	hash := Hash{}
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// looseObjectPath returns where an object is kept outside a pack.
+func (r *Repository) looseObjectPath(hash Hash) string {
+	text := hash.String()
+	return filepath.Join(r.commonDir, "objects", text[:2], text[2:])
+}
+
+// ReadObject returns the type and contents of an object, loose or packed.
+func (r *Repository) ReadObject(hash Hash) (ObjectType, []byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	o, err := r.readObject(hash)
+	return o.objectType, o.data, err
+}
+
+// readObject reads an object with the mutex held.
+func (r *Repository) readObject(hash Hash) (object, error) {
+	if o, ok := r.cache[hash]; ok {
+		return o, nil
+	}
+	o, err := r.readLooseObject(hash)
+	if os.IsNotExist(err) {
+		o, err = r.readPackedObject(hash)
+	}
+	if err != nil {
+		return object{}, err
+	}
+	r.remember(hash, o)
+	return o, nil
+}
+
+// remember caches an object, forgetting the others once the cache is full.
+func (r *Repository) remember(hash Hash, o object) {
+	if r.cache == nil || len(r.cache) >= objectCacheSize {
+		r.cache = map[Hash]object{}
+	}
+	r.cache[hash] = o
+}
+
+// readLooseObject reads an object from its own file, the error is os.ErrNotExist if there's none.
+func (r *Repository) readLooseObject(hash Hash) (object, error) {
+	file, err := r.fs.Open(r.looseObjectPath(hash))
+	if err != nil {
+		return object{}, os.ErrNotExist
+	}
+	defer file.Close()
+	reader, err := zlib.NewReader(file)
+	if err != nil {
+		return object{}, fmt.Errorf("Corrupt object %s: %s", hash, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return object{}, fmt.Errorf("Corrupt object %s: %s", hash, err)
+	}
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return object{}, fmt.Errorf("Corrupt object %s", hash)
+	}
+	header := strings.SplitN(string(data[:nul]), " ", 2)
+	objectType, err := parseObjectType(header[0])
+	if err != nil || len(header) != 2 {
+		return object{}, fmt.Errorf("Corrupt object %s", hash)
+	}
+	if size, err := strconv.Atoi(header[1]); err != nil || size != len(data)-nul-1 {
+		return object{}, fmt.Errorf("Corrupt object %s", hash)
+	}
+	return object{objectType, data[nul+1:]}, nil
+}
+
+// WriteObject stores an object as a loose object and returns its hash, objects already stored are left alone.
+func (r *Repository) WriteObject(objectType ObjectType, data []byte) (Hash, error) {
+	hash := HashObject(objectType, data)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, err := r.readObject(hash); err == nil {
+		return hash, nil
+	}
+
+	compressed := &bytes.Buffer{}
+	writer := zlib.NewWriter(compressed)
+	fmt.Fprintf(writer, "%s %d\x00", objectType, len(data))
+	writer.Write(data)
+	writer.Close()
+
+	filename := r.looseObjectPath(hash)
+	if err := r.fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return hash, err
+	}
+	temp := filename + ".tmp"
+	if err := afero.WriteFile(r.fs, temp, compressed.Bytes(), 0444); err != nil {
+		return hash, err
+	}
+	if err := r.fs.Rename(temp, filename); err != nil {
+		r.fs.Remove(temp)
+		return hash, err
+	}
+	return hash, nil
+}
+
+// Signature is who made a commit and when.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// parseSignature parses "Name <email> seconds +zone".
+func parseSignature(text string) Signature {
+	signature := Signature{}
+	open, close := strings.Index(text, "<"), strings.LastIndex(text, ">")
+	if open == -1 || close < open {
+		signature.Name = text
+		return signature
+	}
+	signature.Name = strings.TrimSpace(text[:open])
+	signature.Email = text[open+1 : close]
+	fields := strings.Fields(text[close+1:])
+	if len(fields) == 2 {
+		seconds, _ := strconv.ParseInt(fields[0], 10, 64)
+		zone, _ := strconv.Atoi(fields[1])
+		offset := (zone/100*60 + zone%100) * 60
+		signature.When = time.Unix(seconds, 0).In(time.FixedZone(fields[1], offset))
+	}
+	return signature
+}
+
+// String formats the signature as commits have it.
+func (signature Signature) String() string {
+	_, offset := signature.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", signature.Name, signature.Email, signature.When.Unix(), sign, offset/3600, offset/60%60)
+}
+
+// Commit is a commit object. Merges have more than one parent and the first commit none.
+type Commit struct {
+	Hash      Hash
+	Tree      Hash
+	Parents   []Hash
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+// Commit reads a commit.
+func (r *Repository) Commit(hash Hash) (*Commit, error) {
+	objectType, data, err := r.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objectType != CommitObject {
+		return nil, fmt.Errorf("%s is a %s, not a commit", hash, objectType)
+	}
+	commit := &Commit{Hash: hash}
+	headers, message := string(data), ""
+	if i := strings.Index(headers, "\n\n"); i != -1 {
+		headers, message = headers[:i], headers[i+2:]
+	}
+	commit.Message = message
+	for _, line := range strings.Split(headers, "\n") {
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			key, value = line[:i], line[i+1:]
+		}
+		switch key {
+		case "tree":
+			commit.Tree, err = ParseHash(value)
+		case "parent":
+			var parent Hash
+			parent, err = ParseHash(value)
+			commit.Parents = append(commit.Parents, parent)
+		case "author":
+			commit.Author = parseSignature(value)
+		case "committer":
+			commit.Committer = parseSignature(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt commit %s: %s", hash, err)
+		}
+	}
+	return commit, nil
+}
+
+// WriteCommit stores a commit and returns its hash, the commit's own Hash is ignored.
+func (r *Repository) WriteCommit(commit *Commit) (Hash, error) {
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "tree %s\n", commit.Tree)
+	for _, parent := range commit.Parents {
+		fmt.Fprintf(text, "parent %s\n", parent)
+	}
+	fmt.Fprintf(text, "author %s\ncommitter %s\n\n%s", commit.Author, commit.Committer, commit.Message)
+	return r.WriteObject(CommitObject, []byte(text.String()))
+}
+
+// File modes of tree and index entries.
+const (
+	ModeFile       = 0100644
+	ModeExecutable = 0100755
+	ModeSymlink    = 0120000
+	ModeDir        = 0040000
+	ModeSubmodule  = 0160000
+)
+
+// TreeEntry is a file or directory in a tree.
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash Hash
+}
+
+// Tree reads the entries of a tree.
+func (r *Repository) Tree(hash Hash) ([]TreeEntry, error) {
+	objectType, data, err := r.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objectType != TreeObject {
+		return nil, fmt.Errorf("%s is a %s, not a tree", hash, objectType)
+	}
+	entries := []TreeEntry{}
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space == -1 || nul < space || len(data) < nul+21 {
+			return nil, fmt.Errorf("Corrupt tree %s", hash)
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt tree %s", hash)
+		}
+		entry := TreeEntry{Mode: uint32(mode), Name: string(data[space+1 : nul])}
+		copy(entry.Hash[:], data[nul+1:nul+21])
+		entries = append(entries, entry)
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// WriteTree stores a tree with the entries and returns its hash. They're sorted the way git sorts them.
+func (r *Repository) WriteTree(entries []TreeEntry) (Hash, error) {
+	sorted := append([]TreeEntry{}, entries...)
+	key := func(entry TreeEntry) string {
+		if entry.Mode == ModeDir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+	data := &bytes.Buffer{}
+	for _, entry := range sorted {
+		fmt.Fprintf(data, "%o %s\x00", entry.Mode, entry.Name)
+		data.Write(entry.Hash[:])
+	}
+	return r.WriteObject(TreeObject, data.Bytes())
+}
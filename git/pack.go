@@ -0,0 +1,253 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// pack is a pack file and its version 2 index, the offsets of its objects sorted by hash.
+type pack struct {
+	name    string
+	hashes  []Hash
+	offsets []int64
+}
+
+// Types of the deltas stored in packs.
+const (
+	ofsDelta = 6
+	refDelta = 7
+)
+
+// maxDeltaDepth stops corrupt packs whose deltas refer to each other from looping.
+const maxDeltaDepth = 1000
+
+var errCorruptPack = errors.New("Corrupt pack")
+
+// loadPacks reads the indexes of the packs the first time an object isn't loose.
+func (r *Repository) loadPacks() error {
+	if r.packs != nil {
+		return nil
+	}
+	r.packs = []*pack{}
+	dir := filepath.Join(r.commonDir, "objects", "pack")
+	names, err := afero.Glob(r.fs, filepath.Join(dir, "pack-*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		p, err := readPackIndex(r.fs, name)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filepath.Base(name), err)
+		}
+		r.packs = append(r.packs, p)
+	}
+	return nil
+}
+
+// readPackIndex reads a version 2 pack index.
+func readPackIndex(fs afero.Fs, name string) (*pack, error) {
+	data, err := afero.ReadFile(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, errors.New("Unsupported pack index")
+	}
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	names := 8 + 256*4
+	offsets := names + count*20 + count*4
+	large := offsets + count*4
+	if len(data) < large {
+		return nil, errCorruptPack
+	}
+
+	p := &pack{name: strings.TrimSuffix(name, ".idx") + ".pack", hashes: make([]Hash, count), offsets: make([]int64, count)}
+	for i := 0; i < count; i++ {
+		copy(p.hashes[i][:], data[names+i*20:])
+		offset := binary.BigEndian.Uint32(data[offsets+i*4:])
+		if offset&0x80000000 == 0 {
+			p.offsets[i] = int64(offset)
+			continue
+		}
+		at := large + int(offset&0x7fffffff)*8
+		if len(data) < at+8 {
+			return nil, errCorruptPack
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(data[at:]))
+	}
+	return p, nil
+}
+
+// find returns the offset of an object in the pack.
+func (p *pack) find(hash Hash) (int64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool { return bytes.Compare(p.hashes[i][:], hash[:]) >= 0 })
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// readPackedObject reads an object from the pack that has it.
+func (r *Repository) readPackedObject(hash Hash) (object, error) {
+	if err := r.loadPacks(); err != nil {
+		return object{}, err
+	}
+	for _, p := range r.packs {
+		if offset, ok := p.find(hash); ok {
+			file, err := r.fs.Open(p.name)
+			if err != nil {
+				return object{}, err
+			}
+			defer file.Close()
+			return r.readPackEntry(file, offset, 0)
+		}
+	}
+	return object{}, ErrNotFound
+}
+
+// readPackEntry reads the object at an offset of a pack, applying deltas to their bases.
+func (r *Repository) readPackEntry(file afero.File, offset int64, depth int) (object, error) {
+	if depth > maxDeltaDepth {
+		return object{}, errCorruptPack
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	b, err := reader.ReadByte()
+	if err != nil {
+		return object{}, errCorruptPack
+	}
+	objectType := ObjectType(b >> 4 & 7)
+	size := int64(b & 15)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, err = reader.ReadByte(); err != nil {
+			return object{}, errCorruptPack
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	var base object
+	switch objectType {
+	case ofsDelta:
+		distance, err := readOffset(reader)
+		if err != nil || distance <= 0 || distance > offset {
+			return object{}, errCorruptPack
+		}
+		if base, err = r.readPackEntry(file, offset-distance, depth+1); err != nil {
+			return object{}, err
+		}
+	case refDelta:
+		baseHash := Hash{}
+		if _, err := io.ReadFull(reader, baseHash[:]); err != nil {
+			return object{}, errCorruptPack
+		}
+		if base, err = r.readObject(baseHash); err != nil {
+			return object{}, err
+		}
+	case CommitObject, TreeObject, BlobObject, TagObject:
+	default:
+		return object{}, errCorruptPack
+	}
+
+	inflater, err := zlib.NewReader(reader)
+	if err != nil {
+		return object{}, errCorruptPack
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(inflater, data); err != nil {
+		return object{}, errCorruptPack
+	}
+	if objectType != ofsDelta && objectType != refDelta {
+		return object{objectType, data}, nil
+	}
+	patched, err := applyDelta(base.data, data)
+	if err != nil {
+		return object{}, err
+	}
+	return object{base.objectType, patched}, nil
+}
+
+// readOffset reads the distance back to the base of an offset delta, version 4 indexes use the same
+// numbers for their paths.
+func readOffset(reader io.ByteReader) (int64, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+		offset = (offset+1)<<7 | int64(b&0x7f)
+	}
+	return offset, nil
+}
+
+// applyDelta rebuilds an object from its base and a delta of copy and insert instructions.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	readSize := func() int {
+		size, shift := 0, uint(0)
+		for len(delta) > 0 {
+			b := delta[0]
+			delta = delta[1:]
+			size |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return size
+	}
+	if readSize() != len(base) {
+		return nil, errCorruptPack
+	}
+	result := make([]byte, 0, readSize())
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > len(delta) {
+				return nil, errCorruptPack
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+			continue
+		}
+		offset, size := 0, 0
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errCorruptPack
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				size |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(base) {
+			return nil, errCorruptPack
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+	if len(result) != cap(result) {
+		return nil, errCorruptPack
+	}
+	return result, nil
+}
@@ -0,0 +1,272 @@
+// Package git reads and writes git repositories straight from their .git directory: loose and packed
+// objects, refs and the index. It doesn't run git, so it works through any afero.Fs and needs no
+// network. Only what an editor needs is there, ie no merges, no pack writing and no config.
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// ErrNotFound is returned for objects, refs and files that aren't in the repository.
+var ErrNotFound = errors.New("Not found")
+
+// ErrNoCommits is returned for HEAD before the first commit.
+var ErrNoCommits = errors.New("No commits yet")
+
+// Repository is a git repository with a work tree. gitDir has HEAD and the index, commonDir the objects
+// and refs, they differ for the work trees of "git worktree".
+type Repository struct {
+	fs        afero.Fs
+	workTree  string
+	gitDir    string
+	commonDir string
+
+	mutex sync.Mutex
+	packs []*pack
+	cache map[Hash]object
+}
+
+// Open finds the repository a file or directory is in by looking for .git in it and the directories above.
+func Open(fs afero.Fs, name string) (*Repository, error) {
+	dir, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := fs.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := fs.Stat(dotGit); err == nil {
+			if info.IsDir() {
+				return openGitDir(fs, dir, dotGit)
+			}
+			return openGitFile(fs, dir, dotGit)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("Not in a git repository: %s", name)
+		}
+		dir = parent
+	}
+}
+
+// openGitFile opens a repository whose .git is a file naming the git directory, as submodules and
+// work trees have.
+func openGitFile(fs afero.Fs, workTree, dotGit string) (*Repository, error) {
+	data, err := afero.ReadFile(fs, dotGit)
+	if err != nil {
+		return nil, err
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return nil, fmt.Errorf("Invalid .git file %s", dotGit)
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(workTree, gitDir)
+	}
+	return openGitDir(fs, workTree, gitDir)
+}
+
+// openGitDir opens a repository from its git directory, which may share its objects and refs with another.
+func openGitDir(fs afero.Fs, workTree, gitDir string) (*Repository, error) {
+	if _, err := fs.Stat(filepath.Join(gitDir, "HEAD")); err != nil {
+		return nil, fmt.Errorf("Invalid git directory %s", gitDir)
+	}
+	commonDir := gitDir
+	if data, err := afero.ReadFile(fs, filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return &Repository{fs: fs, workTree: workTree, gitDir: gitDir, commonDir: commonDir}, nil
+}
+
+// Init creates an empty repository in a directory with "master" as its branch.
+func Init(fs afero.Fs, workTree string) (*Repository, error) {
+	gitDir := filepath.Join(workTree, ".git")
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags"} {
+		if err := fs.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(dir)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	files := map[string]string{
+		"HEAD":   "ref: refs/heads/master\n",
+		"config": "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n",
+	}
+	for name, text := range files {
+		if err := afero.WriteFile(fs, filepath.Join(gitDir, name), []byte(text), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return openGitDir(fs, workTree, gitDir)
+}
+
+// WorkTree returns the directory with the checked out files.
+func (r *Repository) WorkTree() string {
+	return r.workTree
+}
+
+// RelativePath returns the path of a file in the work tree as git names it, relative with slashes.
+func (r *Repository) RelativePath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(r.workTree, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		return "", fmt.Errorf("%s is outside the repository", filename)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// refPath returns where a loose ref is kept, HEAD and the like are per work tree.
+func (r *Repository) refPath(name string) string {
+	if !strings.Contains(name, "/") {
+		return filepath.Join(r.gitDir, name)
+	}
+	return filepath.Join(r.commonDir, filepath.FromSlash(name))
+}
+
+// readRef returns the contents of a ref, from its file or packed-refs.
+func (r *Repository) readRef(name string) (string, error) {
+	if data, err := afero.ReadFile(r.fs, r.refPath(name)); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	file, err := r.fs.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return "", ErrNotFound
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// ResolveRef returns the commit a ref such as "HEAD" or "refs/heads/master" points to, following
+// symbolic refs.
+func (r *Repository) ResolveRef(name string) (Hash, error) {
+	for depth := 0; depth < 10; depth++ {
+		value, err := r.readRef(name)
+		if err != nil {
+			return Hash{}, err
+		}
+		if !strings.HasPrefix(value, "ref:") {
+			return ParseHash(value)
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(value, "ref:"))
+	}
+	return Hash{}, fmt.Errorf("Too many symbolic refs at %s", name)
+}
+
+// Head returns the commit checked out, ErrNoCommits before the first one.
+func (r *Repository) Head() (Hash, error) {
+	hash, err := r.ResolveRef("HEAD")
+	if err == ErrNotFound {
+		return Hash{}, ErrNoCommits
+	}
+	return hash, err
+}
+
+// UpdateRef points a ref at a commit. Symbolic refs are followed, so updating "HEAD" moves the branch
+// that's checked out.
+func (r *Repository) UpdateRef(name string, hash Hash) error {
+	for depth := 0; depth < 10; depth++ {
+		value, err := r.readRef(name)
+		if err != nil || !strings.HasPrefix(value, "ref:") {
+			break
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(value, "ref:"))
+	}
+	filename := r.refPath(name)
+	if err := r.fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(r.fs, filename, []byte(hash.String()+"\n"))
+}
+
+// writeFileAtomic writes a file through a lock file next to it, so readers never see half of it.
+func writeFileAtomic(fs afero.Fs, filename string, data []byte) error {
+	lock := filename + ".lock"
+	file, err := fs.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s is locked", filepath.Base(filename))
+		}
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		fs.Remove(lock)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		fs.Remove(lock)
+		return err
+	}
+	if err := fs.Rename(lock, filename); err != nil {
+		fs.Remove(lock)
+		return err
+	}
+	return nil
+}
+
+// ReadFile returns the contents of a file in the tree of a commit and the hash of its blob,
+// ErrNotFound if it isn't there.
+func (r *Repository) ReadFile(commit Hash, name string) ([]byte, Hash, error) {
+	c, err := r.Commit(commit)
+	if err != nil {
+		return nil, Hash{}, err
+	}
+	hash, err := r.FileHash(c.Tree, name)
+	if err != nil {
+		return nil, Hash{}, err
+	}
+	_, data, err := r.ReadObject(hash)
+	return data, hash, err
+}
+
+// FileHash returns the hash of the blob at a slash separated path in a tree.
+func (r *Repository) FileHash(tree Hash, name string) (Hash, error) {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		entries, err := r.Tree(tree)
+		if err != nil {
+			return Hash{}, err
+		}
+		found := false
+		for _, entry := range entries {
+			if entry.Name != part {
+				continue
+			}
+			isDir := entry.Mode == ModeDir
+			if isDir != (i < len(parts)-1) {
+				return Hash{}, ErrNotFound
+			}
+			tree, found = entry.Hash, true
+			break
+		}
+		if !found {
+			return Hash{}, ErrNotFound
+		}
+	}
+	return tree, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dcbishop/jkl/git"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+// commitFile commits a file at the top of a repository on top of HEAD and stages it.
+func commitFile(repo *git.Repository, name, text, author string, when int64) git.Hash {
+	blob, err := repo.WriteObject(git.BlobObject, []byte(text))
+	So(err, ShouldBeNil)
+	tree, err := repo.WriteTree([]git.TreeEntry{{Mode: git.ModeFile, Name: name, Hash: blob}})
+	So(err, ShouldBeNil)
+	who := git.Signature{Name: author, Email: "someone@example.com", When: time.Unix(when, 0).UTC()}
+	commit := &git.Commit{Tree: tree, Author: who, Committer: who, Message: "Change\n"}
+	if head, err := repo.Head(); err == nil {
+		commit.Parents = []git.Hash{head}
+	}
+	hash, err := repo.WriteCommit(commit)
+	So(err, ShouldBeNil)
+	So(repo.UpdateRef("HEAD", hash), ShouldBeNil)
+	index, err := repo.ReadIndex()
+	So(err, ShouldBeNil)
+	index.Set(git.IndexEntry{Mode: git.ModeFile, Path: name, Hash: blob})
+	So(repo.WriteIndex(index), ShouldBeNil)
+	return hash
+}
+
+// signTexts returns the text of the git signs of a buffer by line.
+func signTexts(buffer *Buffer) map[int]string {
+	texts := map[int]string{}
+	for _, sign := range buffer.Signs() {
+		if sign.Group == "git" {
+			texts[sign.Line] = sign.Text
+		}
+	}
+	return texts
+}
+
+func TestGit(t *testing.T) {
+	Convey("A file in a git repository", t, func() {
+		fs := afero.NewMemMapFs()
+		repo, err := git.Init(fs, "/work")
+		So(err, ShouldBeNil)
+		first := commitFile(repo, "a.txt", "one\ntwo\nthree\nfour\n", "Ann", 1500000000)
+		afero.WriteFile(fs, "/work/a.txt", []byte("one\ntwo\nthree\nfour\n"), 0644)
+
+		editor := NewEditor(fs)
+		editor.EnableGit()
+		editor.OpenFile("/work/a.txt")
+		buffer := editor.CurrentPane().Buffer()
+		cursor := editor.CurrentPane().Cursor()
+
+		Convey("has no signs when it's unchanged", func() {
+			So(editor.GitHunks(buffer), ShouldBeEmpty)
+			So(signTexts(buffer), ShouldBeEmpty)
+		})
+
+		Convey("has signs on the lines that changed", func() {
+			buffer.ReplaceLines(2, 2, []string{"TWO", "two and a half"})
+			buffer.ReplaceLines(5, 5, nil)
+			So(editor.GitHunks(buffer), ShouldResemble, []DiffHunk{{1, 1, 1, 2}, {3, 1, 4, 0}})
+			So(signTexts(buffer), ShouldResemble, map[int]string{2: "~", 3: "+", 4: "_"})
+		})
+
+		Convey("marks lines removed from the top", func() {
+			buffer.ReplaceLines(1, 1, nil)
+			editor.GitHunks(buffer)
+			So(signTexts(buffer), ShouldResemble, map[int]string{1: "‾"})
+		})
+
+		Convey("is compared again when it changes", func() {
+			editor.NotifyChanges()
+			buffer.InsertLines(0, []string{"zero"})
+			editor.NotifyChanges()
+			So(signTexts(buffer), ShouldResemble, map[int]string{1: "+"})
+		})
+
+		Convey("is compared again when the index changes outside the editor", func() {
+			So(editor.GitHunks(buffer), ShouldBeEmpty)
+			commitFile(repo, "a.txt", "one\n2\nthree\nfour\n", "Bob", 1600000000)
+			So(editor.GitHunks(buffer), ShouldResemble, []DiffHunk{{1, 1, 1, 1}})
+
+			Convey("and when HEAD does", func() {
+				So(editor.ExecuteCommand("set gitbase=head"), ShouldBeNil)
+				So(editor.GitHunks(buffer), ShouldResemble, []DiffHunk{{1, 1, 1, 1}})
+				So(repo.UpdateRef("HEAD", first), ShouldBeNil)
+				So(editor.GitHunks(buffer), ShouldBeEmpty)
+			})
+		})
+
+		Convey("moves between hunks", func() {
+			buffer.ReplaceLines(1, 1, []string{"ONE"})
+			buffer.ReplaceLines(4, 4, []string{"FOUR"})
+			So(editor.ExecuteCommand("git next"), ShouldBeNil)
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 4)
+			So(editor.ExecuteCommand("git next").Error(), ShouldEqual, "No more hunks")
+			editor.HandleNormalKey('[')
+			editor.HandleNormalKey('h')
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 1)
+			editor.HandleNormalKey(']')
+			editor.HandleNormalKey('h')
+			_, line = cursor.Position()
+			So(line, ShouldEqual, 4)
+		})
+
+		Convey("previews a hunk", func() {
+			buffer.ReplaceLines(2, 2, []string{"TWO"})
+			cursor.Move(0, 2)
+			So(editor.ExecuteCommand("git preview"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "-two\n+TWO")
+			So(editor.ExecuteCommand("git"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "a.txt: 1 hunks, +0 ~1 -0 against the index")
+		})
+
+		Convey("reverts a hunk", func() {
+			buffer.ReplaceLines(2, 3, []string{"changed"})
+			buffer.InsertLines(3, []string{"five"})
+			cursor.Move(0, 2)
+			So(editor.ExecuteCommand("git revert"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "one\ntwo\nthree\nfour\nfive\n")
+			cursor.Move(0, 5)
+			So(editor.ExecuteCommand("git revert"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "one\ntwo\nthree\nfour\n")
+			So(signTexts(buffer), ShouldBeEmpty)
+			So(editor.ExecuteCommand("git revert").Error(), ShouldEqual, "No hunk under the cursor")
+		})
+
+		Convey("stages a hunk", func() {
+			buffer.ReplaceLines(1, 1, []string{"ONE"})
+			buffer.ReplaceLines(4, 4, []string{"FOUR"})
+			cursor.Move(0, 4)
+			So(editor.ExecuteCommand("git stage"), ShouldBeNil)
+			index, err := repo.ReadIndex()
+			So(err, ShouldBeNil)
+			_, data, err := repo.ReadObject(index.Entry("a.txt").Hash)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "one\ntwo\nthree\nFOUR\n")
+			So(signTexts(buffer), ShouldResemble, map[int]string{1: "~"})
+
+			Convey("which is still a change from HEAD", func() {
+				So(editor.ExecuteCommand("set gitbase=head"), ShouldBeNil)
+				So(editor.GitHunks(buffer), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("without a final newline", func() {
+			commitFile(repo, "b.txt", "one\ntwo", "Ann", 1500000000)
+			afero.WriteFile(fs, "/work/b.txt", []byte("one\ntwo"), 0644)
+			editor.OpenFile("/work/b.txt")
+			buffer := editor.CurrentPane().Buffer()
+			cursor := editor.CurrentPane().Cursor()
+			staged := func() string {
+				index, err := repo.ReadIndex()
+				So(err, ShouldBeNil)
+				_, data, err := repo.ReadObject(index.Entry("b.txt").Hash)
+				So(err, ShouldBeNil)
+				return string(data)
+			}
+
+			Convey("stages a hunk without adding one", func() {
+				buffer.ReplaceLines(1, 1, []string{"ONE"})
+				cursor.Move(0, 1)
+				So(editor.ExecuteCommand("git stage"), ShouldBeNil)
+				So(staged(), ShouldEqual, "ONE\ntwo")
+			})
+
+			Convey("stages the last line as it's written", func() {
+				buffer.InsertLines(2, []string{"three"})
+				So(string(buffer.data), ShouldEqual, "one\ntwo\nthree")
+				cursor.Move(0, 3)
+				So(editor.ExecuteCommand("git stage"), ShouldBeNil)
+				So(staged(), ShouldEqual, "one\ntwo\nthree")
+			})
+		})
+
+		Convey("that isn't tracked is staged whole", func() {
+			afero.WriteFile(fs, "/work/new.txt", []byte("new\n"), 0755)
+			editor.OpenFile("/work/new.txt")
+			So(signTexts(editor.CurrentPane().Buffer()), ShouldBeEmpty)
+			So(editor.ExecuteCommand("git stage"), ShouldBeNil)
+			index, _ := repo.ReadIndex()
+			entry := index.Entry("new.txt")
+			So(entry, ShouldNotBeNil)
+			So(entry.Mode, ShouldEqual, git.ModeExecutable)
+			So(entry.Hash, ShouldEqual, git.HashObject(git.BlobObject, []byte("new\n")))
+		})
+
+		Convey("is blamed on the commits that changed its lines", func() {
+			second := commitFile(repo, "a.txt", "one\n2\nthree\nfour\n", "Bob", 1600000000)
+			commitFile(repo, "a.txt", "one\n2\nthree\nfour\n", "Cat", 1700000000)
+			buffer.SetDataString("one\n2\nthree\nfour!\n")
+			blame, err := editor.Blame(buffer)
+			So(err, ShouldBeNil)
+			So(blame, ShouldHaveLength, 4)
+			So(blame[0].Commit, ShouldEqual, first)
+			So(blame[0].Author, ShouldEqual, "Ann")
+			So(blame[1].Commit, ShouldEqual, second)
+			So(blame[1].Author, ShouldEqual, "Bob")
+			So(blame[2].Commit, ShouldEqual, first)
+			So(blame[3].Commit.IsZero(), ShouldBeTrue)
+
+			Convey("in a pane beside it", func() {
+				source := editor.CurrentPane()
+				So(editor.ExecuteCommand("blame"), ShouldBeNil)
+				pane := editor.CurrentPane()
+				So(pane, ShouldNotEqual, source)
+				lines := bufferLines(pane.Buffer(), 1, 4)
+				So(lines[0], ShouldEqual, first.String()[:8]+" Ann               2017-07-14")
+				So(lines[1], ShouldEqual, second.String()[:8]+" Bob               2020-09-13")
+				So(lines[3], ShouldEqual, "00000000 Not Committed Yet")
+				So(pane.Buffer().Listed(), ShouldBeFalse)
+
+				editor.NotifyChanges()
+				pane.Cursor().Move(0, 3)
+				editor.NotifyChanges()
+				_, line := source.Cursor().Position()
+				So(line, ShouldEqual, 3)
+
+				So(editor.ExecuteCommand("blame"), ShouldBeNil)
+				So(editor.Panes(), ShouldResemble, []*Pane{source})
+			})
+		})
+	})
+
+	Convey("A file outside a repository", t, func() {
+		editor := NewEditor(afero.NewMemMapFs())
+		editor.OpenFile("/tmp/file.txt")
+		So(editor.ExecuteCommand("git next").Error(), ShouldEqual, "Not in a git repository")
+		So(editor.ExecuteCommand("blame").Error(), ShouldEqual, "Not in a git repository")
+	})
+}
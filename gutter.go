@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// signColumnWidth is the number of cells used by the sign column.
+const signColumnWidth = 2
+
+// minimumNumberWidth is the narrowest the line number column gets, including the space after the numbers.
+const minimumNumberWidth = 4
+
+// Gutter is the columns drawn to the left of a Pane's text, from left to right the fold column,
+// sign column and line numbers.
+type Gutter struct {
+	FoldWidth   int
+	SignWidth   int
+	NumberWidth int
+}
+
+// NewGutter works out the size of the pane's gutter from the settings and the buffer.
+// The line number column grows with the number of lines in the buffer.
+func NewGutter(settings *Settings, pane *Pane) Gutter {
+	gutter := Gutter{FoldWidth: settings.FoldColumn}
+
+	buffer := pane.Buffer()
+	if buffer == nil {
+		return gutter
+	}
+
+	if settings.SignColumn == "yes" || settings.SignColumn == "auto" && len(buffer.Signs()) > 0 {
+		gutter.SignWidth = signColumnWidth
+	}
+
+	if settings.Number || settings.RelativeNumber {
+		gutter.NumberWidth = len(strconv.Itoa(buffer.LineCount())) + 1
+		if gutter.NumberWidth < minimumNumberWidth {
+			gutter.NumberWidth = minimumNumberWidth
+		}
+	}
+
+	return gutter
+}
+
+// Width returns the total width of the gutter.
+func (gutter Gutter) Width() int {
+	return gutter.FoldWidth + gutter.SignWidth + gutter.NumberWidth
+}
+
+// RenderGutter draws the gutter of a pane starting at x, y1.
+// lines holds the buffer line shown on each row, 0 for rows without a line.
+func (grid *RuneGrid) RenderGutter(settings *Settings, x, y1 int, pane *Pane, gutter Gutter, lines []int) {
+	cursorLine := 0
+	if cursor := pane.Cursor(); cursor != nil {
+		_, cursorLine = cursor.Position()
+	}
+
+	for i, line := range lines {
+		y := y1 + i
+		xPos := x
+
+		grid.FillStyle(xPos, y, xPos+gutter.FoldWidth-1, y, StyleFoldColumn)
+		if gutter.FoldWidth > 0 && line != 0 {
+			grid.SetCell(xPos, y, foldColumnMarker(pane.Folds(), line))
+		}
+		xPos += gutter.FoldWidth
+
+		if gutter.SignWidth > 0 {
+			grid.FillStyle(xPos, y, xPos+gutter.SignWidth-1, y, StyleSignColumn)
+			if signs := pane.Buffer().SignsAt(line); line != 0 && len(signs) > 0 {
+				grid.DrawText(xPos, y, xPos+gutter.SignWidth-1, signs[0].Text, signs[0].Style)
+			}
+			xPos += gutter.SignWidth
+		}
+
+		if gutter.NumberWidth > 0 && line != 0 {
+			style := StyleLineNumber
+			if line == cursorLine {
+				style = StyleCursorLineNumber
+			}
+			text := lineNumberText(settings, line, cursorLine, gutter.NumberWidth-1)
+			grid.DrawText(xPos, y, xPos+gutter.NumberWidth-2, text, style)
+		}
+	}
+}
+
+// lineNumberText formats a line number as absolute, relative or hybrid depending on the settings.
+func lineNumberText(settings *Settings, line, cursorLine, width int) string {
+	number := line
+	if settings.RelativeNumber {
+		number = line - cursorLine
+		if number < 0 {
+			number = -number
+		}
+	}
+
+	text := strconv.Itoa(number)
+	if settings.Number && settings.RelativeNumber && line == cursorLine {
+		text = strconv.Itoa(line)
+		return text + strings.Repeat(" ", width-len(text))
+	}
+	return strings.Repeat(" ", width-len(text)) + text
+}
+
+// foldColumnMarker returns '+' for a closed fold, '-' for the start of an open fold and
+// '|' for other lines inside an open fold.
+func foldColumnMarker(folds *Folds, line int) rune {
+	if folds.ClosedFoldAt(line) != nil {
+		return '+'
+	}
+
+	marker := ' '
+	for _, fold := range folds.All() {
+		if fold.Start == line {
+			return '-'
+		}
+		if fold.Contains(line) {
+			marker = '|'
+		}
+	}
+	return marker
+}
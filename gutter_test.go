@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGutterWidth(t *testing.T) {
+	Convey("Pane with a 12 line buffer", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString(strings.Repeat("line\n", 12))
+		pane := NewPane()
+		pane.SetBuffer(&buffer)
+		settings := DefaultSettings()
+
+		Convey("has no gutter by default", func() {
+			So(NewGutter(&settings, &pane).Width(), ShouldEqual, 0)
+		})
+
+		Convey("line numbers are at least the minimum width", func() {
+			settings.Number = true
+			So(NewGutter(&settings, &pane).NumberWidth, ShouldEqual, minimumNumberWidth)
+		})
+
+		Convey("line numbers grow with the line count", func() {
+			settings.Number = true
+			buffer.SetDataString(strings.Repeat("line\n", 12345))
+			So(NewGutter(&settings, &pane).NumberWidth, ShouldEqual, 6)
+		})
+
+		Convey("the sign column only shows automatically when there are signs", func() {
+			So(NewGutter(&settings, &pane).SignWidth, ShouldEqual, 0)
+			buffer.PlaceSign(Sign{Line: 1, Text: "E"})
+			So(NewGutter(&settings, &pane).SignWidth, ShouldEqual, signColumnWidth)
+
+			settings.SignColumn = "no"
+			So(NewGutter(&settings, &pane).SignWidth, ShouldEqual, 0)
+		})
+
+		Convey("includes the fold column", func() {
+			settings.FoldColumn = 1
+			settings.SignColumn = "yes"
+			So(NewGutter(&settings, &pane).Width(), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestLineNumberText(t *testing.T) {
+	Convey("with the cursor on line 5", t, func() {
+		settings := DefaultSettings()
+
+		Convey("absolute numbers", func() {
+			settings.Number = true
+			So(lineNumberText(&settings, 3, 5, 3), ShouldEqual, "  3")
+			So(lineNumberText(&settings, 5, 5, 3), ShouldEqual, "  5")
+		})
+
+		Convey("relative numbers", func() {
+			settings.RelativeNumber = true
+			So(lineNumberText(&settings, 3, 5, 3), ShouldEqual, "  2")
+			So(lineNumberText(&settings, 5, 5, 3), ShouldEqual, "  0")
+			So(lineNumberText(&settings, 17, 5, 3), ShouldEqual, " 12")
+		})
+
+		Convey("hybrid numbers", func() {
+			settings.Number = true
+			settings.RelativeNumber = true
+			So(lineNumberText(&settings, 3, 5, 3), ShouldEqual, "  2")
+			So(lineNumberText(&settings, 5, 5, 3), ShouldEqual, "5  ")
+		})
+	})
+}
+
+func TestRenderGutter(t *testing.T) {
+	Convey("Editor with line numbers and a sign", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{"a.txt": []byte("a\nb\n")})
+		editor := NewEditor(fs)
+		editor.OpenFile("a.txt")
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		editor.Settings().Number = true
+		editor.CurrentPane().Buffer().PlaceSign(Sign{Line: 2, Text: ">>", Style: StyleSignError})
+
+		grid := NewRuneGrid(8, 4)
+		grid.RenderEditor(&editor)
+
+		expected := StringToRuneGrid(`
+		__  1_a_
+		>>  2_b_
+		________
+		________
+		`, '_')
+
+		So(grid.Cells(), ShouldResemble, expected.Cells())
+		So(grid.Style(2, 0), ShouldEqual, StyleCursorLineNumber)
+		So(grid.Style(2, 1), ShouldEqual, StyleLineNumber)
+		So(grid.Style(0, 1), ShouldEqual, StyleSignError)
+		So(grid.Style(0, 0), ShouldEqual, StyleSignColumn)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed README.md
+var readme string
+
+// HelpTopic is a section of the README, which jkl carries with it for ":help".
+type HelpTopic struct {
+	Name  string
+	Lines []string
+}
+
+// HelpTopics returns the sections of the README in order, those are underlined with '='.
+func HelpTopics() []HelpTopic {
+	topics := []HelpTopic{}
+	lines := strings.Split(strings.ReplaceAll(readme, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		if i+1 < len(lines) && lines[i] != "" && strings.Trim(lines[i+1], "=") == "" && lines[i+1] != "" {
+			name := strings.TrimSuffix(strings.TrimSpace(lines[i]), ":")
+			topics = append(topics, HelpTopic{Name: name})
+			i++
+			continue
+		}
+		if len(topics) > 0 {
+			topic := &topics[len(topics)-1]
+			topic.Lines = append(topic.Lines, lines[i])
+		}
+	}
+	for i := range topics {
+		topics[i].Lines = trimBlankLines(topics[i].Lines)
+	}
+	return topics
+}
+
+// FindHelpTopic returns the topic starting with a name ignoring case, or the best fuzzy match for it.
+func FindHelpTopic(name string) (HelpTopic, error) {
+	topics := HelpTopics()
+	for _, topic := range topics {
+		if strings.HasPrefix(strings.ToLower(topic.Name), strings.ToLower(name)) {
+			return topic, nil
+		}
+	}
+	best, bestScore := -1, 0
+	for i, topic := range topics {
+		if score, _, ok := FuzzyMatch(name, topic.Name); ok && (best == -1 || score > bestScore) {
+			best, bestScore = i, score
+		}
+	}
+	if best == -1 {
+		return HelpTopic{}, fmt.Errorf("Sorry, no help for %s", name)
+	}
+	return topics[best], nil
+}
+
+// trimBlankLines removes the empty lines at the start and end.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// helpCommand shows a topic, ie ":help completion", ":help" on its own lists them.
+func helpCommand(editor *Editor, command Command) error {
+	name := strings.TrimSpace(command.Args)
+	if name == "" {
+		names := []string{}
+		for _, topic := range HelpTopics() {
+			names = append(names, topic.Name)
+		}
+		if len(names) == 0 {
+			return errors.New("No help topics")
+		}
+		editor.Echo("Help topics: " + strings.Join(names, ", "))
+		return nil
+	}
+	topic, err := FindHelpTopic(name)
+	if err != nil {
+		return err
+	}
+	editor.showHelp(topic)
+	return nil
+}
+
+// showHelp shows a help topic as a message.
+func (editor *Editor) showHelp(topic HelpTopic) {
+	editor.Echo(strings.Join(append([]string{topic.Name + ":"}, topic.Lines...), "\n"))
+}
+
+// completeHelpTopic completes the names of help topics.
+func completeHelpTopic(editor *Editor, arg string) []string {
+	names := []string{}
+	for _, topic := range HelpTopics() {
+		if strings.HasPrefix(strings.ToLower(topic.Name), strings.ToLower(arg)) {
+			names = append(names, topic.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHelp(t *testing.T) {
+	Convey("Help", t, func() {
+		editor := NewEditor(GetTestFs())
+
+		Convey("has a topic for each section of the README", func() {
+			names := []string{}
+			for _, topic := range HelpTopics() {
+				names = append(names, topic.Name)
+			}
+			So(names, ShouldContain, "Sessions")
+			So(names, ShouldContain, "Language servers")
+		})
+
+		Convey("shows a topic by its name or part of it", func() {
+			So(editor.ExecuteCommand("help lang"), ShouldBeNil)
+			So(editor.Message().Text, ShouldStartWith, "Language servers:\nFiles of a language")
+			So(editor.ExecuteCommand("help compltion"), ShouldBeNil)
+			So(editor.Message().Text, ShouldStartWith, "Completion:")
+			So(editor.ExecuteCommand("help nothing-like-it"), ShouldNotBeNil)
+		})
+
+		Convey("lists the topics", func() {
+			So(editor.ExecuteCommand("help"), ShouldBeNil)
+			So(strings.Contains(editor.Message().Text, "Scripting"), ShouldBeTrue)
+			So(editor.CompleteCommand("help Sc"), ShouldResemble, []string{"help Scripting"})
+		})
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import "errors"
+
+// insertKey enters insert mode, after the character under the cursor with after, like 'i' and 'a' do in Vim.
+// In a terminal buffer it enters terminal mode instead while the terminal is running.
+func insertKey(after bool) NormalCommand {
+	return func(editor *Editor) error {
+		if terminal := editor.CurrentPane().Buffer().Terminal(); terminal != nil {
+			if terminal.Running() {
+				return editor.EnterTerminalMode()
+			}
+			return nil
+		}
+		editor.EnterInsertMode(after)
+		return nil
+	}
+}
+
+// EnterInsertMode starts typing text at the cursor, or after the character under it with after.
+// Everything typed until insert mode is left is undone at once.
+func (editor *Editor) EnterInsertMode(after bool) {
+	cursor := editor.CurrentPane().Cursor()
+	if cursor == nil {
+		return
+	}
+	if after {
+		cursor.Move(cursor.ForwardCharacter())
+	}
+	editor.LeaveVisualMode()
+	editor.joinEdits = false
+	editor.SetMode(InsertMode)
+}
+
+// LeaveInsertMode stops typing text and moves the cursor back onto the last character typed.
+func (editor *Editor) LeaveInsertMode() {
+	if editor.Mode() != InsertMode {
+		return
+	}
+	editor.CloseCompletion()
+	editor.SetMode(NormalMode)
+	if cursor := editor.CurrentPane().Cursor(); cursor != nil {
+		cursor.Move(cursor.BackCharacter())
+	}
+}
+
+// HandleInsertKey types a key into the current buffer.
+func (editor *Editor) HandleInsertKey(event KeyEvent) {
+	cursor := editor.CurrentPane().Cursor()
+	if cursor == nil {
+		editor.SetMode(NormalMode)
+		return
+	}
+	if editor.handleCompletionKey(event) {
+		return
+	}
+
+	switch event.Key {
+	case KeyEscape:
+		editor.LeaveInsertMode()
+	case KeyEnter:
+		editor.InsertText("\n")
+	case KeyTab:
+		editor.InsertText("\t")
+	case KeyBackspace:
+		editor.DeleteBackward()
+	case KeyUp:
+		editor.moveInInsertMode(cursor.UpLine())
+	case KeyDown:
+		editor.moveInInsertMode(cursor.DownLine())
+	case KeyLeft:
+		editor.moveInInsertMode(cursor.BackCharacter())
+	case KeyRight:
+		editor.moveInInsertMode(cursor.ForwardCharacter())
+	case KeyRune:
+		if event.Mod&(ModCtrl|ModAlt) == 0 {
+			editor.InsertText(string(event.Rune))
+		}
+	}
+	editor.updateCompletion(event)
+}
+
+// moveInInsertMode moves the cursor, text typed afterwards is undone separately.
+func (editor *Editor) moveInInsertMode(x, line int) {
+	editor.CurrentPane().Cursor().Move(x, line)
+	editor.joinEdits = false
+}
+
+// InsertText types text into the current buffer at the cursor and moves the cursor after it.
+func (editor *Editor) InsertText(text string) {
+	buffer, cursor := editor.CurrentPane().Buffer(), editor.CurrentPane().Cursor()
+	if cursor == nil || text == "" {
+		return
+	}
+
+	offset := buffer.Offset(cursor.Position())
+	buffer.change(offset, offset, text, editor.joinEdits)
+	editor.joinEdits = editor.Mode() == InsertMode
+	cursor.Move(buffer.Position(offset + len(text)))
+}
+
+// DeleteBackward deletes the character before the cursor, joining the line to the previous one at its start.
+func (editor *Editor) DeleteBackward() {
+	buffer, cursor := editor.CurrentPane().Buffer(), editor.CurrentPane().Cursor()
+	if cursor == nil {
+		return
+	}
+
+	end := buffer.Offset(cursor.Position())
+	if end == 0 {
+		return
+	}
+	start := buffer.Offset(cursor.BackCharacter())
+	if start == end {
+		start = end - 1
+		if start > 0 && buffer.data[start-1] == '\r' {
+			start--
+		}
+	}
+	buffer.change(start, end, "", editor.joinEdits)
+	editor.joinEdits = editor.Mode() == InsertMode
+	cursor.Move(buffer.Position(start))
+}
+
+// Undo reverts the last change to the current buffer and moves the cursor to where it was.
+func (editor *Editor) Undo() error {
+	buffer, cursor := editor.CurrentPane().Buffer(), editor.CurrentPane().Cursor()
+	if cursor == nil {
+		return nil
+	}
+
+	offset, ok := buffer.Undo()
+	if !ok {
+		return errors.New("Already at oldest change")
+	}
+	cursor.Move(buffer.Position(offset))
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// typeInsert types text in insert mode, '\n' as Enter and '\b' as Backspace.
+func typeInsert(editor *Editor, text string) {
+	for _, r := range text {
+		switch r {
+		case '\n':
+			editor.HandleInsertKey(KeyEvent{Key: KeyEnter})
+		case '\b':
+			editor.HandleInsertKey(KeyEvent{Key: KeyBackspace})
+		default:
+			editor.HandleInsertKey(KeyEvent{Key: KeyRune, Rune: r})
+		}
+	}
+}
+
+func TestInsertMode(t *testing.T) {
+	Convey("Editor with some text", t, func() {
+		editor := NewEditor(GetTestFs())
+		buffer := editor.AddBuffer(bufferWithText("alpha\nbeta\n"))
+		editor.SwitchToBuffer(buffer)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		cursor := editor.CurrentPane().Cursor()
+		cursor.Move(2, 1)
+
+		Convey("i types before the cursor", func() {
+			editor.HandleNormalKey('i')
+			So(editor.Mode(), ShouldEqual, InsertMode)
+			typeInsert(&editor, "XY")
+			So(string(buffer.data), ShouldEqual, "alXYpha\nbeta\n")
+			x, line := cursor.Position()
+			So([]int{x, line}, ShouldResemble, []int{4, 1})
+
+			grid := NewRuneGrid(20, 4)
+			grid.RenderEditor(&editor)
+			So(string(grid.Cells()[3][:12]), ShouldEqual, "-- INSERT --")
+
+			Convey("Escape leaves it on the last character typed", func() {
+				editor.HandleInsertKey(KeyEvent{Key: KeyEscape})
+				So(editor.Mode(), ShouldEqual, NormalMode)
+				x, _ := cursor.Position()
+				So(x, ShouldEqual, 3)
+			})
+		})
+
+		Convey("a types after the cursor", func() {
+			editor.HandleNormalKey('a')
+			typeInsert(&editor, "é!")
+			So(string(buffer.data), ShouldEqual, "alpé!ha\nbeta\n")
+		})
+
+		Convey("Enter splits lines and Backspace joins them", func() {
+			editor.HandleNormalKey('i')
+			typeInsert(&editor, "\n")
+			So(string(buffer.data), ShouldEqual, "al\npha\nbeta\n")
+			x, line := cursor.Position()
+			So([]int{x, line}, ShouldResemble, []int{0, 2})
+
+			typeInsert(&editor, "\b\b")
+			So(string(buffer.data), ShouldEqual, "apha\nbeta\n")
+		})
+
+		Convey("Pasting types the text", func() {
+			app := NewApp()
+			app.editor = &editor
+			editor.HandleNormalKey('i')
+			app.handleEvent(Event{PasteEvent{Text: "1\n2"}})
+			So(string(buffer.data), ShouldEqual, "al1\n2pha\nbeta\n")
+		})
+
+		Convey("u undoes each visit to insert mode at once", func() {
+			editor.HandleNormalKey('i')
+			typeInsert(&editor, "one")
+			editor.HandleInsertKey(KeyEvent{Key: KeyEscape})
+			editor.HandleNormalKey('i')
+			typeInsert(&editor, "two\b")
+			editor.HandleInsertKey(KeyEvent{Key: KeyEscape})
+			buffer.ReplaceLines(2, 2, []string{"gamma"})
+			So(string(buffer.data), ShouldEqual, "alontwepha\ngamma\n")
+
+			So(editor.Undo(), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "alontwepha\nbeta\n")
+			So(editor.Undo(), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "alonepha\nbeta\n")
+			editor.HandleNormalKey('u')
+			So(string(buffer.data), ShouldEqual, "alpha\nbeta\n")
+			x, line := cursor.Position()
+			So([]int{x, line}, ShouldResemble, []int{2, 1})
+			So(editor.Undo(), ShouldNotBeNil)
+		})
+
+		Convey("Moving in insert mode starts a new undo step", func() {
+			editor.HandleNormalKey('i')
+			typeInsert(&editor, "1")
+			editor.HandleInsertKey(KeyEvent{Key: KeyDown})
+			typeInsert(&editor, "2")
+			So(string(buffer.data), ShouldEqual, "al1pha\nbet2a\n")
+			editor.Undo()
+			So(string(buffer.data), ShouldEqual, "al1pha\nbeta\n")
+		})
+
+		Convey("Loading new text forgets the changes", func() {
+			editor.HandleNormalKey('i')
+			typeInsert(&editor, "1")
+			buffer.SetDataString("new")
+			So(editor.Undo(), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import "strings"
+
+// NormalCommand is run when its key sequence is typed in NormalMode.
+type NormalCommand func(editor *Editor) error
+
+// MapNormal binds a key sequence to a command in NormalMode.
+func (editor *Editor) MapNormal(keys string, command NormalCommand) {
+	if editor.normalMap == nil {
+		editor.normalMap = map[string]NormalCommand{}
+	}
+	editor.normalMap[keys] = command
+}
+
+// PendingKeys returns the keys typed so far of an unfinished key sequence.
+func (editor *Editor) PendingKeys() string {
+	return editor.pendingKeys
+}
+
+// CancelPendingKeys abandons an unfinished key sequence.
+func (editor *Editor) CancelPendingKeys() {
+	editor.pendingKeys = ""
+}
+
+// HandleNormalKey feeds a key typed in NormalMode to the mapped key sequences.
+// It returns true if the key was used, either completing a sequence or as part of one.
+// A key that breaks an unfinished sequence is swallowed along with the sequence.
+func (editor *Editor) HandleNormalKey(r rune) (bool, error) {
+	keys := editor.pendingKeys + string(r)
+
+	if command, ok := editor.normalMap[keys]; ok {
+		editor.pendingKeys = ""
+		return true, command(editor)
+	}
+
+	for mapped := range editor.normalMap {
+		if strings.HasPrefix(mapped, keys) {
+			editor.pendingKeys = keys
+			return true, nil
+		}
+	}
+
+	used := editor.pendingKeys != ""
+	editor.pendingKeys = ""
+	return used, nil
+}
+
+func (editor *Editor) registerDefaultKeys() {
+	editor.MapNormal("zo", foldKey((*Folds).Open))
+	editor.MapNormal("zc", foldKey((*Folds).Close))
+	editor.MapNormal("za", foldKey((*Folds).Toggle))
+	editor.MapNormal("zR", func(editor *Editor) error {
+		editor.CurrentPane().Folds().OpenAll()
+		return nil
+	})
+	editor.MapNormal("zM", func(editor *Editor) error {
+		editor.CurrentPane().Folds().CloseAll()
+		return nil
+	})
+	editor.MapNormal("zE", func(editor *Editor) error {
+		editor.CurrentPane().Folds().Delete()
+		return nil
+	})
+	editor.MapNormal("zfj", foldLinesKey((*Cursor).DownLine))
+	editor.MapNormal("zfk", foldLinesKey((*Cursor).UpLine))
+
+	editor.MapNormal("v", func(editor *Editor) error {
+		if editor.Mode() == VisualMode {
+			editor.LeaveVisualMode()
+		} else {
+			editor.EnterVisualMode()
+		}
+		return nil
+	})
+	editor.MapNormal("y", func(editor *Editor) error {
+		editor.YankSelection()
+		return nil
+	})
+	editor.MapNormal(ctrl('^'), (*Editor).SwitchToAlternateBuffer)
+	editor.MapNormal(ctrl('z'), (*Editor).Suspend)
+	editor.MapNormal("i", insertKey(false))
+	editor.MapNormal("a", insertKey(true))
+	editor.MapNormal("u", (*Editor).Undo)
+	editor.MapNormal("K", (*Editor).Hover)
+	editor.MapNormal("gd", (*Editor).GoToDefinition)
+	editor.MapNormal("gr", (*Editor).FindReferences)
+	editor.MapNormal("]h", func(editor *Editor) error { return editor.NextHunk(1) })
+	editor.MapNormal("[h", func(editor *Editor) error { return editor.NextHunk(-1) })
+	editor.MapNormal("]c", func(editor *Editor) error { return editor.NextDiff(1) })
+	editor.MapNormal("[c", func(editor *Editor) error { return editor.NextDiff(-1) })
+	editor.MapNormal("do", func(editor *Editor) error { return editor.DiffGet("", false) })
+	editor.MapNormal("dp", func(editor *Editor) error { return editor.DiffGet("", true) })
+	editor.MapNormal("]x", func(editor *Editor) error { return editor.NextConflict(1) })
+	editor.MapNormal("[x", func(editor *Editor) error { return editor.NextConflict(-1) })
+	editor.MapNormal(ctrl('p'), pickerKey("files"))
+
+	window := ctrl('w')
+	editor.MapNormal(window+"s", paneKey(func(editor *Editor) { editor.SplitPane(false) }))
+	editor.MapNormal(window+"v", paneKey(func(editor *Editor) { editor.SplitPane(true) }))
+	editor.MapNormal(window+"w", paneKey(func(editor *Editor) { editor.CyclePane(1) }))
+	editor.MapNormal(window+ctrl('w'), paneKey(func(editor *Editor) { editor.CyclePane(1) }))
+	editor.MapNormal(window+"W", paneKey(func(editor *Editor) { editor.CyclePane(-1) }))
+	editor.MapNormal(window+"o", paneKey((*Editor).OnlyPane))
+	editor.MapNormal(window+"c", func(editor *Editor) error {
+		return editor.ClosePane(editor.CurrentPane())
+	})
+}
+
+// ctrl returns the control character typed with Ctrl and the key, for use in key sequences.
+func ctrl(r rune) string {
+	if r >= 'a' && r <= 'z' {
+		return string(r - 'a' + 1)
+	}
+	return string(r ^ 0x40)
+}
+
+// paneKey wraps a pane operation that can't fail as a NormalCommand.
+func paneKey(operation func(editor *Editor)) NormalCommand {
+	return func(editor *Editor) error {
+		operation(editor)
+		return nil
+	}
+}
+
+// foldKey runs a fold operation on the line under the cursor.
+func foldKey(operation func(folds *Folds, line int) error) NormalCommand {
+	return func(editor *Editor) error {
+		cursor := editor.CurrentPane().Cursor()
+		if cursor == nil {
+			return nil
+		}
+		_, line := cursor.Position()
+		return operation(editor.CurrentPane().Folds(), line)
+	}
+}
+
+// foldLinesKey creates a manual fold from the cursor line to where the motion would move it.
+func foldLinesKey(motion func(cursor *Cursor) (int, int)) NormalCommand {
+	return func(editor *Editor) error {
+		cursor := editor.CurrentPane().Cursor()
+		if cursor == nil {
+			return nil
+		}
+		_, start := cursor.Position()
+		_, end := motion(cursor)
+		_, err := editor.CurrentPane().Folds().Create(start, end)
+		return err
+	}
+}
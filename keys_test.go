@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandleNormalKey(t *testing.T) {
+	Convey("Editor with a mapped sequence", t, func() {
+		editor := NewEditor(GetTestFs())
+		ran := 0
+		editor.MapNormal("gx", func(editor *Editor) error {
+			ran++
+			return errors.New("ran")
+		})
+
+		Convey("keys that aren't mapped aren't used", func() {
+			used, err := editor.HandleNormalKey('j')
+			So(used, ShouldBeFalse)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("prefixes wait for the rest of the sequence", func() {
+			used, _ := editor.HandleNormalKey('g')
+			So(used, ShouldBeTrue)
+			So(editor.PendingKeys(), ShouldEqual, "g")
+
+			used, err := editor.HandleNormalKey('x')
+			So(used, ShouldBeTrue)
+			So(err, ShouldNotBeNil)
+			So(ran, ShouldEqual, 1)
+			So(editor.PendingKeys(), ShouldEqual, "")
+		})
+
+		Convey("a key that breaks the sequence is swallowed", func() {
+			editor.HandleNormalKey('g')
+			used, _ := editor.HandleNormalKey('j')
+			So(used, ShouldBeTrue)
+			So(ran, ShouldEqual, 0)
+			So(editor.PendingKeys(), ShouldEqual, "")
+		})
+	})
+
+	Convey("fold keys", t, func() {
+		editor := NewEditor(GetCustomTestFs(map[string][]byte{"a": []byte("1\n2\n3\n4\n")}))
+		editor.OpenFile("a")
+		folds := editor.CurrentPane().Folds()
+
+		for _, r := range "zfj" {
+			editor.HandleNormalKey(r)
+		}
+		So(foldRanges(folds.All()), ShouldResemble, [][2]int{{1, 2}})
+		So(folds.ClosedFoldAt(1), ShouldNotBeNil)
+
+		for _, r := range "zo" {
+			editor.HandleNormalKey(r)
+		}
+		So(folds.ClosedFoldAt(1), ShouldBeNil)
+
+		for _, r := range "zMzRzE" {
+			editor.HandleNormalKey(r)
+		}
+		So(folds.All(), ShouldBeEmpty)
+	})
+
+	Convey("window keys", t, func() {
+		editor := NewEditor(GetTestFs())
+		editor.OpenFile("fakefile.txt")
+		first := editor.CurrentPane()
+
+		for _, r := range ctrl('w') + "v" {
+			editor.HandleNormalKey(r)
+		}
+		So(len(editor.Panes()), ShouldEqual, 2)
+		So(editor.Layout().Vertical, ShouldBeTrue)
+
+		for _, r := range ctrl('w') + "w" {
+			editor.HandleNormalKey(r)
+		}
+		So(editor.CurrentPane(), ShouldEqual, first)
+
+		for _, r := range ctrl('w') + "o" {
+			editor.HandleNormalKey(r)
+		}
+		So(editor.Panes(), ShouldResemble, []*Pane{first})
+	})
+}
@@ -0,0 +1,379 @@
+package main
+
+// ScreenRow is the part of a buffer line drawn on one row of a pane.
+// Start is the display column of the first cell and Prefix the number of cells used by
+// the break indicator and indent on continuation rows.
+// Fold is set when the row is the summary of a closed fold starting at Line.
+// Filler rows stand for lines other panes in diff mode have and have no Line.
+type ScreenRow struct {
+	Line         int
+	Start        int
+	Cells        []rune
+	Prefix       int
+	Continuation bool
+	Fold         *Fold
+	Filler       bool
+}
+
+// PaneView records where a Pane's text was last drawn on the screen.
+// It is used to place the cursor and map screen positions back to the buffer.
+// Frame is the whole area of the Pane including the gutter and status line, StatusY is -1 without a status line.
+type PaneView struct {
+	X       int
+	Y       int
+	Width   int
+	Height  int
+	Rows    []ScreenRow
+	Frame   Rect
+	StatusY int
+}
+
+// View returns where the Pane was last drawn.
+func (pane *Pane) View() *PaneView {
+	return &pane.view
+}
+
+// Wrap returns true if long lines in the Pane are soft wrapped.
+func (pane *Pane) Wrap(settings *Settings) bool {
+	if pane.wrap == nil {
+		return settings.Wrap
+	}
+	return *pane.wrap
+}
+
+// SetWrap overrides the Wrap setting for this Pane.
+func (pane *Pane) SetWrap(wrap bool) {
+	pane.wrap = &wrap
+}
+
+// LeftColumn returns the display column of the first visible cell when not wrapping.
+func (pane *Pane) LeftColumn() int {
+	return pane.leftColumn
+}
+
+// SetLeftColumn sets the display column of the first visible cell when not wrapping.
+func (pane *Pane) SetLeftColumn(column int) {
+	pane.leftColumn = column
+}
+
+// ScreenPosition returns the screen position of a display column on a buffer line.
+// ok is false if that line isn't visible.
+func (view *PaneView) ScreenPosition(line, column int) (x, y int, ok bool) {
+	for i, row := range view.Rows {
+		if row.Line != line || (row.Continuation && column < row.Start) {
+			continue
+		}
+
+		x = view.X + row.Prefix + column - row.Start
+		y = view.Y + i
+		ok = true
+
+		if column < row.Start+len(row.Cells)-row.Prefix {
+			break
+		}
+	}
+
+	if ok && x >= view.X+view.Width {
+		x = view.X + view.Width - 1
+	}
+	return x, y, ok
+}
+
+// BufferPosition returns the buffer line and display column drawn at a screen position.
+// Positions past the end of the text give the last line, positions in the gutter give the first column.
+func (view *PaneView) BufferPosition(x, y int) (line, column int, ok bool) {
+	if len(view.Rows) == 0 {
+		return 0, 0, false
+	}
+
+	i := y - view.Y
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(view.Rows) {
+		i = len(view.Rows) - 1
+	}
+
+	row := view.Rows[i]
+	if row.Fold != nil {
+		return row.Fold.Start, 0, true
+	}
+
+	column = row.Start + x - view.X - row.Prefix
+	if column < row.Start {
+		column = row.Start
+	}
+	return row.Line, column, true
+}
+
+// Lines returns the buffer line of each row, 0 for continuation rows and rows past the end of the buffer.
+func (view *PaneView) Lines() []int {
+	lines := make([]int, view.Height)
+	for i, row := range view.Rows {
+		if !row.Continuation {
+			lines[i] = row.Line
+		}
+	}
+	return lines
+}
+
+// CursorScreenPosition returns where the Pane's cursor was last drawn.
+func (pane *Pane) CursorScreenPosition(settings *Settings) (x, y int, ok bool) {
+	if terminal := pane.Buffer().Terminal(); terminal != nil && terminal.Live() {
+		x, y, visible := terminal.VTerm().Cursor()
+		return pane.view.X + x, pane.view.Y + y, visible
+	}
+
+	cursor := pane.Cursor()
+	if cursor == nil {
+		return 0, 0, false
+	}
+
+	xPos, line := cursor.Position()
+	if fold := pane.Folds().ClosedFoldAt(line); fold != nil {
+		return pane.view.ScreenPosition(fold.Start, 0)
+	}
+
+	text, _ := pane.Buffer().GetLine(line)
+	return pane.view.ScreenPosition(line, DisplayColumn(settings, text, xPos))
+}
+
+// ExpandLine converts a line into the cells used to display it.
+func ExpandLine(settings *Settings, line string) []rune {
+	cells := []rune{}
+	for _, r := range line {
+		if r == '\t' {
+			for i := 0; i < settings.ShiftWidth; i++ {
+				cells = append(cells, ' ')
+			}
+			continue
+		}
+		cells = append(cells, r)
+	}
+	return cells
+}
+
+// DisplayColumn returns the display column of the character at index x of the line.
+func DisplayColumn(settings *Settings, line string, x int) int {
+	column := 0
+	for i, r := range []rune(line) {
+		if i == x {
+			break
+		}
+		if r == '\t' {
+			column += settings.ShiftWidth
+		} else {
+			column++
+		}
+	}
+	return column
+}
+
+// CharacterIndex is the inverse of DisplayColumn, it returns the index of the character drawn at the display column.
+// Columns past the end of the line give the last character.
+func CharacterIndex(settings *Settings, line string, column int) int {
+	runes := []rune(line)
+	position := 0
+	for i, r := range runes {
+		width := 1
+		if r == '\t' {
+			width = settings.ShiftWidth
+		}
+		if column < position+width {
+			return i
+		}
+		position += width
+	}
+
+	if len(runes) == 0 {
+		return 0
+	}
+	return len(runes) - 1
+}
+
+// LayoutRows works out what is drawn on each row of a pane's text area.
+// Closed folds are drawn as a single summary row.
+func LayoutRows(settings *Settings, buffer *Buffer, folds *Folds, topLine, leftColumn, width, height int, wrap bool) []ScreenRow {
+	if topLine < 1 {
+		topLine = 1
+	}
+	if width < 0 {
+		width = 0
+	}
+	rows := []ScreenRow{}
+	if height < 1 {
+		return rows
+	}
+
+	lines, _ := buffer.GetLines(topLine, topLine+height-1)
+	first := topLine
+
+	for line := topLine; len(rows) < height; {
+		if line-first >= len(lines) {
+			if line > buffer.LineCount() {
+				break
+			}
+			first = line
+			lines, _ = buffer.GetLines(first, first+height-1)
+		}
+
+		if fold := folds.ClosedFoldAt(line); fold != nil {
+			summary := []rune{}
+			if width > 0 {
+				summary = []rune(folds.Summary(buffer, fold))
+				for len(summary) < width {
+					summary = append(summary, '-')
+				}
+				summary = summary[:width]
+			}
+			rows = append(rows, ScreenRow{Line: line, Cells: summary, Fold: fold})
+			line = fold.End + 1
+			continue
+		}
+
+		cells := ExpandLine(settings, lines[line-first])
+		if !wrap {
+			rows = append(rows, ScreenRow{Line: line, Start: leftColumn, Cells: sliceCells(cells, leftColumn, leftColumn+width)})
+		} else {
+			rows = append(rows, wrapLine(settings, line, cells, width)...)
+		}
+		line++
+	}
+
+	if len(rows) > height {
+		return rows[:height]
+	}
+	return rows
+}
+
+func sliceCells(cells []rune, start, end int) []rune {
+	if start > len(cells) {
+		return []rune{}
+	}
+	if end > len(cells) {
+		end = len(cells)
+	}
+	return cells[start:end]
+}
+
+// wrapLine splits a line into rows no wider than width, breaking at spaces where possible.
+// Continuation rows start with Settings.ShowBreak and, with BreakIndent, the line's indent.
+func wrapLine(settings *Settings, line int, cells []rune, width int) []ScreenRow {
+	if width < 1 {
+		return []ScreenRow{{Line: line}}
+	}
+
+	prefix := []rune(settings.ShowBreak)
+	if settings.BreakIndent {
+		prefix = append(prefix, cells[:indentWidth(cells)]...)
+	}
+	if width-len(prefix) < width/2 {
+		prefix = []rune(settings.ShowBreak)
+	}
+	if len(prefix) >= width {
+		prefix = []rune{}
+	}
+
+	rows := []ScreenRow{}
+	start := 0
+	for {
+		available := width
+		row := ScreenRow{Line: line, Start: start}
+		if start > 0 {
+			available -= len(prefix)
+			row.Prefix = len(prefix)
+			row.Continuation = true
+		}
+
+		end := start + available
+		if end >= len(cells) {
+			row.Cells = append(append([]rune{}, prefix[:row.Prefix]...), cells[start:]...)
+			return append(rows, row)
+		}
+
+		end = wordBreak(cells, start, end)
+		row.Cells = append(append([]rune{}, prefix[:row.Prefix]...), cells[start:end]...)
+		rows = append(rows, row)
+		start = end
+	}
+}
+
+// wordBreak moves end back to just after the last space before it so words aren't split.
+// Words that don't fit on a row at all are split at end.
+func wordBreak(cells []rune, start, end int) int {
+	if cells[end] == ' ' {
+		return end
+	}
+	for i := end - 1; i > start; i-- {
+		if cells[i] == ' ' {
+			return i + 1
+		}
+	}
+	return end
+}
+
+func indentWidth(cells []rune) int {
+	for i, r := range cells {
+		if r != ' ' {
+			return i
+		}
+	}
+	return len(cells)
+}
+
+// UpdateLeftColumn sets the given Pane's LeftColumn so the cursor stays visible.
+// Settings.SideScrollOffset is the minimum number of columns kept visible on either side of the cursor.
+func UpdateLeftColumn(settings *Settings, pane *Pane, visibleWidth int) {
+	if pane.Wrap(settings) {
+		pane.SetLeftColumn(0)
+		return
+	}
+
+	xPos, line := pane.Cursor().Position()
+	text, _ := pane.Buffer().GetLine(line)
+	column := DisplayColumn(settings, text, xPos)
+
+	if settings.SideScrollOffset*2 >= visibleWidth {
+		newColumn := column - visibleWidth/2
+		if newColumn < 0 {
+			newColumn = 0
+		}
+		pane.SetLeftColumn(newColumn)
+		return
+	}
+
+	if pane.LeftColumn() > column-settings.SideScrollOffset {
+		newColumn := column - settings.SideScrollOffset
+		if newColumn < 0 {
+			newColumn = 0
+		}
+		pane.SetLeftColumn(newColumn)
+		return
+	}
+
+	rightColumn := pane.LeftColumn() + visibleWidth - 1
+	if rightColumn < column+settings.SideScrollOffset {
+		pane.SetLeftColumn(column + settings.SideScrollOffset - visibleWidth + 1)
+	}
+}
+
+// scrollToWrappedCursor moves the Pane's TopLine down until the cursor is visible
+// when lines above it wrap onto several rows.
+func scrollToWrappedCursor(settings *Settings, pane *Pane, width, height int) {
+	xPos, line := pane.Cursor().Position()
+	text, _ := pane.Buffer().GetLine(line)
+	column := DisplayColumn(settings, text, xPos)
+
+	if fold := pane.Folds().ClosedFoldAt(line); fold != nil {
+		line, column = fold.Start, 0
+	}
+
+	for pane.TopLine() < line {
+		view := PaneView{Width: width, Height: height}
+		view.Rows = LayoutRows(settings, pane.Buffer(), pane.Folds(), pane.TopLine(), 0, width, height, true)
+		if _, y, ok := view.ScreenPosition(line, column); ok && y < height-settings.ScrollOffset {
+			return
+		}
+		pane.SetTopLine(pane.TopLine() + 1)
+	}
+}
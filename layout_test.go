@@ -0,0 +1,232 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpandLine(t *testing.T) {
+	Convey("tabs expand to ShiftWidth spaces", t, func() {
+		settings := DefaultSettings()
+		So(string(ExpandLine(&settings, "\ta")), ShouldEqual, "    a")
+		So(DisplayColumn(&settings, "\ta\tb", 2), ShouldEqual, 5)
+		So(DisplayColumn(&settings, "abc", 10), ShouldEqual, 3)
+	})
+}
+
+func rowText(rows []ScreenRow) []string {
+	text := []string{}
+	for _, row := range rows {
+		text = append(text, string(row.Cells))
+	}
+	return text
+}
+
+func TestLayoutRows(t *testing.T) {
+	Convey("Buffer with a long indented line", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("  the quick brown fox\nend")
+		settings := DefaultSettings()
+		settings.ShowBreak = ">"
+
+		Convey("without wrap lines are cut off at the left column", func() {
+			rows := LayoutRows(&settings, &buffer, nil, 1, 6, 5, 3, false)
+			So(rowText(rows), ShouldResemble, []string{"quick", ""})
+			So(rows[0].Start, ShouldEqual, 6)
+		})
+
+		Convey("with wrap lines break at word boundaries with an indented break indicator", func() {
+			rows := LayoutRows(&settings, &buffer, nil, 1, 0, 10, 5, true)
+			So(rowText(rows), ShouldResemble, []string{"  the ", ">  quick ", ">  brown ", ">  fox", "end"})
+			So(rows[1].Continuation, ShouldBeTrue)
+			So(rows[1].Prefix, ShouldEqual, 3)
+			So(rows[1].Start, ShouldEqual, 6)
+			So(rows[4].Line, ShouldEqual, 2)
+		})
+
+		Convey("long words are split", func() {
+			buffer.SetDataString("abcdefgh")
+			settings.BreakIndent = false
+			rows := LayoutRows(&settings, &buffer, nil, 1, 0, 5, 5, true)
+			So(rowText(rows), ShouldResemble, []string{"abcde", ">fgh"})
+		})
+
+		Convey("rows are limited to the height", func() {
+			rows := LayoutRows(&settings, &buffer, nil, 1, 0, 10, 2, true)
+			So(len(rows), ShouldEqual, 2)
+		})
+
+		Convey("closed folds are empty rows in a pane without width", func() {
+			folds := &Folds{}
+			folds.Create(1, 2)
+			for _, width := range []int{0, -3} {
+				rows := LayoutRows(&settings, &buffer, folds, 1, 0, width, 2, false)
+				So(rows, ShouldHaveLength, 1)
+				So(rows[0].Fold, ShouldNotBeNil)
+				So(rows[0].Cells, ShouldBeEmpty)
+			}
+		})
+	})
+}
+
+func TestPaneViewScreenPosition(t *testing.T) {
+	Convey("View of a wrapped line", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("  the quick brown fox\nend")
+		settings := DefaultSettings()
+		settings.ShowBreak = ">"
+		view := PaneView{X: 2, Y: 1, Width: 10, Height: 5}
+		view.Rows = LayoutRows(&settings, &buffer, nil, 1, 0, 10, 5, true)
+
+		Convey("columns on the first row", func() {
+			x, y, ok := view.ScreenPosition(1, 2)
+			So(ok, ShouldBeTrue)
+			So(x, ShouldEqual, 4)
+			So(y, ShouldEqual, 1)
+		})
+
+		Convey("columns on continuation rows skip the prefix", func() {
+			x, y, _ := view.ScreenPosition(1, 12)
+			So(x, ShouldEqual, 2+3)
+			So(y, ShouldEqual, 3)
+		})
+
+		Convey("end of line stays on the last row", func() {
+			x, y, _ := view.ScreenPosition(1, 21)
+			So(x, ShouldEqual, 2+3+3)
+			So(y, ShouldEqual, 4)
+		})
+
+		Convey("lines that aren't visible", func() {
+			_, _, ok := view.ScreenPosition(9, 0)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Lines marks continuation rows with 0", func() {
+			So(view.Lines(), ShouldResemble, []int{1, 0, 0, 0, 2})
+		})
+
+		Convey("BufferPosition is the inverse of ScreenPosition", func() {
+			line, column, ok := view.BufferPosition(2+3, 3)
+			So(ok, ShouldBeTrue)
+			So(line, ShouldEqual, 1)
+			So(column, ShouldEqual, 12)
+		})
+
+		Convey("BufferPosition on the break indicator gives the start of the row", func() {
+			_, column, _ := view.BufferPosition(2, 3)
+			So(column, ShouldEqual, 12)
+		})
+
+		Convey("BufferPosition past the last row gives the last line", func() {
+			line, _, _ := view.BufferPosition(2, 9)
+			So(line, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUpdateLeftColumn(t *testing.T) {
+	Convey("Pane without wrap", t, func() {
+		buffer := NewBuffer()
+		buffer.SetDataString("0123456789abcdefghij")
+		pane := NewPane()
+		pane.SetBuffer(&buffer)
+		pane.SetWrap(false)
+		settings := DefaultSettings()
+
+		Convey("scrolls right when the cursor goes past the edge", func() {
+			pane.Cursor().Move(12, 1)
+			UpdateLeftColumn(&settings, &pane, 10)
+			So(pane.LeftColumn(), ShouldEqual, 3)
+
+			Convey("and back left", func() {
+				pane.Cursor().Move(1, 1)
+				UpdateLeftColumn(&settings, &pane, 10)
+				So(pane.LeftColumn(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("keeps SideScrollOffset columns visible", func() {
+			settings.SideScrollOffset = 2
+			pane.Cursor().Move(12, 1)
+			UpdateLeftColumn(&settings, &pane, 10)
+			So(pane.LeftColumn(), ShouldEqual, 5)
+		})
+
+		Convey("doesn't scroll when wrapping", func() {
+			pane.SetWrap(true)
+			pane.Cursor().Move(12, 1)
+			UpdateLeftColumn(&settings, &pane, 10)
+			So(pane.LeftColumn(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestRenderWrappedPane(t *testing.T) {
+	Convey("Editor showing a long line", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{"a.txt": []byte("one two three\nx\ny\nz\n")})
+		editor := NewEditor(fs)
+		editor.OpenFile("a.txt")
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		editor.Settings().ShowBreak = ""
+		grid := NewRuneGrid(6, 4)
+
+		Convey("wraps it", func() {
+			grid.RenderEditor(&editor)
+			expected := StringToRuneGrid(`
+			one___
+			two___
+			three_
+			______
+			`, '_')
+			expected.SetCell(3, 0, ' ')
+			expected.SetCell(3, 1, ' ')
+			So(grid.Cells(), ShouldResemble, expected.Cells())
+		})
+
+		Convey("scrolls down far enough to show the cursor", func() {
+			editor.CurrentPane().Cursor().Move(0, 2)
+			grid.RenderEditor(&editor)
+			So(editor.CurrentPane().TopLine(), ShouldEqual, 2)
+
+			x, y, ok := editor.CurrentPane().CursorScreenPosition(editor.Settings())
+			So(ok, ShouldBeTrue)
+			So(x, ShouldEqual, 0)
+			So(y, ShouldEqual, 0)
+		})
+
+		Convey("scrolls sideways with :setlocal nowrap", func() {
+			So(editor.ExecuteCommand(":setlocal nowrap"), ShouldBeNil)
+			editor.CurrentPane().Cursor().Move(10, 1)
+			grid.RenderEditor(&editor)
+			So(string(grid.Cells()[0]), ShouldEqual, "wo thr")
+
+			x, _, _ := editor.CurrentPane().CursorScreenPosition(editor.Settings())
+			So(x, ShouldEqual, 5)
+		})
+
+		Convey("on screens too small for the text", func() {
+			editor.Settings().Borders = true
+			editor.Settings().StatusLine = "%f%=%l:%c"
+			for _, size := range [][]int{{80, 1}, {1, 6}} {
+				grid := NewRuneGrid(size[0], size[1])
+				So(func() { grid.RenderEditor(&editor) }, ShouldNotPanic)
+			}
+		})
+	})
+}
+
+func TestCharacterIndex(t *testing.T) {
+	Convey("CharacterIndex finds the character at a display column", t, func() {
+		settings := DefaultSettings()
+		settings.ShiftWidth = 4
+
+		So(CharacterIndex(&settings, "a\tb", 0), ShouldEqual, 0)
+		So(CharacterIndex(&settings, "a\tb", 3), ShouldEqual, 1)
+		So(CharacterIndex(&settings, "a\tb", 5), ShouldEqual, 2)
+		So(CharacterIndex(&settings, "a\tb", 40), ShouldEqual, 2)
+		So(CharacterIndex(&settings, "", 3), ShouldEqual, 0)
+	})
+}
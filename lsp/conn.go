@@ -0,0 +1,314 @@
+// Package lsp is the client side of the Language Server Protocol: a JSON-RPC 2.0 connection using
+// the protocol's Content-Length framing, the types of the requests jkl sends and positions counted
+// in UTF-16 code units like the protocol does.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Error is the error a request was answered with.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// ErrClosed is the error of requests that weren't answered before the connection closed.
+var ErrClosed = errors.New("Connection closed")
+
+// message is a request, reply or notification, requests and replies have an id.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// Handler handles the requests and notifications from the other side. It calls reply once with the
+// result of a request, maybe later and from another goroutine. Replies to notifications are dropped.
+type Handler func(method string, params json.RawMessage, reply func(result interface{}, err error))
+
+// Conn is a JSON-RPC 2.0 connection, see Run. It is safe to use from several goroutines.
+// Messages are written by a goroutine of their own so a slow reader on the other side doesn't
+// hold up the handler.
+type Conn struct {
+	stream  io.ReadWriteCloser
+	handler Handler
+	done    chan struct{}
+	out     chan []byte
+	quit    chan struct{}
+	stopped sync.Once
+
+	mutex   sync.Mutex
+	lastID  int
+	replies map[int]func(result json.RawMessage, err error)
+	closed  bool
+}
+
+// NewConn constructs a connection over a stream, ie the stdin and stdout of a language server.
+func NewConn(stream io.ReadWriteCloser, handler Handler) *Conn {
+	conn := &Conn{
+		stream:  stream,
+		handler: handler,
+		done:    make(chan struct{}),
+		out:     make(chan []byte, 1024),
+		quit:    make(chan struct{}),
+		replies: map[int]func(json.RawMessage, error){},
+	}
+	go conn.write()
+	return conn
+}
+
+// write writes the queued messages until the connection closes, nil closes it.
+func (conn *Conn) write() {
+	for {
+		select {
+		case data := <-conn.out:
+			if data == nil || WriteMessage(conn.stream, data) != nil {
+				conn.stop()
+				return
+			}
+		case <-conn.quit:
+			return
+		}
+	}
+}
+
+// stop closes the stream without waiting for the messages still queued.
+func (conn *Conn) stop() {
+	conn.stopped.Do(func() {
+		close(conn.quit)
+		conn.stream.Close()
+	})
+}
+
+// Run reads messages until the connection closes, calling the handler and the replies of requests
+// on its own goroutine. Requests without a reply get ErrClosed. It returns why it stopped.
+func (conn *Conn) Run() error {
+	reader := bufio.NewReader(conn.stream)
+	var err error
+	for {
+		var data []byte
+		if data, err = ReadMessage(reader); err != nil {
+			break
+		}
+		conn.receive(data)
+	}
+
+	conn.mutex.Lock()
+	closed := conn.closed
+	conn.closed = true
+	replies := conn.replies
+	conn.replies = map[int]func(json.RawMessage, error){}
+	conn.mutex.Unlock()
+
+	conn.stop()
+	for _, reply := range replies {
+		reply(nil, ErrClosed)
+	}
+	close(conn.done)
+	if closed || err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Done is closed once Run returns.
+func (conn *Conn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Close closes the stream once the messages already sent are written, Run returns once it notices.
+func (conn *Conn) Close() error {
+	conn.mutex.Lock()
+	closed := conn.closed
+	conn.closed = true
+	conn.mutex.Unlock()
+	if !closed {
+		select {
+		case conn.out <- nil:
+		case <-conn.quit:
+		}
+	}
+	return nil
+}
+
+// Call sends a request, reply is called with its result or error.
+func (conn *Conn) Call(method string, params interface{}, reply func(result json.RawMessage, err error)) {
+	conn.mutex.Lock()
+	conn.lastID++
+	id := conn.lastID
+	conn.replies[id] = reply
+	conn.mutex.Unlock()
+
+	raw := json.RawMessage(strconv.Itoa(id))
+	if err := conn.send(message{ID: &raw, Method: method}, params); err != nil {
+		conn.mutex.Lock()
+		_, waiting := conn.replies[id]
+		delete(conn.replies, id)
+		conn.mutex.Unlock()
+		if waiting {
+			reply(nil, err)
+		}
+	}
+}
+
+// Notify sends a notification, which isn't answered.
+func (conn *Conn) Notify(method string, params interface{}) error {
+	return conn.send(message{Method: method}, params)
+}
+
+// send writes a message with its params or result.
+func (conn *Conn) send(m message, value interface{}) error {
+	m.JSONRPC = "2.0"
+	if value != nil {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if m.Method != "" {
+			m.Params = data
+		} else {
+			m.Result = data
+		}
+	} else if m.Method == "" && m.Error == nil {
+		m.Result = json.RawMessage("null")
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	conn.mutex.Lock()
+	closed := conn.closed
+	conn.mutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	select {
+	case conn.out <- data:
+		return nil
+	case <-conn.quit:
+		return ErrClosed
+	}
+}
+
+// receive handles a message that was read.
+func (conn *Conn) receive(data []byte) {
+	m := message{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		conn.send(message{ID: &nullID, Error: &Error{Code: ParseError, Message: err.Error()}}, nil)
+		return
+	}
+
+	if m.Method == "" {
+		id := 0
+		if m.ID == nil || json.Unmarshal(*m.ID, &id) != nil {
+			return
+		}
+		conn.mutex.Lock()
+		reply, ok := conn.replies[id]
+		delete(conn.replies, id)
+		conn.mutex.Unlock()
+		if !ok {
+			return
+		}
+		if m.Error != nil {
+			reply(nil, m.Error)
+		} else {
+			reply(m.Result, nil)
+		}
+		return
+	}
+
+	request := m
+	var once sync.Once
+	conn.handler(m.Method, m.Params, func(result interface{}, err error) {
+		once.Do(func() {
+			if request.ID == nil {
+				return
+			}
+			reply := message{ID: request.ID}
+			if err != nil {
+				reply.Error, _ = err.(*Error)
+				if reply.Error == nil {
+					reply.Error = &Error{Code: InternalError, Message: err.Error()}
+				}
+				result = nil
+			}
+			conn.send(reply, result)
+		})
+	})
+}
+
+var nullID = json.RawMessage("null")
+
+// ReadMessage reads the body of a message, the headers before it have to include its Content-Length.
+func ReadMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("Invalid header: %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("Invalid Content-Length: %q", value)
+			}
+		}
+	}
+	if length == -1 {
+		return nil, errors.New("Missing Content-Length")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteMessage writes a message with its Content-Length header.
+func WriteMessage(writer io.Writer, data []byte) error {
+	message := make([]byte, 0, len(data)+32)
+	message = append(message, "Content-Length: "+strconv.Itoa(len(data))+"\r\n\r\n"...)
+	message = append(message, data...)
+	_, err := writer.Write(message)
+	return err
+}
@@ -0,0 +1,244 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pipe is one end of an in-memory stream.
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (p pipe) Close() error {
+	p.Reader.(*io.PipeReader).Close()
+	return p.WriteCloser.Close()
+}
+
+// pipes returns both ends of an in-memory stream.
+func pipes() (pipe, pipe) {
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+	return pipe{clientRead, clientWrite}, pipe{serverRead, serverWrite}
+}
+
+// wait returns what arrives on the channel or fails after a second.
+func wait(values chan interface{}) interface{} {
+	select {
+	case value := <-values:
+		return value
+	case <-time.After(time.Second):
+		return "timed out"
+	}
+}
+
+func TestConn(t *testing.T) {
+	Convey("Two connections", t, func() {
+		clientEnd, serverEnd := pipes()
+		notified := make(chan interface{}, 10)
+		server := NewConn(serverEnd, func(method string, params json.RawMessage, reply func(interface{}, error)) {
+			switch method {
+			case "add":
+				numbers := []int{}
+				json.Unmarshal(params, &numbers)
+				reply(numbers[0]+numbers[1], nil)
+			case "hang":
+			case "later":
+				go reply("done", nil)
+			case "fail":
+				reply(nil, &Error{Code: 7, Message: "Failed"})
+			case "note":
+				notified <- string(params)
+				reply("ignored", nil)
+			default:
+				reply(nil, &Error{Code: MethodNotFound, Message: "Unknown method: " + method})
+			}
+		})
+		client := NewConn(clientEnd, func(method string, params json.RawMessage, reply func(interface{}, error)) {
+			reply(nil, errors.New("Client error"))
+		})
+		go server.Run()
+		go client.Run()
+
+		results := make(chan interface{}, 10)
+		collect := func(result json.RawMessage, err error) {
+			if err != nil {
+				results <- err
+			} else {
+				results <- string(result)
+			}
+		}
+
+		Convey("answer requests", func() {
+			client.Call("add", []int{2, 3}, collect)
+			So(wait(results), ShouldEqual, "5")
+			client.Call("later", nil, collect)
+			So(wait(results), ShouldEqual, `"done"`)
+		})
+
+		Convey("pass on errors", func() {
+			client.Call("fail", nil, collect)
+			err := wait(results).(*Error)
+			So(err.Code, ShouldEqual, 7)
+			So(err.Error(), ShouldEqual, "Failed")
+
+			client.Call("missing", nil, collect)
+			So(wait(results).(*Error).Code, ShouldEqual, MethodNotFound)
+
+			server.Call("anything", nil, collect)
+			err = wait(results).(*Error)
+			So(err.Code, ShouldEqual, InternalError)
+			So(err.Error(), ShouldEqual, "Client error")
+		})
+
+		Convey("send notifications", func() {
+			So(client.Notify("note", map[string]int{"x": 1}), ShouldBeNil)
+			So(wait(notified), ShouldEqual, `{"x":1}`)
+		})
+
+		Convey("fail requests once closed", func() {
+			client.Notify("note", "last")
+			So(client.Close(), ShouldBeNil)
+			So(wait(notified), ShouldEqual, `"last"`)
+			<-client.Done()
+			client.Call("add", []int{1, 1}, collect)
+			So(wait(results), ShouldEqual, ErrClosed)
+			So(client.Notify("note", nil), ShouldEqual, ErrClosed)
+		})
+
+		Convey("fail waiting requests when the other side goes away", func() {
+			client.Call("hang", nil, collect)
+			time.Sleep(10 * time.Millisecond)
+			server.Close()
+			So(wait(results), ShouldEqual, ErrClosed)
+		})
+	})
+}
+
+func TestMessages(t *testing.T) {
+	Convey("Messages", t, func() {
+		Convey("are written with their length", func() {
+			out := bytes.Buffer{}
+			So(WriteMessage(&out, []byte(`{"a":"é"}`)), ShouldBeNil)
+			So(out.String(), ShouldEqual, "Content-Length: 10\r\n\r\n{\"a\":\"é\"}")
+		})
+
+		Convey("are read with any headers", func() {
+			reader := bufio.NewReader(strings.NewReader(
+				"Content-Type: application/vscode-jsonrpc\r\ncontent-length: 2\r\n\r\n{}Content-Length: 4\r\n\r\nnull"))
+			data, err := ReadMessage(reader)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "{}")
+			data, err = ReadMessage(reader)
+			So(string(data), ShouldEqual, "null")
+			_, err = ReadMessage(reader)
+			So(err, ShouldEqual, io.EOF)
+		})
+
+		Convey("need a length", func() {
+			_, err := ReadMessage(bufio.NewReader(strings.NewReader("\r\n{}")))
+			So(err.Error(), ShouldEqual, "Missing Content-Length")
+			_, err = ReadMessage(bufio.NewReader(strings.NewReader("Content-Length: 10\r\n\r\n{}")))
+			So(err, ShouldEqual, io.ErrUnexpectedEOF)
+		})
+	})
+}
+
+func TestProtocol(t *testing.T) {
+	Convey("Results in their different forms are read", t, func() {
+		locations := Locations{}
+		json.Unmarshal([]byte(`{"uri":"file:///a","range":{"start":{"line":1,"character":2},"end":{"line":1,"character":3}}}`), &locations)
+		So(locations, ShouldHaveLength, 1)
+		So(locations[0].Range.Start, ShouldResemble, Position{1, 2})
+		json.Unmarshal([]byte(`[{"targetUri":"file:///b","targetSelectionRange":{"start":{"line":4,"character":0},"end":{"line":4,"character":1}}}]`), &locations)
+		So(locations[0].URI, ShouldEqual, "file:///b")
+		So(locations[0].Range.Start.Line, ShouldEqual, 4)
+		json.Unmarshal([]byte(`null`), &locations)
+		So(locations, ShouldBeEmpty)
+
+		hover := Hover{}
+		json.Unmarshal([]byte(`{"contents":{"kind":"markdown","value":"func f()"}}`), &hover)
+		So(hover.Contents, ShouldEqual, MarkupText("func f()"))
+		json.Unmarshal([]byte(`{"contents":["text",{"language":"go","value":"code"}]}`), &hover)
+		So(hover.Contents, ShouldEqual, MarkupText("text\n\ncode"))
+
+		actions := []CodeAction{}
+		json.Unmarshal([]byte(`[{"title":"Fix","edit":{"changes":{}}},{"title":"Run","command":"run.it","arguments":[1]}]`), &actions)
+		So(actions[0].Edit, ShouldNotBeNil)
+		So(actions[0].Command, ShouldBeNil)
+		So(actions[1].Command.Command, ShouldEqual, "run.it")
+
+		completions := CompletionList{}
+		json.Unmarshal([]byte(`[{"label":"Println","insertText":"Println($1)","insertTextFormat":2}]`), &completions)
+		So(completions.Items[0].InsertTextFormat, ShouldEqual, InsertTextFormatSnippet)
+		json.Unmarshal([]byte(`{"isIncomplete":true,"items":[{"label":"a"},{"label":"b"}]}`), &completions)
+		So(completions.IsIncomplete, ShouldBeTrue)
+		So(completions.Items, ShouldHaveLength, 2)
+
+		symbols := Symbols{}
+		json.Unmarshal([]byte(`[{"name":"T","kind":23,"selectionRange":{"start":{"line":2,"character":5},"end":{"line":2,"character":6}},
+			"children":[{"name":"f","kind":8,"selectionRange":{"start":{"line":3,"character":1},"end":{"line":3,"character":2}}}]}]`), &symbols)
+		So(symbols, ShouldHaveLength, 2)
+		So(symbols[1].Name, ShouldEqual, "f")
+		So(symbols[1].Container, ShouldEqual, "T")
+		So(symbols[1].Location.Range.Start, ShouldResemble, Position{3, 1})
+		So(SymbolKindName(symbols[0].Kind), ShouldEqual, "struct")
+		json.Unmarshal([]byte(`[{"name":"main","kind":12,"containerName":"pkg","location":{"uri":"file:///a.go","range":{"start":{"line":7,"character":0},"end":{"line":9,"character":1}}}}]`), &symbols)
+		So(symbols, ShouldResemble, Symbols{{Name: "main", Kind: 12, Container: "pkg",
+			Location: Location{URI: "file:///a.go", Range: Range{Position{7, 0}, Position{9, 1}}}}})
+
+		capabilities := ServerCapabilities{}
+		json.Unmarshal([]byte(`{"textDocumentSync":2,"hoverProvider":true,"renameProvider":{"prepareProvider":true}}`), &capabilities)
+		So(capabilities.TextDocumentSync.Change, ShouldEqual, SyncIncremental)
+		So(bool(capabilities.HoverProvider), ShouldBeTrue)
+		So(bool(capabilities.RenameProvider), ShouldBeTrue)
+		So(bool(capabilities.DefinitionProvider), ShouldBeFalse)
+		json.Unmarshal([]byte(`{"textDocumentSync":{"change":1,"save":{"includeText":false}}}`), &capabilities)
+		So(capabilities.TextDocumentSync, ShouldResemble, TextDocumentSync{Change: SyncFull, Save: true})
+	})
+
+	Convey("Workspace edits are collected by document", t, func() {
+		edit := WorkspaceEdit{
+			Changes:         map[string][]TextEdit{"file:///a": {{NewText: "1"}}},
+			DocumentChanges: []TextDocumentEdit{{TextDocument: VersionedTextDocumentIdentifier{URI: "file:///a"}, Edits: []TextEdit{{NewText: "2"}}}},
+		}
+		So(edit.Edits()["file:///a"], ShouldHaveLength, 2)
+	})
+
+	Convey("File URIs are escaped", t, func() {
+		uri := FileURI("/tmp/a file#1.go")
+		So(uri, ShouldEqual, "file:///tmp/a%20file%231.go")
+		path, err := URIPath(uri)
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, "/tmp/a file#1.go")
+		_, err = URIPath("http://example.com/")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPositions(t *testing.T) {
+	Convey("Positions count UTF-16 code units", t, func() {
+		text := []byte("héllo\n😀x\nend")
+		So(PositionOf(text, 3), ShouldResemble, Position{0, 2})
+		So(PositionOf(text, 11), ShouldResemble, Position{1, 2})
+		So(PositionOf(text, 12), ShouldResemble, Position{1, 3})
+		So(PositionOf(text, 100), ShouldResemble, Position{2, 3})
+
+		So(Offset(text, Position{1, 2}), ShouldEqual, 11)
+		So(Offset(text, Position{1, 3}), ShouldEqual, 12)
+		So(Offset(text, Position{0, 50}), ShouldEqual, 6)
+		So(Offset(text, Position{9, 0}), ShouldEqual, len(text))
+
+		So(Column("😀x", 2), ShouldEqual, 1)
+		So(Character("😀x", 2), ShouldEqual, 3)
+	})
+}
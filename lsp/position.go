@@ -0,0 +1,72 @@
+package lsp
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PositionOf returns the Position of a byte offset in the text.
+func PositionOf(text []byte, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	position := Position{}
+	for i := 0; i < offset; {
+		r, size := utf8.DecodeRune(text[i:])
+		i += size
+		if r == '\n' {
+			position.Line++
+			position.Character = 0
+		} else {
+			position.Character += utf16.RuneLen(r)
+		}
+	}
+	return position
+}
+
+// Offset returns the byte offset of a Position in the text. Positions past the end of a line are the
+// end of the line and those past the last line the end of the text.
+func Offset(text []byte, position Position) int {
+	offset := 0
+	for line := 0; line < position.Line; line++ {
+		for offset < len(text) && text[offset] != '\n' {
+			offset++
+		}
+		if offset == len(text) {
+			return offset
+		}
+		offset++
+	}
+	for character := 0; character < position.Character && offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRune(text[offset:])
+		offset += size
+		character += utf16.RuneLen(r)
+	}
+	return offset
+}
+
+// Column returns the Character of a Position in the line as a count of runes.
+func Column(line string, character int) int {
+	column := 0
+	for _, r := range line {
+		if character <= 0 {
+			break
+		}
+		character -= utf16.RuneLen(r)
+		column++
+	}
+	return column
+}
+
+// Character returns the Character of a Position for a count of runes into the line.
+func Character(line string, column int) int {
+	character := 0
+	for _, r := range line {
+		if column <= 0 {
+			break
+		}
+		character += utf16.RuneLen(r)
+		column--
+	}
+	return character
+}
@@ -0,0 +1,517 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Position is a place in a document, both start at 0 and Character counts UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is the text from Start up to End.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range in a document.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Locations is the result of requests like textDocument/definition, which can answer with a
+// Location, a list of them, a list of LocationLinks or null.
+type Locations []Location
+
+// UnmarshalJSON reads any of the forms of Locations.
+func (locations *Locations) UnmarshalJSON(data []byte) error {
+	type link struct {
+		Location
+		TargetURI            string `json:"targetUri"`
+		TargetSelectionRange Range  `json:"targetSelectionRange"`
+	}
+	list := []link{}
+	if len(data) > 0 && data[0] == '{' {
+		one := link{}
+		if err := json.Unmarshal(data, &one); err != nil {
+			return err
+		}
+		list = append(list, one)
+	} else if string(data) != "null" {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+	}
+
+	*locations = Locations{}
+	for _, l := range list {
+		if l.TargetURI != "" {
+			l.Location = Location{URI: l.TargetURI, Range: l.TargetSelectionRange}
+		}
+		*locations = append(*locations, l.Location)
+	}
+	return nil
+}
+
+// Documents are named by a URI, file URIs are made with FileURI.
+type (
+	TextDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+	VersionedTextDocumentIdentifier struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+	}
+	TextDocumentItem struct {
+		URI        string `json:"uri"`
+		LanguageID string `json:"languageId"`
+		Version    int    `json:"version"`
+		Text       string `json:"text"`
+	}
+	TextDocumentPositionParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+		Position     Position               `json:"position"`
+	}
+)
+
+// Synchronising documents.
+type (
+	DidOpenTextDocumentParams struct {
+		TextDocument TextDocumentItem `json:"textDocument"`
+	}
+	DidChangeTextDocumentParams struct {
+		TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+		ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+	}
+	DidSaveTextDocumentParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+	DidCloseTextDocumentParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+)
+
+// TextDocumentContentChangeEvent replaces the Range with the Text, or the whole document without one.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Kinds of TextDocumentSyncOptions.Change.
+const (
+	SyncNone        = 0
+	SyncFull        = 1
+	SyncIncremental = 2
+)
+
+// Diagnostic severities.
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+	SeverityHint        = 4
+)
+
+// Diagnostic is a problem a server found in a document.
+type Diagnostic struct {
+	Range    Range           `json:"range"`
+	Severity int             `json:"severity,omitempty"`
+	Code     json.RawMessage `json:"code,omitempty"`
+	Source   string          `json:"source,omitempty"`
+	Message  string          `json:"message"`
+}
+
+// PublishDiagnosticsParams are the diagnostics of a document, they replace those sent before.
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// MarkupText is documentation that may come as a string, MarkupContent, a MarkedString or a list of
+// them. It is read as plain text with the paragraphs separated by blank lines.
+type MarkupText string
+
+// UnmarshalJSON reads any of the forms of MarkupText.
+func (text *MarkupText) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*text = MarkupText(strings.TrimSpace(markupString(value)))
+	return nil
+}
+
+func markupString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case map[string]interface{}:
+		text, _ := value["value"].(string)
+		return text
+	case []interface{}:
+		parts := []string{}
+		for _, part := range value {
+			parts = append(parts, strings.TrimSpace(markupString(part)))
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// Hover is the information about the symbol at a position.
+type Hover struct {
+	Contents MarkupText `json:"contents"`
+}
+
+// TextEdit replaces a range of a document with the NewText.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// TextDocumentEdit are edits to one version of a document.
+type TextDocumentEdit struct {
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                      `json:"edits"`
+}
+
+// WorkspaceEdit are edits to several documents, servers send either Changes or DocumentChanges.
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []TextDocumentEdit    `json:"documentChanges,omitempty"`
+}
+
+// Edits returns the edits of each document whichever way they were sent.
+func (edit WorkspaceEdit) Edits() map[string][]TextEdit {
+	edits := map[string][]TextEdit{}
+	for uri, changes := range edit.Changes {
+		edits[uri] = append(edits[uri], changes...)
+	}
+	for _, change := range edit.DocumentChanges {
+		edits[change.TextDocument.URI] = append(edits[change.TextDocument.URI], change.Edits...)
+	}
+	return edits
+}
+
+// ApplyWorkspaceEditParams is a server asking to change documents, ie for a code action.
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// ApplyWorkspaceEditResult answers ApplyWorkspaceEditParams.
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// Requests about a position.
+type (
+	ReferenceParams struct {
+		TextDocumentPositionParams
+		Context ReferenceContext `json:"context"`
+	}
+	ReferenceContext struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	}
+	RenameParams struct {
+		TextDocumentPositionParams
+		NewName string `json:"newName"`
+	}
+)
+
+// Command is a command of the server, run with workspace/executeCommand.
+type Command struct {
+	Title     string            `json:"title"`
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// ExecuteCommandParams runs a Command.
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// CodeActionParams asks for the code actions of a range.
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+// CodeActionContext are the diagnostics in the range.
+type CodeActionContext struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// CodeAction changes the documents with its Edit, then runs its Command. Servers can answer with
+// plain Commands instead, which are read as a CodeAction with just the Command.
+type CodeAction struct {
+	Title   string         `json:"title"`
+	Kind    string         `json:"kind,omitempty"`
+	Edit    *WorkspaceEdit `json:"edit,omitempty"`
+	Command *Command       `json:"command,omitempty"`
+}
+
+// UnmarshalJSON reads a CodeAction or a Command.
+func (action *CodeAction) UnmarshalJSON(data []byte) error {
+	var fields struct {
+		Title   string          `json:"title"`
+		Kind    string          `json:"kind"`
+		Edit    *WorkspaceEdit  `json:"edit"`
+		Command json.RawMessage `json:"command"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*action = CodeAction{Title: fields.Title, Kind: fields.Kind, Edit: fields.Edit}
+	if len(fields.Command) > 0 && fields.Command[0] == '"' {
+		command := Command{}
+		err := json.Unmarshal(data, &command)
+		action.Command = &command
+		return err
+	}
+	if len(fields.Command) > 0 && string(fields.Command) != "null" {
+		action.Command = &Command{}
+		return json.Unmarshal(fields.Command, action.Command)
+	}
+	return nil
+}
+
+// FormattingOptions say how to indent a formatted document.
+type FormattingOptions struct {
+	TabSize      int  `json:"tabSize"`
+	InsertSpaces bool `json:"insertSpaces"`
+}
+
+// DocumentFormattingParams asks for the edits that format a document.
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+// SignatureHelp are the signatures of the function being called at a position.
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature int                    `json:"activeSignature"`
+	ActiveParameter int                    `json:"activeParameter"`
+}
+
+// SignatureInformation is one signature of a function.
+type SignatureInformation struct {
+	Label         string     `json:"label"`
+	Documentation MarkupText `json:"documentation,omitempty"`
+}
+
+// InsertTextFormatSnippet marks a CompletionItem whose text is a snippet with tab stops such as $1 and ${2:default}.
+const InsertTextFormatSnippet = 2
+
+// CompletionItem is a suggestion for the word being typed. TextEdit, if there is one, says what it replaces,
+// otherwise it replaces the word before the position.
+type CompletionItem struct {
+	Label            string    `json:"label"`
+	Kind             int       `json:"kind,omitempty"`
+	Detail           string    `json:"detail,omitempty"`
+	FilterText       string    `json:"filterText,omitempty"`
+	InsertText       string    `json:"insertText,omitempty"`
+	InsertTextFormat int       `json:"insertTextFormat,omitempty"`
+	TextEdit         *TextEdit `json:"textEdit,omitempty"`
+}
+
+// CompletionList are the suggestions for a position, incomplete lists change as more is typed.
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// UnmarshalJSON reads a CompletionList or a plain list of items.
+func (list *CompletionList) UnmarshalJSON(data []byte) error {
+	*list = CompletionList{}
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, &list.Items)
+	} else if string(data) == "null" {
+		return nil
+	}
+	var fields struct {
+		IsIncomplete bool             `json:"isIncomplete"`
+		Items        []CompletionItem `json:"items"`
+	}
+	err := json.Unmarshal(data, &fields)
+	list.IsIncomplete, list.Items = fields.IsIncomplete, fields.Items
+	return err
+}
+
+// DocumentSymbolParams asks for the symbols defined in a document.
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// Symbol is something named in a document, Container is the name of what it's defined in if anything.
+type Symbol struct {
+	Name      string
+	Kind      int
+	Container string
+	Location  Location
+}
+
+// Symbols is the result of textDocument/documentSymbol, which can be a tree of DocumentSymbols or a list
+// of SymbolInformation. Both are read as a list in the order given, parents before their children.
+// DocumentSymbols don't say which document they're in so their Locations have no URI.
+type Symbols []Symbol
+
+// UnmarshalJSON reads either form of Symbols.
+func (symbols *Symbols) UnmarshalJSON(data []byte) error {
+	type symbol struct {
+		Name           string    `json:"name"`
+		Kind           int       `json:"kind"`
+		ContainerName  string    `json:"containerName"`
+		Location       *Location `json:"location"`
+		SelectionRange Range     `json:"selectionRange"`
+		Children       []symbol  `json:"children"`
+	}
+	list := []symbol{}
+	if string(data) != "null" {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+	}
+
+	*symbols = Symbols{}
+	var add func(list []symbol, container string)
+	add = func(list []symbol, container string) {
+		for _, s := range list {
+			one := Symbol{Name: s.Name, Kind: s.Kind, Container: s.ContainerName}
+			if s.Location != nil {
+				one.Location = *s.Location
+			} else {
+				one.Container, one.Location.Range = container, s.SelectionRange
+			}
+			*symbols = append(*symbols, one)
+			add(s.Children, s.Name)
+		}
+	}
+	add(list, "")
+	return nil
+}
+
+// symbolKinds are the names of the kinds of Symbols, starting from 1.
+var symbolKinds = []string{"file", "module", "namespace", "package", "class", "method", "property", "field",
+	"constructor", "enum", "interface", "function", "variable", "constant", "string", "number", "boolean",
+	"array", "object", "key", "null", "enum member", "struct", "event", "operator", "type parameter"}
+
+// SymbolKindName returns the name of a kind of Symbol, ie "function".
+func SymbolKindName(kind int) string {
+	if kind < 1 || kind > len(symbolKinds) {
+		return "symbol"
+	}
+	return symbolKinds[kind-1]
+}
+
+// ShowMessageParams is a message from the server for the user, Type is one of the severities.
+type ShowMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// InitializeParams start a session with a server.
+type InitializeParams struct {
+	ProcessID        int                `json:"processId"`
+	ClientInfo       ClientInfo         `json:"clientInfo"`
+	RootURI          string             `json:"rootUri"`
+	WorkspaceFolders []WorkspaceFolder  `json:"workspaceFolders"`
+	Capabilities     ClientCapabilities `json:"capabilities"`
+}
+
+// ClientInfo names the editor.
+type ClientInfo struct {
+	Name string `json:"name"`
+}
+
+// WorkspaceFolder is the root of a project.
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// ClientCapabilities are what the client supports, left as JSON as there are so many of them.
+type ClientCapabilities map[string]interface{}
+
+// InitializeResult are what the server supports.
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// ServerCapabilities are the requests a server supports.
+type ServerCapabilities struct {
+	TextDocumentSync           TextDocumentSync `json:"textDocumentSync"`
+	HoverProvider              Provider         `json:"hoverProvider"`
+	DefinitionProvider         Provider         `json:"definitionProvider"`
+	ReferencesProvider         Provider         `json:"referencesProvider"`
+	RenameProvider             Provider         `json:"renameProvider"`
+	CodeActionProvider         Provider         `json:"codeActionProvider"`
+	DocumentFormattingProvider Provider         `json:"documentFormattingProvider"`
+	SignatureHelpProvider      Provider         `json:"signatureHelpProvider"`
+	CompletionProvider         Provider         `json:"completionProvider"`
+	DocumentSymbolProvider     Provider         `json:"documentSymbolProvider"`
+}
+
+// Provider is a capability servers give as true or as an object of options.
+type Provider bool
+
+// UnmarshalJSON reads a Provider, any object is true.
+func (provider *Provider) UnmarshalJSON(data []byte) error {
+	*provider = Provider(len(data) > 0 && (data[0] == '{' || string(data) == "true"))
+	return nil
+}
+
+// TextDocumentSync says how to keep a server's copy of the documents up to date,
+// servers send the kind of Change on its own or an object with it.
+type TextDocumentSync struct {
+	Change int  `json:"change"`
+	Save   bool `json:"save"`
+}
+
+// UnmarshalJSON reads either form of TextDocumentSync.
+func (sync *TextDocumentSync) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '{' {
+		*sync = TextDocumentSync{}
+		return json.Unmarshal(data, &sync.Change)
+	}
+	var options struct {
+		Change int             `json:"change"`
+		Save   json.RawMessage `json:"save"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	*sync = TextDocumentSync{Change: options.Change, Save: len(options.Save) > 0 && string(options.Save) != "false"}
+	return nil
+}
+
+// FileURI returns the URI of a file, relative paths are made absolute.
+func FileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// URIPath returns the path of a file URI.
+func URIPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", errors.New("Not a file: " + uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
@@ -0,0 +1,1099 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcbishop/jkl/lsp"
+)
+
+// LanguageServerConfig says how to start the language server of a filetype, see Editor.SetLanguageServer.
+// RootMarkers are files such as "go.mod" that are in the root directory of a project, a file belongs
+// to the nearest one above it. Files outside a project share a server with their directory.
+type LanguageServerConfig struct {
+	Command     []string
+	RootMarkers []string
+}
+
+// DefaultLanguageServers are the language servers used for filetypes when they are installed.
+var DefaultLanguageServers = map[string]LanguageServerConfig{
+	"go":         {Command: []string{"gopls"}, RootMarkers: []string{"go.work", "go.mod", ".git"}},
+	"rust":       {Command: []string{"rust-analyzer"}, RootMarkers: []string{"Cargo.toml", ".git"}},
+	"python":     {Command: []string{"pylsp"}, RootMarkers: []string{"pyproject.toml", "setup.py", ".git"}},
+	"c":          {Command: []string{"clangd"}, RootMarkers: []string{"compile_commands.json", ".git"}},
+	"cpp":        {Command: []string{"clangd"}, RootMarkers: []string{"compile_commands.json", ".git"}},
+	"javascript": {Command: []string{"typescript-language-server", "--stdio"}, RootMarkers: []string{"package.json", ".git"}},
+}
+
+// languageServerStopTimeout is how long a language server gets to exit when jkl does.
+const languageServerStopTimeout = time.Second
+
+// UseLanguageServers sets up the DefaultLanguageServers whose programs are installed.
+func UseLanguageServers() func(*App) error {
+	return func(a *App) error {
+		for filetype, config := range DefaultLanguageServers {
+			if _, err := exec.LookPath(config.Command[0]); err == nil {
+				a.Editor().SetLanguageServer(filetype, config)
+			}
+		}
+		return nil
+	}
+}
+
+// languageClient is the editor's side of its language servers. connect starts a server,
+// it's replaced by tests.
+type languageClient struct {
+	configs map[string]LanguageServerConfig
+	servers []*LanguageServer
+	connect func(config LanguageServerConfig, root string) (io.ReadWriteCloser, error)
+	hover   *Float
+	actions []lsp.CodeAction
+	server  *LanguageServer
+}
+
+// LanguageServer is a language server for the files of a filetype in a project. Its messages are
+// handled on the editor's main loop, see Editor.Post.
+type LanguageServer struct {
+	editor       *Editor
+	filetype     string
+	root         string
+	conn         *lsp.Conn
+	stderr       *lastLine
+	ready        bool
+	stopped      bool
+	capabilities lsp.ServerCapabilities
+	documents    map[*Buffer]*lspDocument
+	waiting      []func()
+}
+
+// lspDocument is a buffer opened in a language server, changes are the edits it hasn't been sent.
+type lspDocument struct {
+	uri         string
+	version     int
+	opened      bool
+	changes     []lsp.TextDocumentContentChangeEvent
+	diagnostics []lsp.Diagnostic
+}
+
+// SetLanguageServer starts a language server for the open buffers of a filetype and those opened later.
+func (editor *Editor) SetLanguageServer(filetype string, config LanguageServerConfig) {
+	client := &editor.languages
+	if client.configs == nil {
+		client.configs = map[string]LanguageServerConfig{}
+		editor.subscribeLanguageServers()
+	}
+	client.configs[filetype] = config
+	for _, buffer := range editor.buffers {
+		editor.attachLanguageServer(buffer)
+	}
+}
+
+// LanguageServers returns the language servers that are running.
+func (editor *Editor) LanguageServers() []*LanguageServer {
+	return editor.languages.servers
+}
+
+// subscribeLanguageServers keeps the language servers up to date with the buffers.
+func (editor *Editor) subscribeLanguageServers() {
+	bus := editor.Events()
+	attach := func(event EditorEvent) { editor.attachLanguageServer(event.Buffer) }
+	bus.Subscribe(BufRead, "", attach)
+	bus.Subscribe(BufEnter, "", attach)
+	bus.Subscribe(BufWritePost, "", func(event EditorEvent) {
+		if server := editor.attachLanguageServer(event.Buffer); server != nil {
+			server.save(event.Buffer)
+		}
+	})
+	bus.Subscribe(BufDelete, "", func(event EditorEvent) {
+		if server := editor.languageServerOf(event.Buffer); server != nil {
+			server.close(event.Buffer)
+		}
+	})
+	bus.Subscribe(TextChanged, "", func(event EditorEvent) {
+		if server := editor.languageServerOf(event.Buffer); server != nil {
+			server.flush(event.Buffer)
+		}
+	})
+	for _, name := range []EventName{CursorMoved, BufLeave, ModeChanged} {
+		bus.Subscribe(name, "", func(EditorEvent) { editor.closeHover() })
+	}
+}
+
+// languageServerOf returns the language server a buffer is open in, nil if there isn't one.
+func (editor *Editor) languageServerOf(buffer *Buffer) *LanguageServer {
+	for _, server := range editor.languages.servers {
+		if _, ok := server.documents[buffer]; ok {
+			return server
+		}
+	}
+	return nil
+}
+
+// attachLanguageServer opens a buffer in the language server of its filetype and project,
+// starting the server if it isn't running.
+func (editor *Editor) attachLanguageServer(buffer *Buffer) *LanguageServer {
+	if buffer == nil || buffer.Filename() == "" || buffer.Terminal() != nil {
+		return nil
+	}
+	if server := editor.languageServerOf(buffer); server != nil {
+		return server
+	}
+	config, ok := editor.languages.configs[buffer.Filetype()]
+	if !ok || len(config.Command) == 0 {
+		return nil
+	}
+
+	root := editor.projectRoot(buffer.Filename(), config.RootMarkers)
+	var server *LanguageServer
+	for _, running := range editor.languages.servers {
+		if running.filetype == buffer.Filetype() && running.root == root {
+			server = running
+		}
+	}
+	if server == nil {
+		var err error
+		if server, err = editor.startLanguageServer(buffer.Filetype(), root, config); err != nil {
+			editor.EchoError(fmt.Errorf("Language server %s: %s", config.Command[0], err))
+			return nil
+		}
+	}
+	server.open(buffer)
+	return server
+}
+
+// projectRoot returns the nearest directory above a file with one of the markers in it,
+// or the file's directory if there isn't one.
+func (editor *Editor) projectRoot(filename string, markers []string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	dir := filepath.Dir(filename)
+	for parent := dir; ; {
+		for _, marker := range markers {
+			if _, err := editor.fs.Stat(filepath.Join(parent, marker)); err == nil {
+				return parent
+			}
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			return dir
+		}
+		parent = next
+	}
+}
+
+// startLanguageServer starts a language server and initializes it, buffers can be opened in it straight away.
+func (editor *Editor) startLanguageServer(filetype, root string, config LanguageServerConfig) (*LanguageServer, error) {
+	server := &LanguageServer{
+		editor:    editor,
+		filetype:  filetype,
+		root:      root,
+		stderr:    &lastLine{},
+		documents: map[*Buffer]*lspDocument{},
+	}
+
+	connect := editor.languages.connect
+	if connect == nil {
+		connect = server.startProcess
+	}
+	stream, err := connect(config, root)
+	if err != nil {
+		return nil, err
+	}
+	server.conn = lsp.NewConn(stream, server.handle)
+	go func() {
+		err := server.conn.Run()
+		editor.Post(func() { server.exited(err) })
+	}()
+
+	params := lsp.InitializeParams{
+		ProcessID:        os.Getpid(),
+		ClientInfo:       lsp.ClientInfo{Name: "jkl"},
+		RootURI:          lsp.FileURI(root),
+		WorkspaceFolders: []lsp.WorkspaceFolder{{URI: lsp.FileURI(root), Name: filepath.Base(root)}},
+		Capabilities:     clientCapabilities,
+	}
+	server.conn.Call("initialize", params, server.onMainLoop(server.initialized))
+	editor.languages.servers = append(editor.languages.servers, server)
+	return server, nil
+}
+
+// clientCapabilities are what jkl tells language servers it supports.
+var clientCapabilities = lsp.ClientCapabilities{
+	"general": map[string]interface{}{"positionEncodings": []string{"utf-16"}},
+	"workspace": map[string]interface{}{
+		"applyEdit":        true,
+		"workspaceEdit":    map[string]interface{}{"documentChanges": true},
+		"configuration":    true,
+		"workspaceFolders": true,
+	},
+	"textDocument": map[string]interface{}{
+		"synchronization":    map[string]interface{}{"didSave": true},
+		"publishDiagnostics": map[string]interface{}{},
+		"hover":              map[string]interface{}{"contentFormat": []string{"plaintext", "markdown"}},
+		"definition":         map[string]interface{}{"linkSupport": true},
+		"references":         map[string]interface{}{},
+		"rename":             map[string]interface{}{},
+		"formatting":         map[string]interface{}{},
+		"documentSymbol":     map[string]interface{}{"hierarchicalDocumentSymbolSupport": true},
+		"completion": map[string]interface{}{
+			"completionItem": map[string]interface{}{"snippetSupport": true},
+		},
+		"signatureHelp": map[string]interface{}{
+			"signatureInformation": map[string]interface{}{"documentationFormat": []string{"plaintext", "markdown"}},
+		},
+		"codeAction": map[string]interface{}{
+			"codeActionLiteralSupport": map[string]interface{}{
+				"codeActionKind": map[string]interface{}{
+					"valueSet": []string{"", "quickfix", "refactor", "refactor.extract", "refactor.inline",
+						"refactor.rewrite", "source", "source.organizeImports"},
+				},
+			},
+		},
+	},
+}
+
+// languageServerProcess is a language server program talking over its stdin and stdout.
+type languageServerProcess struct {
+	io.ReadCloser
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// Close closes the server's stdin and kills it if it doesn't exit soon after.
+func (process *languageServerProcess) Close() error {
+	process.WriteCloser.Close()
+	done := make(chan error, 1)
+	go func() { done <- process.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(languageServerStopTimeout):
+		process.cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// startProcess runs the program of a language server in the root of its project.
+func (server *LanguageServer) startProcess(config LanguageServerConfig, root string) (io.ReadWriteCloser, error) {
+	cmd := exec.Command(config.Command[0], config.Command[1:]...)
+	cmd.Dir = root
+	cmd.Stderr = server.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &languageServerProcess{ReadCloser: stdout, WriteCloser: stdin, cmd: cmd}, nil
+}
+
+// Name returns the filetype and project the server is for.
+func (server *LanguageServer) Name() string {
+	return server.filetype + " " + server.root
+}
+
+// Ready returns true once the server has been initialized.
+func (server *LanguageServer) Ready() bool {
+	return server.ready
+}
+
+// onMainLoop returns a reply that runs on the editor's main loop.
+func (server *LanguageServer) onMainLoop(reply func(result json.RawMessage, err error)) func(json.RawMessage, error) {
+	return func(result json.RawMessage, err error) {
+		server.editor.Post(func() { reply(result, err) })
+	}
+}
+
+// initialized finishes starting the server once it has answered the initialize request.
+func (server *LanguageServer) initialized(data json.RawMessage, err error) {
+	if server.stopped {
+		return
+	}
+	result := lsp.InitializeResult{}
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil {
+		server.editor.EchoError(fmt.Errorf("Language server %s: %s", server.Name(), err))
+		server.Stop(false)
+		return
+	}
+
+	server.capabilities = result.Capabilities
+	server.ready = true
+	server.conn.Notify("initialized", struct{}{})
+	for buffer := range server.documents {
+		server.open(buffer)
+	}
+	for _, f := range server.waiting {
+		f()
+	}
+	server.waiting = nil
+}
+
+// exited forgets a server once its connection has closed.
+func (server *LanguageServer) exited(err error) {
+	if server.stopped {
+		return
+	}
+	server.Stop(false)
+	message := "Exited"
+	if err != nil {
+		message = err.Error()
+	}
+	if line := server.stderr.String(); line != "" {
+		message += ": " + line
+	}
+	server.editor.EchoError(fmt.Errorf("Language server %s: %s", server.Name(), message))
+}
+
+// Stop shuts the server down and closes its buffers. With wait it waits a little for the server to
+// exit, ie when jkl is exiting, the replies then don't need the main loop.
+func (server *LanguageServer) Stop(wait bool) {
+	if server.stopped {
+		return
+	}
+	server.stopped = true
+	client := &server.editor.languages
+	for i, running := range client.servers {
+		if running == server {
+			client.servers = append(client.servers[:i:i], client.servers[i+1:]...)
+			break
+		}
+	}
+	if client.server == server {
+		client.server, client.actions = nil, nil
+	}
+	for buffer := range server.documents {
+		buffer.watchEdits(server, nil)
+		buffer.ClearSigns("diagnostics")
+	}
+	server.documents = map[*Buffer]*lspDocument{}
+
+	conn := server.conn
+	conn.Call("shutdown", nil, func(json.RawMessage, error) {
+		conn.Notify("exit", nil)
+		conn.Close()
+	})
+	if wait {
+		select {
+		case <-conn.Done():
+		case <-time.After(languageServerStopTimeout):
+			conn.Close()
+		}
+	}
+}
+
+// StopLanguageServers stops every language server, waiting a little for them to exit.
+func (editor *Editor) StopLanguageServers() {
+	for _, server := range append([]*LanguageServer{}, editor.languages.servers...) {
+		server.Stop(true)
+	}
+}
+
+// whenReady runs f once the server is initialized.
+func (server *LanguageServer) whenReady(f func()) {
+	if server.ready {
+		f()
+		return
+	}
+	server.waiting = append(server.waiting, f)
+}
+
+// languageID returns the languageId of a filetype.
+func languageID(filetype string) string {
+	if filetype == "sh" {
+		return "shellscript"
+	}
+	return filetype
+}
+
+// open sends a buffer to the server once it's ready and keeps it up to date from then on.
+func (server *LanguageServer) open(buffer *Buffer) {
+	document, ok := server.documents[buffer]
+	if !ok {
+		document = &lspDocument{uri: lsp.FileURI(buffer.Filename())}
+		server.documents[buffer] = document
+	}
+	if !server.ready || document.opened {
+		return
+	}
+
+	document.opened = true
+	document.version = 1
+	server.conn.Notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{TextDocument: lsp.TextDocumentItem{
+		URI:        document.uri,
+		LanguageID: languageID(buffer.Filetype()),
+		Version:    document.version,
+		Text:       string(buffer.data),
+	}})
+	buffer.watchEdits(server, func(start, end int, text string) {
+		document.changes = append(document.changes, lsp.TextDocumentContentChangeEvent{
+			Range: &lsp.Range{Start: lsp.PositionOf(buffer.data, start), End: lsp.PositionOf(buffer.data, end)},
+			Text:  text,
+		})
+	})
+}
+
+// flush sends the server the changes to a buffer it hasn't seen.
+func (server *LanguageServer) flush(buffer *Buffer) {
+	document, ok := server.documents[buffer]
+	if !ok || len(document.changes) == 0 {
+		return
+	}
+	changes := document.changes
+	document.changes = nil
+
+	switch server.capabilities.TextDocumentSync.Change {
+	case lsp.SyncNone:
+		return
+	case lsp.SyncFull:
+		changes = []lsp.TextDocumentContentChangeEvent{{Text: string(buffer.data)}}
+	}
+	document.version++
+	server.conn.Notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: document.uri, Version: document.version},
+		ContentChanges: changes,
+	})
+}
+
+// flushAll sends the server the changes to all its buffers, before a request so it sees what the user does.
+func (server *LanguageServer) flushAll() {
+	for buffer := range server.documents {
+		server.flush(buffer)
+	}
+}
+
+// save tells the server a buffer was written.
+func (server *LanguageServer) save(buffer *Buffer) {
+	document, ok := server.documents[buffer]
+	if !ok || !document.opened {
+		return
+	}
+	server.flush(buffer)
+	server.conn.Notify("textDocument/didSave", lsp.DidSaveTextDocumentParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: document.uri},
+	})
+}
+
+// close tells the server a buffer is no longer being edited.
+func (server *LanguageServer) close(buffer *Buffer) {
+	document, ok := server.documents[buffer]
+	if !ok {
+		return
+	}
+	delete(server.documents, buffer)
+	buffer.watchEdits(server, nil)
+	buffer.ClearSigns("diagnostics")
+	if document.opened {
+		server.conn.Notify("textDocument/didClose", lsp.DidCloseTextDocumentParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: document.uri},
+		})
+	}
+}
+
+// handle answers the requests and notifications of the server on the main loop.
+func (server *LanguageServer) handle(method string, params json.RawMessage, reply func(interface{}, error)) {
+	server.editor.Post(func() {
+		if server.stopped {
+			reply(nil, lsp.ErrClosed)
+			return
+		}
+		reply(server.request(method, params))
+	})
+}
+
+// request runs a request or notification from the server and returns its result.
+func (server *LanguageServer) request(method string, params json.RawMessage) (interface{}, error) {
+	editor := server.editor
+	switch method {
+	case "textDocument/publishDiagnostics":
+		diagnostics := lsp.PublishDiagnosticsParams{}
+		if err := json.Unmarshal(params, &diagnostics); err != nil {
+			return nil, &lsp.Error{Code: lsp.InvalidParams, Message: err.Error()}
+		}
+		server.showDiagnostics(diagnostics)
+		return nil, nil
+
+	case "window/showMessage":
+		message := lsp.ShowMessageParams{}
+		json.Unmarshal(params, &message)
+		switch message.Type {
+		case lsp.SeverityError:
+			editor.EchoError(errors.New(message.Message))
+		case lsp.SeverityWarning:
+			editor.EchoWarning(message.Message)
+		default:
+			editor.Echo(message.Message)
+		}
+		return nil, nil
+
+	case "workspace/applyEdit":
+		edit := lsp.ApplyWorkspaceEditParams{}
+		if err := json.Unmarshal(params, &edit); err != nil {
+			return nil, &lsp.Error{Code: lsp.InvalidParams, Message: err.Error()}
+		}
+		if err := editor.applyWorkspaceEdit(edit.Edit); err != nil {
+			return lsp.ApplyWorkspaceEditResult{FailureReason: err.Error()}, nil
+		}
+		return lsp.ApplyWorkspaceEditResult{Applied: true}, nil
+
+	case "workspace/configuration":
+		var configuration struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &configuration)
+		return make([]interface{}, len(configuration.Items)), nil
+
+	case "workspace/workspaceFolders":
+		return []lsp.WorkspaceFolder{{URI: lsp.FileURI(server.root), Name: filepath.Base(server.root)}}, nil
+
+	case "window/logMessage", "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability":
+		return nil, nil
+	}
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil
+	}
+	return nil, &lsp.Error{Code: lsp.MethodNotFound, Message: "Unknown method: " + method}
+}
+
+// showDiagnostics puts signs on the lines of a buffer that have diagnostics.
+func (server *LanguageServer) showDiagnostics(params lsp.PublishDiagnosticsParams) {
+	for buffer, document := range server.documents {
+		if document.uri != params.URI {
+			continue
+		}
+		document.diagnostics = params.Diagnostics
+		buffer.ClearSigns("diagnostics")
+		for _, diagnostic := range params.Diagnostics {
+			text, style := "E", StyleSignError
+			switch diagnostic.Severity {
+			case lsp.SeverityWarning:
+				text, style = "W", StyleSignWarning
+			case lsp.SeverityInformation:
+				text, style = "I", StyleSignInfo
+			case lsp.SeverityHint:
+				text, style = "H", StyleSignInfo
+			}
+			buffer.PlaceSign(Sign{
+				Group:    "diagnostics",
+				Line:     diagnostic.Range.Start.Line + 1,
+				Text:     text,
+				Style:    style,
+				Priority: lsp.SeverityHint + 1 - diagnostic.Severity,
+			})
+		}
+	}
+}
+
+// Diagnostics returns the diagnostics of a buffer from its language server.
+func (editor *Editor) Diagnostics(buffer *Buffer) []lsp.Diagnostic {
+	if server := editor.languageServerOf(buffer); server != nil {
+		return server.documents[buffer].diagnostics
+	}
+	return nil
+}
+
+// languageServerHere returns the language server of the current buffer with its changes sent,
+// once it has checked the server supports a request.
+func (editor *Editor) languageServerHere(supported func(lsp.ServerCapabilities) lsp.Provider, what string) (*LanguageServer, error) {
+	server := editor.languageServerOf(editor.CurrentPane().Buffer())
+	switch {
+	case server == nil:
+		return nil, errors.New("No language server for this buffer")
+	case !server.ready:
+		return nil, errors.New("Language server is starting")
+	case !bool(supported(server.capabilities)):
+		return nil, fmt.Errorf("Language server can't %s", what)
+	}
+	server.flushAll()
+	return server, nil
+}
+
+// cursorPosition returns where the cursor is in the current buffer as the language server sees it.
+func (editor *Editor) cursorPosition() lsp.TextDocumentPositionParams {
+	buffer := editor.CurrentPane().Buffer()
+	x, line := editor.CurrentPane().Cursor().Position()
+	text, _ := buffer.GetLine(line)
+	return lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: lsp.FileURI(buffer.Filename())},
+		Position:     lsp.Position{Line: line - 1, Character: lsp.Character(text, x)},
+	}
+}
+
+// callLanguageServer sends a request and reads its result on the main loop, errors are shown.
+func (server *LanguageServer) callLanguageServer(method string, params interface{}, result interface{}, f func()) {
+	server.conn.Call(method, params, server.onMainLoop(func(data json.RawMessage, err error) {
+		if err == nil {
+			err = json.Unmarshal(data, result)
+		}
+		if err != nil {
+			server.editor.EchoError(err)
+			return
+		}
+		f()
+	}))
+}
+
+// Hover shows what the language server knows about the symbol under the cursor, or scrolls what's shown.
+func (editor *Editor) Hover() error {
+	if hover := editor.languages.hover; hover != nil {
+		editor.FocusFloat(hover)
+		return nil
+	}
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.HoverProvider }, "hover")
+	if err != nil {
+		return err
+	}
+	var hover *lsp.Hover
+	server.callLanguageServer("textDocument/hover", editor.cursorPosition(), &hover, func() {
+		if hover == nil || hover.Contents == "" {
+			editor.Echo("No information")
+			return
+		}
+		editor.showHover(strings.Split(string(hover.Contents), "\n"))
+	})
+	return nil
+}
+
+// completeFromLanguageServer is the CompletionSource of the buffer's language server.
+func (editor *Editor) completeFromLanguageServer(request CompletionRequest, reply func([]CompletionItem)) {
+	server := editor.languageServerOf(request.Buffer)
+	if server == nil || !server.ready || !bool(server.capabilities.CompletionProvider) {
+		reply(nil)
+		return
+	}
+	server.flushAll()
+	params := lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: server.documents[request.Buffer].uri},
+		Position:     lsp.Position{Line: request.Line - 1, Character: lsp.Character(request.Before, request.Column)},
+	}
+	server.conn.Call("textDocument/completion", params, func(data json.RawMessage, err error) {
+		list := lsp.CompletionList{}
+		if err != nil || json.Unmarshal(data, &list) != nil {
+			reply(nil)
+			return
+		}
+		items := []CompletionItem{}
+		for _, completion := range list.Items {
+			item := CompletionItem{
+				Text:    completion.Label,
+				Label:   completion.Label,
+				Detail:  completion.Detail,
+				Filter:  completion.FilterText,
+				Start:   request.WordStart,
+				Snippet: completion.InsertTextFormat == lsp.InsertTextFormatSnippet,
+			}
+			if completion.InsertText != "" {
+				item.Text = completion.InsertText
+			}
+			if edit := completion.TextEdit; edit != nil {
+				item.Text = edit.NewText
+				if edit.Range.Start.Line == params.Position.Line {
+					item.Start = lsp.Column(request.Before, edit.Range.Start.Character)
+				}
+			}
+			items = append(items, item)
+		}
+		reply(items)
+	})
+}
+
+// SignatureHelp shows the signature of the function being called at the cursor.
+func (editor *Editor) SignatureHelp() error {
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.SignatureHelpProvider }, "show signatures")
+	if err != nil {
+		return err
+	}
+	var help *lsp.SignatureHelp
+	server.callLanguageServer("textDocument/signatureHelp", editor.cursorPosition(), &help, func() {
+		if help == nil || len(help.Signatures) == 0 {
+			editor.Echo("No signature")
+			return
+		}
+		active := help.ActiveSignature
+		if active < 0 || active >= len(help.Signatures) {
+			active = 0
+		}
+		signature := help.Signatures[active]
+		lines := []string{signature.Label}
+		if signature.Documentation != "" {
+			lines = append(lines, strings.Split(string(signature.Documentation), "\n")...)
+		}
+		editor.showHover(lines)
+	})
+	return nil
+}
+
+// Maximum size of the float showing hover information.
+const (
+	hoverMaxWidth  = 80
+	hoverMaxHeight = 15
+)
+
+// showHover shows lines in a float under the cursor, or as a message if the cursor isn't drawn yet.
+func (editor *Editor) showHover(lines []string) {
+	editor.closeHover()
+	if _, _, ok := editor.CurrentPane().CursorScreenPosition(editor.Settings()); !ok {
+		editor.Echo(strings.Join(lines, "\n"))
+		return
+	}
+
+	width := 1
+	for _, line := range lines {
+		if length := len([]rune(line)); length > width {
+			width = length
+		}
+	}
+	if width > hoverMaxWidth {
+		width = hoverMaxWidth
+	}
+	height := len(lines)
+	if height > hoverMaxHeight {
+		height = hoverMaxHeight
+	}
+	hover := &Float{Anchor: AnchorCursor, X: -1, Y: 1, Width: width, Height: height, Lines: lines, Border: true, Z: floatLayerHover}
+	hover.Closed = func() {
+		if editor.languages.hover == hover {
+			editor.languages.hover = nil
+		}
+	}
+	editor.languages.hover = hover
+	editor.OpenFloat(hover)
+}
+
+// closeHover closes the float showing hover information.
+func (editor *Editor) closeHover() {
+	if editor.languages.hover != nil {
+		editor.CloseFloat(editor.languages.hover)
+	}
+}
+
+// GoToDefinition jumps to where the symbol under the cursor is defined.
+func (editor *Editor) GoToDefinition() error {
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.DefinitionProvider }, "find definitions")
+	if err != nil {
+		return err
+	}
+	locations := lsp.Locations{}
+	server.callLanguageServer("textDocument/definition", editor.cursorPosition(), &locations, func() {
+		if len(locations) == 0 {
+			editor.EchoError(errors.New("No definition found"))
+			return
+		}
+		if err := editor.jumpToLocation(locations[0]); err != nil {
+			editor.EchoError(err)
+		}
+	})
+	return nil
+}
+
+// FindReferences lists where the symbol under the cursor is used and jumps to the first place.
+func (editor *Editor) FindReferences() error {
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.ReferencesProvider }, "find references")
+	if err != nil {
+		return err
+	}
+	params := lsp.ReferenceParams{TextDocumentPositionParams: editor.cursorPosition()}
+	params.Context.IncludeDeclaration = true
+	locations := lsp.Locations{}
+	server.callLanguageServer("textDocument/references", params, &locations, func() {
+		if len(locations) == 0 {
+			editor.EchoError(errors.New("No references found"))
+			return
+		}
+		lines := []string{}
+		for _, location := range locations {
+			lines = append(lines, editor.describeLocation(location))
+		}
+		if err := editor.jumpToLocation(locations[0]); err != nil {
+			editor.EchoError(err)
+			return
+		}
+		editor.Echo(strings.Join(lines, "\n"))
+	})
+	return nil
+}
+
+// Rename renames the symbol under the cursor everywhere it's used.
+func (editor *Editor) Rename(name string) error {
+	if name == "" {
+		return errors.New("Argument required")
+	}
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.RenameProvider }, "rename")
+	if err != nil {
+		return err
+	}
+	params := lsp.RenameParams{TextDocumentPositionParams: editor.cursorPosition(), NewName: name}
+	var edit *lsp.WorkspaceEdit
+	server.callLanguageServer("textDocument/rename", params, &edit, func() {
+		if edit == nil {
+			editor.EchoError(errors.New("Nothing to rename"))
+			return
+		}
+		if err := editor.applyWorkspaceEdit(*edit); err != nil {
+			editor.EchoError(err)
+		}
+	})
+	return nil
+}
+
+// FormatBuffer formats the current buffer with its language server.
+func (editor *Editor) FormatBuffer() error {
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.DocumentFormattingProvider }, "format")
+	if err != nil {
+		return err
+	}
+	buffer := editor.CurrentPane().Buffer()
+	params := lsp.DocumentFormattingParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: lsp.FileURI(buffer.Filename())},
+		Options:      lsp.FormattingOptions{TabSize: editor.Settings().ShiftWidth},
+	}
+	edits := []lsp.TextEdit{}
+	server.callLanguageServer("textDocument/formatting", params, &edits, func() {
+		applyTextEdits(buffer, edits)
+	})
+	return nil
+}
+
+// CodeActions lists the code actions for the cursor's line, see ApplyCodeAction.
+func (editor *Editor) CodeActions() error {
+	server, err := editor.languageServerHere(func(c lsp.ServerCapabilities) lsp.Provider { return c.CodeActionProvider }, "suggest code actions")
+	if err != nil {
+		return err
+	}
+	buffer := editor.CurrentPane().Buffer()
+	_, line := editor.CurrentPane().Cursor().Position()
+	params := lsp.CodeActionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: lsp.FileURI(buffer.Filename())},
+		Range:        lsp.Range{Start: lsp.Position{Line: line - 1}, End: lsp.Position{Line: line}},
+		Context:      lsp.CodeActionContext{Diagnostics: []lsp.Diagnostic{}},
+	}
+	for _, diagnostic := range server.documents[buffer].diagnostics {
+		if diagnostic.Range.Start.Line <= line-1 && diagnostic.Range.End.Line >= line-1 {
+			params.Context.Diagnostics = append(params.Context.Diagnostics, diagnostic)
+		}
+	}
+
+	actions := []lsp.CodeAction{}
+	server.callLanguageServer("textDocument/codeAction", params, &actions, func() {
+		editor.languages.actions, editor.languages.server = actions, server
+		if len(actions) == 0 {
+			editor.Echo("No code actions")
+			return
+		}
+		lines := []string{}
+		for i, action := range actions {
+			lines = append(lines, fmt.Sprintf("%d: %s", i+1, action.Title))
+		}
+		editor.Echo(strings.Join(lines, "\n"))
+	})
+	return nil
+}
+
+// ApplyCodeAction applies one of the code actions listed by CodeActions, the first is 1.
+func (editor *Editor) ApplyCodeAction(n int) error {
+	actions, server := editor.languages.actions, editor.languages.server
+	if n < 1 || n > len(actions) {
+		return fmt.Errorf("No code action %d", n)
+	}
+	action := actions[n-1]
+	if action.Edit != nil {
+		if err := editor.applyWorkspaceEdit(*action.Edit); err != nil {
+			return err
+		}
+	}
+	if action.Command != nil {
+		params := lsp.ExecuteCommandParams{Command: action.Command.Command, Arguments: action.Command.Arguments}
+		var result json.RawMessage
+		server.callLanguageServer("workspace/executeCommand", params, &result, func() {})
+	}
+	return nil
+}
+
+// describeLocation returns a location as "file:line:column: text".
+func (editor *Editor) describeLocation(location lsp.Location) string {
+	path, err := lsp.URIPath(location.URI)
+	if err != nil {
+		return location.URI
+	}
+	line := location.Range.Start.Line + 1
+	text := ""
+	if buffer := editor.bufferOfFile(path); buffer != nil {
+		text, _ = buffer.GetLine(line)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	column := lsp.Column(text, location.Range.Start.Character) + 1
+	return fmt.Sprintf("%s:%d:%d: %s", path, line, column, strings.TrimSpace(text))
+}
+
+// jumpToLocation shows the file of a location in the current pane with the cursor at its start.
+func (editor *Editor) jumpToLocation(location lsp.Location) error {
+	path, err := lsp.URIPath(location.URI)
+	if err != nil {
+		return err
+	}
+	buffer := editor.loadFile(path)
+	editor.SwitchToBuffer(buffer)
+	line := location.Range.Start.Line + 1
+	text, _ := buffer.GetLine(line)
+	editor.CurrentPane().Cursor().Move(lsp.Column(text, location.Range.Start.Character), line)
+	return nil
+}
+
+// bufferOfFile returns the buffer editing a file, nil if it isn't open.
+func (editor *Editor) bufferOfFile(filename string) *Buffer {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	for _, buffer := range editor.buffers {
+		if buffer.Filename() == "" {
+			continue
+		}
+		if other, err := filepath.Abs(buffer.Filename()); err == nil && other == abs {
+			return buffer
+		}
+	}
+	return nil
+}
+
+// loadFile returns the buffer editing a file, reading it into a new buffer if it isn't open.
+func (editor *Editor) loadFile(filename string) *Buffer {
+	if buffer := editor.bufferOfFile(filename); buffer != nil {
+		return buffer
+	}
+	newBuffer := editor.openFile(filename)
+	buffer := editor.AddBuffer(&newBuffer)
+	editor.events.Publish(EditorEvent{Name: BufRead, Buffer: buffer})
+	return buffer
+}
+
+// applyWorkspaceEdit changes the files of a WorkspaceEdit, reading those that aren't open.
+func (editor *Editor) applyWorkspaceEdit(edit lsp.WorkspaceEdit) error {
+	for uri, edits := range edit.Edits() {
+		path, err := lsp.URIPath(uri)
+		if err != nil {
+			return err
+		}
+		applyTextEdits(editor.loadFile(path), edits)
+	}
+	return nil
+}
+
+// applyTextEdits changes a buffer as one change that is undone together.
+// Edits starting at the same place are inserted in the order they're given.
+func applyTextEdits(buffer *Buffer, edits []lsp.TextEdit) {
+	type change struct {
+		start, end, order int
+		text              string
+	}
+	changes := []change{}
+	for i, edit := range edits {
+		start, end := lsp.Offset(buffer.data, edit.Range.Start), lsp.Offset(buffer.data, edit.Range.End)
+		if end < start {
+			start, end = end, start
+		}
+		changes = append(changes, change{start, end, i, edit.NewText})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].start != changes[j].start {
+			return changes[i].start > changes[j].start
+		}
+		return changes[i].order > changes[j].order
+	})
+	for i, c := range changes {
+		buffer.change(c.start, c.end, c.text, i > 0)
+	}
+}
+
+// lspCommand runs the language server commands, ":lsp" on its own lists the servers.
+func lspCommand(editor *Editor, command Command) error {
+	fields := strings.Fields(command.Args)
+	if len(fields) == 0 {
+		lines := []string{}
+		for _, server := range editor.languages.servers {
+			status := "running"
+			if !server.ready {
+				status = "starting"
+			}
+			lines = append(lines, fmt.Sprintf("%s %s, %d buffers", server.Name(), status, len(server.documents)))
+		}
+		if len(lines) == 0 {
+			return errors.New("No language servers running")
+		}
+		editor.Echo(strings.Join(lines, "\n"))
+		return nil
+	}
+
+	switch fields[0] {
+	case "start":
+		if editor.attachLanguageServer(editor.CurrentPane().Buffer()) == nil {
+			return errors.New("No language server for this buffer")
+		}
+		return nil
+	case "stop":
+		server := editor.languageServerOf(editor.CurrentPane().Buffer())
+		if server == nil {
+			return errors.New("No language server for this buffer")
+		}
+		server.Stop(false)
+		return nil
+	case "hover":
+		return editor.Hover()
+	case "signature":
+		return editor.SignatureHelp()
+	case "definition":
+		return editor.GoToDefinition()
+	case "references":
+		return editor.FindReferences()
+	case "rename":
+		return editor.Rename(strings.Join(fields[1:], " "))
+	case "format":
+		return editor.FormatBuffer()
+	case "action":
+		if len(fields) == 1 {
+			return editor.CodeActions()
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("Invalid code action: %s", fields[1])
+		}
+		return editor.ApplyCodeAction(n)
+	case "diagnostics":
+		lines := []string{}
+		for _, diagnostic := range editor.Diagnostics(editor.CurrentPane().Buffer()) {
+			severity := map[int]string{lsp.SeverityWarning: "warning", lsp.SeverityInformation: "info", lsp.SeverityHint: "hint"}[diagnostic.Severity]
+			if severity == "" {
+				severity = "error"
+			}
+			lines = append(lines, fmt.Sprintf("%d:%d: %s: %s", diagnostic.Range.Start.Line+1, diagnostic.Range.Start.Character+1, severity, diagnostic.Message))
+		}
+		if len(lines) == 0 {
+			editor.Echo("No diagnostics")
+			return nil
+		}
+		editor.Echo(strings.Join(lines, "\n"))
+		return nil
+	}
+	return fmt.Errorf("Unknown language server command: %s", fields[0])
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dcbishop/jkl/lsp"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+// languagePipe is one end of an in-memory stream to a fake language server.
+type languagePipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (p languagePipe) Close() error {
+	p.Reader.(*io.PipeReader).Close()
+	return p.WriteCloser.Close()
+}
+
+// fakeLanguageServer keeps the documents it is sent up to date and answers requests about /src/main.go.
+type fakeLanguageServer struct {
+	conn      *lsp.Conn
+	mutex     sync.Mutex
+	root      string
+	documents map[string]string
+	methods   []string
+}
+
+// connect starts the fake server on the other end of an in-memory stream.
+func (server *fakeLanguageServer) connect(config LanguageServerConfig, root string) (io.ReadWriteCloser, error) {
+	server.root = root
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+	server.conn = lsp.NewConn(languagePipe{serverRead, serverWrite}, server.handle)
+	go server.conn.Run()
+	return languagePipe{clientRead, clientWrite}, nil
+}
+
+// received returns true if the server was sent a method.
+func (server *fakeLanguageServer) received(method string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, m := range server.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the server's copy of a document.
+func (server *fakeLanguageServer) text(uri string) string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.documents[uri]
+}
+
+func fakeRange(line, start, end int) lsp.Range {
+	return lsp.Range{Start: lsp.Position{Line: line, Character: start}, End: lsp.Position{Line: line, Character: end}}
+}
+
+func (server *fakeLanguageServer) handle(method string, params json.RawMessage, reply func(interface{}, error)) {
+	server.mutex.Lock()
+	server.methods = append(server.methods, method)
+	server.mutex.Unlock()
+	main := lsp.FileURI("/src/main.go")
+
+	switch method {
+	case "initialize":
+		reply(map[string]interface{}{"capabilities": map[string]interface{}{
+			"textDocumentSync": lsp.SyncIncremental, "hoverProvider": true, "definitionProvider": true,
+			"referencesProvider": true, "renameProvider": map[string]bool{"prepareProvider": false},
+			"codeActionProvider": true, "documentFormattingProvider": true, "signatureHelpProvider": map[string]interface{}{},
+			"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}}, "documentSymbolProvider": true,
+		}}, nil)
+	case "textDocument/didOpen":
+		open := lsp.DidOpenTextDocumentParams{}
+		json.Unmarshal(params, &open)
+		server.mutex.Lock()
+		server.documents[open.TextDocument.URI] = open.TextDocument.Text
+		server.mutex.Unlock()
+		server.conn.Notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{
+			URI:         open.TextDocument.URI,
+			Diagnostics: []lsp.Diagnostic{{Range: fakeRange(3, 1, 2), Severity: lsp.SeverityWarning, Message: "x declared and not used"}},
+		})
+	case "textDocument/didChange":
+		change := lsp.DidChangeTextDocumentParams{}
+		json.Unmarshal(params, &change)
+		server.mutex.Lock()
+		text := []byte(server.documents[change.TextDocument.URI])
+		for _, c := range change.ContentChanges {
+			start, end := lsp.Offset(text, c.Range.Start), lsp.Offset(text, c.Range.End)
+			text = append(append(append([]byte{}, text[:start]...), c.Text...), text[end:]...)
+		}
+		server.documents[change.TextDocument.URI] = string(text)
+		server.mutex.Unlock()
+	case "textDocument/hover":
+		reply(map[string]interface{}{"contents": map[string]string{"kind": "markdown", "value": "func main()"}}, nil)
+	case "textDocument/completion":
+		position := lsp.TextDocumentPositionParams{}
+		json.Unmarshal(params, &position)
+		at := position.Position
+		reply(lsp.CompletionList{Items: []lsp.CompletionItem{{
+			Label:            "Println",
+			Detail:           "func(a ...any)",
+			InsertTextFormat: lsp.InsertTextFormatSnippet,
+			TextEdit:         &lsp.TextEdit{Range: fakeRange(at.Line, at.Character-2, at.Character), NewText: "Println(${1:a})"},
+		}}}, nil)
+	case "textDocument/documentSymbol":
+		reply([]map[string]interface{}{{
+			"name": "main", "kind": 12, "range": fakeRange(2, 0, 5), "selectionRange": fakeRange(2, 5, 9),
+			"children": []map[string]interface{}{{"name": "x", "kind": 13, "range": fakeRange(3, 1, 2), "selectionRange": fakeRange(3, 1, 2)}},
+		}}, nil)
+	case "textDocument/signatureHelp":
+		reply(lsp.SignatureHelp{Signatures: []lsp.SignatureInformation{{Label: "helper(n int)", Documentation: "Helps."}}}, nil)
+	case "textDocument/definition":
+		reply([]lsp.Location{{URI: lsp.FileURI("/src/util.go"), Range: fakeRange(2, 5, 11)}}, nil)
+	case "textDocument/references":
+		reply([]lsp.Location{{URI: main, Range: fakeRange(3, 1, 2)}, {URI: main, Range: fakeRange(4, 1, 2)}}, nil)
+	case "textDocument/rename":
+		name := lsp.RenameParams{}
+		json.Unmarshal(params, &name)
+		reply(lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{main: {
+			{Range: fakeRange(3, 1, 2), NewText: name.NewName},
+			{Range: fakeRange(4, 1, 2), NewText: name.NewName},
+		}}}, nil)
+	case "textDocument/codeAction":
+		reply([]interface{}{
+			lsp.CodeAction{Title: "Remove x", Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{main: {
+				{Range: lsp.Range{Start: lsp.Position{Line: 3}, End: lsp.Position{Line: 4}}},
+			}}}},
+			lsp.Command{Title: "Add comment", Command: "comment"},
+		}, nil)
+	case "workspace/executeCommand":
+		server.conn.Call("workspace/applyEdit", lsp.ApplyWorkspaceEditParams{Edit: lsp.WorkspaceEdit{
+			DocumentChanges: []lsp.TextDocumentEdit{{
+				TextDocument: lsp.VersionedTextDocumentIdentifier{URI: main},
+				Edits:        []lsp.TextEdit{{Range: fakeRange(0, 0, 0), NewText: "// Comment.\n"}},
+			}},
+		}}, func(json.RawMessage, error) { reply(nil, nil) })
+	case "textDocument/formatting":
+		reply([]lsp.TextEdit{
+			{Range: fakeRange(3, 0, 0), NewText: "\t"},
+			{Range: fakeRange(3, 1, 3), NewText: ""},
+		}, nil)
+	case "shutdown":
+		reply(nil, nil)
+	default:
+		reply(nil, &lsp.Error{Code: lsp.MethodNotFound, Message: "Unknown method"})
+	}
+}
+
+func TestLanguageServers(t *testing.T) {
+	Convey("With a language server for Go", t, func() {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "/src/go.mod", []byte("module example\n"), 0644)
+		afero.WriteFile(fs, "/src/main.go", []byte("package main\n\nfunc main() {\n\tx := 1\n\tx++\n}\n"), 0644)
+		afero.WriteFile(fs, "/src/util.go", []byte("package main\n\nfunc helper(n int) {}\n"), 0644)
+		afero.WriteFile(fs, "/src/notes.txt", []byte("notes\n"), 0644)
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		work := make(chan func(), 1024)
+		editor.SetPost(func(f func()) { work <- f })
+		run := func(done func() bool) {
+			deadline := time.After(5 * time.Second)
+			for !done() {
+				select {
+				case f := <-work:
+					f()
+				case <-time.After(time.Millisecond):
+				case <-deadline:
+					So(done(), ShouldBeTrue)
+					return
+				}
+			}
+		}
+
+		fake := &fakeLanguageServer{documents: map[string]string{}}
+		editor.languages.connect = fake.connect
+		editor.SetLanguageServer("go", DefaultLanguageServers["go"])
+		editor.OpenFile("/src/main.go")
+		buffer := editor.CurrentPane().Buffer()
+		uri := lsp.FileURI("/src/main.go")
+		run(func() bool { return fake.text(uri) != "" && len(buffer.Signs()) > 0 })
+		defer editor.StopLanguageServers()
+		editor.NotifyChanges()
+
+		Convey("the server is started in the project's root", func() {
+			So(fake.root, ShouldEqual, "/src")
+			So(editor.LanguageServers(), ShouldHaveLength, 1)
+			So(fake.received("initialized"), ShouldBeTrue)
+			So(fake.text(uri), ShouldEqual, string(buffer.data))
+		})
+
+		Convey("other files of the project share the server", func() {
+			editor.OpenFile("/src/util.go")
+			run(func() bool { return fake.text(lsp.FileURI("/src/util.go")) != "" })
+			So(editor.LanguageServers(), ShouldHaveLength, 1)
+		})
+
+		Convey("changes to the buffer are sent to the server", func() {
+			buffer.InsertLines(1, []string{"// héllo 😀"})
+			buffer.ReplaceLines(5, 5, []string{"\tx += 2"})
+			editor.NotifyChanges()
+			run(func() bool { return fake.text(uri) == string(buffer.data) })
+			buffer.Undo()
+			editor.NotifyChanges()
+			run(func() bool { return fake.text(uri) == string(buffer.data) })
+			So(fake.text(uri), ShouldContainSubstring, "// héllo 😀\n")
+		})
+
+		Convey("diagnostics are shown as signs", func() {
+			signs := buffer.SignsAt(4)
+			So(signs, ShouldHaveLength, 1)
+			So(signs[0].Text, ShouldEqual, "W")
+			So(signs[0].Style, ShouldEqual, StyleSignWarning)
+			So(editor.ExecuteCommand("lsp diagnostics"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "4:2: warning: x declared and not used")
+		})
+
+		Convey("K shows what's under the cursor", func() {
+			editor.CurrentPane().Cursor().Move(5, 3)
+			So(editor.Hover(), ShouldBeNil)
+			run(func() bool { return editor.Message().Text != "" })
+			So(editor.Message().Text, ShouldEqual, "func main()")
+
+			Convey("in a float once the screen is drawn", func() {
+				grid := NewRuneGrid(20, 8)
+				grid.RenderEditor(&editor)
+				So(editor.Hover(), ShouldBeNil)
+				run(func() bool { return len(editor.Floats()) > 0 })
+				grid.RenderEditor(&editor)
+				rows := []string{}
+				for _, row := range grid.Cells() {
+					rows = append(rows, string(row))
+				}
+				So(rows[3][6:], ShouldStartWith, "┌───────────┐")
+				So(rows[4][6:], ShouldStartWith, "│func main()│")
+
+				Convey("and K again gives it the keys", func() {
+					So(editor.Hover(), ShouldBeNil)
+					So(editor.FocusedFloat(), ShouldEqual, editor.Floats()[0])
+					editor.HandleFloatKey(KeyEvent{Key: KeyEscape})
+					So(editor.Floats(), ShouldBeEmpty)
+					So(editor.FocusedFloat(), ShouldBeNil)
+				})
+
+				editor.CurrentPane().Cursor().Move(0, 2)
+				editor.NotifyChanges()
+				So(editor.Floats(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("completions come from the server", func() {
+			editor.CurrentPane().Cursor().Move(4, 5)
+			editor.EnterInsertMode(false)
+			editor.InsertText("\n\tfmt.Pr")
+			editor.OpenCompletion()
+			run(func() bool { return len(editor.Completions()) > 0 })
+			So(editor.Completions()[0], ShouldResemble, CompletionItem{
+				Text: "Println(${1:a})", Label: "Println", Detail: "func(a ...any)", Start: 5, Snippet: true,
+			})
+			editor.SelectCompletion(1)
+			editor.AcceptCompletion()
+			line, _ := buffer.GetLine(6)
+			So(line, ShouldEqual, "\tfmt.Println(a)")
+			x, _ := editor.CurrentPane().Cursor().Position()
+			So(x, ShouldEqual, 13)
+		})
+
+		Convey("the symbols of the buffer are picked from", func() {
+			So(editor.ExecuteCommand("pick symbols"), ShouldBeNil)
+			run(func() bool { return len(editor.PickerMatches()) == 2 && editor.PickerPreview() != nil })
+			So(editor.PickerMatches()[1], ShouldResemble, PickerItem{Text: "x", Detail: "variable main",
+				Value: lsp.Location{URI: uri, Range: fakeRange(3, 1, 2)}})
+			So(editor.PickerPreview()[0], ShouldEqual, "package main")
+			editor.HandlePickerKey(KeyEvent{Rune: 'x'})
+			So(editor.HandlePickerKey(KeyEvent{Key: KeyEnter}), ShouldBeNil)
+			x, line := editor.CurrentPane().Cursor().Position()
+			So([]int{x, line}, ShouldResemble, []int{1, 4})
+		})
+
+		Convey("signature help is shown", func() {
+			So(editor.ExecuteCommand("lsp signature"), ShouldBeNil)
+			run(func() bool { return editor.Message().Text != "" })
+			So(editor.Message().Text, ShouldEqual, "helper(n int)\nHelps.")
+		})
+
+		Convey("gd goes to the definition", func() {
+			So(editor.GoToDefinition(), ShouldBeNil)
+			run(func() bool { return editor.CurrentPane().Buffer() != buffer })
+			So(editor.CurrentPane().Buffer().Filename(), ShouldEqual, "/src/util.go")
+			x, line := editor.CurrentPane().Cursor().Position()
+			So(x, ShouldEqual, 5)
+			So(line, ShouldEqual, 3)
+
+			Convey("and opens the file in the server", func() {
+				run(func() bool { return fake.text(lsp.FileURI("/src/util.go")) != "" })
+				So(editor.languageServerOf(editor.CurrentPane().Buffer()), ShouldNotBeNil)
+			})
+		})
+
+		Convey("gr lists the references", func() {
+			editor.CurrentPane().Cursor().Move(0, 1)
+			So(editor.FindReferences(), ShouldBeNil)
+			run(func() bool { return editor.Message().Text != "" })
+			So(editor.Message().Text, ShouldEndWith, "main.go:4:2: x := 1\n/src/main.go:5:2: x++")
+			x, line := editor.CurrentPane().Cursor().Position()
+			So(x, ShouldEqual, 1)
+			So(line, ShouldEqual, 4)
+		})
+
+		Convey("symbols are renamed", func() {
+			So(editor.ExecuteCommand("lsp rename count"), ShouldBeNil)
+			run(func() bool { line, _ := buffer.GetLine(5); return line == "\tcount++" })
+			line, _ := buffer.GetLine(4)
+			So(line, ShouldEqual, "\tcount := 1")
+
+			Convey("as one change", func() {
+				So(editor.Undo(), ShouldBeNil)
+				line, _ := buffer.GetLine(4)
+				So(line, ShouldEqual, "\tx := 1")
+			})
+		})
+
+		Convey("code actions are listed and applied", func() {
+			editor.CurrentPane().Cursor().Move(1, 4)
+			So(editor.ExecuteCommand("lsp action"), ShouldBeNil)
+			run(func() bool { return editor.Message().Text != "" })
+			So(editor.Message().Text, ShouldEqual, "1: Remove x\n2: Add comment")
+
+			So(editor.ExecuteCommand("lsp action 1"), ShouldBeNil)
+			line, _ := buffer.GetLine(4)
+			So(line, ShouldEqual, "\tx++")
+
+			So(editor.ExecuteCommand("lsp action 2"), ShouldBeNil)
+			run(func() bool { line, _ := buffer.GetLine(1); return line == "// Comment." })
+			So(editor.ExecuteCommand("lsp action 3").Error(), ShouldEqual, "No code action 3")
+		})
+
+		Convey("the buffer is formatted", func() {
+			buffer.ReplaceLines(4, 4, []string{"x   := 1"})
+			So(editor.ExecuteCommand("lsp format"), ShouldBeNil)
+			run(func() bool { line, _ := buffer.GetLine(4); return line == "\tx := 1" })
+		})
+
+		Convey("buffers without a server say so", func() {
+			editor.OpenFile("/src/notes.txt")
+			So(editor.Hover().Error(), ShouldEqual, "No language server for this buffer")
+			So(editor.ExecuteCommand("lsp bogus").Error(), ShouldEqual, "Unknown language server command: bogus")
+		})
+
+		Convey("deleted buffers are closed", func() {
+			So(editor.DeleteBuffer(buffer, true), ShouldBeNil)
+			run(func() bool { return fake.received("textDocument/didClose") })
+			So(editor.languageServerOf(buffer), ShouldBeNil)
+		})
+
+		Convey("servers are shut down when jkl exits", func() {
+			editor.StopLanguageServers()
+			So(editor.LanguageServers(), ShouldBeEmpty)
+			So(buffer.Signs(), ShouldBeEmpty)
+			<-fake.conn.Done()
+			So(fake.received("shutdown"), ShouldBeTrue)
+			So(fake.received("exit"), ShouldBeTrue)
+			So(strings.Contains(editor.Message().Text, "Exited"), ShouldBeFalse)
+		})
+	})
+}
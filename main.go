@@ -8,18 +8,30 @@ import (
 )
 
 func main() {
-	options := processArguments()
-	driver := NewTermboxDriver()
-	ui := NewTerminalUI(&driver)
 	fs := afero.OsFs{}
-
-	options = append(options, SetOut(os.Stdout))
-	options = append(options, SetErrOut(os.Stderr))
-	options = append(options, SetUI(&ui))
-	options = append(options, SetFS(&fs))
+	options := Options{SetOut(os.Stdout), SetErrOut(os.Stderr), SetFS(&fs)}
+	options = append(options, processArguments()...)
 
 	app := NewApp(options...)
+	if app.UI == nil {
+		app.LoadOptions(UseDriver("termbox"))
+	}
+	if _, ok := app.UI.(*Server); !ok {
+		app.LoadOptions(ListenForRemote())
+	}
+	app.LoadOptions(SetupPlugins(), UseLanguageServers(), UseGit(), RestoreBuffers())
+	if path, err := PluginCommands(); err == nil {
+		app.LoadOptions(StartPlugins(path))
+	}
+	if path, err := ConfigFile("init.lua"); err == nil {
+		app.LoadOptions(RunConfig(path))
+	}
 	app.Run()
+
+	if err := app.ExecRestart(); err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot restart:", err)
+		os.Exit(1)
+	}
 }
 
 func processArguments() Options {
@@ -0,0 +1,94 @@
+package main
+
+import "strings"
+
+// Severity is how important a Message is.
+type Severity int
+
+// Message severities, in increasing importance.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// Style returns the Style messages of this Severity are drawn with.
+func (severity Severity) Style() Style {
+	switch severity {
+	case SeverityWarning:
+		return StyleWarning
+	case SeverityError:
+		return StyleError
+	}
+	return StyleMessage
+}
+
+// Message is text shown to the user in the message area.
+type Message struct {
+	Text     string
+	Severity Severity
+}
+
+// maxMessageHistory is the number of messages kept for :messages.
+const maxMessageHistory = 200
+
+// ShowMessage displays the message and adds it to the message history.
+func (editor *Editor) ShowMessage(message Message) {
+	editor.message = message
+
+	editor.messages = append(editor.messages, message)
+	if len(editor.messages) > maxMessageHistory {
+		editor.messages = editor.messages[len(editor.messages)-maxMessageHistory:]
+	}
+}
+
+// Echo shows an informational message.
+func (editor *Editor) Echo(text string) {
+	editor.ShowMessage(Message{Text: text, Severity: SeverityInfo})
+}
+
+// EchoWarning shows a warning message.
+func (editor *Editor) EchoWarning(text string) {
+	editor.ShowMessage(Message{Text: text, Severity: SeverityWarning})
+}
+
+// EchoError shows an error message.
+func (editor *Editor) EchoError(err error) {
+	editor.ShowMessage(Message{Text: err.Error(), Severity: SeverityError})
+}
+
+// Message returns the message currently being shown.
+func (editor *Editor) Message() Message {
+	return editor.message
+}
+
+// ClearMessage stops showing the current message, it stays in the history.
+func (editor *Editor) ClearMessage() {
+	editor.message = Message{}
+}
+
+// Messages returns the message history, oldest first.
+func (editor *Editor) Messages() []Message {
+	return editor.messages
+}
+
+// ClearMessages empties the message history.
+func (editor *Editor) ClearMessages() {
+	editor.messages = nil
+}
+
+func messagesCommand(editor *Editor, command Command) error {
+	if command.Args == "clear" {
+		editor.ClearMessages()
+		return nil
+	}
+
+	lines := []string{}
+	for _, message := range editor.Messages() {
+		lines = append(lines, message.Text)
+	}
+
+	// Shown without going through ShowMessage so :messages doesn't add itself to the history.
+	editor.message = Message{Text: strings.Join(lines, "\n")}
+	return nil
+}
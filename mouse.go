@@ -0,0 +1,146 @@
+package main
+
+// mouseScrollLines is how many lines the wheel scrolls a pane.
+const mouseScrollLines = 3
+
+// mouseState tracks a mouse drag in progress.
+type mouseState struct {
+	selecting bool
+	pressed   bool
+	resizing  *Split
+	separator int
+	origin    int
+	sizes     []int
+}
+
+// HandleMouse moves the cursor, selects, scrolls or resizes panes in response to the mouse.
+// Positions are mapped through where each Pane was last drawn.
+func (editor *Editor) HandleMouse(event MouseEvent) {
+	state := &editor.mouse
+
+	switch {
+	case event.Button == MouseWheelUp || event.Button == MouseWheelDown:
+		if pane := editor.PaneAt(event.X, event.Y); pane != nil {
+			lines := mouseScrollLines
+			if event.Button == MouseWheelUp {
+				lines = -lines
+			}
+			editor.ScrollPane(pane, lines)
+		}
+
+	case event.Action == MousePress && event.Button == MouseLeft:
+		editor.mousePress(event)
+
+	case event.Action == MouseDrag && state.resizing != nil:
+		delta := event.X - state.origin
+		if !state.resizing.Vertical {
+			delta = event.Y - state.origin
+		}
+		state.resizing.resizeChildren(state.separator, state.sizes, delta)
+
+	case event.Action == MouseDrag && state.pressed:
+		if !state.selecting {
+			editor.EnterVisualMode()
+			state.selecting = true
+		}
+		editor.moveCursorTo(editor.CurrentPane(), event.X, event.Y)
+
+	case event.Action == MouseRelease:
+		*state = mouseState{}
+	}
+}
+
+func (editor *Editor) mousePress(event MouseEvent) {
+	state := &editor.mouse
+	*state = mouseState{}
+
+	pane := editor.PaneAt(event.X, event.Y)
+	if pane != nil {
+		editor.SetCurrentPane(pane)
+		editor.LeaveVisualMode()
+		if editor.Mode() == TerminalMode && pane.Buffer().Terminal() == nil {
+			editor.SetMode(NormalMode)
+		}
+	}
+
+	// Separators, including status lines between stacked panes without borders, are dragged to resize.
+	if split, i := editor.layout.separatorAt(event.X, event.Y); split != nil {
+		state.resizing = split
+		state.separator = i
+		state.sizes = append([]int{}, split.sizes...)
+		state.origin = event.Y
+		if split.Vertical {
+			state.origin = event.X
+		}
+		return
+	}
+
+	if pane == nil || event.Y == pane.View().StatusY {
+		return
+	}
+
+	editor.moveCursorTo(pane, event.X, event.Y)
+	state.pressed = true
+}
+
+// moveCursorTo moves the Pane's cursor to the character drawn at the screen position.
+func (editor *Editor) moveCursorTo(pane *Pane, x, y int) {
+	cursor := pane.Cursor()
+	if cursor == nil {
+		return
+	}
+
+	line, column, ok := pane.View().BufferPosition(x, y)
+	if !ok {
+		return
+	}
+
+	text, _ := pane.Buffer().GetLine(line)
+	cursor.Move(CharacterIndex(editor.Settings(), text, column), line)
+}
+
+// ScrollPane scrolls the Pane by a number of visible lines, negative scrolls up.
+// The cursor is moved if it would otherwise scroll out of view.
+func (editor *Editor) ScrollPane(pane *Pane, lines int) {
+	cursor := pane.Cursor()
+	if cursor == nil {
+		return
+	}
+
+	settings := editor.Settings()
+	folds := pane.Folds()
+	lastVisible := folds.VisibleLine(pane.Buffer().LineCount())
+
+	top := folds.VisibleLine(pane.TopLine()) + lines
+	if top > lastVisible {
+		top = lastVisible
+	}
+	if top < 1 {
+		top = 1
+	}
+	pane.SetTopLine(folds.LineAtVisible(top))
+
+	height := pane.View().Height
+	if height < 1 {
+		height = 1
+	}
+	offset := settings.ScrollOffset
+	if offset*2 >= height {
+		offset = (height - 1) / 2
+	}
+
+	x, line := cursor.Position()
+	visible := folds.VisibleLine(line)
+	switch {
+	case visible < top+offset && top > 1:
+		visible = top + offset
+	case visible > top+height-1-offset:
+		visible = top + height - 1 - offset
+	default:
+		return
+	}
+	if visible > lastVisible {
+		visible = lastVisible
+	}
+	cursor.Move(x, folds.LineAtVisible(visible))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMouse(t *testing.T) {
+	Convey("Editor drawn on a grid", t, func() {
+		lines := []string{}
+		for i := 1; i <= 20; i++ {
+			lines = append(lines, fmt.Sprintf("line %d\ttext", i))
+		}
+
+		editor := NewEditor(GetTestFs())
+		buffer := editor.AddBuffer(bufferWithText(strings.Join(lines, "\n")))
+		editor.SwitchToBuffer(buffer)
+		editor.Settings().Borders = false
+		editor.Settings().Wrap = false
+		pane := editor.CurrentPane()
+
+		grid := NewRuneGrid(30, 8)
+		render := func() {
+			grid = NewRuneGrid(30, 8)
+			grid.RenderEditor(&editor)
+		}
+		render()
+
+		Convey("clicking moves the cursor to the character", func() {
+			editor.HandleMouse(MouseEvent{X: 2, Y: 3, Button: MouseLeft})
+			x, line := pane.Cursor().Position()
+			So(x, ShouldEqual, 2)
+			So(line, ShouldEqual, 4)
+
+			Convey("inside a tab", func() {
+				editor.HandleMouse(MouseEvent{X: 9, Y: 0, Button: MouseLeft})
+				x, _ := pane.Cursor().Position()
+				So(x, ShouldEqual, 6)
+			})
+
+			Convey("past the end of the line", func() {
+				editor.HandleMouse(MouseEvent{X: 25, Y: 0, Button: MouseLeft})
+				x, _ := pane.Cursor().Position()
+				So(x, ShouldEqual, 10)
+			})
+		})
+
+		Convey("clicking accounts for the gutter and top line", func() {
+			editor.Settings().Number = true
+			pane.Cursor().Move(0, 6)
+			pane.SetTopLine(5)
+			render()
+			editor.HandleMouse(MouseEvent{X: 4, Y: 2, Button: MouseLeft})
+			x, line := pane.Cursor().Position()
+			So(x, ShouldEqual, 0)
+			So(line, ShouldEqual, 7)
+		})
+
+		Convey("dragging selects", func() {
+			editor.HandleMouse(MouseEvent{X: 1, Y: 0, Button: MouseLeft})
+			editor.HandleMouse(MouseEvent{X: 3, Y: 1, Button: MouseLeft, Action: MouseDrag})
+			editor.HandleMouse(MouseEvent{X: 3, Y: 1, Action: MouseRelease})
+			So(editor.Mode(), ShouldEqual, VisualMode)
+			startX, startLine, endX, endLine := pane.Selection()
+			So([]int{startX, startLine, endX, endLine}, ShouldResemble, []int{1, 1, 3, 2})
+
+			Convey("clicking again stops selecting", func() {
+				editor.HandleMouse(MouseEvent{X: 1, Y: 0, Button: MouseLeft})
+				So(editor.Mode(), ShouldEqual, NormalMode)
+			})
+		})
+
+		Convey("the wheel scrolls and keeps the cursor in view", func() {
+			editor.HandleMouse(MouseEvent{X: 1, Y: 1, Button: MouseWheelDown})
+			So(pane.TopLine(), ShouldEqual, 4)
+			_, line := pane.Cursor().Position()
+			So(line, ShouldEqual, 4)
+
+			editor.HandleMouse(MouseEvent{X: 1, Y: 1, Button: MouseWheelUp})
+			So(pane.TopLine(), ShouldEqual, 1)
+		})
+
+		Convey("with stacked panes", func() {
+			top := editor.SplitPane(false)
+			render()
+			So(top.View().StatusY, ShouldEqual, 3)
+
+			Convey("clicking a pane focuses it", func() {
+				editor.HandleMouse(MouseEvent{X: 1, Y: 4, Button: MouseLeft})
+				So(editor.CurrentPane(), ShouldEqual, pane)
+
+				Convey("clicking a status line focuses its pane", func() {
+					editor.HandleMouse(MouseEvent{X: 1, Y: 3, Button: MouseLeft})
+					So(editor.CurrentPane(), ShouldEqual, top)
+				})
+			})
+
+			Convey("dragging the status line between them resizes", func() {
+				editor.HandleMouse(MouseEvent{X: 1, Y: 3, Button: MouseLeft})
+				editor.HandleMouse(MouseEvent{X: 1, Y: 5, Button: MouseLeft, Action: MouseDrag})
+				editor.HandleMouse(MouseEvent{X: 1, Y: 5, Action: MouseRelease})
+				render()
+				So(top.View().StatusY, ShouldEqual, 5)
+				So(pane.View().Frame, ShouldResemble, Rect{0, 6, 29, 6})
+			})
+		})
+
+		Convey("dragging a vertical separator resizes", func() {
+			left := editor.SplitPane(true)
+			render()
+			So(grid.Cells()[0][15], ShouldEqual, '│')
+
+			editor.HandleMouse(MouseEvent{X: 15, Y: 0, Button: MouseLeft})
+			editor.HandleMouse(MouseEvent{X: 10, Y: 0, Button: MouseLeft, Action: MouseDrag})
+			render()
+			So(grid.Cells()[0][10], ShouldEqual, '│')
+			So(left.View().Frame.X2, ShouldEqual, 9)
+		})
+	})
+
+	Convey("App with the mouse turned off", t, func() {
+		app := fakeApp()
+		editor := app.Editor()
+		editor.SwitchToBuffer(editor.AddBuffer(bufferWithText("one\ntwo\nthree")))
+		grid := NewRuneGrid(30, 8)
+		grid.RenderEditor(editor)
+		So(editor.ExecuteCommand("set nomouse"), ShouldBeNil)
+
+		app.handleEvent(Event{MouseEvent{X: 3, Y: 2, Button: MouseLeft}})
+		x, line := editor.CurrentPane().Cursor().Position()
+		So(x, ShouldEqual, 0)
+		So(line, ShouldEqual, 1)
+
+		So(editor.ExecuteCommand("set mouse"), ShouldBeNil)
+		app.handleEvent(Event{MouseEvent{X: 3, Y: 2, Button: MouseLeft}})
+		_, line = editor.CurrentPane().Cursor().Position()
+		So(line, ShouldNotEqual, 1)
+	})
+}
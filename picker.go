@@ -0,0 +1,790 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dcbishop/jkl/lsp"
+	"github.com/spf13/afero"
+)
+
+// Picker chooses one of a list of items by typing part of it, see OpenPicker.
+type Picker struct {
+	Title string
+	// Items are the candidates known when the picker opens.
+	Items []PickerItem
+	// Stream, if set, runs in a goroutine of its own adding candidates as it finds them.
+	// It returns when it runs out or done is closed.
+	Stream func(add func(items []PickerItem), done <-chan struct{})
+	// Preview, if set, is called on the main loop when an item is selected. It calls show once with
+	// the lines to preview, maybe later from another goroutine.
+	Preview func(item PickerItem, show func(lines []string))
+	// Accept is called on the main loop with the chosen item once the picker has closed.
+	Accept func(item PickerItem) error
+}
+
+// PickerItem is a candidate of a Picker, matched by its Text. Detail is shown after the Text and
+// Value is for the Picker's own use.
+type PickerItem struct {
+	Text   string
+	Detail string
+	Value  interface{}
+}
+
+// pickerState is the open picker. matches are the items matching the query, best first, and preview
+// is what was shown for the item previewItem.
+type pickerState struct {
+	picker      *Picker
+	query       []rune
+	items       []PickerItem
+	matches     []pickerMatch
+	selected    int
+	top         int
+	rows        int
+	done        chan struct{}
+	streaming   bool
+	previewing  int
+	previewItem int
+	preview     []string
+	float       *Float
+}
+
+type pickerMatch struct {
+	item      int
+	score     int
+	positions []int
+}
+
+// Limits of a picker. The stream stops after pickerMaxItems, previews show pickerPreviewLines lines
+// from pickerPreviewContext lines before the line of interest.
+const (
+	pickerMaxItems        = 200000
+	pickerPreviewLines    = 200
+	pickerPreviewContext  = 5
+	pickerMinWidth        = 40
+	pickerMinHeight       = 8
+	pickerPreviewMinWidth = 60
+)
+
+// pickerSources make the built in pickers of ":pick {source} [args]".
+var pickerSources = map[string]func(editor *Editor, args string) (*Picker, error){
+	"files":    filesPicker,
+	"buffers":  buffersPicker,
+	"history":  historyPicker,
+	"commands": commandsPicker,
+	"help":     helpPicker,
+	"symbols":  symbolsPicker,
+}
+
+// OpenPicker shows a picker over the panes, closing the one already open. Keys go to the picker
+// until an item is chosen or it's closed.
+func (editor *Editor) OpenPicker(picker *Picker) {
+	editor.ClosePicker()
+	state := &pickerState{picker: picker, done: make(chan struct{}), previewItem: -1}
+	state.float = &Float{
+		Anchor: AnchorCenter, Title: picker.Title, Border: true, Shadow: true, Z: floatLayerPicker,
+		Style: StylePicker, ShowCursor: true,
+		Layout: func(float *Float, width, height int) bool {
+			float.Width, float.Height = pickerSize(width, pickerMinWidth), pickerSize(height, pickerMinHeight)
+			return true
+		},
+		Draw: func(grid *RuneGrid, x1, y1, x2, y2 int) { grid.drawPicker(editor, state, x1, y1, x2, y2) },
+		Key: func(event KeyEvent) bool {
+			if err := editor.HandlePickerKey(event); err != nil {
+				editor.EchoError(err)
+			}
+			return true
+		},
+		Closed: func() {
+			state.stop()
+			if editor.picker == state {
+				editor.picker = nil
+			}
+		},
+	}
+	editor.picker = state
+	state.add(picker.Items)
+	editor.OpenFloat(state.float)
+	editor.FocusFloat(state.float)
+
+	if picker.Stream != nil {
+		state.streaming = true
+		done := state.done
+		go func() {
+			picker.Stream(func(items []PickerItem) {
+				editor.Post(func() {
+					if editor.picker == state {
+						editor.addPickerItems(items)
+					}
+				})
+			}, done)
+			editor.Post(func() { state.streaming = false })
+		}()
+	}
+	editor.previewPicked()
+}
+
+// ClosePicker closes the open picker without choosing anything.
+func (editor *Editor) ClosePicker() {
+	if editor.picker != nil {
+		editor.CloseFloat(editor.picker.float)
+	}
+}
+
+// Picker returns the open picker, nil if there isn't one.
+func (editor *Editor) Picker() *Picker {
+	if editor.picker == nil {
+		return nil
+	}
+	return editor.picker.picker
+}
+
+// AddPickerItems adds candidates to a picker if it's still open.
+func (editor *Editor) AddPickerItems(picker *Picker, items []PickerItem) {
+	if editor.Picker() == picker && picker != nil {
+		editor.addPickerItems(items)
+	}
+}
+
+// addPickerItems adds candidates to the open picker and previews the selected one.
+func (editor *Editor) addPickerItems(items []PickerItem) {
+	editor.picker.add(items)
+	if len(editor.picker.items) >= pickerMaxItems {
+		editor.picker.stop()
+	}
+	editor.previewPicked()
+}
+
+// PickerMatches returns the items of the open picker that match what's been typed, best first.
+func (editor *Editor) PickerMatches() []PickerItem {
+	items := []PickerItem{}
+	if state := editor.picker; state != nil {
+		for _, match := range state.matches {
+			items = append(items, state.items[match.item])
+		}
+	}
+	return items
+}
+
+// PickerSelected returns the index in PickerMatches of the item Enter chooses.
+func (editor *Editor) PickerSelected() int {
+	if editor.picker == nil {
+		return 0
+	}
+	return editor.picker.selected
+}
+
+// PickerPreview returns the lines previewing the selected item.
+func (editor *Editor) PickerPreview() []string {
+	if editor.picker == nil {
+		return nil
+	}
+	return editor.picker.preview
+}
+
+// HandlePickerKey types into the open picker's prompt, moves through its matches or chooses one with Enter.
+func (editor *Editor) HandlePickerKey(event KeyEvent) error {
+	state := editor.picker
+	if state == nil {
+		return nil
+	}
+	ctrl := func(r rune) bool { return event.Key == KeyRune && event.Mod&ModCtrl != 0 && event.Rune == r }
+	page := state.rows
+	if page < 1 {
+		page = 1
+	}
+
+	switch {
+	case event.Key == KeyEscape || ctrl('c') || ctrl('g'):
+		editor.ClosePicker()
+		return nil
+	case event.Key == KeyEnter:
+		return editor.acceptPicked()
+	case event.Key == KeyDown || event.Key == KeyTab && event.Mod&ModShift == 0 || ctrl('n') || ctrl('j'):
+		state.move(1)
+	case event.Key == KeyUp || event.Key == KeyTab || ctrl('p') || ctrl('k'):
+		state.move(-1)
+	case event.Key == KeyPageDown:
+		state.move(page)
+	case event.Key == KeyPageUp:
+		state.move(-page)
+	case event.Key == KeyBackspace:
+		if len(state.query) > 0 {
+			state.setQuery(state.query[:len(state.query)-1])
+		}
+	case ctrl('u'):
+		state.setQuery(nil)
+	case ctrl('w'):
+		query := strings.TrimRight(string(state.query), " ")
+		state.setQuery([]rune(query[:strings.LastIndex(query, " ")+1]))
+	case event.Key == KeyRune && event.Mod&(ModCtrl|ModAlt) == 0:
+		state.setQuery(append(state.query[:len(state.query):len(state.query)], event.Rune))
+	}
+	editor.previewPicked()
+	return nil
+}
+
+// acceptPicked closes the picker and gives its selected item to the Picker's Accept.
+func (editor *Editor) acceptPicked() error {
+	state := editor.picker
+	if len(state.matches) == 0 {
+		return nil
+	}
+	item := state.items[state.matches[state.selected].item]
+	editor.ClosePicker()
+	if state.picker.Accept == nil {
+		return nil
+	}
+	return state.picker.Accept(item)
+}
+
+// previewPicked asks the Picker for the preview of the selected item if it changed.
+func (editor *Editor) previewPicked() {
+	state := editor.picker
+	if state == nil || state.picker.Preview == nil {
+		return
+	}
+	item := -1
+	if len(state.matches) > 0 {
+		item = state.matches[state.selected].item
+	}
+	if item == state.previewItem {
+		return
+	}
+	state.previewItem, state.preview = item, nil
+	state.previewing++
+	if item == -1 {
+		return
+	}
+	previewing := state.previewing
+	state.picker.Preview(state.items[item], func(lines []string) {
+		editor.Post(func() {
+			if editor.picker == state && state.previewing == previewing {
+				state.preview = lines
+			}
+		})
+	})
+}
+
+// stop stops the picker's stream.
+func (state *pickerState) stop() {
+	select {
+	case <-state.done:
+	default:
+		close(state.done)
+	}
+}
+
+// add adds candidates and ranks those matching the query, the selected item stays selected.
+func (state *pickerState) add(items []PickerItem) {
+	if room := pickerMaxItems - len(state.items); len(items) > room {
+		items = items[:room]
+	}
+	first := len(state.items)
+	state.items = append(state.items, items...)
+	selected := state.selectedItem()
+	state.match(first)
+	state.reselect(selected)
+}
+
+// setQuery changes what's been typed and selects the best match.
+func (state *pickerState) setQuery(query []rune) {
+	state.query = query
+	state.matches = nil
+	state.match(0)
+	state.selected, state.top = 0, 0
+}
+
+// match scores the items from first on against the query and ranks those that match with the others.
+// Without a query the items stay in their order.
+func (state *pickerState) match(first int) {
+	query := string(state.query)
+	for i := first; i < len(state.items); i++ {
+		if score, positions, ok := FuzzyMatch(query, state.items[i].Text); ok {
+			state.matches = append(state.matches, pickerMatch{item: i, score: score, positions: positions})
+		}
+	}
+	if query == "" {
+		return
+	}
+	sort.SliceStable(state.matches, func(i, j int) bool {
+		a, b := state.matches[i], state.matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if la, lb := len(state.items[a.item].Text), len(state.items[b.item].Text); la != lb {
+			return la < lb
+		}
+		return a.item < b.item
+	})
+}
+
+// selectedItem returns the index in items of the selected match, -1 if there isn't one.
+func (state *pickerState) selectedItem() int {
+	if state.selected >= len(state.matches) {
+		return -1
+	}
+	return state.matches[state.selected].item
+}
+
+// reselect selects the match of an item again after the matches were ranked.
+func (state *pickerState) reselect(item int) {
+	for i, match := range state.matches {
+		if match.item == item {
+			state.selected = i
+			return
+		}
+	}
+	state.selected = 0
+}
+
+// move selects the match delta after the selected one, stopping at the first and last.
+func (state *pickerState) move(delta int) {
+	state.selected += delta
+	if state.selected >= len(state.matches) {
+		state.selected = len(state.matches) - 1
+	}
+	if state.selected < 0 {
+		state.selected = 0
+	}
+}
+
+// pickerSize returns the size inside the border of a picker in a frame, four fifths of it unless
+// that's less than least.
+func pickerSize(frame, least int) int {
+	if size := frame * 4 / 5; size >= least {
+		return size - 2
+	}
+	return frame - 2
+}
+
+// drawPicker draws the inside of the picker's float, its prompt and matches on the left and the preview
+// of the selected one on the right if there's room.
+func (grid *RuneGrid) drawPicker(editor *Editor, state *pickerState, x1, y1, x2, y2 int) {
+	right := x2
+	if state.picker.Preview != nil && x2-x1+3 >= pickerPreviewMinWidth {
+		split := x1 + (x2-x1+3)/2 - 1
+		grid.DrawVerticalLine(split, y1, y2, '│')
+		grid.SetCell(split, y1-1, '┬')
+		grid.SetCell(split, y2+1, '┴')
+		for i, line := range state.preview {
+			if y1+i > y2 {
+				break
+			}
+			grid.DrawText(split+2, y1+i, x2, string(ExpandLine(editor.Settings(), line)), StylePicker)
+		}
+		right = split - 1
+	}
+
+	prompt := "> " + string(state.query)
+	grid.DrawText(x1+1, y1, right, prompt, StylePicker)
+	count := fmt.Sprintf("%d/%d", len(state.matches), len(state.items))
+	if state.streaming {
+		count += "…"
+	}
+	if x := right - len([]rune(count)); x > x1+1+len([]rune(prompt)) {
+		grid.DrawText(x, y1, right, count, StylePicker)
+	}
+	cursor := 1 + len([]rune(prompt))
+	if cursor > right-x1 {
+		cursor = right - x1
+	}
+	state.float.CursorX, state.float.CursorY = cursor, 0
+
+	state.rows = y2 - y1
+	if state.selected < state.top {
+		state.top = state.selected
+	}
+	if state.selected >= state.top+state.rows {
+		state.top = state.selected - state.rows + 1
+	}
+	for i := 0; i < state.rows && state.top+i < len(state.matches); i++ {
+		match := state.matches[state.top+i]
+		item := state.items[match.item]
+		row := y1 + 1 + i
+		style := StylePicker
+		if state.top+i == state.selected {
+			style = StylePickerSelected
+			grid.FillStyle(x1, row, right, row, style)
+		}
+		grid.DrawText(x1+1, row, right, item.Text, style)
+		if style != StylePickerSelected {
+			for _, position := range match.positions {
+				if x1+1+position <= right {
+					grid.SetStyle(x1+1+position, row, StylePickerMatch)
+				}
+			}
+		}
+		if item.Detail != "" {
+			grid.DrawText(x1+3+len([]rune(item.Text)), row, right, item.Detail, style)
+		}
+	}
+}
+
+// previewFile shows lines of a file starting a little before line, or from the top for 0. Files being
+// edited are shown as they are in their buffer, others are read in a goroutine.
+func (editor *Editor) previewFile(filename string, line int, show func(lines []string)) {
+	first := line - pickerPreviewContext
+	if first < 1 {
+		first = 1
+	}
+	if buffer := editor.bufferOfFile(filename); buffer != nil {
+		show(bufferLines(buffer, first, first+pickerPreviewLines-1))
+		return
+	}
+	fs := editor.fs
+	go func() {
+		file, err := fs.Open(filename)
+		if err != nil {
+			show([]string{err.Error()})
+			return
+		}
+		defer file.Close()
+		show(previewLines(file, first))
+	}()
+}
+
+// previewLines reads the lines to preview from first, one for the first line, binary files aren't shown.
+func previewLines(reader io.Reader, first int) []string {
+	lines := []string{}
+	scanner := bufio.NewScanner(reader)
+	for n := 1; scanner.Scan() && len(lines) < pickerPreviewLines; n++ {
+		if strings.ContainsRune(scanner.Text(), 0) {
+			return []string{"Binary file"}
+		}
+		if n >= first {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return lines
+}
+
+// bufferLines returns the lines of a buffer from first to last, stopping at its end.
+func bufferLines(buffer *Buffer, first, last int) []string {
+	if last > buffer.LineCount() {
+		last = buffer.LineCount()
+	}
+	if first > last {
+		return nil
+	}
+	lines, _ := buffer.GetLines(first, last)
+	return lines
+}
+
+// filesPicker finds the files under a directory, the working directory by default, and opens one.
+func filesPicker(editor *Editor, dir string) (*Picker, error) {
+	root := dir
+	if root == "" {
+		root = "."
+	}
+	fs := editor.fs
+	path := func(item PickerItem) string { return filepath.Join(root, filepath.FromSlash(item.Text)) }
+	return &Picker{
+		Title: "Files",
+		Stream: func(add func([]PickerItem), done <-chan struct{}) {
+			WalkFiles(fs, root, done, func(paths []string) {
+				items := make([]PickerItem, len(paths))
+				for i, path := range paths {
+					items[i] = PickerItem{Text: path}
+				}
+				add(items)
+			})
+		},
+		Preview: func(item PickerItem, show func([]string)) {
+			editor.previewFile(path(item), 0, show)
+		},
+		Accept: func(item PickerItem) error {
+			editor.SwitchToBuffer(editor.loadFile(path(item)))
+			return nil
+		},
+	}, nil
+}
+
+// buffersPicker switches to one of the listed buffers.
+func buffersPicker(editor *Editor, args string) (*Picker, error) {
+	items := []PickerItem{}
+	for _, buffer := range editor.buffers {
+		if buffer.Listed() {
+			items = append(items, PickerItem{Text: buffer.Name(), Detail: fmt.Sprintf("[%d]", buffer.ID()), Value: buffer})
+		}
+	}
+	return &Picker{
+		Title: "Buffers",
+		Items: items,
+		Preview: func(item PickerItem, show func([]string)) {
+			show(bufferLines(item.Value.(*Buffer), 1, pickerPreviewLines))
+		},
+		Accept: func(item PickerItem) error {
+			for _, buffer := range editor.buffers {
+				if buffer == item.Value {
+					editor.SwitchToBuffer(buffer)
+					return nil
+				}
+			}
+			return errors.New("Buffer was deleted")
+		},
+	}, nil
+}
+
+// historyPicker runs one of the commands typed before, the latest first.
+func historyPicker(editor *Editor, args string) (*Picker, error) {
+	history := editor.commandLine.History()
+	if len(history) == 0 {
+		return nil, errors.New("No commands typed yet")
+	}
+	items := []PickerItem{}
+	for i := len(history) - 1; i >= 0; i-- {
+		items = append(items, PickerItem{Text: history[i]})
+	}
+	return &Picker{
+		Title: "Command history",
+		Items: items,
+		Accept: func(item PickerItem) error {
+			editor.commandLine.AddHistory(item.Text)
+			return editor.ExecuteCommand(item.Text)
+		},
+	}, nil
+}
+
+// commandsPicker starts typing one of the ex commands.
+func commandsPicker(editor *Editor, args string) (*Picker, error) {
+	items := []PickerItem{}
+	for _, def := range editor.commands {
+		item := PickerItem{Text: def.FullName()}
+		if def.Name != item.Text {
+			item.Detail = def.Name
+		}
+		items = append(items, item)
+	}
+	return &Picker{
+		Title: "Commands",
+		Items: items,
+		Accept: func(item PickerItem) error {
+			editor.EnterCommandMode()
+			editor.commandLine.SetText(item.Text + " ")
+			return nil
+		},
+	}, nil
+}
+
+// helpPicker shows one of the help topics.
+func helpPicker(editor *Editor, args string) (*Picker, error) {
+	items := []PickerItem{}
+	for _, topic := range HelpTopics() {
+		items = append(items, PickerItem{Text: topic.Name, Value: topic})
+	}
+	return &Picker{
+		Title: "Help",
+		Items: items,
+		Preview: func(item PickerItem, show func([]string)) {
+			show(item.Value.(HelpTopic).Lines)
+		},
+		Accept: func(item PickerItem) error {
+			editor.showHelp(item.Value.(HelpTopic))
+			return nil
+		},
+	}, nil
+}
+
+// symbolsPicker jumps to a symbol of the current buffer from its language server, or to a name
+// from the tags file without one.
+func symbolsPicker(editor *Editor, args string) (*Picker, error) {
+	buffer := editor.CurrentPane().Buffer()
+	server := editor.languageServerOf(buffer)
+	if server == nil || !server.ready || !bool(server.capabilities.DocumentSymbolProvider) {
+		return editor.tagsPicker(buffer)
+	}
+
+	server.flushAll()
+	uri := server.documents[buffer].uri
+	replies := make(chan lsp.Symbols, 1)
+	params := lsp.DocumentSymbolParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}
+	server.conn.Call("textDocument/documentSymbol", params, func(data json.RawMessage, err error) {
+		symbols := lsp.Symbols{}
+		if err == nil {
+			json.Unmarshal(data, &symbols)
+		}
+		replies <- symbols
+	})
+
+	location := func(item PickerItem) lsp.Location { return item.Value.(lsp.Location) }
+	return &Picker{
+		Title: "Symbols",
+		Stream: func(add func([]PickerItem), done <-chan struct{}) {
+			select {
+			case symbols := <-replies:
+				items := []PickerItem{}
+				for _, symbol := range symbols {
+					if symbol.Location.URI == "" {
+						symbol.Location.URI = uri
+					}
+					detail := strings.TrimSpace(lsp.SymbolKindName(symbol.Kind) + " " + symbol.Container)
+					items = append(items, PickerItem{Text: symbol.Name, Detail: detail, Value: symbol.Location})
+				}
+				add(items)
+			case <-done:
+			}
+		},
+		Preview: func(item PickerItem, show func([]string)) {
+			path, err := lsp.URIPath(location(item).URI)
+			if err != nil {
+				show(nil)
+				return
+			}
+			editor.previewFile(path, location(item).Range.Start.Line+1, show)
+		},
+		Accept: func(item PickerItem) error {
+			return editor.jumpToLocation(location(item))
+		},
+	}, nil
+}
+
+// tagLocation is where a ctags tag is, its address is a line number or a search pattern.
+type tagLocation struct {
+	filename string
+	address  string
+}
+
+// tagsPicker jumps to one of the names in the tags file of a buffer.
+func (editor *Editor) tagsPicker(buffer *Buffer) (*Picker, error) {
+	filename := ""
+	for _, tags := range tagFiles(buffer) {
+		if _, err := editor.fs.Stat(tags); err == nil {
+			filename = tags
+			break
+		}
+	}
+	if filename == "" {
+		return nil, errors.New("No language server or tags file for symbols")
+	}
+
+	fs := editor.fs
+	return &Picker{
+		Title: "Tags",
+		Stream: func(add func([]PickerItem), done <-chan struct{}) {
+			file, err := fs.Open(filename)
+			if err != nil {
+				return
+			}
+			defer file.Close()
+			items := []PickerItem{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Split(scanner.Text(), "\t")
+				if len(fields) < 3 || strings.HasPrefix(fields[0], "!_TAG_") {
+					continue
+				}
+				location := tagLocation{filename: fields[1], address: fields[2]}
+				if !filepath.IsAbs(location.filename) {
+					location.filename = filepath.Join(filepath.Dir(filename), location.filename)
+				}
+				detail := fields[1]
+				if len(fields) > 3 && len(fields[3]) == 1 {
+					detail = fields[3] + " " + detail
+				}
+				items = append(items, PickerItem{Text: fields[0], Detail: detail, Value: location})
+			}
+			add(items)
+		},
+		Preview: func(item PickerItem, show func([]string)) {
+			location := item.Value.(tagLocation)
+			go func() {
+				data, err := afero.ReadFile(fs, location.filename)
+				if err != nil {
+					show([]string{err.Error()})
+					return
+				}
+				line := tagLine(strings.Split(string(data), "\n"), location.address)
+				show(previewLines(strings.NewReader(string(data)), line-pickerPreviewContext))
+			}()
+		},
+		Accept: func(item PickerItem) error {
+			location := item.Value.(tagLocation)
+			buffer := editor.loadFile(location.filename)
+			editor.SwitchToBuffer(buffer)
+			lines := bufferLines(buffer, 1, buffer.LineCount())
+			editor.CurrentPane().Cursor().Move(0, tagLine(lines, location.address))
+			return nil
+		},
+	}, nil
+}
+
+// tagLine returns the line, starting from one, a ctags address is on: a line number or a pattern
+// like /^func main() {$/. It's the first line if the pattern isn't found.
+func tagLine(lines []string, address string) int {
+	address = strings.TrimSuffix(address, `;"`)
+	if n, err := strconv.Atoi(address); err == nil {
+		return n
+	}
+	if len(address) < 2 || address[0] != '/' && address[0] != '?' || address[len(address)-1] != address[0] {
+		return 1
+	}
+	pattern := address[1 : len(address)-1]
+	start := strings.HasPrefix(pattern, "^")
+	end := strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`)
+	pattern = strings.TrimPrefix(pattern, "^")
+	if end {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+	pattern = strings.NewReplacer(`\/`, "/", `\?`, "?", `\\`, `\`, `\$`, "$").Replace(pattern)
+	for i, line := range lines {
+		switch {
+		case start && end && line == pattern,
+			start && !end && strings.HasPrefix(line, pattern),
+			!start && end && strings.HasSuffix(line, pattern),
+			!start && !end && strings.Contains(line, pattern):
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// pickCommand opens one of the built in pickers, ie ":pick buffers", the files by default.
+// Sources can be shortened, ":pick files {dir}" finds the files under a directory.
+func pickCommand(editor *Editor, command Command) error {
+	name, args, _ := strings.Cut(strings.TrimSpace(command.Args), " ")
+	if name == "" {
+		name = "files"
+	}
+	matches := completePickerSource(editor, name)
+	if len(matches) != 1 {
+		return fmt.Errorf("No picker %s", name)
+	}
+	picker, err := pickerSources[matches[0]](editor, strings.TrimSpace(args))
+	if err != nil {
+		return err
+	}
+	editor.OpenPicker(picker)
+	return nil
+}
+
+// completePickerSource completes the names of the built in pickers.
+func completePickerSource(editor *Editor, arg string) []string {
+	names := []string{}
+	for name := range pickerSources {
+		if strings.HasPrefix(name, arg) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pickerKey opens one of the built in pickers.
+func pickerKey(source string) NormalCommand {
+	return func(editor *Editor) error {
+		picker, err := pickerSources[source](editor, "")
+		if err != nil {
+			return err
+		}
+		editor.OpenPicker(picker)
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestPicker(t *testing.T) {
+	Convey("Editor with a picker", t, func() {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "/proj/main.go", []byte("package main\n"), 0644)
+		afero.WriteFile(fs, "/proj/src/lib.go", []byte("package lib\n\n// Tagged is tagged.\nfunc Tagged() {\n}\n"), 0644)
+		afero.WriteFile(fs, "/proj/src/library.txt", []byte("books\n"), 0644)
+		afero.WriteFile(fs, "/proj/src/tags", []byte("!_TAG_FILE_FORMAT\t2\nTagged\tlib.go\t/^func Tagged() {$/;\"\tf\n"), 0644)
+		afero.WriteFile(fs, "/proj/.gitignore", []byte("tags\n"), 0644)
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		work := make(chan func(), 1024)
+		editor.SetPost(func(f func()) { work <- f })
+		run := func(done func() bool) {
+			deadline := time.After(time.Second)
+			for !done() {
+				select {
+				case f := <-work:
+					f()
+				case <-time.After(time.Millisecond):
+				case <-deadline:
+					So(done(), ShouldBeTrue)
+					return
+				}
+			}
+		}
+		typePicker := func(text string) {
+			for _, r := range text {
+				editor.HandlePickerKey(KeyEvent{Rune: r})
+			}
+		}
+		texts := func() []string {
+			texts := []string{}
+			for _, item := range editor.PickerMatches() {
+				texts = append(texts, item.Text)
+			}
+			return texts
+		}
+
+		Convey("files are found as they're walked", func() {
+			So(editor.ExecuteCommand("pick files /proj"), ShouldBeNil)
+			run(func() bool { return len(editor.PickerMatches()) == 4 && !editor.picker.streaming })
+			So(texts(), ShouldContain, "src/lib.go")
+			So(texts(), ShouldNotContain, "src/tags")
+
+			Convey("and ranked as the name is typed", func() {
+				typePicker("lib")
+				So(texts(), ShouldResemble, []string{"src/lib.go", "src/library.txt"})
+				run(func() bool { return editor.PickerPreview() != nil })
+				So(editor.PickerPreview(), ShouldResemble, []string{"package lib", "", "// Tagged is tagged.", "func Tagged() {", "}"})
+
+				editor.HandlePickerKey(KeyEvent{Key: KeyDown})
+				So(editor.PickerSelected(), ShouldEqual, 1)
+				run(func() bool { return len(editor.PickerPreview()) == 1 })
+				So(editor.PickerPreview(), ShouldResemble, []string{"books"})
+
+				editor.HandlePickerKey(KeyEvent{Key: KeyBackspace})
+				So(texts(), ShouldResemble, []string{"src/lib.go", "src/library.txt"})
+				So(editor.PickerSelected(), ShouldEqual, 0)
+				editor.HandlePickerKey(KeyEvent{Rune: 'u', Mod: ModCtrl})
+				So(editor.PickerMatches(), ShouldHaveLength, 4)
+			})
+
+			Convey("and drawn over the panes", func() {
+				typePicker("lib")
+				run(func() bool { return editor.PickerPreview() != nil })
+				grid := NewRuneGrid(80, 20)
+				grid.RenderEditor(&editor)
+				rows := []string{}
+				for _, row := range grid.Cells() {
+					rows = append(rows, string(row))
+				}
+				So(rows[2], ShouldContainSubstring, "┌─ Files ─")
+				So(rows[3], ShouldContainSubstring, "│ > lib")
+				So(rows[3], ShouldContainSubstring, "2/4")
+				So(rows[4], ShouldContainSubstring, "│ src/lib.go ")
+				So(rows[3], ShouldContainSubstring, "│ package lib")
+				So(grid.Styles()[4][10], ShouldEqual, StylePickerSelected)
+				So(grid.Styles()[5][14], ShouldEqual, StylePickerMatch)
+				x, y, ok := editor.FloatCursor()
+				So(ok, ShouldBeTrue)
+				So([]int{x, y}, ShouldResemble, []int{15, 3})
+			})
+
+			Convey("Enter opens the selected file", func() {
+				typePicker("lib")
+				So(editor.HandlePickerKey(KeyEvent{Key: KeyEnter}), ShouldBeNil)
+				So(editor.Picker(), ShouldBeNil)
+				So(editor.CurrentPane().Buffer().Filename(), ShouldEqual, "/proj/src/lib.go")
+			})
+
+			Convey("Escape closes the picker", func() {
+				editor.HandlePickerKey(KeyEvent{Key: KeyEscape})
+				So(editor.Picker(), ShouldBeNil)
+			})
+		})
+
+		Convey("buffers are switched to", func() {
+			editor.AddBuffer(bufferWithText("one\n")).SetFilename("one.txt")
+			two := editor.AddBuffer(bufferWithText("two\n"))
+			two.SetFilename("two.txt")
+			So(editor.ExecuteCommand("pick buf"), ShouldBeNil)
+			So(texts(), ShouldContain, "two.txt")
+			typePicker("two")
+			run(func() bool { return editor.PickerPreview() != nil })
+			So(editor.PickerPreview(), ShouldResemble, []string{"two"})
+			editor.HandlePickerKey(KeyEvent{Key: KeyEnter})
+			So(editor.CurrentPane().Buffer(), ShouldEqual, two)
+		})
+
+		Convey("commands typed before are run again", func() {
+			So(editor.ExecuteCommand("pick history"), ShouldNotBeNil)
+			for _, line := range []string{"set number", "set nonumber"} {
+				editor.EnterCommandMode()
+				editor.CommandLine().SetText(line)
+				editor.ExecuteCommandLine()
+			}
+			So(editor.ExecuteCommand("pick history"), ShouldBeNil)
+			So(texts(), ShouldResemble, []string{"set nonumber", "set number"})
+			editor.HandlePickerKey(KeyEvent{Rune: 'n', Mod: ModCtrl})
+			editor.HandlePickerKey(KeyEvent{Key: KeyEnter})
+			So(editor.Settings().Number, ShouldBeTrue)
+			So(editor.CommandLine().History(), ShouldResemble, []string{"set nonumber", "set number"})
+		})
+
+		Convey("commands are started on the command line", func() {
+			So(editor.ExecuteCommand("pick commands"), ShouldBeNil)
+			typePicker("vspl")
+			So(texts()[0], ShouldEqual, "vsplit")
+			So(editor.PickerMatches()[0].Detail, ShouldEqual, "vs[plit]")
+			editor.HandlePickerKey(KeyEvent{Key: KeyEnter})
+			So(editor.Mode(), ShouldEqual, CommandMode)
+			So(editor.CommandLine().Text(), ShouldEqual, "vsplit ")
+		})
+
+		Convey("help topics are previewed and shown", func() {
+			So(editor.ExecuteCommand("pick help"), ShouldBeNil)
+			typePicker("complet")
+			run(func() bool { return editor.PickerPreview() != nil })
+			So(strings.Join(editor.PickerPreview(), " "), ShouldContainSubstring, "Ctrl-N")
+			editor.HandlePickerKey(KeyEvent{Key: KeyEnter})
+			So(editor.Message().Text, ShouldStartWith, "Completion:\n")
+		})
+
+		Convey("tags are symbols without a language server", func() {
+			editor.OpenFile("/proj/src/lib.go")
+			So(editor.ExecuteCommand("pick symbols"), ShouldBeNil)
+			run(func() bool { return len(editor.PickerMatches()) == 1 && editor.PickerPreview() != nil })
+			So(editor.PickerMatches()[0].Detail, ShouldEqual, "f lib.go")
+			So(editor.PickerPreview()[0], ShouldEqual, "package lib")
+			editor.HandlePickerKey(KeyEvent{Key: KeyEnter})
+			_, line := editor.CurrentPane().Cursor().Position()
+			So(line, ShouldEqual, 4)
+		})
+
+		Convey("items are added while it's open", func() {
+			picker := &Picker{Items: []PickerItem{{Text: "first"}}}
+			editor.OpenPicker(picker)
+			typePicker("f")
+			editor.AddPickerItems(picker, []PickerItem{{Text: "fast"}, {Text: "f"}})
+			So(texts(), ShouldResemble, []string{"f", "fast", "first"})
+			So(editor.PickerSelected(), ShouldEqual, 2)
+			editor.ClosePicker()
+			editor.AddPickerItems(picker, []PickerItem{{Text: "late"}})
+			So(editor.PickerMatches(), ShouldBeEmpty)
+		})
+
+		Convey("an unknown source is an error", func() {
+			So(fmt.Sprint(editor.ExecuteCommand("pick nothing")), ShouldEqual, "No picker nothing")
+		})
+	})
+
+	Convey("Tag addresses", t, func() {
+		lines := []string{"package a", "func a() {", "}", "a/b"}
+		So(tagLine(lines, "3"), ShouldEqual, 3)
+		So(tagLine(lines, `/^func a() {$/;"`), ShouldEqual, 2)
+		So(tagLine(lines, `/a\/b/`), ShouldEqual, 4)
+		So(tagLine(lines, `/missing/`), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,103 @@
+// Package plugin is the API of plugins compiled into jkl.
+//
+// A plugin is a Go package that calls Register from init(). It is built into jkl by listing its
+// import path in the plugin manifest and running "jkl --rebuild", which imports it from the
+// generated plugins.go. Once the editor starts, each plugin's Setup is called with a Host to add
+// commands, key mappings, options, event listeners and status line items through.
+//
+// The API only grows within an APIVersion, a plugin built against it keeps compiling.
+package plugin
+
+import (
+	"errors"
+	"sync"
+)
+
+// APIVersion is the version of the plugin API, it changes when the API changes incompatibly.
+const APIVersion = 1
+
+// Plugin is a plugin compiled into the editor.
+type Plugin struct {
+	Name  string
+	Setup func(host Host) error
+}
+
+// Host is the editor as plugins see it. It is only used from the editor's main loop,
+// where Setup and the functions plugins register are called.
+type Host interface {
+	// AddCommand adds an ex command, name can mark the shortest abbreviation with brackets, ie "wc[ount]".
+	AddCommand(name string, run func(args string) error) error
+	// MapKeys binds a key sequence in normal mode.
+	MapKeys(keys string, run func() error)
+	// AddOption adds an option for ":set", value is a *bool, *int or *string holding its default.
+	AddOption(name string, value interface{}) error
+	// On calls listener each time the event happens.
+	On(event EventType, listener func(event Event))
+	// AddStatusItem adds the status line item "%{name}", see DefaultStatusLine.
+	AddStatusItem(name string, item func(buffer Buffer) string) error
+	// CurrentBuffer returns the buffer being edited, nil if there isn't one.
+	CurrentBuffer() Buffer
+	// Echo shows a message.
+	Echo(text string)
+}
+
+// Buffer is the text of a file being edited, lines are numbered from 1.
+type Buffer interface {
+	Name() string
+	Filename() string
+	Modified() bool
+	LineCount() int
+	GetLine(line int) (string, error)
+	GetLines(first, last int) ([]string, error)
+	ReplaceLines(first, last int, lines []string) error
+	InsertLines(after int, lines []string) error
+}
+
+// EventType is something that happens in the editor that plugins can listen for.
+type EventType string
+
+// BufferOpened is sent once a file is read into a new buffer, BufferSaved once a buffer is written
+// to its file, BufferChanged after a buffer's text changed and ModeChanged when the input mode does.
+// Changes are reported once per redraw, not per edit.
+const (
+	BufferOpened  EventType = "BufferOpened"
+	BufferSaved   EventType = "BufferSaved"
+	BufferChanged EventType = "BufferChanged"
+	ModeChanged   EventType = "ModeChanged"
+)
+
+// Event is passed to listeners, Mode is the new mode for ModeChanged and Buffer is nil for it.
+type Event struct {
+	Type   EventType
+	Buffer Buffer
+	Mode   string
+}
+
+var (
+	mutex   sync.Mutex
+	plugins []Plugin
+)
+
+// Register adds a plugin, it is called from the plugin's init().
+func Register(p Plugin) error {
+	if p.Name == "" || p.Setup == nil {
+		return errors.New("Plugin needs a name and a setup function")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, registered := range plugins {
+		if registered.Name == p.Name {
+			return errors.New("Plugin already registered: " + p.Name)
+		}
+	}
+	plugins = append(plugins, p)
+	return nil
+}
+
+// Registered returns the registered plugins in the order they registered.
+func Registered() []Plugin {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]Plugin{}, plugins...)
+}
@@ -0,0 +1,31 @@
+package plugin
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegister(t *testing.T) {
+	Convey("Registering plugins", t, func() {
+		plugins = nil
+		setup := func(host Host) error { return nil }
+
+		So(Register(Plugin{Name: "one", Setup: setup}), ShouldBeNil)
+		So(Register(Plugin{Name: "two", Setup: setup}), ShouldBeNil)
+
+		Convey("keeps them in order", func() {
+			registered := Registered()
+			So(registered, ShouldHaveLength, 2)
+			So(registered[0].Name, ShouldEqual, "one")
+			So(registered[1].Name, ShouldEqual, "two")
+		})
+
+		Convey("rejects duplicates and incomplete plugins", func() {
+			So(Register(Plugin{Name: "one", Setup: setup}), ShouldNotBeNil)
+			So(Register(Plugin{Name: "three"}), ShouldNotBeNil)
+			So(Register(Plugin{Setup: setup}), ShouldNotBeNil)
+			So(Registered(), ShouldHaveLength, 2)
+		})
+	})
+}
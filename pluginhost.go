@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dcbishop/jkl/plugin"
+)
+
+// pluginHost is the editor as a plugin sees it, see plugin.Host.
+type pluginHost struct {
+	editor *Editor
+}
+
+// SetupPlugins calls the setup of each plugin, usually those from plugin.Registered.
+// A plugin that fails doesn't stop the others, the first error is returned.
+func (editor *Editor) SetupPlugins(plugins []plugin.Plugin) error {
+	var first error
+	for _, p := range plugins {
+		if err := p.Setup(pluginHost{editor: editor}); err != nil && first == nil {
+			first = fmt.Errorf("Plugin %s: %s", p.Name, err)
+		}
+	}
+	return first
+}
+
+func (host pluginHost) AddCommand(name string, run func(args string) error) error {
+	def := CommandDefinition{Name: name}
+	if def.FullName() == "" || host.editor.LookupCommand(def.FullName()) != nil {
+		return fmt.Errorf("Command already exists: %s", def.FullName())
+	}
+	def.Run = func(editor *Editor, command Command) error {
+		return run(command.Args)
+	}
+	host.editor.RegisterCommand(def)
+	return nil
+}
+
+func (host pluginHost) MapKeys(keys string, run func() error) {
+	host.editor.MapNormal(keys, func(editor *Editor) error {
+		return run()
+	})
+}
+
+func (host pluginHost) AddOption(name string, value interface{}) error {
+	return host.editor.Settings().addOption(name, value)
+}
+
+// pluginEvents are the editor's events that plugins hear about.
+var pluginEvents = map[plugin.EventType]EventName{
+	plugin.BufferOpened:  BufRead,
+	plugin.BufferSaved:   BufWritePost,
+	plugin.BufferChanged: TextChanged,
+	plugin.ModeChanged:   ModeChanged,
+}
+
+func (host pluginHost) On(event plugin.EventType, listener func(event plugin.Event)) {
+	name, ok := pluginEvents[event]
+	if !ok {
+		return
+	}
+	host.editor.events.Subscribe(name, "", func(e EditorEvent) {
+		converted := plugin.Event{Type: event, Buffer: pluginBuffer(e.Buffer)}
+		if e.Name == ModeChanged {
+			converted.Mode = e.Mode.String()
+		}
+		listener(converted)
+	})
+}
+
+func (host pluginHost) AddStatusItem(name string, item func(buffer plugin.Buffer) string) error {
+	editor := host.editor
+	if name == "" || strings.ContainsAny(name, "{}") {
+		return fmt.Errorf("Invalid status line item: %q", name)
+	}
+	if _, ok := editor.statusItems[name]; ok {
+		return fmt.Errorf("Status line item already exists: %s", name)
+	}
+	if editor.statusItems == nil {
+		editor.statusItems = map[string]func(plugin.Buffer) string{}
+	}
+	editor.statusItems[name] = item
+	return nil
+}
+
+func (host pluginHost) CurrentBuffer() plugin.Buffer {
+	return pluginBuffer(host.editor.CurrentPane().Buffer())
+}
+
+func (host pluginHost) Echo(text string) {
+	host.editor.Echo(text)
+}
+
+// pluginBuffer returns the buffer as a plugin.Buffer, keeping nil a nil interface.
+func pluginBuffer(buffer *Buffer) plugin.Buffer {
+	if buffer == nil {
+		return nil
+	}
+	return buffer
+}
+
+// expandStatusItems replaces the "%{name}" items of a status line format added by plugins.
+func (editor *Editor) expandStatusItems(format string, pane *Pane) string {
+	if !strings.Contains(format, "%{") {
+		return format
+	}
+
+	expanded := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			expanded.WriteByte(format[i])
+			continue
+		}
+
+		end := strings.IndexByte(format[i:], '}')
+		if format[i+1] != '{' || end == -1 {
+			expanded.WriteString(format[i : i+2])
+			i++
+			continue
+		}
+
+		text := ""
+		if item, ok := editor.statusItems[format[i+2:i+end]]; ok && pane.Buffer() != nil {
+			text = item(pane.Buffer())
+		}
+		expanded.WriteString(strings.ReplaceAll(text, "%", "%%"))
+		i += end
+	}
+	return expanded.String()
+}
+
+// addOption adds an option, value is a *bool, *int or *string holding its default.
+func (settings *Settings) addOption(name string, value interface{}) error {
+	switch value.(type) {
+	case *bool, *int, *string:
+	default:
+		return errors.New("Option needs a *bool, *int or *string")
+	}
+	if name == "" || settings.setting(name) != nil {
+		return fmt.Errorf("Option already exists: %s", name)
+	}
+	if settings.options == nil {
+		settings.options = map[string]interface{}{}
+	}
+	settings.options[name] = value
+	return nil
+}
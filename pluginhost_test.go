@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dcbishop/jkl/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestPluginHost(t *testing.T) {
+	Convey("Editor with a plugin set up", t, func() {
+		fs := afero.NewMemMapFs()
+		editor := NewEditor(fs)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = "%f %{words}%%"
+
+		var commandArgs string
+		keys := 0
+		verbose := false
+		events := []plugin.Event{}
+		p := plugin.Plugin{Name: "words", Setup: func(host plugin.Host) error {
+			So(host.CurrentBuffer(), ShouldBeNil)
+			So(host.AddCommand("wc[ount]", func(args string) error {
+				commandArgs = args
+				host.Echo(host.CurrentBuffer().Name())
+				return nil
+			}), ShouldBeNil)
+			host.MapKeys("gw", func() error {
+				keys++
+				return nil
+			})
+			So(host.AddOption("wordsverbose", &verbose), ShouldBeNil)
+			So(host.AddStatusItem("words", func(buffer plugin.Buffer) string {
+				return "3 words 100%"
+			}), ShouldBeNil)
+			for _, event := range []plugin.EventType{plugin.BufferOpened, plugin.BufferSaved, plugin.BufferChanged, plugin.ModeChanged} {
+				host.On(event, func(event plugin.Event) { events = append(events, event) })
+			}
+			return nil
+		}}
+		So(editor.SetupPlugins([]plugin.Plugin{p}), ShouldBeNil)
+
+		afero.WriteFile(fs, "notes.txt", []byte("one two three\n"), 0644)
+		editor.OpenFile("notes.txt")
+		So(events, ShouldHaveLength, 1)
+		So(events[0].Type, ShouldEqual, plugin.BufferOpened)
+		So(events[0].Buffer.Name(), ShouldEqual, "notes.txt")
+
+		Convey("commands, mappings and options can be added", func() {
+			So(editor.ExecuteCommand("wc here"), ShouldBeNil)
+			So(commandArgs, ShouldEqual, "here")
+			So(editor.Message().Text, ShouldEqual, "notes.txt")
+
+			editor.HandleNormalKey('g')
+			editor.HandleNormalKey('w')
+			So(keys, ShouldEqual, 1)
+
+			So(editor.ExecuteCommand("set wordsverbose"), ShouldBeNil)
+			So(verbose, ShouldBeTrue)
+			value, _ := editor.Settings().Get("wordsverbose")
+			So(value, ShouldEqual, "true")
+		})
+
+		Convey("names that are taken are refused", func() {
+			host := pluginHost{editor: &editor}
+			So(host.AddCommand("wcount", func(string) error { return nil }), ShouldNotBeNil)
+			So(host.AddCommand("set", func(string) error { return nil }), ShouldNotBeNil)
+			So(host.AddOption("wrap", new(bool)), ShouldNotBeNil)
+			So(host.AddOption("other", 1), ShouldNotBeNil)
+			So(host.AddStatusItem("words", nil), ShouldNotBeNil)
+		})
+
+		Convey("status line items are drawn", func() {
+			grid := NewRuneGrid(30, 3)
+			grid.RenderEditor(&editor)
+			So(string(grid.Cells()[1][:24]), ShouldEqual, "notes.txt 3 words 100%% ")
+		})
+
+		Convey("listeners hear about changes, saves and modes", func() {
+			editor.NotifyChanges()
+			editor.HandleNormalKey('i')
+			editor.InsertText("four ")
+			editor.InsertText("five ")
+			editor.NotifyChanges()
+			editor.NotifyChanges()
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+
+			types := []plugin.EventType{}
+			for _, event := range events {
+				types = append(types, event.Type)
+			}
+			So(types, ShouldResemble, []plugin.EventType{plugin.BufferOpened, plugin.ModeChanged, plugin.BufferChanged, plugin.BufferSaved})
+			So(events[1].Mode, ShouldEqual, "insert")
+			So(events[1].Buffer, ShouldBeNil)
+		})
+	})
+
+	Convey("A failing plugin doesn't stop the others", t, func() {
+		editor := NewEditor(GetTestFs())
+		ran := false
+		err := editor.SetupPlugins([]plugin.Plugin{
+			{Name: "broken", Setup: func(plugin.Host) error { return errors.New("Oops") }},
+			{Name: "fine", Setup: func(plugin.Host) error { ran = true; return nil }},
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Plugin broken: Oops")
+		So(ran, ShouldBeTrue)
+	})
+}
+
+func TestWriteBuffer(t *testing.T) {
+	Convey("Editor with a modified buffer", t, func() {
+		fs := afero.NewMemMapFs()
+		editor := NewEditor(fs)
+		buffer := editor.AddBuffer(bufferWithText("text\n"))
+		editor.SwitchToBuffer(buffer)
+		buffer.SetModified(true)
+
+		Convey(":w needs a file name for a new buffer", func() {
+			So(editor.ExecuteCommand("w"), ShouldNotBeNil)
+		})
+
+		Convey(":w name writes it and names the buffer", func() {
+			So(editor.ExecuteCommand("w new.txt"), ShouldBeNil)
+			data, _ := afero.ReadFile(fs, "new.txt")
+			So(string(data), ShouldEqual, "text\n")
+			So(buffer.Filename(), ShouldEqual, "new.txt")
+			So(buffer.Modified(), ShouldBeFalse)
+			So(editor.Message().Text, ShouldEqual, `"new.txt" 1L, 5B written`)
+		})
+	})
+}
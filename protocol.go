@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken between a Server and its clients.
+//
+// The protocol is a stream of JSON objects, one per line, over a Unix socket. Every message
+// has a "type" field, the other fields depend on the type and are left out when zero.
+//
+// The client starts by sending
+//
+//	{"type":"hello","version":1}
+//
+// and the server answers with its own hello, or an error message and closes the connection if
+// it doesn't speak that version. After the handshake the client either attaches or sends requests.
+//
+// Client to server:
+//
+//	attach   {"width":W,"height":H}              show the session in a terminal of this size
+//	resize   {"width":W,"height":H}              the terminal changed size
+//	key      {"key":K,"rune":R,"mod":M}          a key press, see KeyEvent
+//	mouse    {"x":X,"y":Y,"button":B,"action":A,"mod":M}
+//	paste    {"text":T}                          text pasted all at once
+//	focus    {"focused":F}                       the terminal gained or lost focus
+//	redraw   {}                                  send the whole screen again
+//	detach   {}                                  detach every attached client
+//	open     {"text":F,"line":L,"wait":W}        open a file and go to the line if it isn't 0
+//	restart  {}                                  restart with the rebuilt executable, see App.Restart
+//
+// Server to client:
+//
+//	hello    {"version":V}
+//	draw     {"width":W,"height":H,"full":F,"spans":[...],"cursor":{"x":X,"y":Y},"mouse":M}
+//	ok       {}                                  a request succeeded
+//	error    {"error":E}                         a request failed
+//	detach   {}                                  the client was detached, the session goes on
+//	exit     {}                                  the session ended
+//	restart  {}                                  the session is restarting, attach again once it's back
+//	closed   {"text":F}                          the buffer of a file opened with wait was deleted
+//
+// A draw holds the runs of cells that changed since the last draw sent to the client, each
+// span is {"x":X,"y":Y,"text":T,"styles":[...]} with one Style per character of the text.
+// A full draw replaces the whole screen. The cursor is left out when it isn't shown and mouse
+// is true when the terminal should report mouse events. Input events are only accepted from
+// attached clients. The screen has the size of the client that most recently attached or resized.
+// Instances started in a terminal also listen, but only accept requests, not attaching clients.
+//
+// A CollabServer speaks the same protocol, with the same handshake, to editors sharing buffers:
+//
+//	join     {"text":N,"content":C}              client: share the document N, created with C if it's new
+//	joined   {"site":S,"ops":[...]}              server: the site of the client and the document so far
+//	ops      {"site":S,"ops":[...]}              edits of a site, see DocOp, the server adds the site
+//	cursor   {"site":S,"anchor":A,"mark":M}      where a site's cursor is and where its selection starts
+//	left     {"site":S}                          server: the site stopped sharing the document
+//
+// Anchors are CharIDs, see Document.Anchor, the mark is left out when nothing is selected.
+const ProtocolVersion = 1
+
+// WireMessage is a message of the wire protocol, see ProtocolVersion.
+type WireMessage struct {
+	Type    string      `json:"type"`
+	Version int         `json:"version,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Width   int         `json:"width,omitempty"`
+	Height  int         `json:"height,omitempty"`
+	Key     Key         `json:"key,omitempty"`
+	Rune    rune        `json:"rune,omitempty"`
+	Mod     Modifier    `json:"mod,omitempty"`
+	X       int         `json:"x,omitempty"`
+	Y       int         `json:"y,omitempty"`
+	Button  MouseButton `json:"button,omitempty"`
+	Action  MouseAction `json:"action,omitempty"`
+	Text    string      `json:"text,omitempty"`
+	Line    int         `json:"line,omitempty"`
+	Wait    bool        `json:"wait,omitempty"`
+	Focused bool        `json:"focused,omitempty"`
+	Full    bool        `json:"full,omitempty"`
+	Spans   []WireSpan  `json:"spans,omitempty"`
+	Cursor  *WireCursor `json:"cursor,omitempty"`
+	Mouse   bool        `json:"mouse,omitempty"`
+	Site    int         `json:"site,omitempty"`
+	Content string      `json:"content,omitempty"`
+	Ops     []DocOp     `json:"ops,omitempty"`
+	Anchor  *CharID     `json:"anchor,omitempty"`
+	Mark    *CharID     `json:"mark,omitempty"`
+}
+
+// WireSpan is a run of cells in a draw message.
+type WireSpan struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Text   string  `json:"text"`
+	Styles []Style `json:"styles"`
+}
+
+// WireCursor is where the cursor is shown after a draw message.
+type WireCursor struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// WireConn reads and writes messages of the wire protocol.
+type WireConn struct {
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+}
+
+// NewWireConn constructs a WireConn on a connection.
+func NewWireConn(conn io.ReadWriteCloser) WireConn {
+	return WireConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Read returns the next message.
+func (wire *WireConn) Read() (WireMessage, error) {
+	message := WireMessage{}
+	line, err := wire.reader.ReadBytes('\n')
+	if err != nil {
+		return message, err
+	}
+	err = json.Unmarshal(line, &message)
+	return message, err
+}
+
+// Write sends a message.
+func (wire *WireConn) Write(message WireMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = wire.conn.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the connection.
+func (wire *WireConn) Close() error {
+	return wire.conn.Close()
+}
+
+// eventMessage returns the message carrying an input event, false if the event isn't sent to servers.
+func eventMessage(event Event) (WireMessage, bool) {
+	switch data := event.Data.(type) {
+	case KeyEvent:
+		return WireMessage{Type: "key", Key: data.Key, Rune: data.Rune, Mod: data.Mod}, true
+	case MouseEvent:
+		return WireMessage{Type: "mouse", X: data.X, Y: data.Y, Button: data.Button, Action: data.Action, Mod: data.Mod}, true
+	case PasteEvent:
+		return WireMessage{Type: "paste", Text: data.Text}, true
+	case FocusEvent:
+		return WireMessage{Type: "focus", Focused: data.Focused}, true
+	case ResizeEvent:
+		return WireMessage{Type: "resize", Width: data.Width, Height: data.Height}, true
+	}
+	return WireMessage{}, false
+}
+
+// Event returns the input event carried by the message, false if it doesn't carry one.
+func (message WireMessage) Event() (Event, bool) {
+	switch message.Type {
+	case "key":
+		return Event{KeyEvent{Key: message.Key, Rune: message.Rune, Mod: message.Mod}}, true
+	case "mouse":
+		return Event{MouseEvent{X: message.X, Y: message.Y, Button: message.Button, Action: message.Action, Mod: message.Mod}}, true
+	case "paste":
+		return Event{PasteEvent{Text: message.Text}}, true
+	case "focus":
+		return Event{FocusEvent{Focused: message.Focused}}, true
+	case "resize":
+		return Event{ResizeEvent{Width: message.Width, Height: message.Height}}, true
+	}
+	return Event{}, false
+}
+
+// wireSpans copies spans of a RuneGrid into a draw message.
+func wireSpans(spans []Span) []WireSpan {
+	wire := make([]WireSpan, len(spans))
+	for i, span := range spans {
+		wire[i] = WireSpan{
+			X:      span.X,
+			Y:      span.Y,
+			Text:   string(span.Cells),
+			Styles: append([]Style{}, span.Styles...),
+		}
+	}
+	return wire
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWireMessageEvents(t *testing.T) {
+	Convey("Input events survive being sent as messages", t, func() {
+		for _, event := range []Event{
+			{KeyEvent{Key: KeyRune, Rune: 'x', Mod: ModCtrl}},
+			{KeyEvent{Key: KeyF5}},
+			{MouseEvent{X: 3, Y: 4, Button: MouseLeft, Action: MouseDrag, Mod: ModShift}},
+			{PasteEvent{Text: "pasted\ntext"}},
+			{FocusEvent{Focused: true}},
+			{ResizeEvent{Width: 100, Height: 30}},
+		} {
+			message, ok := eventMessage(event)
+			So(ok, ShouldBeTrue)
+			decoded, ok := message.Event()
+			So(ok, ShouldBeTrue)
+			So(decoded, ShouldResemble, event)
+		}
+	})
+
+	Convey("Other messages don't carry events", t, func() {
+		_, ok := eventMessage(Event{"something"})
+		So(ok, ShouldBeFalse)
+		_, ok = WireMessage{Type: "draw"}.Event()
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestWireConn(t *testing.T) {
+	Convey("Messages are sent one per line", t, func() {
+		a, b := net.Pipe()
+		sender, receiver := NewWireConn(a), NewWireConn(b)
+		defer sender.Close()
+		defer receiver.Close()
+
+		grid := NewRuneGrid(2, 1)
+		sent := WireMessage{
+			Type:   "draw",
+			Width:  10,
+			Height: 2,
+			Spans:  wireSpans(grid.Diff(nil)),
+			Cursor: &WireCursor{X: 1},
+		}
+		go sender.Write(sent)
+		received, err := receiver.Read()
+		So(err, ShouldBeNil)
+		So(received, ShouldResemble, sent)
+		So(received.Spans[0].Text, ShouldEqual, "\x00\x00")
+	})
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dcbishop/jkl/plugin"
+)
+
+// restoreVariable is the environment variable a restarting jkl passes its buffers to the new process in.
+const restoreVariable = "JKL_RESTORE"
+
+// RestartEvent asks the App to restart, see App.Restart. Done is called with the result before it does.
+type RestartEvent struct {
+	Done func(err error)
+}
+
+// restoredBuffer is a file that was open when jkl restarted.
+type restoredBuffer struct {
+	File    string `json:"file"`
+	X       int    `json:"x"`
+	Line    int    `json:"line"`
+	Current bool   `json:"current,omitempty"`
+}
+
+// ConfigFile returns the path of a file in the jkl configuration directory.
+func ConfigFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "jkl", name), nil
+}
+
+// PluginManifest returns the path of the plugin manifest, "plugins" in the jkl configuration directory.
+func PluginManifest() (string, error) {
+	return ConfigFile("plugins")
+}
+
+// ReadPluginManifest returns the import paths of the plugins listed in a manifest, one per line.
+// Blank lines and lines starting with '#' are ignored, a missing manifest lists no plugins.
+func ReadPluginManifest(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	imports := []string{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t\"\\`") {
+			return nil, fmt.Errorf("%s:%d: Invalid import path: %s", path, number, line)
+		}
+		imports = append(imports, line)
+	}
+	return imports, scanner.Err()
+}
+
+// GeneratePlugins returns the source of plugins.go, which links the plugins into jkl by importing them.
+func GeneratePlugins(imports []string) ([]byte, error) {
+	source := bytes.Buffer{}
+	source.WriteString("// Code generated by \"jkl --rebuild\" from the plugin manifest. DO NOT EDIT.\n\n")
+	source.WriteString("package main\n")
+	if len(imports) > 0 {
+		source.WriteString("\nimport (\n")
+		for _, path := range imports {
+			fmt.Fprintf(&source, "\t_ %s\n", strconv.Quote(path))
+		}
+		source.WriteString(")\n")
+	}
+	return format.Source(source.Bytes())
+}
+
+// Rebuild regenerates plugins.go in the jkl source directory from the manifest and builds jkl to output.
+// Go is run without network access, so plugins and dependencies have to be in the vendor
+// directory, the module cache or GOPATH. If the build fails plugins.go is put back as it was.
+func Rebuild(source, manifest, output string) error {
+	pluginsFile := filepath.Join(source, "plugins.go")
+	previous, err := os.ReadFile(pluginsFile)
+	if err != nil {
+		return fmt.Errorf("No jkl source in %s, set JKL_SOURCE or --source", source)
+	}
+
+	imports, err := ReadPluginManifest(manifest)
+	if err != nil {
+		return err
+	}
+	generated, err := GeneratePlugins(imports)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(pluginsFile, generated, 0644); err != nil {
+		return err
+	}
+
+	built := output + ".new"
+	cmd := exec.Command("go", "build", "-o", built, ".")
+	cmd.Dir = source
+	cmd.Env = buildEnvironment(source)
+	if log, err := cmd.CombinedOutput(); err != nil {
+		os.WriteFile(pluginsFile, previous, 0644)
+		os.Remove(built)
+		return fmt.Errorf("Build failed: %s\n%s", err, log)
+	}
+	return os.Rename(built, output)
+}
+
+// buildEnvironment returns the environment go is run in to build the source directory offline.
+func buildEnvironment(source string) []string {
+	env := append(os.Environ(), "GOPROXY=off")
+	if _, err := os.Stat(filepath.Join(source, "go.mod")); err != nil {
+		return append(env, "GO111MODULE=off")
+	}
+	if info, err := os.Stat(filepath.Join(source, "vendor")); err == nil && info.IsDir() {
+		return append(env, "GOFLAGS=-mod=vendor")
+	}
+	return env
+}
+
+// RestartSession asks the named session, or the jkl with that name, to restart with its rebuilt executable.
+func RestartSession(name string) error {
+	wire, err := DialSession(name)
+	if err != nil {
+		return err
+	}
+	defer wire.Close()
+
+	if err := wire.Write(WireMessage{Type: "restart"}); err != nil {
+		return err
+	}
+	reply, err := wire.Read()
+	if err != nil {
+		return err
+	}
+	if reply.Type == "error" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// Restart stops the App and replaces the process with its executable, which was usually just rebuilt.
+// The files of the listed buffers are opened again with the cursor where it was and a session keeps
+// its name, attached terminals attach again. It refuses if a buffer has changes that aren't written.
+func (app *App) Restart() error {
+	for _, buffer := range app.editor.Buffers() {
+		if buffer.Modified() && buffer.Terminal() == nil {
+			return fmt.Errorf("No write since last change for buffer %q", buffer.Name())
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(app.editor.restoreState())
+	if err != nil {
+		return err
+	}
+
+	args := []string{executable}
+	if server, ok := app.UI.(*Server); ok {
+		args = append(args, "--daemon", "--session="+server.Name())
+		server.Restarting()
+	}
+	app.restartArgs = args
+	app.restartEnv = append(os.Environ(), restoreVariable+"="+string(state))
+	go app.Stop()
+	return nil
+}
+
+// ExecRestart replaces the process as Restart asked once Run has returned, it returns an error if it couldn't.
+// Nothing happens if the App wasn't restarting.
+func (app *App) ExecRestart() error {
+	if app.restartArgs == nil {
+		return nil
+	}
+	return execProcess(app.restartArgs[0], app.restartArgs, app.restartEnv)
+}
+
+// restoreState returns the files of the listed buffers and where their cursor is.
+func (editor *Editor) restoreState() []restoredBuffer {
+	state := []restoredBuffer{}
+	for _, buffer := range editor.Buffers() {
+		if !buffer.Listed() || buffer.Filename() == "" || buffer.Terminal() != nil {
+			continue
+		}
+		restored := restoredBuffer{File: buffer.Filename(), Line: 1}
+		for _, pane := range editor.Panes() {
+			if cursor := pane.cursors[buffer]; cursor != nil {
+				restored.X, restored.Line = cursor.Position()
+				break
+			}
+		}
+		restored.Current = buffer == editor.CurrentPane().Buffer()
+		state = append(state, restored)
+	}
+	return state
+}
+
+// restore opens the files of a restarted jkl again.
+func (editor *Editor) restore(state []restoredBuffer) {
+	var current *Buffer
+	for _, restored := range state {
+		buffer := editor.OpenFileAt(restored.File, restored.Line)
+		editor.CurrentPane().Cursor().Move(restoredPosition(buffer, restored.X, restored.Line))
+		if restored.Current {
+			current = buffer
+		}
+	}
+	if current != nil {
+		editor.SwitchToBuffer(current)
+	}
+}
+
+// restoredPosition keeps a restored cursor in the buffer, the file may have shrunk while jkl was rebuilt.
+func restoredPosition(buffer *Buffer, x, line int) (int, int) {
+	if count := buffer.LineCount(); line > count {
+		line = count
+	}
+	if line < 1 {
+		line = 1
+	}
+	text, _ := buffer.GetLine(line)
+	if length := utf8.RuneCountInString(text); x >= length {
+		x = length - 1
+	}
+	if x < 0 {
+		x = 0
+	}
+	return x, line
+}
+
+// restartRequested handles a RestartEvent.
+func (app *App) restartRequested(event RestartEvent) {
+	err := app.Restart()
+	if event.Done != nil {
+		event.Done(err)
+	}
+	app.reportError(err)
+}
+
+// SetupPlugins sets up the plugins compiled into jkl, see plugin.Register.
+func SetupPlugins() func(*App) error {
+	return func(a *App) error {
+		err := a.Editor().SetupPlugins(plugin.Registered())
+		if err != nil {
+			a.Editor().EchoError(err)
+		}
+		return err
+	}
+}
+
+// RestoreBuffers opens the files that were open when jkl restarted, see App.Restart.
+func RestoreBuffers() func(*App) error {
+	return func(a *App) error {
+		data, ok := os.LookupEnv(restoreVariable)
+		if !ok {
+			return nil
+		}
+		os.Unsetenv(restoreVariable)
+
+		state := []restoredBuffer{}
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return err
+		}
+		a.Editor().restore(state)
+		return nil
+	}
+}
+
+// RebuildJkl rebuilds jkl with the plugins of the manifest, see Rebuild, and exits. The running
+// session of that name, or the default one if no name is given, is restarted with the new build.
+func RebuildJkl(source, manifest, session string) func(*App) error {
+	return func(a *App) error {
+		err := rebuildAndRestart(a, source, manifest, session)
+		if err != nil {
+			fmt.Fprintln(a.ErrOut, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+		return err
+	}
+}
+
+func rebuildAndRestart(a *App, source, manifest, session string) error {
+	if source == "" {
+		source = os.Getenv("JKL_SOURCE")
+	}
+	if source == "" {
+		source = "."
+	}
+	if manifest == "" {
+		var err error
+		if manifest, err = PluginManifest(); err != nil {
+			return err
+		}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if err := Rebuild(source, manifest, executable); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Out, "Rebuilt %s\n", executable)
+
+	name := session
+	if name == "" {
+		name = DefaultSession
+	}
+	if err := RestartSession(name); err != nil {
+		if session == "" && errors.Is(err, ErrNoSession) {
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(a.Out, "Restarted session %s\n", name)
+	return nil
+}
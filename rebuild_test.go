@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeFiles creates files under dir from a map of relative paths to contents.
+func writeFiles(dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+		So(os.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+	}
+}
+
+func TestPluginManifest(t *testing.T) {
+	Convey("Plugin manifests list import paths", t, func() {
+		dir, err := os.MkdirTemp("", "jkl-manifest")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		manifest := filepath.Join(dir, "plugins")
+
+		writeFiles(dir, map[string]string{"plugins": "# Plugins\n\nexample.com/one\n  example.com/two/sub  \n"})
+		imports, err := ReadPluginManifest(manifest)
+		So(err, ShouldBeNil)
+		So(imports, ShouldResemble, []string{"example.com/one", "example.com/two/sub"})
+
+		Convey("a missing manifest lists none", func() {
+			imports, err := ReadPluginManifest(filepath.Join(dir, "missing"))
+			So(err, ShouldBeNil)
+			So(imports, ShouldBeEmpty)
+		})
+
+		Convey("lines that aren't import paths are refused", func() {
+			writeFiles(dir, map[string]string{"plugins": "example.com/one\n\"quoted\"\n"})
+			_, err := ReadPluginManifest(manifest)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "plugins:2")
+		})
+	})
+
+	Convey("plugins.go imports the plugins", t, func() {
+		source, err := GeneratePlugins([]string{"example.com/one"})
+		So(err, ShouldBeNil)
+		So(string(source), ShouldContainSubstring, "\t_ \"example.com/one\"\n")
+
+		Convey("and is empty in the source tree", func() {
+			source, err := GeneratePlugins(nil)
+			So(err, ShouldBeNil)
+			current, err := os.ReadFile("plugins.go")
+			So(err, ShouldBeNil)
+			So(string(current), ShouldEqual, string(source))
+		})
+	})
+}
+
+func TestRebuild(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	Convey("Rebuilding a program with a plugin from GOPATH", t, func() {
+		dir, err := os.MkdirTemp("", "jkl-rebuild")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		gopath := filepath.Join(dir, "gopath")
+		source := filepath.Join(dir, "source")
+		writeFiles(dir, map[string]string{
+			"gopath/src/example.com/hello/hello.go": "package hello\n\nimport \"fmt\"\n\nfunc init() { fmt.Println(\"hello from a plugin\") }\n",
+			"source/main.go":                        "package main\n\nfunc main() {}\n",
+			"source/plugins.go":                     "package main\n",
+			"plugins":                               "example.com/hello\n",
+		})
+		oldGOPATH := os.Getenv("GOPATH")
+		os.Setenv("GOPATH", gopath)
+		defer os.Setenv("GOPATH", oldGOPATH)
+		output := filepath.Join(dir, "jkl")
+
+		So(Rebuild(source, filepath.Join(dir, "plugins"), output), ShouldBeNil)
+		out, err := exec.Command(output).Output()
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, "hello from a plugin\n")
+
+		Convey("a failing build leaves plugins.go as it was", func() {
+			writeFiles(dir, map[string]string{"plugins": "example.com/missing\n"})
+			err := Rebuild(source, filepath.Join(dir, "plugins"), output)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Build failed")
+			generated, _ := os.ReadFile(filepath.Join(source, "plugins.go"))
+			So(string(generated), ShouldContainSubstring, "example.com/hello")
+		})
+
+		Convey("a directory without plugins.go isn't jkl", func() {
+			So(Rebuild(dir, filepath.Join(dir, "plugins"), output), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRestart(t *testing.T) {
+	Convey("Editor with files open", t, func() {
+		files := map[string][]byte{"a.txt": []byte("one\ntwo\nthree\n"), "b.txt": []byte("one\ntwo\n")}
+		editor := NewEditor(GetCustomTestFs(files))
+		editor.OpenFiles([]string{"a.txt", "b.txt"})
+		editor.CurrentPane().Cursor().Move(2, 3)
+		editor.SwitchToBuffer(editor.Buffers()[1])
+		editor.CurrentPane().Cursor().Move(1, 2)
+
+		Convey("the files and cursors are restored after restarting", func() {
+			state := editor.restoreState()
+			So(state, ShouldResemble, []restoredBuffer{
+				{File: "a.txt", X: 2, Line: 3},
+				{File: "b.txt", X: 1, Line: 2, Current: true},
+			})
+
+			restarted := NewEditor(GetCustomTestFs(files))
+			restarted.restore(state)
+			So(restarted.Buffers(), ShouldHaveLength, 2)
+			So(restarted.CurrentPane().Buffer().Name(), ShouldEqual, "b.txt")
+			x, line := restarted.CurrentPane().Cursor().Position()
+			So([]int{x, line}, ShouldResemble, []int{1, 2})
+		})
+
+		Convey("cursors are kept in files that shrank while restarting", func() {
+			restarted := NewEditor(GetCustomTestFs(map[string][]byte{"a.txt": []byte("one\nab\n")}))
+			restarted.restore([]restoredBuffer{{File: "a.txt", X: 7, Line: 9}})
+			x, line := restarted.CurrentPane().Cursor().Position()
+			So([]int{x, line}, ShouldResemble, []int{1, 2})
+		})
+	})
+
+	Convey("A session asked to restart", t, func() {
+		withSession(func(app *App, server *Server) {
+			wire, _ := attach(20, 5)
+			defer wire.Close()
+			control, err := DialSession("test")
+			So(err, ShouldBeNil)
+			defer control.Close()
+
+			Convey("refuses with changes that aren't written", func() {
+				onMainLoop(app, func() {
+					buffer := app.Editor().AddBuffer(bufferWithText("text"))
+					buffer.SetModified(true)
+				})
+				So(control.Write(WireMessage{Type: "restart"}), ShouldBeNil)
+				reply := readMessageUntil(control, func(m WireMessage) bool { return m.Type != "draw" })
+				So(reply.Type, ShouldEqual, "error")
+				So(app.Running(), ShouldBeTrue)
+			})
+
+			Convey("stops and tells attached terminals to attach again", func() {
+				So(control.Write(WireMessage{Type: "restart"}), ShouldBeNil)
+				So(readMessageUntil(control, ofType("ok")).Type, ShouldEqual, "ok")
+				So(readMessageUntil(wire, func(m WireMessage) bool { return m.Type != "draw" }).Type, ShouldEqual, "restart")
+
+				deadline := time.Now().Add(time.Second)
+				for app.Running() && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				So(app.Running(), ShouldBeFalse)
+				So(app.restartArgs[1:], ShouldResemble, []string{"--daemon", "--session=test"})
+			})
+		})
+	})
+}
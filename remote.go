@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNoInstance is returned by FindSession when no jkl is running.
+var ErrNoInstance = errors.New("No running jkl found")
+
+// OpenFileAt opens a file in a new buffer in the current pane and moves the cursor to the line.
+// Lines start at 1, 0 leaves the cursor at the start of the file.
+func (editor *Editor) OpenFileAt(filename string, line int) *Buffer {
+	editor.LeaveVisualMode()
+	editor.LeaveTerminalMode()
+	editor.OpenFile(filename)
+
+	buffer := editor.LastBuffer()
+	if line > buffer.LineCount() {
+		line = buffer.LineCount()
+	}
+	if line > 0 {
+		editor.CurrentPane().Cursor().Move(0, line)
+	}
+	return buffer
+}
+
+// splitFileLine splits a "file:42" argument into the file and line, the line is 0 if there isn't one.
+// Existing files with a colon in their name are left alone.
+func splitFileLine(arg string) (string, int) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, 0
+	}
+	if i := strings.LastIndex(arg, ":"); i > 0 {
+		if line, err := strconv.Atoi(arg[i+1:]); err == nil && line > 0 {
+			return arg[:i], line
+		}
+	}
+	return arg, 0
+}
+
+// FindSession returns the name of the most recently started jkl that is running, found by its socket in the RuntimeDir.
+// Sockets left behind by instances that are no longer running are removed.
+func FindSession() (string, error) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return "", err
+	}
+
+	name, newest := "", time.Time{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		conn, err := net.Dial("unix", path)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+		}
+		if err != nil {
+			continue
+		}
+		conn.Close()
+
+		if name == "" || info.ModTime().After(newest) {
+			name, newest = strings.TrimSuffix(filepath.Base(path), ".sock"), info.ModTime()
+		}
+	}
+
+	if name == "" {
+		return "", ErrNoInstance
+	}
+	return name, nil
+}
+
+// RemoteOpen opens files in the named session, or the jkl FindSession finds if the name is empty.
+// Files can end in ":line". With wait it returns once all their buffers are deleted or the editor exits.
+func RemoteOpen(session string, files []string, wait bool) error {
+	if session == "" {
+		found, err := FindSession()
+		if err != nil {
+			return err
+		}
+		session = found
+	}
+
+	wire, err := DialSession(session)
+	if err != nil {
+		return err
+	}
+	defer wire.Close()
+
+	pending := 0
+	for _, file := range files {
+		filename, line := splitFileLine(file)
+		if absolute, err := filepath.Abs(filename); err == nil {
+			filename = absolute
+		}
+		if err := wire.Write(WireMessage{Type: "open", Text: filename, Line: line, Wait: wait}); err != nil {
+			return err
+		}
+
+	reply:
+		for {
+			message, err := wire.Read()
+			if err != nil {
+				return fmt.Errorf("Session %s closed the connection", session)
+			}
+			switch message.Type {
+			case "closed":
+				pending--
+			case "error":
+				return errors.New(message.Error)
+			case "ok":
+				break reply
+			}
+		}
+		if wait {
+			pending++
+		}
+	}
+
+	for pending > 0 {
+		message, err := wire.Read()
+		if err != nil || message.Type == "exit" {
+			return nil
+		}
+		if message.Type == "closed" {
+			pending--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dcbishop/jkl/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// onMainLoop runs f on the App's main loop and waits for it.
+func onMainLoop(app *App, f func()) {
+	done := make(chan interface{})
+	app.Post(func() {
+		f()
+		close(done)
+	})
+	<-done
+}
+
+func TestSplitFileLine(t *testing.T) {
+	Convey("Files can be given with a line number", t, func() {
+		name, line := splitFileLine("main.go:42")
+		So(name, ShouldEqual, "main.go")
+		So(line, ShouldEqual, 42)
+
+		name, line = splitFileLine("main.go")
+		So(name, ShouldEqual, "main.go")
+		So(line, ShouldEqual, 0)
+
+		name, line = splitFileLine("main.go:x")
+		So(name, ShouldEqual, "main.go:x")
+		So(line, ShouldEqual, 0)
+	})
+
+	Convey("Existing files with a colon in the name are left alone", t, func() {
+		dir, _ := os.MkdirTemp("", "jkl-remote")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "notes:3")
+		os.WriteFile(path, []byte("x"), 0600)
+
+		name, line := splitFileLine(path)
+		So(name, ShouldEqual, path)
+		So(line, ShouldEqual, 0)
+	})
+}
+
+func TestOpenFileAt(t *testing.T) {
+	Convey("Opening a file at a line", t, func() {
+		editor := NewEditor(GetCustomTestFs(map[string][]byte{"a": []byte("1\n2\n3\n")}))
+		buffer := editor.OpenFileAt("a", 2)
+		So(editor.CurrentPane().Buffer(), ShouldEqual, buffer)
+		_, line := editor.CurrentPane().Cursor().Position()
+		So(line, ShouldEqual, 2)
+
+		Convey("past the end goes to the last line", func() {
+			editor.OpenFileAt("a", 99)
+			_, line := editor.CurrentPane().Cursor().Position()
+			So(line, ShouldEqual, editor.LastBuffer().LineCount())
+		})
+
+		Convey("tells watchers when it is deleted", func() {
+			deleted := 0
+			editor.WhenDeleted(buffer, func() { deleted++ })
+			So(editor.DeleteBuffer(buffer, false), ShouldBeNil)
+			So(deleted, ShouldEqual, 1)
+			So(editor.DeleteBuffer(buffer, false), ShouldBeNil)
+			So(deleted, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRemoteOpen(t *testing.T) {
+	Convey("With a running session", t, func() {
+		withSession(func(app *App, server *Server) {
+			Convey("files are opened in it at their line", func() {
+				So(RemoteOpen("test", []string{"remote.txt:3"}, false), ShouldBeNil)
+
+				var name string
+				onMainLoop(app, func() { name = app.Editor().CurrentPane().Buffer().Filename() })
+				absolute, _ := filepath.Abs("remote.txt")
+				So(name, ShouldEqual, absolute)
+			})
+
+			Convey("waiting returns once the buffer is deleted", func() {
+				result := make(chan error)
+				go func() { result <- RemoteOpen("test", []string{"one.txt", "two.txt"}, true) }()
+
+				deleteBuffer := func(name string) {
+					deadline := time.Now().Add(time.Second)
+					for time.Now().Before(deadline) {
+						found := false
+						onMainLoop(app, func() {
+							absolute, _ := filepath.Abs(name)
+							if buffer, err := app.Editor().FindBuffer(absolute); err == nil {
+								app.Editor().DeleteBuffer(buffer, true)
+								found = true
+							}
+						})
+						if found {
+							return
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+
+				deleteBuffer("one.txt")
+				select {
+				case <-result:
+					So("returned too early", ShouldBeEmpty)
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				deleteBuffer("two.txt")
+				select {
+				case err := <-result:
+					So(err, ShouldBeNil)
+				case <-time.After(time.Second):
+					So("didn't return", ShouldBeEmpty)
+				}
+			})
+
+			Convey("it is found in the runtime directory", func() {
+				name, err := FindSession()
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "test")
+			})
+
+			Convey("stale sockets are removed when looking for it", func() {
+				path, _ := SessionSocket("stale")
+				listener, _ := net.Listen("unix", path)
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				listener.Close()
+
+				name, err := FindSession()
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "test")
+				_, err = os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Without a running jkl", t, func() {
+		withRuntimeDir(func(string) {
+			So(RemoteOpen("", []string{"a"}, false), ShouldEqual, ErrNoInstance)
+		})
+	})
+}
+
+func TestControlServer(t *testing.T) {
+	Convey("An App shown in a terminal takes requests through a control server", t, func() {
+		withRuntimeDir(func(string) {
+			path, _ := SessionSocket("editor")
+			server := NewControlServer(path)
+			So(server.Listen(), ShouldBeNil)
+
+			ui := NewFakeUI()
+			app := NewApp(SetUI(&ui))
+			app.SetRemote(&server)
+			go app.Run()
+			So(service.WaitUntilRunning(&app, time.Second), ShouldBeNil)
+			defer app.Stop()
+
+			So(RemoteOpen("", []string{"control.txt"}, false), ShouldBeNil)
+			var name string
+			onMainLoop(&app, func() { name = app.Editor().CurrentPane().Buffer().Filename() })
+			So(filepath.Base(name), ShouldEqual, "control.txt")
+
+			Convey("but can't be attached", func() {
+				wire, err := DialSession("editor")
+				So(err, ShouldBeNil)
+				defer wire.Close()
+				wire.Write(WireMessage{Type: "attach", Width: 10, Height: 5})
+				So(readMessageUntil(wire, ofType("error")).Error, ShouldEqual, "Session can't be attached")
+			})
+		})
+	})
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcbishop/jkl/plugin"
+	"github.com/dcbishop/jkl/service"
+)
+
+// Plugin processes talk JSON-RPC 2.0 with jkl over their stdin and stdout, one message per line.
+// Both sides send requests, jkl answers a plugin's requests in the order they arrive.
+//
+// Requests a plugin sends, buffers are given by number and 0 is the current buffer:
+//
+//	echo {text}                                  shows a message
+//	command_add {name}                           adds an ex command, ie "wc[ount]"
+//	keys_map {keys}                              binds keys in normal mode
+//	subscribe {events}                           sends the events, see plugin.EventType
+//	buffer_info {buffer}                         {buffer, name, filename, modified, lines}
+//	buffer_get_lines {buffer, first, last}       the lines from first to last
+//	buffer_set_lines {buffer, first, last, lines} replaces the lines from first to last
+//	buffer_insert_lines {buffer, after, lines}   adds lines after a line, 0 adds them at the top
+//	float_open {x, y, width, height, lines,      shows a floating window, {float}
+//	  anchor, z, title, border, shadow, focus}   anchor is "editor", "cursor" or "center", see Float
+//	float_set_lines {float, lines}               changes its text
+//	float_close {float}                          closes it
+//
+// Requests jkl sends, a plugin replies once it has handled them:
+//
+//	command {name, args}    one of its commands was run
+//	keys {keys}             one of its key bindings was pressed
+//
+// and the notification "event" {type, buffer, name, mode} for the events it subscribed to. A floating
+// window opened with focus gets the keys typed as "float_key" {float, keys} notifications, keys as a
+// terminal sends them, except Escape, which closes it. "float_closed" {float} tells the plugin the
+// user closed one.
+// An error reply to a command or keys request is shown to the user.
+
+// RPCMessage is a JSON-RPC request, reply or notification.
+type RPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error of a failed request.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcEditorError    = -32000
+)
+
+// rpcParams are the parameters of the requests and notifications in either direction.
+type rpcParams struct {
+	Text     string   `json:"text,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Args     string   `json:"args,omitempty"`
+	Keys     string   `json:"keys,omitempty"`
+	Events   []string `json:"events,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Buffer   int      `json:"buffer,omitempty"`
+	First    int      `json:"first,omitempty"`
+	Last     int      `json:"last,omitempty"`
+	After    int      `json:"after,omitempty"`
+	Lines    []string `json:"lines,omitempty"`
+	Float    int      `json:"float,omitempty"`
+	X        int      `json:"x,omitempty"`
+	Y        int      `json:"y,omitempty"`
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	Anchor   string   `json:"anchor,omitempty"`
+	Z        int      `json:"z,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Border   bool     `json:"border,omitempty"`
+	Shadow   bool     `json:"shadow,omitempty"`
+	Focus    bool     `json:"focus,omitempty"`
+	Filename string   `json:"filename,omitempty"`
+	Modified bool     `json:"modified,omitempty"`
+}
+
+// Back-off between restarts of a plugin process that keeps exiting.
+const (
+	pluginMinBackoff = 100 * time.Millisecond
+	pluginMaxBackoff = 30 * time.Second
+)
+
+// PluginProcess runs a plugin program and restarts it when it exits, waiting longer each time
+// it exits again soon after starting. Messages are handled on the editor's main loop, see Editor.Post.
+type PluginProcess struct {
+	name       string
+	args       []string
+	editor     *Editor
+	state      service.State
+	quit       chan bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mutex    sync.Mutex
+	stopped  bool
+	cmd      *exec.Cmd
+	restarts int
+
+	// Used on the main loop.
+	conn     *pluginConn
+	commands map[string]bool
+	events   map[plugin.EventType]bool
+	floats   map[int]*Float
+	lastID   int
+	replies  map[int]func(RPCMessage)
+}
+
+// pluginConn is one run of a plugin process, messages to it are written by its own goroutine.
+type pluginConn struct {
+	out    chan RPCMessage
+	closed bool
+}
+
+// NewPluginProcess constructs a PluginProcess running the program with its arguments, named after the program.
+func NewPluginProcess(editor *Editor, args []string) *PluginProcess {
+	return &PluginProcess{
+		name:       strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0])),
+		args:       args,
+		editor:     editor,
+		quit:       make(chan bool),
+		minBackoff: pluginMinBackoff,
+		maxBackoff: pluginMaxBackoff,
+		commands:   map[string]bool{},
+		events:     map[plugin.EventType]bool{},
+		floats:     map[int]*Float{},
+		replies:    map[int]func(RPCMessage){},
+	}
+}
+
+// Name returns the name of the plugin.
+func (p *PluginProcess) Name() string {
+	return p.name
+}
+
+// Restarts returns how many times the plugin process was started again after it exited.
+func (p *PluginProcess) Restarts() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.restarts
+}
+
+// Run starts the plugin process and restarts it whenever it exits until Stop() is called.
+func (p *PluginProcess) Run() {
+	if p.state.SetRunning() != nil {
+		panic("PluginProcess already running.")
+	}
+	defer p.state.SetStopped()
+
+	backoff := p.minBackoff
+	for {
+		started := time.Now()
+		if err := p.runOnce(); err != nil {
+			p.editor.Post(func() { p.editor.EchoError(fmt.Errorf("Plugin %s: %s", p.name, err)) })
+		}
+		if time.Since(started) > p.maxBackoff {
+			backoff = p.minBackoff
+		}
+
+		select {
+		case <-p.quit:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+
+		p.mutex.Lock()
+		p.restarts++
+		p.mutex.Unlock()
+	}
+}
+
+// Running returns true if Run() was called but Stop() hasn't been.
+func (p *PluginProcess) Running() bool {
+	return p.state.Running()
+}
+
+// Stop kills the plugin process and terminates the Run loop.
+func (p *PluginProcess) Stop() {
+	p.mutex.Lock()
+	if p.stopped {
+		p.mutex.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.quit)
+	if p.cmd != nil {
+		p.cmd.Process.Kill()
+	}
+	p.mutex.Unlock()
+	service.WaitUntilStopped(p, time.Second)
+}
+
+// runOnce runs the plugin process until it exits, passing what it sends to the main loop.
+func (p *PluginProcess) runOnce() error {
+	cmd := exec.Command(p.args[0], p.args[1:]...)
+	stderr := &lastLine{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	if p.stopped {
+		p.mutex.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	p.cmd = cmd
+	p.mutex.Unlock()
+
+	conn := &pluginConn{out: make(chan RPCMessage, 256)}
+	p.editor.Post(func() { p.conn = conn })
+	go conn.write(stdin)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		message := RPCMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			p.editor.Post(func() {
+				p.send(conn, RPCMessage{Error: &RPCError{Code: rpcParseError, Message: "Invalid JSON"}})
+			})
+			continue
+		}
+		p.editor.Post(func() { p.receive(conn, message) })
+	}
+	// Scanning stops early on a line too long to read, the plugin is killed so it can be started again.
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		cmd.Process.Kill()
+	}
+
+	err = cmd.Wait()
+	p.mutex.Lock()
+	p.cmd = nil
+	stopped := p.stopped
+	p.mutex.Unlock()
+	p.editor.Post(func() { p.exited(conn) })
+
+	if stopped {
+		return nil
+	}
+	if scanErr != nil {
+		return fmt.Errorf("Reading its output: %s", scanErr)
+	}
+	if err == nil {
+		err = errors.New("Exited")
+	}
+	if line := stderr.String(); line != "" {
+		return fmt.Errorf("%s: %s", err, line)
+	}
+	return err
+}
+
+// lastLine keeps the last line written to it, ie the error a plugin printed before exiting.
+type lastLine struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (last *lastLine) Write(data []byte) (int, error) {
+	last.mutex.Lock()
+	defer last.mutex.Unlock()
+	last.data = append(last.data, data...)
+	if trimmed := bytes.TrimRight(last.data, "\n"); bytes.LastIndexByte(trimmed, '\n') != -1 {
+		last.data = last.data[bytes.LastIndexByte(trimmed, '\n')+1:]
+	}
+	if len(last.data) > 1024 {
+		last.data = last.data[len(last.data)-1024:]
+	}
+	return len(data), nil
+}
+
+func (last *lastLine) String() string {
+	last.mutex.Lock()
+	defer last.mutex.Unlock()
+	return strings.TrimSpace(string(last.data))
+}
+
+// write sends messages to the plugin until the connection is closed.
+func (conn *pluginConn) write(stdin io.WriteCloser) {
+	defer stdin.Close()
+	encoder := json.NewEncoder(stdin)
+	for message := range conn.out {
+		if encoder.Encode(message) != nil {
+			for range conn.out {
+			}
+			return
+		}
+	}
+}
+
+// exited forgets what belonged to a run of the plugin process once it has exited.
+func (p *PluginProcess) exited(conn *pluginConn) {
+	conn.closed = true
+	close(conn.out)
+	if p.conn != conn {
+		return
+	}
+	p.conn = nil
+	p.events = map[plugin.EventType]bool{}
+	p.replies = map[int]func(RPCMessage){}
+	for id, float := range p.floats {
+		delete(p.floats, id)
+		p.editor.CloseFloat(float)
+	}
+}
+
+// send queues a message to the plugin, a plugin that doesn't keep up is killed.
+func (p *PluginProcess) send(conn *pluginConn, message RPCMessage) {
+	if conn == nil || conn.closed {
+		return
+	}
+	message.JSONRPC = "2.0"
+	select {
+	case conn.out <- message:
+	default:
+		p.mutex.Lock()
+		if p.cmd != nil {
+			p.cmd.Process.Kill()
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// call sends a request to the plugin, reply is called with its answer.
+func (p *PluginProcess) call(method string, params rpcParams, reply func(RPCMessage)) error {
+	if p.conn == nil {
+		return fmt.Errorf("Plugin %s is not running", p.name)
+	}
+	p.lastID++
+	p.replies[p.lastID] = reply
+	id, _ := json.Marshal(p.lastID)
+	data, _ := json.Marshal(params)
+	p.send(p.conn, RPCMessage{ID: id, Method: method, Params: data})
+	return nil
+}
+
+// callAndReport sends a request to the plugin and shows the error it replies with, if any.
+func (p *PluginProcess) callAndReport(method string, params rpcParams) error {
+	return p.call(method, params, func(reply RPCMessage) {
+		if reply.Error != nil {
+			p.editor.EchoError(errors.New(reply.Error.Message))
+		}
+	})
+}
+
+// receive handles a message from the plugin.
+func (p *PluginProcess) receive(conn *pluginConn, message RPCMessage) {
+	if message.Method == "" {
+		id := 0
+		if json.Unmarshal(message.ID, &id) == nil && p.conn == conn {
+			if reply, ok := p.replies[id]; ok {
+				delete(p.replies, id)
+				reply(message)
+			}
+		}
+		return
+	}
+
+	params := rpcParams{}
+	if len(message.Params) > 0 {
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			p.reply(conn, message, nil, &RPCError{Code: rpcInvalidParams, Message: err.Error()})
+			return
+		}
+	}
+
+	result, err := p.handle(message.Method, params)
+	if errors.Is(err, errUnknownMethod) {
+		p.reply(conn, message, nil, &RPCError{Code: rpcMethodNotFound, Message: "Unknown method: " + message.Method})
+	} else if err != nil {
+		p.reply(conn, message, nil, &RPCError{Code: rpcEditorError, Message: err.Error()})
+	} else {
+		p.reply(conn, message, result, nil)
+	}
+}
+
+// reply answers a request, notifications aren't answered.
+func (p *PluginProcess) reply(conn *pluginConn, request RPCMessage, result interface{}, err *RPCError) {
+	if len(request.ID) == 0 {
+		return
+	}
+	message := RPCMessage{ID: request.ID, Error: err}
+	if err == nil {
+		message.Result, _ = json.Marshal(result)
+	}
+	p.send(conn, message)
+}
+
+var errUnknownMethod = errors.New("Unknown method")
+
+// handle runs a request from the plugin and returns its result.
+func (p *PluginProcess) handle(method string, params rpcParams) (interface{}, error) {
+	editor := p.editor
+	switch method {
+	case "echo":
+		editor.Echo(params.Text)
+		return nil, nil
+
+	case "command_add":
+		return nil, p.addCommand(params.Name)
+
+	case "keys_map":
+		if params.Keys == "" {
+			return nil, errors.New("No keys")
+		}
+		keys := params.Keys
+		editor.MapNormal(keys, func(editor *Editor) error {
+			return p.callAndReport("keys", rpcParams{Keys: keys})
+		})
+		return nil, nil
+
+	case "subscribe":
+		for _, event := range params.Events {
+			switch plugin.EventType(event) {
+			case plugin.BufferOpened, plugin.BufferSaved, plugin.BufferChanged, plugin.ModeChanged:
+				p.events[plugin.EventType(event)] = true
+			default:
+				return nil, fmt.Errorf("Unknown event: %s", event)
+			}
+		}
+		return nil, nil
+
+	case "buffer_info", "buffer_get_lines", "buffer_set_lines", "buffer_insert_lines":
+		buffer := editor.CurrentPane().Buffer()
+		if params.Buffer != 0 {
+			buffer = editor.BufferByID(params.Buffer)
+		}
+		if buffer == nil {
+			return nil, errors.New("No such buffer")
+		}
+		return p.handleBuffer(method, buffer, params)
+
+	case "float_open":
+		if params.Width <= 0 || params.Height <= 0 {
+			return nil, errors.New("Floating window needs a width and height")
+		}
+		anchor, ok := floatAnchors[params.Anchor]
+		if !ok {
+			return nil, fmt.Errorf("Unknown anchor %s", params.Anchor)
+		}
+		p.lastID++
+		p.openFloat(p.lastID, params, anchor)
+		return rpcParams{Float: p.lastID}, nil
+
+	case "float_set_lines", "float_close":
+		float, ok := p.floats[params.Float]
+		if !ok {
+			return nil, errors.New("No such floating window")
+		}
+		if method == "float_close" {
+			delete(p.floats, params.Float)
+			editor.CloseFloat(float)
+		} else {
+			float.Lines = params.Lines
+		}
+		return nil, nil
+	}
+	return nil, errUnknownMethod
+}
+
+// floatAnchors are the anchors of float_open by name.
+var floatAnchors = map[string]FloatAnchor{"": AnchorEditor, "editor": AnchorEditor, "cursor": AnchorCursor, "center": AnchorCenter}
+
+// openFloat opens a floating window for the plugin, which hears of the keys typed into it if it has the
+// focus and of it being closed by the user.
+func (p *PluginProcess) openFloat(id int, params rpcParams, anchor FloatAnchor) {
+	float := &Float{
+		X: params.X, Y: params.Y, Width: params.Width, Height: params.Height, Lines: params.Lines,
+		Anchor: anchor, Z: params.Z, Title: params.Title, Border: params.Border, Shadow: params.Shadow,
+	}
+	conn := p.conn
+	float.Closed = func() {
+		if p.floats[id] == float {
+			delete(p.floats, id)
+			data, _ := json.Marshal(rpcParams{Float: id})
+			p.send(conn, RPCMessage{Method: "float_closed", Params: data})
+		}
+	}
+	float.Key = func(event KeyEvent) bool {
+		if event.Key == KeyEscape {
+			return false
+		}
+		data, _ := json.Marshal(rpcParams{Float: id, Keys: string(encodeKey(event, false))})
+		p.send(conn, RPCMessage{Method: "float_key", Params: data})
+		return true
+	}
+	p.floats[id] = float
+	p.editor.OpenFloat(float)
+	if params.Focus {
+		p.editor.FocusFloat(float)
+	}
+}
+
+// handleBuffer runs a buffer request.
+func (p *PluginProcess) handleBuffer(method string, buffer *Buffer, params rpcParams) (interface{}, error) {
+	switch method {
+	case "buffer_get_lines":
+		return buffer.GetLines(params.First, params.Last)
+	case "buffer_set_lines":
+		return nil, buffer.ReplaceLines(params.First, params.Last, params.Lines)
+	case "buffer_insert_lines":
+		return nil, buffer.InsertLines(params.After, params.Lines)
+	}
+	return rpcParams{
+		Buffer:   buffer.ID(),
+		Name:     buffer.Name(),
+		Filename: buffer.Filename(),
+		Modified: buffer.Modified(),
+		Last:     buffer.LineCount(),
+	}, nil
+}
+
+// addCommand adds an ex command that asks the plugin to run it, a restarted plugin can add its commands again.
+func (p *PluginProcess) addCommand(name string) error {
+	def := CommandDefinition{Name: name}
+	if p.commands[def.FullName()] {
+		return nil
+	}
+	fullName := def.FullName()
+	err := pluginHost{editor: p.editor}.AddCommand(name, func(args string) error {
+		return p.callAndReport("command", rpcParams{Name: fullName, Args: args})
+	})
+	if err == nil {
+		p.commands[fullName] = true
+	}
+	return err
+}
+
+// notify sends an event to the plugin if it subscribed to it.
+func (p *PluginProcess) notify(event plugin.Event) {
+	if p.conn == nil || !p.events[event.Type] {
+		return
+	}
+	params := rpcParams{Type: string(event.Type), Mode: event.Mode}
+	if buffer, ok := event.Buffer.(*Buffer); ok {
+		params.Buffer = buffer.ID()
+		params.Name = buffer.Name()
+	}
+	data, _ := json.Marshal(params)
+	p.send(p.conn, RPCMessage{Method: "event", Params: data})
+}
+
+// StartPlugin runs a plugin program with its arguments, see PluginProcess.
+func (editor *Editor) StartPlugin(args []string) (*PluginProcess, error) {
+	if len(args) == 0 {
+		return nil, errors.New("No plugin program")
+	}
+	p := NewPluginProcess(editor, args)
+	if err := editor.runPlugin(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// runPlugin starts a PluginProcess and passes it the editor's events.
+func (editor *Editor) runPlugin(p *PluginProcess) error {
+	for _, running := range editor.plugins {
+		if running.name == p.name {
+			return fmt.Errorf("Plugin already running: %s", p.name)
+		}
+	}
+
+	host := pluginHost{editor: editor}
+	for _, event := range []plugin.EventType{plugin.BufferOpened, plugin.BufferSaved, plugin.BufferChanged, plugin.ModeChanged} {
+		host.On(event, p.notify)
+	}
+	editor.plugins = append(editor.plugins, p)
+	go p.Run()
+	return nil
+}
+
+// Plugins returns the plugin processes that were started.
+func (editor *Editor) Plugins() []*PluginProcess {
+	return editor.plugins
+}
+
+// StopPlugins stops every plugin process.
+func (editor *Editor) StopPlugins() {
+	for _, p := range editor.plugins {
+		p.Stop()
+	}
+}
+
+// PluginCommands returns the path of the file listing plugin programs to start, "plugin-commands"
+// in the jkl configuration directory.
+func PluginCommands() (string, error) {
+	return ConfigFile("plugin-commands")
+}
+
+// StartPlugins starts the plugin programs listed in the file, one program and its arguments per line.
+// Blank lines and lines starting with '#' are ignored.
+func StartPlugins(path string) func(*App) error {
+	return func(a *App) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, err := a.Editor().StartPlugin(strings.Fields(line)); err != nil {
+				a.Editor().EchoError(err)
+			}
+		}
+		return nil
+	}
+}
+
+// pluginCommand starts a plugin program, without one it lists the plugin processes.
+func pluginCommand(editor *Editor, command Command) error {
+	if command.Args != "" {
+		_, err := editor.StartPlugin(strings.Fields(command.Args))
+		return err
+	}
+
+	lines := []string{}
+	for _, p := range editor.plugins {
+		status := "running"
+		if p.conn == nil {
+			status = "restarting"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s, restarted %d times", p.name, status, p.Restarts()))
+	}
+	if len(lines) == 0 {
+		return errors.New("No plugins running")
+	}
+	editor.Echo(strings.Join(lines, "\n"))
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+// helperPlugin is the plugin run by TestPluginHelperProcess.
+type helperPlugin struct {
+	in      *bufio.Scanner
+	out     *json.Encoder
+	lastID  int
+	backlog []RPCMessage
+}
+
+// TestPluginHelperProcess isn't a real test, it is the plugin program the other tests start.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("JKL_PLUGIN_HELPER") == "" {
+		return
+	}
+
+	h := helperPlugin{in: bufio.NewScanner(os.Stdin), out: json.NewEncoder(os.Stdout)}
+	for _, name := range []string{"Hello", "Crash", "Fail", "Float", "Menu", "Flood"} {
+		h.call("command_add", rpcParams{Name: name})
+	}
+	h.call("keys_map", rpcParams{Keys: "gh"})
+	h.call("subscribe", rpcParams{Events: []string{"BufferSaved"}})
+
+	for {
+		message := h.next()
+		params := rpcParams{}
+		json.Unmarshal(message.Params, &params)
+
+		switch {
+		case message.Method == "command" && params.Name == "Hello":
+			info := rpcParams{}
+			json.Unmarshal(h.call("buffer_info", rpcParams{}).Result, &info)
+			lines := []string{}
+			json.Unmarshal(h.call("buffer_get_lines", rpcParams{First: 1, Last: 1}).Result, &lines)
+			text := fmt.Sprintf("Hello %s, %s of %s", params.Args, lines[0], info.Name)
+			h.call("buffer_set_lines", rpcParams{First: 1, Last: 1, Lines: []string{text}})
+			h.reply(message, nil)
+		case message.Method == "command" && params.Name == "Crash":
+			fmt.Fprintln(os.Stderr, "Crashed on purpose")
+			os.Exit(3)
+		case message.Method == "command" && params.Name == "Flood":
+			os.Stdout.Write(make([]byte, 17*1024*1024))
+		case message.Method == "command" && params.Name == "Fail":
+			h.reply(message, &RPCError{Code: 1, Message: "It failed"})
+		case message.Method == "command" && params.Name == "Float":
+			h.call("float_open", rpcParams{X: 1, Y: 1, Width: 7, Height: 2, Lines: []string{"floats"}})
+			h.reply(message, nil)
+		case message.Method == "command" && params.Name == "Menu":
+			menu := rpcParams{Anchor: "center", Width: 6, Height: 1, Lines: []string{"choose"}, Title: "Menu", Border: true, Focus: true}
+			h.call("float_open", menu)
+			h.reply(message, nil)
+		case message.Method == "float_key":
+			h.call("echo", rpcParams{Text: fmt.Sprintf("Float %d got %q", params.Float, params.Keys)})
+		case message.Method == "float_closed":
+			h.call("echo", rpcParams{Text: fmt.Sprintf("Float %d closed", params.Float)})
+		case message.Method == "keys":
+			h.call("echo", rpcParams{Text: "Pressed " + params.Keys})
+			h.reply(message, nil)
+		case message.Method == "event":
+			h.call("echo", rpcParams{Text: params.Type + " " + params.Name})
+		}
+	}
+}
+
+// call sends a request and waits for its reply, keeping what else arrives for next.
+func (h *helperPlugin) call(method string, params rpcParams) RPCMessage {
+	h.lastID++
+	id, _ := json.Marshal(h.lastID)
+	data, _ := json.Marshal(params)
+	h.out.Encode(RPCMessage{JSONRPC: "2.0", ID: id, Method: method, Params: data})
+
+	for {
+		message := h.read()
+		if message.Method == "" && string(message.ID) == string(id) {
+			return message
+		}
+		h.backlog = append(h.backlog, message)
+	}
+}
+
+func (h *helperPlugin) next() RPCMessage {
+	if len(h.backlog) > 0 {
+		message := h.backlog[0]
+		h.backlog = h.backlog[1:]
+		return message
+	}
+	return h.read()
+}
+
+func (h *helperPlugin) read() RPCMessage {
+	if !h.in.Scan() {
+		os.Exit(0)
+	}
+	message := RPCMessage{}
+	json.Unmarshal(h.in.Bytes(), &message)
+	return message
+}
+
+func (h *helperPlugin) reply(request RPCMessage, err *RPCError) {
+	h.out.Encode(RPCMessage{JSONRPC: "2.0", ID: request.ID, Result: json.RawMessage("null"), Error: err})
+}
+
+// withHelperPlugin runs the helper plugin for an editor, run handles its messages until done returns true.
+func withHelperPlugin(f func(editor *Editor, p *PluginProcess, run func(done func() bool))) {
+	os.Setenv("JKL_PLUGIN_HELPER", "1")
+	defer os.Unsetenv("JKL_PLUGIN_HELPER")
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "notes.txt", []byte("first\nsecond\n"), 0644)
+	editor := NewEditor(fs)
+	editor.Settings().Borders = false
+	work := make(chan func(), 1024)
+	editor.SetPost(func(f func()) { work <- f })
+	editor.OpenFile("notes.txt")
+
+	run := func(done func() bool) {
+		deadline := time.After(5 * time.Second)
+		for !done() {
+			select {
+			case f := <-work:
+				f()
+			case <-deadline:
+				So(done(), ShouldBeTrue)
+				return
+			}
+		}
+	}
+
+	p := NewPluginProcess(&editor, []string{os.Args[0], "-test.run=^TestPluginHelperProcess$"})
+	p.minBackoff = 10 * time.Millisecond
+	So(editor.runPlugin(p), ShouldBeNil)
+	defer editor.StopPlugins()
+
+	run(func() bool { return editor.LookupCommand("Float") != nil && len(p.events) > 0 })
+	f(&editor, p, run)
+}
+
+func TestPluginProcess(t *testing.T) {
+	Convey("Editor running a plugin process", t, func() {
+		withHelperPlugin(func(editor *Editor, p *PluginProcess, run func(done func() bool)) {
+			So(p.Name(), ShouldEqual, "jkl")
+			So(p.Running(), ShouldBeTrue)
+			buffer := editor.CurrentPane().Buffer()
+
+			Convey("its commands can read and change buffers", func() {
+				So(editor.ExecuteCommand("Hello world"), ShouldBeNil)
+				run(func() bool { line, _ := buffer.GetLine(1); return line != "first" })
+				line, _ := buffer.GetLine(1)
+				So(line, ShouldEqual, "Hello world, first of notes.txt")
+			})
+
+			Convey("errors it replies with are shown", func() {
+				So(editor.ExecuteCommand("Fail"), ShouldBeNil)
+				run(func() bool { return editor.Message().Text != "" })
+				So(editor.Message().Text, ShouldEqual, "It failed")
+				So(editor.Message().Severity, ShouldEqual, SeverityError)
+			})
+
+			Convey("its key bindings and events reach it", func() {
+				editor.HandleNormalKey('g')
+				editor.HandleNormalKey('h')
+				run(func() bool { return editor.Message().Text != "" })
+				So(editor.Message().Text, ShouldEqual, "Pressed gh")
+
+				So(editor.WriteBuffer(buffer, ""), ShouldBeNil)
+				run(func() bool { return editor.Message().Text != "Pressed gh" })
+				So(editor.Message().Text, ShouldEqual, "BufferSaved notes.txt")
+			})
+
+			Convey("it can draw into floating windows", func() {
+				So(editor.ExecuteCommand("Float"), ShouldBeNil)
+				run(func() bool { return len(editor.Floats()) > 0 })
+
+				grid := NewRuneGrid(12, 4)
+				grid.RenderEditor(editor)
+				So(string(grid.Cells()[1][1:8]), ShouldEqual, "floats ")
+				So(grid.Styles()[2][7], ShouldEqual, StyleFloat)
+			})
+
+			Convey("its floating windows can take the keys", func() {
+				So(editor.ExecuteCommand("Menu"), ShouldBeNil)
+				run(func() bool { return editor.FocusedFloat() != nil })
+				So(editor.FocusedFloat().Title, ShouldEqual, "Menu")
+				id := 0
+				for key := range p.floats {
+					id = key
+				}
+
+				editor.HandleFloatKey(KeyEvent{Rune: 'x'})
+				editor.HandleFloatKey(KeyEvent{Key: KeyUp})
+				run(func() bool { return editor.Message().Text == fmt.Sprintf(`Float %d got "\x1b[A"`, id) })
+
+				editor.HandleFloatKey(KeyEvent{Key: KeyEscape})
+				So(editor.Floats(), ShouldBeEmpty)
+				run(func() bool { return editor.Message().Text == fmt.Sprintf("Float %d closed", id) })
+			})
+
+			Convey("an unknown anchor is an error", func() {
+				_, err := p.handle("float_open", rpcParams{Anchor: "nowhere", Width: 1, Height: 1})
+				So(fmt.Sprint(err), ShouldEqual, "Unknown anchor nowhere")
+			})
+
+			Convey("it is restarted when it crashes", func() {
+				So(editor.ExecuteCommand("Float"), ShouldBeNil)
+				run(func() bool { return len(editor.Floats()) > 0 })
+				So(editor.ExecuteCommand("Crash"), ShouldBeNil)
+				run(func() bool { return p.conn == nil && editor.Message().Text != "" })
+				So(editor.Message().Text, ShouldEqual, "Plugin jkl: exit status 3: Crashed on purpose")
+				So(editor.Floats(), ShouldBeEmpty)
+				So(editor.ExecuteCommand("Hello"), ShouldNotBeNil)
+
+				run(func() bool { return p.Restarts() == 1 && len(p.events) > 0 })
+				So(editor.ExecuteCommand("Hello again"), ShouldBeNil)
+				run(func() bool { line, _ := buffer.GetLine(1); return line != "first" })
+				line, _ := buffer.GetLine(1)
+				So(line, ShouldEqual, "Hello again, first of notes.txt")
+			})
+
+			Convey("it is restarted when it sends a line too long to read", func() {
+				So(editor.ExecuteCommand("Flood"), ShouldBeNil)
+				run(func() bool { return p.conn == nil && editor.Message().Text != "" })
+				So(editor.Message().Text, ShouldEqual, "Plugin jkl: Reading its output: bufio.Scanner: token too long")
+				run(func() bool { return p.Restarts() == 1 && len(p.events) > 0 })
+				So(editor.ExecuteCommand("Hello"), ShouldBeNil)
+			})
+
+			Convey("stopping it stops the process", func() {
+				editor.StopPlugins()
+				So(p.Running(), ShouldBeFalse)
+				So(p.Restarts(), ShouldEqual, 0)
+			})
+
+			Convey("a second one with the same name isn't started", func() {
+				_, err := editor.StartPlugin([]string{os.Args[0]})
+				So(err, ShouldNotBeNil)
+				So(editor.Plugins(), ShouldHaveLength, 1)
+			})
+		})
+	})
+}
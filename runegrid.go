@@ -1,10 +1,65 @@
 package main
 
+import "strings"
+
+// Style is the highlight group of a cell, the UI decides how each Style looks.
+type Style int
+
+// Styles used when rendering the editor.
+const (
+	StyleNormal Style = iota
+	StyleStatusLine
+	StyleStatusLineNC
+	StyleMessage
+	StyleWarning
+	StyleError
+	StyleLineNumber
+	StyleCursorLineNumber
+	StyleSignColumn
+	StyleFoldColumn
+	StyleSignError
+	StyleSignWarning
+	StyleSignInfo
+	StyleSignAdd
+	StyleSignChange
+	StyleSignDelete
+	StyleFolded
+	StyleVisual
+	StylePeerCursor1
+	StylePeerCursor2
+	StylePeerCursor3
+	StylePeerCursor4
+	StylePeerCursor5
+	StylePeerCursor6
+	StylePeerSelection1
+	StylePeerSelection2
+	StylePeerSelection3
+	StylePeerSelection4
+	StylePeerSelection5
+	StylePeerSelection6
+	StyleFloat
+	StyleShadow
+	StyleCompletion
+	StyleCompletionSelected
+	StylePicker
+	StylePickerSelected
+	StylePickerMatch
+	StyleDiffAdd
+	StyleDiffChange
+	StyleDiffText
+	StyleDiffDelete
+	StyleConflictMarker
+	StyleConflictOurs
+	StyleConflictBase
+	StyleConflictTheirs
+)
+
 // RuneGrid contains the rendered text UI
 type RuneGrid struct {
 	width  int
 	height int
 	cells  [][]rune
+	styles [][]Style
 }
 
 // New constructs a RuneGrid with the given width and height
@@ -13,10 +68,12 @@ func NewRuneGrid(width, height int) RuneGrid {
 		width:  width,
 		height: height,
 		cells:  make([][]rune, height),
+		styles: make([][]Style, height),
 	}
 
 	for i := range grid.cells {
 		grid.cells[i] = make([]rune, width)
+		grid.styles[i] = make([]Style, width)
 	}
 
 	return grid
@@ -31,6 +88,12 @@ func (grid *RuneGrid) RenderEditor(editor *Editor) {
 
 	settings := editor.Settings()
 
+	minimumHeight := 1
+	if settings.Borders && settings.OuterBorder {
+		minimumHeight = 3
+	}
+	y2 = grid.RenderMessageArea(editor, x1, y2, x2, y1+minimumHeight)
+
 	if settings.Borders && settings.OuterBorder {
 		grid.DrawBox(x1, y1, x2, y2, '═', '║', '╔', '╗', '╚', '╝')
 
@@ -44,46 +107,108 @@ func (grid *RuneGrid) RenderEditor(editor *Editor) {
 		return
 	}
 
-	grid.RenderPane(editor, x1, y1, x2, y2, editor.CurrentPane())
+	editor.syncDiff()
+	grid.RenderLayout(editor, editor.Layout(), x1, y1, x2, y2)
+	grid.RenderFloats(editor, x1, y1, x2, y2)
 }
 
 // RenderPane render the Pane and it's contents.
 func (grid *RuneGrid) RenderPane(editor *Editor, x1, y1, x2, y2 int, pane *Pane) {
+	frame := Rect{x1, y1, x2, y2}
+	pane.View().Frame = frame
 	if pane.Buffer() == nil {
 		return
 	}
-	UpdateTopLine(editor.Settings(), pane, y2-y1)
-	grid.RenderBuffer(editor.Settings(), x1, y1, x2, y2, pane.Buffer(), pane.TopLine())
+	settings := editor.Settings()
+	statusY := -1
+	if settings.StatusLine != "" && y2 > y1 {
+		grid.RenderStatusLine(editor, x1, y2, x2, pane)
+		statusY = y2
+		y2--
+	}
+
+	if terminal := pane.Buffer().Terminal(); terminal != nil && terminal.Live() {
+		terminal.Resize(x2-x1+1, y2-y1+1)
+		*pane.View() = PaneView{X: x1, Y: y1, Width: x2 - x1 + 1, Height: y2 - y1 + 1, Frame: frame, StatusY: statusY}
+		grid.RenderTerminal(x1, y1, x2, y2, terminal)
+		return
+	}
+
+	pane.Folds().Update(settings, pane.Buffer())
+	UpdateTopLine(settings, pane, y2-y1)
+
+	gutter := NewGutter(settings, pane)
+	x1 += gutter.Width()
+
+	width := x2 - x1 + 1
+	height := y2 - y1 + 1
+	wrap := pane.Wrap(settings)
+	if wrap {
+		scrollToWrappedCursor(settings, pane, width, height)
+	}
+	UpdateLeftColumn(settings, pane, width)
+
+	view := pane.View()
+	*view = PaneView{X: x1, Y: y1, Width: width, Height: height, Frame: frame, StatusY: statusY}
+	view.Rows = LayoutRows(settings, pane.Buffer(), pane.Folds(), pane.TopLine(), pane.LeftColumn(), width, height, wrap)
+	view.Rows = editor.layoutDiffRows(pane, view.Rows, height)
+
+	if gutter.Width() > 0 {
+		grid.RenderGutter(settings, x1-gutter.Width(), y1, pane, gutter, view.Lines())
+	}
+	grid.RenderRows(x1, y1, x2, y2, view.Rows)
+	grid.RenderDiff(editor, pane)
+	grid.RenderConflicts(editor, pane)
+	grid.RenderPeers(settings, pane)
+
+	if editor.Mode() == VisualMode && pane == editor.CurrentPane() {
+		grid.RenderSelection(settings, pane)
+	}
 }
 
 // UpdateTopLine sets the given Pane's TopLine based on the cursor position.
+// Closed folds count as a single line.
 // [TODO]: Move this into editor module and run it when resize event occurs or cursor is moved. - 2014-10-19 03:09pm
 func UpdateTopLine(
 	settings *Settings,
 	pane *Pane,
 	visibleHeight int,
 ) {
-	_, line := pane.Cursor().Position()
+	folds := pane.Folds()
+	_, cursorLine := pane.Cursor().Position()
+	line := folds.VisibleLine(cursorLine)
+	topLine := folds.VisibleLine(pane.TopLine())
+
+	setTopLine := func(visible int) {
+		if visible >= 1 {
+			visible = folds.LineAtVisible(visible)
+		}
+		pane.SetTopLine(visible)
+	}
 
 	if settings.ScrollOffset*2 > visibleHeight {
 		newLine := line - visibleHeight/2
 		if newLine < 1 {
 			newLine = 1
 		}
-		pane.SetTopLine(newLine)
+		setTopLine(newLine)
 		return
 	}
 
-	if pane.TopLine() > (line - settings.ScrollOffset) {
-		pane.SetTopLine(line - settings.ScrollOffset)
+	if topLine > (line - settings.ScrollOffset) {
+		setTopLine(line - settings.ScrollOffset)
 		return
 	}
 
-	bottomLine := pane.TopLine() + visibleHeight
+	bottomLine := topLine + visibleHeight
 	if bottomLine < (line + settings.ScrollOffset) {
-		pane.SetTopLine(line + settings.ScrollOffset - visibleHeight)
+		setTopLine(line + settings.ScrollOffset - visibleHeight)
 		return
 	}
+
+	if fold := folds.ClosedFoldAt(pane.TopLine()); fold != nil {
+		pane.SetTopLine(fold.Start)
+	}
 }
 
 // RenderBuffer blits the buffer onto the grid.
@@ -93,35 +218,133 @@ func (grid *RuneGrid) RenderBuffer(
 	buffer *Buffer,
 	topLine int,
 ) {
-	if topLine < 1 {
-		topLine = 1
-	}
-
-	xPos := x1
-	yPos := y1
-
-	// [TODO]: Offset render by topline - 2014-10-19 05:20pm
-	lines, _ := buffer.GetLines(topLine, topLine+y2-y1)
-	for _, line := range lines {
-		for _, r := range line {
-			if xPos <= x2 && yPos <= y2 {
-				if r == '\t' {
-					for i := 0; i < settings.ShiftWidth; i++ {
-						grid.SetCell(xPos, yPos, ' ')
-						xPos++
-					}
-				} else {
-					grid.SetCell(xPos, yPos, rune(r))
-					xPos++
-				}
+	rows := LayoutRows(settings, buffer, nil, topLine, 0, x2-x1+1, y2-y1+1, false)
+	grid.RenderRows(x1, y1, x2, y2, rows)
+}
 
+// RenderRows draws laid out rows of text.
+func (grid *RuneGrid) RenderRows(x1, y1, x2, y2 int, rows []ScreenRow) {
+	for i, row := range rows {
+		y := y1 + i
+		if y > y2 {
+			return
+		}
+		for j, r := range row.Cells {
+			if x1+j > x2 {
+				break
 			}
+			grid.SetCell(x1+j, y, r)
+		}
+		if row.Fold != nil {
+			grid.FillStyle(x1, y, x2, y, StyleFolded)
 		}
-		yPos++
-		xPos = x1
 	}
 }
 
+// RenderStatusLine draws the status line of the pane on row y.
+func (grid *RuneGrid) RenderStatusLine(editor *Editor, x1, y, x2 int, pane *Pane) {
+	style := StyleStatusLineNC
+	if pane == editor.CurrentPane() {
+		style = StyleStatusLine
+	}
+
+	format := pane.StatusLine()
+	if format == "" {
+		format = editor.Settings().StatusLine
+	}
+
+	text := FormatStatusLine(editor.expandStatusItems(format, pane), pane, x2-x1+1)
+	grid.DrawHorizontalLine(x1, x2, y, ' ')
+	grid.FillStyle(x1, y, x2, y, style)
+	grid.DrawText(x1, y, x2, text, style)
+}
+
+// RenderMessageArea draws the command line or the current message upwards from row y.
+// It will not use any rows above minY. Returns the last row left free above the area.
+func (grid *RuneGrid) RenderMessageArea(editor *Editor, x1, y, x2, minY int) int {
+	lines := []string{""}
+	style := StyleNormal
+
+	if editor.Mode() == CommandMode {
+		lines = []string{":" + editor.CommandLine().Text()}
+	} else if message := editor.Message(); message.Text != "" {
+		lines = strings.Split(message.Text, "\n")
+		style = message.Severity.Style()
+	} else if editor.Mode() == VisualMode {
+		lines = []string{"-- VISUAL --"}
+	} else if editor.Mode() == TerminalMode {
+		lines = []string{"-- TERMINAL --"}
+	} else if editor.Mode() == InsertMode {
+		lines = []string{"-- INSERT --"}
+	}
+
+	rows := len(lines)
+	if y-rows+1 < minY {
+		rows = y - minY + 1
+	}
+	if rows <= 0 {
+		return y
+	}
+
+	lines = lines[len(lines)-rows:]
+	top := y - rows + 1
+	for i, line := range lines {
+		grid.DrawText(x1, top+i, x2, line, style)
+	}
+
+	return top - 1
+}
+
+// Clear resets every cell to empty with the normal Style, keeping the grid's memory.
+func (grid *RuneGrid) Clear() {
+	for y := range grid.cells {
+		for x := range grid.cells[y] {
+			grid.cells[y][x] = 0
+			grid.styles[y][x] = StyleNormal
+		}
+	}
+}
+
+// Span is a run of changed cells on one row.
+// Cells and Styles share memory with the grid they came from.
+type Span struct {
+	X      int
+	Y      int
+	Cells  []rune
+	Styles []Style
+}
+
+// Diff returns the runs of cells that differ from the previous grid.
+// Grids of different sizes differ everywhere.
+func (grid *RuneGrid) Diff(previous *RuneGrid) []Span {
+	spans := []Span{}
+	sameSize := previous != nil && previous.width == grid.width && previous.height == grid.height
+
+	for y := range grid.cells {
+		start := -1
+		for x := 0; x <= grid.width; x++ {
+			changed := x < grid.width && (!sameSize ||
+				grid.cells[y][x] != previous.cells[y][x] ||
+				grid.styles[y][x] != previous.styles[y][x])
+
+			if changed && start == -1 {
+				start = x
+			}
+			if !changed && start != -1 {
+				spans = append(spans, Span{
+					X:      start,
+					Y:      y,
+					Cells:  grid.cells[y][start:x],
+					Styles: grid.styles[y][start:x],
+				})
+				start = -1
+			}
+		}
+	}
+
+	return spans
+}
+
 // SetCell sets a cell in the RuneGrid to the given rune
 func (grid *RuneGrid) SetCell(x, y int, r rune) {
 	if !grid.IsCellValid(x, y) {
@@ -131,9 +354,49 @@ func (grid *RuneGrid) SetCell(x, y int, r rune) {
 	grid.cells[y][x] = r
 }
 
+// SetStyle sets the Style of a cell.
+func (grid *RuneGrid) SetStyle(x, y int, style Style) {
+	if !grid.IsCellValid(x, y) {
+		return
+	}
+
+	grid.styles[y][x] = style
+}
+
+// Style returns the Style of a cell.
+func (grid *RuneGrid) Style(x, y int) Style {
+	if !grid.IsCellValid(x, y) {
+		return StyleNormal
+	}
+
+	return grid.styles[y][x]
+}
+
+// FillStyle sets the Style of every cell in a rectangle.
+func (grid *RuneGrid) FillStyle(x1, y1, x2, y2 int, style Style) {
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			grid.SetStyle(x, y, style)
+		}
+	}
+}
+
+// DrawText writes text along row y starting at x1, anything past x2 is cut off.
+func (grid *RuneGrid) DrawText(x1, y, x2 int, text string, style Style) {
+	x := x1
+	for _, r := range text {
+		if x > x2 {
+			return
+		}
+		grid.SetCell(x, y, r)
+		grid.SetStyle(x, y, style)
+		x++
+	}
+}
+
 // IsCellValid returns true if the cell coordinates are valid
 func (grid *RuneGrid) IsCellValid(x, y int) bool {
-	if x >= grid.width || y >= grid.height {
+	if x < 0 || y < 0 || x >= grid.width || y >= grid.height {
 		return false
 	}
 	return true
@@ -149,6 +412,11 @@ func (grid *RuneGrid) Cells() [][]rune {
 	return grid.cells
 }
 
+// Styles gets the styles of the grid's cells.
+func (grid *RuneGrid) Styles() [][]Style {
+	return grid.styles
+}
+
 // DrawBox a box with the given runes.
 func (grid *RuneGrid) DrawBox(x1, y1, x2, y2 int, r rune, rExtra ...rune) {
 	if len(rExtra) != 0 && len(rExtra) != 1 && len(rExtra) != 5 {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -96,6 +97,7 @@ func TestRenderEditor(t *testing.T) {
 			expected := StringToRuneGrid(singleBang, '.')
 
 			editor.Settings().Borders = false
+			editor.Settings().StatusLine = ""
 			grid.RenderEditor(&editor)
 
 			So(grid, ShouldResemble, expected)
@@ -103,6 +105,57 @@ func TestRenderEditor(t *testing.T) {
 	})
 }
 
+func TestRenderStatusLine(t *testing.T) {
+	Convey("Editor with a file open in a 12x5 grid", t, func() {
+		fs := GetCustomTestFs(map[string][]byte{"a.go": []byte("abc\ndef\n")})
+		editor := NewEditor(fs)
+		editor.OpenFile("a.go")
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = "%f%m%=%l:%c"
+		grid := NewRuneGrid(12, 5)
+
+		Convey("draws the status line under the text and leaves a message row", func() {
+			expected := StringToRuneGrid(`
+			abc_________
+			def_________
+			____________
+			a.go     1:1
+			____________
+			`, '_')
+			expected.FillStyle(0, 3, 11, 3, StyleStatusLine)
+
+			grid.RenderEditor(&editor)
+			So(grid, ShouldResemble, expected)
+		})
+
+		Convey("draws errors in the message area", func() {
+			editor.EchoError(errors.New("Oops"))
+			grid.RenderEditor(&editor)
+
+			So(string(grid.Cells()[4][:4]), ShouldEqual, "Oops")
+			So(grid.Style(0, 4), ShouldEqual, StyleError)
+		})
+
+		Convey("multi-line messages push the panes up", func() {
+			editor.Echo("one\ntwo")
+			grid.RenderEditor(&editor)
+
+			So(string(grid.Cells()[3][:3]), ShouldEqual, "one")
+			So(string(grid.Cells()[4][:3]), ShouldEqual, "two")
+			So(string(grid.Cells()[2][:4]), ShouldEqual, "a.go")
+		})
+
+		Convey("shows the command being typed", func() {
+			editor.EnterCommandMode()
+			editor.CommandLine().Insert('l')
+			editor.CommandLine().Insert('s')
+			grid.RenderEditor(&editor)
+
+			So(string(grid.Cells()[4][:3]), ShouldEqual, ":ls")
+		})
+	})
+}
+
 const OneToNine = `
 123
 456
@@ -190,3 +243,55 @@ func TestRenderBuffer(t *testing.T) {
 		So(grid, ShouldResemble, expected)
 	})
 }
+
+func TestRuneGridDiff(t *testing.T) {
+	Convey("given two identical grids", t, func() {
+		front := NewRuneGrid(5, 3)
+		back := NewRuneGrid(5, 3)
+		front.DrawText(0, 1, 4, "hello", StyleNormal)
+		back.DrawText(0, 1, 4, "hello", StyleNormal)
+
+		Convey("there are no changes", func() {
+			So(back.Diff(&front), ShouldBeEmpty)
+		})
+
+		Convey("changed cells are grouped into spans", func() {
+			back.SetCell(1, 1, 'a')
+			back.SetCell(2, 1, 'L')
+			back.SetCell(4, 2, 'x')
+
+			spans := back.Diff(&front)
+			So(len(spans), ShouldEqual, 2)
+			So(spans[0].X, ShouldEqual, 1)
+			So(spans[0].Y, ShouldEqual, 1)
+			So(string(spans[0].Cells), ShouldEqual, "aL")
+			So(spans[1].X, ShouldEqual, 4)
+			So(spans[1].Y, ShouldEqual, 2)
+			So(string(spans[1].Cells), ShouldEqual, "x")
+		})
+
+		Convey("a style change is a change", func() {
+			back.SetStyle(0, 0, StyleError)
+			spans := back.Diff(&front)
+			So(len(spans), ShouldEqual, 1)
+			So(spans[0].Styles, ShouldResemble, []Style{StyleError})
+		})
+
+		Convey("without a previous grid every row is a span", func() {
+			spans := back.Diff(nil)
+			So(len(spans), ShouldEqual, 3)
+			So(len(spans[1].Cells), ShouldEqual, 5)
+		})
+
+		Convey("a grid of another size differs everywhere", func() {
+			other := NewRuneGrid(4, 3)
+			So(len(back.Diff(&other)), ShouldEqual, 3)
+		})
+
+		Convey("Clear empties the grid", func() {
+			back.SetStyle(0, 1, StyleError)
+			back.Clear()
+			So(back, ShouldResemble, NewRuneGrid(5, 3))
+		})
+	})
+}
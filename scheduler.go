@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Timer is a function scheduled to run on the App's main loop.
+// Timers must only be created and stopped from the main loop, use App.Post from other goroutines.
+type Timer struct {
+	when     time.Time
+	interval time.Duration
+	f        func()
+	stopped  bool
+}
+
+// Stop prevents the Timer from running again.
+func (timer *Timer) Stop() {
+	timer.stopped = true
+}
+
+// scheduler keeps the pending Timers in the order they are due.
+type scheduler struct {
+	timers []*Timer
+}
+
+func (s *scheduler) add(timer *Timer) {
+	s.timers = append(s.timers, timer)
+	sort.SliceStable(s.timers, func(i, j int) bool {
+		return s.timers[i].when.Before(s.timers[j].when)
+	})
+}
+
+// next returns when the next Timer is due, ok is false if there are none.
+func (s *scheduler) next() (when time.Time, ok bool) {
+	s.removeStopped()
+	if len(s.timers) == 0 {
+		return time.Time{}, false
+	}
+	return s.timers[0].when, true
+}
+
+// runDue runs every Timer that is due at now and returns how many ran.
+// Repeating Timers are rescheduled.
+func (s *scheduler) runDue(now time.Time) int {
+	ran := 0
+	for {
+		s.removeStopped()
+		if len(s.timers) == 0 || s.timers[0].when.After(now) {
+			return ran
+		}
+
+		timer := s.timers[0]
+		s.timers = s.timers[1:]
+		timer.f()
+		ran++
+
+		if timer.interval > 0 && !timer.stopped {
+			timer.when = now.Add(timer.interval)
+			s.add(timer)
+		}
+	}
+}
+
+func (s *scheduler) removeStopped() {
+	timers := s.timers[:0]
+	for _, timer := range s.timers {
+		if !timer.stopped {
+			timers = append(timers, timer)
+		}
+	}
+	s.timers = timers
+}
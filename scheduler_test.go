@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduler(t *testing.T) {
+	Convey("given a scheduler", t, func() {
+		s := scheduler{}
+		now := time.Now()
+		ran := []string{}
+
+		_, ok := s.next()
+		So(ok, ShouldBeFalse)
+
+		s.add(&Timer{when: now.Add(2 * time.Second), f: func() { ran = append(ran, "second") }})
+		s.add(&Timer{when: now.Add(time.Second), f: func() { ran = append(ran, "first") }})
+
+		Convey("next returns the earliest timer", func() {
+			when, ok := s.next()
+			So(ok, ShouldBeTrue)
+			So(when, ShouldEqual, now.Add(time.Second))
+		})
+
+		Convey("runDue only runs timers that are due, in order", func() {
+			So(s.runDue(now), ShouldEqual, 0)
+			So(s.runDue(now.Add(time.Second)), ShouldEqual, 1)
+			So(s.runDue(now.Add(5*time.Second)), ShouldEqual, 1)
+			So(ran, ShouldResemble, []string{"first", "second"})
+			_, ok := s.next()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("stopped timers don't run", func() {
+			s.timers[0].Stop()
+			So(s.runDue(now.Add(5*time.Second)), ShouldEqual, 1)
+			So(ran, ShouldResemble, []string{"second"})
+		})
+
+		Convey("repeating timers are rescheduled", func() {
+			s = scheduler{}
+			count := 0
+			s.add(&Timer{when: now, interval: time.Second, f: func() { count++ }})
+			s.runDue(now)
+			when, ok := s.next()
+			So(ok, ShouldBeTrue)
+			So(when, ShouldEqual, now.Add(time.Second))
+			s.runDue(now.Add(time.Second))
+			So(count, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,820 @@
+// Package script is a small interpreter for a subset of Lua used to configure and script jkl.
+//
+// It has local and global variables, functions and closures, tables, multiple return values and
+// the usual statements and operators, but no metatables, coroutines, varargs, goto or patterns.
+// Scripts can only reach outside the interpreter through the functions the host adds, there is
+// no io or os library, and a script that runs for too long is stopped.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a script value: nil, bool, float64, string, *Table, *Function or *GoFunction.
+type Value interface{}
+
+// Function is a function defined by a script.
+type Function struct {
+	name   string
+	params []string
+	body   block
+	scope  *scope
+	chunk  string
+}
+
+// GoFunction is a function the host gives scripts, it returns any number of values.
+type GoFunction struct {
+	Name string
+	Fn   func(args []Value) ([]Value, error)
+}
+
+// NewFunction constructs a GoFunction.
+func NewFunction(name string, fn func(args []Value) ([]Value, error)) *GoFunction {
+	return &GoFunction{Name: name, Fn: fn}
+}
+
+// Error is an error in a script and where it happened.
+type Error struct {
+	Chunk   string
+	Line    int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", err.Chunk, err.Line, err.Message)
+}
+
+// DefaultMaxSteps is how many statements a script can run each time the host runs or calls it.
+const DefaultMaxSteps = 10000000
+
+const maxDepth = 200
+
+// Interpreter runs scripts, the globals are shared by everything it runs. It isn't safe for concurrent use.
+type Interpreter struct {
+	// MaxSteps is how many statements can run before a script is stopped, 0 for no limit.
+	MaxSteps int
+	// Print shows what scripts print, it is discarded if nil.
+	Print func(text string)
+
+	globals *Table
+	steps   int
+	depth   int
+}
+
+// scope holds the local variables of a block.
+type scope struct {
+	vars   map[string]*Value
+	parent *scope
+}
+
+func (s *scope) lookup(name string) *Value {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *scope) declare(name string, value Value) {
+	s.vars[name] = &value
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]*Value{}, parent: parent}
+}
+
+// Control flow leaving a block early.
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+// New constructs an Interpreter with the standard library.
+func New() *Interpreter {
+	interp := &Interpreter{MaxSteps: DefaultMaxSteps, globals: NewTable()}
+	interp.openLibrary()
+	return interp
+}
+
+// Globals returns the table of global variables.
+func (interp *Interpreter) Globals() *Table {
+	return interp.globals
+}
+
+// SetGlobal sets a global variable.
+func (interp *Interpreter) SetGlobal(name string, value Value) {
+	interp.globals.Set(name, value)
+}
+
+// Global returns a global variable.
+func (interp *Interpreter) Global(name string) Value {
+	return interp.globals.Get(name)
+}
+
+// Run runs a chunk of script and returns what it returns, chunk names it in errors.
+func (interp *Interpreter) Run(chunk, source string) ([]Value, error) {
+	body, err := parse(chunk, source)
+	if err != nil {
+		return nil, err
+	}
+	fn := &Function{name: chunk, body: body, scope: newScope(nil), chunk: chunk}
+	return interp.Call(fn)
+}
+
+// Eval returns the value of an expression.
+func (interp *Interpreter) Eval(chunk, expr string) (Value, error) {
+	values, err := interp.Run(chunk, "return "+expr)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// Call calls a script or Go function from the host. Go runtime errors in the call are returned as script errors.
+func (interp *Interpreter) Call(fn Value, args ...Value) (results []Value, err error) {
+	if interp.depth == 0 {
+		interp.steps = 0
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			results, err = nil, &Error{Chunk: "?", Message: fmt.Sprint("Runtime error: ", r)}
+		}
+	}()
+	return interp.call(fn, args, "?", 0)
+}
+
+func (interp *Interpreter) call(fn Value, args []Value, chunk string, line int) ([]Value, error) {
+	switch fn := fn.(type) {
+	case *GoFunction:
+		results, err := fn.Fn(args)
+		if err != nil {
+			var scriptError *Error
+			if errors.As(err, &scriptError) {
+				return nil, err
+			}
+			return nil, &Error{Chunk: chunk, Line: line, Message: err.Error()}
+		}
+		return results, nil
+
+	case *Function:
+		if interp.depth >= maxDepth {
+			return nil, &Error{Chunk: chunk, Line: line, Message: "Stack overflow"}
+		}
+		interp.depth++
+		defer func() { interp.depth-- }()
+
+		s := newScope(fn.scope)
+		for i, param := range fn.params {
+			var arg Value
+			if i < len(args) {
+				arg = args[i]
+			}
+			s.declare(param, arg)
+		}
+		_, results, err := interp.exec(fn.chunk, fn.body, s)
+		return results, err
+	}
+	return nil, &Error{Chunk: chunk, Line: line, Message: "Attempt to call a " + TypeName(fn) + " value"}
+}
+
+// exec runs the statements of a block in a scope.
+func (interp *Interpreter) exec(chunk string, body block, s *scope) (flow, []Value, error) {
+	if len(body) == 0 {
+		return flowNormal, nil, interp.step(chunk, 0)
+	}
+	for _, statement := range body {
+		if err := interp.step(chunk, statementLine(statement)); err != nil {
+			return flowNormal, nil, err
+		}
+
+		f, values, err := interp.statement(chunk, statement, s)
+		if err != nil || f != flowNormal {
+			return f, values, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// step counts a statement and stops the script once it has run too many.
+func (interp *Interpreter) step(chunk string, line int) error {
+	interp.steps++
+	if interp.MaxSteps > 0 && interp.steps > interp.MaxSteps {
+		return &Error{Chunk: chunk, Line: line, Message: "Script ran for too long"}
+	}
+	return nil
+}
+
+// statementLine returns the line of a statement for errors that don't have a better one.
+func statementLine(s statement) int {
+	switch s := s.(type) {
+	case assignStatement:
+		return s.line
+	case numericFor:
+		return s.line
+	case genericFor:
+		return s.line
+	case callStatement:
+		return expressionLine(s.call)
+	}
+	return 0
+}
+
+func expressionLine(e expression) int {
+	switch e := e.(type) {
+	case callExpr:
+		return e.line
+	case methodCall:
+		return e.line
+	}
+	return 0
+}
+
+func (interp *Interpreter) statement(chunk string, s statement, sc *scope) (flow, []Value, error) {
+	switch s := s.(type) {
+	case localStatement:
+		values, err := interp.evalList(chunk, s.exprs, sc, len(s.names))
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, name := range s.names {
+			sc.declare(name, values[i])
+		}
+
+	case localFunction:
+		sc.declare(s.name, nil)
+		fn := interp.closure(chunk, s.fn, sc)
+		*sc.lookup(s.name) = fn
+
+	case assignStatement:
+		values, err := interp.evalList(chunk, s.exprs, sc, len(s.targets))
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, target := range s.targets {
+			if err := interp.assign(chunk, target, values[i], sc); err != nil {
+				return flowNormal, nil, err
+			}
+		}
+
+	case callStatement:
+		if _, err := interp.evalMulti(chunk, s.call, sc); err != nil {
+			return flowNormal, nil, err
+		}
+
+	case doStatement:
+		return interp.exec(chunk, s.body, newScope(sc))
+
+	case whileStatement:
+		for {
+			cond, err := interp.eval(chunk, s.cond, sc)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if !Truthy(cond) {
+				break
+			}
+			f, values, err := interp.exec(chunk, s.body, newScope(sc))
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+		}
+
+	case repeatStatement:
+		for {
+			inner := newScope(sc)
+			f, values, err := interp.exec(chunk, s.body, inner)
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+			cond, err := interp.eval(chunk, s.cond, inner)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if Truthy(cond) {
+				break
+			}
+		}
+
+	case ifStatement:
+		for i, condExpr := range s.conds {
+			cond, err := interp.eval(chunk, condExpr, sc)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if Truthy(cond) {
+				return interp.exec(chunk, s.blocks[i], newScope(sc))
+			}
+		}
+		if s.otherwise != nil {
+			return interp.exec(chunk, s.otherwise, newScope(sc))
+		}
+
+	case numericFor:
+		return interp.numericFor(chunk, s, sc)
+
+	case genericFor:
+		return interp.genericFor(chunk, s, sc)
+
+	case returnStatement:
+		values, err := interp.evalList(chunk, s.exprs, sc, -1)
+		return flowReturn, values, err
+
+	case breakStatement:
+		return flowBreak, nil, nil
+	}
+	return flowNormal, nil, nil
+}
+
+func (interp *Interpreter) numericFor(chunk string, s numericFor, sc *scope) (flow, []Value, error) {
+	bounds := []float64{}
+	for _, expr := range []expression{s.start, s.limit, s.step} {
+		if expr == nil {
+			bounds = append(bounds, 1)
+			continue
+		}
+		value, err := interp.eval(chunk, expr, sc)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		n, ok := toNumber(value)
+		if !ok {
+			return flowNormal, nil, &Error{Chunk: chunk, Line: s.line, Message: "'for' value must be a number"}
+		}
+		bounds = append(bounds, n)
+	}
+	start, limit, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return flowNormal, nil, &Error{Chunk: chunk, Line: s.line, Message: "'for' step is zero"}
+	}
+
+	for i := start; step > 0 && i <= limit || step < 0 && i >= limit; i += step {
+		inner := newScope(sc)
+		inner.declare(s.name, i)
+		f, values, err := interp.exec(chunk, s.body, inner)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (interp *Interpreter) genericFor(chunk string, s genericFor, sc *scope) (flow, []Value, error) {
+	values, err := interp.evalList(chunk, s.exprs, sc, 3)
+	if err != nil {
+		return flowNormal, nil, err
+	}
+	iterator, state, control := values[0], values[1], values[2]
+
+	for {
+		results, err := interp.call(iterator, []Value{state, control}, chunk, s.line)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		if len(results) == 0 || results[0] == nil {
+			break
+		}
+		control = results[0]
+
+		inner := newScope(sc)
+		for i, name := range s.names {
+			var value Value
+			if i < len(results) {
+				value = results[i]
+			}
+			inner.declare(name, value)
+		}
+		f, values, err := interp.exec(chunk, s.body, inner)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// assign stores a value in a variable or table field.
+func (interp *Interpreter) assign(chunk string, target expression, value Value, sc *scope) error {
+	switch target := target.(type) {
+	case nameExpr:
+		if v := sc.lookup(target.name); v != nil {
+			*v = value
+			return nil
+		}
+		interp.globals.Set(target.name, value)
+		return nil
+
+	case indexExpr:
+		object, err := interp.eval(chunk, target.object, sc)
+		if err != nil {
+			return err
+		}
+		key, err := interp.eval(chunk, target.key, sc)
+		if err != nil {
+			return err
+		}
+		table, ok := object.(*Table)
+		if !ok {
+			return &Error{Chunk: chunk, Line: target.line, Message: "Attempt to index a " + TypeName(object) + " value" + describeExpr(target.object)}
+		}
+		if err := table.set(key, value); err != nil {
+			return &Error{Chunk: chunk, Line: target.line, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// evalList evaluates expressions, the last one can give several values. With want >= 0
+// the values are truncated or padded with nil to that many.
+func (interp *Interpreter) evalList(chunk string, exprs []expression, sc *scope, want int) ([]Value, error) {
+	values := []Value{}
+	for i, expr := range exprs {
+		if i == len(exprs)-1 {
+			last, err := interp.evalMulti(chunk, expr, sc)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, last...)
+			break
+		}
+		value, err := interp.eval(chunk, expr, sc)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if want >= 0 {
+		for len(values) < want {
+			values = append(values, nil)
+		}
+		values = values[:want]
+	}
+	return values, nil
+}
+
+// evalMulti evaluates an expression that can give several values, a function call.
+func (interp *Interpreter) evalMulti(chunk string, expr expression, sc *scope) ([]Value, error) {
+	switch e := expr.(type) {
+	case callExpr:
+		fn, err := interp.eval(chunk, e.fn, sc)
+		if err != nil {
+			return nil, err
+		}
+		args, err := interp.evalList(chunk, e.args, sc, -1)
+		if err != nil {
+			return nil, err
+		}
+		if !callable(fn) {
+			return nil, &Error{Chunk: chunk, Line: e.line, Message: "Attempt to call a " + TypeName(fn) + " value" + describeExpr(e.fn)}
+		}
+		return interp.call(fn, args, chunk, e.line)
+
+	case methodCall:
+		object, err := interp.eval(chunk, e.object, sc)
+		if err != nil {
+			return nil, err
+		}
+		fn, err := interp.index(object, e.name, chunk, e.line, e.object)
+		if err != nil {
+			return nil, err
+		}
+		args, err := interp.evalList(chunk, e.args, sc, -1)
+		if err != nil {
+			return nil, err
+		}
+		if !callable(fn) {
+			return nil, &Error{Chunk: chunk, Line: e.line, Message: fmt.Sprintf("Attempt to call a %s value (method '%s')", TypeName(fn), e.name)}
+		}
+		return interp.call(fn, append([]Value{object}, args...), chunk, e.line)
+	}
+
+	value, err := interp.eval(chunk, expr, sc)
+	return []Value{value}, err
+}
+
+// eval evaluates an expression to a single value.
+func (interp *Interpreter) eval(chunk string, expr expression, sc *scope) (Value, error) {
+	switch e := expr.(type) {
+	case constant:
+		return e.value, nil
+
+	case nameExpr:
+		if v := sc.lookup(e.name); v != nil {
+			return *v, nil
+		}
+		return interp.globals.Get(e.name), nil
+
+	case indexExpr:
+		object, err := interp.eval(chunk, e.object, sc)
+		if err != nil {
+			return nil, err
+		}
+		key, err := interp.eval(chunk, e.key, sc)
+		if err != nil {
+			return nil, err
+		}
+		return interp.index(object, key, chunk, e.line, e.object)
+
+	case callExpr, methodCall:
+		values, err := interp.evalMulti(chunk, expr, sc)
+		if err != nil || len(values) == 0 {
+			return nil, err
+		}
+		return values[0], nil
+
+	case parenExpr:
+		return interp.eval(chunk, e.inner, sc)
+
+	case *functionExpr:
+		return interp.closure(chunk, e, sc), nil
+
+	case tableExpr:
+		return interp.table(chunk, e, sc)
+
+	case unaryExpr:
+		operand, err := interp.eval(chunk, e.operand, sc)
+		if err != nil {
+			return nil, err
+		}
+		return unary(e.op, operand, chunk, e.line)
+
+	case binaryExpr:
+		left, err := interp.eval(chunk, e.left, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.op == "and" && !Truthy(left), e.op == "or" && Truthy(left):
+			return left, nil
+		}
+		right, err := interp.eval(chunk, e.right, sc)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "and" || e.op == "or" {
+			return right, nil
+		}
+		return binary(e.op, left, right, chunk, e.line)
+	}
+	return nil, fmt.Errorf("Unknown expression %T", expr)
+}
+
+func (interp *Interpreter) closure(chunk string, fn *functionExpr, sc *scope) *Function {
+	return &Function{name: fn.name, params: fn.params, body: fn.body, scope: sc, chunk: chunk}
+}
+
+func (interp *Interpreter) table(chunk string, e tableExpr, sc *scope) (Value, error) {
+	table := NewTable()
+	n := 0
+	for i, keyExpr := range e.keys {
+		if keyExpr == nil && i == len(e.keys)-1 {
+			values, err := interp.evalMulti(chunk, e.values[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				n++
+				table.Set(float64(n), value)
+			}
+			break
+		}
+
+		value, err := interp.eval(chunk, e.values[i], sc)
+		if err != nil {
+			return nil, err
+		}
+		if keyExpr == nil {
+			n++
+			table.Set(float64(n), value)
+			continue
+		}
+		key, err := interp.eval(chunk, keyExpr, sc)
+		if err != nil {
+			return nil, err
+		}
+		if err := table.set(key, value); err != nil {
+			return nil, &Error{Chunk: chunk, Line: e.line, Message: err.Error()}
+		}
+	}
+	return table, nil
+}
+
+// index returns a field of a table, strings have the functions of the string library as methods.
+func (interp *Interpreter) index(object, key Value, chunk string, line int, expr expression) (Value, error) {
+	switch object := object.(type) {
+	case *Table:
+		return object.Get(key), nil
+	case string:
+		if library, ok := interp.globals.Get("string").(*Table); ok {
+			return library.Get(key), nil
+		}
+	}
+	return nil, &Error{Chunk: chunk, Line: line, Message: "Attempt to index a " + TypeName(object) + " value" + describeExpr(expr)}
+}
+
+// describeExpr names the variable an expression is, for errors.
+func describeExpr(expr expression) string {
+	switch e := expr.(type) {
+	case nameExpr:
+		return fmt.Sprintf(" (variable '%s')", e.name)
+	case indexExpr:
+		if key, ok := e.key.(constant); ok {
+			if name, ok := key.value.(string); ok {
+				return fmt.Sprintf(" (field '%s')", name)
+			}
+		}
+	}
+	return ""
+}
+
+func callable(fn Value) bool {
+	switch fn.(type) {
+	case *Function, *GoFunction:
+		return true
+	}
+	return false
+}
+
+func unary(op string, operand Value, chunk string, line int) (Value, error) {
+	switch op {
+	case "not":
+		return !Truthy(operand), nil
+	case "-":
+		if n, ok := toNumber(operand); ok {
+			return -n, nil
+		}
+	case "#":
+		switch operand := operand.(type) {
+		case string:
+			return float64(len(operand)), nil
+		case *Table:
+			return float64(operand.Len()), nil
+		}
+		return nil, &Error{Chunk: chunk, Line: line, Message: "Attempt to get length of a " + TypeName(operand) + " value"}
+	}
+	return nil, &Error{Chunk: chunk, Line: line, Message: "Attempt to perform arithmetic on a " + TypeName(operand) + " value"}
+}
+
+func binary(op string, left, right Value, chunk string, line int) (Value, error) {
+	fail := func(message string) (Value, error) {
+		return nil, &Error{Chunk: chunk, Line: line, Message: message}
+	}
+
+	switch op {
+	case "==":
+		return Equal(left, right), nil
+	case "~=":
+		return !Equal(left, right), nil
+
+	case "..":
+		l, lok := concatString(left)
+		r, rok := concatString(right)
+		if !lok || !rok {
+			bad := left
+			if lok {
+				bad = right
+			}
+			return fail("Attempt to concatenate a " + TypeName(bad) + " value")
+		}
+		return l + r, nil
+
+	case "<", "<=", ">", ">=":
+		if op == ">" || op == ">=" {
+			left, right = right, left
+			op = strings.Replace(op, ">", "<", 1)
+		}
+		if l, ok := left.(float64); ok {
+			if r, ok := right.(float64); ok {
+				return l < r || op == "<=" && l == r, nil
+			}
+		}
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l < r || op == "<=" && l == r, nil
+			}
+		}
+		return fail("Attempt to compare " + TypeName(left) + " with " + TypeName(right))
+	}
+
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok {
+		bad := left
+		if lok {
+			bad = right
+		}
+		return fail("Attempt to perform arithmetic on a " + TypeName(bad) + " value")
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "%":
+		return l - math.Floor(l/r)*r, nil
+	case "^":
+		return math.Pow(l, r), nil
+	}
+	return fail("Unknown operator " + op)
+}
+
+func concatString(value Value) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case float64:
+		return formatNumber(value), true
+	}
+	return "", false
+}
+
+// toNumber converts a number or a string holding one to a number.
+func toNumber(value Value) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Truthy returns false for nil and false, true for every other value.
+func Truthy(value Value) bool {
+	return value != nil && value != false
+}
+
+// Equal compares values the way the == operator does, tables and functions are equal only to themselves.
+func Equal(a, b Value) bool {
+	return a == b
+}
+
+// TypeName returns the type of a value as the type function names it.
+func TypeName(value Value) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function, *GoFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+// ToString converts a value to a string the way the tostring function does.
+func ToString(value Value) string {
+	switch value := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return formatNumber(value)
+	case string:
+		return value
+	case *Function:
+		return fmt.Sprintf("function: %p", value)
+	case *GoFunction:
+		return "function: builtin: " + value.Name
+	}
+	return fmt.Sprintf("%s: %p", TypeName(value), value)
+}
+
+// formatNumber writes whole numbers without a fraction.
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
@@ -0,0 +1,221 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenNumber
+	tokenString
+	tokenKeyword
+	tokenSymbol
+)
+
+// token is a word of a script, text is the keyword or symbol itself for those.
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+	line   int
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+	"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
+}
+
+// symbols are the operators and punctuation, longest first so ".." is preferred over ".".
+var symbols = []string{
+	"==", "~=", "<=", ">=", "..",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// lexer splits a script into tokens.
+type lexer struct {
+	chunk  string
+	source string
+	pos    int
+	line   int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &Error{Chunk: l.chunk, Line: l.line, Message: fmt.Sprintf(format, args...)}
+}
+
+// tokens returns every token of the source followed by tokenEOF.
+func (l *lexer) tokens() ([]token, error) {
+	l.line = 1
+	tokens := []token{}
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.source[l.pos]
+	switch {
+	case isLetter(c):
+		start := l.pos
+		for l.pos < len(l.source) && (isLetter(l.source[l.pos]) || isDigit(l.source[l.pos])) {
+			l.pos++
+		}
+		word := l.source[start:l.pos]
+		if keywords[word] {
+			return token{kind: tokenKeyword, text: word, line: l.line}, nil
+		}
+		return token{kind: tokenName, text: word, line: l.line}, nil
+
+	case isDigit(c) || c == '.' && l.pos+1 < len(l.source) && isDigit(l.source[l.pos+1]):
+		return l.number()
+
+	case c == '"' || c == '\'':
+		return l.quoted(c)
+
+	case c == '[' && strings.HasPrefix(l.source[l.pos:], "[["):
+		line := l.line
+		text, err := l.long()
+		return token{kind: tokenString, text: text, line: line}, err
+	}
+
+	for _, symbol := range symbols {
+		if strings.HasPrefix(l.source[l.pos:], symbol) {
+			l.pos += len(symbol)
+			return token{kind: tokenSymbol, text: symbol, line: l.line}, nil
+		}
+	}
+	return token{}, l.errorf("Unexpected symbol %q", c)
+}
+
+// skipSpace skips white space and comments.
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.source[l.pos:], "--[["):
+			l.pos += 2
+			if _, err := l.long(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(l.source[l.pos:], "--"):
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.source[l.pos:], "0x") || strings.HasPrefix(l.source[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(l.source) && strings.IndexByte("0123456789abcdefABCDEF", l.source[l.pos]) != -1 {
+			l.pos++
+		}
+		n, err := strconv.ParseInt(l.source[start+2:l.pos], 16, 64)
+		if err != nil {
+			return token{}, l.errorf("Malformed number %s", l.source[start:l.pos])
+		}
+		return token{kind: tokenNumber, number: float64(n), line: l.line}, nil
+	}
+
+	for l.pos < len(l.source) && (isDigit(l.source[l.pos]) || l.source[l.pos] == '.') {
+		l.pos++
+	}
+	if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+			l.pos++
+		}
+		for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+			l.pos++
+		}
+	}
+	n, err := strconv.ParseFloat(l.source[start:l.pos], 64)
+	if err != nil {
+		return token{}, l.errorf("Malformed number %s", l.source[start:l.pos])
+	}
+	return token{kind: tokenNumber, number: n, line: l.line}, nil
+}
+
+func (l *lexer) quoted(quote byte) (token, error) {
+	line := l.line
+	text := strings.Builder{}
+	for l.pos++; l.pos < len(l.source); l.pos++ {
+		c := l.source[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return token{kind: tokenString, text: text.String(), line: line}, nil
+		case c == '\n':
+			return token{}, l.errorf("Unfinished string")
+		case c == '\\' && l.pos+1 < len(l.source):
+			l.pos++
+			escaped, ok := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', '0': 0, '\\': '\\', '"': '"', '\'': '\'', '\n': '\n'}[l.source[l.pos]]
+			if !ok {
+				return token{}, l.errorf("Invalid escape sequence \\%c", l.source[l.pos])
+			}
+			if escaped == '\n' {
+				l.line++
+			}
+			text.WriteByte(escaped)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	return token{}, l.errorf("Unfinished string")
+}
+
+// long reads a [[long string]] or the rest of a --[[block comment]], a newline straight after [[ is skipped.
+func (l *lexer) long() (string, error) {
+	l.pos += 2
+	if strings.HasPrefix(l.source[l.pos:], "\n") {
+		l.pos++
+		l.line++
+	}
+	end := strings.Index(l.source[l.pos:], "]]")
+	if end == -1 {
+		return "", l.errorf("Unfinished long string")
+	}
+	text := l.source[l.pos : l.pos+end]
+	l.line += strings.Count(text, "\n")
+	l.pos += end + 2
+	return text, nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,515 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxString is the longest string string.rep makes.
+const maxString = 16 << 20
+
+// openLibrary adds the standard functions and the string, table and math libraries.
+func (interp *Interpreter) openLibrary() {
+	functions := map[string]func(args []Value) ([]Value, error){
+		"print": func(args []Value) ([]Value, error) {
+			texts := []string{}
+			for _, arg := range args {
+				texts = append(texts, ToString(arg))
+			}
+			if interp.Print != nil {
+				interp.Print(strings.Join(texts, "\t"))
+			}
+			return nil, nil
+		},
+		"type": func(args []Value) ([]Value, error) {
+			if len(args) == 0 {
+				return nil, errors.New("Bad argument #1 to 'type' (value expected)")
+			}
+			return []Value{TypeName(args[0])}, nil
+		},
+		"tostring": func(args []Value) ([]Value, error) {
+			return []Value{ToString(Arg(args, 0))}, nil
+		},
+		"tonumber": func(args []Value) ([]Value, error) {
+			if n, ok := toNumber(Arg(args, 0)); ok {
+				return []Value{n}, nil
+			}
+			return []Value{nil}, nil
+		},
+		"ipairs": func(args []Value) ([]Value, error) {
+			table, err := CheckTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			next := NewFunction("ipairs", func(args []Value) ([]Value, error) {
+				i, _ := toNumber(Arg(args, 1))
+				value := table.Get(i + 1)
+				if value == nil {
+					return []Value{nil}, nil
+				}
+				return []Value{i + 1, value}, nil
+			})
+			return []Value{next, table, float64(0)}, nil
+		},
+		"pairs": func(args []Value) ([]Value, error) {
+			table, err := CheckTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			keys := table.Keys()
+			next := NewFunction("pairs", func(args []Value) ([]Value, error) {
+				for len(keys) > 0 {
+					key := keys[0]
+					keys = keys[1:]
+					if value := table.Get(key); value != nil {
+						return []Value{key, value}, nil
+					}
+				}
+				return []Value{nil}, nil
+			})
+			return []Value{next, table, nil}, nil
+		},
+		"error": func(args []Value) ([]Value, error) {
+			return nil, errors.New(ToString(Arg(args, 0)))
+		},
+		"assert": func(args []Value) ([]Value, error) {
+			if Truthy(Arg(args, 0)) {
+				return args, nil
+			}
+			if message := Arg(args, 1); message != nil {
+				return nil, errors.New(ToString(message))
+			}
+			return nil, errors.New("Assertion failed!")
+		},
+		"pcall": func(args []Value) ([]Value, error) {
+			if len(args) == 0 {
+				return nil, errors.New("Bad argument #1 to 'pcall' (value expected)")
+			}
+			results, err := interp.call(args[0], args[1:], "?", 0)
+			if err != nil {
+				return []Value{false, err.Error()}, nil
+			}
+			return append([]Value{true}, results...), nil
+		},
+		"unpack": unpack,
+	}
+	for name, fn := range functions {
+		interp.SetGlobal(name, NewFunction(name, fn))
+	}
+
+	interp.SetGlobal("string", library("string", map[string]func(args []Value) ([]Value, error){
+		"len":    stringLen,
+		"sub":    stringSub,
+		"upper":  stringMap(strings.ToUpper),
+		"lower":  stringMap(strings.ToLower),
+		"rep":    stringRep,
+		"find":   stringFind,
+		"format": stringFormat,
+		"split":  stringSplit,
+		"trim":   stringMap(strings.TrimSpace),
+	}))
+	interp.SetGlobal("table", library("table", map[string]func(args []Value) ([]Value, error){
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"concat": tableConcat,
+		"unpack": unpack,
+		"sort": func(args []Value) ([]Value, error) {
+			return tableSort(interp, args)
+		},
+	}))
+
+	maths := library("math", map[string]func(args []Value) ([]Value, error){
+		"floor": mathFunction(math.Floor),
+		"ceil":  mathFunction(math.Ceil),
+		"abs":   mathFunction(math.Abs),
+		"sqrt":  mathFunction(math.Sqrt),
+		"max":   mathExtreme(func(a, b float64) bool { return a > b }),
+		"min":   mathExtreme(func(a, b float64) bool { return a < b }),
+	})
+	maths.Set("huge", math.Inf(1))
+	maths.Set("pi", math.Pi)
+	interp.SetGlobal("math", maths)
+}
+
+func library(name string, functions map[string]func(args []Value) ([]Value, error)) *Table {
+	table := NewTable()
+	names := []string{}
+	for fn := range functions {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+	for _, fn := range names {
+		table.Set(fn, NewFunction(name+"."+fn, functions[fn]))
+	}
+	return table
+}
+
+// Arg returns an argument, nil if there aren't that many.
+func Arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// CheckString returns an argument that must be a string, numbers are converted.
+func CheckString(args []Value, i int) (string, error) {
+	switch value := Arg(args, i).(type) {
+	case string:
+		return value, nil
+	case float64:
+		return formatNumber(value), nil
+	}
+	return "", badArgument(args, i, "string")
+}
+
+// CheckInt returns an argument that must be a whole number.
+func CheckInt(args []Value, i int) (int, error) {
+	n, ok := toNumber(Arg(args, i))
+	if !ok || n != math.Trunc(n) {
+		return 0, badArgument(args, i, "integer")
+	}
+	return int(n), nil
+}
+
+// CheckTable returns an argument that must be a table.
+func CheckTable(args []Value, i int) (*Table, error) {
+	if table, ok := Arg(args, i).(*Table); ok {
+		return table, nil
+	}
+	return nil, badArgument(args, i, "table")
+}
+
+// CheckFunction returns an argument that must be a function.
+func CheckFunction(args []Value, i int) (Value, error) {
+	if fn := Arg(args, i); callable(fn) {
+		return fn, nil
+	}
+	return nil, badArgument(args, i, "function")
+}
+
+func badArgument(args []Value, i int, want string) error {
+	got := "no value"
+	if i < len(args) {
+		got = TypeName(args[i])
+	}
+	return fmt.Errorf("Bad argument #%d (%s expected, got %s)", i+1, want, got)
+}
+
+func stringLen(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	return []Value{float64(len(s))}, err
+}
+
+// stringSub returns the bytes from i to j, negative positions count from the end.
+func stringSub(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	i, j := 1, -1
+	if Arg(args, 1) != nil {
+		if i, err = CheckInt(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	if Arg(args, 2) != nil {
+		if j, err = CheckInt(args, 2); err != nil {
+			return nil, err
+		}
+	}
+
+	position := func(n int) int {
+		if n < 0 {
+			return len(s) + n + 1
+		}
+		return n
+	}
+	i, j = position(i), position(j)
+	if i < 1 {
+		i = 1
+	}
+	if j > len(s) {
+		j = len(s)
+	}
+	if i > j {
+		return []Value{""}, nil
+	}
+	return []Value{s[i-1 : j]}, nil
+}
+
+func stringMap(f func(string) string) func(args []Value) ([]Value, error) {
+	return func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		return []Value{f(s)}, err
+	}
+}
+
+func stringRep(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := CheckInt(args, 1)
+	if err != nil || n < 0 {
+		return []Value{""}, err
+	}
+	if len(s) > 0 && n > maxString/len(s) {
+		return nil, errors.New("Resulting string too large")
+	}
+	return []Value{strings.Repeat(s, n)}, nil
+}
+
+// stringFind looks for plain text, patterns aren't supported. It returns where it starts and ends.
+func stringFind(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	text, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	start := 1
+	if Arg(args, 2) != nil {
+		if start, err = CheckInt(args, 2); err != nil {
+			return nil, err
+		}
+	}
+	if start < 1 || start > len(s)+1 {
+		return []Value{nil}, nil
+	}
+
+	i := strings.Index(s[start-1:], text)
+	if i == -1 {
+		return []Value{nil}, nil
+	}
+	i += start
+	return []Value{float64(i), float64(i + len(text) - 1)}, nil
+}
+
+// stringFormat formats like fmt.Sprintf with %d, %s, %q, %x and %f style verbs.
+func stringFormat(args []Value) ([]Value, error) {
+	format, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := strings.Builder{}
+	arg := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			result.WriteByte(format[i])
+			continue
+		}
+		end := i + 1
+		for end < len(format) && strings.IndexByte("-+ #0123456789.", format[end]) != -1 {
+			end++
+		}
+		if end == len(format) {
+			return nil, errors.New("Invalid format string")
+		}
+		verb := format[i : end+1]
+		i = end
+
+		switch format[end] {
+		case '%':
+			result.WriteByte('%')
+			continue
+		case 'd', 'x', 'X', 'c':
+			n, err := CheckInt(args, arg)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&result, verb, n)
+		case 'f', 'g', 'e':
+			n, ok := toNumber(Arg(args, arg))
+			if !ok {
+				return nil, badArgument(args, arg, "number")
+			}
+			fmt.Fprintf(&result, verb, n)
+		case 's':
+			fmt.Fprintf(&result, verb, ToString(Arg(args, arg)))
+		case 'q':
+			result.WriteString(strconv.Quote(ToString(Arg(args, arg))))
+		default:
+			return nil, fmt.Errorf("Invalid conversion '%s' to 'format'", verb)
+		}
+		arg++
+	}
+	return []Value{result.String()}, nil
+}
+
+// stringSplit splits a string around a separator, into lines by default.
+func stringSplit(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	separator := "\n"
+	if Arg(args, 1) != nil {
+		if separator, err = CheckString(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	list := NewTable()
+	for i, part := range strings.Split(s, separator) {
+		list.Set(float64(i+1), part)
+	}
+	return []Value{list}, nil
+}
+
+// tableInsert appends a value, or inserts it at a position moving the values after it up.
+func tableInsert(args []Value) ([]Value, error) {
+	table, err := CheckTable(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		table.Set(float64(table.Len()+1), Arg(args, 1))
+		return nil, nil
+	}
+
+	position, err := CheckInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	length := table.Len()
+	if position < 1 || position > length+1 {
+		return nil, errors.New("Bad argument #2 to 'insert' (position out of bounds)")
+	}
+	for i := length; i >= position; i-- {
+		table.Set(float64(i+1), table.Get(float64(i)))
+	}
+	table.Set(float64(position), args[2])
+	return nil, nil
+}
+
+// tableRemove removes and returns the last value, or the one at a position moving the values after it down.
+func tableRemove(args []Value) ([]Value, error) {
+	table, err := CheckTable(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	length := table.Len()
+	position := length
+	if Arg(args, 1) != nil {
+		if position, err = CheckInt(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	if length == 0 {
+		return []Value{nil}, nil
+	}
+	if position < 1 || position > length {
+		return nil, errors.New("Bad argument #2 to 'remove' (position out of bounds)")
+	}
+
+	removed := table.Get(float64(position))
+	for i := position; i < length; i++ {
+		table.Set(float64(i), table.Get(float64(i+1)))
+	}
+	table.Set(float64(length), nil)
+	return []Value{removed}, nil
+}
+
+func tableConcat(args []Value) ([]Value, error) {
+	table, err := CheckTable(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	separator := ""
+	if Arg(args, 1) != nil {
+		if separator, err = CheckString(args, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	parts := []string{}
+	for i, value := range table.List() {
+		part, ok := concatString(value)
+		if !ok {
+			return nil, fmt.Errorf("Invalid value (at index %d) in table for 'concat'", i+1)
+		}
+		parts = append(parts, part)
+	}
+	return []Value{strings.Join(parts, separator)}, nil
+}
+
+// tableSort sorts a list in place with < or a function returning true if its first argument goes first.
+func tableSort(interp *Interpreter, args []Value) ([]Value, error) {
+	table, err := CheckTable(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	less := Arg(args, 1)
+
+	list := table.List()
+	var failed error
+	sort.SliceStable(list, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		if less == nil {
+			result, err := binary("<", list[i], list[j], "?", 0)
+			if err != nil {
+				failed = errors.New(err.(*Error).Message)
+			}
+			return result == true
+		}
+		results, err := interp.call(less, []Value{list[i], list[j]}, "?", 0)
+		if err != nil {
+			failed = err
+			return false
+		}
+		return len(results) > 0 && Truthy(results[0])
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	for i, value := range list {
+		table.Set(float64(i+1), value)
+	}
+	return nil, nil
+}
+
+func unpack(args []Value) ([]Value, error) {
+	table, err := CheckTable(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return table.List(), nil
+}
+
+func mathFunction(f func(float64) float64) func(args []Value) ([]Value, error) {
+	return func(args []Value) ([]Value, error) {
+		n, ok := toNumber(Arg(args, 0))
+		if !ok {
+			return nil, badArgument(args, 0, "number")
+		}
+		return []Value{f(n)}, nil
+	}
+}
+
+func mathExtreme(better func(a, b float64) bool) func(args []Value) ([]Value, error) {
+	return func(args []Value) ([]Value, error) {
+		if len(args) == 0 {
+			return nil, badArgument(args, 0, "number")
+		}
+		var best float64
+		for i := range args {
+			n, ok := toNumber(args[i])
+			if !ok {
+				return nil, badArgument(args, i, "number")
+			}
+			if i == 0 || better(n, best) {
+				best = n
+			}
+		}
+		return []Value{best}, nil
+	}
+}
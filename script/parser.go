@@ -0,0 +1,696 @@
+package script
+
+import "fmt"
+
+// The syntax tree of a script, expressions and statements are evaluated by Interpreter.
+
+type block []statement
+
+type statement interface{}
+
+type expression interface{}
+
+type (
+	localStatement struct {
+		names []string
+		exprs []expression
+	}
+	assignStatement struct {
+		targets []expression
+		exprs   []expression
+		line    int
+	}
+	callStatement struct {
+		call expression
+	}
+	doStatement struct {
+		body block
+	}
+	whileStatement struct {
+		cond expression
+		body block
+	}
+	repeatStatement struct {
+		body block
+		cond expression
+	}
+	ifStatement struct {
+		conds     []expression
+		blocks    []block
+		otherwise block
+	}
+	numericFor struct {
+		name               string
+		start, limit, step expression
+		body               block
+		line               int
+	}
+	genericFor struct {
+		names []string
+		exprs []expression
+		body  block
+		line  int
+	}
+	localFunction struct {
+		name string
+		fn   *functionExpr
+	}
+	returnStatement struct {
+		exprs []expression
+	}
+	breakStatement struct{}
+)
+
+type (
+	constant struct {
+		value Value
+	}
+	nameExpr struct {
+		name string
+		line int
+	}
+	indexExpr struct {
+		object, key expression
+		line        int
+	}
+	callExpr struct {
+		fn   expression
+		args []expression
+		line int
+	}
+	methodCall struct {
+		object expression
+		name   string
+		args   []expression
+		line   int
+	}
+	functionExpr struct {
+		name   string
+		params []string
+		body   block
+	}
+	binaryExpr struct {
+		op          string
+		left, right expression
+		line        int
+	}
+	unaryExpr struct {
+		op      string
+		operand expression
+		line    int
+	}
+	tableExpr struct {
+		keys   []expression
+		values []expression
+		line   int
+	}
+	parenExpr struct {
+		inner expression
+	}
+)
+
+// binaryPriority is the left and right priority of each binary operator, right associative ones bind tighter on the right.
+var binaryPriority = map[string][2]int{
+	"or":  {1, 1},
+	"and": {2, 2},
+	"<":   {3, 3},
+	">":   {3, 3},
+	"<=":  {3, 3},
+	">=":  {3, 3},
+	"~=":  {3, 3},
+	"==":  {3, 3},
+	"..":  {5, 4},
+	"+":   {6, 6},
+	"-":   {6, 6},
+	"*":   {7, 7},
+	"/":   {7, 7},
+	"%":   {7, 7},
+	"^":   {10, 9},
+}
+
+const unaryPriority = 8
+
+// parser builds the syntax tree of a chunk from its tokens.
+type parser struct {
+	chunk  string
+	tokens []token
+	pos    int
+}
+
+// parse returns the syntax tree of a chunk.
+func parse(chunk, source string) (block, error) {
+	lex := lexer{chunk: chunk, source: source}
+	tokens, err := lex.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{chunk: chunk, tokens: tokens}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return body, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is returns true if the next token is the keyword or symbol.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokenKeyword || t.kind == tokenSymbol) && t.text == text
+}
+
+// accept skips the keyword or symbol if it is next.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("'%s' expected near %s", text, p.describe(p.peek()))
+	}
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	if p.peek().kind != tokenName {
+		return "", p.errorf("Name expected near %s", p.describe(p.peek()))
+	}
+	return p.advance().text, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Chunk: p.chunk, Line: p.peek().line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) unexpected() error {
+	return p.errorf("Unexpected %s", p.describe(p.peek()))
+}
+
+func (p *parser) describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of script"
+	case tokenNumber:
+		return fmt.Sprintf("'%s'", formatNumber(t.number))
+	case tokenString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// block parses statements until one that ends a block.
+func (p *parser) block() (block, error) {
+	body := block{}
+	for {
+		if p.peek().kind == tokenEOF || p.is("end") || p.is("else") || p.is("elseif") || p.is("until") {
+			return body, nil
+		}
+		if p.accept(";") {
+			continue
+		}
+		if p.accept("return") {
+			ret := returnStatement{}
+			if !p.is("end") && !p.is("else") && !p.is("elseif") && !p.is("until") && !p.is(";") && p.peek().kind != tokenEOF {
+				exprs, err := p.expressions()
+				if err != nil {
+					return nil, err
+				}
+				ret.exprs = exprs
+			}
+			p.accept(";")
+			return append(body, ret), nil
+		}
+
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+}
+
+// blockUntil parses a block followed by the keyword that ends it.
+func (p *parser) blockUntil(end string) (block, error) {
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect(end)
+}
+
+func (p *parser) statement() (statement, error) {
+	line := p.peek().line
+	switch {
+	case p.accept("break"):
+		return breakStatement{}, nil
+
+	case p.accept("do"):
+		body, err := p.blockUntil("end")
+		return doStatement{body: body}, err
+
+	case p.accept("while"):
+		cond, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.blockUntil("end")
+		return whileStatement{cond: cond, body: body}, err
+
+	case p.accept("repeat"):
+		body, err := p.blockUntil("until")
+		if err != nil {
+			return nil, err
+		}
+		cond, err := p.expression(0)
+		return repeatStatement{body: body, cond: cond}, err
+
+	case p.accept("if"):
+		return p.ifStatement()
+
+	case p.accept("for"):
+		return p.forStatement(line)
+
+	case p.accept("function"):
+		return p.functionStatement(line)
+
+	case p.accept("local"):
+		if p.accept("function") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := p.functionBody(name)
+			return localFunction{name: name, fn: fn}, err
+		}
+
+		local := localStatement{}
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			local.names = append(local.names, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if p.accept("=") {
+			exprs, err := p.expressions()
+			if err != nil {
+				return nil, err
+			}
+			local.exprs = exprs
+		}
+		return local, nil
+	}
+
+	expr, err := p.suffixed()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("=") && !p.is(",") {
+		switch expr.(type) {
+		case callExpr, methodCall:
+			return callStatement{call: expr}, nil
+		}
+		return nil, p.errorf("Syntax error near %s", p.describe(p.peek()))
+	}
+
+	assign := assignStatement{targets: []expression{expr}, line: line}
+	for p.accept(",") {
+		target, err := p.suffixed()
+		if err != nil {
+			return nil, err
+		}
+		assign.targets = append(assign.targets, target)
+	}
+	for _, target := range assign.targets {
+		switch target.(type) {
+		case nameExpr, indexExpr:
+		default:
+			return nil, p.errorf("Cannot assign to an expression")
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	assign.exprs, err = p.expressions()
+	return assign, err
+}
+
+func (p *parser) ifStatement() (statement, error) {
+	s := ifStatement{}
+	for {
+		cond, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+
+		if p.accept("elseif") {
+			continue
+		}
+		if p.accept("else") {
+			s.otherwise, err = p.block()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, p.expect("end")
+	}
+}
+
+func (p *parser) forStatement(line int) (statement, error) {
+	first, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("=") {
+		loop := numericFor{name: first, line: line}
+		if loop.start, err = p.expression(0); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if loop.limit, err = p.expression(0); err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			if loop.step, err = p.expression(0); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		loop.body, err = p.blockUntil("end")
+		return loop, err
+	}
+
+	loop := genericFor{names: []string{first}, line: line}
+	for p.accept(",") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		loop.names = append(loop.names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if loop.exprs, err = p.expressions(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	loop.body, err = p.blockUntil("end")
+	return loop, err
+}
+
+// functionStatement parses "function a.b.c:d() end", which assigns to a.b.c.d with a self parameter.
+func (p *parser) functionStatement(line int) (statement, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	var target expression = nameExpr{name: name, line: line}
+	fullName := name
+	method := false
+	for p.is(".") || p.is(":") {
+		method = p.advance().text == ":"
+		field, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		target = indexExpr{object: target, key: constant{value: field}, line: line}
+		fullName += "." + field
+		if method {
+			break
+		}
+	}
+
+	fn, err := p.functionBody(fullName)
+	if err != nil {
+		return nil, err
+	}
+	if method {
+		fn.params = append([]string{"self"}, fn.params...)
+	}
+	return assignStatement{targets: []expression{target}, exprs: []expression{fn}, line: line}, nil
+}
+
+// functionBody parses the parameters and body of a function.
+func (p *parser) functionBody(name string) (*functionExpr, error) {
+	fn := &functionExpr{name: name}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		for {
+			param, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn.params = append(fn.params, param)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.blockUntil("end")
+	fn.body = body
+	return fn, err
+}
+
+func (p *parser) expressions() ([]expression, error) {
+	exprs := []expression{}
+	for {
+		expr, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.accept(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// expression parses binary operators binding tighter than priority.
+func (p *parser) expression(priority int) (expression, error) {
+	var left expression
+	var err error
+	t := p.peek()
+	if p.accept("not") || p.accept("-") || p.accept("#") {
+		operand, err := p.expression(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = unaryExpr{op: t.text, operand: operand, line: t.line}
+	} else if left, err = p.simple(); err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		op, ok := binaryPriority[t.text]
+		if !ok || t.kind == tokenString || t.kind == tokenName || op[0] <= priority {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.expression(op[1])
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: t.text, left: left, right: right, line: t.line}
+	}
+}
+
+// simple parses a value or a suffixed expression.
+func (p *parser) simple() (expression, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber:
+		p.advance()
+		return constant{value: t.number}, nil
+	case t.kind == tokenString:
+		p.advance()
+		return constant{value: t.text}, nil
+	case p.accept("nil"):
+		return constant{value: nil}, nil
+	case p.accept("true"):
+		return constant{value: true}, nil
+	case p.accept("false"):
+		return constant{value: false}, nil
+	case p.accept("function"):
+		return p.functionBody("")
+	case p.is("{"):
+		return p.table()
+	}
+	return p.suffixed()
+}
+
+// suffixed parses a name or parenthesised expression followed by fields, indexes and calls.
+func (p *parser) suffixed() (expression, error) {
+	var expr expression
+	t := p.peek()
+	switch {
+	case t.kind == tokenName:
+		p.advance()
+		expr = nameExpr{name: t.text, line: t.line}
+	case p.accept("("):
+		inner, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		expr = parenExpr{inner: inner}
+	default:
+		return nil, p.unexpected()
+	}
+
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			expr = indexExpr{object: expr, key: constant{value: name}, line: t.line}
+		case p.accept("["):
+			key, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			expr = indexExpr{object: expr, key: key, line: t.line}
+		case p.accept(":"):
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			expr = methodCall{object: expr, name: name, args: args, line: t.line}
+		case p.is("(") || p.is("{") || t.kind == tokenString:
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			expr = callExpr{fn: expr, args: args, line: t.line}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// arguments parses "(a, b)", a table or a string passed to a function.
+func (p *parser) arguments() ([]expression, error) {
+	t := p.peek()
+	if t.kind == tokenString {
+		p.advance()
+		return []expression{constant{value: t.text}}, nil
+	}
+	if p.is("{") {
+		table, err := p.table()
+		return []expression{table}, err
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.accept(")") {
+		return []expression{}, nil
+	}
+	args, err := p.expressions()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+// table parses a table constructor, "{1, 2, name = 3, [key] = 4}".
+func (p *parser) table() (expression, error) {
+	table := tableExpr{line: p.peek().line}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.accept("}") {
+		var key expression
+		switch {
+		case p.accept("["):
+			var err error
+			if key, err = p.expression(0); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+		case p.peek().kind == tokenName && p.tokens[p.pos+1].text == "=" && p.tokens[p.pos+1].kind == tokenSymbol:
+			key = constant{value: p.advance().text}
+			p.advance()
+		}
+
+		value, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		table.keys = append(table.keys, key)
+		table.values = append(table.values, value)
+
+		if !p.accept(",") && !p.accept(";") {
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return table, nil
+}
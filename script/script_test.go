@@ -0,0 +1,274 @@
+package script
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// run runs a script and returns what it returns as strings.
+func run(interp *Interpreter, source string) ([]string, error) {
+	values, err := interp.Run("test", source)
+	texts := []string{}
+	for _, value := range values {
+		texts = append(texts, ToString(value))
+	}
+	return texts, err
+}
+
+func TestInterpreter(t *testing.T) {
+	Convey("An interpreter", t, func() {
+		interp := New()
+		printed := []string{}
+		interp.Print = func(text string) { printed = append(printed, text) }
+
+		Convey("evaluates expressions", func() {
+			for source, want := range map[string]string{
+				"1 + 2 * 3":               "7",
+				"(1 + 2) * 3":             "9",
+				"2 ^ 3 ^ 2":               "512",
+				"-2 ^ 2":                  "-4",
+				"7 % 3":                   "1",
+				"-7 % 3":                  "2",
+				"7 / 2":                   "3.5",
+				"'a' .. 'b' .. 1":         "ab1",
+				"'10' + 1":                "11",
+				"1 < 2 and 'yes' or 'no'": "yes",
+				"nil or false":            "false",
+				"not nil":                 "true",
+				"#'four'":                 "4",
+				"#{1, 2, 3}":              "3",
+				"'abc' < 'abd'":           "true",
+				"2 >= 3":                  "false",
+				"1 == 1.0":                "true",
+				"'1' == 1":                "false",
+				"0x10":                    "16",
+				"1e3":                     "1000",
+				"[[long\nstring]]":        "long\nstring",
+				"'tab\\tand \\'quote\\''": "tab\tand 'quote'",
+			} {
+				value, err := interp.Eval("test", source)
+				So(err, ShouldBeNil)
+				So(ToString(value), ShouldEqual, want)
+			}
+		})
+
+		Convey("runs statements", func() {
+			result, err := run(interp, `
+				-- Comments are skipped
+				local total = 0
+				for i = 1, 10 do
+					if i % 2 == 0 then
+						total = total + i
+					elseif i == 5 then
+						total = total + 100
+					else
+						total = total - 1
+					end
+				end
+
+				local n = 0
+				while true do
+					n = n + 1
+					if n > 3 then break end
+				end
+				repeat n = n - 1 until n == 0
+
+				--[[ Block
+				     comments too ]]
+				local countdown = {}
+				for i = 3, 1, -1 do table.insert(countdown, i) end
+				return total, n, table.concat(countdown, ",")
+			`)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"126", "0", "3,2,1"})
+		})
+
+		Convey("has functions, closures and multiple results", func() {
+			result, err := run(interp, `
+				local function counter()
+					local count = 0
+					return function()
+						count = count + 1
+						return count
+					end
+				end
+				local a, b = counter(), counter()
+				a() a()
+
+				function swap(x, y) return y, x end
+				local x, y = swap(1, 2)
+
+				local function fib(n)
+					if n < 2 then return n end
+					return fib(n - 1) + fib(n - 2)
+				end
+				return a(), b(), x, y, fib(15), select
+			`)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"3", "1", "2", "1", "610", "nil"})
+		})
+
+		Convey("has tables with fields and methods", func() {
+			result, err := run(interp, `
+				local point = {x = 1, y = 2, [3] = "three", "one"}
+				point.z = point.x + point.y
+				function point:sum() return self.x + self.y + self.z end
+
+				local keys = {}
+				for key, value in pairs({a = 1, b = 2, c = 3}) do
+					keys[#keys + 1] = key .. "=" .. value
+				end
+				local list = {}
+				for i, v in ipairs({"a", "b", nil, "d"}) do list[i] = v end
+
+				local words = ("one two"):upper()
+				return point:sum(), point[1], point[3], table.concat(keys, " "), #list, words
+			`)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"6", "one", "three", "a=1 b=2 c=3", "2", "ONE TWO"})
+		})
+
+		Convey("has a small standard library", func() {
+			result, err := run(interp, `
+				local list = {5, 2, 8}
+				table.sort(list)
+				local last = table.remove(list)
+				table.insert(list, 1, 0)
+				local descending = {1, 3, 2}
+				table.sort(descending, function(a, b) return a > b end)
+				print("printed", 1, nil)
+				return table.concat(list, ","), last, table.concat(descending, ""),
+					string.sub("hello", 2, -2), string.find("hello", "ll"),
+					string.format("%d %s %5.1f %q", 3, "x", 2.25, "q"),
+					math.max(3, 9, 1), math.floor(2.7), tostring(nil), tonumber("x"),
+					type({}), #string.split("a,b,c", ",")
+			`)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{
+				"0,2,5", "8", "321", "ell", "3", `3 x   2.2 "q"`, "9", "2", "nil", "nil", "table", "3",
+			})
+			So(printed, ShouldResemble, []string{"printed\t1\tnil"})
+		})
+
+		Convey("keeps globals between runs", func() {
+			_, err := run(interp, "greeting = 'hi'")
+			So(err, ShouldBeNil)
+			So(interp.Global("greeting"), ShouldEqual, "hi")
+			result, _ := run(interp, "return greeting")
+			So(result, ShouldResemble, []string{"hi"})
+		})
+
+		Convey("calls Go functions and is called from Go", func() {
+			interp.SetGlobal("double", NewFunction("double", func(args []Value) ([]Value, error) {
+				n, err := CheckInt(args, 0)
+				return []Value{float64(n * 2)}, err
+			}))
+			_, err := run(interp, "function twice(f, x) return f(f(x)) end")
+			So(err, ShouldBeNil)
+
+			values, err := interp.Call(interp.Global("twice"), interp.Global("double"), 3.0)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []Value{12.0})
+
+			_, err = run(interp, "\n\ndouble('x')")
+			So(err.Error(), ShouldEqual, "test:3: Bad argument #1 (integer expected, got string)")
+		})
+
+		Convey("reports errors with where they happened", func() {
+			for source, want := range map[string]string{
+				"x = ":                    "test:1: Unexpected end of script",
+				"if true then":            "test:1: 'end' expected near end of script",
+				"x = 'open":               "test:1: Unfinished string",
+				"\nmissing()":             "test:2: Attempt to call a nil value (variable 'missing')",
+				"local t = {}\nt.a.b = 1": "test:2: Attempt to index a nil value (field 'a')",
+				"return 1 + {}":           "test:1: Attempt to perform arithmetic on a table value",
+				"return 1 < 'x'":          "test:1: Attempt to compare number with string",
+				"error('Oops')":           "test:1: Oops",
+				"local function f() return f() + 1 end f()": "test:1: Stack overflow",
+			} {
+				_, err := run(interp, source)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, want)
+			}
+		})
+
+		Convey("catches errors with pcall", func() {
+			result, err := run(interp, "return pcall(function(x) error('Bad ' .. x) end, 'thing')")
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"false", "test:1: Bad thing"})
+
+			result, err = run(interp, "return pcall(function(a, b) return a + b end, 1, 2)")
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"true", "3"})
+		})
+
+		Convey("stops scripts that run for too long", func() {
+			interp.MaxSteps = 1000
+			_, err := run(interp, "while true do end")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Script ran for too long")
+
+			Convey("but each run gets its own steps", func() {
+				_, err := run(interp, "for i = 1, 500 do end")
+				So(err, ShouldBeNil)
+				_, err = run(interp, "for i = 1, 500 do end")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("doesn't make strings too large to hold", func() {
+			_, err := run(interp, "return string.rep('ab', 4611686018427387904)")
+			So(err.Error(), ShouldEqual, "test:1: Resulting string too large")
+		})
+
+		Convey("turns Go runtime errors into script errors", func() {
+			interp.SetGlobal("broken", NewFunction("broken", func(args []Value) ([]Value, error) {
+				var t *Table
+				return []Value{t.Get("x")}, nil
+			}))
+			_, err := run(interp, "local function f() return broken() end f()")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "?:0: Runtime error: ")
+
+			result, err := run(interp, "local function f() return 1 end return f()")
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []string{"1"})
+		})
+
+		Convey("has no way to reach files", func() {
+			for _, name := range []string{"io", "os", "require", "dofile", "loadfile", "load"} {
+				So(interp.Global(name), ShouldBeNil)
+			}
+		})
+	})
+}
+
+func TestTable(t *testing.T) {
+	Convey("A table", t, func() {
+		table := NewList("a", "b", "c")
+		So(table.Len(), ShouldEqual, 3)
+
+		Convey("shrinks its list when a value is removed", func() {
+			table.Set(2.0, nil)
+			So(table.Len(), ShouldEqual, 1)
+			So(table.Keys(), ShouldResemble, []Value{1.0, 3.0})
+		})
+
+		Convey("grows its list over values already set", func() {
+			table.Set(5.0, "e")
+			So(table.Len(), ShouldEqual, 3)
+			table.Set(4.0, "d")
+			So(table.Len(), ShouldEqual, 5)
+			So(table.List(), ShouldResemble, []Value{"a", "b", "c", "d", "e"})
+		})
+
+		Convey("keeps the order keys were added in", func() {
+			table.Set("z", 1.0)
+			table.Set("a", 2.0)
+			table.Set(1.0, nil)
+			table.Set(1.0, "again")
+			So(table.Keys(), ShouldResemble, []Value{1.0, 2.0, 3.0, "z", "a"})
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package script
+
+import (
+	"errors"
+	"math"
+)
+
+// Table is the only data structure of scripts, it maps any value but nil and NaN to a value.
+// Its fields are iterated in the order they were added.
+type Table struct {
+	values map[Value]Value
+	keys   []Value
+	added  map[Value]bool
+	length int
+}
+
+// NewTable constructs an empty Table.
+func NewTable() *Table {
+	return &Table{values: map[Value]Value{}, added: map[Value]bool{}}
+}
+
+// NewList constructs a Table holding the values at 1, 2, 3...
+func NewList(values ...Value) *Table {
+	table := NewTable()
+	for i, value := range values {
+		table.Set(float64(i+1), value)
+	}
+	return table
+}
+
+// Get returns the value of a key, nil if it has none.
+func (table *Table) Get(key Value) Value {
+	return table.values[normalizeKey(key)]
+}
+
+// Set sets the value of a key, setting it to nil removes it. Invalid keys are ignored.
+func (table *Table) Set(key Value, value Value) {
+	table.set(key, value)
+}
+
+func (table *Table) set(key Value, value Value) error {
+	key = normalizeKey(key)
+	if key == nil {
+		return errors.New("Table index is nil")
+	}
+	if n, ok := key.(float64); ok && math.IsNaN(n) {
+		return errors.New("Table index is NaN")
+	}
+
+	if value == nil {
+		delete(table.values, key)
+		if n, ok := key.(float64); ok && n >= 1 && n <= float64(table.length) && n == math.Trunc(n) {
+			table.length = int(n) - 1
+		}
+		return nil
+	}
+
+	if !table.added[key] {
+		table.added[key] = true
+		table.keys = append(table.keys, key)
+	}
+	table.values[key] = value
+	if n, ok := key.(float64); ok && n == float64(table.length+1) {
+		for table.values[float64(table.length+1)] != nil {
+			table.length++
+		}
+	}
+	return nil
+}
+
+// Len returns the length of the list in the table, the values at 1, 2, 3... up to the first nil.
+func (table *Table) Len() int {
+	return table.length
+}
+
+// List returns the values at 1, 2, 3... up to the first nil.
+func (table *Table) List() []Value {
+	list := make([]Value, table.length)
+	for i := range list {
+		list[i] = table.values[float64(i+1)]
+	}
+	return list
+}
+
+// Keys returns the keys that have a value in the order they were first added.
+func (table *Table) Keys() []Value {
+	keys := []Value{}
+	for _, key := range table.keys {
+		if _, ok := table.values[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// normalizeKey makes -0 and 0 the same key.
+func normalizeKey(key Value) Value {
+	if n, ok := key.(float64); ok && n == 0 {
+		return float64(0)
+	}
+	return key
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dcbishop/jkl/script"
+	"github.com/spf13/afero"
+)
+
+// Script returns the interpreter that runs the editor's scripts, see package script.
+// Scripts see the editor as the global "editor" and only reach files through the editor's afero.Fs.
+//
+//	editor.echo(text)                  shows a message
+//	editor.confirm(question, f)        asks a question in a dialog and calls f(yes) with the answer
+//	editor.command(line)               runs an ex command, one of scriptCommands or one a script added
+//	editor.map(keys, f)                binds keys in normal mode to f()
+//	editor.command_add(name, f)        adds an ex command that calls f(args)
+//	editor.on(event, [pattern,] f)     calls f({event, buffer, pane, mode}) on an event, see EventBus
+//	editor.off(id)                     stops calling the function editor.on returned the id of
+//	editor.get(option)                 returns an option's value
+//	editor.set(option, value)          changes it
+//	editor.add_option(name, default)   adds an option for ":set"
+//	editor.snippet(ft, trigger, body)  adds a snippet for a filetype, see Editor.AddSnippet
+//	editor.add_completion(f)           completes words in insert mode with the list f(word) returns
+//	editor.mode()                      returns the input mode, ie "normal"
+//	editor.buffer(), editor.buffers()  return the current buffer and every buffer
+//	editor.pane(), editor.panes()      return the current pane and every pane
+//	editor.open(filename)              opens a file and returns its buffer
+//	editor.read_file(name)             returns the contents of a file
+//	editor.write_file(name, text)      writes a file
+//
+// A buffer has the methods id, name, filename, modified, line_count, line(n), lines(first, last),
+// set_lines(first, last, lines) and insert_lines(after, lines). A pane has buffer() and cursor(),
+// the cursor into its current buffer. A cursor has position(), which returns x and line, and move(x, line).
+func (editor *Editor) Script() *script.Interpreter {
+	if editor.script != nil {
+		return editor.script
+	}
+
+	interp := script.New()
+	interp.Print = editor.Echo
+	editor.script = interp
+	editor.scriptObjects = map[interface{}]*script.Table{}
+	editor.scriptAdded = map[string]bool{}
+
+	api := script.NewTable()
+	for name, fn := range map[string]func(args []script.Value) ([]script.Value, error){
+		"echo":           editor.scriptEcho,
+		"confirm":        editor.scriptConfirm,
+		"command":        editor.scriptCommand,
+		"map":            editor.scriptMap,
+		"command_add":    editor.scriptAddCommand,
+		"on":             editor.scriptOn,
+		"off":            editor.scriptOff,
+		"get":            editor.scriptGet,
+		"set":            editor.scriptSet,
+		"add_option":     editor.scriptAddOption,
+		"snippet":        editor.scriptSnippet,
+		"add_completion": editor.scriptAddCompletion,
+		"mode":           editor.scriptMode,
+		"buffer":         editor.scriptBuffer,
+		"buffers":        editor.scriptBuffers,
+		"pane":           editor.scriptPane,
+		"panes":          editor.scriptPanes,
+		"open":           editor.scriptOpen,
+		"read_file":      editor.scriptReadFile,
+		"write_file":     editor.scriptWriteFile,
+	} {
+		api.Set(name, script.NewFunction("editor."+name, fn))
+	}
+	interp.SetGlobal("editor", api)
+	return interp
+}
+
+// RunScript runs a script, chunk names it in errors.
+func (editor *Editor) RunScript(chunk, source string) ([]script.Value, error) {
+	return editor.Script().Run(chunk, source)
+}
+
+// RunScriptFile runs a script file from the editor's filesystem.
+func (editor *Editor) RunScriptFile(filename string) error {
+	data, err := afero.ReadFile(editor.fs, filename)
+	if err != nil {
+		return fmt.Errorf("Can't open file %s", filename)
+	}
+	_, err = editor.RunScript(filename, string(data))
+	return err
+}
+
+// scriptCommands are the ex commands scripts may run, those that don't start processes or reach files
+// other than through the editor's afero.Fs.
+var scriptCommands = map[string]bool{
+	"b[uffer]": true, "w[rite]": true, "bd[elete]": true, "bw[ipeout]": true, "mes[sages]": true,
+	"se[t]": true, "setl[ocal]": true, "fo[ld]": true, "foldo[pen]": true, "foldc[lose]": true,
+	"sp[lit]": true, "vs[plit]": true, "clo[se]": true, "on[ly]": true, "r[ead]": true,
+	"lua": true, "luaf[ile]": true, "git": true, "bl[ame]": true, "difft[his]": true, "diffo[ff]": true,
+	"diffu[pdate]": true, "diffg[et]": true, "diffpu[t]": true, "conf[lict]": true, "h[elp]": true,
+}
+
+// checkScriptCommand returns an error unless a script may run the command, ":read !" and setting the shell
+// are refused too.
+func (editor *Editor) checkScriptCommand(line string) error {
+	command, err := ParseCommand(line)
+	if err != nil || command.Name == "" {
+		return err
+	}
+	def := editor.LookupCommand(command.Name)
+	if def == nil {
+		return nil
+	}
+	refused := !scriptCommands[def.Name] && !editor.scriptAdded[def.Name]
+	switch def.Name {
+	case "r[ead]":
+		refused = command.Bang || strings.HasPrefix(command.Args, "!")
+	case "se[t]", "setl[ocal]":
+		for _, arg := range strings.Fields(command.Args) {
+			if end := strings.IndexAny(arg, "=:+-^!&?"); end != -1 {
+				arg = arg[:end]
+			}
+			refused = refused || arg == "shell" || arg == "sh"
+		}
+	}
+	if refused {
+		return fmt.Errorf("Scripts can't run: %s", strings.TrimLeft(line, ": \t"))
+	}
+	return nil
+}
+
+// callScript calls a function of a script from the editor.
+func (editor *Editor) callScript(fn script.Value, args ...script.Value) ([]script.Value, error) {
+	return editor.Script().Call(fn, args...)
+}
+
+func (editor *Editor) scriptEcho(args []script.Value) ([]script.Value, error) {
+	texts := []string{}
+	for _, arg := range args {
+		texts = append(texts, script.ToString(arg))
+	}
+	editor.Echo(strings.Join(texts, " "))
+	return nil, nil
+}
+
+func (editor *Editor) scriptConfirm(args []script.Value) ([]script.Value, error) {
+	question, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := script.CheckFunction(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	editor.Confirm(question, func(yes bool) {
+		if _, err := editor.callScript(fn, yes); err != nil {
+			editor.EchoError(err)
+		}
+	})
+	return nil, nil
+}
+
+func (editor *Editor) scriptCommand(args []script.Value) ([]script.Value, error) {
+	line, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := editor.checkScriptCommand(line); err != nil {
+		return nil, err
+	}
+	return nil, editor.ExecuteCommand(line)
+}
+
+func (editor *Editor) scriptMap(args []script.Value) ([]script.Value, error) {
+	keys, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := script.CheckFunction(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	editor.MapNormal(keys, func(editor *Editor) error {
+		_, err := editor.callScript(fn)
+		return err
+	})
+	return nil, nil
+}
+
+func (editor *Editor) scriptAddCommand(args []script.Value) ([]script.Value, error) {
+	name, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := script.CheckFunction(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	err = pluginHost{editor: editor}.AddCommand(name, func(args string) error {
+		_, err := editor.callScript(fn, args)
+		return err
+	})
+	if err == nil {
+		editor.scriptAdded[name] = true
+	}
+	return nil, err
+}
+
+func (editor *Editor) scriptOn(args []script.Value) ([]script.Value, error) {
+	name, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	event, err := ParseEventName(name)
+	if err != nil {
+		return nil, err
+	}
+	pattern := ""
+	if _, ok := script.Arg(args, 1).(string); ok {
+		pattern, args = args[1].(string), args[1:]
+	}
+	fn, err := script.CheckFunction(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	id := editor.events.Subscribe(event, pattern, func(event EditorEvent) {
+		info := script.NewTable()
+		info.Set("event", string(event.Name))
+		info.Set("buffer", editor.scriptBufferObject(event.Buffer))
+		if event.Pane != nil {
+			info.Set("pane", editor.scriptPaneObject(event.Pane))
+		}
+		if event.Name == ModeChanged {
+			info.Set("mode", event.Mode.String())
+		}
+		if _, err := editor.callScript(fn, info); err != nil {
+			editor.EchoError(err)
+		}
+	})
+	return []script.Value{float64(id)}, nil
+}
+
+func (editor *Editor) scriptOff(args []script.Value) ([]script.Value, error) {
+	id, err := script.CheckInt(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []script.Value{editor.events.Unsubscribe(id)}, nil
+}
+
+func (editor *Editor) scriptGet(args []script.Value) ([]script.Value, error) {
+	name, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch value := editor.settings.setting(name).(type) {
+	case *bool:
+		return []script.Value{*value}, nil
+	case *int:
+		return []script.Value{float64(*value)}, nil
+	case *string:
+		return []script.Value{*value}, nil
+	}
+	return nil, fmt.Errorf("Unknown option: %s", name)
+}
+
+func (editor *Editor) scriptSet(args []script.Value) ([]script.Value, error) {
+	name, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if name == "shell" || name == "sh" {
+		return nil, errors.New("Scripts can't set the shell")
+	}
+	switch value := editor.settings.setting(name).(type) {
+	case *bool:
+		*value = script.Truthy(script.Arg(args, 1))
+		return nil, nil
+	case *int:
+		n, err := script.CheckInt(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkNumber(name, n); err != nil {
+			return nil, err
+		}
+		*value = n
+		return nil, nil
+	case *string:
+		text, err := script.CheckString(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		*value = text
+		return nil, nil
+	}
+	return nil, fmt.Errorf("Unknown option: %s", name)
+}
+
+func (editor *Editor) scriptAddOption(args []script.Value) ([]script.Value, error) {
+	name, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch initial := script.Arg(args, 1).(type) {
+	case bool:
+		value = &initial
+	case float64:
+		n := int(initial)
+		value = &n
+	case string:
+		value = &initial
+	default:
+		return nil, errors.New("Option default must be a boolean, number or string")
+	}
+	return nil, editor.settings.addOption(name, value)
+}
+
+func (editor *Editor) scriptSnippet(args []script.Value) ([]script.Value, error) {
+	texts := make([]string, 3)
+	for i := range texts {
+		var err error
+		if texts[i], err = script.CheckString(args, i); err != nil {
+			return nil, err
+		}
+	}
+	editor.AddSnippet(texts[0], texts[1], texts[2])
+	return nil, nil
+}
+
+func (editor *Editor) scriptAddCompletion(args []script.Value) ([]script.Value, error) {
+	fn, err := script.CheckFunction(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	editor.AddCompletionSource(CompletionFunc(func(request CompletionRequest, reply func([]CompletionItem)) {
+		results, err := editor.callScript(fn, request.Word)
+		if err != nil {
+			editor.EchoError(err)
+			reply(nil)
+			return
+		}
+		items := []CompletionItem{}
+		if list, ok := script.Arg(results, 0).(*script.Table); ok {
+			for _, value := range list.List() {
+				items = append(items, CompletionItem{Text: script.ToString(value), Start: request.WordStart})
+			}
+		}
+		reply(items)
+	}))
+	return nil, nil
+}
+
+func (editor *Editor) scriptMode(args []script.Value) ([]script.Value, error) {
+	return []script.Value{editor.Mode().String()}, nil
+}
+
+func (editor *Editor) scriptBuffer(args []script.Value) ([]script.Value, error) {
+	return []script.Value{editor.scriptBufferObject(editor.CurrentPane().Buffer())}, nil
+}
+
+func (editor *Editor) scriptBuffers(args []script.Value) ([]script.Value, error) {
+	list := script.NewTable()
+	for i, buffer := range editor.Buffers() {
+		list.Set(float64(i+1), editor.scriptBufferObject(buffer))
+	}
+	return []script.Value{list}, nil
+}
+
+func (editor *Editor) scriptPane(args []script.Value) ([]script.Value, error) {
+	return []script.Value{editor.scriptPaneObject(editor.CurrentPane())}, nil
+}
+
+func (editor *Editor) scriptPanes(args []script.Value) ([]script.Value, error) {
+	list := script.NewTable()
+	for i, pane := range editor.Panes() {
+		list.Set(float64(i+1), editor.scriptPaneObject(pane))
+	}
+	return []script.Value{list}, nil
+}
+
+func (editor *Editor) scriptOpen(args []script.Value) ([]script.Value, error) {
+	filename, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	editor.OpenFile(filename)
+	return []script.Value{editor.scriptBufferObject(editor.CurrentPane().Buffer())}, nil
+}
+
+func (editor *Editor) scriptReadFile(args []script.Value) ([]script.Value, error) {
+	filename, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(editor.fs, filename)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open file %s", filename)
+	}
+	return []script.Value{string(data)}, nil
+}
+
+func (editor *Editor) scriptWriteFile(args []script.Value) ([]script.Value, error) {
+	filename, err := script.CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	text, err := script.CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := afero.WriteFile(editor.fs, filename, []byte(text), 0644); err != nil {
+		return nil, fmt.Errorf("Cannot write %s: %s", filename, err)
+	}
+	return nil, nil
+}
+
+// scriptObject returns the table scripts see an object of the editor as, the same one each time
+// so they can be compared. Its methods can be called with ':' or '.'.
+func (editor *Editor) scriptObject(object interface{}, methods map[string]func(args []script.Value) ([]script.Value, error)) *script.Table {
+	if table, ok := editor.scriptObjects[object]; ok {
+		return table
+	}
+
+	table := script.NewTable()
+	for name, method := range methods {
+		method := method
+		table.Set(name, script.NewFunction(name, func(args []script.Value) ([]script.Value, error) {
+			if len(args) > 0 && args[0] == table {
+				args = args[1:]
+			}
+			return method(args)
+		}))
+	}
+	editor.scriptObjects[object] = table
+	return table
+}
+
+// scriptBufferObject returns a buffer as scripts see it, nil stays nil.
+func (editor *Editor) scriptBufferObject(buffer *Buffer) script.Value {
+	if buffer == nil {
+		return nil
+	}
+	lines := func(args []script.Value, i int) ([]string, error) {
+		list, err := script.CheckTable(args, i)
+		if err != nil {
+			return nil, err
+		}
+		lines := []string{}
+		for _, value := range list.List() {
+			lines = append(lines, script.ToString(value))
+		}
+		return lines, nil
+	}
+
+	return editor.scriptObject(buffer, map[string]func(args []script.Value) ([]script.Value, error){
+		"id": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{float64(buffer.ID())}, nil
+		},
+		"name": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{buffer.Name()}, nil
+		},
+		"filename": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{buffer.Filename()}, nil
+		},
+		"modified": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{buffer.Modified()}, nil
+		},
+		"line_count": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{float64(buffer.LineCount())}, nil
+		},
+		"line": func(args []script.Value) ([]script.Value, error) {
+			n, err := script.CheckInt(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			line, err := buffer.GetLine(n)
+			return []script.Value{line}, err
+		},
+		"lines": func(args []script.Value) ([]script.Value, error) {
+			first, err := script.CheckInt(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			last, err := script.CheckInt(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			lines, err := buffer.GetLines(first, last)
+			if err != nil {
+				return nil, err
+			}
+			list := script.NewTable()
+			for i, line := range lines {
+				list.Set(float64(i+1), line)
+			}
+			return []script.Value{list}, nil
+		},
+		"set_lines": func(args []script.Value) ([]script.Value, error) {
+			first, err := script.CheckInt(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			last, err := script.CheckInt(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			replacement, err := lines(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			return nil, buffer.ReplaceLines(first, last, replacement)
+		},
+		"insert_lines": func(args []script.Value) ([]script.Value, error) {
+			after, err := script.CheckInt(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			inserted, err := lines(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return nil, buffer.InsertLines(after, inserted)
+		},
+	})
+}
+
+// scriptPaneObject returns a pane as scripts see it.
+func (editor *Editor) scriptPaneObject(pane *Pane) script.Value {
+	return editor.scriptObject(pane, map[string]func(args []script.Value) ([]script.Value, error){
+		"buffer": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{editor.scriptBufferObject(pane.Buffer())}, nil
+		},
+		"cursor": func(args []script.Value) ([]script.Value, error) {
+			return []script.Value{editor.scriptCursorObject(pane.Cursor())}, nil
+		},
+	})
+}
+
+// scriptCursorObject returns a cursor as scripts see it, nil stays nil.
+func (editor *Editor) scriptCursorObject(cursor *Cursor) script.Value {
+	if cursor == nil {
+		return nil
+	}
+	return editor.scriptObject(cursor, map[string]func(args []script.Value) ([]script.Value, error){
+		"position": func(args []script.Value) ([]script.Value, error) {
+			x, line := cursor.Position()
+			return []script.Value{float64(x), float64(line)}, nil
+		},
+		"move": func(args []script.Value) ([]script.Value, error) {
+			x, err := script.CheckInt(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			line, err := script.CheckInt(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			cursor.Move(x, line)
+			return nil, nil
+		},
+	})
+}
+
+// luaCommand runs a line of script, ":lua =expression" shows the value of an expression.
+func luaCommand(editor *Editor, command Command) error {
+	source := command.Args
+	show := strings.HasPrefix(source, "=")
+	if show {
+		source = "return " + source[1:]
+	}
+
+	values, err := editor.RunScript("lua", source)
+	if err != nil || !show {
+		return err
+	}
+	texts := []string{}
+	for _, value := range values {
+		texts = append(texts, script.ToString(value))
+	}
+	editor.Echo(strings.Join(texts, "\t"))
+	return nil
+}
+
+func luaFileCommand(editor *Editor, command Command) error {
+	if command.Args == "" {
+		return errors.New("Argument required")
+	}
+	return editor.RunScriptFile(command.Args)
+}
+
+// RunConfig runs the script that configures jkl, usually init.lua in the jkl configuration directory.
+// A missing script is ignored.
+func RunConfig(path string) func(*App) error {
+	return func(a *App) error {
+		editor := a.Editor()
+		if _, err := editor.fs.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+		err := editor.RunScriptFile(path)
+		if err != nil {
+			editor.EchoError(err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestScripting(t *testing.T) {
+	Convey("Editor with a file open", t, func() {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "notes.txt", []byte("one\ntwo\nthree\n"), 0644)
+		editor := NewEditor(fs)
+		editor.OpenFile("notes.txt")
+
+		Convey("runs scripts that edit buffers", func() {
+			So(editor.ExecuteCommand(`lua local b = editor.buffer() b:set_lines(2, 2, {"TWO", "2"}) b:insert_lines(0, {"zero"})`), ShouldBeNil)
+			lines, _ := editor.CurrentPane().Buffer().GetLines(1, 5)
+			So(lines, ShouldResemble, []string{"zero", "one", "TWO", "2", "three"})
+
+			So(editor.ExecuteCommand("lua editor.pane():cursor():move(1, 3)"), ShouldBeNil)
+			So(editor.ExecuteCommand("lua =editor.pane():cursor():position()"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "1\t3")
+		})
+
+		Convey("shows the values of expressions", func() {
+			So(editor.ExecuteCommand("lua =editor.buffer():name(), editor.buffer():line_count(), editor.mode()"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "notes.txt\t3\tnormal")
+			So(editor.ExecuteCommand("lua =editor.buffer() == editor.buffers()[1]"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "true")
+		})
+
+		Convey("adds mappings and commands", func() {
+			So(editor.ExecuteCommand(`lua editor.map("gl", function() editor.echo("mapped") end)`), ShouldBeNil)
+			editor.HandleNormalKey('g')
+			editor.HandleNormalKey('l')
+			So(editor.Message().Text, ShouldEqual, "mapped")
+
+			So(editor.ExecuteCommand(`lua editor.command_add("Gr[eet]", function(args) editor.echo("Hello " .. args) end)`), ShouldBeNil)
+			So(editor.ExecuteCommand("Gr world"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "Hello world")
+		})
+
+		Convey("asks questions", func() {
+			So(editor.ExecuteCommand(`lua editor.confirm("Sure?", function(yes) answer = yes end)`), ShouldBeNil)
+			So(editor.FocusedFloat().Title, ShouldEqual, "Confirm")
+			editor.HandleFloatKey(KeyEvent{Rune: 'y'})
+			So(editor.Script().Global("answer"), ShouldEqual, true)
+		})
+
+		Convey("runs autocommands", func() {
+			So(editor.ExecuteCommand(`lua editor.on("BufWritePost", "*.txt", function(e) saved = e.event .. " " .. e.buffer:filename() end)`), ShouldBeNil)
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+			So(editor.Script().Global("saved"), ShouldEqual, "BufWritePost notes.txt")
+
+			So(editor.ExecuteCommand(`lua editor.on("Nothing", print)`), ShouldNotBeNil)
+		})
+
+		Convey("stops running an autocommand it removed", func() {
+			So(editor.ExecuteCommand(`lua saves = 0 id = editor.on("BufWritePost", function() saves = saves + 1 end)`), ShouldBeNil)
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+			So(editor.ExecuteCommand(`lua =editor.off(id), editor.off(id)`), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "true\tfalse")
+			So(editor.ExecuteCommand("w"), ShouldBeNil)
+			So(editor.Script().Global("saves"), ShouldEqual, 1)
+		})
+
+		Convey("gets and sets options", func() {
+			So(editor.ExecuteCommand(`lua editor.set("number", true) editor.set("shiftwidth", 8) editor.add_option("mine", "x")`), ShouldBeNil)
+			So(editor.Settings().Number, ShouldBeTrue)
+			So(editor.Settings().ShiftWidth, ShouldEqual, 8)
+			So(editor.ExecuteCommand("set mine=y"), ShouldBeNil)
+			So(editor.ExecuteCommand(`lua =editor.get("mine"), editor.get("number"), editor.get("shiftwidth")`), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "y\ttrue\t8")
+
+			err := editor.ExecuteCommand(`lua editor.set("shiftwidth", "wide")`)
+			So(err.Error(), ShouldEqual, "lua:1: Bad argument #2 (integer expected, got string)")
+		})
+
+		Convey("only reaches files through the editor", func() {
+			So(editor.ExecuteCommand(`lua editor.write_file("copy.txt", editor.read_file("notes.txt"))`), ShouldBeNil)
+			data, _ := afero.ReadFile(fs, "copy.txt")
+			So(string(data), ShouldEqual, "one\ntwo\nthree\n")
+			So(editor.ExecuteCommand("lua =io, os"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "nil\tnil")
+		})
+
+		Convey("can't run commands that start processes", func() {
+			for _, line := range []string{
+				"!echo escaped > /tmp/jkl_escaped", "r !echo escaped", "r! echo escaped", "%!sort", "2,3!sort",
+				"terminal", "plugin list", "autocmd BufEnter * !echo escaped", "set shell=/bin/evil",
+				"setlocal nowrap sh=/bin/evil", "suspend", "lsp start",
+			} {
+				err := editor.ExecuteCommand(`lua editor.command("` + line + `")`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Scripts can't run: "+line)
+			}
+			_, err := afero.ReadFile(afero.NewOsFs(), "/tmp/jkl_escaped")
+			So(err, ShouldNotBeNil)
+			So(editor.ExecuteCommand(`lua editor.set("shell", "/bin/evil")`).Error(), ShouldContainSubstring, "Scripts can't set the shell")
+			So(editor.Settings().Shell, ShouldNotEqual, "/bin/evil")
+
+			Convey("but can run the others and those scripts added", func() {
+				So(editor.ExecuteCommand(`lua editor.command("set number")`), ShouldBeNil)
+				So(editor.Settings().Number, ShouldBeTrue)
+				So(editor.ExecuteCommand(`lua editor.command_add("Hi", function() editor.echo("hi") end) editor.command("Hi")`), ShouldBeNil)
+				So(editor.Message().Text, ShouldEqual, "hi")
+			})
+		})
+
+		Convey("runs script files", func() {
+			afero.WriteFile(fs, "script.lua", []byte("editor.echo('from', 'file')\n"), 0644)
+			So(editor.ExecuteCommand("luafile script.lua"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "from file")
+			So(editor.ExecuteCommand("luaf missing.lua"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("An app runs its config script", t, func() {
+		fs := afero.NewMemMapFs()
+
+		Convey("when there is one", func() {
+			afero.WriteFile(fs, "/config/init.lua", []byte("editor.set('number', true)\n"), 0644)
+			app := NewApp(SetFS(fs), RunConfig("/config/init.lua"))
+			So(app.Editor().Settings().Number, ShouldBeTrue)
+		})
+
+		Convey("and shows its errors", func() {
+			afero.WriteFile(fs, "/config/init.lua", []byte("\nbroken(\n"), 0644)
+			app := NewApp(SetFS(fs), RunConfig("/config/init.lua"))
+			So(app.Editor().Message().Text, ShouldEqual, "/config/init.lua:3: Unexpected end of script")
+		})
+
+		Convey("but doesn't need one", func() {
+			app := NewApp(SetFS(fs), RunConfig("/config/init.lua"))
+			So(app.Editor().Message().Text, ShouldEqual, "")
+		})
+	})
+}
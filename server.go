@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcbishop/jkl/service"
+)
+
+// DefaultSession is the name of the session used when none is given.
+const DefaultSession = "default"
+
+// clientQueueLength is how many messages can wait to be written to a client.
+// Draws that don't fit are dropped and the client gets the whole screen once there is room.
+const clientQueueLength = 64
+
+// Detacher is implemented by UIs that terminals attach to, see Editor.SetDetacher.
+type Detacher interface {
+	Detach()
+}
+
+// SetDetacher sets what ":detach" detaches from, usually the UI.
+func (editor *Editor) SetDetacher(detacher Detacher) {
+	editor.detacher = detacher
+}
+
+// Detach detaches the terminal the user is typing in from the session, which keeps running.
+func (editor *Editor) Detach() error {
+	if editor.detacher == nil {
+		return errors.New("Not attached to a session")
+	}
+	editor.detacher.Detach()
+	return nil
+}
+
+// RuntimeDir returns the per-user directory holding the sockets of running sessions, creating it if needed.
+// It is $XDG_RUNTIME_DIR/jkl, or a jkl-<uid> directory in the temporary directory.
+func RuntimeDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("jkl-%d", os.Getuid()))
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		dir = filepath.Join(runtime, "jkl")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("Cannot create runtime directory: %s", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("Runtime directory %s is accessible by other users", dir)
+	}
+	return dir, nil
+}
+
+// SessionSocket returns the path of the socket of the named session.
+func SessionSocket(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\x00") {
+		return "", fmt.Errorf("Invalid session name: %q", name)
+	}
+
+	dir, err := RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".sock"), nil
+}
+
+// Server is a UI without a terminal of its own. It shows the editor in terminals attached
+// over a Unix socket, see ProtocolVersion, and keeps running when they all detach.
+type Server struct {
+	path     string
+	listener net.Listener
+	state    service.State
+	quit     chan bool
+	events   chan Event
+	writers  sync.WaitGroup
+
+	attachable bool
+
+	mutex      sync.Mutex
+	clients    map[*serverClient]bool
+	active     *serverClient
+	closed     bool
+	restarting bool
+	width      int
+	height     int
+	front      RuneGrid
+	back       RuneGrid
+}
+
+// serverClient is a connection to a Server. Messages to it are queued and written by its own goroutine.
+type serverClient struct {
+	conn     net.Conn
+	out      chan WireMessage
+	attached bool
+	full     bool
+}
+
+// NewServer constructs a Server listening on the socket at path.
+func NewServer(path string) Server {
+	return newServer(path, true)
+}
+
+// NewControlServer constructs a Server that only accepts requests like "open", not attaching clients.
+// It lets an editor shown by another UI be found by "jkl --remote", see App.SetRemote.
+func NewControlServer(path string) Server {
+	return newServer(path, false)
+}
+
+func newServer(path string, attachable bool) Server {
+	return Server{
+		path:       path,
+		quit:       make(chan bool),
+		events:     make(chan Event),
+		clients:    map[*serverClient]bool{},
+		width:      80,
+		height:     24,
+		attachable: attachable,
+	}
+}
+
+// Listen creates the socket clients connect to.
+// A socket left behind by a session that is no longer running is replaced.
+func (server *Server) Listen() error {
+	if server.listener != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("unix", server.path)
+	if err != nil {
+		if conn, dialErr := net.Dial("unix", server.path); dialErr == nil {
+			conn.Close()
+			return fmt.Errorf("Session already running at %s", server.path)
+		}
+		os.Remove(server.path)
+		listener, err = net.Listen("unix", server.path)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot listen on %s: %s", server.path, err)
+	}
+
+	server.listener = listener
+	return nil
+}
+
+// Run accepts clients until Stop() is called, then tells them the session ended.
+func (server *Server) Run() {
+	if server.state.SetRunning() != nil {
+		panic("Server already running.")
+	}
+	defer server.state.SetStopped()
+
+	server.mutex.Lock()
+	server.closed = false
+	server.mutex.Unlock()
+
+	done := make(chan interface{})
+	if server.Listen() == nil {
+		go server.accept(server.listener, done)
+	}
+
+	<-server.quit
+	close(done)
+	if server.listener != nil {
+		server.listener.Close()
+		server.listener = nil
+	}
+
+	server.mutex.Lock()
+	server.closed = true
+	last := WireMessage{Type: "exit"}
+	if server.restarting {
+		last.Type = "restart"
+	}
+	for client := range server.clients {
+		client.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		server.drop(client, last)
+	}
+	server.mutex.Unlock()
+	server.writers.Wait()
+}
+
+// Running returns true if Run() was called but Stop() hasn't been.
+func (server *Server) Running() bool {
+	return server.state.Running()
+}
+
+// Stop terminates the Run loop.
+func (server *Server) Stop() {
+	if !server.Running() {
+		return
+	}
+	server.quit <- true
+	service.WaitUntilStopped(server, time.Second)
+}
+
+// Name returns the name of the session, see SessionSocket.
+func (server *Server) Name() string {
+	return strings.TrimSuffix(filepath.Base(server.path), ".sock")
+}
+
+// Restarting tells attached clients to attach again once the server stops, instead of that the session ended.
+func (server *Server) Restarting() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.restarting = true
+}
+
+// Events gets the channel of input from attached clients.
+func (server *Server) Events() <-chan Event {
+	return server.events
+}
+
+// Redraw sends what changed on the screen to the attached clients.
+func (server *Server) Redraw(editor *Editor) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !server.hasAttached() {
+		return
+	}
+
+	if w, h := server.back.Size(); w != server.width || h != server.height {
+		server.front = NewRuneGrid(server.width, server.height)
+		server.back = NewRuneGrid(server.width, server.height)
+		for client := range server.clients {
+			client.full = true
+		}
+	} else {
+		server.back.Clear()
+	}
+	server.back.RenderEditor(editor)
+
+	draw := WireMessage{
+		Type:   "draw",
+		Width:  server.width,
+		Height: server.height,
+		Mouse:  editor.Settings().Mouse,
+	}
+	if x, y, ok := cursorPosition(editor, server.height); ok {
+		draw.Cursor = &WireCursor{X: x, Y: y}
+	}
+
+	changes := wireSpans(server.back.Diff(&server.front))
+	var everything []WireSpan
+	for client := range server.clients {
+		if !client.attached {
+			continue
+		}
+
+		message := draw
+		message.Spans = changes
+		if client.full {
+			if everything == nil {
+				everything = wireSpans(server.back.Diff(nil))
+			}
+			message.Spans, message.Full = everything, true
+		}
+		client.full = !client.send(message)
+	}
+
+	server.front, server.back = server.back, server.front
+}
+
+// Detach detaches the client that sent the last input.
+func (server *Server) Detach() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.active != nil {
+		server.drop(server.active, WireMessage{Type: "detach"})
+	}
+}
+
+// DetachAll detaches every attached client.
+func (server *Server) DetachAll() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for client := range server.clients {
+		if client.attached {
+			server.drop(client, WireMessage{Type: "detach"})
+		}
+	}
+}
+
+// Attached returns how many clients are attached.
+func (server *Server) Attached() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	count := 0
+	for client := range server.clients {
+		if client.attached {
+			count++
+		}
+	}
+	return count
+}
+
+func (server *Server) accept(listener net.Listener, done chan interface{}) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.serve(conn, done)
+	}
+}
+
+// serve handles the messages of a connection until it is closed or dropped.
+func (server *Server) serve(conn net.Conn, done chan interface{}) {
+	client := &serverClient{conn: conn, out: make(chan WireMessage, clientQueueLength)}
+	if !server.add(client) {
+		conn.Close()
+		return
+	}
+	defer server.remove(client)
+
+	wire := NewWireConn(conn)
+	hello, err := wire.Read()
+	if err != nil || hello.Type != "hello" {
+		server.reply(client, WireMessage{Type: "error", Error: "Expected hello"})
+		return
+	}
+	if hello.Version != ProtocolVersion {
+		server.reply(client, WireMessage{Type: "error", Error: fmt.Sprintf("Unsupported protocol version %d", hello.Version)})
+		return
+	}
+	server.reply(client, WireMessage{Type: "hello", Version: ProtocolVersion})
+
+	for {
+		message, err := wire.Read()
+		if err != nil || !server.handle(client, message, done) {
+			return
+		}
+	}
+}
+
+// handle acts on a message from a client, it returns false once the client is gone.
+func (server *Server) handle(client *serverClient, message WireMessage, done chan interface{}) bool {
+	switch message.Type {
+	case "attach":
+		if !server.attachable {
+			return server.reply(client, WireMessage{Type: "error", Error: "Session can't be attached"})
+		}
+		server.mutex.Lock()
+		client.attached, client.full = true, true
+		server.active = client
+		server.resize(message.Width, message.Height)
+		server.mutex.Unlock()
+		return server.post(server.sizeEvent(), done)
+
+	case "redraw":
+		server.mutex.Lock()
+		client.full = true
+		server.mutex.Unlock()
+		return server.post(server.sizeEvent(), done)
+
+	case "detach":
+		server.DetachAll()
+		return server.reply(client, WireMessage{Type: "ok"})
+
+	case "open":
+		return server.open(client, message, done)
+
+	case "restart":
+		event := RestartEvent{Done: func(err error) {
+			if err != nil {
+				server.reply(client, WireMessage{Type: "error", Error: err.Error()})
+			} else {
+				server.reply(client, WireMessage{Type: "ok"})
+			}
+		}}
+		return server.post(Event{event}, done)
+	}
+
+	event, ok := message.Event()
+	if !ok {
+		return server.reply(client, WireMessage{Type: "error", Error: "Unknown message type: " + message.Type})
+	}
+
+	server.mutex.Lock()
+	attached := client.attached
+	if attached {
+		server.active = client
+		if message.Type == "resize" {
+			server.resize(message.Width, message.Height)
+		}
+	}
+	server.mutex.Unlock()
+
+	if !attached {
+		return server.reply(client, WireMessage{Type: "error", Error: "Not attached"})
+	}
+	return server.post(event, done)
+}
+
+// open asks the App to open a file. With wait the client is told when its buffer is deleted.
+func (server *Server) open(client *serverClient, message WireMessage, done chan interface{}) bool {
+	if message.Text == "" {
+		return server.reply(client, WireMessage{Type: "error", Error: "No file name"})
+	}
+
+	event := OpenEvent{Filename: message.Text, Line: message.Line}
+	if message.Wait {
+		event.Deleted = func() {
+			server.reply(client, WireMessage{Type: "closed", Text: message.Text})
+		}
+	}
+	if !server.post(Event{event}, done) {
+		return false
+	}
+	return server.reply(client, WireMessage{Type: "ok"})
+}
+
+// post passes an event to the App, it returns false if the server stopped first.
+func (server *Server) post(event Event, done chan interface{}) bool {
+	select {
+	case server.events <- event:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// sizeEvent returns a ResizeEvent with the size of the screen, posting it makes the App redraw.
+func (server *Server) sizeEvent() Event {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return Event{ResizeEvent{Width: server.width, Height: server.height}}
+}
+
+// resize changes the size of the screen, the mutex must be held.
+func (server *Server) resize(width, height int) {
+	if width > 0 && height > 0 {
+		server.width, server.height = width, height
+	}
+}
+
+// hasAttached returns true if any client is attached, the mutex must be held.
+func (server *Server) hasAttached() bool {
+	for client := range server.clients {
+		if client.attached {
+			return true
+		}
+	}
+	return false
+}
+
+// reply queues a message to a client, it returns false if the client is gone.
+func (server *Server) reply(client *serverClient, message WireMessage) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !server.clients[client] {
+		return false
+	}
+	client.send(message)
+	return true
+}
+
+// add starts writing to a new client, it returns false if the server is stopping.
+func (server *Server) add(client *serverClient) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.closed {
+		return false
+	}
+	server.clients[client] = true
+	server.writers.Add(1)
+	go func() {
+		defer server.writers.Done()
+		client.write()
+	}()
+	return true
+}
+
+// remove forgets a client whose connection was closed.
+func (server *Server) remove(client *serverClient) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.clients[client] {
+		server.drop(client, WireMessage{})
+	}
+}
+
+// drop sends a last message to a client and closes its connection once it is written.
+// The mutex must be held.
+func (server *Server) drop(client *serverClient, last WireMessage) {
+	if last.Type != "" {
+		client.send(last)
+	}
+	delete(server.clients, client)
+	close(client.out)
+	if server.active == client {
+		server.active = nil
+	}
+}
+
+// send queues a message without blocking, it returns false if the queue is full.
+func (client *serverClient) send(message WireMessage) bool {
+	select {
+	case client.out <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sends queued messages until the queue is closed, then closes the connection.
+func (client *serverClient) write() {
+	wire := NewWireConn(client.conn)
+	for message := range client.out {
+		if wire.Write(message) != nil {
+			break
+		}
+	}
+	client.conn.Close()
+}
+
+func detachCommand(editor *Editor, command Command) error {
+	return editor.Detach()
+}
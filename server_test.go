@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dcbishop/jkl/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// withRuntimeDir points the runtime directory at a new temporary directory while f runs.
+func withRuntimeDir(f func(dir string)) {
+	dir, err := os.MkdirTemp("", "jkl-runtime")
+	So(err, ShouldBeNil)
+	old, had := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	defer func() {
+		if had {
+			os.Setenv("XDG_RUNTIME_DIR", old)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+		os.RemoveAll(dir)
+	}()
+	f(dir)
+}
+
+// withSession runs an App with a Server for the "test" session while f runs.
+func withSession(f func(app *App, server *Server)) {
+	withRuntimeDir(func(dir string) {
+		path, err := SessionSocket("test")
+		So(err, ShouldBeNil)
+		server := NewServer(path)
+		So(server.Listen(), ShouldBeNil)
+
+		app := NewApp(SetUI(&server))
+		go app.Run()
+		So(service.WaitUntilRunning(&app, time.Second), ShouldBeNil)
+		defer app.Stop()
+
+		f(&app, &server)
+	})
+}
+
+// readMessageUntil reads messages until one matches, failing after a second.
+func readMessageUntil(wire WireConn, match func(WireMessage) bool) WireMessage {
+	wire.conn.(net.Conn).SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		message, err := wire.Read()
+		So(err, ShouldBeNil)
+		if err != nil || match(message) {
+			return message
+		}
+	}
+}
+
+func ofType(name string) func(WireMessage) bool {
+	return func(message WireMessage) bool { return message.Type == name }
+}
+
+// attach connects to the test session and waits for the first screen.
+func attach(width, height int) (WireConn, WireMessage) {
+	wire, err := DialSession("test")
+	So(err, ShouldBeNil)
+	So(wire.Write(WireMessage{Type: "attach", Width: width, Height: height}), ShouldBeNil)
+	return wire, readMessageUntil(wire, ofType("draw"))
+}
+
+func sendKeys(wire WireConn, keys string) {
+	for _, r := range keys {
+		event := Event{KeyEvent{Key: KeyRune, Rune: r}}
+		if r == '\r' {
+			event = Event{KeyEvent{Key: KeyEnter}}
+		}
+		message, _ := eventMessage(event)
+		So(wire.Write(message), ShouldBeNil)
+	}
+}
+
+func waitForAttached(server *Server, count int) {
+	deadline := time.Now().Add(time.Second)
+	for server.Attached() != count && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	So(server.Attached(), ShouldEqual, count)
+}
+
+func TestRuntimeDir(t *testing.T) {
+	Convey("Sockets are kept in a private directory under $XDG_RUNTIME_DIR", t, func() {
+		withRuntimeDir(func(runtime string) {
+			dir, err := RuntimeDir()
+			So(err, ShouldBeNil)
+			So(dir, ShouldEqual, filepath.Join(runtime, "jkl"))
+			info, _ := os.Stat(dir)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0700))
+
+			path, err := SessionSocket("work")
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, filepath.Join(dir, "work.sock"))
+
+			Convey("which must not be accessible by others", func() {
+				os.Chmod(dir, 0755)
+				_, err := RuntimeDir()
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Session names can't be paths", t, func() {
+		_, err := SessionSocket("../work")
+		So(err, ShouldNotBeNil)
+		_, err = SessionSocket("")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestServer(t *testing.T) {
+	Convey("With a running session", t, func() {
+		withSession(func(app *App, server *Server) {
+			Convey("a second server can't take its socket", func() {
+				path, _ := SessionSocket("test")
+				other := NewServer(path)
+				So(other.Listen(), ShouldNotBeNil)
+			})
+
+			Convey("a stale socket is replaced", func() {
+				path, _ := SessionSocket("stale")
+				listener, _ := net.Listen("unix", path)
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				listener.Close()
+				other := NewServer(path)
+				So(other.Listen(), ShouldBeNil)
+				other.listener.Close()
+			})
+
+			Convey("clients speaking another version are refused", func() {
+				path, _ := SessionSocket("test")
+				conn, err := net.Dial("unix", path)
+				So(err, ShouldBeNil)
+				wire := NewWireConn(conn)
+				defer wire.Close()
+				wire.Write(WireMessage{Type: "hello", Version: ProtocolVersion + 1})
+				reply := readMessageUntil(wire, func(WireMessage) bool { return true })
+				So(reply.Type, ShouldEqual, "error")
+				So(reply.Error, ShouldContainSubstring, "Unsupported protocol version")
+			})
+
+			Convey("input is refused before attaching", func() {
+				wire, err := DialSession("test")
+				So(err, ShouldBeNil)
+				defer wire.Close()
+				sendKeys(wire, "j")
+				So(readMessageUntil(wire, ofType("error")).Error, ShouldEqual, "Not attached")
+			})
+
+			Convey("an attached client gets the whole screen first", func() {
+				wire, draw := attach(30, 6)
+				defer wire.Close()
+				So(draw.Full, ShouldBeTrue)
+				So(draw.Width, ShouldEqual, 30)
+				So(draw.Height, ShouldEqual, 6)
+				So(draw.Spans, ShouldHaveLength, 6)
+				So(server.Attached(), ShouldEqual, 1)
+
+				Convey("then only what its input changed", func() {
+					sendKeys(wire, ":")
+					draw := readMessageUntil(wire, func(m WireMessage) bool {
+						return m.Type == "draw" && m.Cursor != nil && m.Cursor.Y == 5
+					})
+					So(draw.Full, ShouldBeFalse)
+					So(draw.Spans, ShouldHaveLength, 1)
+					So(draw.Spans[0].Y, ShouldEqual, 5)
+					So(draw.Cursor.X, ShouldEqual, 1)
+				})
+
+				Convey("and is detached by :detach", func() {
+					sendKeys(wire, ":detach\r")
+					readMessageUntil(wire, ofType("detach"))
+					waitForAttached(server, 0)
+					So(app.Running(), ShouldBeTrue)
+				})
+
+				Convey("and by a detach request", func() {
+					So(DetachSession("test"), ShouldBeNil)
+					readMessageUntil(wire, ofType("detach"))
+					waitForAttached(server, 0)
+
+					Convey("after which the session can be attached again", func() {
+						again, draw := attach(20, 4)
+						defer again.Close()
+						So(draw.Full, ShouldBeTrue)
+						So(draw.Width, ShouldEqual, 20)
+					})
+				})
+
+				Convey("the session survives the connection being closed", func() {
+					wire.Close()
+					waitForAttached(server, 0)
+					So(app.Running(), ShouldBeTrue)
+				})
+
+				Convey("and is told when the session ends", func() {
+					app.Stop()
+					readMessageUntil(wire, ofType("exit"))
+				})
+			})
+		})
+	})
+}
+
+func TestEditorDetach(t *testing.T) {
+	Convey("Detaching without a session is an error", t, func() {
+		editor := NewEditor(GetTestFs())
+		So(editor.ExecuteCommand("detach"), ShouldNotBeNil)
+	})
+}
@@ -73,5 +73,5 @@ func (state *State) SetState(newState bool) error {
 
 // Running returns true if the state of the service is running.
 func (state *State) Running() bool {
-	return *(*uint32)(state) == 1
+	return atomic.LoadUint32((*uint32)(state)) == 1
 }
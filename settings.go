@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// setting returns a pointer to the named field of the settings, either a *bool, *int or *string.
+// Returns nil if there is no such setting.
+func (settings *Settings) setting(name string) interface{} {
+	switch name {
+	case "borders":
+		return &settings.Borders
+	case "outerborder":
+		return &settings.OuterBorder
+	case "shiftwidth", "sw":
+		return &settings.ShiftWidth
+	case "scrolloff", "so":
+		return &settings.ScrollOffset
+	case "statusline", "stl":
+		return &settings.StatusLine
+	case "number", "nu":
+		return &settings.Number
+	case "relativenumber", "rnu":
+		return &settings.RelativeNumber
+	case "signcolumn", "scl":
+		return &settings.SignColumn
+	case "foldcolumn", "fdc":
+		return &settings.FoldColumn
+	case "wrap":
+		return &settings.Wrap
+	case "sidescrolloff", "siso":
+		return &settings.SideScrollOffset
+	case "showbreak", "sbr":
+		return &settings.ShowBreak
+	case "breakindent", "bri":
+		return &settings.BreakIndent
+	case "foldmethod", "fdm":
+		return &settings.FoldMethod
+	case "foldmarker", "fmr":
+		return &settings.FoldMarker
+	case "timeoutlen", "tm":
+		return &settings.TimeoutLength
+	case "mouse":
+		return &settings.Mouse
+	case "shell", "sh":
+		return &settings.Shell
+	case "completekeys", "cpk":
+		return &settings.CompleteKeys
+	case "autocomplete", "ac":
+		return &settings.AutoComplete
+	case "gitbase":
+		return &settings.GitBase
+	}
+	if value, ok := settings.options[name]; ok {
+		return value
+	}
+	return nil
+}
+
+// Get returns the value of the named setting as a string.
+func (settings *Settings) Get(name string) (string, error) {
+	switch value := settings.setting(name).(type) {
+	case *bool:
+		return strconv.FormatBool(*value), nil
+	case *int:
+		return strconv.Itoa(*value), nil
+	case *string:
+		return *value, nil
+	}
+	return "", fmt.Errorf("Unknown option: %s", name)
+}
+
+// Set changes a setting using Vim's :set syntax.
+// "name" or "noname" turn a boolean on or off, "invname" or "name!" toggle it and
+// "name=value" sets a number or string.
+func (settings *Settings) Set(arg string) error {
+	if i := strings.Index(arg, "="); i != -1 {
+		return settings.setValue(arg[:i], arg[i+1:])
+	}
+
+	name := strings.TrimSuffix(arg, "!")
+	toggle := name != arg
+	on := true
+
+	if strings.HasPrefix(name, "inv") && settings.setting(name) == nil {
+		name = name[3:]
+		toggle = true
+	} else if strings.HasPrefix(name, "no") && settings.setting(name) == nil {
+		name = name[2:]
+		on = false
+	}
+
+	value, ok := settings.setting(name).(*bool)
+	if !ok {
+		return fmt.Errorf("Unknown option: %s", arg)
+	}
+
+	if toggle {
+		on = !*value
+	}
+	*value = on
+	return nil
+}
+
+// checkNumber returns an error if a number can't be the value of the option, the shift width divides indents.
+func checkNumber(name string, n int) error {
+	if (name == "shiftwidth" || name == "sw") && n <= 0 {
+		return fmt.Errorf("Argument must be positive: %s=%d", name, n)
+	}
+	return nil
+}
+
+func (settings *Settings) setValue(name, text string) error {
+	switch value := settings.setting(name).(type) {
+	case *int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("Number required after =: %s=%s", name, text)
+		}
+		if err := checkNumber(name, n); err != nil {
+			return err
+		}
+		*value = n
+		return nil
+	case *string:
+		*value = text
+		return nil
+	case *bool:
+		return fmt.Errorf("Invalid argument: %s=%s", name, text)
+	}
+	return fmt.Errorf("Unknown option: %s", name)
+}
+
+func setCommand(editor *Editor, command Command) error {
+	for _, arg := range strings.Fields(command.Args) {
+		if strings.HasSuffix(arg, "?") {
+			name := strings.TrimSuffix(arg, "?")
+			value, err := editor.Settings().Get(name)
+			if err != nil {
+				return err
+			}
+			editor.Echo(name + "=" + value)
+			continue
+		}
+
+		if err := editor.Settings().Set(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setLocalCommand changes options of the current pane, falling back to :set for global ones.
+func setLocalCommand(editor *Editor, command Command) error {
+	pane := editor.CurrentPane()
+	for _, arg := range strings.Fields(command.Args) {
+		switch {
+		case arg == "wrap":
+			pane.SetWrap(true)
+		case arg == "nowrap":
+			pane.SetWrap(false)
+		case arg == "invwrap" || arg == "wrap!":
+			pane.SetWrap(!pane.Wrap(editor.Settings()))
+		case strings.HasPrefix(arg, "statusline=") || strings.HasPrefix(arg, "stl="):
+			pane.SetStatusLine(arg[strings.Index(arg, "=")+1:])
+		default:
+			if err := setCommand(editor, Command{Name: "set", Args: arg}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSettingsSet(t *testing.T) {
+	Convey("Default settings", t, func() {
+		settings := DefaultSettings()
+
+		Convey("boolean settings can be turned on, off and toggled", func() {
+			So(settings.Set("number"), ShouldBeNil)
+			So(settings.Number, ShouldBeTrue)
+			So(settings.Set("nonu"), ShouldBeNil)
+			So(settings.Number, ShouldBeFalse)
+			So(settings.Set("invrnu"), ShouldBeNil)
+			So(settings.RelativeNumber, ShouldBeTrue)
+			So(settings.Set("rnu!"), ShouldBeNil)
+			So(settings.RelativeNumber, ShouldBeFalse)
+		})
+
+		Convey("number and string settings take a value", func() {
+			So(settings.Set("fdc=2"), ShouldBeNil)
+			So(settings.FoldColumn, ShouldEqual, 2)
+			So(settings.Set("signcolumn=yes"), ShouldBeNil)
+			So(settings.SignColumn, ShouldEqual, "yes")
+		})
+
+		Convey("invalid settings return errors", func() {
+			So(settings.Set("nosuchthing"), ShouldNotBeNil)
+			So(settings.Set("fdc=x"), ShouldNotBeNil)
+			So(settings.Set("number=1"), ShouldNotBeNil)
+			So(settings.Set("fdc"), ShouldNotBeNil)
+		})
+
+		Convey("the shift width must be positive", func() {
+			So(settings.Set("sw=0").Error(), ShouldEqual, "Argument must be positive: sw=0")
+			So(settings.Set("shiftwidth=-2"), ShouldNotBeNil)
+			So(settings.ShiftWidth, ShouldEqual, 4)
+		})
+
+		Convey("Get returns the value as a string", func() {
+			value, err := settings.Get("sw")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "4")
+		})
+	})
+
+	Convey(":set changes the editor settings", t, func() {
+		editor := NewEditor(GetTestFs())
+		So(editor.ExecuteCommand(":set nu rnu"), ShouldBeNil)
+		So(editor.Settings().Number, ShouldBeTrue)
+		So(editor.Settings().RelativeNumber, ShouldBeTrue)
+
+		So(editor.ExecuteCommand(":set nu?"), ShouldBeNil)
+		So(editor.Message().Text, ShouldEqual, "nu=true")
+
+		Convey("and indent folds still work after a bad shift width", func() {
+			editor.OpenFile("a.txt")
+			editor.CurrentPane().Buffer().SetDataString("a\n    b\n")
+			So(editor.ExecuteCommand(":set sw=0"), ShouldNotBeNil)
+			So(editor.ExecuteCommand(`lua editor.set("sw", 0)`), ShouldNotBeNil)
+			So(editor.ExecuteCommand(":set fdm=indent"), ShouldBeNil)
+			grid := NewRuneGrid(20, 5)
+			grid.RenderEditor(&editor)
+			So(editor.Settings().ShiftWidth, ShouldEqual, 4)
+		})
+	})
+}
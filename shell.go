@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+// Suspender is implemented by UIs that can give the terminal to another program for a while, see Editor.SetSuspender.
+type Suspender interface {
+	Suspend()
+	Resume()
+}
+
+// SetSuspender sets what gives the terminal to shell commands and Ctrl-Z, usually the UI.
+// Without one, shell commands have their output shown as a message and the editor can't be suspended.
+func (editor *Editor) SetSuspender(suspender Suspender) {
+	editor.suspender = suspender
+}
+
+// shellCommand returns a command that runs the line with the shell setting.
+func (editor *Editor) shellCommand(line string) *exec.Cmd {
+	return exec.Command(editor.settings.Shell, "-c", line)
+}
+
+// RunShell runs a command line. When there is a Suspender the command gets the terminal
+// and the user presses Enter to return, otherwise its output is shown as a message.
+func (editor *Editor) RunShell(line string) error {
+	cmd := editor.shellCommand(line)
+
+	if editor.suspender == nil {
+		output, err := cmd.CombinedOutput()
+		if text := strings.TrimRight(string(output), "\n"); text != "" {
+			editor.Echo(text)
+		}
+		return shellError(err)
+	}
+
+	editor.suspender.Suspend()
+	defer editor.suspender.Resume()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := shellError(cmd.Run())
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "\n%s", err)
+	}
+	waitForEnter(os.Stdin, os.Stdout)
+	return nil
+}
+
+// waitForEnter asks the user to press Enter and waits for them to do it.
+func waitForEnter(in io.Reader, out io.Writer) {
+	fmt.Fprint(out, "\nPress ENTER to continue")
+	bufio.NewReader(in).ReadString('\n')
+}
+
+// FilterLines replaces the lines from first to last with the output of a command given them as input, ie "sort".
+// The lines are left alone if the command fails.
+func (editor *Editor) FilterLines(first, last int, line string) error {
+	buffer := editor.CurrentPane().Buffer()
+	if buffer == nil {
+		return errors.New("No buffer")
+	}
+
+	lines, err := buffer.GetLines(first, last)
+	if err != nil {
+		return err
+	}
+
+	output, err := editor.captureShell(line, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return err
+	}
+
+	if err := buffer.ReplaceLines(first, last, outputLines(output)); err != nil {
+		return err
+	}
+	editor.CurrentPane().Cursor().Move(0, first)
+	return nil
+}
+
+// ReadShell inserts the output of a command after the line.
+func (editor *Editor) ReadShell(after int, line string) error {
+	output, err := editor.captureShell(line, "")
+	if err != nil {
+		return err
+	}
+	return editor.insertLines(after, outputLines(output))
+}
+
+// ReadFile inserts the contents of a file after the line.
+func (editor *Editor) ReadFile(after int, filename string) error {
+	file, err := editor.fs.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Can't open file %s", filename)
+	}
+	defer file.Close()
+
+	data := new(bytes.Buffer)
+	data.ReadFrom(file)
+	return editor.insertLines(after, outputLines(data.String()))
+}
+
+// insertLines adds lines to the current buffer and moves the cursor to the first of them.
+func (editor *Editor) insertLines(after int, lines []string) error {
+	buffer := editor.CurrentPane().Buffer()
+	if buffer == nil {
+		return errors.New("No buffer")
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err := buffer.InsertLines(after, lines); err != nil {
+		return err
+	}
+	editor.CurrentPane().Cursor().Move(0, after+1)
+	return nil
+}
+
+// captureShell runs a command line with the given input and returns what it writes to stdout.
+func (editor *Editor) captureShell(line, input string) (string, error) {
+	cmd := editor.shellCommand(line)
+	cmd.Stdin = strings.NewReader(input)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err = shellError(err); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s: %s", err, message)
+		}
+		return "", err
+	}
+	return string(output), nil
+}
+
+// shellError describes why a shell command failed.
+func shellError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("Shell returned %d", exit.ExitCode())
+	}
+	return err
+}
+
+// outputLines splits command output into lines, ignoring the final newline.
+func outputLines(output string) []string {
+	if output == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
+
+// Suspend gives the terminal back to the shell and stops the process until it is continued, like Ctrl-Z normally does.
+func (editor *Editor) Suspend() error {
+	if editor.suspender == nil || continueSignal == nil {
+		return errors.New("Cannot suspend")
+	}
+
+	editor.suspender.Suspend()
+	defer editor.suspender.Resume()
+
+	continued := make(chan os.Signal, 1)
+	signal.Notify(continued, continueSignal)
+	defer signal.Stop(continued)
+
+	suspendProcess()
+	<-continued
+	return nil
+}
+
+// bangCommand runs ":!cmd", or filters the lines through the command when there is a range, ie ":%!sort".
+func bangCommand(editor *Editor, command Command) error {
+	if command.Args == "" {
+		return errors.New("Argument required")
+	}
+
+	if command.Range == "" {
+		return editor.RunShell(command.Args)
+	}
+
+	first, last, err := editor.CommandRange(command)
+	if err != nil {
+		return err
+	}
+	return editor.FilterLines(first, last, command.Args)
+}
+
+// readCommand inserts a file, or the output of a command with ":r !cmd", after the line.
+func readCommand(editor *Editor, command Command) error {
+	_, last, err := editor.CommandRange(command)
+	if err != nil {
+		return err
+	}
+
+	args := command.Args
+	if command.Bang || strings.HasPrefix(args, "!") {
+		args = strings.TrimSpace(strings.TrimPrefix(args, "!"))
+		if args == "" {
+			return errors.New("Argument required")
+		}
+		return editor.ReadShell(last, args)
+	}
+
+	if args == "" {
+		return errors.New("No file name")
+	}
+	return editor.ReadFile(last, args)
+}
+
+func suspendCommand(editor *Editor, command Command) error {
+	return editor.Suspend()
+}
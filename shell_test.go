@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShellCommands(t *testing.T) {
+	Convey("Editor with some lines", t, func() {
+		editor := NewEditor(GetTestFs())
+		editor.Settings().Shell = "sh"
+		buffer := editor.AddBuffer(bufferWithText("c\nb\na\n"))
+		editor.SwitchToBuffer(buffer)
+		cursor := editor.CurrentPane().Cursor()
+
+		Convey(":{range}! filters the lines through the command", func() {
+			So(editor.ExecuteCommand("%!sort"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "a\nb\nc\n")
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 1)
+		})
+
+		Convey("a failing filter leaves the lines alone", func() {
+			err := editor.ExecuteCommand("1,2!echo oops >&2; exit 3")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Shell returned 3: oops")
+			So(string(buffer.data), ShouldEqual, "c\nb\na\n")
+		})
+
+		Convey(":r !cmd reads the output after the current line", func() {
+			cursor.Move(0, 2)
+			So(editor.ExecuteCommand("r !printf '1\\n2\\n'"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "c\nb\n1\n2\na\n")
+			_, line := cursor.Position()
+			So(line, ShouldEqual, 3)
+		})
+
+		Convey(":r file reads a file", func() {
+			So(editor.ExecuteCommand("$r file.txt"), ShouldBeNil)
+			So(string(buffer.data), ShouldEqual, "c\nb\na\n!\n")
+		})
+
+		Convey(":r of a missing file is an error", func() {
+			So(editor.ExecuteCommand("r nothere.txt"), ShouldNotBeNil)
+		})
+
+		Convey(":!cmd shows the output without a Suspender", func() {
+			So(editor.ExecuteCommand("!echo hello"), ShouldBeNil)
+			So(editor.Message().Text, ShouldEqual, "hello")
+		})
+
+		Convey(":! needs a command", func() {
+			So(editor.ExecuteCommand("!"), ShouldNotBeNil)
+		})
+
+		Convey("suspending needs a Suspender", func() {
+			So(editor.ExecuteCommand("suspend"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("waitForEnter prompts and reads a line", t, func() {
+		in := strings.NewReader("\nleft over")
+		out := new(bytes.Buffer)
+		waitForEnter(in, out)
+		So(out.String(), ShouldEqual, "\nPress ENTER to continue")
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import "sort"
+
+// Sign is a marker shown in the sign column next to a line, such as a diagnostic,
+// VCS change or breakpoint.
+// Group lets a subsystem manage its own signs, ie "diagnostics" or "git".
+// When several signs are on one line the one with the highest Priority is shown.
+type Sign struct {
+	ID       int
+	Group    string
+	Line     int
+	Text     string
+	Style    Style
+	Priority int
+}
+
+// PlaceSign adds a sign to the buffer and returns its ID.
+// If sign.ID is already in use in the same group the existing sign is replaced.
+func (buffer *Buffer) PlaceSign(sign Sign) int {
+	if sign.ID == 0 {
+		buffer.lastSignID++
+		sign.ID = buffer.lastSignID
+	}
+
+	for i, s := range buffer.signs {
+		if s.ID == sign.ID && s.Group == sign.Group {
+			buffer.signs[i] = sign
+			return sign.ID
+		}
+	}
+
+	buffer.signs = append(buffer.signs, sign)
+	return sign.ID
+}
+
+// UnplaceSign removes the sign with the given ID from a group.
+func (buffer *Buffer) UnplaceSign(group string, id int) {
+	for i, s := range buffer.signs {
+		if s.ID == id && s.Group == group {
+			buffer.signs = append(buffer.signs[:i], buffer.signs[i+1:]...)
+			return
+		}
+	}
+}
+
+// ClearSigns removes every sign in a group.
+func (buffer *Buffer) ClearSigns(group string) {
+	signs := []Sign{}
+	for _, s := range buffer.signs {
+		if s.Group != group {
+			signs = append(signs, s)
+		}
+	}
+	buffer.signs = signs
+}
+
+// Signs returns every sign in the buffer.
+func (buffer *Buffer) Signs() []Sign {
+	return buffer.signs
+}
+
+// SignsAt returns the signs on a line, highest priority first.
+func (buffer *Buffer) SignsAt(line int) []Sign {
+	signs := []Sign{}
+	for _, s := range buffer.signs {
+		if s.Line == line {
+			signs = append(signs, s)
+		}
+	}
+
+	sort.SliceStable(signs, func(i, j int) bool {
+		return signs[i].Priority > signs[j].Priority
+	})
+	return signs
+}
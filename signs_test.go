@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigns(t *testing.T) {
+	Convey("Buffer with signs from two groups", t, func() {
+		buffer := NewBuffer()
+		error := buffer.PlaceSign(Sign{Group: "diagnostics", Line: 3, Text: "E", Priority: 10})
+		buffer.PlaceSign(Sign{Group: "git", Line: 3, Text: "+", Priority: 5})
+		buffer.PlaceSign(Sign{Group: "git", Line: 4, Text: "~", Priority: 5})
+
+		Convey("PlaceSign assigns IDs", func() {
+			So(error, ShouldEqual, 1)
+			So(len(buffer.Signs()), ShouldEqual, 3)
+		})
+
+		Convey("SignsAt orders by priority", func() {
+			signs := buffer.SignsAt(3)
+			So(len(signs), ShouldEqual, 2)
+			So(signs[0].Text, ShouldEqual, "E")
+			So(signs[1].Text, ShouldEqual, "+")
+		})
+
+		Convey("PlaceSign with an existing ID replaces the sign", func() {
+			buffer.PlaceSign(Sign{ID: error, Group: "diagnostics", Line: 7, Text: "W"})
+			So(len(buffer.Signs()), ShouldEqual, 3)
+			So(buffer.SignsAt(7)[0].Text, ShouldEqual, "W")
+		})
+
+		Convey("UnplaceSign removes a single sign", func() {
+			buffer.UnplaceSign("diagnostics", error)
+			So(len(buffer.SignsAt(3)), ShouldEqual, 1)
+		})
+
+		Convey("ClearSigns removes a group", func() {
+			buffer.ClearSigns("git")
+			So(len(buffer.Signs()), ShouldEqual, 1)
+			So(buffer.Signs()[0].Group, ShouldEqual, "diagnostics")
+		})
+	})
+}
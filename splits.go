@@ -0,0 +1,376 @@
+package main
+
+import "errors"
+
+// Rect is an area of the screen, inclusive of both corners.
+type Rect struct {
+	X1 int
+	Y1 int
+	X2 int
+	Y2 int
+}
+
+// Contains returns true if the cell is inside the Rect.
+func (rect Rect) Contains(x, y int) bool {
+	return x >= rect.X1 && x <= rect.X2 && y >= rect.Y1 && y <= rect.Y2
+}
+
+// Split is a node of the pane layout. A leaf shows a Pane, other nodes divide their area
+// between their Children, side by side when Vertical or stacked otherwise.
+// Size is the rows or columns the Split wants in its parent, 0 shares out the space left over.
+type Split struct {
+	Pane     *Pane
+	Vertical bool
+	Children []*Split
+	Size     int
+
+	parent     *Split
+	area       Rect
+	sizes      []int
+	separators []int
+}
+
+// Leaves returns the Panes of the layout in order.
+func (split *Split) Leaves() []*Pane {
+	if split.Pane != nil {
+		return []*Pane{split.Pane}
+	}
+
+	panes := []*Pane{}
+	for _, child := range split.Children {
+		panes = append(panes, child.Leaves()...)
+	}
+	return panes
+}
+
+// Find returns the leaf showing the Pane, or nil.
+func (split *Split) Find(pane *Pane) *Split {
+	if split.Pane == pane {
+		return split
+	}
+	for _, child := range split.Children {
+		if found := child.Find(pane); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Area returns where the Split was last drawn.
+func (split *Split) Area() Rect {
+	return split.area
+}
+
+// divide shares the available rows or columns between the children.
+func (split *Split) divide(available int) []int {
+	sizes := make([]int, len(split.Children))
+	shared := []int{}
+	remaining := available
+
+	for i, child := range split.Children {
+		if child.Size > 0 {
+			sizes[i] = child.Size
+			remaining -= child.Size
+		} else {
+			shared = append(shared, i)
+		}
+	}
+
+	if len(shared) == 0 {
+		shared = []int{len(sizes) - 1}
+	}
+	for n, i := range shared {
+		share := remaining / len(shared)
+		if n < remaining%len(shared) {
+			share++
+		}
+		sizes[i] += share
+	}
+
+	// Take back space from the last children if the fixed sizes don't fit.
+	for i := len(sizes) - 1; i >= 0; i-- {
+		if sizes[i] >= 1 {
+			continue
+		}
+		short := 1 - sizes[i]
+		sizes[i] = 1
+		for j := len(sizes) - 1; j >= 0 && short > 0; j-- {
+			if j != i && sizes[j] > 1 {
+				take := sizes[j] - 1
+				if take > short {
+					take = short
+				}
+				sizes[j] -= take
+				short -= take
+			}
+		}
+	}
+
+	return sizes
+}
+
+// separatorWidth is the number of cells between the children of a Split.
+// Side by side panes always have a separator, stacked panes are separated by their status lines without borders.
+func (split *Split) separatorWidth(settings *Settings) int {
+	if split.Vertical || settings.Borders {
+		return 1
+	}
+	return 0
+}
+
+// Layout returns the root of the pane layout.
+func (editor *Editor) Layout() *Split {
+	return editor.layout
+}
+
+// SplitPane splits the current Pane in two, the new Pane shows the same Buffer and gets the focus.
+// A vertical split puts the new Pane on the left, otherwise it goes above.
+func (editor *Editor) SplitPane(vertical bool) *Pane {
+	current := editor.CurrentPane()
+	pane := NewPane()
+	pane.SetBuffer(current.Buffer())
+	if cursor := current.Cursor(); cursor != nil {
+		pane.Cursor().Move(cursor.Position())
+	}
+	pane.SetTopLine(current.TopLine())
+	pane.wrap = current.wrap
+
+	leaf := editor.layout.Find(current)
+	newLeaf := &Split{Pane: &pane}
+
+	if parent := leaf.parent; parent != nil && parent.Vertical == vertical {
+		newLeaf.parent = parent
+		for i, child := range parent.Children {
+			if child == leaf {
+				parent.Children = append(parent.Children[:i], append([]*Split{newLeaf}, parent.Children[i:]...)...)
+				break
+			}
+		}
+		for _, child := range parent.Children {
+			child.Size = 0
+		}
+	} else {
+		old := &Split{Pane: leaf.Pane, parent: leaf}
+		newLeaf.parent = leaf
+		leaf.Pane = nil
+		leaf.Vertical = vertical
+		leaf.Children = []*Split{newLeaf, old}
+	}
+
+	editor.panes = append(editor.panes, &pane)
+	editor.SetCurrentPane(&pane)
+	return &pane
+}
+
+// ClosePane removes a Pane from the layout, the last Pane can't be closed.
+func (editor *Editor) ClosePane(pane *Pane) error {
+	if len(editor.panes) <= 1 {
+		return errors.New("Cannot close last window")
+	}
+
+	leaf := editor.layout.Find(pane)
+	if leaf == nil || leaf.parent == nil {
+		return errors.New("No such window")
+	}
+
+	parent := leaf.parent
+	index := 0
+	for i, child := range parent.Children {
+		if child == leaf {
+			index = i
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	for _, child := range parent.Children {
+		child.Size = 0
+	}
+
+	if len(parent.Children) == 1 {
+		only := parent.Children[0]
+		parent.Pane = only.Pane
+		parent.Vertical = only.Vertical
+		parent.Children = only.Children
+		for _, child := range parent.Children {
+			child.parent = parent
+		}
+	}
+
+	for i, p := range editor.panes {
+		if p == pane {
+			editor.panes = append(editor.panes[:i], editor.panes[i+1:]...)
+			break
+		}
+	}
+
+	if editor.CurrentPane() == pane {
+		if index >= len(parent.Children) {
+			index = len(parent.Children) - 1
+		}
+		next := parent
+		if len(parent.Children) > 0 {
+			next = parent.Children[index]
+		}
+		editor.SetCurrentPane(next.Leaves()[0])
+	}
+	return nil
+}
+
+// OnlyPane closes every Pane but the current one.
+func (editor *Editor) OnlyPane() {
+	current := editor.CurrentPane()
+	editor.layout = &Split{Pane: current}
+	editor.panes = []*Pane{current}
+}
+
+// CyclePane moves the focus forward or backward through the Panes by the offset.
+func (editor *Editor) CyclePane(offset int) {
+	panes := editor.layout.Leaves()
+	for i, pane := range panes {
+		if pane == editor.CurrentPane() {
+			next := ((i+offset)%len(panes) + len(panes)) % len(panes)
+			editor.SetCurrentPane(panes[next])
+			return
+		}
+	}
+}
+
+// PaneAt returns the Pane drawn over the cell, or nil.
+func (editor *Editor) PaneAt(x, y int) *Pane {
+	for _, pane := range editor.layout.Leaves() {
+		if pane.View().Frame.Contains(x, y) {
+			return pane
+		}
+	}
+	return nil
+}
+
+// RenderLayout draws the Split and everything in it.
+func (grid *RuneGrid) RenderLayout(editor *Editor, split *Split, x1, y1, x2, y2 int) {
+	split.area = Rect{x1, y1, x2, y2}
+
+	if split.Pane != nil {
+		grid.RenderPane(editor, x1, y1, x2, y2, split.Pane)
+		return
+	}
+
+	settings := editor.Settings()
+	separator := split.separatorWidth(settings)
+	total := y2 - y1 + 1
+	start := y1
+	if split.Vertical {
+		total = x2 - x1 + 1
+		start = x1
+	}
+
+	split.sizes = split.divide(total - separator*(len(split.Children)-1))
+	split.separators = make([]int, len(split.Children)-1)
+
+	pos := start
+	for i, child := range split.Children {
+		end := pos + split.sizes[i] - 1
+		if split.Vertical {
+			grid.RenderLayout(editor, child, pos, y1, end, y2)
+		} else {
+			grid.RenderLayout(editor, child, x1, pos, x2, end)
+		}
+		pos = end + 1
+
+		if i == len(split.Children)-1 {
+			break
+		}
+		split.separators[i] = pos
+		if separator == 0 {
+			split.separators[i] = end
+			continue
+		}
+		if split.Vertical {
+			grid.DrawVerticalLine(pos, y1, y2, separatorRune(settings, true))
+		} else {
+			grid.DrawHorizontalLine(x1, x2, pos, separatorRune(settings, false))
+		}
+		pos += separator
+	}
+}
+
+func separatorRune(settings *Settings, vertical bool) rune {
+	switch {
+	case vertical && settings.Borders:
+		return '║'
+	case vertical:
+		return '│'
+	}
+	return '═'
+}
+
+// separatorAt finds the separator between two children drawn over the cell.
+// Without borders the last row of the upper pane, its status line, is used for stacked panes.
+func (split *Split) separatorAt(x, y int) (*Split, int) {
+	if split.Pane != nil || !split.area.Contains(x, y) {
+		return nil, 0
+	}
+
+	for i, pos := range split.separators {
+		if split.Vertical && x == pos || !split.Vertical && y == pos {
+			return split, i
+		}
+	}
+
+	for _, child := range split.Children {
+		if found, i := child.separatorAt(x, y); found != nil {
+			return found, i
+		}
+	}
+	return nil, 0
+}
+
+// resizeChildren moves the separator after child i by delta, starting from the given sizes.
+func (split *Split) resizeChildren(i int, sizes []int, delta int) {
+	if sizes[i]+delta < 1 {
+		delta = 1 - sizes[i]
+	}
+	if sizes[i+1]-delta < 1 {
+		delta = sizes[i+1] - 1
+	}
+
+	for j, child := range split.Children {
+		child.Size = sizes[j]
+	}
+	split.Children[i].Size += delta
+	split.Children[i+1].Size -= delta
+}
+
+func splitCommand(editor *Editor, command Command) error {
+	return splitWithFile(editor, command, false)
+}
+
+func verticalSplitCommand(editor *Editor, command Command) error {
+	return splitWithFile(editor, command, true)
+}
+
+// splitWithFile splits the current Pane and switches the new one to the named buffer, if there is one.
+func splitWithFile(editor *Editor, command Command, vertical bool) error {
+	var buffer *Buffer
+	if command.Args != "" {
+		found, err := editor.FindBuffer(command.Args)
+		if err != nil {
+			return err
+		}
+		buffer = found
+	}
+
+	editor.SplitPane(vertical)
+	if buffer != nil {
+		editor.SwitchToBuffer(buffer)
+	}
+	return nil
+}
+
+func closeCommand(editor *Editor, command Command) error {
+	return editor.ClosePane(editor.CurrentPane())
+}
+
+func onlyCommand(editor *Editor, command Command) error {
+	editor.OnlyPane()
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSplits(t *testing.T) {
+	Convey("Editor with a file open", t, func() {
+		editor := NewEditor(GetTestFs())
+		editor.OpenFile("fakefile.txt")
+		first := editor.CurrentPane()
+		first.Cursor().Move(2, 3)
+
+		Convey("SplitPane shows the same buffer in a new focused pane", func() {
+			pane := editor.SplitPane(false)
+			So(editor.CurrentPane(), ShouldEqual, pane)
+			So(pane.Buffer(), ShouldEqual, first.Buffer())
+			x, line := pane.Cursor().Position()
+			So(x, ShouldEqual, 2)
+			So(line, ShouldEqual, 3)
+			So(editor.Layout().Leaves(), ShouldResemble, []*Pane{pane, first})
+			So(len(editor.Panes()), ShouldEqual, 2)
+
+			Convey("splitting the same way adds a sibling", func() {
+				third := editor.SplitPane(false)
+				So(len(editor.Layout().Children), ShouldEqual, 3)
+				So(editor.Layout().Leaves(), ShouldResemble, []*Pane{third, pane, first})
+			})
+
+			Convey("splitting the other way nests", func() {
+				third := editor.SplitPane(true)
+				So(len(editor.Layout().Children), ShouldEqual, 2)
+				So(editor.Layout().Children[0].Vertical, ShouldBeTrue)
+				So(editor.Layout().Leaves(), ShouldResemble, []*Pane{third, pane, first})
+			})
+
+			Convey("ClosePane collapses the layout", func() {
+				So(editor.ClosePane(pane), ShouldBeNil)
+				So(editor.CurrentPane(), ShouldEqual, first)
+				So(editor.Layout().Pane, ShouldEqual, first)
+				So(editor.Panes(), ShouldResemble, []*Pane{first})
+
+				Convey("the last pane can't be closed", func() {
+					So(editor.ClosePane(first), ShouldNotBeNil)
+				})
+			})
+
+			Convey("CyclePane moves the focus", func() {
+				editor.CyclePane(1)
+				So(editor.CurrentPane(), ShouldEqual, first)
+				editor.CyclePane(1)
+				So(editor.CurrentPane(), ShouldEqual, pane)
+				editor.CyclePane(-1)
+				So(editor.CurrentPane(), ShouldEqual, first)
+			})
+
+			Convey("OnlyPane closes the others", func() {
+				editor.OnlyPane()
+				So(editor.Panes(), ShouldResemble, []*Pane{pane})
+				So(editor.Layout().Pane, ShouldEqual, pane)
+			})
+		})
+
+		Convey("ex commands", func() {
+			So(editor.ExecuteCommand("vs"), ShouldBeNil)
+			So(editor.Layout().Vertical, ShouldBeTrue)
+			So(editor.ExecuteCommand("sp"), ShouldBeNil)
+			So(len(editor.Panes()), ShouldEqual, 3)
+			So(editor.ExecuteCommand("clo"), ShouldBeNil)
+			So(len(editor.Panes()), ShouldEqual, 2)
+			So(editor.ExecuteCommand("on"), ShouldBeNil)
+			So(len(editor.Panes()), ShouldEqual, 1)
+		})
+
+		Convey("rendering a vertical split", func() {
+			editor.Settings().Borders = false
+			editor.Settings().StatusLine = ""
+			left := editor.SplitPane(true)
+			grid := NewRuneGrid(21, 5)
+			grid.RenderEditor(&editor)
+
+			So(grid.Cells()[0][10], ShouldEqual, '│')
+			So(left.View().Frame, ShouldResemble, Rect{0, 0, 9, 3})
+			So(first.View().Frame, ShouldResemble, Rect{11, 0, 20, 3})
+			So(editor.PaneAt(3, 1), ShouldEqual, left)
+			So(editor.PaneAt(15, 1), ShouldEqual, first)
+			So(editor.PaneAt(10, 1), ShouldBeNil)
+		})
+	})
+}
+
+func TestSplitDivide(t *testing.T) {
+	Convey("Split.divide", t, func() {
+		split := &Split{Children: []*Split{{}, {}, {}}}
+
+		Convey("shares space evenly, earlier children get the remainder", func() {
+			So(split.divide(11), ShouldResemble, []int{4, 4, 3})
+		})
+
+		Convey("fixed sizes are kept", func() {
+			split.Children[0].Size = 2
+			So(split.divide(10), ShouldResemble, []int{2, 4, 4})
+		})
+
+		Convey("the last child takes up the slack when every size is fixed", func() {
+			for _, child := range split.Children {
+				child.Size = 2
+			}
+			So(split.divide(10), ShouldResemble, []int{2, 2, 6})
+		})
+
+		Convey("sizes that don't fit are shrunk", func() {
+			split.Children[0].Size = 9
+			So(split.divide(5), ShouldResemble, []int{3, 1, 1})
+		})
+	})
+}
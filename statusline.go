@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// DefaultStatusLine is the status line format used when none is configured.
+const DefaultStatusLine = "%f %m%=%y %e %o %l:%c %p%%"
+
+// FormatStatusLine expands the format items in a status line format for the given pane
+// and pads or truncates the result to width.
+//
+// Format items:
+//
+//	%f  buffer name
+//	%n  buffer number
+//	%m  "[+]" if the buffer is modified
+//	%y  filetype
+//	%e  encoding
+//	%o  line ending style
+//	%l  line number
+//	%c  column number
+//	%L  number of lines
+//	%p  percentage through the file
+//	%=  separates the left and right aligned parts
+//	%%  a literal '%'
+//
+// Items added by plugins, "%{name}", are expanded by the editor before formatting.
+func FormatStatusLine(format string, pane *Pane, width int) string {
+	left := bytes.Buffer{}
+	right := bytes.Buffer{}
+	out := &left
+
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' || i+1 == len(runes) {
+			out.WriteRune(runes[i])
+			continue
+		}
+
+		i++
+		if runes[i] == '=' {
+			out = &right
+			continue
+		}
+		out.WriteString(statusLineItem(runes[i], pane))
+	}
+
+	return alignStatusLine(left.String(), right.String(), width)
+}
+
+func statusLineItem(item rune, pane *Pane) string {
+	if item == '%' {
+		return "%"
+	}
+
+	buffer := pane.Buffer()
+	if buffer == nil {
+		return ""
+	}
+
+	x, line := 0, 1
+	if cursor := pane.Cursor(); cursor != nil {
+		x, line = cursor.Position()
+	}
+
+	switch item {
+	case 'f':
+		return buffer.Name()
+	case 'n':
+		return strconv.Itoa(buffer.ID())
+	case 'm':
+		if buffer.Modified() {
+			return "[+]"
+		}
+	case 'y':
+		return buffer.Filetype()
+	case 'e':
+		return buffer.Encoding()
+	case 'o':
+		return buffer.FileFormat()
+	case 'l':
+		return strconv.Itoa(line)
+	case 'c':
+		return strconv.Itoa(x + 1)
+	case 'L':
+		return strconv.Itoa(buffer.LineCount())
+	case 'p':
+		return strconv.Itoa(percentThrough(line, buffer.LineCount()))
+	}
+	return ""
+}
+
+func percentThrough(line, lineCount int) int {
+	if lineCount <= 1 {
+		return 100
+	}
+	return (line - 1) * 100 / (lineCount - 1)
+}
+
+// alignStatusLine pads between left and right so right is flush with width.
+// When there isn't enough room the left side is truncated first.
+func alignStatusLine(left, right string, width int) string {
+	if width < 1 {
+		return ""
+	}
+	leftRunes := []rune(left)
+	rightRunes := []rune(right)
+
+	if len(rightRunes) > width {
+		return string(rightRunes[len(rightRunes)-width:])
+	}
+
+	room := width - len(rightRunes)
+	if len(leftRunes) > room {
+		leftRunes = leftRunes[:room]
+	}
+
+	padding := strings.Repeat(" ", room-len(leftRunes))
+	return string(leftRunes) + padding + string(rightRunes)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormatStatusLine(t *testing.T) {
+	Convey("Pane showing a three line Go file", t, func() {
+		buffer := NewBuffer()
+		buffer.SetFilename("main.go")
+		buffer.SetDataString("package main\r\n\r\nfunc main() {}\r\n")
+		pane := NewPane()
+		pane.SetBuffer(&buffer)
+
+		Convey("expands format items", func() {
+			So(FormatStatusLine("%n %f%m %y %e %o %L", &pane, 30), ShouldEqual, "0 main.go go utf-8 dos 3      ")
+		})
+
+		Convey("shows the modified flag", func() {
+			buffer.SetModified(true)
+			So(FormatStatusLine("%f%m", &pane, 10), ShouldEqual, "main.go[+]")
+		})
+
+		Convey("shows the cursor position and percentage", func() {
+			pane.Cursor().Move(4, 2)
+			So(FormatStatusLine("%l:%c %p%%", &pane, 8), ShouldEqual, "2:5 50% ")
+		})
+
+		Convey("right aligns after %=", func() {
+			So(FormatStatusLine("%f%=%l:%c", &pane, 12), ShouldEqual, "main.go  1:1")
+		})
+
+		Convey("truncates the left side first", func() {
+			So(FormatStatusLine("%f%=%l:%c", &pane, 6), ShouldEqual, "mai1:1")
+		})
+
+		Convey("is empty when there's no room", func() {
+			So(FormatStatusLine("%f%=%l:%c", &pane, 0), ShouldEqual, "")
+			So(FormatStatusLine("%f%=%l:%c", &pane, -1), ShouldEqual, "")
+		})
+
+		Convey("without a buffer only literal text is shown", func() {
+			empty := NewPane()
+			So(FormatStatusLine("%f [%l]", &empty, 5), ShouldEqual, " []  ")
+		})
+	})
+}
@@ -1,36 +1,32 @@
 package main
 
-import "github.com/nsf/termbox-go"
+import (
+	"sync"
+
+	"github.com/nsf/termbox-go"
+)
+
+// termboxUsers counts the initialized TermboxDrivers, termbox itself is only started once and closed by the last one.
+var termboxUsers struct {
+	sync.Mutex
+	count int
+}
 
 // TermboxDriver is a ConsoleDriver that uses termbox-go.
+// It can be closed and initialized again, ie to let another program use the terminal.
 type TermboxDriver struct {
 	events chan Event
 	quit   chan interface{}
+	done   chan interface{}
+	active bool
 	width  int
 	height int
 }
 
-// NewTermboxDriver constructs a new TermboxDriver.
+// NewTermboxDriver constructs a new TermboxDriver, termbox isn't started until Init.
 func NewTermboxDriver() TermboxDriver {
-	tbd := TermboxDriver{
-		width:  0,
-		height: 0,
-	}
-
-	tbd.initializeChannels()
-
-	termbox.Init()
-	tbd.setSize(termbox.Size())
-
-	return tbd
-}
-
-func (tbd *TermboxDriver) initializeChannels() {
-	if tbd.events == nil {
-		tbd.events = make(chan Event)
-	}
-	if tbd.quit == nil {
-		tbd.quit = make(chan interface{})
+	return TermboxDriver{
+		events: make(chan Event),
 	}
 }
 
@@ -39,20 +35,43 @@ func (tbd *TermboxDriver) Size() (width int, height int) {
 	return tbd.width, tbd.height
 }
 
-// Init initilizes the Termbox library.
+// Init initilizes the Termbox library and starts reading events.
 func (tbd *TermboxDriver) Init() {
-	go tbd.handleEvents()
+	if tbd.active {
+		return
+	}
+	tbd.active = true
+
+	termboxUsers.Lock()
+	if termboxUsers.count == 0 {
+		termbox.Init()
+	}
+	termboxUsers.count++
+	termboxUsers.Unlock()
+
+	tbd.setSize(termbox.Size())
+	tbd.quit = make(chan interface{})
+	tbd.done = make(chan interface{})
+	go tbd.handleEvents(tbd.quit, tbd.done)
 }
 
-// Close cleansup the Termbox library.
-// [TODO]: If this is called on one instance of multiple TermboxDriver
-// instances then they all die... Need a refrence count. - 2014-09-30 02:25pm
+// Close stops reading events and cleans up the Termbox library once no other TermboxDriver is using it.
 func (tbd *TermboxDriver) Close() {
+	if !tbd.active {
+		return
+	}
+	tbd.active = false
+
 	close(tbd.quit)
-	tbd.quit = nil
-	tbd.initializeChannels()
+	termbox.Interrupt()
+	<-tbd.done
 
-	termbox.Close()
+	termboxUsers.Lock()
+	termboxUsers.count--
+	if termboxUsers.count == 0 {
+		termbox.Close()
+	}
+	termboxUsers.Unlock()
 }
 
 // SetCell sets a character in the console
@@ -65,6 +84,15 @@ func (tbd *TermboxDriver) SetCursor(x, y int) {
 	termbox.SetCursor(x, y)
 }
 
+// SetMouse turns reporting of mouse events on or off.
+func (tbd *TermboxDriver) SetMouse(enabled bool) {
+	mode := termbox.InputEsc
+	if enabled {
+		mode |= termbox.InputMouse
+	}
+	termbox.SetInputMode(mode)
+}
+
 // Events returns a channel of events
 func (tbd *TermboxDriver) Events() chan Event {
 	return tbd.events
@@ -76,29 +104,42 @@ func (tbd *TermboxDriver) AfterDraw() {
 }
 
 func colorToAttribute(color Color) termbox.Attribute {
-	return color.(termbox.Attribute)
+	switch c := color.(type) {
+	case RGB:
+		return termbox.Attribute(c.NearestBasic() + 1)
+	case termbox.Attribute:
+		return c
+	}
+	return termbox.ColorDefault
 }
 
-func (tbd *TermboxDriver) handleEvents() {
-loop:
+// handleEvents polls termbox until it is interrupted after quit is closed.
+// Interrupts meant for another TermboxDriver are passed on.
+func (tbd *TermboxDriver) handleEvents(quit, done chan interface{}) {
+	defer close(done)
+
 	for {
-		select {
-		case <-tbd.quit:
-			break loop
-		default:
-			tbd.handleEvent()
+		event := termbox.PollEvent()
+
+		if event.Type == termbox.EventInterrupt {
+			select {
+			case <-quit:
+				return
+			default:
+				go termbox.Interrupt()
+				continue
+			}
 		}
-	}
-}
 
-func (tbd *TermboxDriver) handleEvent() {
-	event := termbox.PollEvent()
+		if event.Type == termbox.EventResize {
+			tbd.setSize(event.Width, event.Height)
+		}
 
-	if event.Type == termbox.EventResize {
-		tbd.setSize(event.Width, event.Height)
+		select {
+		case tbd.events <- termboxEventToInternal(event):
+		case <-quit:
+		}
 	}
-
-	tbd.events <- termboxEventToInternal(event)
 }
 
 func (tbd *TermboxDriver) setSize(width, height int) {
@@ -106,6 +147,95 @@ func (tbd *TermboxDriver) setSize(width, height int) {
 	tbd.height = height
 }
 
+var termboxKeys = map[termbox.Key]Key{
+	termbox.KeyEsc:        KeyEscape,
+	termbox.KeyEnter:      KeyEnter,
+	termbox.KeyTab:        KeyTab,
+	termbox.KeyBackspace:  KeyBackspace,
+	termbox.KeyBackspace2: KeyBackspace,
+	termbox.KeyDelete:     KeyDelete,
+	termbox.KeyInsert:     KeyInsert,
+	termbox.KeyArrowUp:    KeyUp,
+	termbox.KeyArrowDown:  KeyDown,
+	termbox.KeyArrowLeft:  KeyLeft,
+	termbox.KeyArrowRight: KeyRight,
+	termbox.KeyHome:       KeyHome,
+	termbox.KeyEnd:        KeyEnd,
+	termbox.KeyPgup:       KeyPageUp,
+	termbox.KeyPgdn:       KeyPageDown,
+	termbox.KeyF1:         KeyF1,
+	termbox.KeyF2:         KeyF2,
+	termbox.KeyF3:         KeyF3,
+	termbox.KeyF4:         KeyF4,
+	termbox.KeyF5:         KeyF5,
+	termbox.KeyF6:         KeyF6,
+	termbox.KeyF7:         KeyF7,
+	termbox.KeyF8:         KeyF8,
+	termbox.KeyF9:         KeyF9,
+	termbox.KeyF10:        KeyF10,
+	termbox.KeyF11:        KeyF11,
+	termbox.KeyF12:        KeyF12,
+}
+
+var termboxMouseButtons = map[termbox.Key]MouseButton{
+	termbox.MouseLeft:      MouseLeft,
+	termbox.MouseMiddle:    MouseMiddle,
+	termbox.MouseRight:     MouseRight,
+	termbox.MouseRelease:   MouseNone,
+	termbox.MouseWheelUp:   MouseWheelUp,
+	termbox.MouseWheelDown: MouseWheelDown,
+}
+
+// termboxEventToInternal converts a termbox event to one of the UI's own event types.
 func termboxEventToInternal(event termbox.Event) Event {
+	switch event.Type {
+	case termbox.EventKey:
+		return Event{termboxKeyToInternal(event)}
+	case termbox.EventResize:
+		return Event{ResizeEvent{Width: event.Width, Height: event.Height}}
+	case termbox.EventMouse:
+		return Event{termboxMouseToInternal(event)}
+	}
 	return Event{event}
 }
+
+func termboxKeyToInternal(event termbox.Event) KeyEvent {
+	var mod Modifier
+	if event.Mod&termbox.ModAlt != 0 {
+		mod |= ModAlt
+	}
+
+	if event.Ch != 0 {
+		return KeyEvent{Rune: event.Ch, Mod: mod}
+	}
+	if key, ok := termboxKeys[event.Key]; ok {
+		return KeyEvent{Key: key, Mod: mod}
+	}
+	return controlKey(rune(event.Key), mod)
+}
+
+func termboxMouseToInternal(event termbox.Event) MouseEvent {
+	mouse := MouseEvent{X: event.MouseX, Y: event.MouseY, Button: termboxMouseButtons[event.Key]}
+	switch {
+	case event.Key == termbox.MouseRelease:
+		mouse.Action = MouseRelease
+	case event.Mod&termbox.ModMotion != 0:
+		mouse.Action = MouseDrag
+	}
+	return mouse
+}
+
+// controlKey converts a control character to a KeyEvent, ie 0x01 is Ctrl-A and 0x1E is Ctrl-^.
+func controlKey(r rune, mod Modifier) KeyEvent {
+	switch {
+	case r == 0x20:
+		return KeyEvent{Rune: ' ', Mod: mod}
+	case r == 0x00:
+		return KeyEvent{Rune: ' ', Mod: mod | ModCtrl}
+	case r < 0x1B:
+		return KeyEvent{Rune: 'a' + r - 1, Mod: mod | ModCtrl}
+	case r > 0x1B && r < 0x20:
+		return KeyEvent{Rune: []rune{'\\', ']', '^', '_'}[r-0x1C], Mod: mod | ModCtrl}
+	}
+	return KeyEvent{Rune: r, Mod: mod}
+}
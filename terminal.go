@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// Terminal runs a program in a pseudo-terminal and emulates what it draws, see Editor.OpenTerminal.
+// While the user scrolls through it in normal mode the output is shown as the text of its Buffer instead.
+type Terminal struct {
+	vterm     VTerm
+	pty       *os.File
+	cmd       *exec.Cmd
+	running   bool
+	exitCode  int
+	scrolling bool
+	escape    bool
+}
+
+// VTerm returns the emulated screen.
+func (terminal *Terminal) VTerm() *VTerm {
+	return &terminal.vterm
+}
+
+// Running returns true until the program exits.
+func (terminal *Terminal) Running() bool {
+	return terminal.running
+}
+
+// ExitCode returns the exit code of the program once it has exited.
+func (terminal *Terminal) ExitCode() int {
+	return terminal.exitCode
+}
+
+// Live returns true if the emulated screen is shown rather than the Buffer's text.
+func (terminal *Terminal) Live() bool {
+	return !terminal.scrolling
+}
+
+// Send types text into the program.
+func (terminal *Terminal) Send(data []byte) {
+	if terminal.running {
+		terminal.pty.Write(data)
+	}
+}
+
+// Resize changes the size of the emulated screen and tells the program.
+func (terminal *Terminal) Resize(width, height int) {
+	if w, h := terminal.vterm.Size(); w == width && h == height {
+		return
+	}
+	terminal.vterm.Resize(width, height)
+	if terminal.running {
+		setPTYSize(terminal.pty.Fd(), width, height)
+	}
+}
+
+// Stop kills the program.
+func (terminal *Terminal) Stop() {
+	if terminal.running && terminal.cmd.Process != nil {
+		terminal.cmd.Process.Kill()
+	}
+}
+
+// Terminal returns the Terminal shown in the buffer, or nil if it's a normal buffer.
+func (buffer *Buffer) Terminal() *Terminal {
+	if buffer == nil {
+		return nil
+	}
+	return buffer.terminal
+}
+
+// SetPost sets how work is passed to the main loop from other goroutines, usually App.Post.
+func (editor *Editor) SetPost(post func(f func())) {
+	editor.post = post
+}
+
+// Post runs f on the main loop, it is safe to call from any goroutine.
+// Without a main loop f is run straight away.
+func (editor *Editor) Post(f func()) {
+	if editor.post == nil {
+		f()
+		return
+	}
+	editor.post(f)
+}
+
+// OpenTerminal starts a command in a new terminal buffer, an empty command starts the shell.
+func (editor *Editor) OpenTerminal(command string) (*Buffer, error) {
+	cmd := exec.Command(editor.settings.Shell)
+	name := "!" + editor.settings.Shell
+	if command != "" {
+		cmd = exec.Command(editor.settings.Shell, "-c", command)
+		name = "!" + command
+	}
+	cmd.Env = append(os.Environ(), "TERM=xterm")
+
+	terminal := &Terminal{vterm: NewVTerm(80, 24), cmd: cmd}
+	pty, err := startInPTY(cmd, 80, 24)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start terminal: %s", err)
+	}
+	terminal.pty = pty
+	terminal.running = true
+	terminal.vterm.Respond = terminal.Send
+
+	buffer := NewBuffer()
+	buffer.SetFilename(name)
+	buffer.terminal = terminal
+	added := editor.AddBuffer(&buffer)
+
+	go editor.readTerminal(added, terminal)
+	return added, nil
+}
+
+// readTerminal passes the program's output to the main loop until it exits.
+func (editor *Editor) readTerminal(buffer *Buffer, terminal *Terminal) {
+	data := make([]byte, 4096)
+	for {
+		n, err := terminal.pty.Read(data)
+		if n > 0 {
+			output := append([]byte{}, data[:n]...)
+			editor.Post(func() { terminal.vterm.Write(output) })
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	terminal.cmd.Wait()
+	code := terminal.cmd.ProcessState.ExitCode()
+	editor.Post(func() { editor.terminalExited(buffer, code) })
+}
+
+// terminalExited leaves the output of a finished program in its buffer.
+func (editor *Editor) terminalExited(buffer *Buffer, code int) {
+	terminal := buffer.Terminal()
+	terminal.running = false
+	terminal.exitCode = code
+	terminal.pty.Close()
+
+	if editor.Mode() == TerminalMode && editor.CurrentPane().Buffer() == buffer {
+		editor.SetMode(NormalMode)
+	}
+	terminal.scrolling = true
+	text := fmt.Sprintf("[Process exited %d]", code)
+	if output := strings.TrimRight(terminal.vterm.Text(), "\n"); output != "" {
+		text = output + "\n" + text
+	}
+	buffer.SetDataString(text)
+	buffer.SetModified(false)
+}
+
+// EnterTerminalMode sends keys to the terminal shown in the current Pane.
+func (editor *Editor) EnterTerminalMode() error {
+	terminal := editor.CurrentPane().Buffer().Terminal()
+	if terminal == nil || !terminal.Running() {
+		return errors.New("Not a running terminal")
+	}
+
+	terminal.scrolling = false
+	editor.SetMode(TerminalMode)
+	return nil
+}
+
+// LeaveTerminalMode stops sending keys to the terminal and copies its output into the buffer
+// so it can be scrolled through and yanked in normal mode.
+func (editor *Editor) LeaveTerminalMode() {
+	if editor.Mode() != TerminalMode {
+		return
+	}
+	editor.SetMode(NormalMode)
+
+	pane := editor.CurrentPane()
+	buffer := pane.Buffer()
+	terminal := buffer.Terminal()
+	terminal.scrolling = true
+	buffer.SetDataString(terminal.vterm.Text())
+	buffer.SetModified(false)
+
+	x, y, _ := terminal.vterm.Cursor()
+	pane.Cursor().Move(x, len(terminal.vterm.Scrollback())+y+1)
+}
+
+// HandleTerminalKey sends a key to the terminal, Ctrl-\ Ctrl-N goes back to normal mode.
+func (editor *Editor) HandleTerminalKey(event KeyEvent) {
+	terminal := editor.CurrentPane().Buffer().Terminal()
+	if terminal == nil {
+		editor.SetMode(NormalMode)
+		return
+	}
+
+	backslash := event.Key == KeyRune && event.Rune == '\\' && event.Mod == ModCtrl
+	if terminal.escape {
+		terminal.escape = false
+		if event.Key == KeyRune && event.Rune == 'n' && event.Mod == ModCtrl {
+			editor.LeaveTerminalMode()
+			return
+		}
+		terminal.Send([]byte{0x1c})
+	}
+	if backslash {
+		terminal.escape = true
+		return
+	}
+
+	terminal.Send(encodeKey(event, terminal.vterm.appCursor))
+}
+
+// PasteTerminal sends pasted text to the terminal, marked as a paste if the program asked for that.
+func (editor *Editor) PasteTerminal(text string) {
+	terminal := editor.CurrentPane().Buffer().Terminal()
+	if terminal == nil {
+		return
+	}
+	if terminal.vterm.bracketedPaste {
+		text = pasteStart + text + pasteEnd
+	}
+	terminal.Send([]byte(text))
+}
+
+// terminalKeys are the sequences xterm sends for special keys.
+var terminalKeys = map[Key]string{
+	KeyEscape:    "\x1b",
+	KeyEnter:     "\r",
+	KeyTab:       "\t",
+	KeyBackspace: "\x7f",
+	KeyDelete:    "\x1b[3~",
+	KeyInsert:    "\x1b[2~",
+	KeyPageUp:    "\x1b[5~",
+	KeyPageDown:  "\x1b[6~",
+	KeyF1:        "\x1bOP",
+	KeyF2:        "\x1bOQ",
+	KeyF3:        "\x1bOR",
+	KeyF4:        "\x1bOS",
+	KeyF5:        "\x1b[15~",
+	KeyF6:        "\x1b[17~",
+	KeyF7:        "\x1b[18~",
+	KeyF8:        "\x1b[19~",
+	KeyF9:        "\x1b[20~",
+	KeyF10:       "\x1b[21~",
+	KeyF11:       "\x1b[23~",
+	KeyF12:       "\x1b[24~",
+}
+
+// cursorKeys are the final characters of the cursor key sequences, which change in application cursor mode.
+var cursorKeys = map[Key]byte{
+	KeyUp: 'A', KeyDown: 'B', KeyRight: 'C', KeyLeft: 'D', KeyHome: 'H', KeyEnd: 'F',
+}
+
+// encodeKey returns what a terminal sends to a program for the key.
+func encodeKey(event KeyEvent, appCursor bool) []byte {
+	var out []byte
+	if event.Mod&ModAlt != 0 {
+		out = append(out, 0x1b)
+	}
+
+	switch {
+	case event.Key == KeyRune && event.Mod&ModCtrl != 0:
+		return append(out, []byte(ctrl(event.Rune))...)
+	case event.Key == KeyRune:
+		return utf8.AppendRune(out, event.Rune)
+	case event.Key == KeyTab && event.Mod&ModShift != 0:
+		return append(out, "\x1b[Z"...)
+	}
+
+	if final, ok := cursorKeys[event.Key]; ok {
+		if appCursor {
+			return append(out, 0x1b, 'O', final)
+		}
+		return append(out, 0x1b, '[', final)
+	}
+	return append(out, terminalKeys[event.Key]...)
+}
+
+// RenderTerminal draws the emulated screen of a terminal in the area.
+func (grid *RuneGrid) RenderTerminal(x1, y1, x2, y2 int, terminal *Terminal) {
+	for y, row := range terminal.vterm.Cells() {
+		if y1+y > y2 {
+			break
+		}
+		for x, r := range row {
+			if x1+x > x2 {
+				break
+			}
+			grid.SetCell(x1+x, y1+y, r)
+		}
+	}
+}
+
+func terminalCommand(editor *Editor, command Command) error {
+	buffer, err := editor.OpenTerminal(command.Args)
+	if err != nil {
+		return err
+	}
+
+	editor.SplitPane(false)
+	editor.SwitchToBuffer(buffer)
+	return editor.EnterTerminalMode()
+}
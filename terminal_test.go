@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncodeKey(t *testing.T) {
+	Convey("encodeKey", t, func() {
+		So(string(encodeKey(KeyEvent{Rune: 'é'}, false)), ShouldEqual, "é")
+		So(string(encodeKey(KeyEvent{Rune: 'c', Mod: ModCtrl}, false)), ShouldEqual, "\x03")
+		So(string(encodeKey(KeyEvent{Rune: 'x', Mod: ModAlt}, false)), ShouldEqual, "\x1bx")
+		So(string(encodeKey(KeyEvent{Key: KeyEnter}, false)), ShouldEqual, "\r")
+		So(string(encodeKey(KeyEvent{Key: KeyTab, Mod: ModShift}, false)), ShouldEqual, "\x1b[Z")
+		So(string(encodeKey(KeyEvent{Key: KeyUp}, false)), ShouldEqual, "\x1b[A")
+		So(string(encodeKey(KeyEvent{Key: KeyUp}, true)), ShouldEqual, "\x1bOA")
+		So(string(encodeKey(KeyEvent{Key: KeyF5}, false)), ShouldEqual, "\x1b[15~")
+	})
+}
+
+func TestTerminalMode(t *testing.T) {
+	Convey("Editor showing a terminal", t, func() {
+		reader, writer, err := os.Pipe()
+		So(err, ShouldBeNil)
+		defer reader.Close()
+		defer writer.Close()
+
+		terminal := &Terminal{vterm: NewVTerm(10, 3), pty: writer, running: true}
+		buffer := NewBuffer()
+		buffer.terminal = terminal
+
+		editor := NewEditor(GetTestFs())
+		editor.Settings().StatusLine = ""
+		editor.Settings().Borders = false
+		editor.SwitchToBuffer(editor.AddBuffer(&buffer))
+
+		typed := func() string {
+			writer.Close()
+			data := make([]byte, 100)
+			n, _ := reader.Read(data)
+			return string(data[:n])
+		}
+
+		Convey("i enters terminal mode", func() {
+			editor.HandleNormalKey('i')
+			So(editor.Mode(), ShouldEqual, TerminalMode)
+
+			Convey("keys are sent to the program", func() {
+				editor.HandleTerminalKey(KeyEvent{Rune: 'l'})
+				editor.HandleTerminalKey(KeyEvent{Rune: 's'})
+				editor.HandleTerminalKey(KeyEvent{Key: KeyEnter})
+				editor.HandleTerminalKey(KeyEvent{Rune: '\\', Mod: ModCtrl})
+				editor.HandleTerminalKey(KeyEvent{Rune: 'x'})
+				So(typed(), ShouldEqual, "ls\r\x1cx")
+				So(editor.Mode(), ShouldEqual, TerminalMode)
+			})
+
+			Convey("the screen is drawn with the cursor", func() {
+				terminal.vterm.Write([]byte("$ ls\r\nfile\r\n$ "))
+				grid := NewRuneGrid(10, 4)
+				grid.RenderEditor(&editor)
+				So(string(grid.Cells()[1]), ShouldEqual, "file      ")
+				So(string(grid.Cells()[3]), ShouldEqual, "-- TERMINA")
+
+				x, y, ok := editor.CurrentPane().CursorScreenPosition(editor.Settings())
+				So(ok, ShouldBeTrue)
+				So([]int{x, y}, ShouldResemble, []int{2, 2})
+			})
+
+			Convey("Ctrl-\\ Ctrl-N goes to normal mode with the output in the buffer", func() {
+				terminal.vterm.Write([]byte("one\r\ntwo\r\nthree\r\n$ "))
+				editor.HandleTerminalKey(KeyEvent{Rune: '\\', Mod: ModCtrl})
+				editor.HandleTerminalKey(KeyEvent{Rune: 'n', Mod: ModCtrl})
+
+				So(editor.Mode(), ShouldEqual, NormalMode)
+				So(terminal.Live(), ShouldBeFalse)
+				So(string(buffer.data), ShouldEqual, "one\ntwo\nthree\n$")
+				x, line := editor.CurrentPane().Cursor().Position()
+				So([]int{x, line}, ShouldResemble, []int{2, 4})
+
+				Convey("and the scrollback can be yanked", func() {
+					editor.CurrentPane().Cursor().Move(0, 1)
+					editor.HandleNormalKey('v')
+					editor.CurrentPane().Cursor().Move(1, 2)
+					editor.HandleNormalKey('y')
+					So(editor.Register(), ShouldEqual, "one\ntw")
+					So(editor.Mode(), ShouldEqual, NormalMode)
+				})
+
+				Convey("i goes back to the live screen", func() {
+					editor.HandleNormalKey('i')
+					So(terminal.Live(), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("deleting the buffer needs ! while the job runs", func() {
+			So(editor.DeleteBuffer(&buffer, false), ShouldNotBeNil)
+		})
+
+		Convey("when the program exits its output stays in the buffer", func() {
+			terminal.vterm.Write([]byte("done"))
+			terminal.cmd = nil
+			terminal.running = false
+			editor.terminalExited(editor.CurrentPane().Buffer(), 2)
+			So(string(editor.CurrentPane().Buffer().data), ShouldEqual, "done\n[Process exited 2]")
+			So(editor.EnterTerminalMode(), ShouldNotBeNil)
+		})
+	})
+}
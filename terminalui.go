@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"time"
 
 	"github.com/dcbishop/jkl/service"
@@ -21,17 +22,154 @@ type ConsoleDriver interface {
 	AfterDraw()
 }
 
+// RGB is a true colour. Drivers that can't show it use the nearest colour they have.
+type RGB struct {
+	R uint8
+	G uint8
+	B uint8
+}
+
+// basicColors are the usual values of the 8 standard terminal colours.
+var basicColors = []RGB{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+}
+
+// NearestBasic returns the index of the closest of the 8 standard terminal colours.
+func (c RGB) NearestBasic() int {
+	nearest, best := 0, -1
+	for i, basic := range basicColors {
+		dr, dg, db := int(c.R)-int(basic.R), int(c.G)-int(basic.G), int(c.B)-int(basic.B)
+		if distance := dr*dr + dg*dg + db*db; best == -1 || distance < best {
+			nearest, best = i, distance
+		}
+	}
+	return nearest
+}
+
+// Nearest256 returns the index of the closest colour in the xterm 256 colour palette's 6x6x6 cube or grey ramp.
+func (c RGB) Nearest256() int {
+	if c.R == c.G && c.G == c.B {
+		if c.R < 8 {
+			return 16
+		}
+		if c.R > 238 {
+			return 231
+		}
+		return 232 + (int(c.R)-8)/10
+	}
+
+	level := func(v uint8) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (int(v) - 35) / 40
+	}
+	return 16 + 36*level(c.R) + 6*level(c.G) + level(c.B)
+}
+
+// Repainter is implemented by ConsoleDrivers that can lose what is on the screen, ie after being suspended.
+// NeedsRepaint returns true once after that happens.
+type Repainter interface {
+	NeedsRepaint() bool
+}
+
+// MouseCapturer is implemented by ConsoleDrivers that can turn mouse events on and off.
+type MouseCapturer interface {
+	SetMouse(enabled bool)
+}
+
+// ColorPair is the foreground and background Color of a cell.
+type ColorPair struct {
+	Fg Color
+	Bg Color
+}
+
+// Theme maps the Style of a cell to the colours it is drawn with.
+type Theme map[Style]ColorPair
+
+// DefaultTheme constructs the default colour Theme.
+func DefaultTheme() Theme {
+	return Theme{
+		StyleNormal:       {termbox.ColorWhite, termbox.ColorRed},
+		StyleStatusLine:   {termbox.ColorBlack, termbox.ColorWhite},
+		StyleStatusLineNC: {termbox.ColorWhite, termbox.ColorBlack},
+		StyleMessage:      {termbox.ColorWhite, termbox.ColorRed},
+		StyleWarning:      {termbox.ColorYellow, termbox.ColorRed},
+		StyleError:        {termbox.ColorWhite | termbox.AttrBold, termbox.ColorBlack},
+
+		StyleLineNumber:       {termbox.ColorYellow, termbox.ColorRed},
+		StyleCursorLineNumber: {termbox.ColorYellow | termbox.AttrBold, termbox.ColorRed},
+		StyleSignColumn:       {termbox.ColorWhite, termbox.ColorRed},
+		StyleFoldColumn:       {termbox.ColorCyan, termbox.ColorRed},
+		StyleSignError:        {termbox.ColorWhite | termbox.AttrBold, termbox.ColorRed},
+		StyleSignWarning:      {termbox.ColorYellow | termbox.AttrBold, termbox.ColorRed},
+		StyleSignInfo:         {termbox.ColorCyan, termbox.ColorRed},
+		StyleSignAdd:          {termbox.ColorGreen, termbox.ColorRed},
+		StyleSignChange:       {termbox.ColorBlue, termbox.ColorRed},
+		StyleSignDelete:       {termbox.ColorBlack, termbox.ColorRed},
+		StyleFolded:           {termbox.ColorCyan, termbox.ColorBlack},
+		StyleVisual:           {termbox.ColorWhite | termbox.AttrReverse, termbox.ColorRed},
+
+		StylePeerCursor1:    {termbox.ColorBlack, termbox.ColorGreen},
+		StylePeerCursor2:    {termbox.ColorBlack, termbox.ColorYellow},
+		StylePeerCursor3:    {termbox.ColorBlack, termbox.ColorBlue},
+		StylePeerCursor4:    {termbox.ColorBlack, termbox.ColorMagenta},
+		StylePeerCursor5:    {termbox.ColorBlack, termbox.ColorCyan},
+		StylePeerCursor6:    {termbox.ColorBlack, termbox.ColorWhite},
+		StylePeerSelection1: {termbox.ColorGreen, termbox.ColorBlack},
+		StylePeerSelection2: {termbox.ColorYellow, termbox.ColorBlack},
+		StylePeerSelection3: {termbox.ColorBlue, termbox.ColorBlack},
+		StylePeerSelection4: {termbox.ColorMagenta, termbox.ColorBlack},
+		StylePeerSelection5: {termbox.ColorCyan, termbox.ColorBlack},
+		StylePeerSelection6: {termbox.ColorWhite, termbox.ColorBlack},
+
+		StyleFloat:              {termbox.ColorBlack, termbox.ColorWhite},
+		StyleShadow:             {termbox.ColorWhite, termbox.ColorBlack},
+		StyleCompletion:         {termbox.ColorBlack, termbox.ColorWhite},
+		StyleCompletionSelected: {termbox.ColorWhite, termbox.ColorBlue},
+		StylePicker:             {termbox.ColorBlack, termbox.ColorWhite},
+		StylePickerSelected:     {termbox.ColorWhite, termbox.ColorBlue},
+		StylePickerMatch:        {termbox.ColorRed, termbox.ColorWhite},
+
+		StyleDiffAdd:    {termbox.ColorBlack, termbox.ColorGreen},
+		StyleDiffChange: {termbox.ColorBlack, termbox.ColorYellow},
+		StyleDiffText:   {termbox.ColorWhite, termbox.ColorRed},
+		StyleDiffDelete: {termbox.ColorRed, termbox.ColorDefault},
+
+		StyleConflictMarker: {termbox.ColorWhite | termbox.AttrBold, termbox.ColorMagenta},
+		StyleConflictOurs:   {termbox.ColorBlack, termbox.ColorGreen},
+		StyleConflictBase:   {termbox.ColorBlack, termbox.ColorWhite},
+		StyleConflictTheirs: {termbox.ColorBlack, termbox.ColorCyan},
+	}
+}
+
 // TerminalUI a text based user interface renderer.
+// It keeps the last drawn frame and only sends the cells that changed to the ConsoleDriver.
+// mutex guards the console and the drawing state, which Run's goroutine sets up and others draw with.
 type TerminalUI struct {
 	quit    chan bool
 	state   service.State
+	mutex   *sync.Mutex
 	Console ConsoleDriver
+	Theme   Theme
+
+	front     RuneGrid
+	back      RuneGrid
+	repaint   bool
+	lastTheme Theme
+	mouse     *bool
 }
 
 // NewTerminalUI constructs a new TerminalUI.
 func NewTerminalUI(driver ConsoleDriver) TerminalUI {
 	tui := TerminalUI{
 		Console: driver,
+		Theme:   DefaultTheme(),
+		mutex:   &sync.Mutex{},
 	}
 	tui.initializeQuitChannel()
 	return tui
@@ -39,13 +177,15 @@ func NewTerminalUI(driver ConsoleDriver) TerminalUI {
 
 // Run enters the main UI loop untill Stop() is called.
 func (tui *TerminalUI) Run() {
+	tui.mutex.Lock()
 	if tui.state.SetRunning() != nil {
+		tui.mutex.Unlock()
 		panic("UI already running.")
-		return
 	}
 	defer tui.state.SetStopped()
 
 	tui.initialize()
+	tui.mutex.Unlock()
 	tui.waitForQuit()
 	tui.cleanUp()
 }
@@ -71,48 +211,136 @@ func (tui *TerminalUI) Events() <-chan Event {
 
 // Redraw updates the display
 func (tui *TerminalUI) Redraw(editor *Editor) {
+	tui.mutex.Lock()
+	defer tui.mutex.Unlock()
 	if !tui.state.Running() {
 		return
 	}
 	defer tui.Console.AfterDraw()
 
-	// [TODO]: Cache runegrid and change on resize only - 2014-09-27 10:10pm
+	tui.updateMouse(editor.Settings().Mouse)
+	if repainter, ok := tui.Console.(Repainter); ok && repainter.NeedsRepaint() {
+		tui.repaint = true
+	}
+
 	width, height := tui.Console.Size()
-	grid := NewRuneGrid(width, height)
-	grid.RenderEditor(editor)
+	if w, h := tui.back.Size(); w != width || h != height {
+		tui.front = NewRuneGrid(width, height)
+		tui.back = NewRuneGrid(width, height)
+		tui.repaint = true
+	} else {
+		tui.back.Clear()
+	}
+
+	tui.back.RenderEditor(editor)
+	tui.renderGrid()
+
+	if x, y, ok := cursorPosition(editor, height); ok {
+		tui.Console.SetCursor(x, y)
+	}
+}
+
+// cursorPosition returns where the cursor is shown on a screen of the given height.
+func cursorPosition(editor *Editor, height int) (x, y int, ok bool) {
+	if x, y, ok := editor.FloatCursor(); ok {
+		return x, y, true
+	}
+	if editor.Mode() == CommandMode {
+		return len([]rune(editor.CommandLine().Text())) + 1, height - 1, true
+	}
+	return editor.CurrentPane().CursorScreenPosition(editor.Settings())
+}
+
+// updateMouse tells the console whether to report mouse events when the setting changes.
+func (tui *TerminalUI) updateMouse(enabled bool) {
+	capturer, ok := tui.Console.(MouseCapturer)
+	if !ok || tui.mouse != nil && *tui.mouse == enabled {
+		return
+	}
+	capturer.SetMouse(enabled)
+	tui.mouse = &enabled
+}
 
-	tui.renderGrid(&grid)
+// Suspend closes the console so another program can use the terminal until Resume is called.
+func (tui *TerminalUI) Suspend() {
+	tui.mutex.Lock()
+	defer tui.mutex.Unlock()
+	if !tui.Running() {
+		return
+	}
+	tui.Console.Close()
+}
 
-	if editor.CurrentPane().Cursor() == nil {
+// Resume initializes the console again after Suspend and repaints everything on the next Redraw.
+func (tui *TerminalUI) Resume() {
+	tui.mutex.Lock()
+	defer tui.mutex.Unlock()
+	if !tui.Running() {
 		return
 	}
+	tui.Console.Init()
+	tui.mouse = nil
+	tui.repaint = true
+}
 
-	xPos, linePos := editor.CurrentPane().Cursor().Position()
-	linePos = linePos - editor.CurrentPane().TopLine() + 1
+// Invalidate forces the next Redraw to send every cell, ie after the terminal was cleared by something else.
+func (tui *TerminalUI) Invalidate() {
+	tui.mutex.Lock()
+	tui.repaint = true
+	tui.mutex.Unlock()
+}
+
+// renderGrid sends the cells of the back grid that changed since the last frame to the console,
+// then swaps the grids so the back grid becomes the front.
+func (tui *TerminalUI) renderGrid() {
+	previous := &tui.front
+	if tui.repaint || !sameTheme(tui.Theme, tui.lastTheme) {
+		previous = nil
+	}
 
-	if editor.Settings().Borders && editor.Settings().OuterBorder {
-		xPos++
-		linePos++
+	for _, span := range tui.back.Diff(previous) {
+		for i, r := range span.Cells {
+			colors := tui.Theme[span.Styles[i]]
+			tui.Console.SetCell(span.X+i, span.Y, r, colors.Fg, colors.Bg)
+		}
 	}
 
-	tui.Console.SetCursor(xPos, linePos-1)
+	tui.front, tui.back = tui.back, tui.front
+	tui.repaint = false
+	tui.lastTheme = copyTheme(tui.Theme)
 }
 
-func (tui *TerminalUI) renderGrid(grid *RuneGrid) {
-	for y, l := range grid.Cells() {
-		for x, r := range l {
-			tui.Console.SetCell(x, y, r, termbox.ColorWhite, termbox.ColorRed)
+func sameTheme(a, b Theme) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for style, colors := range a {
+		if other, ok := b[style]; !ok || other != colors {
+			return false
 		}
 	}
+	return true
+}
+
+func copyTheme(theme Theme) Theme {
+	copied := Theme{}
+	for style, colors := range theme {
+		copied[style] = colors
+	}
+	return copied
 }
 
 func (tui *TerminalUI) initialize() {
 	tui.initializeConsoleDriver()
 	tui.Console.Init()
+	tui.mouse = nil
+	tui.repaint = true
 }
 
 func (tui *TerminalUI) cleanUp() {
+	tui.mutex.Lock()
 	tui.Console.Close()
+	tui.mutex.Unlock()
 }
 
 func (tui *TerminalUI) initializeConsoleDriver() {
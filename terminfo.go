@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Terminfo holds the capabilities of a terminal used by the ANSIDriver.
+// Strings are keyed by their short terminfo name, ie "smcup".
+type Terminfo struct {
+	Names   []string
+	Colors  int
+	Strings map[string]string
+}
+
+// terminfoStrings maps the index of a string capability in a compiled terminfo file to its name.
+var terminfoStrings = map[int]string{
+	5:   "clear",
+	10:  "cup",
+	13:  "civis",
+	16:  "cnorm",
+	27:  "bold",
+	28:  "smcup",
+	34:  "rev",
+	36:  "smul",
+	39:  "sgr0",
+	40:  "rmcup",
+	55:  "kbs",
+	59:  "kdch1",
+	61:  "kcud1",
+	66:  "kf1",
+	67:  "kf10",
+	68:  "kf2",
+	69:  "kf3",
+	70:  "kf4",
+	71:  "kf5",
+	72:  "kf6",
+	73:  "kf7",
+	74:  "kf8",
+	75:  "kf9",
+	76:  "khome",
+	77:  "kich1",
+	79:  "kcub1",
+	81:  "knp",
+	82:  "kpp",
+	83:  "kcuf1",
+	87:  "kcuu1",
+	88:  "rmkx",
+	89:  "smkx",
+	164: "kend",
+	216: "kf11",
+	217: "kf12",
+}
+
+// terminfoColors is the index of the max_colors number capability.
+const terminfoColors = 13
+
+const (
+	terminfoMagic   = 0432
+	terminfoMagic32 = 01036
+)
+
+// XtermTerminfo returns the capabilities of xterm, used when the terminal has no terminfo entry.
+func XtermTerminfo() *Terminfo {
+	return &Terminfo{
+		Names:  []string{"xterm"},
+		Colors: 8,
+		Strings: map[string]string{
+			"clear": "\x1b[H\x1b[2J",
+			"cup":   "\x1b[%i%p1%d;%p2%dH",
+			"civis": "\x1b[?25l",
+			"cnorm": "\x1b[?12l\x1b[?25h",
+			"bold":  "\x1b[1m",
+			"smcup": "\x1b[?1049h\x1b[22;0;0t",
+			"rev":   "\x1b[7m",
+			"smul":  "\x1b[4m",
+			"sgr0":  "\x1b(B\x1b[m",
+			"rmcup": "\x1b[?1049l\x1b[23;0;0t",
+			"kbs":   "\x7f",
+			"kdch1": "\x1b[3~",
+			"kcud1": "\x1bOB",
+			"kf1":   "\x1bOP",
+			"kf2":   "\x1bOQ",
+			"kf3":   "\x1bOR",
+			"kf4":   "\x1bOS",
+			"kf5":   "\x1b[15~",
+			"kf6":   "\x1b[17~",
+			"kf7":   "\x1b[18~",
+			"kf8":   "\x1b[19~",
+			"kf9":   "\x1b[20~",
+			"kf10":  "\x1b[21~",
+			"kf11":  "\x1b[23~",
+			"kf12":  "\x1b[24~",
+			"khome": "\x1bOH",
+			"kend":  "\x1bOF",
+			"kich1": "\x1b[2~",
+			"kcub1": "\x1bOD",
+			"knp":   "\x1b[6~",
+			"kpp":   "\x1b[5~",
+			"kcuf1": "\x1bOC",
+			"kcuu1": "\x1bOA",
+			"rmkx":  "\x1b[?1l\x1b>",
+			"smkx":  "\x1b[?1h\x1b=",
+		},
+	}
+}
+
+// LoadTerminfo finds and reads the compiled terminfo entry for the terminal,
+// searching $TERMINFO, ~/.terminfo, $TERMINFO_DIRS and the usual system directories.
+func LoadTerminfo(term string) (*Terminfo, error) {
+	if term == "" {
+		return nil, errors.New("No terminal type")
+	}
+
+	dirs := []string{os.Getenv("TERMINFO")}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	dirs = append(dirs, strings.Split(os.Getenv("TERMINFO_DIRS"), ":")...)
+	dirs = append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo")
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		for _, sub := range []string{term[:1], fmt.Sprintf("%x", term[0])} {
+			data, err := os.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return ParseTerminfo(data)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("No terminfo entry for %s", term)
+}
+
+// ParseTerminfo reads a compiled terminfo entry, extended capabilities are ignored.
+func ParseTerminfo(data []byte) (*Terminfo, error) {
+	if len(data) < 12 {
+		return nil, errors.New("Terminfo entry is too short")
+	}
+
+	header := make([]int, 6)
+	for i := range header {
+		header[i] = int(int16(binary.LittleEndian.Uint16(data[i*2:])))
+	}
+
+	numberSize := 2
+	switch header[0] {
+	case terminfoMagic:
+	case terminfoMagic32:
+		numberSize = 4
+	default:
+		return nil, errors.New("Not a compiled terminfo entry")
+	}
+
+	for _, count := range header[1:] {
+		if count < 0 {
+			return nil, errors.New("Terminfo entry has a negative size")
+		}
+	}
+	namesSize, boolCount, numberCount, stringCount, tableSize := header[1], header[2], header[3], header[4], header[5]
+	pos := 12
+
+	if pos+namesSize > len(data) {
+		return nil, errors.New("Terminfo entry is truncated")
+	}
+	info := &Terminfo{
+		Names:   strings.Split(strings.TrimRight(string(data[pos:pos+namesSize]), "\x00"), "|"),
+		Strings: map[string]string{},
+	}
+	pos += namesSize + boolCount
+	if pos%2 == 1 {
+		pos++
+	}
+
+	numbers := pos
+	pos += numberCount * numberSize
+	offsets := pos
+	table := offsets + stringCount*2
+	// The sections follow each other, so the entry is long enough for all of them if it is for the last.
+	if table+tableSize > len(data) {
+		return nil, errors.New("Terminfo entry is truncated")
+	}
+
+	if numberCount > terminfoColors {
+		at := numbers + terminfoColors*numberSize
+		if numberSize == 2 {
+			info.Colors = int(int16(binary.LittleEndian.Uint16(data[at:])))
+		} else {
+			info.Colors = int(int32(binary.LittleEndian.Uint32(data[at:])))
+		}
+	}
+
+	for index, name := range terminfoStrings {
+		if index >= stringCount {
+			continue
+		}
+		offset := int(int16(binary.LittleEndian.Uint16(data[offsets+index*2:])))
+		if offset < 0 || offset >= tableSize {
+			continue
+		}
+		value := data[table+offset : table+tableSize]
+		if end := strings.IndexByte(string(value), 0); end != -1 {
+			value = value[:end]
+		}
+		info.Strings[name] = string(value)
+	}
+
+	return info, nil
+}
+
+// Parameterize fills in the parameters of a capability like "cup".
+// Only %i, %p1-%p9, %d and %% are supported, ok is false if anything else is used.
+func Parameterize(capability string, params ...int) (string, bool) {
+	var out strings.Builder
+	stack := []int{}
+	increment := false
+
+	for i := 0; i < len(capability); i++ {
+		c := capability[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(capability) {
+			return "", false
+		}
+
+		switch capability[i] {
+		case '%':
+			out.WriteByte('%')
+		case 'i':
+			increment = true
+		case 'p':
+			i++
+			if i >= len(capability) || capability[i] < '1' || capability[i] > '9' {
+				return "", false
+			}
+			n := int(capability[i] - '1')
+			value := 0
+			if n < len(params) {
+				value = params[n]
+			}
+			if increment && n < 2 {
+				value++
+			}
+			stack = append(stack, value)
+		case 'd':
+			if len(stack) == 0 {
+				return "", false
+			}
+			out.WriteString(strconv.Itoa(stack[len(stack)-1]))
+			stack = stack[:len(stack)-1]
+		default:
+			return "", false
+		}
+	}
+
+	return out.String(), true
+}
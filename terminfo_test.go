@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// compileTerminfo builds a minimal compiled terminfo entry.
+func compileTerminfo(names string, colors int, strings map[int]string) []byte {
+	stringCount := 0
+	for index := range strings {
+		if index+1 > stringCount {
+			stringCount = index + 1
+		}
+	}
+
+	table := []byte{}
+	offsets := make([]int, stringCount)
+	for i := range offsets {
+		offsets[i] = -1
+		if value, ok := strings[i]; ok {
+			offsets[i] = len(table)
+			table = append(append(table, value...), 0)
+		}
+	}
+
+	data := []byte{}
+	short := func(value int) {
+		data = binary.LittleEndian.AppendUint16(data, uint16(int16(value)))
+	}
+
+	namesData := append([]byte(names), 0)
+	for _, value := range []int{terminfoMagic, len(namesData), 0, terminfoColors + 1, stringCount, len(table)} {
+		short(value)
+	}
+	data = append(data, namesData...)
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	for i := 0; i <= terminfoColors; i++ {
+		if i == terminfoColors {
+			short(colors)
+		} else {
+			short(-1)
+		}
+	}
+	for _, offset := range offsets {
+		short(offset)
+	}
+	return append(data, table...)
+}
+
+func TestTerminfo(t *testing.T) {
+	Convey("parsing a compiled entry", t, func() {
+		data := compileTerminfo("test|a test terminal", 256, map[int]string{
+			10: "\x1b[%i%p1%d;%p2%dH",
+			28: "\x1b[?1049h",
+			87: "\x1bOA",
+		})
+
+		info, err := ParseTerminfo(data)
+		So(err, ShouldBeNil)
+		So(info.Names, ShouldResemble, []string{"test", "a test terminal"})
+		So(info.Colors, ShouldEqual, 256)
+		So(info.Strings["smcup"], ShouldEqual, "\x1b[?1049h")
+		So(info.Strings["kcuu1"], ShouldEqual, "\x1bOA")
+		So(info.Strings, ShouldNotContainKey, "rmcup")
+	})
+
+	Convey("invalid entries are rejected", t, func() {
+		_, err := ParseTerminfo([]byte("not terminfo at all"))
+		So(err, ShouldNotBeNil)
+
+		data := compileTerminfo("test", 8, map[int]string{10: "abc"})
+		_, err = ParseTerminfo(data[:len(data)-2])
+		So(err, ShouldNotBeNil)
+
+		Convey("wherever they're cut off", func() {
+			for size := 0; size < len(data); size++ {
+				_, err := ParseTerminfo(data[:size])
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("when a size in the header is negative", func() {
+			for field := 1; field < 6; field++ {
+				bad := append([]byte{}, data...)
+				binary.LittleEndian.PutUint16(bad[field*2:], uint16(0x8000))
+				_, err := ParseTerminfo(bad)
+				So(err.Error(), ShouldEqual, "Terminfo entry has a negative size")
+			}
+		})
+	})
+
+	Convey("loading the entry of an unknown terminal fails", t, func() {
+		_, err := LoadTerminfo("no-such-terminal")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("parameterizing cursor movement", t, func() {
+		move, ok := Parameterize("\x1b[%i%p1%d;%p2%dH", 4, 9)
+		So(ok, ShouldBeTrue)
+		So(move, ShouldEqual, "\x1b[5;10H")
+
+		_, ok = Parameterize("%p1%{8}%<%t3%e%d", 4)
+		So(ok, ShouldBeFalse)
+	})
+}
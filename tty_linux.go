@@ -0,0 +1,192 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	resizeSignal   os.Signal = syscall.SIGWINCH
+	continueSignal os.Signal = syscall.SIGCONT
+)
+
+var errWoken = errors.New("Woken")
+
+// ttyState is the saved state of a terminal to restore when leaving raw mode.
+type ttyState struct {
+	termios syscall.Termios
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal into raw mode and returns its previous state.
+func makeRaw(fd uintptr) (*ttyState, error) {
+	state := &ttyState{}
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&state.termios)); err != nil {
+		return nil, err
+	}
+
+	raw := state.termios
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restoreTTY puts the terminal back into the state saved by makeRaw.
+func restoreTTY(fd uintptr, state *ttyState) error {
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&state.termios))
+}
+
+// ttySize returns the number of columns and rows of the terminal.
+func ttySize(fd uintptr) (width, height int, err error) {
+	var size struct {
+		rows, cols, xpixel, ypixel uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+// openPTY opens a pseudo-terminal pair.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := 0
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var number uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(number)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// setPTYSize tells the program in a pseudo-terminal how big it is.
+func setPTYSize(fd uintptr, width, height int) error {
+	size := struct{ rows, cols, xpixel, ypixel uint16 }{uint16(height), uint16(width), 0, 0}
+	return ioctl(fd, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// startInPTY starts the command in a new session with a pseudo-terminal as its controlling terminal.
+// It returns the master side, reading it gives the program's output and writing to it types.
+func startInPTY(cmd *exec.Cmd, width, height int) (*os.File, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+
+	setPTYSize(master.Fd(), width, height)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// detachProcess makes the command start in a new session without a controlling terminal,
+// so it keeps running after the terminal it was started from is closed.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// execProcess replaces the process with another program.
+func execProcess(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
+
+// suspendProcess stops the process as if Ctrl-Z was typed in a normal terminal.
+// It returns once the process is continued.
+func suspendProcess() {
+	syscall.Kill(0, syscall.SIGTSTP)
+}
+
+// pollFd is the struct pollfd of poll(2).
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// Events of poll(2).
+const (
+	pollIn   = 0x1
+	pollNval = 0x20
+)
+
+// waitReadable blocks until fd has input, the timeout passes or wake becomes readable.
+// ready is false if the timeout passed, a negative timeout waits forever. Waking returns an error.
+// It uses ppoll rather than select, which can't wait for descriptors past FD_SETSIZE.
+func waitReadable(fd, wake uintptr, timeout time.Duration) (ready bool, err error) {
+	if fd == ^uintptr(0) || wake == ^uintptr(0) {
+		return false, syscall.EBADF
+	}
+
+	fds := [2]pollFd{{fd: int32(fd), events: pollIn}, {fd: int32(wake), events: pollIn}}
+	var ts *syscall.Timespec
+	if timeout >= 0 {
+		// The kernel leaves what's left of the timeout here, so waiting again after EINTR doesn't start over.
+		t := syscall.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)),
+			uintptr(unsafe.Pointer(ts)), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return false, errno
+		}
+		if n == 0 {
+			return false, nil
+		}
+		if fds[0].revents&pollNval != 0 || fds[1].revents&pollNval != 0 {
+			return false, syscall.EBADF
+		}
+		if fds[1].revents != 0 {
+			return false, errWoken
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,178 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readUntil reads from the file until the text has been seen.
+func readUntil(file *os.File, text string) string {
+	output := make(chan string)
+	go func() {
+		seen := ""
+		buffer := make([]byte, 1024)
+		for !strings.Contains(seen, text) {
+			n, err := file.Read(buffer)
+			if err != nil {
+				break
+			}
+			seen += string(buffer[:n])
+		}
+		output <- seen
+	}()
+
+	select {
+	case seen := <-output:
+		return seen
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
+func TestANSIDriverPTY(t *testing.T) {
+	master, slave, err := openPTY()
+	if err != nil {
+		t.Skip("No pseudo-terminals available:", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	Convey("given an ANSIDriver on a pseudo-terminal", t, func() {
+		So(setPTYSize(slave.Fd(), 100, 30), ShouldBeNil)
+
+		driver := NewANSIDriver(slave, slave)
+		driver.info = XtermTerminfo()
+		driver.parser = newInputParser(driver.info)
+		driver.Init()
+		Reset(driver.Close)
+
+		So(readUntil(master, "\x1b[H\x1b[2J"), ShouldContainSubstring, "\x1b[?2004h")
+
+		Convey("the terminal is in raw mode and sized", func() {
+			var termios syscall.Termios
+			So(ioctl(slave.Fd(), syscall.TCGETS, unsafe.Pointer(&termios)), ShouldBeNil)
+			So(termios.Lflag&(syscall.ECHO|syscall.ICANON), ShouldEqual, 0)
+
+			width, height := driver.Size()
+			So(width, ShouldEqual, 100)
+			So(height, ShouldEqual, 30)
+		})
+
+		Convey("typed keys become events", func() {
+			master.Write([]byte("j\x1b[A"))
+			So((<-driver.Events()).Data, ShouldResemble, KeyEvent{Rune: 'j'})
+			So((<-driver.Events()).Data, ShouldResemble, KeyEvent{Key: KeyUp})
+
+			Convey("a lone escape is the Escape key after a short wait", func() {
+				master.Write([]byte("\x1b"))
+				So((<-driver.Events()).Data, ShouldResemble, KeyEvent{Key: KeyEscape})
+			})
+		})
+
+		Convey("drawing reaches the terminal", func() {
+			driver.SetCell(0, 0, 'x', nil, nil)
+			driver.AfterDraw()
+			So(readUntil(master, syncEnd), ShouldContainSubstring, "x")
+		})
+
+		Convey("Close restores the terminal", func() {
+			driver.Close()
+			So(readUntil(master, "\x1b[?1049l"), ShouldContainSubstring, "\x1b[?2004l")
+
+			var termios syscall.Termios
+			So(ioctl(slave.Fd(), syscall.TCGETS, unsafe.Pointer(&termios)), ShouldBeNil)
+			So(termios.Lflag&syscall.ICANON, ShouldNotEqual, 0)
+		})
+	})
+}
+
+func TestWaitReadable(t *testing.T) {
+	Convey("Waiting for descriptors past FD_SETSIZE", t, func() {
+		in, typed, err := os.Pipe()
+		So(err, ShouldBeNil)
+		defer in.Close()
+		defer typed.Close()
+		wake, woken, err := os.Pipe()
+		So(err, ShouldBeNil)
+		defer wake.Close()
+		defer woken.Close()
+
+		high := uintptr(2000)
+		if err := syscall.Dup2(int(in.Fd()), int(high)); err != nil {
+			t.Skip("Can't open that many files:", err)
+		}
+		defer syscall.Close(int(high))
+		So(syscall.Dup2(int(wake.Fd()), int(high+1)), ShouldBeNil)
+		defer syscall.Close(int(high + 1))
+
+		ready, err := waitReadable(high, high+1, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		So(ready, ShouldBeFalse)
+
+		typed.Write([]byte("x"))
+		ready, err = waitReadable(high, high+1, time.Second)
+		So(err, ShouldBeNil)
+		So(ready, ShouldBeTrue)
+
+		woken.Write([]byte("x"))
+		_, err = waitReadable(high, high+1, -1)
+		So(err, ShouldEqual, errWoken)
+	})
+}
+
+func TestTerminalPTY(t *testing.T) {
+	Convey("Editor running a terminal", t, func() {
+		posted := make(chan func(), 64)
+		editor := NewEditor(GetTestFs())
+		editor.Settings().Shell = "sh"
+		editor.SetPost(func(f func()) { posted <- f })
+
+		// runUntil runs posted work until the condition is true.
+		runUntil := func(done func() bool) bool {
+			timeout := time.After(5 * time.Second)
+			for !done() {
+				select {
+				case f := <-posted:
+					f()
+				case <-timeout:
+					return false
+				}
+			}
+			return true
+		}
+
+		Convey("a command's output is emulated and kept when it exits", func() {
+			buffer, err := editor.OpenTerminal("printf 'hello\\n'; exit 3")
+			So(err, ShouldBeNil)
+			So(buffer.Name(), ShouldEqual, "!printf 'hello\\n'; exit 3")
+
+			So(runUntil(func() bool { return !buffer.Terminal().Running() }), ShouldBeTrue)
+			So(buffer.Terminal().ExitCode(), ShouldEqual, 3)
+			So(string(buffer.data), ShouldEqual, "hello\n[Process exited 3]")
+		})
+
+		Convey("typed keys reach the program", func() {
+			So(editor.ExecuteCommand("terminal cat"), ShouldBeNil)
+			So(editor.Mode(), ShouldEqual, TerminalMode)
+			So(len(editor.Panes()), ShouldEqual, 2)
+			terminal := editor.CurrentPane().Buffer().Terminal()
+
+			for _, r := range "hi" {
+				editor.HandleTerminalKey(KeyEvent{Rune: r})
+			}
+			editor.HandleTerminalKey(KeyEvent{Key: KeyEnter})
+			So(runUntil(func() bool { return terminal.VTerm().Text() == "hi\nhi\n" }), ShouldBeTrue)
+
+			So(editor.DeleteBuffer(editor.CurrentPane().Buffer(), true), ShouldBeNil)
+			So(runUntil(func() bool { return !terminal.Running() }), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,51 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var (
+	resizeSignal   os.Signal
+	continueSignal os.Signal
+)
+
+type ttyState struct{}
+
+var errTTYUnsupported = errors.New("Raw terminal mode is not supported on this platform")
+
+func makeRaw(fd uintptr) (*ttyState, error) {
+	return nil, errTTYUnsupported
+}
+
+func restoreTTY(fd uintptr, state *ttyState) error {
+	return errTTYUnsupported
+}
+
+func ttySize(fd uintptr) (width, height int, err error) {
+	return 0, 0, errTTYUnsupported
+}
+
+func setPTYSize(fd uintptr, width, height int) error {
+	return errTTYUnsupported
+}
+
+func startInPTY(cmd *exec.Cmd, width, height int) (*os.File, error) {
+	return nil, errTTYUnsupported
+}
+
+func detachProcess(cmd *exec.Cmd) {}
+
+func suspendProcess() {}
+
+func execProcess(path string, args, env []string) error {
+	return errors.New("Restarting is not supported on this platform")
+}
+
+func waitReadable(fd, wake uintptr, timeout time.Duration) (ready bool, err error) {
+	return false, errTTYUnsupported
+}
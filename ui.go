@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/dcbishop/jkl/service"
@@ -28,6 +29,7 @@ type FakeUI struct {
 	state     service.State
 	eventChan chan Event
 	quit      chan interface{}
+	redraws   int32
 }
 
 // NewFakeUI constructs a new FakeUI.
@@ -79,6 +81,12 @@ func (ui *FakeUI) Events() <-chan Event {
 
 // Redraw updates the display
 func (ui *FakeUI) Redraw(editor *Editor) {
+	atomic.AddInt32(&ui.redraws, 1)
+}
+
+// Redraws returns the number of times Redraw has been called.
+func (ui *FakeUI) Redraws() int {
+	return int(atomic.LoadInt32(&ui.redraws))
 }
 
 func (ui *FakeUI) loopUntilQuit() {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dcbishop/jkl/service"
+	"github.com/nsf/termbox-go"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -104,3 +105,135 @@ func TestRedraw(t *testing.T) {
 		})
 	})
 }
+
+func TestRedrawCommandLine(t *testing.T) {
+	Convey("TerminalUI in command mode", t, func() {
+		console := NewFakeDriver()
+		console.SetSize(20, 6)
+		tui := NewTerminalUI(&console)
+
+		go tui.Run()
+		service.WaitUntilRunning(&tui, time.Second)
+		defer tui.Stop()
+
+		editor := NewEditor(GetTestFs())
+		editor.OpenFile("file.txt")
+		editor.EnterCommandMode()
+		editor.CommandLine().SetText("ls")
+		tui.Redraw(&editor)
+
+		Convey("places the cursor after the command", func() {
+			So(console.CursorX, ShouldEqual, 3)
+			So(console.CursorY, ShouldEqual, 5)
+			So(string(console.Grid.Cells()[5][:3]), ShouldEqual, ":ls")
+		})
+	})
+}
+
+func TestRedrawCursorWithGutter(t *testing.T) {
+	Convey("TerminalUI with line numbers", t, func() {
+		console := NewFakeDriver()
+		console.SetSize(20, 6)
+		tui := NewTerminalUI(&console)
+
+		go tui.Run()
+		service.WaitUntilRunning(&tui, time.Second)
+		defer tui.Stop()
+
+		editor := NewEditor(GetTestFs())
+		editor.OpenFile("fakefile.txt")
+		editor.Settings().Number = true
+		editor.CurrentPane().Cursor().Move(2, 1)
+		tui.Redraw(&editor)
+
+		Convey("places the cursor after the gutter and border", func() {
+			So(console.CursorX, ShouldEqual, 1+minimumNumberWidth+2)
+			So(console.CursorY, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRedrawDamage(t *testing.T) {
+	Convey("TerminalUI that has drawn a frame", t, func() {
+		console := NewFakeDriver()
+		console.SetSize(40, 10)
+		tui := NewTerminalUI(&console)
+
+		go tui.Run()
+		service.WaitUntilRunning(&tui, time.Second)
+		defer tui.Stop()
+
+		editor := NewEditor(GetTestFs())
+		editor.OpenFile("fakefile.txt")
+		editor.Settings().StatusLine = ""
+		tui.Redraw(&editor)
+		So(console.CellsSet, ShouldEqual, 40*10)
+
+		Convey("redrawing without changes sends nothing", func() {
+			console.CellsSet = 0
+			tui.Redraw(&editor)
+			So(console.CellsSet, ShouldEqual, 0)
+		})
+
+		Convey("a single changed character sends a single cell", func() {
+			console.CellsSet = 0
+			editor.EnterCommandMode()
+			tui.Redraw(&editor)
+			So(console.CellsSet, ShouldEqual, 1)
+			So(console.Grid.Cells()[9][0], ShouldEqual, ':')
+		})
+
+		Convey("Invalidate sends every cell", func() {
+			console.CellsSet = 0
+			tui.Invalidate()
+			tui.Redraw(&editor)
+			So(console.CellsSet, ShouldEqual, 40*10)
+		})
+
+		Convey("changing the theme sends every cell", func() {
+			console.CellsSet = 0
+			tui.Theme[StyleNormal] = ColorPair{termbox.ColorDefault, termbox.ColorDefault}
+			tui.Redraw(&editor)
+			So(console.CellsSet, ShouldEqual, 40*10)
+		})
+
+		Convey("resizing reallocates the grids and repaints", func() {
+			console.SetSize(30, 8)
+			console.CellsSet = 0
+			tui.Redraw(&editor)
+			So(console.CellsSet, ShouldEqual, 30*8)
+			w, h := tui.front.Size()
+			So(w, ShouldEqual, 30)
+			So(h, ShouldEqual, 8)
+		})
+	})
+}
+
+func TestTerminalUISuspend(t *testing.T) {
+	Convey("Running TerminalUI", t, func() {
+		console := NewFakeDriver()
+		console.SetSize(20, 5)
+		tui := NewTerminalUI(&console)
+
+		go tui.Run()
+		service.WaitUntilRunning(&tui, time.Second)
+		defer tui.Stop()
+
+		editor := NewEditor(GetTestFs())
+		tui.Redraw(&editor)
+
+		Convey("Suspend closes the console and Resume opens it again", func() {
+			tui.Suspend()
+			So(console.Closes, ShouldEqual, 1)
+			inits := console.Inits
+			tui.Resume()
+			So(console.Inits, ShouldEqual, inits+1)
+
+			Convey("and everything is drawn again", func() {
+				console.CellsSet = 0
+				tui.Redraw(&editor)
+				So(console.CellsSet, ShouldEqual, 20*5)
+			})
+		})
+	})
+}
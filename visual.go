@@ -0,0 +1,109 @@
+package main
+
+import "strings"
+
+// EnterVisualMode starts selecting text from the cursor.
+func (editor *Editor) EnterVisualMode() {
+	pane := editor.CurrentPane()
+	if pane.Cursor() == nil {
+		return
+	}
+	pane.visual.Move(pane.Cursor().Position())
+	editor.SetMode(VisualMode)
+}
+
+// LeaveVisualMode stops selecting text.
+func (editor *Editor) LeaveVisualMode() {
+	if editor.Mode() == VisualMode {
+		editor.SetMode(NormalMode)
+	}
+}
+
+// YankSelection copies the selected text into the register and leaves Visual mode with the cursor at its start.
+func (editor *Editor) YankSelection() {
+	if editor.Mode() != VisualMode {
+		return
+	}
+	pane := editor.CurrentPane()
+	editor.register = pane.SelectedText()
+	startX, startLine, _, _ := pane.Selection()
+	editor.LeaveVisualMode()
+	pane.Cursor().Move(startX, startLine)
+}
+
+// Register returns the last yanked text.
+func (editor *Editor) Register() string {
+	return editor.register
+}
+
+// SelectedText returns the text of the Pane's selection.
+func (pane *Pane) SelectedText() string {
+	startX, startLine, endX, endLine := pane.Selection()
+	lines, _ := pane.Buffer().GetLines(startLine, endLine)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	last := []rune(lines[len(lines)-1])
+	if endX+1 < len(last) {
+		lines[len(lines)-1] = string(last[:endX+1])
+	}
+	first := []rune(lines[0])
+	if startX > len(first) {
+		startX = len(first)
+	}
+	lines[0] = string(first[startX:])
+	return strings.Join(lines, "\n")
+}
+
+// Selection returns the start and end of the selected text in the Pane, in order.
+// The end character is included in the selection.
+func (pane *Pane) Selection() (startX, startLine, endX, endLine int) {
+	startX, startLine = pane.visual.Position()
+	endX, endLine = pane.Cursor().Position()
+
+	if endLine < startLine || endLine == startLine && endX < startX {
+		startX, startLine, endX, endLine = endX, endLine, startX, startLine
+	}
+	return startX, startLine, endX, endLine
+}
+
+// RenderSelection highlights the Pane's selection where it was drawn.
+func (grid *RuneGrid) RenderSelection(settings *Settings, pane *Pane) {
+	startX, startLine, endX, endLine := pane.Selection()
+	grid.RenderRange(settings, pane, startX, startLine, endX, endLine, StyleVisual)
+}
+
+// RenderRange highlights the text of the Pane from one position to another, both included, where it was drawn.
+func (grid *RuneGrid) RenderRange(settings *Settings, pane *Pane, startX, startLine, endX, endLine int, style Style) {
+	view := pane.View()
+
+	for i, row := range view.Rows {
+		if row.Line < startLine || row.Line > endLine {
+			continue
+		}
+
+		text, _ := pane.Buffer().GetLine(row.Line)
+		first, last := 0, len(ExpandLine(settings, text))
+		if row.Line == startLine {
+			first = DisplayColumn(settings, text, startX)
+		}
+		if row.Line == endLine {
+			last = DisplayColumn(settings, text, endX+1) - 1
+			if last < first {
+				last = first
+			}
+		}
+		if row.Fold != nil {
+			first, last = row.Start, row.Start+view.Width-1
+		}
+
+		y := view.Y + i
+		for column := first; column <= last; column++ {
+			x := view.X + row.Prefix + column - row.Start
+			if column >= row.Start && x < view.X+view.Width && column < row.Start+len(row.Cells)-row.Prefix+1 {
+				grid.SetStyle(x, y, style)
+			}
+		}
+	}
+}
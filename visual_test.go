@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVisualMode(t *testing.T) {
+	Convey("Editor with some text", t, func() {
+		editor := NewEditor(GetTestFs())
+		buffer := editor.AddBuffer(bufferWithText("alpha beta\n\tgamma\ndelta"))
+		editor.SwitchToBuffer(buffer)
+		editor.Settings().Borders = false
+		editor.Settings().StatusLine = ""
+		pane := editor.CurrentPane()
+		pane.Cursor().Move(6, 1)
+
+		Convey("v starts selecting from the cursor", func() {
+			editor.HandleNormalKey('v')
+			So(editor.Mode(), ShouldEqual, VisualMode)
+			pane.Cursor().Move(2, 2)
+
+			startX, startLine, endX, endLine := pane.Selection()
+			So([]int{startX, startLine, endX, endLine}, ShouldResemble, []int{6, 1, 2, 2})
+
+			Convey("the selection is highlighted", func() {
+				grid := NewRuneGrid(20, 4)
+				grid.RenderEditor(&editor)
+				styles := grid.Styles()
+
+				So(styles[0][5], ShouldEqual, StyleNormal)
+				So(styles[0][6], ShouldEqual, StyleVisual)
+				So(styles[0][9], ShouldEqual, StyleVisual)
+				So(styles[1][0], ShouldEqual, StyleVisual)
+				So(styles[1][5], ShouldEqual, StyleVisual)
+				So(styles[1][6], ShouldEqual, StyleNormal)
+				So(styles[2][0], ShouldEqual, StyleNormal)
+				So(string(grid.Cells()[3][:12]), ShouldEqual, "-- VISUAL --")
+			})
+
+			Convey("the selection is in order when the cursor is before the start", func() {
+				pane.Cursor().Move(1, 1)
+				startX, startLine, endX, endLine := pane.Selection()
+				So([]int{startX, startLine, endX, endLine}, ShouldResemble, []int{1, 1, 6, 1})
+
+				Convey("and y leaves the cursor at the start", func() {
+					editor.HandleNormalKey('y')
+					So(editor.Register(), ShouldEqual, "lpha b")
+					x, line := pane.Cursor().Position()
+					So([]int{x, line}, ShouldResemble, []int{1, 1})
+				})
+			})
+
+			Convey("y yanks the selection and leaves the cursor at its start", func() {
+				editor.HandleNormalKey('y')
+				So(editor.Mode(), ShouldEqual, NormalMode)
+				So(editor.Register(), ShouldEqual, "beta\n\tga")
+				x, line := pane.Cursor().Position()
+				So([]int{x, line}, ShouldResemble, []int{6, 1})
+			})
+
+			Convey("v again stops selecting", func() {
+				editor.HandleNormalKey('v')
+				So(editor.Mode(), ShouldEqual, NormalMode)
+			})
+		})
+	})
+}
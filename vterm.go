@@ -0,0 +1,549 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultScrollback is how many lines scrolled off the top of a VTerm are kept.
+const DefaultScrollback = 10000
+
+// VTerm emulates the parts of a VT100/xterm terminal that shells and command line programs use.
+// Output written to it is drawn on a grid of cells, lines scrolled off the top of the main screen are kept as scrollback.
+// Colours and text attributes are parsed but not kept.
+type VTerm struct {
+	width  int
+	height int
+	cells  [][]rune
+
+	main      [][]rune
+	alternate bool
+
+	cursorX       int
+	cursorY       int
+	savedX        int
+	savedY        int
+	wrapNext      bool
+	cursorVisible bool
+	top           int
+	bottom        int
+
+	appCursor      bool
+	bracketedPaste bool
+
+	scrollback    []string
+	MaxScrollback int
+	Title         string
+
+	// Respond is called with replies to queries such as cursor position reports.
+	Respond func(data []byte)
+
+	pending []byte
+}
+
+// NewVTerm constructs a blank VTerm of the given size.
+func NewVTerm(width, height int) VTerm {
+	vt := VTerm{MaxScrollback: DefaultScrollback}
+	vt.Resize(width, height)
+	vt.cursorVisible = true
+	return vt
+}
+
+// Size returns the number of columns and rows.
+func (vt *VTerm) Size() (width, height int) {
+	return vt.width, vt.height
+}
+
+// Cells returns the rows of the screen.
+func (vt *VTerm) Cells() [][]rune {
+	return vt.cells
+}
+
+// Cursor returns where the cursor is on the screen and whether the program wants it shown.
+func (vt *VTerm) Cursor() (x, y int, visible bool) {
+	x = vt.cursorX
+	if x >= vt.width {
+		x = vt.width - 1
+	}
+	return x, vt.cursorY, vt.cursorVisible
+}
+
+// Scrollback returns the lines that have scrolled off the top, oldest first.
+func (vt *VTerm) Scrollback() []string {
+	return vt.scrollback
+}
+
+// Text returns the scrollback followed by the screen, without trailing blank lines after the cursor.
+func (vt *VTerm) Text() string {
+	lines := append([]string{}, vt.scrollback...)
+	for _, row := range vt.cells {
+		lines = append(lines, strings.TrimRight(string(row), " "))
+	}
+
+	last := len(vt.scrollback) + vt.cursorY + 1
+	for len(lines) > last && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Resize changes the size of the screen, keeping what fits. Lines pushed off the top when it gets shorter go to the scrollback.
+func (vt *VTerm) Resize(width, height int) {
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	if vt.cursorY >= height {
+		if !vt.alternate {
+			vt.pushScrollback(vt.cells[:vt.cursorY-height+1])
+		}
+		vt.cells = vt.cells[vt.cursorY-height+1:]
+		vt.cursorY = height - 1
+	}
+
+	vt.cells = resizeCells(vt.cells, width, height)
+	if vt.main != nil {
+		vt.main = resizeCells(vt.main, width, height)
+	}
+
+	vt.width, vt.height = width, height
+	vt.top, vt.bottom = 0, height-1
+	vt.cursorX = clamp(vt.cursorX, 0, width-1)
+	vt.savedX = clamp(vt.savedX, 0, width-1)
+	vt.savedY = clamp(vt.savedY, 0, height-1)
+	vt.wrapNext = false
+}
+
+func resizeCells(cells [][]rune, width, height int) [][]rune {
+	resized := make([][]rune, height)
+	for y := range resized {
+		resized[y] = blankRow(width)
+		if y < len(cells) {
+			copy(resized[y], cells[y])
+		}
+	}
+	return resized
+}
+
+func blankRow(width int) []rune {
+	row := make([]rune, width)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// Write feeds program output to the terminal. Sequences split between writes are kept until the rest arrives.
+func (vt *VTerm) Write(data []byte) (int, error) {
+	input := append(vt.pending, data...)
+	vt.pending = nil
+
+	for pos := 0; pos < len(input); {
+		n := vt.parse(input[pos:])
+		if n == 0 {
+			vt.pending = append([]byte{}, input[pos:]...)
+			break
+		}
+		pos += n
+	}
+	return len(data), nil
+}
+
+// parse handles the first character or sequence in data, returning 0 if it isn't complete.
+func (vt *VTerm) parse(data []byte) int {
+	c := data[0]
+	switch {
+	case c == 0x1b:
+		return vt.parseEscape(data)
+	case c < 0x20 || c == 0x7f:
+		vt.control(c)
+		return 1
+	}
+
+	if !utf8.FullRune(data) {
+		return 0
+	}
+	r, n := utf8.DecodeRune(data)
+	vt.print(r)
+	return n
+}
+
+func (vt *VTerm) control(c byte) {
+	switch c {
+	case '\r':
+		vt.moveTo(0, vt.cursorY)
+	case '\n', '\v', '\f':
+		vt.lineFeed()
+	case '\b':
+		vt.moveTo(vt.cursorX-1, vt.cursorY)
+	case '\t':
+		vt.moveTo((vt.cursorX/8+1)*8, vt.cursorY)
+	}
+}
+
+func (vt *VTerm) print(r rune) {
+	if vt.wrapNext {
+		vt.cursorX = 0
+		vt.lineFeed()
+	}
+
+	vt.cells[vt.cursorY][vt.cursorX] = r
+	if vt.cursorX == vt.width-1 {
+		vt.wrapNext = true
+	} else {
+		vt.cursorX++
+	}
+}
+
+// moveTo moves the cursor, keeping it on the screen.
+func (vt *VTerm) moveTo(x, y int) {
+	vt.cursorX = clamp(x, 0, vt.width-1)
+	vt.cursorY = clamp(y, 0, vt.height-1)
+	vt.wrapNext = false
+}
+
+func (vt *VTerm) lineFeed() {
+	vt.wrapNext = false
+	if vt.cursorY == vt.bottom {
+		vt.scrollUp(1)
+	} else if vt.cursorY < vt.height-1 {
+		vt.cursorY++
+	}
+}
+
+func (vt *VTerm) reverseIndex() {
+	vt.wrapNext = false
+	if vt.cursorY == vt.top {
+		vt.scrollDown(1)
+	} else if vt.cursorY > 0 {
+		vt.cursorY--
+	}
+}
+
+// scrollUp moves the lines of the scroll region up, lines leaving the top of the main screen go to the scrollback.
+func (vt *VTerm) scrollUp(n int) {
+	vt.deleteLines(vt.top, n, vt.top == 0 && !vt.alternate)
+}
+
+func (vt *VTerm) scrollDown(n int) {
+	vt.insertLines(vt.top, n)
+}
+
+// deleteLines removes n lines at y, the lines below move up and blank lines are added at the bottom of the scroll region.
+func (vt *VTerm) deleteLines(y, n int, keep bool) {
+	if y < vt.top || y > vt.bottom {
+		return
+	}
+	n = clamp(n, 0, vt.bottom-y+1)
+	if keep {
+		vt.pushScrollback(vt.cells[y : y+n])
+	}
+
+	region := vt.cells[y : vt.bottom+1]
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = blankRow(vt.width)
+	}
+}
+
+// insertLines adds n blank lines at y, the lines below move down and fall off the bottom of the scroll region.
+func (vt *VTerm) insertLines(y, n int) {
+	if y < vt.top || y > vt.bottom {
+		return
+	}
+	n = clamp(n, 0, vt.bottom-y+1)
+
+	region := vt.cells[y : vt.bottom+1]
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = blankRow(vt.width)
+	}
+}
+
+func (vt *VTerm) pushScrollback(rows [][]rune) {
+	for _, row := range rows {
+		vt.scrollback = append(vt.scrollback, strings.TrimRight(string(row), " "))
+	}
+	if extra := len(vt.scrollback) - vt.MaxScrollback; extra > 0 {
+		vt.scrollback = append([]string{}, vt.scrollback[extra:]...)
+	}
+}
+
+// erase blanks the cells of a row from x1 up to but not including x2.
+func (vt *VTerm) erase(y, x1, x2 int) {
+	row := vt.cells[y]
+	for x := clamp(x1, 0, vt.width); x < clamp(x2, 0, vt.width); x++ {
+		row[x] = ' '
+	}
+}
+
+// parseEscape handles a sequence starting with ESC.
+func (vt *VTerm) parseEscape(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+
+	switch data[1] {
+	case '[':
+		return vt.parseCSI(data)
+	case ']':
+		return vt.parseString(data, true)
+	case 'P', '_', '^', 'X':
+		return vt.parseString(data, false)
+	case '(', ')', '*', '+', '#', '%':
+		if len(data) < 3 {
+			return 0
+		}
+		return 3
+	case '7':
+		vt.savedX, vt.savedY = vt.cursorX, vt.cursorY
+	case '8':
+		vt.moveTo(vt.savedX, vt.savedY)
+	case 'D':
+		vt.lineFeed()
+	case 'E':
+		vt.cursorX = 0
+		vt.lineFeed()
+	case 'M':
+		vt.reverseIndex()
+	case 'c':
+		vt.reset()
+	}
+	return 2
+}
+
+// parseString skips an OSC, DCS or similar string ended by BEL or ESC \, OSC 0 and 2 set the Title.
+func (vt *VTerm) parseString(data []byte, osc bool) int {
+	for i := 2; i < len(data); i++ {
+		end := 0
+		switch {
+		case data[i] == 0x07:
+			end = i + 1
+		case data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\':
+			end = i + 2
+		case data[i] == 0x1b && i+1 == len(data):
+			return 0
+		default:
+			continue
+		}
+
+		if osc {
+			text := string(data[2:i])
+			if strings.HasPrefix(text, "0;") || strings.HasPrefix(text, "2;") {
+				vt.Title = text[2:]
+			}
+		}
+		return end
+	}
+	return 0
+}
+
+// parseCSI handles a control sequence, ESC [ parameters final.
+func (vt *VTerm) parseCSI(data []byte) int {
+	end := 2
+	for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+		end++
+	}
+	if end >= len(data) {
+		return 0
+	}
+
+	params := string(data[2:end])
+	private := ""
+	if params != "" && strings.ContainsRune("?<=>", rune(params[0])) {
+		private, params = params[:1], params[1:]
+	}
+	params = strings.TrimRight(params, " !\"#$%&'()*+,-./")
+
+	fields := strings.Split(params, ";")
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		values[i], _ = strconv.Atoi(strings.SplitN(field, ":", 2)[0])
+	}
+	arg := func(i, def int) int {
+		if i >= len(values) || values[i] == 0 {
+			return def
+		}
+		return values[i]
+	}
+
+	if private == "?" {
+		vt.setModes(data[end], values)
+		return end + 1
+	}
+	if private != "" {
+		return end + 1
+	}
+
+	n := arg(0, 1)
+	switch data[end] {
+	case 'A':
+		vt.moveTo(vt.cursorX, vt.cursorY-n)
+	case 'B', 'e':
+		vt.moveTo(vt.cursorX, vt.cursorY+n)
+	case 'C', 'a':
+		vt.moveTo(vt.cursorX+n, vt.cursorY)
+	case 'D':
+		vt.moveTo(vt.cursorX-n, vt.cursorY)
+	case 'E':
+		vt.moveTo(0, vt.cursorY+n)
+	case 'F':
+		vt.moveTo(0, vt.cursorY-n)
+	case 'G', '`':
+		vt.moveTo(n-1, vt.cursorY)
+	case 'd':
+		vt.moveTo(vt.cursorX, n-1)
+	case 'H', 'f':
+		vt.moveTo(arg(1, 1)-1, n-1)
+	case 'J':
+		vt.eraseDisplay(arg(0, 0))
+	case 'K':
+		vt.eraseLine(arg(0, 0))
+	case 'L':
+		vt.insertLines(vt.cursorY, n)
+		vt.cursorX = 0
+	case 'M':
+		vt.deleteLines(vt.cursorY, n, false)
+		vt.cursorX = 0
+	case '@':
+		row := vt.cells[vt.cursorY]
+		n = clamp(n, 0, vt.width-vt.cursorX)
+		copy(row[vt.cursorX+n:], row[vt.cursorX:])
+		vt.erase(vt.cursorY, vt.cursorX, vt.cursorX+n)
+	case 'P':
+		row := vt.cells[vt.cursorY]
+		n = clamp(n, 0, vt.width-vt.cursorX)
+		copy(row[vt.cursorX:], row[vt.cursorX+n:])
+		vt.erase(vt.cursorY, vt.width-n, vt.width)
+	case 'X':
+		vt.erase(vt.cursorY, vt.cursorX, vt.cursorX+n)
+	case 'S':
+		vt.scrollUp(n)
+	case 'T':
+		vt.scrollDown(n)
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, vt.height)-1
+		if top < bottom && bottom < vt.height {
+			vt.top, vt.bottom = top, bottom
+			vt.moveTo(0, 0)
+		}
+	case 's':
+		vt.savedX, vt.savedY = vt.cursorX, vt.cursorY
+	case 'u':
+		vt.moveTo(vt.savedX, vt.savedY)
+	case 'n':
+		switch arg(0, 0) {
+		case 5:
+			vt.respond("\x1b[0n")
+		case 6:
+			vt.respond(fmt.Sprintf("\x1b[%d;%dR", vt.cursorY+1, vt.cursorX+1))
+		}
+	case 'c':
+		vt.respond("\x1b[?1;2c")
+	}
+	return end + 1
+}
+
+// setModes handles the private modes set by CSI ? Pm h and reset by CSI ? Pm l.
+func (vt *VTerm) setModes(final byte, modes []int) {
+	if final != 'h' && final != 'l' {
+		return
+	}
+	on := final == 'h'
+
+	for _, mode := range modes {
+		switch mode {
+		case 1:
+			vt.appCursor = on
+		case 25:
+			vt.cursorVisible = on
+		case 2004:
+			vt.bracketedPaste = on
+		case 47, 1047, 1049:
+			vt.setAlternateScreen(on, mode == 1049)
+		}
+	}
+}
+
+// setAlternateScreen switches between the main screen and a blank alternate screen that has no scrollback.
+func (vt *VTerm) setAlternateScreen(on, saveCursor bool) {
+	if on == vt.alternate {
+		return
+	}
+	vt.alternate = on
+
+	if on {
+		if saveCursor {
+			vt.savedX, vt.savedY = vt.cursorX, vt.cursorY
+		}
+		vt.main = vt.cells
+		vt.cells = resizeCells(nil, vt.width, vt.height)
+		return
+	}
+
+	vt.cells = vt.main
+	vt.main = nil
+	if saveCursor {
+		vt.moveTo(vt.savedX, vt.savedY)
+	}
+}
+
+func (vt *VTerm) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		vt.eraseLine(0)
+		for y := vt.cursorY + 1; y < vt.height; y++ {
+			vt.erase(y, 0, vt.width)
+		}
+	case 1:
+		vt.eraseLine(1)
+		for y := 0; y < vt.cursorY; y++ {
+			vt.erase(y, 0, vt.width)
+		}
+	case 2:
+		for y := 0; y < vt.height; y++ {
+			vt.erase(y, 0, vt.width)
+		}
+	case 3:
+		vt.scrollback = nil
+	}
+}
+
+func (vt *VTerm) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		vt.erase(vt.cursorY, vt.cursorX, vt.width)
+	case 1:
+		vt.erase(vt.cursorY, 0, vt.cursorX+1)
+	case 2:
+		vt.erase(vt.cursorY, 0, vt.width)
+	}
+}
+
+// reset clears the screen and modes but keeps the scrollback.
+func (vt *VTerm) reset() {
+	scrollback, max, respond := vt.scrollback, vt.MaxScrollback, vt.Respond
+	*vt = NewVTerm(vt.width, vt.height)
+	vt.scrollback, vt.MaxScrollback, vt.Respond = scrollback, max, respond
+}
+
+func (vt *VTerm) respond(reply string) {
+	if vt.Respond != nil {
+		vt.Respond([]byte(reply))
+	}
+}
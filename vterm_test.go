@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// screen returns the rows of the VTerm with trailing spaces removed.
+func screen(vt *VTerm) []string {
+	rows := []string{}
+	for _, row := range vt.Cells() {
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+	return rows
+}
+
+func TestVTerm(t *testing.T) {
+	Convey("VTerm", t, func() {
+		vt := NewVTerm(5, 3)
+
+		Convey("prints text and moves to the next line", func() {
+			vt.Write([]byte("ab\r\ncd"))
+			So(screen(&vt), ShouldResemble, []string{"ab", "cd", ""})
+			x, y, visible := vt.Cursor()
+			So([]int{x, y}, ShouldResemble, []int{2, 1})
+			So(visible, ShouldBeTrue)
+		})
+
+		Convey("wraps at the right edge", func() {
+			vt.Write([]byte("abcdefg"))
+			So(screen(&vt), ShouldResemble, []string{"abcde", "fg", ""})
+		})
+
+		Convey("scrolls lines off the top into the scrollback", func() {
+			vt.Write([]byte("1\r\n2\r\n3\r\n4\r\n5"))
+			So(screen(&vt), ShouldResemble, []string{"3", "4", "5"})
+			So(vt.Scrollback(), ShouldResemble, []string{"1", "2"})
+			So(vt.Text(), ShouldEqual, "1\n2\n3\n4\n5")
+
+			Convey("up to the maximum", func() {
+				vt.MaxScrollback = 2
+				vt.Write([]byte("\r\n6"))
+				So(vt.Scrollback(), ShouldResemble, []string{"2", "3"})
+			})
+
+			Convey("ED 3 clears the scrollback", func() {
+				vt.Write([]byte("\x1b[3J"))
+				So(vt.Scrollback(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("moves the cursor and erases", func() {
+			vt.Write([]byte("aaaaa\r\nbbbbb\r\nccccc"))
+			vt.Write([]byte("\x1b[2;3H\x1b[K"))
+			So(screen(&vt), ShouldResemble, []string{"aaaaa", "bb", "ccccc"})
+			vt.Write([]byte("\x1b[1J"))
+			So(screen(&vt), ShouldResemble, []string{"", "", "ccccc"})
+			vt.Write([]byte("\x1b[2J"))
+			So(screen(&vt), ShouldResemble, []string{"", "", ""})
+		})
+
+		Convey("inserts and deletes characters", func() {
+			vt.Write([]byte("abcde\x1b[1;2H\x1b[2P"))
+			So(screen(&vt)[0], ShouldEqual, "ade")
+			vt.Write([]byte("\x1b[@"))
+			So(screen(&vt)[0], ShouldEqual, "a de")
+		})
+
+		Convey("inserts and deletes lines inside the scroll region", func() {
+			vt.Write([]byte("1\r\n2\r\n3\x1b[1;2r\x1b[L"))
+			So(screen(&vt), ShouldResemble, []string{"", "1", "3"})
+			vt.Write([]byte("\x1b[M"))
+			So(screen(&vt), ShouldResemble, []string{"1", "", "3"})
+		})
+
+		Convey("the alternate screen leaves the main screen alone", func() {
+			vt.Write([]byte("main\x1b[?1049h"))
+			So(screen(&vt), ShouldResemble, []string{"", "", ""})
+			vt.Write([]byte("alt\r\n\n\n\n"))
+			So(vt.Scrollback(), ShouldBeEmpty)
+			vt.Write([]byte("\x1b[?1049l"))
+			So(screen(&vt), ShouldResemble, []string{"main", "", ""})
+			x, _, _ := vt.Cursor()
+			So(x, ShouldEqual, 4)
+		})
+
+		Convey("answers cursor position reports", func() {
+			replies := []string{}
+			vt.Respond = func(data []byte) { replies = append(replies, string(data)) }
+			vt.Write([]byte("ab\x1b[6n"))
+			So(replies, ShouldResemble, []string{"\x1b[1;3R"})
+		})
+
+		Convey("keeps sequences split between writes", func() {
+			vt.Write([]byte("\x1b["))
+			vt.Write([]byte("2;2Hx\xe2\x82"))
+			vt.Write([]byte("\xac"))
+			So(screen(&vt), ShouldResemble, []string{"", " x€", ""})
+		})
+
+		Convey("skips OSC strings and keeps the title", func() {
+			vt.Write([]byte("\x1b]0;title\x07a\x1b]8;;\x1b\\b"))
+			So(vt.Title, ShouldEqual, "title")
+			So(screen(&vt)[0], ShouldEqual, "ab")
+		})
+
+		Convey("hides the cursor and tracks modes", func() {
+			vt.Write([]byte("\x1b[?25l\x1b[?1h\x1b[?2004h"))
+			_, _, visible := vt.Cursor()
+			So(visible, ShouldBeFalse)
+			So(vt.appCursor, ShouldBeTrue)
+			So(vt.bracketedPaste, ShouldBeTrue)
+		})
+
+		Convey("resizing keeps the cursor line, pushing lines into the scrollback", func() {
+			vt.Write([]byte("1\r\n2\r\n3"))
+			vt.Resize(4, 2)
+			So(screen(&vt), ShouldResemble, []string{"2", "3"})
+			So(vt.Scrollback(), ShouldResemble, []string{"1"})
+			w, h := vt.Size()
+			So([]int{w, h}, ShouldResemble, []int{4, 2})
+		})
+	})
+}